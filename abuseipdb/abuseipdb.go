@@ -0,0 +1,440 @@
+// Package abuseipdb consults AbuseIPDB about the IPs botrate analyzes,
+// and reports the offenders it blocks back.
+//
+// A Client is an analyzer.Signal scoring an IP by its AbuseIPDB
+// confidence score: a score at the threshold blocks the IP on its own,
+// lower ones add up with the other signals. Lookups never block the
+// analyzer: the first requests of an IP are scored without AbuseIPDB
+// while its score is fetched in the background, and scores are cached,
+// as the free plan allows 1000 checks a day.
+//
+// See botrate.WithAbuseIPDB.
+package abuseipdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultBaseURL   = "https://api.abuseipdb.com/api/v2"
+	DefaultThreshold = 75
+	DefaultMaxAge    = 30
+	DefaultCacheTTL  = 24 * time.Hour
+	DefaultCacheSize = 100000
+	DefaultTimeout   = 5 * time.Second
+	DefaultQueueCap  = 1000
+
+	// DefaultCategories are the AbuseIPDB categories of reports, Bad Web
+	// Bot.
+	DefaultCategories = []int{19}
+
+	// DefaultCooldown is how long an IP is not reported again, AbuseIPDB
+	// rejects reports of the same IP within 15 minutes.
+	DefaultCooldown = 15 * time.Minute
+)
+
+// Option is a functional option for configuring Client.
+type Option func(*Client)
+
+// WithThreshold sets the confidence score, from 0 to 100, that blocks an
+// IP on its own. Lower scores count proportionally.
+func WithThreshold(score int) Option {
+	return func(c *Client) {
+		c.threshold = score
+	}
+}
+
+// WithMaxAge sets how many days of reports AbuseIPDB considers.
+func WithMaxAge(days int) Option {
+	return func(c *Client) {
+		c.maxAge = days
+	}
+}
+
+// WithCache sets how long scores are cached and the most IPs cached.
+func WithCache(ttl time.Duration, size int) Option {
+	return func(c *Client) {
+		c.cacheTTL = ttl
+		c.cacheSize = size
+	}
+}
+
+// WithReport reports the blocks made for reasons, see
+// analyzer.Event.Reason, e.g. "honeypot". Nothing is reported by
+// default.
+func WithReport(reasons ...string) Option {
+	return func(c *Client) {
+		c.report = make(map[string]bool, len(reasons))
+		for _, r := range reasons {
+			c.report[r] = true
+		}
+	}
+}
+
+// WithCategories sets the AbuseIPDB categories of reports.
+func WithCategories(categories ...int) Option {
+	return func(c *Client) {
+		c.categories = categories
+	}
+}
+
+// WithBaseURL sets the API base URL, e.g. for a proxy or tests.
+func WithBaseURL(u string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithClient sets the HTTP client calling the API.
+func WithClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.client = hc
+	}
+}
+
+// WithTimeout sets the timeout for an API call.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = d
+	}
+}
+
+// WithQueueCap sets how many lookups and reports can wait. They are
+// dropped when the queue is full, and a dropped lookup is retried on a
+// later request.
+func WithQueueCap(cap int) Option {
+	return func(c *Client) {
+		c.queueCap = cap
+	}
+}
+
+// WithLogger sets the logger for failed API calls.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// entry is a cached score.
+type entry struct {
+	score   int
+	expires time.Time
+}
+
+// task is a lookup, or a report of a block.
+type task struct {
+	ip     string
+	report *analyzer.Event
+}
+
+// Client looks IPs up on AbuseIPDB and reports offenders. It implements
+// analyzer.Signal.
+type Client struct {
+	apiKey     string
+	threshold  int
+	maxAge     int
+	cacheTTL   time.Duration
+	cacheSize  int
+	report     map[string]bool
+	categories []int
+	baseURL    string
+	client     *http.Client
+	timeout    time.Duration
+	queueCap   int
+	logger     *slog.Logger
+
+	mu       sync.Mutex
+	cache    map[string]entry
+	pending  map[string]bool
+	reported map[string]time.Time
+	paused   time.Time // rate limited until
+
+	queue chan task
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// New returns a client calling the API with apiKey.
+func New(apiKey string, opts ...Option) *Client {
+	c := &Client{
+		apiKey:     apiKey,
+		threshold:  DefaultThreshold,
+		maxAge:     DefaultMaxAge,
+		cacheTTL:   DefaultCacheTTL,
+		cacheSize:  DefaultCacheSize,
+		categories: DefaultCategories,
+		baseURL:    DefaultBaseURL,
+		client:     http.DefaultClient,
+		timeout:    DefaultTimeout,
+		queueCap:   DefaultQueueCap,
+		cache:      make(map[string]entry),
+		pending:    make(map[string]bool),
+		reported:   make(map[string]time.Time),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.logger == nil {
+		c.logger = logging.Discard()
+	}
+
+	c.queue = make(chan task, c.queueCap)
+	go c.run()
+	return c
+}
+
+func (c *Client) Name() string {
+	return "abuseipdb"
+}
+
+// Observe returns the cached score of o.IP, scaled so the threshold
+// scores 1. Without one, it queues a lookup and returns 0.
+func (c *Client) Observe(o analyzer.Observation) float64 {
+	if c.threshold <= 0 || !public(o.IP) {
+		return 0
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	e, cached := c.cache[o.IP]
+	if cached && now.Before(e.expires) {
+		c.mu.Unlock()
+		return float64(e.score) / float64(c.threshold)
+	}
+	if c.pending[o.IP] || now.Before(c.paused) {
+		c.mu.Unlock()
+		return 0
+	}
+	c.pending[o.IP] = true
+	c.mu.Unlock()
+
+	select {
+	case c.queue <- task{ip: o.IP}:
+	default:
+		c.mu.Lock()
+		delete(c.pending, o.IP)
+		c.mu.Unlock()
+	}
+	return 0
+}
+
+// Forget drops the cached score of ip, e.g. after a manual unblock.
+func (c *Client) Forget(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, ip)
+}
+
+// Reset keeps the cache, scores outlive windows.
+func (c *Client) Reset() {}
+
+// Handle reports the blocks made for the reasons of WithReport, see
+// botrate.WithEventFunc. An IP is not reported twice within
+// DefaultCooldown.
+func (c *Client) Handle(e analyzer.Event) {
+	if e.Type != analyzer.EventBlock || !c.report[e.Reason()] || !public(e.IP) {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	if last, ok := c.reported[e.IP]; ok && now.Sub(last) < DefaultCooldown {
+		c.mu.Unlock()
+		return
+	}
+	c.reported[e.IP] = now
+	c.mu.Unlock()
+
+	select {
+	case c.queue <- task{ip: e.IP, report: &e}:
+	default:
+	}
+}
+
+// Close stops the background lookups and reports. Those still queued
+// are dropped.
+func (c *Client) Close() error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+	return nil
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case t := <-c.queue:
+			if t.report != nil {
+				c.send(ctx, *t.report)
+				continue
+			}
+			c.lookup(ctx, t.ip)
+		}
+	}
+}
+
+func (c *Client) lookup(ctx context.Context, ip string) {
+	score, err := c.Check(ctx, ip)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, ip)
+	if err != nil {
+		c.logger.Warn("botrate: abuseipdb check failed", "ip", ip, "error", err)
+		return
+	}
+
+	now := time.Now()
+	if len(c.cache) >= c.cacheSize {
+		c.evict(now)
+	}
+	c.cache[ip] = entry{score: score, expires: now.Add(c.cacheTTL)}
+}
+
+// evict makes room in the cache, dropping the expired scores, or an
+// arbitrary one if none expired. It is called under mu.
+func (c *Client) evict(now time.Time) {
+	for ip, e := range c.cache {
+		if !now.Before(e.expires) {
+			delete(c.cache, ip)
+		}
+	}
+	for ip := range c.cache {
+		if len(c.cache) < c.cacheSize {
+			return
+		}
+		delete(c.cache, ip)
+	}
+}
+
+// Check returns the AbuseIPDB confidence score of ip, from 0 to 100.
+func (c *Client) Check(ctx context.Context, ip string) (int, error) {
+	q := url.Values{}
+	q.Set("ipAddress", ip)
+	q.Set("maxAgeInDays", strconv.Itoa(c.maxAge))
+
+	var res struct {
+		Data struct {
+			AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/check?"+q.Encode(), nil, &res); err != nil {
+		return 0, err
+	}
+	return res.Data.AbuseConfidenceScore, nil
+}
+
+// Report reports ip to AbuseIPDB under the categories of WithCategories.
+func (c *Client) Report(ctx context.Context, ip, comment string) error {
+	categories := make([]string, len(c.categories))
+	for i, cat := range c.categories {
+		categories[i] = strconv.Itoa(cat)
+	}
+
+	form := url.Values{}
+	form.Set("ip", ip)
+	form.Set("categories", strings.Join(categories, ","))
+	form.Set("comment", comment)
+	return c.do(ctx, http.MethodPost, "/report", form, nil)
+}
+
+func (c *Client) send(ctx context.Context, e analyzer.Event) {
+	comment := "Blocked by botrate: " + e.Reason()
+	switch {
+	case e.Honeypot != "":
+		comment += " " + e.Honeypot
+	case e.Pages > 0:
+		comment += fmt.Sprintf(", %d distinct pages", e.Pages)
+	}
+	if err := c.Report(ctx, e.IP, comment); err != nil {
+		c.logger.Warn("botrate: abuseipdb report failed", "ip", e.IP, "error", err)
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, form url.Values, result any) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Key", c.apiKey)
+	req.Header.Set("Accept", "application/json")
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		c.pause(resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("abuseipdb: %s", resp.Status)
+	}
+	if result == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// pause stops queuing lookups for the Retry-After of a rate limited
+// response, a minute if missing.
+func (c *Client) pause(retryAfter string) {
+	d := time.Minute
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs > 0 {
+		d = time.Duration(secs) * time.Second
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = time.Now().Add(d)
+}
+
+// public reports whether ip is worth asking AbuseIPDB about.
+func public(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
@@ -0,0 +1,186 @@
+package abuseipdb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+var _ analyzer.Signal = (*Client)(nil)
+
+// fakeAPI serves the check and report endpoints.
+type fakeAPI struct {
+	scores  map[string]int
+	checks  atomic.Int32
+	status  int
+	mu      sync.Mutex
+	reports []map[string]string
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Key") != "key" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if f.status != 0 {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, http.StatusText(f.status), f.status)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/check":
+		f.checks.Add(1)
+		var res struct {
+			Data struct {
+				AbuseConfidenceScore int `json:"abuseConfidenceScore"`
+			} `json:"data"`
+		}
+		res.Data.AbuseConfidenceScore = f.scores[r.URL.Query().Get("ipAddress")]
+		json.NewEncoder(w).Encode(res)
+	case "/report":
+		r.ParseForm()
+		f.mu.Lock()
+		f.reports = append(f.reports, map[string]string{
+			"ip":         r.PostForm.Get("ip"),
+			"categories": r.PostForm.Get("categories"),
+			"comment":    r.PostForm.Get("comment"),
+		})
+		f.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestClient(t *testing.T, api *fakeAPI, opts ...Option) *Client {
+	t.Helper()
+
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+
+	c := New("key", append([]Option{WithBaseURL(srv.URL)}, opts...)...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClient_Observe(t *testing.T) {
+	api := &fakeAPI{scores: map[string]int{"203.0.113.1": 100, "203.0.113.2": 25}}
+	c := newTestClient(t, api, WithThreshold(50))
+
+	bad := analyzer.Observation{IP: "203.0.113.1"}
+	if s := c.Observe(bad); s != 0 {
+		t.Errorf("the first observation should not wait for the lookup, got %v", s)
+	}
+	waitFor(t, func() bool { return c.Observe(bad) == 2 }, "score at twice the threshold should score 2")
+
+	low := analyzer.Observation{IP: "203.0.113.2"}
+	c.Observe(low)
+	waitFor(t, func() bool { return c.Observe(low) == 0.5 }, "score at half the threshold should score 0.5")
+
+	// Cached scores are not looked up again
+	for i := 0; i < 10; i++ {
+		c.Observe(bad)
+	}
+	if n := api.checks.Load(); n != 2 {
+		t.Errorf("expected 2 checks, got %d", n)
+	}
+
+	c.Forget("203.0.113.1")
+	if s := c.Observe(bad); s != 0 {
+		t.Errorf("forgotten IP should be looked up again, got %v", s)
+	}
+}
+
+func TestClient_Observe_Private(t *testing.T) {
+	api := &fakeAPI{}
+	c := newTestClient(t, api)
+
+	for _, ip := range []string{"192.168.1.1", "10.0.0.1", "127.0.0.1", "::1", "invalid"} {
+		c.Observe(analyzer.Observation{IP: ip})
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := api.checks.Load(); n != 0 {
+		t.Errorf("private IPs should not be looked up, got %d checks", n)
+	}
+}
+
+func TestClient_Observe_RateLimited(t *testing.T) {
+	api := &fakeAPI{status: http.StatusTooManyRequests}
+	c := newTestClient(t, api)
+
+	c.Observe(analyzer.Observation{IP: "203.0.113.1"})
+	waitFor(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return !c.paused.IsZero() && len(c.pending) == 0
+	}, "rate limited lookup should pause lookups")
+
+	c.Observe(analyzer.Observation{IP: "203.0.113.2"})
+	c.mu.Lock()
+	pending := len(c.pending)
+	c.mu.Unlock()
+	if pending != 0 {
+		t.Error("lookups should not be queued while paused")
+	}
+}
+
+func TestClient_Cache(t *testing.T) {
+	api := &fakeAPI{scores: map[string]int{}}
+	c := newTestClient(t, api, WithCache(time.Hour, 2))
+
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2", "203.0.113.3"} {
+		c.lookup(context.Background(), ip)
+	}
+	if n := len(c.cache); n != 2 {
+		t.Errorf("expected the cache bounded to 2, got %d", n)
+	}
+	if _, ok := c.cache["203.0.113.3"]; !ok {
+		t.Error("the latest score should be cached")
+	}
+}
+
+func TestClient_Handle(t *testing.T) {
+	api := &fakeAPI{}
+	c := newTestClient(t, api, WithReport("honeypot"), WithCategories(19, 21))
+
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "203.0.113.1", Honeypot: "/wp-login.php"})
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "203.0.113.1", Honeypot: "/wp-login.php"})
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "203.0.113.2", Pages: 100})
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "192.168.1.1", Honeypot: "/wp-login.php"})
+	c.Handle(analyzer.Event{Type: analyzer.EventUnblock, IP: "203.0.113.3"})
+	waitFor(t, func() bool {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		return len(api.reports) > 0
+	}, "honeypot block should be reported")
+	time.Sleep(50 * time.Millisecond)
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.reports) != 1 {
+		t.Fatalf("expected 1 report within the cooldown, got %+v", api.reports)
+	}
+	r := api.reports[0]
+	if r["ip"] != "203.0.113.1" || r["categories"] != "19,21" || r["comment"] != "Blocked by botrate: honeypot /wp-login.php" {
+		t.Errorf("unexpected report %+v", r)
+	}
+}
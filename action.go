@@ -0,0 +1,52 @@
+package botrate
+
+import "fmt"
+
+// Action is what to do with a denied request, see WithAction.
+type Action int
+
+const (
+	// ActionReject rejects denied requests right away. It is the default.
+	ActionReject Action = iota
+
+	// ActionTarpit holds denied requests for a ramping delay before
+	// rejecting them, to waste scraper resources. It is up to the caller,
+	// e.g. the botratehttp middleware, to hold the request.
+	ActionTarpit
+
+	// ActionChallenge serves denied requests a challenge, e.g. a CAPTCHA,
+	// that humans can pass to bypass the limiter. It is up to the caller,
+	// e.g. the botratehttp middleware with WithChallenge, to serve it.
+	ActionChallenge
+)
+
+// String returns the action name.
+func (a Action) String() string {
+	switch a {
+	case ActionReject:
+		return "reject"
+	case ActionTarpit:
+		return "tarpit"
+	case ActionChallenge:
+		return "challenge"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseAction returns the action named s, as returned by String, e.g. to
+// read actions from configuration.
+func ParseAction(s string) (Action, error) {
+	for _, a := range []Action{ActionReject, ActionTarpit, ActionChallenge} {
+		if s == a.String() {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("botrate: unknown action %q", s)
+}
+
+// Action returns the action for requests denied with reason, see
+// WithAction.
+func (l *Limiter) Action(reason Reason) Action {
+	return l.config().Actions[reason]
+}
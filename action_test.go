@@ -0,0 +1,66 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestLimiter_WithAction(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAction(ReasonFakeBot, ActionTarpit),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("TestBot/1.0", "10.0.0.1", "/"); d.Allowed || d.Action != ActionTarpit {
+		t.Errorf("fake bot should be tarpitted, got %+v", d)
+	}
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	if d := l.Decide("Mozilla/5.0", "192.168.1.1", "/"); d.Allowed || d.Action != ActionReject {
+		t.Errorf("rate limited IP should be rejected, got %+v", d)
+	}
+
+	if d := l.Decide("Mozilla/5.0", "192.168.1.2", "/"); !d.Allowed || d.Action != ActionReject {
+		t.Errorf("allowed request should have no action, got %+v", d)
+	}
+}
+
+func TestLimiter_WithAction_DryRun(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAction(ReasonFakeBot, ActionTarpit),
+		WithDryRun(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("TestBot/1.0", "10.0.0.1", "/"); !d.Allowed || d.Action != ActionReject {
+		t.Errorf("dry run should not tarpit, got %+v", d)
+	}
+}
+
+func TestAction_String(t *testing.T) {
+	if ActionReject.String() != "reject" || ActionTarpit.String() != "tarpit" || ActionChallenge.String() != "challenge" || Action(9).String() != "unknown" {
+		t.Error("unexpected action names")
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, a := range []Action{ActionReject, ActionTarpit, ActionChallenge} {
+		if got, err := ParseAction(a.String()); err != nil || got != a {
+			t.Errorf("ParseAction(%q) = %v, %v", a, got, err)
+		}
+	}
+	if _, err := ParseAction("drop"); err == nil {
+		t.Error("unknown actions should fail")
+	}
+}
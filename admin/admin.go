@@ -0,0 +1,336 @@
+// Package admin provides an HTTP handler for inspecting and managing a
+// botrate.Limiter at runtime.
+//
+// Mount it under a prefix with http.StripPrefix:
+//
+//	mux.Handle("/debug/botrate/", http.StripPrefix("/debug/botrate", admin.New(limiter)))
+//
+// Endpoints:
+//
+//	GET    /              stats snapshot
+//	GET    /blocked       blocked IPs with their page counts
+//	POST   /blocked/{ip}  block an IP for ?duration=, forever without
+//	DELETE /blocked/{ip}  unblock an IP
+//	GET    /ips/{ip}      what the limiter knows about an IP, see botrate.Limiter.Inspect
+//	GET    /config        limiter configuration
+//	PATCH  /config        set the limit, page threshold or window, see configPatch
+//	GET    /events        recent block events, newest first
+//	GET    /events/stream live events as Server-Sent Events
+//	GET    /snapshot      limiter state, see botrate.Limiter.Snapshot
+//	PUT    /snapshot      restore a snapshot, see botrate.Limiter.RestoreSnapshot
+//
+// The handler performs no authentication; protect it the same way as
+// net/http/pprof.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/analyzer"
+	"golang.org/x/time/rate"
+)
+
+// maxSnapshotSize bounds the snapshots PUT /snapshot reads.
+const maxSnapshotSize = 256 << 20
+
+// keepAlive is how often an idle event stream gets a comment, so proxies
+// don't time it out.
+var keepAlive = 30 * time.Second
+
+type handler struct {
+	l   *botrate.Limiter
+	mux *http.ServeMux
+}
+
+// New returns an http.Handler serving the admin API for l.
+func New(l *botrate.Limiter) http.Handler {
+	h := &handler{
+		l:   l,
+		mux: http.NewServeMux(),
+	}
+
+	h.mux.HandleFunc("GET /{$}", h.stats)
+	h.mux.HandleFunc("GET /blocked", h.blocked)
+	h.mux.HandleFunc("POST /blocked/{ip}", h.block)
+	h.mux.HandleFunc("DELETE /blocked/{ip}", h.unblock)
+	h.mux.HandleFunc("GET /ips/{ip}", h.inspect)
+	h.mux.HandleFunc("GET /config", h.config)
+	h.mux.HandleFunc("PATCH /config", h.patchConfig)
+	h.mux.HandleFunc("GET /events", h.events)
+	h.mux.HandleFunc("GET /events/stream", h.stream)
+	h.mux.HandleFunc("GET /snapshot", h.snapshot)
+	h.mux.HandleFunc("PUT /snapshot", h.restore)
+
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// StripPrefix leaves an empty path for the mount point itself
+	if r.URL.Path == "" {
+		r.URL.Path = "/"
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+type blockedIP struct {
+	IP    string     `json:"ip"`
+	Until *time.Time `json:"until,omitempty"`
+	Pages int        `json:"pages,omitempty"`
+}
+
+type config struct {
+	Hash             string   `json:"hash"`
+	Limit            string   `json:"limit"`
+	FakeBotLimit     string   `json:"fake_bot_limit"`
+	Burst            int      `json:"burst"`
+	Window           string   `json:"window"`
+	PageThreshold    int      `json:"page_threshold"`
+	RequestThreshold int      `json:"request_threshold,omitempty"`
+	ErrorThreshold   int      `json:"error_threshold,omitempty"`
+	LoginThreshold   int      `json:"login_threshold,omitempty"`
+	ScoreThreshold   float64  `json:"score_threshold"`
+	Signals          []string `json:"signals,omitempty"`
+	QueueCap         int      `json:"queue_cap"`
+	BlockDuration    string   `json:"block_duration"`
+}
+
+// configPatch is the body of PATCH /config, e.g.
+// {"page_threshold": 30, "window": "10m"}. Absent fields are left as
+// they are.
+type configPatch struct {
+	Limit         *string `json:"limit"` // events/duration, see botrate.ParseLimit
+	PageThreshold *int    `json:"page_threshold"`
+	Window        *string `json:"window"`
+}
+
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.l.Stats())
+}
+
+func (h *handler) blocked(w http.ResponseWriter, r *http.Request) {
+	// Page counts are only known for IPs still in the recent events
+	pages := make(map[string]int)
+	for _, e := range h.l.Events() {
+		if _, seen := pages[e.IP]; !seen {
+			pages[e.IP] = e.Pages
+		}
+	}
+
+	entries := h.l.Blocklist()
+	out := make([]blockedIP, 0, len(entries))
+	for _, e := range entries {
+		b := blockedIP{IP: e.IP, Pages: pages[e.IP]}
+		if !e.Until.IsZero() {
+			until := e.Until
+			b.Until = &until
+		}
+		out = append(out, b)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].IP < out[j].IP
+	})
+
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *handler) block(w http.ResponseWriter, r *http.Request) {
+	var d time.Duration
+	if s := r.URL.Query().Get("duration"); s != "" {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil || d <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid duration"})
+			return
+		}
+	}
+	if err := h.l.Block(r.PathValue("ip"), d); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) unblock(w http.ResponseWriter, r *http.Request) {
+	if err := h.l.Unblock(r.PathValue("ip")); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) inspect(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.l.Inspect(r.PathValue("ip")))
+}
+
+func (h *handler) config(w http.ResponseWriter, r *http.Request) {
+	cfg := h.l.Config()
+	writeJSON(w, http.StatusOK, config{
+		Hash:             h.l.ConfigHash(),
+		Limit:            formatLimit(cfg.Limit),
+		FakeBotLimit:     formatLimit(cfg.FakeBotLimit),
+		Burst:            cfg.Burst,
+		Window:           cfg.Window.String(),
+		PageThreshold:    cfg.PageThreshold,
+		RequestThreshold: cfg.RequestThreshold,
+		ErrorThreshold:   cfg.ErrorThreshold,
+		LoginThreshold:   loginThreshold(cfg),
+		ScoreThreshold:   cfg.ScoreThreshold,
+		Signals:          signalNames(cfg.Signals),
+		QueueCap:         cfg.QueueCap,
+		BlockDuration:    cfg.BlockDuration.String(),
+	})
+}
+
+func (h *handler) patchConfig(w http.ResponseWriter, r *http.Request) {
+	var p configPatch
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Everything is checked before anything is set
+	var limit rate.Limit
+	if p.Limit != nil {
+		var err error
+		if limit, err = botrate.ParseLimit(*p.Limit); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	if p.PageThreshold != nil && *p.PageThreshold <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid page threshold"})
+		return
+	}
+	var window time.Duration
+	if p.Window != nil {
+		var err error
+		if window, err = time.ParseDuration(*p.Window); err != nil || window <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid window"})
+			return
+		}
+	}
+
+	if p.Limit != nil {
+		h.l.SetLimit(limit)
+	}
+	if p.PageThreshold != nil {
+		h.l.SetPageThreshold(*p.PageThreshold)
+	}
+	if p.Window != nil {
+		h.l.SetWindow(window)
+	}
+	h.config(w, r)
+}
+
+func signalNames(signals []analyzer.Signal) []string {
+	names := make([]string, 0, len(signals))
+	for _, s := range signals {
+		names = append(names, s.Name())
+	}
+	return names
+}
+
+func (h *handler) events(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.l.Events())
+}
+
+// stream sends the limiter's events as they happen, see
+// botrate.Limiter.Subscribe, each as an SSE event named after its type
+// with the JSON event as data, until the client goes away.
+func (h *handler) stream(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := h.l.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return // the limiter is closed
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (h *handler) snapshot(w http.ResponseWriter, r *http.Request) {
+	data, err := h.l.Snapshot()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(data)
+}
+
+func (h *handler) restore(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshotSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": err.Error()})
+		return
+	}
+	if err := h.l.RestoreSnapshot(data); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// formatLimit renders a rate.Limit as an interval, which is how limits
+// are usually configured (rate.Every).
+func formatLimit(limit rate.Limit) string {
+	switch {
+	case limit == rate.Inf:
+		return "unlimited"
+	case limit <= 0:
+		return "blocked"
+	default:
+		return "1 every " + time.Duration(float64(time.Second)/float64(limit)).String()
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// loginThreshold returns the login threshold, zero when login detection
+// is off.
+func loginThreshold(cfg botrate.Config) int {
+	if len(cfg.LoginPaths) == 0 {
+		return 0
+	}
+	return cfg.LoginThreshold
+}
@@ -0,0 +1,327 @@
+package admin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+)
+
+func newLimiter(t *testing.T) *botrate.Limiter {
+	t.Helper()
+
+	kb, err := knownbots.New(knownbots.WithRoot(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Failed to create knownbots validator: %v", err)
+	}
+	t.Cleanup(func() { kb.Close() })
+
+	l, err := botrate.New(
+		botrate.WithKnownbots(kb),
+		botrate.WithLimit(rate.Every(time.Minute)),
+		botrate.WithAnalyzerWindow(time.Hour),
+		botrate.WithAnalyzerPageThreshold(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+	return l
+}
+
+func do(t *testing.T, h http.Handler, method, path string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: invalid JSON %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec
+}
+
+func TestAdmin_Stats(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+
+	var s botrate.Stats
+	rec := do(t, h, http.MethodGet, "/", &s)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if s.Requests != 1 {
+		t.Errorf("expected 1 request, got %d", s.Requests)
+	}
+}
+
+func TestAdmin_Blocked(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Block("192.168.1.2", 0)
+
+	var out []blockedIP
+	do(t, h, http.MethodGet, "/blocked", &out)
+
+	if len(out) != 2 {
+		t.Fatalf("expected 2 blocked IPs, got %d", len(out))
+	}
+	if out[0].IP != "192.168.1.1" || out[0].Until == nil {
+		t.Errorf("expected expiring block first, got %+v", out[0])
+	}
+	if out[1].IP != "192.168.1.2" || out[1].Until != nil {
+		t.Errorf("expected permanent block second, got %+v", out[1])
+	}
+}
+
+func TestAdmin_Blocked_Pages(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/a")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/b")
+	time.Sleep(200 * time.Millisecond)
+
+	var out []blockedIP
+	do(t, h, http.MethodGet, "/blocked", &out)
+
+	if len(out) != 1 || out[0].Pages != 2 {
+		t.Errorf("expected blocked IP with 2 pages, got %+v", out)
+	}
+}
+
+func TestAdmin_Unblock(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Block("192.168.1.1", time.Hour)
+
+	rec := do(t, h, http.MethodDelete, "/blocked/192.168.1.1", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+
+	if len(l.Blocklist()) != 0 {
+		t.Error("IP should be unblocked")
+	}
+}
+
+func TestAdmin_Block(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	rec := do(t, h, http.MethodPost, "/blocked/192.168.1.1?duration=1h", nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", rec.Code)
+	}
+	entries := l.Blocklist()
+	if len(entries) != 1 || entries[0].IP != "192.168.1.1" || entries[0].Until.IsZero() {
+		t.Errorf("IP should be blocked for an hour, got %+v", entries)
+	}
+
+	if rec := do(t, h, http.MethodPost, "/blocked/192.168.1.2?duration=soon", nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid durations should get 400, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Inspect(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Block("192.168.1.1", time.Hour)
+
+	var info botrate.IPInfo
+	if rec := do(t, h, http.MethodGet, "/ips/192.168.1.1", &info); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	if info.IP != "192.168.1.1" || !info.Blocked || len(info.Events) != 1 {
+		t.Errorf("unexpected info %+v", info)
+	}
+}
+
+func TestAdmin_Snapshot(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Block("192.168.1.1", time.Hour)
+	rec := do(t, h, http.MethodGet, "/snapshot", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+
+	other := newLimiter(t)
+	req := httptest.NewRequest(http.MethodPut, "/snapshot", strings.NewReader(rec.Body.String()))
+	rec = httptest.NewRecorder()
+	New(other).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+	if entries := other.Blocklist(); len(entries) != 1 || entries[0].IP != "192.168.1.1" {
+		t.Errorf("snapshot should be restored, got %+v", entries)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/snapshot", strings.NewReader(`{"version":0}`))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid snapshots should get 400, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Config(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	var cfg config
+	do(t, h, http.MethodGet, "/config", &cfg)
+
+	if cfg.Limit != "1 every 1m0s" {
+		t.Errorf("unexpected limit %q", cfg.Limit)
+	}
+	if cfg.PageThreshold != 2 {
+		t.Errorf("expected threshold 2, got %d", cfg.PageThreshold)
+	}
+	if cfg.Window != "1h0m0s" {
+		t.Errorf("unexpected window %q", cfg.Window)
+	}
+	if cfg.Hash != l.ConfigHash() {
+		t.Errorf("expected hash %q, got %q", l.ConfigHash(), cfg.Hash)
+	}
+}
+
+func TestAdmin_PatchConfig(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	patch := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPatch, "/config", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := patch(`{"limit": "1/10m", "page_threshold": 30, "window": "10m"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var cfg config
+	if err := json.Unmarshal(rec.Body.Bytes(), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Limit != "1 every 10m0s" || cfg.PageThreshold != 30 || cfg.Window != "10m0s" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if cfg.Hash != l.ConfigHash() {
+		t.Errorf("expected hash %q, got %q", l.ConfigHash(), cfg.Hash)
+	}
+
+	for _, body := range []string{`{"window": "soon"}`, `{"page_threshold": 0}`, `{"limit": "fast"}`, `{"burst": 2}`} {
+		if rec := patch(body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rec.Code)
+		}
+	}
+	if got := l.Config().PageThreshold; got != 30 {
+		t.Errorf("invalid patches should change nothing, threshold %d", got)
+	}
+}
+
+func TestAdmin_Events(t *testing.T) {
+	l := newLimiter(t)
+	h := New(l)
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Block("192.168.1.2", time.Hour)
+
+	var events []analyzer.Event
+	do(t, h, http.MethodGet, "/events", &events)
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].IP != "192.168.1.2" || !events[0].Manual {
+		t.Errorf("expected newest manual event first, got %+v", events[0])
+	}
+}
+
+func TestAdmin_EventStream(t *testing.T) {
+	l := newLimiter(t)
+	srv := httptest.NewServer(New(l))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events/stream", nil)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET /events/stream: %v", err)
+	}
+	defer res.Body.Close()
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %q", ct)
+	}
+
+	// Subscribed once the headers are sent
+	l.Block("192.168.1.1", time.Hour)
+
+	r := bufio.NewReader(res.Body)
+	line, _ := r.ReadString('\n')
+	if line != "event: block\n" {
+		t.Fatalf("expected a block event, got %q", line)
+	}
+	line, _ = r.ReadString('\n')
+	var e analyzer.Event
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil || e.IP != "192.168.1.1" {
+		t.Errorf("expected the JSON event, got %q", line)
+	}
+}
+
+func TestAdmin_StripPrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/botrate/", http.StripPrefix("/debug/botrate", New(newLimiter(t))))
+
+	rec := do(t, mux, http.MethodGet, "/debug/botrate/config", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "page_threshold") {
+		t.Errorf("expected config under prefix, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAdmin_MethodNotAllowed(t *testing.T) {
+	h := New(newLimiter(t))
+
+	rec := do(t, h, http.MethodPost, "/blocked", nil)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestFormatLimit(t *testing.T) {
+	testCases := []struct {
+		limit rate.Limit
+		want  string
+	}{
+		{rate.Inf, "unlimited"},
+		{0, "blocked"},
+		{rate.Every(10 * time.Minute), "1 every 10m0s"},
+	}
+
+	for _, tc := range testCases {
+		if got := formatLimit(tc.limit); got != tc.want {
+			t.Errorf("formatLimit(%v) = %q, want %q", tc.limit, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package botrate
+
+import "github.com/cnlangzi/knownbots"
+
+// AIBotAction is what to do with AI bots, see WithAIBotPolicy.
+type AIBotAction int
+
+const (
+	// AIBotAllow lets AI bots through like other verified bots.
+	AIBotAllow AIBotAction = iota
+
+	// AIBotLimit throttles each AI bot, across its IPs, with
+	// WithAIBotLimit.
+	AIBotLimit
+
+	// AIBotDeny denies AI bots with ReasonAIBot.
+	AIBotDeny
+)
+
+// String returns the action name.
+func (a AIBotAction) String() string {
+	switch a {
+	case AIBotAllow:
+		return "allow"
+	case AIBotLimit:
+		return "limit"
+	case AIBotDeny:
+		return "deny"
+	default:
+		return "unknown"
+	}
+}
+
+// aiKinds are the knownbots kinds of AI bots.
+var aiKinds = []knownbots.BotKind{knownbots.KindAITraining, knownbots.KindAIAssist, knownbots.KindAIMixed}
+
+// aiBotPolicy finds the action for a bot, by name, then by kind, then
+// for all AI bots. Keys are bot names, kinds, or "" for all AI bots.
+type aiBotPolicy map[string]AIBotAction
+
+func (p aiBotPolicy) action(res knownbots.Result) (AIBotAction, bool) {
+	if a, ok := p[res.BotName]; ok {
+		return a, true
+	}
+	if a, ok := p[string(res.BotKind)]; ok {
+		return a, true
+	}
+	for _, k := range aiKinds {
+		if res.BotKind == k {
+			a, ok := p[""]
+			return a, ok
+		}
+	}
+	return AIBotAllow, false
+}
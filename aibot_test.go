@@ -0,0 +1,73 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+)
+
+func TestAIBotPolicy_Action(t *testing.T) {
+	p := aiBotPolicy{
+		"":                               AIBotLimit,
+		string(knownbots.KindAITraining): AIBotDeny,
+		"gptbot":                         AIBotAllow,
+	}
+	tests := []struct {
+		res  knownbots.Result
+		want AIBotAction
+		ok   bool
+	}{
+		{knownbots.Result{BotName: "gptbot", BotKind: knownbots.KindAITraining}, AIBotAllow, true},
+		{knownbots.Result{BotName: "ccbot", BotKind: knownbots.KindAITraining}, AIBotDeny, true},
+		{knownbots.Result{BotName: "perplexity-user", BotKind: knownbots.KindAIAssist}, AIBotLimit, true},
+		{knownbots.Result{BotName: "googlebot", BotKind: knownbots.KindSearchEngine}, AIBotAllow, false},
+	}
+	for _, tt := range tests {
+		if got, ok := p.action(tt.res); got != tt.want || ok != tt.ok {
+			t.Errorf("action(%s) = %v, %v, want %v, %v", tt.res.BotName, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLimiter_WithAIBotPolicy(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.NewAI(t)),
+		WithAIBotPolicy(AIBotDeny, string(knownbots.KindAITraining)),
+		WithAIBotPolicy(AIBotLimit),
+		WithAIBotLimit(rate.Every(time.Hour), 2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	d := l.Decide("TrainBot/1.0", "192.168.100.1", "/")
+	if d.Allowed || d.Reason != ReasonAIBot || d.BotKind != knownbots.KindAITraining {
+		t.Errorf("training bots should be denied, got %+v", d)
+	}
+
+	// The bucket is shared by all the IPs of the bot
+	for _, ip := range []string{"192.168.100.1", "192.168.100.2"} {
+		if d := l.Decide("AnswerBot/1.0", ip, "/"); !d.Allowed {
+			t.Errorf("answer bots within the burst should be allowed, got %+v", d)
+		}
+	}
+	if d := l.Decide("AnswerBot/1.0", "192.168.100.3", "/"); d.Allowed || d.Reason != ReasonAIBot {
+		t.Errorf("answer bots past the burst should be limited, got %+v", d)
+	}
+
+	for i := 0; i < 5; i++ {
+		if d := l.Decide("TestBot/1.0", "192.168.100.1", "/"); !d.Allowed {
+			t.Fatalf("search engines should not be affected, got %+v", d)
+		}
+	}
+	if d := l.Decide("TrainBot/1.0", "10.0.0.1", "/"); d.Reason != ReasonFakeBot {
+		t.Errorf("unverified AI bots should be fake bots, got %+v", d)
+	}
+	if s := l.Stats(); s.Denied[ReasonAIBot] != 2 {
+		t.Errorf("expected 2 AI bot denials, got %d", s.Denied[ReasonAIBot])
+	}
+}
@@ -1,80 +1,688 @@
 package analyzer
 
 import (
+	"context"
+	"errors"
 	"hash/maphash"
+	"log/slog"
+	"net/netip"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/cnlangzi/botrate/internal/logging"
 )
 
 type Config struct {
 	Window        time.Duration
 	PageThreshold int
 	QueueCap      int
+
+	// BlockDuration is how long an IP stays blocked. Zero blocks forever.
+	BlockDuration time.Duration
+
+	// PenaltySchedule escalates block durations for repeat offenders,
+	// e.g. 5m, 1h, 24h: an IP's nth block lasts the nth duration, or
+	// the last one past the end. Offenses are forgotten once the last
+	// duration has passed since a block ended. Overrides BlockDuration.
+	PenaltySchedule []time.Duration
+
+	// SweepInterval is how often expired blocks are removed.
+	// Defaults to DefaultSweepInterval, capped at BlockDuration or the
+	// shortest penalty.
+	SweepInterval time.Duration
+
+	// Store holds the blocklist. Defaults to a MemoryStore.
+	Store Store
+
+	// MaxBlocklist bounds the IPs on the default MemoryStore and on the
+	// greylist, see MemoryStore.SetMaxLen. Zero is no bound.
+	MaxBlocklist int
+
+	// PrefixThreshold is the number of IPs of a prefix blocked in a
+	// window, by analysis or honeypot, at which the whole prefix is
+	// blocked for BlockDuration, see BlockedPrefix: botnets rotating
+	// addresses within a provider's range outpace blocking them one by
+	// one. Prefixes are PrefixLenV4 and PrefixLenV6 bits long, defaulting
+	// to DefaultPrefixLenV4 and DefaultPrefixLenV6. Prefix blocks are kept
+	// in memory, whatever the Store. Zero disables them.
+	PrefixThreshold int
+	PrefixLenV4     int
+	PrefixLenV6     int
+
+	// EventsCap is the number of recent block events kept for inspection.
+	// Defaults to DefaultEventsCap.
+	EventsCap int
+
+	// IgnoreAssets lists static asset extensions (".js") and content
+	// types ("image/") that do not count as distinct pages, since one HTML
+	// page legitimately fans out to dozens of asset URLs. See DefaultAssets.
+	IgnoreAssets []string
+
+	// PathNormalizer maps a path to the page it counts as before hashing.
+	// Defaults to StripQuery, see also NormalizePath.
+	PathNormalizer func(string) string
+
+	// BloomCapacity and BloomFalsePositiveRate size the bloom filter
+	// deduplicating pages per window: the number of distinct IP and page
+	// pairs per window, and the rate at which a new page is mistaken for a
+	// revisit. Default to BloomMaxCapacity and BloomFalsePositiveRate.
+	// With a QueryThreshold, the query strings of pages get a filter of
+	// their own, sized alike.
+	BloomCapacity          uint
+	BloomFalsePositiveRate float64
+
+	// SlidingWindow makes counts decay smoothly instead of resetting at
+	// the end of a window: the previous window's counts are added,
+	// weighted down from 1 to 0 over the current window. Otherwise a bot
+	// can stay just below the thresholds in every window forever. See
+	// Rotator for custom signals.
+	SlidingWindow bool
+
+	// HyperLogLog estimates distinct pages per IP with a HyperLogLog
+	// sketch of visited paths instead of the bloom filter and counter.
+	// It is not distorted by bloom false positives, at 256 bytes per IP.
+	HyperLogLog bool
+
+	// RequestThreshold is the number of requests per window, distinct
+	// or not, that blocks an IP on its own. Zero disables the signal.
+	RequestThreshold int
+
+	// ErrorThreshold is the number of error responses (status >= 400)
+	// per window that blocks an IP on its own, see RecordResponse. Zero
+	// disables the signal.
+	ErrorThreshold int
+
+	// LoginThreshold is the number of failed logins per window, see
+	// RecordLoginFailure, that blocks an IP on its own. It is meant to be
+	// much lower than the page threshold. Zero disables the signal.
+	LoginThreshold int
+
+	// HeaderThreshold is the number of requests per window whose headers
+	// don't match their User-Agent, see RecordHeaders, that blocks an IP
+	// on its own. Zero disables the signal.
+	HeaderThreshold int
+
+	// SequenceThreshold is the number of sequential IDs per window, like
+	// /item/1001 then /item/1002, that blocks an IP on its own: scrapers
+	// enumerate IDs at rates the page threshold misses, and NormalizePath
+	// counts them as one page. Paging through a listing is sequential
+	// too, so it is meant to be above the pages a reader pages through.
+	// Zero disables the signal.
+	SequenceThreshold int
+
+	// QueryThreshold is the number of distinct query strings of a page
+	// per window that blocks an IP on its own: parameter fuzzers and
+	// cache-busting scrapers hit one page with thousands of them, which
+	// count as one page once StripQuery strips them, and as a crawl
+	// otherwise. Pages are as PathNormalizer counts them, so it must
+	// strip the query. Zero disables the signal.
+	QueryThreshold int
+
+	// Signals are scored alongside the built-in distinct pages and
+	// requests signals.
+	Signals []Signal
+
+	// ScoreThreshold is the summed signal score at which an IP is
+	// blocked. Defaults to DefaultScoreThreshold.
+	ScoreThreshold float64
+
+	// GreylistThreshold is the summed signal score, below ScoreThreshold,
+	// at which an IP is greylisted for the rest of the window: suspicious
+	// but not blocked yet. Zero disables the greylist.
+	GreylistThreshold float64
+
+	// OnGreylist is called from a worker goroutine, or the recording one
+	// with Sync, when an IP is greylisted, and must not block.
+	OnGreylist func(ip string, score float64)
+
+	// OnEvent is called with every block, unblock and drop event, from
+	// the goroutine causing it, and must not block.
+	OnEvent func(Event)
+
+	// OnWindow is called from a background goroutine at the end of every
+	// window, e.g. to forget state kept for a window, and must not block.
+	OnWindow func()
+
+	// Workers is the number of worker goroutines analyzing events, each
+	// with its own queue of QueueCap events, bloom filter and counters
+	// for a share of the IPs. Raise it when a single worker falls behind
+	// and events are dropped. Custom Signals are shared by the workers
+	// and locked while called. Defaults to 1.
+	Workers int
+
+	// Sync analyzes events inline, on the goroutine recording them,
+	// instead of queueing them for the workers: a block applies before
+	// Record returns. Meant for deterministic tests, it puts the analysis
+	// on the request path. Windows still rotate in the background.
+	Sync bool
+
+	// Clock tells the time for windows, blocks and sweeps. Defaults to
+	// SystemClock. It also times the default MemoryStore, but not a
+	// Store passed in.
+	Clock Clock
+
+	// Logger receives block, rotation and queue drop events.
+	// Defaults to discarding everything.
+	Logger *slog.Logger
+}
+
+// ErrClosed is returned by Flush once the analyzer is closed.
+var ErrClosed = errors.New("analyzer: closed")
+
+// Default configuration values.
+var (
+	DefaultSweepInterval  = time.Minute
+	DefaultEventsCap      = 100
+	DefaultScoreThreshold = 1.0
+)
+
+// Thresholds are the settings of Config that can be changed while the
+// analyzer runs, see SetThresholds.
+type Thresholds struct {
+	PageThreshold     int
+	RequestThreshold  int
+	ErrorThreshold    int
+	LoginThreshold    int
+	HeaderThreshold   int
+	SequenceThreshold int
+	QueryThreshold    int
+	ScoreThreshold    float64
+	GreylistThreshold float64
+	PrefixThreshold   int
+	BlockDuration     time.Duration
 }
 
 type Request struct {
 	IP   string
 	Path uint64
+
+	// Threshold overrides Config.PageThreshold when positive.
+	Threshold int
+
+	// Status is the response status for responses, zero for requests.
+	Status int
+
+	// LoginFailure marks a failed login response.
+	LoginFailure bool
+
+	// Headers and Mismatch describe the request's headers, see
+	// RecordHeaders.
+	Headers  uint64
+	Mismatch bool
+
+	// Template and ID describe the path's last numeric segment, see
+	// Observation.Template.
+	Template uint64
+	ID       uint64
+
+	// Query is the hash of the query string, see Observation.Query.
+	Query uint64
+
+	// Weight, Method and MethodThreshold, see Visit.
+	Weight          int
+	Method          string
+	MethodThreshold int
+}
+
+// Visit is a request to record with RecordVisit.
+type Visit struct {
+	IP   string
+	Path string
+
+	// Threshold overrides Config.PageThreshold when positive, see
+	// RecordThreshold.
+	Threshold int
+
+	// Headers and Mismatch describe the request's headers, see
+	// RecordHeaders.
+	Headers  uint64
+	Mismatch bool
+
+	// Weight is how many requests the request counts as, for the pages
+	// and requests signals, e.g. more for a POST than a GET. Values
+	// below 1 are 1. The estimate of Config.HyperLogLog is not weighted.
+	Weight int
+
+	// MethodThreshold, when positive, is the number of requests per
+	// window of Method, weighted, that blocks an IP on its own: posting
+	// a form a few times can warrant a block long before reading pages
+	// does. Requests without one are not counted.
+	Method          string
+	MethodThreshold int
 }
 
 type Analyzer struct {
 	cfg Config
 
-	// Hot path: atomic blocklist with string keys
-	blocklist atomic.Pointer[map[string]struct{}]
+	// Thresholds in effect, Config's until SetThresholds
+	thresholds atomic.Pointer[Thresholds]
+
+	// Window in effect, Config's until SetWindow
+	window   atomic.Int64
+	windowMu sync.Mutex
+
+	// Hot path: blocklist store
+	store Store
 
-	// Cold path: event queue
-	queue chan *Request
+	// Cold path: workers, each analyzing a share of the IPs
+	shards []*shard
 
-	// Worker state
-	bloom   *DoubleBufferBloom
-	counter *Counter
+	// Recent block events
+	events *ring
+
+	// Offenses for the penalty schedule, nil without one
+	penalties *penalties
+
+	// Greylisted IPs, expiring after a window
+	grey *MemoryStore
+
+	// Prefix blocks, see Config.PrefixThreshold
+	prefixes *prefixes
+
+	// Events dropped because the queue was full
+	dropped     atomic.Uint64
+	lastDropped uint64
+
+	// Blocks evicted at the last report
+	lastEvicted uint64
+
+	logger *slog.Logger
+
+	// Static assets that are not counted
+	assets *assetFilter
+
+	// Flush requests for the janitor, see shard.flushes
+	flushes chan chan struct{}
+
+	// Window tickers for the janitor on SetWindow, see shard.windows
+	windows chan Ticker
 
 	// Close channel for cleanup
 	stop chan struct{}
-
-	// Object pool for Request reuse
-	pool sync.Pool
 }
 
 func New(cfg Config) *Analyzer {
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
+
 	a := &Analyzer{
-		cfg:     cfg,
-		queue:   make(chan *Request, cfg.QueueCap),
-		bloom:   NewDoubleBufferBloom(),
-		counter: NewCounter(),
-		stop:    make(chan struct{}),
-		pool: sync.Pool{
-			New: func() interface{} {
-				return &Request{}
-			},
-		},
-	}
-
-	bl := make(map[string]struct{})
-	a.blocklist.Store(&bl)
-
-	go a.worker()
+		cfg:      cfg,
+		grey:     newMemoryStore(cfg.Clock, cfg.MaxBlocklist),
+		prefixes: newPrefixes(cfg.PrefixLenV4, cfg.PrefixLenV6),
+		flushes:  make(chan chan struct{}),
+		windows:  make(chan Ticker),
+		stop:     make(chan struct{}),
+	}
+
+	a.logger = cfg.Logger
+	if a.logger == nil {
+		a.logger = logging.Discard()
+	}
+
+	a.assets = newAssetFilter(cfg.IgnoreAssets)
+
+	if a.cfg.BloomCapacity == 0 {
+		a.cfg.BloomCapacity = BloomMaxCapacity
+	}
+	if a.cfg.BloomFalsePositiveRate <= 0 || a.cfg.BloomFalsePositiveRate >= 1 {
+		a.cfg.BloomFalsePositiveRate = BloomFalsePositiveRate
+	}
+	if a.cfg.Workers <= 0 {
+		a.cfg.Workers = 1
+	}
+	a.SetThresholds(Thresholds{
+		PageThreshold:     cfg.PageThreshold,
+		RequestThreshold:  cfg.RequestThreshold,
+		ErrorThreshold:    cfg.ErrorThreshold,
+		LoginThreshold:    cfg.LoginThreshold,
+		HeaderThreshold:   cfg.HeaderThreshold,
+		SequenceThreshold: cfg.SequenceThreshold,
+		QueryThreshold:    cfg.QueryThreshold,
+		ScoreThreshold:    cfg.ScoreThreshold,
+		GreylistThreshold: cfg.GreylistThreshold,
+		PrefixThreshold:   cfg.PrefixThreshold,
+		BlockDuration:     cfg.BlockDuration,
+	})
+
+	if a.cfg.PathNormalizer == nil {
+		a.cfg.PathNormalizer = StripQuery
+	}
+
+	a.store = cfg.Store
+	if a.store == nil {
+		a.store = newMemoryStore(cfg.Clock, cfg.MaxBlocklist)
+	}
+
+	if a.cfg.EventsCap <= 0 {
+		a.cfg.EventsCap = DefaultEventsCap
+	}
+	a.events = newRing(a.cfg.EventsCap)
+
+	if a.cfg.SweepInterval <= 0 {
+		a.cfg.SweepInterval = DefaultSweepInterval
+	}
+	if a.cfg.BlockDuration > 0 && a.cfg.BlockDuration < a.cfg.SweepInterval {
+		a.cfg.SweepInterval = a.cfg.BlockDuration
+	}
+	if len(a.cfg.PenaltySchedule) > 0 {
+		a.penalties = newPenalties(a.cfg.PenaltySchedule)
+		for _, d := range a.cfg.PenaltySchedule {
+			if d > 0 && d < a.cfg.SweepInterval {
+				a.cfg.SweepInterval = d
+			}
+		}
+	}
+
+	a.window.Store(int64(a.cfg.Window))
+
+	signals := a.cfg.Signals
+	if a.cfg.Workers > 1 {
+		// Custom signals are shared by the workers
+		signals = make([]Signal, len(a.cfg.Signals))
+		for i, sig := range a.cfg.Signals {
+			signals[i] = &lockedSignal{Signal: sig}
+		}
+	}
+	a.shards = make([]*shard, a.cfg.Workers)
+	for i := range a.shards {
+		a.shards[i] = newShard(a, i == 0, signals)
+		go a.shards[i].work(a.cfg.Clock.NewTicker(a.cfg.Window))
+	}
+	// Tickers are created before the goroutines start, so a ManualClock
+	// advanced right after New fires them
+	go a.janitor(a.cfg.Clock.NewTicker(a.cfg.Window), a.cfg.Clock.NewTicker(a.cfg.SweepInterval))
 	return a
 }
 
 func (a *Analyzer) Record(ip, path string) {
-	req := a.pool.Get().(*Request)
-	req.IP = ip
-	req.Path = hashStr(path)
+	a.RecordThreshold(ip, path, 0)
+}
+
+// RecordThreshold is like Record but blocks ip at threshold distinct pages
+// instead of Config.PageThreshold, when threshold is positive.
+func (a *Analyzer) RecordThreshold(ip, path string, threshold int) {
+	a.RecordHeaders(ip, path, threshold, 0, false)
+}
+
+// RecordHeaders is like RecordThreshold, with the hash of the request's
+// header names, zero if unknown, for custom signals, and whether its
+// headers don't match its User-Agent, e.g. a browser's without the
+// headers browsers send, see Config.HeaderThreshold.
+func (a *Analyzer) RecordHeaders(ip, path string, threshold int, headers uint64, mismatch bool) {
+	a.RecordVisit(Visit{IP: ip, Path: path, Threshold: threshold, Headers: headers, Mismatch: mismatch})
+}
+
+// RecordVisit is like Record, for a request described by v.
+func (a *Analyzer) RecordVisit(v Visit) {
+	if a.assets.Match(v.Path) {
+		return
+	}
+
+	req := Request{
+		IP:              v.IP,
+		Path:            hashStr(a.cfg.PathNormalizer(v.Path)),
+		Threshold:       v.Threshold,
+		Headers:         v.Headers,
+		Mismatch:        v.Mismatch,
+		Weight:          v.Weight,
+		Method:          v.Method,
+		MethodThreshold: v.MethodThreshold,
+	}
+	t := a.thresholds.Load()
+	if t.SequenceThreshold > 0 {
+		// The IDs are read before normalization, which may collapse them
+		req.Template, req.ID = sequenceID(StripQuery(v.Path))
+	}
+	if t.QueryThreshold > 0 {
+		req.Query = queryHash(v.Path)
+	}
+	a.enqueue(req)
+}
+
+// RecordResponse records the status of a response sent to ip, so signals
+// can detect 404 storms, brute forcing and fuzzers triggering errors.
+func (a *Analyzer) RecordResponse(ip string, status int) {
+	a.enqueue(Request{IP: ip, Status: status})
+}
+
+// RecordLoginFailure records a failed login by ip, see
+// Config.LoginThreshold.
+func (a *Analyzer) RecordLoginFailure(ip string, status int) {
+	a.enqueue(Request{IP: ip, Status: status, LoginFailure: true})
+}
+
+// enqueue hands req to the worker of its shard. Requests are passed by
+// value: a pool of them would miss whenever the worker returns one on
+// another P than the caller's, allocating on the hot path.
+func (a *Analyzer) enqueue(req Request) {
+	if a.cfg.Sync {
+		a.shard(req.IP).process(req)
+		return
+	}
 
 	select {
-	case a.queue <- req:
+	case a.shard(req.IP).queue <- req:
 	default:
-		a.pool.Put(req)
+		// Queue full: drop and report on the next rotation,
+		// logging here would flood under load.
+		a.dropped.Add(1)
 	}
 }
 
 func (a *Analyzer) Blocked(ip string) bool {
-	bl := *a.blocklist.Load()
-	_, exists := bl[ip]
-	return exists
+	return a.store.Blocked(ip)
+}
+
+// Greylisted reports whether ip is greylisted, see
+// Config.GreylistThreshold. Blocked IPs are not greylisted.
+func (a *Analyzer) Greylisted(ip string) bool {
+	return a.grey.Blocked(ip)
+}
+
+// Block adds ip to the blocklist for d, overriding the configured
+// BlockDuration. A d <= 0 blocks forever.
+func (a *Analyzer) Block(ip string, d time.Duration) error {
+	if err := a.store.Block(ip, d); err != nil {
+		return err
+	}
+	a.emit(Event{Type: EventBlock, Time: a.cfg.Clock.Now(), IP: ip, Manual: true, Duration: d})
+	a.logger.Info("botrate: ip blocked manually", "ip", ip, "duration", d)
+	return nil
+}
+
+// Trap blocks ip right away for requesting the honeypot path, a URL no
+// human would visit. Unlike the score, it does not wait for the worker.
+func (a *Analyzer) Trap(ip, path string) error {
+	offenses, d := a.penalty(ip)
+	if err := a.store.Block(ip, d); err != nil {
+		return err
+	}
+	a.emit(Event{Type: EventBlock, Time: a.cfg.Clock.Now(), IP: ip, Honeypot: path, Offenses: offenses, Duration: d})
+	a.logger.Info("botrate: ip blocked by honeypot", "ip", ip, "path", path, "offenses", offenses, "duration", d)
+	a.escalate(ip)
+	return nil
+}
+
+// Unblock removes ip from the blocklist and resets its page count and
+// offenses so it isn't blocked again on its next request. A prefix, e.g.
+// "203.0.113.0/24", removes its prefix block.
+func (a *Analyzer) Unblock(ip string) error {
+	if prefix, err := netip.ParsePrefix(ip); err == nil {
+		a.unblockPrefix(prefix)
+		return nil
+	}
+	if err := a.store.Unblock(ip); err != nil {
+		return err
+	}
+	if a.penalties != nil {
+		a.penalties.forget(ip)
+	}
+	a.grey.Unblock(ip)
+	a.logger.Info("botrate: ip unblocked", "ip", ip)
+	a.emit(Event{Type: EventUnblock, Time: a.cfg.Clock.Now(), IP: ip, Manual: true})
+
+	if a.cfg.Sync {
+		a.shard(ip).forget(ip)
+		return nil
+	}
+	select {
+	case a.shard(ip).resets <- ip:
+	case <-a.stop:
+	}
+	return nil
+}
+
+// Thresholds returns the thresholds in effect.
+func (a *Analyzer) Thresholds() Thresholds {
+	return *a.thresholds.Load()
+}
+
+// SetThresholds changes the thresholds while the analyzer runs, keeping
+// the counts, blocks and greylist: they apply from the next event
+// analyzed, blocks already made keep their duration. A ScoreThreshold
+// <= 0 is DefaultScoreThreshold, as for Config.
+func (a *Analyzer) SetThresholds(t Thresholds) {
+	if t.ScoreThreshold <= 0 {
+		t.ScoreThreshold = DefaultScoreThreshold
+	}
+	a.thresholds.Store(&t)
+}
+
+// Window returns the window in effect.
+func (a *Analyzer) Window() time.Duration {
+	return time.Duration(a.window.Load())
+}
+
+// SetWindow changes the window while the analyzer runs, keeping the
+// counts: the current window ends d after the call, and the next ones
+// last d. d must be positive.
+func (a *Analyzer) SetWindow(d time.Duration) {
+	if d <= 0 {
+		panic("analyzer: non-positive window")
+	}
+
+	a.windowMu.Lock()
+	defer a.windowMu.Unlock()
+	a.window.Store(int64(d))
+	windows := []chan Ticker{a.windows}
+	for _, s := range a.shards {
+		windows = append(windows, s.windows)
+	}
+	for _, w := range windows {
+		// Ticking from now, not from when the shard gets to it
+		ticker := a.cfg.Clock.NewTicker(d)
+		select {
+		case w <- ticker:
+		case <-a.stop:
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// Range calls fn for each blocked IP and the time its block ends
+// (zero never expires), until fn returns false.
+func (a *Analyzer) Range(fn func(ip string, until time.Time) bool) {
+	a.store.Range(fn)
+}
+
+// emit records e for Events if it is a block, and passes it to
+// Config.OnEvent.
+func (a *Analyzer) emit(e Event) {
+	if e.Type == EventBlock {
+		a.events.add(e)
+	}
+	if a.cfg.OnEvent != nil {
+		a.cfg.OnEvent(e)
+	}
+}
+
+// Events returns recent block events, newest first.
+func (a *Analyzer) Events() []Event {
+	return a.events.list()
+}
+
+// Stats is a snapshot of analyzer state.
+type Stats struct {
+	Blocklist int
+	Greylist  int
+	QueueLen  int
+	QueueCap  int
+	Dropped   uint64
+
+	// Evicted is the number of blocks evicted to keep within
+	// Config.MaxBlocklist.
+	Evicted uint64
+
+	// Prefixes is the number of blocked prefixes, and PrefixBlocks of
+	// prefix blocks so far, see Config.PrefixThreshold.
+	Prefixes     int
+	PrefixBlocks uint64
+}
+
+func (a *Analyzer) Stats() Stats {
+	st := Stats{
+		Blocklist: a.store.Len(),
+		Greylist:  a.grey.Len(),
+		Dropped:   a.dropped.Load(),
+		Evicted:   a.evicted(),
+
+		Prefixes:     len(*a.prefixes.blocked.Load()),
+		PrefixBlocks: a.prefixes.promoted.Load(),
+	}
+	for _, s := range a.shards {
+		st.QueueLen += len(s.queue)
+		st.QueueCap += cap(s.queue)
+	}
+	return st
+}
+
+// shard returns the worker analyzing ip. An IP always goes to the same
+// worker, so its counts and blocks need no coordination.
+func (a *Analyzer) shard(ip string) *shard {
+	if len(a.shards) == 1 {
+		return a.shards[0]
+	}
+	return a.shards[maphash.String(seed, ip)%uint64(len(a.shards))]
+}
+
+// Flush blocks until the events recorded before it was called have been
+// analyzed and their blocks applied, e.g. before Close on shutdown so
+// the final blocks reach the Store. Ticks of a ManualClock advanced
+// before are handled too. It returns ctx's error if ctx is
+// done first, and ErrClosed after Close.
+func (a *Analyzer) Flush(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	flushes := []chan chan struct{}{a.flushes}
+	for _, s := range a.shards {
+		flushes = append(flushes, s.flushes)
+	}
+	done := make([]chan struct{}, len(flushes))
+	for i, f := range flushes {
+		done[i] = make(chan struct{})
+		select {
+		case f <- done[i]:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.stop:
+			return ErrClosed
+		}
+	}
+	for _, d := range done {
+		select {
+		case <-d:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.stop:
+			return ErrClosed
+		}
+	}
+	return nil
 }
 
 func (a *Analyzer) Close() {
@@ -86,62 +694,134 @@ func (a *Analyzer) Close() {
 	}
 }
 
-func (a *Analyzer) worker() {
-	ticker := time.NewTicker(a.cfg.Window)
-	defer ticker.Stop()
+// janitor expires blocks, and reports dropped events and evicted blocks
+// and forgets the IPs blocked by prefix once a window.
+func (a *Analyzer) janitor(ticker, sweep Ticker) {
+	defer func() {
+		ticker.Stop()
+	}()
+	defer sweep.Stop()
 
 	for {
 		select {
 		case <-a.stop:
 			return
-		case req := <-a.queue:
-			a.analyze(req)
-			a.pool.Put(req)
-		case <-ticker.C:
+		case t := <-a.windows:
+			ticker.Stop()
+			ticker = t
+		case <-ticker.C():
 			a.rotate()
+		case <-sweep.C():
+			a.expire(a.cfg.Clock.Now())
+		case done := <-a.flushes:
+			select {
+			case <-ticker.C():
+				a.rotate()
+			default:
+			}
+			select {
+			case <-sweep.C():
+				a.expire(a.cfg.Clock.Now())
+			default:
+			}
+			close(done)
 		}
 	}
 }
 
-func (a *Analyzer) analyze(req *Request) {
-	// Bloom filter deduplication
-	key := hashIPPath(req.IP, req.Path)
-	if a.bloom.TestAndAdd(u64ToBytes(key)) {
-		return
+// rotate reports at the end of a window and forgets the IPs blocked by
+// prefix in it.
+func (a *Analyzer) rotate() {
+	a.reportDropped()
+	a.reportEvicted()
+	a.prefixes.reset()
+	if a.cfg.OnWindow != nil {
+		a.cfg.OnWindow()
 	}
+}
 
-	// Counter increment
-	count := a.counter.Visit(req.IP)
+// greylist greylists ip for a window, unless it already is.
+func (a *Analyzer) greylist(ip string, score float64) {
+	if a.grey.Blocked(ip) {
+		return
+	}
+	window := a.Window()
+	a.grey.Block(ip, window)
+	a.logger.Info("botrate: ip greylisted", "ip", ip, "score", score, "duration", window)
+	if a.cfg.OnGreylist != nil {
+		a.cfg.OnGreylist(ip, score)
+	}
+}
 
-	// Threshold check
-	if int(count) >= a.cfg.PageThreshold {
-		a.block(req.IP)
+// penalty returns ip's offense count, zero without a penalty schedule,
+// and how long to block it for.
+func (a *Analyzer) penalty(ip string) (int, time.Duration) {
+	if a.penalties == nil {
+		return 0, a.thresholds.Load().BlockDuration
 	}
+	return a.penalties.next(ip, a.cfg.Clock.Now())
 }
 
-func (a *Analyzer) block(ip string) {
-	old := *a.blocklist.Load()
+func (a *Analyzer) block(ip string, d time.Duration) bool {
+	// Store errors are not fatal: the IP will trip the threshold again
+	// on its next distinct page.
+	if err := a.store.Block(ip, d); err != nil {
+		a.logger.Warn("botrate: failed to store block", "ip", ip, "error", err)
+		return false
+	}
+	return true
+}
 
-	if _, exists := old[ip]; exists {
-		return
+func (a *Analyzer) expire(now time.Time) {
+	// A failed sweep is retried on the next tick.
+	if err := a.store.Expire(now); err != nil {
+		a.logger.Warn("botrate: failed to expire blocks", "error", err)
 	}
+	if a.penalties != nil {
+		a.penalties.expire(now)
+	}
+	a.grey.Expire(now)
+	a.prefixes.expire(now)
+}
 
-	new := make(map[string]struct{}, len(old)+1)
-	for k := range old {
-		new[k] = struct{}{}
+func (a *Analyzer) reportDropped() {
+	dropped := a.dropped.Load()
+	if n := dropped - a.lastDropped; n > 0 {
+		a.logger.Warn("botrate: analyzer queue full, events dropped",
+			"dropped", n,
+			"queue_cap", a.cfg.QueueCap,
+		)
+		a.emit(Event{Type: EventDrop, Time: a.cfg.Clock.Now(), Dropped: n})
 	}
-	new[ip] = struct{}{}
+	a.lastDropped = dropped
+}
 
-	a.blocklist.Store(&new)
+// evicted returns the blocks the store evicted, if it evicts any.
+func (a *Analyzer) evicted() uint64 {
+	if s, ok := a.store.(interface{ Evicted() uint64 }); ok {
+		return s.Evicted()
+	}
+	return 0
 }
 
-func (a *Analyzer) rotate() {
-	a.bloom.Rotate()
-	a.counter.Clear()
+func (a *Analyzer) reportEvicted() {
+	evicted := a.evicted()
+	if n := evicted - a.lastEvicted; n > 0 {
+		a.logger.Warn("botrate: blocklist full, blocks evicted",
+			"evicted", n,
+			"max_blocklist", a.cfg.MaxBlocklist,
+		)
+	}
+	a.lastEvicted = evicted
 }
 
+// seed keys every hash, maphash values are only comparable under the
+// same seed.
+var seed = maphash.MakeSeed()
+
 func hashIPPath(ip string, pathHash uint64) uint64 {
 	var h maphash.Hash
+	h.SetSeed(seed)
 	h.WriteString(ip)
 	h.Write([]byte{
 		byte(pathHash), byte(pathHash >> 8), byte(pathHash >> 16), byte(pathHash >> 24),
@@ -151,14 +831,5 @@ func hashIPPath(ip string, pathHash uint64) uint64 {
 }
 
 func hashStr(s string) uint64 {
-	var h maphash.Hash
-	h.WriteString(s)
-	return h.Sum64()
-}
-
-func u64ToBytes(v uint64) []byte {
-	return []byte{
-		byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24),
-		byte(v >> 32), byte(v >> 40), byte(v >> 48), byte(v >> 56),
-	}
+	return maphash.String(seed, s)
 }
@@ -1,6 +1,10 @@
 package analyzer
 
 import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -18,19 +22,19 @@ func TestAnalyzer_New(t *testing.T) {
 		t.Fatal("New() returned nil")
 	}
 
-	if a.blocklist.Load() == nil {
-		t.Error("blocklist should be initialized")
+	if a.store == nil {
+		t.Error("blocklist store should be initialized")
 	}
 
-	if a.queue == nil {
+	if len(a.shards) != 1 || a.shards[0].queue == nil {
 		t.Error("queue should be initialized")
 	}
 
-	if a.bloom == nil {
+	if a.shards[0].bloom == nil {
 		t.Error("bloom filter should be initialized")
 	}
 
-	if a.counter == nil {
+	if a.shards[0].counter == nil {
 		t.Error("counter should be initialized")
 	}
 
@@ -58,6 +62,7 @@ func TestAnalyzer_Record(t *testing.T) {
 		Window:        time.Second * 10,
 		PageThreshold: 3, // Low threshold for testing
 		QueueCap:      1000,
+		Sync:          true,
 	}
 
 	a := New(cfg)
@@ -69,9 +74,6 @@ func TestAnalyzer_Record(t *testing.T) {
 	a.Record("192.168.1.1", "/page3")
 	a.Record("192.168.1.1", "/page4")
 
-	// Wait for worker to process
-	time.Sleep(time.Second * 2)
-
 	// IP should be blocked after exceeding threshold
 	if !a.Blocked("192.168.1.1") {
 		t.Error("IP should be blocked after exceeding threshold")
@@ -124,6 +126,37 @@ func TestAnalyzer_Record_DuplicatePaths(t *testing.T) {
 	}
 }
 
+func TestAnalyzer_Record_DuplicatePaths_LowThreshold(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 2,
+		QueueCap:      1000,
+	})
+	defer a.Close()
+
+	for i := 0; i < 10; i++ {
+		a.Record("192.168.1.1", "/same-page")
+	}
+
+	time.Sleep(time.Millisecond * 100)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("repeated visits to one page should count once")
+	}
+}
+
+func TestHash_Stable(t *testing.T) {
+	if hashStr("/a") != hashStr("/a") {
+		t.Error("hashStr should be stable within a process")
+	}
+	if hashIPPath("192.168.1.1", hashStr("/a")) != hashIPPath("192.168.1.1", hashStr("/a")) {
+		t.Error("hashIPPath should be stable within a process")
+	}
+	if hashIPPath("192.168.1.1", hashStr("/a")) == hashIPPath("192.168.1.1", hashStr("/b")) {
+		t.Error("different paths should hash differently")
+	}
+}
+
 func TestAnalyzer_Block(t *testing.T) {
 	cfg := Config{
 		Window:        time.Minute,
@@ -135,7 +168,7 @@ func TestAnalyzer_Block(t *testing.T) {
 	defer a.Close()
 
 	// Manually block an IP
-	a.block("192.168.1.1")
+	a.block("192.168.1.1", cfg.BlockDuration)
 
 	if !a.Blocked("192.168.1.1") {
 		t.Error("IP should be blocked")
@@ -153,8 +186,8 @@ func TestAnalyzer_Block_AlreadyBlocked(t *testing.T) {
 	defer a.Close()
 
 	// Block same IP twice
-	a.block("192.168.1.1")
-	a.block("192.168.1.1")
+	a.block("192.168.1.1", cfg.BlockDuration)
+	a.block("192.168.1.1", cfg.BlockDuration)
 
 	if !a.Blocked("192.168.1.1") {
 		t.Error("IP should still be blocked")
@@ -170,6 +203,7 @@ func TestAnalyzer_DifferentIPs(t *testing.T) {
 		Window:        time.Second * 10,
 		PageThreshold: 5,
 		QueueCap:      1000,
+		Sync:          true,
 	}
 
 	a := New(cfg)
@@ -182,8 +216,6 @@ func TestAnalyzer_DifferentIPs(t *testing.T) {
 		}
 	}
 
-	time.Sleep(time.Second * 2)
-
 	// All IPs should be blocked
 	for i := 0; i < 10; i++ {
 		ip := string(rune('A' + i))
@@ -227,7 +259,7 @@ func BenchmarkAnalyzer_Blocked(b *testing.B) {
 	defer a.Close()
 
 	// Block an IP
-	a.block("192.168.1.1")
+	a.block("192.168.1.1", cfg.BlockDuration)
 
 	b.ResetTimer()
 	b.ReportAllocs()
@@ -236,3 +268,201 @@ func BenchmarkAnalyzer_Blocked(b *testing.B) {
 		a.Blocked("192.168.1.1")
 	}
 }
+
+func TestAnalyzer_Unblock(t *testing.T) {
+	cfg := Config{
+		Window:        time.Second * 10,
+		PageThreshold: 3,
+		QueueCap:      1000,
+	}
+
+	a := New(cfg)
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/page1")
+	a.Record("192.168.1.1", "/page2")
+	a.Record("192.168.1.1", "/page3")
+
+	time.Sleep(time.Millisecond * 200)
+
+	if !a.Blocked("192.168.1.1") {
+		t.Fatal("IP should be blocked after exceeding threshold")
+	}
+
+	if err := a.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked")
+	}
+
+	// Page count is reset, one more page must not re-block
+	a.Record("192.168.1.1", "/page4")
+	time.Sleep(time.Millisecond * 200)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("unblocked IP should start from a fresh count")
+	}
+}
+
+func TestAnalyzer_Block_Manual(t *testing.T) {
+	cfg := Config{
+		Window:        time.Minute,
+		PageThreshold: 50,
+		QueueCap:      1000,
+	}
+
+	a := New(cfg)
+	defer a.Close()
+
+	if err := a.Block("192.168.1.1", time.Minute); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	if !a.Blocked("192.168.1.1") {
+		t.Error("IP should be blocked")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use by the worker.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestAnalyzer_Logger(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	cfg := Config{
+		Window:        time.Millisecond * 200,
+		PageThreshold: 2,
+		QueueCap:      1000,
+		Logger:        logger,
+	}
+
+	a := New(cfg)
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/page1")
+	a.Record("192.168.1.1", "/page2")
+
+	time.Sleep(time.Millisecond * 500)
+
+	out := buf.String()
+	if !strings.Contains(out, "ip blocked") || !strings.Contains(out, "ip=192.168.1.1") {
+		t.Errorf("expected block log, got %q", out)
+	}
+	if !strings.Contains(out, "window rotated") {
+		t.Errorf("expected rotation log, got %q", out)
+	}
+}
+
+func TestAnalyzer_Logger_Dropped(t *testing.T) {
+	var buf syncBuffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	cfg := Config{
+		Window:        time.Hour,
+		PageThreshold: 50,
+		QueueCap:      1,
+		Logger:        logger,
+	}
+
+	a := New(cfg)
+
+	// Stop the worker so the queue stays full
+	a.Close()
+	time.Sleep(time.Millisecond * 50)
+
+	a.shards[0].queue <- Request{}
+	a.Record("192.168.1.1", "/page1")
+
+	if a.dropped.Load() != 1 {
+		t.Fatalf("expected 1 dropped event, got %d", a.dropped.Load())
+	}
+
+	a.reportDropped()
+
+	if !strings.Contains(buf.String(), "events dropped") {
+		t.Errorf("expected drop log, got %q", buf.String())
+	}
+}
+
+func TestAnalyzer_Sync(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 2, QueueCap: 1, Workers: 2, Sync: true})
+	defer a.Close()
+
+	// Nothing is queued, so nothing is dropped even with a tiny queue
+	for _, p := range []string{"/1", "/2", "/3"} {
+		a.Record("192.168.1.1", p)
+	}
+	if !a.Blocked("192.168.1.1") {
+		t.Fatal("ip should be blocked when Record returns")
+	}
+	if s := a.Stats(); s.Dropped != 0 || s.QueueLen != 0 {
+		t.Errorf("sync mode should not queue, got %+v", s)
+	}
+
+	// Unblocking resets the counts right away too
+	if err := a.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	a.Record("192.168.1.1", "/4")
+	if a.Blocked("192.168.1.1") {
+		t.Error("unblocked ip should start counting from zero")
+	}
+}
+
+func TestAnalyzer_Stats(t *testing.T) {
+	cfg := Config{
+		Window:        time.Minute,
+		PageThreshold: 50,
+		QueueCap:      1000,
+	}
+
+	a := New(cfg)
+	defer a.Close()
+
+	a.block("192.168.1.1", cfg.BlockDuration)
+	a.block("192.168.1.2", cfg.BlockDuration)
+
+	s := a.Stats()
+	if s.Blocklist != 2 {
+		t.Errorf("expected blocklist size 2, got %d", s.Blocklist)
+	}
+	if s.QueueCap != 1000 {
+		t.Errorf("expected queue cap 1000, got %d", s.QueueCap)
+	}
+	if s.Dropped != 0 {
+		t.Errorf("expected no drops, got %d", s.Dropped)
+	}
+}
+
+func TestAnalyzer_Trap(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 100, QueueCap: 100})
+	defer a.Close()
+
+	if err := a.Trap("192.168.1.1", "/trap"); err != nil {
+		t.Fatalf("Trap() returned error: %v", err)
+	}
+	if !a.Blocked("192.168.1.1") {
+		t.Error("trapped IP should be blocked right away")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Honeypot != "/trap" || events[0].Manual {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
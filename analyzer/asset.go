@@ -0,0 +1,91 @@
+package analyzer
+
+import (
+	"mime"
+	"strings"
+)
+
+// DefaultAssets are the static assets ignored when Config.IgnoreAssets is
+// set with no entries of its own, see botrate.WithIgnoreAssets.
+var DefaultAssets = []string{
+	"image/", "font/", "audio/", "video/",
+	".css", ".js", ".mjs", ".map", ".ico", ".woff", ".woff2", ".webmanifest",
+}
+
+// assetFilter matches paths of static assets by extension (".js") or by
+// the content type the extension maps to ("image/").
+type assetFilter struct {
+	exts  map[string]struct{}
+	types []string
+}
+
+func newAssetFilter(assets []string) *assetFilter {
+	if len(assets) == 0 {
+		return nil
+	}
+
+	f := &assetFilter{exts: make(map[string]struct{})}
+	for _, a := range assets {
+		a = strings.ToLower(strings.TrimSpace(a))
+		if strings.HasPrefix(a, ".") {
+			f.exts[a] = struct{}{}
+		} else if a != "" {
+			f.types = append(f.types, a)
+		}
+	}
+	return f
+}
+
+// Match reports whether path is a static asset. A nil filter matches
+// nothing.
+func (f *assetFilter) Match(path string) bool {
+	if f == nil {
+		return false
+	}
+
+	ext := pathExt(path)
+	if ext == "" {
+		return false
+	}
+	if _, ok := f.exts[ext]; ok {
+		return true
+	}
+	if len(f.types) == 0 {
+		return false
+	}
+
+	typ := mime.TypeByExtension(ext)
+	for _, t := range f.types {
+		if strings.HasPrefix(typ, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathExt returns the extension of the last path segment, without query
+// or fragment, lower cased.
+func pathExt(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	for i := len(path) - 1; i >= 0 && path[i] != '/'; i-- {
+		if path[i] == '.' {
+			ext := path[i:]
+			if hasUpper(ext) {
+				ext = strings.ToLower(ext)
+			}
+			return ext
+		}
+	}
+	return ""
+}
+
+func hasUpper(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			return true
+		}
+	}
+	return false
+}
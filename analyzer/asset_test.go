@@ -0,0 +1,66 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssetFilter_Match(t *testing.T) {
+	f := newAssetFilter([]string{".js", "image/", " .CSS "})
+
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{"/app.js", true},
+		{"/app.JS", true},
+		{"/app.js?v=3", true},
+		{"/theme.css#x", true},
+		{"/img/logo.png", true},
+		{"/img/photo.JPG", true},
+		{"/index.html", false},
+		{"/products/42", false},
+		{"/v1.2/products", false},
+		{"/", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		if got := f.Match(tc.path); got != tc.want {
+			t.Errorf("Match(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestAssetFilter_Nil(t *testing.T) {
+	if f := newAssetFilter(nil); f.Match("/app.js") {
+		t.Error("no assets should match nothing")
+	}
+}
+
+func TestAnalyzer_IgnoreAssets(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 3,
+		QueueCap:      100,
+		IgnoreAssets:  DefaultAssets,
+	})
+	defer a.Close()
+
+	for _, p := range []string{"/", "/app.js", "/theme.css", "/logo.png", "/font.woff2", "/favicon.ico"} {
+		a.Record("192.168.1.1", p)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("assets should not count as distinct pages")
+	}
+
+	a.Record("192.168.1.1", "/about")
+	a.Record("192.168.1.1", "/contact")
+	time.Sleep(100 * time.Millisecond)
+
+	if !a.Blocked("192.168.1.1") {
+		t.Error("distinct pages should still be counted")
+	}
+}
@@ -1,9 +1,13 @@
 package analyzer
 
 import (
+	"math/bits"
+
 	"github.com/bits-and-blooms/bloom/v3"
 )
 
+// BloomMaxCapacity and BloomFalsePositiveRate size the bloom filters of
+// analyzers without Config.BloomCapacity and Config.BloomFalsePositiveRate.
 var BloomMaxCapacity uint = 100000
 
 var BloomFalsePositiveRate = 0.01
@@ -11,25 +15,78 @@ var BloomFalsePositiveRate = 0.01
 type DoubleBufferBloom struct {
 	current  *bloom.BloomFilter
 	previous *bloom.BloomFilter
+
+	capacity uint
+	fpRate   float64
 }
 
 func NewDoubleBufferBloom() *DoubleBufferBloom {
+	return NewDoubleBufferBloomWithEstimates(BloomMaxCapacity, BloomFalsePositiveRate)
+}
+
+// NewDoubleBufferBloomWithEstimates returns a DoubleBufferBloom whose
+// filters hold capacity keys at a false positive rate of fpRate.
+func NewDoubleBufferBloomWithEstimates(capacity uint, fpRate float64) *DoubleBufferBloom {
 	return &DoubleBufferBloom{
-		current:  bloom.NewWithEstimates(BloomMaxCapacity, BloomFalsePositiveRate),
-		previous: bloom.NewWithEstimates(BloomMaxCapacity, BloomFalsePositiveRate),
+		current:  bloom.NewWithEstimates(capacity, fpRate),
+		previous: bloom.NewWithEstimates(capacity, fpRate),
+		capacity: capacity,
+		fpRate:   fpRate,
 	}
 }
 
+// TestAndAdd reports whether key was seen in the current or previous
+// window, and adds it to the current one. Keys seen in the previous window
+// are carried over, so revisits across a rotation are not counted again.
 func (dbf *DoubleBufferBloom) TestAndAdd(key []byte) bool {
 	if dbf.current.Test(key) {
 		return true
 	}
 	dbf.current.Add(key)
-	return false
+	return dbf.previous.Test(key)
+}
+
+// TestAndAddUint64 is like TestAndAdd for a key that is a hash already,
+// e.g. of maphash: the bit positions are derived from it by double
+// hashing rather than by hashing its bytes. A key added with one of the
+// methods is unknown to the other.
+func (dbf *DoubleBufferBloom) TestAndAddUint64(key uint64) bool {
+	if addUint64(dbf.current, key) {
+		return true
+	}
+	return testUint64(dbf.previous, key)
+}
+
+// addUint64 sets the bits of key in f and reports whether they were all
+// set already.
+func addUint64(f *bloom.BloomFilter, key uint64) bool {
+	set, m := f.BitSet(), uint64(f.Cap())
+	h1, h2 := key, bits.RotateLeft64(key, 32)|1
+	present := true
+	for i := uint64(0); i < uint64(f.K()); i++ {
+		loc := uint((h1 + i*h2) % m)
+		if !set.Test(loc) {
+			present = false
+			set.Set(loc)
+		}
+	}
+	return present
+}
+
+// testUint64 reports whether the bits of key are all set in f.
+func testUint64(f *bloom.BloomFilter, key uint64) bool {
+	set, m := f.BitSet(), uint64(f.Cap())
+	h1, h2 := key, bits.RotateLeft64(key, 32)|1
+	for i := uint64(0); i < uint64(f.K()); i++ {
+		if !set.Test(uint((h1 + i*h2) % m)) {
+			return false
+		}
+	}
+	return true
 }
 
 func (dbf *DoubleBufferBloom) Rotate() {
-	newFilter := bloom.NewWithEstimates(BloomMaxCapacity, BloomFalsePositiveRate)
+	newFilter := bloom.NewWithEstimates(dbf.capacity, dbf.fpRate)
 	dbf.previous = dbf.current
 	dbf.current = newFilter
 }
@@ -1,7 +1,9 @@
 package analyzer
 
 import (
+	"strconv"
 	"testing"
+	"time"
 )
 
 func TestDoubleBufferBloom_New(t *testing.T) {
@@ -186,6 +188,48 @@ func TestDoubleBufferBloom_LongKey(t *testing.T) {
 	}
 }
 
+func TestDoubleBufferBloom_TestAndAddUint64(t *testing.T) {
+	bloom := NewDoubleBufferBloom()
+
+	for _, key := range []uint64{0, 1, hashStr("/a"), ^uint64(0)} {
+		if bloom.TestAndAddUint64(key) {
+			t.Errorf("first TestAndAddUint64 for %d should return false", key)
+		}
+		if !bloom.TestAndAddUint64(key) {
+			t.Errorf("second TestAndAddUint64 for %d should return true", key)
+		}
+	}
+
+	// Carried over a rotation, then forgotten after the next
+	bloom.Rotate()
+	if !bloom.TestAndAddUint64(1) {
+		t.Error("key from the previous window should return true")
+	}
+	bloom.Rotate()
+	bloom.Rotate()
+	if bloom.TestAndAddUint64(0) {
+		t.Error("key from two windows ago should return false")
+	}
+}
+
+func TestDoubleBufferBloom_TestAndAddUint64_FalsePositives(t *testing.T) {
+	bloom := NewDoubleBufferBloomWithEstimates(10000, 0.01)
+
+	for i := 0; i < 10000; i++ {
+		bloom.TestAndAddUint64(hashStr("key-" + strconv.Itoa(i)))
+	}
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if testUint64(bloom.current, hashStr("other-"+strconv.Itoa(i))) {
+			fp++
+		}
+	}
+	// 1% expected, with room for chance
+	if fp > 300 {
+		t.Errorf("expected about 100 false positives, got %d", fp)
+	}
+}
+
 func BenchmarkDoubleBufferBloom_TestAndAdd(b *testing.B) {
 	bloom := NewDoubleBufferBloom()
 	keys := make([][]byte, b.N)
@@ -218,6 +262,17 @@ func BenchmarkDoubleBufferBloom_TestAndAddExisting(b *testing.B) {
 	}
 }
 
+func BenchmarkDoubleBufferBloom_TestAndAddUint64(b *testing.B) {
+	bloom := NewDoubleBufferBloom()
+
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		bloom.TestAndAddUint64(uint64(i) * 0x9e3779b97f4a7c15)
+	}
+}
+
 func BenchmarkDoubleBufferBloom_Rotate(b *testing.B) {
 	bloom := NewDoubleBufferBloom()
 
@@ -233,3 +288,58 @@ func BenchmarkDoubleBufferBloom_Rotate(b *testing.B) {
 		bloom.Rotate()
 	}
 }
+
+func TestNewDoubleBufferBloomWithEstimates(t *testing.T) {
+	small := NewDoubleBufferBloomWithEstimates(1000, 0.01)
+	large := NewDoubleBufferBloomWithEstimates(1000000, 0.001)
+
+	if small.current.Cap() >= large.current.Cap() {
+		t.Errorf("larger estimates should size a larger filter, got %d >= %d", small.current.Cap(), large.current.Cap())
+	}
+
+	size := small.current.Cap()
+	small.Rotate()
+	if small.current.Cap() != size {
+		t.Errorf("rotation should keep the size, got %d, want %d", small.current.Cap(), size)
+	}
+}
+
+func TestAnalyzer_BloomConfig(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 50, QueueCap: 10, BloomCapacity: 1000, BloomFalsePositiveRate: 0.05})
+	defer a.Close()
+	if want := NewDoubleBufferBloomWithEstimates(1000, 0.05).current.Cap(); a.shards[0].bloom.current.Cap() != want {
+		t.Errorf("expected a filter of %d bits, got %d", want, a.shards[0].bloom.current.Cap())
+	}
+
+	d := New(Config{Window: time.Hour, PageThreshold: 50, QueueCap: 10})
+	defer d.Close()
+	if d.cfg.BloomCapacity != BloomMaxCapacity || d.cfg.BloomFalsePositiveRate != BloomFalsePositiveRate {
+		t.Errorf("expected the package defaults, got %d %v", d.cfg.BloomCapacity, d.cfg.BloomFalsePositiveRate)
+	}
+}
+
+func TestDoubleBufferBloom_RotateKeepsPrevious(t *testing.T) {
+	bloom := NewDoubleBufferBloom()
+	bloom.TestAndAdd([]byte("key-1"))
+
+	bloom.Rotate()
+	if !bloom.TestAndAdd([]byte("key-1")) {
+		t.Error("key from the previous window should be seen")
+	}
+	if bloom.TestAndAdd([]byte("key-2")) {
+		t.Error("new key should not be seen")
+	}
+
+	// Carried over into the current window, so it survives another rotation
+	bloom.Rotate()
+	if !bloom.TestAndAdd([]byte("key-1")) {
+		t.Error("key revisited in the previous window should be seen")
+	}
+
+	// Forgotten after two windows without a visit
+	bloom.Rotate()
+	bloom.Rotate()
+	if bloom.TestAndAdd([]byte("key-2")) {
+		t.Error("key unseen for two windows should be forgotten")
+	}
+}
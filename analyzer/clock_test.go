@@ -0,0 +1,178 @@
+package analyzer
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewManualClock(start)
+
+	tk := c.NewTicker(time.Minute)
+	c.Advance(30 * time.Second)
+	select {
+	case <-tk.C():
+		t.Fatal("ticker should not tick before its interval")
+	default:
+	}
+
+	// Ticks the reader is not ready for are dropped
+	c.Advance(5 * time.Minute)
+	if got := <-tk.C(); !got.Equal(start.Add(5*time.Minute + 30*time.Second)) {
+		t.Errorf("expected a tick at the new time, got %v", got.Sub(start))
+	}
+	select {
+	case <-tk.C():
+		t.Error("missed ticks should be dropped")
+	default:
+	}
+
+	tk.Stop()
+	c.Advance(time.Hour)
+	select {
+	case <-tk.C():
+		t.Error("stopped ticker should not tick")
+	default:
+	}
+}
+
+func TestAnalyzer_ManualClock(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	a := New(Config{
+		Window:        time.Minute,
+		PageThreshold: 3,
+		QueueCap:      100,
+		BlockDuration: 10 * time.Minute,
+		Clock:         clock,
+	})
+	defer a.Close()
+	ctx := context.Background()
+
+	// Two pages per window never add up to three
+	for i := 0; i < 3; i++ {
+		a.Record("192.168.1.1", "/a")
+		a.Record("192.168.1.1", "/b")
+		a.Flush(ctx)
+		clock.Advance(time.Minute)
+		a.Flush(ctx)
+	}
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("counts should reset every window")
+	}
+
+	a.Record("192.168.1.1", "/c")
+	a.Record("192.168.1.1", "/d")
+	a.Record("192.168.1.1", "/e")
+	a.Flush(ctx)
+	if !a.Blocked("192.168.1.1") {
+		t.Fatal("three pages in a window should block")
+	}
+	var until time.Time
+	a.Range(func(ip string, u time.Time) bool {
+		until = u
+		return false
+	})
+	if want := clock.Now().Add(10 * time.Minute); !until.Equal(want) {
+		t.Errorf("block should end at %v, got %v", want, until)
+	}
+
+	// The sweep runs on the clock too
+	clock.Advance(10 * time.Minute)
+	a.Flush(ctx)
+	if a.Blocked("192.168.1.1") {
+		t.Error("block should expire after BlockDuration on the clock")
+	}
+}
+
+func TestAnalyzer_SetWindow(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	a := New(Config{
+		Window:        time.Minute,
+		PageThreshold: 3,
+		QueueCap:      100,
+		Clock:         clock,
+	})
+	defer a.Close()
+	ctx := context.Background()
+
+	a.Record("192.168.1.1", "/a")
+	a.Record("192.168.1.1", "/b")
+	a.Flush(ctx)
+	a.SetWindow(10 * time.Minute)
+	if got := a.Window(); got != 10*time.Minute {
+		t.Errorf("expected a 10m window, got %s", got)
+	}
+
+	// The counts are kept past the old window's end
+	clock.Advance(time.Minute)
+	a.Flush(ctx)
+	a.Record("192.168.1.1", "/c")
+	a.Flush(ctx)
+	if !a.Blocked("192.168.1.1") {
+		t.Fatal("counts should be kept in the longer window")
+	}
+
+	a.Record("192.168.1.2", "/a")
+	a.Record("192.168.1.2", "/b")
+	a.Flush(ctx)
+	clock.Advance(9 * time.Minute)
+	a.Flush(ctx)
+	a.Record("192.168.1.2", "/c")
+	a.Flush(ctx)
+	if a.Blocked("192.168.1.2") {
+		t.Error("counts should reset at the end of the new window")
+	}
+}
+
+func TestAnalyzer_SetWindow_Prefixes(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	a := New(Config{
+		Window:          time.Minute,
+		PageThreshold:   3,
+		QueueCap:        100,
+		Clock:           clock,
+		PrefixThreshold: 2,
+	})
+	defer a.Close()
+	ctx := context.Background()
+
+	a.SetWindow(10 * time.Minute)
+	a.Trap("192.0.2.1", "/trap")
+	clock.Advance(time.Minute)
+	a.Flush(ctx)
+	a.Trap("192.0.2.2", "/trap")
+	if _, ok := a.BlockedPrefix(netip.MustParseAddr("192.0.2.9")); !ok {
+		t.Fatal("IPs blocked in the longer window should count for the prefix")
+	}
+
+	a.Trap("198.51.100.1", "/trap")
+	clock.Advance(9 * time.Minute)
+	a.Flush(ctx)
+	a.Trap("198.51.100.2", "/trap")
+	if _, ok := a.BlockedPrefix(netip.MustParseAddr("198.51.100.9")); ok {
+		t.Error("IPs blocked in an earlier window should not count")
+	}
+}
+
+func TestAnalyzer_SetThresholds(t *testing.T) {
+	a := New(Config{Window: time.Minute, PageThreshold: 10, QueueCap: 100, Sync: true})
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/a")
+	a.Record("192.168.1.1", "/a")
+	a.SetThresholds(Thresholds{PageThreshold: 10, RequestThreshold: 2})
+	if got := a.Thresholds(); got.ScoreThreshold != DefaultScoreThreshold {
+		t.Errorf("expected the default score threshold, got %g", got.ScoreThreshold)
+	}
+	a.Record("192.168.1.1", "/a")
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("requests before the threshold was set should not count")
+	}
+	a.Record("192.168.1.1", "/a")
+	if !a.Blocked("192.168.1.1") {
+		t.Error("the request threshold set should block")
+	}
+}
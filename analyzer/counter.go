@@ -2,6 +2,7 @@ package analyzer
 
 import (
 	"container/list"
+	"math"
 )
 
 type Counter struct {
@@ -9,6 +10,9 @@ type Counter struct {
 	data    map[string]uint16
 	lru     *list.List
 	index   map[string]*list.Element
+
+	// Counts of the previous window, see Rotate
+	prev map[string]uint16
 }
 
 func NewCounter() *Counter {
@@ -21,8 +25,15 @@ func NewCounter() *Counter {
 }
 
 func (c *Counter) Visit(ip string) uint16 {
+	return c.Add(ip, 1)
+}
+
+// Add counts n visits of ip, e.g. a request weighted by its method.
+func (c *Counter) Add(ip string, n uint16) uint16 {
 	if elem, exists := c.index[ip]; exists {
-		count := c.data[ip] + 1
+		count := c.data[ip]
+		// Saturate instead of wrapping around to zero
+		count += min(n, math.MaxUint16-count)
 		c.data[ip] = count
 		c.lru.MoveToFront(elem)
 		return count
@@ -38,17 +49,56 @@ func (c *Counter) Visit(ip string) uint16 {
 	}
 
 	elem := c.lru.PushFront(ip)
-	c.data[ip] = 1
+	c.data[ip] = n
 	c.index[ip] = elem
-	return 1
+	return n
+}
+
+// Set sets ip's count to n, e.g. when restoring a snapshot.
+func (c *Counter) Set(ip string, n uint16) {
+	if elem, exists := c.index[ip]; exists {
+		c.data[ip] = n
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.Visit(ip)
+	c.data[ip] = n
 }
 
 func (c *Counter) Count(ip string) uint16 {
 	return c.data[ip]
 }
 
+// Weighted returns ip's count plus its count in the previous window
+// weighted by w, for a sliding window.
+func (c *Counter) Weighted(ip string, w float64) float64 {
+	n := float64(c.data[ip])
+	if w > 0 {
+		n += float64(c.prev[ip]) * w
+	}
+	return n
+}
+
+func (c *Counter) Delete(ip string) {
+	if elem, exists := c.index[ip]; exists {
+		c.lru.Remove(elem)
+		delete(c.index, ip)
+		delete(c.data, ip)
+	}
+	delete(c.prev, ip)
+}
+
 func (c *Counter) Clear() {
 	c.data = make(map[string]uint16)
 	c.lru = list.New()
 	c.index = make(map[string]*list.Element)
+	c.prev = nil
+}
+
+// Rotate starts a new window, keeping the counts as the previous
+// window's, see Weighted.
+func (c *Counter) Rotate() {
+	prev := c.data
+	c.Clear()
+	c.prev = prev
 }
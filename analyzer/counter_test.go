@@ -1,6 +1,7 @@
 package analyzer
 
 import (
+	"math"
 	"testing"
 )
 
@@ -26,6 +27,22 @@ func TestCounter_Visit_NewIP(t *testing.T) {
 	}
 }
 
+func TestCounter_Add(t *testing.T) {
+	c := NewCounter()
+
+	if n := c.Add("192.168.1.1", 5); n != 5 {
+		t.Errorf("expected 5, got %d", n)
+	}
+	if n := c.Visit("192.168.1.1"); n != 6 {
+		t.Errorf("expected 6, got %d", n)
+	}
+
+	// Saturates instead of wrapping around
+	if n := c.Add("192.168.1.1", math.MaxUint16); n != math.MaxUint16 {
+		t.Errorf("expected %d, got %d", math.MaxUint16, n)
+	}
+}
+
 func TestCounter_Visit_DifferentIPs(t *testing.T) {
 	c := NewCounter()
 
@@ -85,6 +102,29 @@ func TestCounter_Clear(t *testing.T) {
 	}
 }
 
+func TestCounter_Delete(t *testing.T) {
+	c := NewCounter()
+
+	c.Visit("192.168.1.1")
+	c.Visit("192.168.1.1")
+	c.Visit("192.168.1.2")
+
+	c.Delete("192.168.1.1")
+
+	if count := c.Count("192.168.1.1"); count != 0 {
+		t.Errorf("after delete, expected 0, got %d", count)
+	}
+	if count := c.Count("192.168.1.2"); count != 1 {
+		t.Errorf("other IPs should be kept, expected 1, got %d", count)
+	}
+	if c.lru.Len() != 1 || len(c.index) != 1 {
+		t.Errorf("LRU should drop the deleted IP, got len %d", c.lru.Len())
+	}
+
+	// Deleting an unknown IP is a no-op
+	c.Delete("10.0.0.1")
+}
+
 func TestCounter_LRUEviction(t *testing.T) {
 	c := NewCounter()
 	c.maxSize = 5
@@ -226,3 +266,37 @@ func BenchmarkCounter_Clear(b *testing.B) {
 		c.Clear()
 	}
 }
+
+func TestCounter_Saturates(t *testing.T) {
+	c := NewCounter()
+	c.Visit("A")
+	c.data["A"] = math.MaxUint16
+
+	if count := c.Visit("A"); count != math.MaxUint16 {
+		t.Errorf("expected count to saturate at %d, got %d", math.MaxUint16, count)
+	}
+}
+
+func TestCounter_Rotate(t *testing.T) {
+	c := NewCounter()
+	c.Visit("192.168.1.1")
+	c.Visit("192.168.1.1")
+
+	c.Rotate()
+	if c.Count("192.168.1.1") != 0 {
+		t.Error("rotated counter should start a new window")
+	}
+	c.Visit("192.168.1.1")
+
+	if n := c.Weighted("192.168.1.1", 0.5); n != 2 {
+		t.Errorf("expected 1 + 2*0.5, got %v", n)
+	}
+	if n := c.Weighted("192.168.1.1", 0); n != 1 {
+		t.Errorf("expected the current count only, got %v", n)
+	}
+
+	c.Delete("192.168.1.1")
+	if n := c.Weighted("192.168.1.1", 1); n != 0 {
+		t.Errorf("deleted IP should have no counts, got %v", n)
+	}
+}
@@ -0,0 +1,101 @@
+package analyzer
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types, see Event.Type.
+const (
+	EventBlock   = "block"
+	EventUnblock = "unblock"
+	EventDrop    = "drop" // events dropped because the queue was full
+
+	// EventVerificationFailed is a bot failing verification, delivered
+	// by botrate.Limiter.Subscribe rather than the analyzer.
+	EventVerificationFailed = "verification_failed"
+)
+
+// Event describes a block, or with Config.OnEvent an unblock or drop.
+type Event struct {
+	Type       string        `json:"type"`
+	Time       time.Time     `json:"time"`
+	IP         string        `json:"ip,omitempty"`
+	Pages      int           `json:"pages,omitempty"`      // distinct pages that tripped the threshold
+	Requests   int           `json:"requests,omitempty"`   // requests in the window, with a request threshold
+	Errors     int           `json:"errors,omitempty"`     // error responses in the window, with an error threshold
+	Logins     int           `json:"logins,omitempty"`     // failed logins in the window, with a login threshold
+	Mismatches int           `json:"mismatches,omitempty"` // requests with headers not matching their UA, with a header threshold
+	Sequential int           `json:"sequential,omitempty"` // sequential IDs in the window, with a sequence threshold
+	Queries    int           `json:"queries,omitempty"`    // most query strings of a page in the window, with a query threshold
+	Methods    int           `json:"methods,omitempty"`    // weighted requests of methods with their own threshold in the window
+	Score      float64       `json:"score,omitempty"`      // summed signal score that tripped the threshold
+	Manual     bool          `json:"manual,omitempty"`
+	Honeypot   string        `json:"honeypot,omitempty"` // trap path requested, see Analyzer.Trap
+	IPs        int           `json:"ips,omitempty"`      // IPs blocked in the window, for prefix blocks
+	Offenses   int           `json:"offenses,omitempty"` // blocks in a row, with a penalty schedule
+	Duration   time.Duration `json:"duration"`
+	Dropped    uint64        `json:"dropped,omitempty"` // events dropped since the last drop event
+	Bot        string        `json:"bot,omitempty"`     // bot claimed, for verification failures
+	UA         string        `json:"ua,omitempty"`      // user agent claiming it
+}
+
+// Reason returns why a block was made: "manual", "honeypot", "prefix"
+// for the prefix of IPs blocked, or "score" for behavior analysis.
+func (e Event) Reason() string {
+	switch {
+	case e.Manual:
+		return "manual"
+	case e.Honeypot != "":
+		return "honeypot"
+	case e.IPs > 0:
+		return "prefix"
+	default:
+		return "score"
+	}
+}
+
+// ring keeps the most recent events.
+type ring struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+func newRing(size int) *ring {
+	return &ring{events: make([]Event, size)}
+}
+
+func (r *ring) add(e Event) {
+	if len(r.events) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events[r.next] = e
+	r.next = (r.next + 1) % len(r.events)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the events, newest first.
+func (r *ring) list() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.events)
+	}
+
+	out := make([]Event, 0, n)
+	for i := 0; i < n; i++ {
+		idx := (r.next - 1 - i + len(r.events)) % len(r.events)
+		out = append(out, r.events[idx])
+	}
+	return out
+}
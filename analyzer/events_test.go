@@ -0,0 +1,112 @@
+package analyzer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRing_List(t *testing.T) {
+	r := newRing(3)
+
+	if got := r.list(); len(got) != 0 {
+		t.Errorf("new ring should be empty, got %d", len(got))
+	}
+
+	r.add(Event{IP: "1"})
+	r.add(Event{IP: "2"})
+
+	got := r.list()
+	if len(got) != 2 || got[0].IP != "2" || got[1].IP != "1" {
+		t.Errorf("expected newest first, got %+v", got)
+	}
+}
+
+func TestRing_Wrap(t *testing.T) {
+	r := newRing(3)
+
+	for i := 1; i <= 5; i++ {
+		r.add(Event{IP: strconv.Itoa(i)})
+	}
+
+	got := r.list()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(got))
+	}
+	for i, want := range []string{"5", "4", "3"} {
+		if got[i].IP != want {
+			t.Errorf("event %d: expected %s, got %s", i, want, got[i].IP)
+		}
+	}
+}
+
+func TestRing_Zero(t *testing.T) {
+	r := newRing(0)
+	r.add(Event{IP: "1"})
+
+	if got := r.list(); len(got) != 0 {
+		t.Errorf("zero-sized ring should keep nothing, got %d", len(got))
+	}
+}
+
+func TestEvent_Reason(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{Event{Manual: true}, "manual"},
+		{Event{Honeypot: "/wp-login.php"}, "honeypot"},
+		{Event{Pages: 10, Score: 1}, "score"},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Reason(); got != tt.want {
+			t.Errorf("Reason() of %+v = %q, want %q", tt.e, got, tt.want)
+		}
+	}
+}
+
+func TestAnalyzer_OnEvent(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []Event
+	)
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 2,
+		QueueCap:      100,
+		Sync:          true,
+		OnEvent: func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	})
+	a.Close()
+
+	a.Record("192.168.1.1", "/a")
+	a.Record("192.168.1.1", "/b")
+	a.Unblock("192.168.1.1")
+	a.dropped.Add(3)
+	a.reportDropped()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %+v", events)
+	}
+	if e := events[0]; e.Type != EventBlock || e.IP != "192.168.1.1" || e.Pages != 2 {
+		t.Errorf("unexpected block event %+v", e)
+	}
+	if e := events[1]; e.Type != EventUnblock || e.IP != "192.168.1.1" {
+		t.Errorf("unexpected unblock event %+v", e)
+	}
+	if e := events[2]; e.Type != EventDrop || e.Dropped != 3 {
+		t.Errorf("unexpected drop event %+v", e)
+	}
+
+	// Only blocks are kept for Events
+	if got := a.Events(); len(got) != 1 || got[0].Type != EventBlock {
+		t.Errorf("expected the block event only, got %+v", got)
+	}
+}
@@ -0,0 +1,153 @@
+package analyzer
+
+import (
+	"math"
+	"math/bits"
+)
+
+// hllPrecision is the number of hash bits that pick a register: 256
+// registers, 256 bytes per IP, for a standard error of about 6.5%.
+const hllPrecision = 8
+
+const hllRegisters = 1 << hllPrecision
+
+// HLL is a HyperLogLog sketch estimating the number of distinct hashes
+// added to it.
+type HLL struct {
+	reg [hllRegisters]uint8
+}
+
+// Add adds hash and reports whether the sketch changed, i.e. hash is
+// likely new.
+func (h *HLL) Add(hash uint64) bool {
+	idx := hash >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank <= h.reg[idx] {
+		return false
+	}
+	h.reg[idx] = rank
+	return true
+}
+
+// Estimate returns the estimated number of distinct hashes added.
+func (h *HLL) Estimate() float64 {
+	var sum float64
+	zeros := 0
+	for _, r := range h.reg {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	est := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if est <= 2.5*m && zeros > 0 {
+		// Linear counting is more accurate for small cardinalities,
+		// which is where page thresholds are
+		est = m * math.Log(m/float64(zeros))
+	}
+	return est
+}
+
+// hllSet keeps a sketch of visited path hashes per IP, bounded to maxSize
+// IPs.
+type hllSet struct {
+	maxSize  int
+	sketches map[string]*HLL
+
+	// Sketches of the previous window, see Rotate
+	prev map[string]*HLL
+}
+
+func newHLLSet() *hllSet {
+	return &hllSet{
+		maxSize:  100000,
+		sketches: make(map[string]*HLL),
+	}
+}
+
+// Add adds path to ip's sketch and reports whether it is likely a
+// distinct page.
+func (s *hllSet) Add(ip string, path uint64) bool {
+	h, ok := s.sketches[ip]
+	if !ok {
+		if len(s.sketches) >= s.maxSize {
+			// Evict an arbitrary IP, like the LRU counter evicts the
+			// oldest one
+			for k := range s.sketches {
+				delete(s.sketches, k)
+				break
+			}
+		}
+		h = &HLL{}
+		s.sketches[ip] = h
+	}
+	return h.Add(path)
+}
+
+// Count returns the estimated distinct pages of ip.
+func (s *hllSet) Count(ip string) uint16 {
+	return uint16(min(math.Round(s.Weighted(ip, 0)), math.MaxUint16))
+}
+
+// Weighted returns the estimated distinct pages of ip plus those of the
+// previous window weighted by w, for a sliding window.
+func (s *hllSet) Weighted(ip string, w float64) float64 {
+	var n float64
+	if h, ok := s.sketches[ip]; ok {
+		n = h.Estimate()
+	}
+	if h, ok := s.prev[ip]; ok && w > 0 {
+		n += h.Estimate() * w
+	}
+	return n
+}
+
+func (s *hllSet) Delete(ip string) {
+	delete(s.sketches, ip)
+	delete(s.prev, ip)
+}
+
+func (s *hllSet) Clear() {
+	s.sketches = make(map[string]*HLL)
+	s.prev = nil
+}
+
+// Rotate starts a new window, keeping the sketches as the previous
+// window's, see Weighted.
+func (s *hllSet) Rotate() {
+	prev := s.sketches
+	s.Clear()
+	s.prev = prev
+}
+
+// hllPagesSignal scores the estimated distinct pages per window against
+// the page threshold. It replaces pagesSignal with Config.HyperLogLog;
+// the analyzer adds paths to the sketches.
+type hllPagesSignal struct {
+	set *hllSet
+}
+
+func (s *hllPagesSignal) Name() string {
+	return "pages"
+}
+
+func (s *hllPagesSignal) Observe(o Observation) float64 {
+	if o.PageThreshold <= 0 {
+		return 0
+	}
+	return s.set.Weighted(o.IP, o.Previous) / float64(o.PageThreshold)
+}
+
+func (s *hllPagesSignal) Forget(ip string) {
+	s.set.Delete(ip)
+}
+
+func (s *hllPagesSignal) Reset() {
+	s.set.Clear()
+}
+
+func (s *hllPagesSignal) Rotate() {
+	s.set.Rotate()
+}
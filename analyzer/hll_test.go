@@ -0,0 +1,89 @@
+package analyzer
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestHLL_Estimate(t *testing.T) {
+	for _, n := range []int{10, 50, 200, 1000, 10000} {
+		var h HLL
+		for i := 0; i < n; i++ {
+			h.Add(hashStr("/page/" + strconv.Itoa(i)))
+		}
+		// Duplicates do not count
+		for i := 0; i < n; i++ {
+			if h.Add(hashStr("/page/"+strconv.Itoa(i))) && n <= 50 {
+				t.Errorf("n=%d: duplicate %d changed the sketch", n, i)
+			}
+		}
+
+		est := h.Estimate()
+		if err := math.Abs(est-float64(n)) / float64(n); err > 0.2 {
+			t.Errorf("n=%d: estimate %.0f is off by %.0f%%", n, est, err*100)
+		}
+	}
+
+	var h HLL
+	if h.Estimate() != 0 {
+		t.Errorf("empty sketch should estimate 0, got %v", h.Estimate())
+	}
+}
+
+func TestHLLSet(t *testing.T) {
+	s := newHLLSet()
+	s.maxSize = 2
+
+	s.Add("192.168.1.1", hashStr("/a"))
+	s.Add("192.168.1.1", hashStr("/b"))
+	if c := s.Count("192.168.1.1"); c != 2 {
+		t.Errorf("expected 2 pages, got %d", c)
+	}
+
+	s.Add("192.168.1.2", hashStr("/a"))
+	s.Add("192.168.1.3", hashStr("/a"))
+	if len(s.sketches) != 2 {
+		t.Errorf("set should be bounded to 2 IPs, got %d", len(s.sketches))
+	}
+
+	s.Delete("192.168.1.3")
+	if s.Count("192.168.1.3") != 0 {
+		t.Error("deleted IP should have no pages")
+	}
+	s.Clear()
+	if len(s.sketches) != 0 {
+		t.Error("cleared set should be empty")
+	}
+}
+
+func TestAnalyzer_HyperLogLog(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 20,
+		QueueCap:      1000,
+		HyperLogLog:   true,
+	})
+	defer a.Close()
+
+	// Revisits do not count
+	for i := 0; i < 100; i++ {
+		a.Record("192.168.1.1", "/same")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("revisiting one page should not block")
+	}
+
+	for i := 0; i < 30; i++ {
+		a.Record("192.168.1.1", "/page/"+strconv.Itoa(i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !a.Blocked("192.168.1.1") {
+		t.Error("distinct pages beyond the threshold should block")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Pages < 18 || events[0].Pages > 22 {
+		t.Errorf("expected about 20 pages, got %+v", events)
+	}
+}
@@ -0,0 +1,52 @@
+package analyzer
+
+import (
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// HostKey returns the key of ip's requests to the virtual host host, so
+// a client is analyzed and blocked per host, and heavy but legitimate
+// use of one site sharing a server doesn't block it on the others: the
+// HostName of host, a slash and ip. An empty host keys on ip alone.
+func HostKey(host, ip string) string {
+	host = HostName(host)
+	if host == "" {
+		return ip
+	}
+	return host + "/" + ip
+}
+
+// HostName returns host, e.g. a Host header, lowercased and without its
+// port and trailing dot.
+func HostName(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// UAKey returns the key of ip's requests with the User-Agent ua, so the
+// users of a large NAT, such as a mobile carrier or a corporate proxy,
+// are analyzed and blocked apart unless they share a browser build: ip,
+// a '#' and the 64-bit FNV-1a hash of ua in hex. An empty ua keys on ip
+// alone.
+func UAKey(ip, ua string) string {
+	if ua == "" {
+		return ip
+	}
+	h := fnv.New64a()
+	h.Write([]byte(ua))
+	buf := make([]byte, 0, 64)
+	buf = append(buf, ip...)
+	buf = append(buf, '#')
+	buf = strconv.AppendUint(buf, h.Sum64(), 16)
+	return string(buf)
+}
+
+// RecordHost records a request by ip to path on host, under HostKey.
+func (a *Analyzer) RecordHost(host, ip, path string) {
+	a.Record(HostKey(host, ip), path)
+}
@@ -0,0 +1,73 @@
+package analyzer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHostKey(t *testing.T) {
+	testCases := []struct{ host, ip, want string }{
+		{"example.com", "1.2.3.4", "example.com/1.2.3.4"},
+		{"Example.COM:8080", "1.2.3.4", "example.com/1.2.3.4"},
+		{"example.com.", "1.2.3.4", "example.com/1.2.3.4"},
+		{"[::1]:443", "2001:db8::1", "::1/2001:db8::1"},
+		{"", "1.2.3.4", "1.2.3.4"},
+	}
+
+	for _, tc := range testCases {
+		if got := HostKey(tc.host, tc.ip); got != tc.want {
+			t.Errorf("HostKey(%q, %q) = %q, want %q", tc.host, tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestHostName(t *testing.T) {
+	testCases := []struct{ host, want string }{
+		{"example.com", "example.com"},
+		{"Example.COM:8080", "example.com"},
+		{"example.com.", "example.com"},
+		{"[::1]:443", "::1"},
+		{"", ""},
+	}
+
+	for _, tc := range testCases {
+		if got := HostName(tc.host); got != tc.want {
+			t.Errorf("HostName(%q) = %q, want %q", tc.host, got, tc.want)
+		}
+	}
+}
+
+func TestUAKey(t *testing.T) {
+	key := UAKey("1.2.3.4", "Mozilla/5.0")
+	if !strings.HasPrefix(key, "1.2.3.4#") || len(key) <= len("1.2.3.4#") {
+		t.Errorf("unexpected key %q", key)
+	}
+	if UAKey("1.2.3.4", "Mozilla/5.0") != key {
+		t.Error("keys of the same IP and UA should be equal")
+	}
+	if UAKey("1.2.3.4", "curl/8.0") == key || UAKey("1.2.3.5", "Mozilla/5.0") == key {
+		t.Error("keys of other IPs or UAs should differ")
+	}
+	if got := UAKey("1.2.3.4", ""); got != "1.2.3.4" {
+		t.Errorf("expected the IP for an empty UA, got %q", got)
+	}
+}
+
+func TestAnalyzer_RecordHost(t *testing.T) {
+	a := New(Config{Window: time.Minute, PageThreshold: 3, QueueCap: 100, Sync: true})
+	defer a.Close()
+
+	for _, path := range []string{"/a", "/b"} {
+		a.RecordHost("a.example.com", "1.2.3.4", path)
+		a.RecordHost("b.example.com", "1.2.3.4", path)
+	}
+	a.RecordHost("a.example.com", "1.2.3.4", "/c")
+
+	if !a.Blocked(HostKey("a.example.com", "1.2.3.4")) {
+		t.Error("IP should be blocked on the host it crawled")
+	}
+	if a.Blocked(HostKey("b.example.com", "1.2.3.4")) || a.Blocked("1.2.3.4") {
+		t.Error("IP should not be blocked on other hosts")
+	}
+}
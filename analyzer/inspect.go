@@ -0,0 +1,88 @@
+package analyzer
+
+import (
+	"net/netip"
+	"time"
+)
+
+// State is an IP's analyzer state, see Analyzer.Inspect.
+type State struct {
+	Blocked bool `json:"blocked"`
+
+	// Until is when the block ends, zero never or when not blocked.
+	Until time.Time `json:"until"`
+
+	Greylisted bool `json:"greylisted,omitempty"`
+
+	// Prefix is the blocked prefix containing the IP, see
+	// Config.PrefixThreshold.
+	Prefix string `json:"prefix,omitempty"`
+
+	// Pages, Requests, Errors, Logins, Mismatches, Sequential, Queries
+	// and Methods are the counts in the current window, the previous
+	// window's weighted in with SlidingWindow, as the signals see them.
+	// Queries is the most query strings of one of the IP's pages, and
+	// Methods the weighted requests with a method threshold.
+	Pages      int `json:"pages"`
+	Requests   int `json:"requests,omitempty"`
+	Errors     int `json:"errors,omitempty"`
+	Logins     int `json:"logins,omitempty"`
+	Mismatches int `json:"mismatches,omitempty"`
+	Sequential int `json:"sequential,omitempty"`
+	Queries    int `json:"queries,omitempty"`
+	Methods    int `json:"methods,omitempty"`
+
+	// Offenses is the offense count, with a penalty schedule.
+	Offenses int `json:"offenses,omitempty"`
+
+	// Events are the IP's recent block events, newest first.
+	Events []Event `json:"events,omitempty"`
+}
+
+// Inspect returns ip's state, e.g. to tell why it is blocked. It walks
+// the blocklist and the recent events, so it is not meant for the
+// request path.
+func (a *Analyzer) Inspect(ip string) State {
+	st := State{
+		Blocked:    a.store.Blocked(ip),
+		Greylisted: a.grey.Blocked(ip),
+	}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		st.Prefix, _ = a.BlockedPrefix(addr)
+	}
+	if st.Blocked {
+		a.store.Range(func(blocked string, until time.Time) bool {
+			if blocked != ip {
+				return true
+			}
+			st.Until = until
+			return false
+		})
+	}
+
+	s := a.shard(ip)
+	s.mu.Lock()
+	var w float64
+	if a.cfg.SlidingWindow {
+		w = s.previous(a.cfg.Clock.Now())
+	}
+	st.Pages = s.pages(ip, w)
+	st.Requests = count(s.requests, ip, w)
+	st.Errors = count(s.errors, ip, w)
+	st.Logins = count(s.logins, ip, w)
+	st.Mismatches = count(s.headers, ip, w)
+	st.Sequential = count(s.steps, ip, w)
+	st.Queries = count(s.queries, ip, w)
+	st.Methods = count(s.methods, ip, w)
+	s.mu.Unlock()
+
+	if a.penalties != nil {
+		st.Offenses = a.penalties.count(ip, a.cfg.Clock.Now())
+	}
+	for _, e := range a.events.list() {
+		if e.IP == ip {
+			st.Events = append(st.Events, e)
+		}
+	}
+	return st
+}
@@ -0,0 +1,45 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalyzer_Inspect(t *testing.T) {
+	a := New(Config{
+		Window:           time.Hour,
+		PageThreshold:    3,
+		RequestThreshold: 100,
+		QueueCap:         100,
+		PenaltySchedule:  []time.Duration{time.Minute, time.Hour},
+		Sync:             true,
+	})
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/a")
+	a.Record("192.168.1.1", "/b")
+	a.Record("192.168.1.1", "/b")
+
+	st := a.Inspect("192.168.1.1")
+	if st.Blocked || st.Pages != 2 || st.Requests != 3 || st.Offenses != 0 || len(st.Events) != 0 {
+		t.Errorf("unexpected state of an unblocked IP %+v", st)
+	}
+
+	a.Record("192.168.1.1", "/c")
+	st = a.Inspect("192.168.1.1")
+	if !st.Blocked || st.Until.IsZero() || st.Pages != 3 || st.Offenses != 1 {
+		t.Errorf("unexpected state of a blocked IP %+v", st)
+	}
+	if len(st.Events) != 1 || st.Events[0].Pages != 3 {
+		t.Errorf("expected the block event, got %+v", st.Events)
+	}
+
+	a.Block("10.0.0.1", 0)
+	if st := a.Inspect("10.0.0.1"); !st.Blocked || !st.Until.IsZero() || len(st.Events) != 1 || !st.Events[0].Manual {
+		t.Errorf("unexpected state of a manually blocked IP %+v", st)
+	}
+
+	if st := a.Inspect("10.0.0.2"); st.Blocked || st.Pages != 0 || st.Events != nil {
+		t.Errorf("unknown IP should have no state, got %+v", st)
+	}
+}
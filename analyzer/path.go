@@ -0,0 +1,122 @@
+package analyzer
+
+import (
+	"hash/maphash"
+	"strconv"
+	"strings"
+)
+
+// StripQuery returns path without its query string and fragment. It is the
+// default Config.PathNormalizer.
+func StripQuery(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		return path[:i]
+	}
+	return path
+}
+
+// NormalizePath strips the query string and collapses numeric and UUID
+// segments to ":id", so "/product/42?ref=x" becomes "/product/:id" and
+// paging through IDs counts as one page.
+func NormalizePath(path string) string {
+	path = StripQuery(path)
+
+	if !hasIDSegment(path) {
+		return path
+	}
+
+	var b strings.Builder
+	b.Grow(len(path))
+	for i, seg := range strings.Split(path, "/") {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		if isID(seg) {
+			b.WriteString(":id")
+		} else {
+			b.WriteString(seg)
+		}
+	}
+	return b.String()
+}
+
+// sequenceID returns the hash of path with its last numeric segment
+// collapsed to ":id", and the segment's value, or zeros if path has no
+// numeric segment of up to 18 digits, see Config.SequenceThreshold.
+func sequenceID(path string) (template, id uint64) {
+	for end := len(path); end > 0; {
+		start := strings.LastIndexByte(path[:end], '/') + 1
+		if seg := path[start:end]; seg != "" && len(seg) <= 18 && isDigits(seg) {
+			id, _ = strconv.ParseUint(seg, 10, 64)
+			var h maphash.Hash
+			h.SetSeed(seed)
+			h.WriteString(path[:start])
+			h.WriteString(":id")
+			h.WriteString(path[end:])
+			return h.Sum64(), id
+		}
+		end = start - 1
+	}
+	return 0, 0
+}
+
+// queryHash returns the hash of path's query string, zero if it has none,
+// see Config.QueryThreshold.
+func queryHash(path string) uint64 {
+	if i := strings.IndexByte(path, '#'); i >= 0 {
+		path = path[:i]
+	}
+	_, query, _ := strings.Cut(path, "?")
+	if query == "" {
+		return 0
+	}
+	return hashStr(query)
+}
+
+func hasIDSegment(path string) bool {
+	for path != "" {
+		var seg string
+		seg, path, _ = strings.Cut(path, "/")
+		if isID(seg) {
+			return true
+		}
+	}
+	return false
+}
+
+// isID reports whether seg is all digits or a UUID.
+func isID(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	return isDigits(seg) || isUUID(seg)
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			c := s[i]
+			if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,131 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStripQuery(t *testing.T) {
+	testCases := []struct{ in, want string }{
+		{"/search?q=x", "/search"},
+		{"/page#top", "/page"},
+		{"/a?b#c", "/a"},
+		{"/plain", "/plain"},
+	}
+
+	for _, tc := range testCases {
+		if got := StripQuery(tc.in); got != tc.want {
+			t.Errorf("StripQuery(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestNormalizePath(t *testing.T) {
+	testCases := []struct{ in, want string }{
+		{"/product/42", "/product/:id"},
+		{"/product/42?ref=x", "/product/:id"},
+		{"/users/7/orders/1001", "/users/:id/orders/:id"},
+		{"/order/550e8400-e29b-41d4-a716-446655440000", "/order/:id"},
+		{"/order/550E8400-E29B-41D4-A716-446655440000/", "/order/:id/"},
+		{"/v2/items", "/v2/items"},
+		{"/", "/"},
+		{"", ""},
+		{"/product/42abc", "/product/42abc"},
+	}
+
+	for _, tc := range testCases {
+		if got := NormalizePath(tc.in); got != tc.want {
+			t.Errorf("NormalizePath(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestAnalyzer_PathNormalizer(t *testing.T) {
+	a := New(Config{
+		Window:         time.Hour,
+		PageThreshold:  2,
+		QueueCap:       100,
+		PathNormalizer: NormalizePath,
+	})
+	defer a.Close()
+
+	for i := 0; i < 10; i++ {
+		a.Record("192.168.1.1", "/product/"+string(rune('0'+i)))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("normalized paths should count as one page")
+	}
+}
+
+func TestAnalyzer_PathNormalizer_Default(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 2,
+		QueueCap:      100,
+	})
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/search?q=a")
+	a.Record("192.168.1.1", "/search?q=b")
+	time.Sleep(100 * time.Millisecond)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("query strings should be stripped by default")
+	}
+}
+
+func TestSequenceID(t *testing.T) {
+	item, id := sequenceID("/item/1001")
+	if item == 0 || id != 1001 {
+		t.Fatalf("sequenceID(/item/1001) = %x, %d", item, id)
+	}
+	if template, id := sequenceID("/item/1002"); template != item || id != 1002 {
+		t.Errorf("IDs of a path should share its template, got %x, %d", template, id)
+	}
+	if template, id := sequenceID("/item/1002/"); template == item || id != 1002 {
+		t.Errorf("a trailing slash should make another template, got %x, %d", template, id)
+	}
+
+	// Only the last numeric segment is collapsed
+	reviews, id := sequenceID("/item/42/reviews/7")
+	if other, _ := sequenceID("/item/43/reviews/8"); reviews == item || other == reviews || id != 7 {
+		t.Errorf("unexpected template %x, %d", reviews, id)
+	}
+
+	for _, path := range []string{"", "/", "/item", "/item/12a", "/item/1234567890123456789"} {
+		if template, id := sequenceID(path); template != 0 || id != 0 {
+			t.Errorf("sequenceID(%q) = %x, %d, want none", path, template, id)
+		}
+	}
+}
+
+func TestQueryHash(t *testing.T) {
+	q := queryHash("/search?q=a")
+	if q == 0 || queryHash("/other?q=a#top") != q {
+		t.Error("the query string should be hashed alone")
+	}
+	if queryHash("/search?q=b") == q {
+		t.Error("query strings should hash apart")
+	}
+	for _, path := range []string{"/search", "/search?", "/search?#top", "/search#a?b"} {
+		if queryHash(path) != 0 {
+			t.Errorf("queryHash(%q) should be zero", path)
+		}
+	}
+}
+
+func BenchmarkNormalizePath(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		NormalizePath("/blog/hello-world?utm_source=x")
+	}
+}
+
+func BenchmarkSequenceID(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sequenceID("/item/1001/reviews")
+	}
+}
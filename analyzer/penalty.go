@@ -0,0 +1,110 @@
+package analyzer
+
+import (
+	"sync"
+	"time"
+)
+
+// penalties escalates block durations for repeat offenders along a
+// schedule. An IP's offenses are forgotten once the longest penalty has
+// passed since its last block ended without a re-offense.
+type penalties struct {
+	mu       sync.Mutex
+	schedule []time.Duration
+	offenses map[string]offense
+}
+
+type offense struct {
+	n      int
+	forget time.Time // zero never
+}
+
+func newPenalties(schedule []time.Duration) *penalties {
+	return &penalties{
+		schedule: schedule,
+		offenses: make(map[string]offense),
+	}
+}
+
+// next records an offense by ip at now and returns the offense count and
+// block duration for it.
+func (p *penalties) next(ip string, now time.Time) (int, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	o := p.offenses[ip]
+	if !o.forget.IsZero() && now.After(o.forget) {
+		o = offense{}
+	}
+	o.n++
+
+	d := p.schedule[min(o.n, len(p.schedule))-1]
+	longest := p.schedule[len(p.schedule)-1]
+	if d > 0 && longest > 0 {
+		o.forget = now.Add(d + longest)
+	} else {
+		o.forget = time.Time{}
+	}
+	p.offenses[ip] = o
+	return o.n, d
+}
+
+// count returns ip's offense count at now.
+func (p *penalties) count(ip string, now time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	o := p.offenses[ip]
+	if !o.forget.IsZero() && now.After(o.forget) {
+		return 0
+	}
+	return o.n
+}
+
+// Offense is an IP's offense count for the penalty schedule, see
+// Snapshot.
+type Offense struct {
+	IP    string `json:"ip"`
+	Count int    `json:"count"`
+
+	// Forget is when the offenses are forgotten, zero never.
+	Forget time.Time `json:"forget,omitempty"`
+}
+
+// list returns the offenses.
+func (p *penalties) list() []Offense {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	offenses := make([]Offense, 0, len(p.offenses))
+	for ip, o := range p.offenses {
+		offenses = append(offenses, Offense{IP: ip, Count: o.n, Forget: o.forget})
+	}
+	return offenses
+}
+
+// restore adds offenses, replacing those of the same IPs.
+func (p *penalties) restore(offenses []Offense) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, o := range offenses {
+		p.offenses[o.IP] = offense{n: o.Count, forget: o.Forget}
+	}
+}
+
+// forget drops ip's offenses, e.g. after a false positive.
+func (p *penalties) forget(ip string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.offenses, ip)
+}
+
+// expire drops offenses forgotten by now.
+func (p *penalties) expire(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for ip, o := range p.offenses {
+		if !o.forget.IsZero() && now.After(o.forget) {
+			delete(p.offenses, ip)
+		}
+	}
+}
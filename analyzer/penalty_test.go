@@ -0,0 +1,88 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPenalties_Next(t *testing.T) {
+	p := newPenalties([]time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour})
+	now := time.Now()
+
+	want := []time.Duration{5 * time.Minute, time.Hour, 24 * time.Hour, 24 * time.Hour}
+	for i, w := range want {
+		n, d := p.next("192.168.1.1", now)
+		if n != i+1 || d != w {
+			t.Errorf("offense %d: got (%d, %v), want (%d, %v)", i+1, n, d, i+1, w)
+		}
+	}
+
+	if n, d := p.next("192.168.1.2", now); n != 1 || d != 5*time.Minute {
+		t.Errorf("other IPs should start over, got (%d, %v)", n, d)
+	}
+}
+
+func TestPenalties_Forget(t *testing.T) {
+	p := newPenalties([]time.Duration{time.Minute, time.Hour})
+	now := time.Now()
+
+	p.next("192.168.1.1", now)
+
+	// Still remembered right after the block ends
+	if n, _ := p.next("192.168.1.1", now.Add(2*time.Minute)); n != 2 {
+		t.Errorf("expected second offense, got %d", n)
+	}
+
+	// Forgotten once the longest penalty passed since the block ended
+	later := now.Add(2*time.Minute + 3*time.Hour)
+	if n, d := p.next("192.168.1.1", later); n != 1 || d != time.Minute {
+		t.Errorf("expected a fresh start, got (%d, %v)", n, d)
+	}
+
+	p.expire(later.Add(3 * time.Hour))
+	if len(p.offenses) != 0 {
+		t.Errorf("expired offenses should be dropped, got %v", p.offenses)
+	}
+
+	p.next("192.168.1.1", now)
+	p.forget("192.168.1.1")
+	if n, _ := p.next("192.168.1.1", now); n != 1 {
+		t.Errorf("forgotten IP should start over, got %d", n)
+	}
+}
+
+func TestAnalyzer_PenaltySchedule(t *testing.T) {
+	a := New(Config{
+		Window:          time.Hour,
+		PageThreshold:   100,
+		QueueCap:        100,
+		PenaltySchedule: []time.Duration{time.Minute, time.Hour},
+	})
+	defer a.Close()
+
+	if a.cfg.SweepInterval != time.Minute {
+		t.Errorf("sweep interval should be capped at the shortest penalty, got %v", a.cfg.SweepInterval)
+	}
+
+	a.Trap("192.168.1.1", "/trap")
+	a.store.Unblock("192.168.1.1") // expired, not forgiven
+	a.Trap("192.168.1.1", "/trap")
+
+	events := a.Events()
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0].Offenses != 2 || events[0].Duration != time.Hour {
+		t.Errorf("second block should escalate, got %+v", events[0])
+	}
+	if events[1].Offenses != 1 || events[1].Duration != time.Minute {
+		t.Errorf("first block should be short, got %+v", events[1])
+	}
+
+	// Manual unblocks forgive
+	a.Unblock("192.168.1.1")
+	a.Trap("192.168.1.1", "/trap")
+	if e := a.Events()[0]; e.Offenses != 1 {
+		t.Errorf("unblocked IP should start over, got %+v", e)
+	}
+}
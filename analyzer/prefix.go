@@ -0,0 +1,229 @@
+package analyzer
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultPrefixLenV4 and DefaultPrefixLenV6 are the prefix lengths IPs
+// are grouped by for prefix blocks, see Config.PrefixThreshold.
+var (
+	DefaultPrefixLenV4 = 24
+	DefaultPrefixLenV6 = 48
+)
+
+// maxPrefixIPs bounds the IPs blocked in a window that prefixes keep
+// count of. Past it, further IPs are not counted until the window ends.
+const maxPrefixIPs = 1 << 16
+
+// prefixes escalates the blocks of IPs to blocks of their prefix, once
+// enough IPs of a prefix are blocked in a window.
+type prefixes struct {
+	lenV4, lenV6 int
+
+	// IPs blocked in the window by prefix, n in all
+	mu  sync.Mutex
+	ips map[netip.Prefix]map[netip.Addr]struct{}
+	n   int
+
+	// Blocked prefixes, copy-on-write like MemoryStore's map
+	blocked atomic.Pointer[map[netip.Prefix]prefixBlock]
+
+	// Prefixes blocked so far
+	promoted atomic.Uint64
+}
+
+type prefixBlock struct {
+	until time.Time // zero never expires
+
+	// The prefix as text, so lookups don't format it
+	key string
+}
+
+func newPrefixes(lenV4, lenV6 int) *prefixes {
+	if lenV4 <= 0 || lenV4 > 32 {
+		lenV4 = DefaultPrefixLenV4
+	}
+	if lenV6 <= 0 || lenV6 > 128 {
+		lenV6 = DefaultPrefixLenV6
+	}
+	p := &prefixes{lenV4: lenV4, lenV6: lenV6, ips: make(map[netip.Prefix]map[netip.Addr]struct{})}
+	m := make(map[netip.Prefix]prefixBlock)
+	p.blocked.Store(&m)
+	return p
+}
+
+// prefix returns the prefix of addr, false if it is not a valid IP.
+func (p *prefixes) prefix(addr netip.Addr) (netip.Prefix, bool) {
+	addr = addr.Unmap()
+	bits := p.lenV6
+	if addr.Is4() {
+		bits = p.lenV4
+	}
+	prefix, err := addr.Prefix(bits)
+	return prefix, err == nil
+}
+
+// observe counts the block of ip, ignoring keys that are not IPs, and
+// returns its prefix and blocked IPs once they reach threshold, unless
+// the prefix is blocked already. The count then starts over.
+func (p *prefixes) observe(ip string, threshold int) (netip.Prefix, int, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, 0, false
+	}
+	prefix, ok := p.prefix(addr)
+	if !ok {
+		return netip.Prefix{}, 0, false
+	}
+	if _, blocked := (*p.blocked.Load())[prefix]; blocked {
+		return netip.Prefix{}, 0, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ips := p.ips[prefix]
+	if _, ok := ips[addr.Unmap()]; !ok {
+		if p.n >= maxPrefixIPs {
+			return netip.Prefix{}, 0, false
+		}
+		if ips == nil {
+			ips = make(map[netip.Addr]struct{})
+			p.ips[prefix] = ips
+		}
+		ips[addr.Unmap()] = struct{}{}
+		p.n++
+	}
+	n := len(ips)
+	if n < threshold {
+		return netip.Prefix{}, 0, false
+	}
+	delete(p.ips, prefix)
+	p.n -= n
+	return prefix, n, true
+}
+
+// reset forgets the IPs blocked in the window.
+func (p *prefixes) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	clear(p.ips)
+	p.n = 0
+}
+
+// update replaces the blocked prefixes with fn's changes to a copy.
+func (p *prefixes) update(fn func(m map[netip.Prefix]prefixBlock)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	old := *p.blocked.Load()
+	m := make(map[netip.Prefix]prefixBlock, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	fn(m)
+	p.blocked.Store(&m)
+}
+
+// block blocks prefix for ttl from now, forever if ttl <= 0.
+func (p *prefixes) block(prefix netip.Prefix, ttl time.Duration, now time.Time) {
+	b := prefixBlock{key: prefix.String()}
+	if ttl > 0 {
+		b.until = now.Add(ttl)
+	}
+	p.update(func(m map[netip.Prefix]prefixBlock) {
+		m[prefix] = b
+	})
+	p.promoted.Add(1)
+}
+
+// unblock removes the block of prefix and reports whether there was one.
+func (p *prefixes) unblock(prefix netip.Prefix) bool {
+	if _, ok := (*p.blocked.Load())[prefix]; !ok {
+		return false
+	}
+	p.update(func(m map[netip.Prefix]prefixBlock) {
+		delete(m, prefix)
+	})
+	return true
+}
+
+// lookup returns the blocked prefix containing addr, if any.
+func (p *prefixes) lookup(addr netip.Addr) (string, bool) {
+	m := *p.blocked.Load()
+	if len(m) == 0 {
+		return "", false
+	}
+	prefix, ok := p.prefix(addr)
+	if !ok {
+		return "", false
+	}
+	b, ok := m[prefix]
+	return b.key, ok
+}
+
+// expire removes the blocks that ended before now.
+func (p *prefixes) expire(now time.Time) {
+	expired := false
+	for _, b := range *p.blocked.Load() {
+		if !b.until.IsZero() && b.until.Before(now) {
+			expired = true
+			break
+		}
+	}
+	if !expired {
+		return
+	}
+	p.update(func(m map[netip.Prefix]prefixBlock) {
+		for prefix, b := range m {
+			if !b.until.IsZero() && b.until.Before(now) {
+				delete(m, prefix)
+			}
+		}
+	})
+}
+
+// BlockedPrefix returns the blocked prefix containing addr, as text, see
+// Config.PrefixThreshold. It is meant for the request path.
+func (a *Analyzer) BlockedPrefix(addr netip.Addr) (string, bool) {
+	return a.prefixes.lookup(addr)
+}
+
+// RangePrefixes calls fn for each blocked prefix and the time its block
+// ends (zero never expires), until fn returns false.
+func (a *Analyzer) RangePrefixes(fn func(prefix netip.Prefix, until time.Time) bool) {
+	for prefix, b := range *a.prefixes.blocked.Load() {
+		if !fn(prefix, b.until) {
+			return
+		}
+	}
+}
+
+// escalate counts the block of ip and blocks its prefix for
+// BlockDuration once Thresholds.PrefixThreshold of its IPs were blocked
+// in the window.
+func (a *Analyzer) escalate(ip string) {
+	t := a.thresholds.Load()
+	if t.PrefixThreshold <= 0 {
+		return
+	}
+	prefix, n, ok := a.prefixes.observe(ip, t.PrefixThreshold)
+	if !ok {
+		return
+	}
+
+	now := a.cfg.Clock.Now()
+	a.prefixes.block(prefix, t.BlockDuration, now)
+	a.emit(Event{Type: EventBlock, Time: now, IP: prefix.String(), IPs: n, Duration: t.BlockDuration})
+	a.logger.Info("botrate: prefix blocked", "prefix", prefix, "ips", n, "duration", t.BlockDuration)
+}
+
+// unblockPrefix removes the block of prefix.
+func (a *Analyzer) unblockPrefix(prefix netip.Prefix) {
+	if !a.prefixes.unblock(prefix.Masked()) {
+		return
+	}
+	a.logger.Info("botrate: prefix unblocked", "prefix", prefix)
+	a.emit(Event{Type: EventUnblock, Time: a.cfg.Clock.Now(), IP: prefix.Masked().String(), Manual: true})
+}
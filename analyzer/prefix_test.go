@@ -0,0 +1,94 @@
+package analyzer
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestAnalyzer_PrefixThreshold(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	a := New(Config{Window: time.Hour, PageThreshold: 1, QueueCap: 100, Sync: true, PrefixThreshold: 3, BlockDuration: time.Minute, Clock: clock})
+	defer a.Close()
+
+	addr := netip.MustParseAddr("203.0.113.200")
+	a.Record("203.0.113.1", "/a")
+	a.Record("203.0.113.2", "/a")
+	a.Trap("198.51.100.1", "/trap") // another prefix
+	a.Block("203.0.113.3", 0)       // manual blocks don't count
+	if _, ok := a.BlockedPrefix(addr); ok {
+		t.Fatal("prefix should not be blocked below the threshold")
+	}
+
+	a.Trap("203.0.113.4", "/trap")
+	prefix, ok := a.BlockedPrefix(addr)
+	if !ok || prefix != "203.0.113.0/24" {
+		t.Fatalf("expected 203.0.113.0/24 to be blocked, got %q, %v", prefix, ok)
+	}
+	if e := a.Events()[0]; e.IP != prefix || e.IPs != 3 || e.Reason() != "prefix" || e.Duration != time.Minute {
+		t.Errorf("unexpected prefix block event %+v", e)
+	}
+	if st := a.Stats(); st.Prefixes != 1 || st.PrefixBlocks != 1 {
+		t.Errorf("expected 1 prefix blocked, got %+v", st)
+	}
+	if got := a.Inspect("203.0.113.200").Prefix; got != prefix {
+		t.Errorf("expected Inspect to report the prefix, got %q", got)
+	}
+
+	// Expired with the block duration
+	a.expire(clock.Now().Add(2 * time.Minute))
+	if _, ok := a.BlockedPrefix(addr); ok {
+		t.Error("prefix block should expire")
+	}
+}
+
+func TestPrefixes_Max(t *testing.T) {
+	p := newPrefixes(0, 0)
+	p.observe("192.0.2.1", 3)
+	p.n = maxPrefixIPs
+
+	if _, _, ok := p.observe("198.51.100.1", 1); ok {
+		t.Error("IPs past the bound should not be counted")
+	}
+	if _, _, ok := p.observe("192.0.2.1", 1); !ok {
+		t.Error("IPs counted already should still reach the threshold")
+	}
+	p.reset()
+	if _, _, ok := p.observe("198.51.100.1", 1); !ok {
+		t.Error("IPs should be counted again in the next window")
+	}
+}
+
+func TestAnalyzer_PrefixThreshold_Window(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 1, QueueCap: 100, Sync: true, PrefixThreshold: 2})
+	defer a.Close()
+
+	a.Trap("2001:db8:1:2::1", "/trap")
+	a.prefixes.reset() // next window
+	a.Trap("2001:db8:1:3::1", "/trap")
+	if _, ok := a.BlockedPrefix(netip.MustParseAddr("2001:db8:1::9")); ok {
+		t.Fatal("IPs blocked in an earlier window should not count")
+	}
+
+	a.Trap("2001:db8:1:4::1", "/trap")
+	prefix, ok := a.BlockedPrefix(netip.MustParseAddr("2001:db8:1::9"))
+	if !ok || prefix != "2001:db8:1::/48" {
+		t.Fatalf("expected 2001:db8:1::/48 to be blocked, got %q, %v", prefix, ok)
+	}
+
+	var blocked []netip.Prefix
+	a.RangePrefixes(func(p netip.Prefix, until time.Time) bool {
+		blocked = append(blocked, p)
+		return true
+	})
+	if len(blocked) != 1 || blocked[0].String() != prefix {
+		t.Errorf("unexpected blocked prefixes %v", blocked)
+	}
+
+	if err := a.Unblock(prefix); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	if _, ok := a.BlockedPrefix(netip.MustParseAddr("2001:db8:1::9")); ok {
+		t.Error("unblocked prefix should not be blocked")
+	}
+}
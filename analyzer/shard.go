@@ -0,0 +1,325 @@
+package analyzer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// shard is a worker analyzing a share of the IPs, see Config.Workers.
+// Its state is guarded by mu, only contended with Config.Sync; the
+// blocklist, greylist and penalties it writes to are shared by all
+// shards.
+type shard struct {
+	a *Analyzer
+
+	mu sync.Mutex
+
+	// Event queue
+	queue chan Request
+
+	// Counter resets for manually unblocked IPs
+	resets chan string
+
+	// Tickers of window changes, see Analyzer.SetWindow
+	windows chan Ticker
+
+	// Flush requests, closed once the queue is drained
+	flushes chan chan struct{}
+
+	bloom    *DoubleBufferBloom
+	queryset *DoubleBufferBloom // nil until a QueryThreshold applies
+	counter  *Counter
+	hll      *hllSet  // nil without HyperLogLog
+	requests *Counter // empty without a RequestThreshold
+	errors   *Counter // empty without an ErrorThreshold
+	logins   *Counter // empty without a LoginThreshold
+	headers  *Counter // empty without a HeaderThreshold
+	steps    *Counter // empty without a SequenceThreshold
+	queries  *Counter // empty without a QueryThreshold
+	methods  *Counter // empty without method thresholds
+	signals  []Signal
+
+	// Counts per method of methodsSignal, summed in methods
+	byMethod map[string]*Counter
+
+	// Number of signals owned by the shard, the rest are the shared
+	// custom signals
+	own int
+
+	// first rotates the shared signals, once for all shards
+	first bool
+
+	// Start of the current window, for SlidingWindow
+	windowStart time.Time
+}
+
+func newShard(a *Analyzer, first bool, shared []Signal) *shard {
+	cfg := a.cfg
+	s := &shard{
+		a:           a,
+		queue:       make(chan Request, cfg.QueueCap),
+		resets:      make(chan string, 64),
+		windows:     make(chan Ticker),
+		flushes:     make(chan chan struct{}),
+		counter:     NewCounter(),
+		first:       first,
+		windowStart: cfg.Clock.Now(),
+	}
+
+	// The shards split the bloom capacity between them
+	capacity := max(cfg.BloomCapacity/uint(cfg.Workers), 1)
+	s.bloom = NewDoubleBufferBloomWithEstimates(capacity, cfg.BloomFalsePositiveRate)
+
+	if cfg.HyperLogLog {
+		s.hll = newHLLSet()
+		s.signals = []Signal{&hllPagesSignal{set: s.hll}}
+	} else {
+		s.signals = []Signal{&pagesSignal{counter: s.counter}}
+	}
+	// The thresholds can be set later, see SetThresholds, so the signals
+	// are there even when disabled
+	s.requests, s.errors, s.logins, s.headers = NewCounter(), NewCounter(), NewCounter(), NewCounter()
+	s.steps, s.queries, s.methods = NewCounter(), NewCounter(), NewCounter()
+	s.byMethod = make(map[string]*Counter)
+	s.signals = append(s.signals,
+		&requestsSignal{counter: s.requests, threshold: func() int { return a.thresholds.Load().RequestThreshold }},
+		&errorsSignal{counter: s.errors, threshold: func() int { return a.thresholds.Load().ErrorThreshold }},
+		&loginSignal{counter: s.logins, threshold: func() int { return a.thresholds.Load().LoginThreshold }},
+		&headersSignal{counter: s.headers, threshold: func() int { return a.thresholds.Load().HeaderThreshold }},
+		&sequenceSignal{counter: s.steps, threshold: func() int { return a.thresholds.Load().SequenceThreshold }, last: make(map[string]*sequences)},
+		&queriesSignal{counter: s.queries, threshold: func() int { return a.thresholds.Load().QueryThreshold }, pages: make(map[ipPage]uint16)},
+		&methodsSignal{counter: s.methods, methods: s.byMethod},
+	)
+	s.own = len(s.signals)
+	s.signals = append(s.signals, shared...)
+	return s
+}
+
+func (s *shard) work(ticker Ticker) {
+	defer func() {
+		ticker.Stop()
+	}()
+
+	for {
+		select {
+		case <-s.a.stop:
+			return
+		case req := <-s.queue:
+			s.process(req)
+		case ip := <-s.resets:
+			s.forget(ip)
+		case t := <-s.windows:
+			ticker.Stop()
+			ticker = t
+		case done := <-s.flushes:
+			// Ticks, resets and events from before the flush are
+			// already delivered
+			select {
+			case <-ticker.C():
+				s.mu.Lock()
+				s.rotate()
+				s.mu.Unlock()
+			default:
+			}
+			for n := len(s.resets); n > 0; n-- {
+				s.forget(<-s.resets)
+			}
+			for n := len(s.queue); n > 0; n-- {
+				s.process(<-s.queue)
+			}
+			close(done)
+		case <-ticker.C():
+			s.mu.Lock()
+			s.rotate()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// process analyzes req.
+func (s *shard) process(req Request) {
+	s.mu.Lock()
+	s.analyze(&req)
+	s.mu.Unlock()
+}
+
+// forget resets the counts of ip, e.g. after a manual unblock.
+func (s *shard) forget(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sig := range s.signals {
+		sig.Forget(ip)
+	}
+}
+
+func (s *shard) analyze(req *Request) {
+	a := s.a
+	t := a.thresholds.Load()
+	threshold := t.PageThreshold
+	if req.Threshold > 0 {
+		threshold = req.Threshold
+	}
+
+	o := Observation{IP: req.IP, Path: req.Path, PageThreshold: threshold, Status: req.Status, LoginFailure: req.LoginFailure, Headers: req.Headers, Mismatch: req.Mismatch, Template: req.Template, ID: req.ID, Query: req.Query, Weight: req.Weight, Method: req.Method, MethodThreshold: req.MethodThreshold}
+	if a.cfg.SlidingWindow {
+		o.Previous = s.previous(s.a.cfg.Clock.Now())
+	}
+	switch {
+	case req.Status != 0:
+	case s.hll != nil:
+		o.Distinct = s.hll.Add(req.IP, req.Path)
+	default:
+		// Bloom filter deduplication
+		o.Distinct = !s.bloom.TestAndAddUint64(hashIPPath(req.IP, req.Path))
+	}
+	if req.Query != 0 {
+		// Queries get their own filter, sized like the pages' one, so
+		// they don't fill up the pages' and make new pages revisits
+		if s.queryset == nil {
+			s.queryset = NewDoubleBufferBloomWithEstimates(s.bloom.capacity, s.bloom.fpRate)
+		}
+		o.NewQuery = !s.queryset.TestAndAddUint64(hashIPPath(req.IP, req.Path^req.Query))
+	}
+
+	var score float64
+	for _, sig := range s.signals {
+		score += sig.Observe(o)
+	}
+
+	// Score check
+	// Already blocked IPs, e.g. through responses to their throttled
+	// requests, are not blocked again: that would be another offense
+	greylist := t.GreylistThreshold > 0 && score >= t.GreylistThreshold
+	if score < t.ScoreThreshold && !greylist || a.store.Blocked(req.IP) {
+		return
+	}
+	if score < t.ScoreThreshold {
+		a.greylist(req.IP, score)
+		return
+	}
+	offenses, d := a.penalty(req.IP)
+	if a.block(req.IP, d) {
+		a.grey.Unblock(req.IP)
+		e := Event{
+			Type:       EventBlock,
+			Time:       s.a.cfg.Clock.Now(),
+			IP:         req.IP,
+			Pages:      s.pages(req.IP, o.Previous),
+			Requests:   count(s.requests, req.IP, o.Previous),
+			Errors:     count(s.errors, req.IP, o.Previous),
+			Logins:     count(s.logins, req.IP, o.Previous),
+			Mismatches: count(s.headers, req.IP, o.Previous),
+			Sequential: count(s.steps, req.IP, o.Previous),
+			Queries:    count(s.queries, req.IP, o.Previous),
+			Methods:    count(s.methods, req.IP, o.Previous),
+			Score:      score,
+			Offenses:   offenses,
+			Duration:   d,
+		}
+		a.emit(e)
+		a.logger.Info("botrate: ip blocked",
+			"ip", req.IP,
+			"pages", e.Pages,
+			"requests", e.Requests,
+			"errors", e.Errors,
+			"logins", e.Logins,
+			"mismatches", e.Mismatches,
+			"sequential", e.Sequential,
+			"queries", e.Queries,
+			"methods", e.Methods,
+			"threshold", threshold,
+			"score", score,
+			"window", a.Window(),
+			"offenses", offenses,
+			"duration", d,
+		)
+		a.escalate(req.IP)
+	}
+}
+
+// pages returns ip's distinct pages in the window, the previous
+// window's weighted by w.
+func (s *shard) pages(ip string, w float64) int {
+	if s.hll != nil {
+		return int(math.Round(s.hll.Weighted(ip, w)))
+	}
+	return count(s.counter, ip, w)
+}
+
+// count returns ip's count in c, which may be nil, the previous window's
+// weighted by w.
+func count(c *Counter, ip string, w float64) int {
+	if c == nil {
+		return 0
+	}
+	return int(math.Round(c.Weighted(ip, w)))
+}
+
+// previous returns the weight of the previous window's counts at now,
+// see Observation.Previous.
+func (s *shard) previous(now time.Time) float64 {
+	window := s.a.Window()
+	elapsed := now.Sub(s.windowStart)
+	if elapsed >= window {
+		return 0
+	}
+	return 1 - float64(elapsed)/float64(window)
+}
+
+func (s *shard) rotate() {
+	tracked := len(s.counter.data)
+	if s.hll != nil {
+		tracked = len(s.hll.sketches)
+	}
+	s.a.logger.Debug("botrate: window rotated", "tracked_ips", tracked)
+
+	s.bloom.Rotate()
+	if s.queryset != nil {
+		s.queryset.Rotate()
+	}
+	s.windowStart = s.a.cfg.Clock.Now()
+
+	signals := s.signals
+	if !s.first {
+		signals = signals[:s.own]
+	}
+	for _, sig := range signals {
+		if s.a.cfg.SlidingWindow {
+			rotate(sig)
+		} else {
+			sig.Reset()
+		}
+	}
+}
+
+// lockedSignal serializes calls to a custom signal shared by several
+// workers.
+type lockedSignal struct {
+	mu sync.Mutex
+	Signal
+}
+
+func (s *lockedSignal) Observe(o Observation) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Signal.Observe(o)
+}
+
+func (s *lockedSignal) Forget(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Signal.Forget(ip)
+}
+
+func (s *lockedSignal) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Signal.Reset()
+}
+
+func (s *lockedSignal) Rotate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rotate(s.Signal)
+}
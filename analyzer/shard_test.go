@@ -0,0 +1,120 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAnalyzer_Workers(t *testing.T) {
+	hits := newHitsSignal(1000)
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 3,
+		QueueCap:      100,
+		Workers:       4,
+		Signals:       []Signal{hits},
+	})
+	defer a.Close()
+
+	if len(a.shards) != 4 {
+		t.Fatalf("expected 4 workers, got %d", len(a.shards))
+	}
+	if s := a.Stats(); s.QueueCap != 400 {
+		t.Errorf("expected the queues to sum up to 400, got %d", s.QueueCap)
+	}
+
+	// Each IP's pages land on one worker, whichever it is
+	for i := 0; i < 20; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		for _, p := range []string{"/1", "/2", "/3"} {
+			a.Record(ip, p)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < 20; i++ {
+		if ip := fmt.Sprintf("10.0.0.%d", i); !a.Blocked(ip) {
+			t.Errorf("%s should be blocked", ip)
+		}
+	}
+	if s := a.Stats(); s.Blocklist != 20 {
+		t.Errorf("expected 20 blocked IPs in the shared blocklist, got %d", s.Blocklist)
+	}
+}
+
+func TestAnalyzer_Workers_Unblock(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 2, QueueCap: 100, Workers: 3})
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/1")
+	a.Record("192.168.1.1", "/2")
+	time.Sleep(50 * time.Millisecond)
+	if !a.Blocked("192.168.1.1") {
+		t.Fatal("ip should be blocked")
+	}
+
+	// The reset reaches the IP's worker, so one more page doesn't block
+	if err := a.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	a.Record("192.168.1.1", "/3")
+	time.Sleep(50 * time.Millisecond)
+	if a.Blocked("192.168.1.1") {
+		t.Error("unblocked ip should start counting from zero")
+	}
+}
+
+func TestShard_Rotate_SharedSignals(t *testing.T) {
+	hits := newHitsSignal(10)
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 100,
+		QueueCap:      100,
+		Workers:       3,
+		Signals:       []Signal{hits},
+	})
+	a.Close()
+
+	for _, s := range a.shards {
+		s.rotate()
+	}
+	if hits.resets != 1 {
+		t.Errorf("shared signals should be reset once per window, got %d", hits.resets)
+	}
+}
+
+func TestAnalyzer_Flush(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 3, QueueCap: 1000, Workers: 2})
+
+	for i := 0; i < 10; i++ {
+		ip := fmt.Sprintf("10.0.0.%d", i)
+		for _, p := range []string{"/1", "/2", "/3"} {
+			a.Record(ip, p)
+		}
+	}
+	if err := a.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if s := a.Stats(); s.Blocklist != 10 || s.QueueLen != 0 {
+		t.Errorf("expected 10 blocked IPs and an empty queue after Flush, got %+v", s)
+	}
+
+	a.Close()
+	if err := a.Flush(context.Background()); err != ErrClosed {
+		t.Errorf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestAnalyzer_Flush_Context(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 3, QueueCap: 1000})
+	defer a.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.Flush(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
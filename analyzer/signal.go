@@ -0,0 +1,505 @@
+package analyzer
+
+import (
+	"maps"
+	"math"
+)
+
+// Observation is what a Signal sees of a recorded request.
+type Observation struct {
+	// IP is the key the request is analyzed by.
+	IP string
+
+	// Path is the hash of the normalized path.
+	Path uint64
+
+	// Distinct reports whether IP has not visited Path in the window or
+	// the previous one.
+	Distinct bool
+
+	// PageThreshold is the distinct pages threshold for IP.
+	PageThreshold int
+
+	// Status is the response status for observations from
+	// RecordResponse and RecordLoginFailure, zero for requests.
+	// Responses have no Path.
+	Status int
+
+	// LoginFailure reports whether the observation is a failed login from
+	// RecordLoginFailure. Such observations are not responses for the
+	// built-in errors signal.
+	LoginFailure bool
+
+	// Headers is the hash of the request's header names, zero if
+	// unknown, and Mismatch reports whether its headers don't match its
+	// User-Agent, see RecordHeaders.
+	Headers  uint64
+	Mismatch bool
+
+	// Template is the hash of the path with its last numeric segment
+	// collapsed, and ID the segment's value, so "/item/1002" is ID 1002
+	// of "/item/:id". Both are zero for paths without one, or without
+	// Config.SequenceThreshold.
+	Template uint64
+	ID       uint64
+
+	// Query is the hash of the query string, zero for paths without one,
+	// or without Config.QueryThreshold, and NewQuery reports whether IP
+	// has not sent it for Path in the window or the previous one.
+	Query    uint64
+	NewQuery bool
+
+	// Weight is how many requests the request counts as, at least 1, and
+	// MethodThreshold the threshold of its Method, zero if none, see
+	// Visit.
+	Weight          int
+	Method          string
+	MethodThreshold int
+
+	// Previous is the weight of the previous window's counts with
+	// Config.SlidingWindow, from 1 at the start of a window down to 0 at
+	// its end, see Rotator. It is zero without a sliding window.
+	Previous float64
+}
+
+// weight returns o.Weight, at least 1.
+func (o *Observation) weight() uint16 {
+	return uint16(min(max(o.Weight, 1), math.MaxUint16))
+}
+
+// Signal contributes to an IP's score. The analyzer sums the scores of
+// all signals and blocks the IP once the sum reaches
+// Config.ScoreThreshold. By convention a score of 1 means the signal
+// alone warrants a block.
+//
+// Signals are only called from the analyzer worker, so they need no
+// locking. With Config.Workers, the analyzer locks custom signals
+// itself.
+type Signal interface {
+	// Name identifies the signal in logs.
+	Name() string
+
+	// Observe records o and returns the IP's current score.
+	Observe(o Observation) float64
+
+	// Forget drops the state kept for ip, e.g. after a manual unblock.
+	Forget(ip string)
+
+	// Reset drops all state at the end of a window.
+	Reset()
+}
+
+// Rotator is implemented by signals supporting Config.SlidingWindow. At
+// the end of a window, Rotate keeps the window's state as the previous
+// window's, to be weighted by Observation.Previous, instead of Reset
+// dropping it. Signals that are not Rotators are Reset.
+type Rotator interface {
+	Rotate()
+}
+
+// Weight scales the scores of s by w.
+func Weight(s Signal, w float64) Signal {
+	return &weighted{Signal: s, w: w}
+}
+
+type weighted struct {
+	Signal
+	w float64
+}
+
+func (s *weighted) Observe(o Observation) float64 {
+	return s.Signal.Observe(o) * s.w
+}
+
+func (s *weighted) Rotate() {
+	rotate(s.Signal)
+}
+
+// rotate rotates s if it is a Rotator, and resets it otherwise.
+func rotate(s Signal) {
+	if r, ok := s.(Rotator); ok {
+		r.Rotate()
+		return
+	}
+	s.Reset()
+}
+
+// pagesSignal scores distinct pages per window against the page
+// threshold. It is always the analyzer's first signal.
+type pagesSignal struct {
+	counter *Counter
+}
+
+func (s *pagesSignal) Name() string {
+	return "pages"
+}
+
+func (s *pagesSignal) Observe(o Observation) float64 {
+	if o.Status == 0 && o.Distinct {
+		s.counter.Add(o.IP, o.weight())
+	}
+	if o.PageThreshold <= 0 {
+		return 0
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(o.PageThreshold)
+}
+
+func (s *pagesSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+}
+
+func (s *pagesSignal) Reset() {
+	s.counter.Clear()
+}
+
+func (s *pagesSignal) Rotate() {
+	s.counter.Rotate()
+}
+
+// requestsSignal scores requests per window, distinct or not, against
+// the request threshold, so a bot hammering one URL is caught too.
+type requestsSignal struct {
+	counter   *Counter
+	threshold func() int // disabled when <= 0
+}
+
+func (s *requestsSignal) Name() string {
+	return "requests"
+}
+
+func (s *requestsSignal) Observe(o Observation) float64 {
+	threshold := s.threshold()
+	if threshold <= 0 {
+		return 0
+	}
+	if o.Status == 0 {
+		s.counter.Add(o.IP, o.weight())
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(threshold)
+}
+
+func (s *requestsSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+}
+
+func (s *requestsSignal) Reset() {
+	s.counter.Clear()
+}
+
+func (s *requestsSignal) Rotate() {
+	s.counter.Rotate()
+}
+
+// errorsSignal scores error responses per window against the error
+// threshold: 404 storms, 401 brute forcing, fuzzers triggering 5xx.
+type errorsSignal struct {
+	counter   *Counter
+	threshold func() int // disabled when <= 0
+}
+
+func (s *errorsSignal) Name() string {
+	return "errors"
+}
+
+func (s *errorsSignal) Observe(o Observation) float64 {
+	threshold := s.threshold()
+	if threshold <= 0 {
+		return 0
+	}
+	if o.Status >= 400 && !o.LoginFailure {
+		s.counter.Visit(o.IP)
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(threshold)
+}
+
+func (s *errorsSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+}
+
+func (s *errorsSignal) Reset() {
+	s.counter.Clear()
+}
+
+func (s *errorsSignal) Rotate() {
+	s.counter.Rotate()
+}
+
+// loginSignal scores failed logins per window against the login
+// threshold, to stop brute forcing and credential stuffing long before
+// the page threshold would.
+type loginSignal struct {
+	counter   *Counter
+	threshold func() int // disabled when <= 0
+}
+
+func (s *loginSignal) Name() string {
+	return "logins"
+}
+
+func (s *loginSignal) Observe(o Observation) float64 {
+	threshold := s.threshold()
+	if threshold <= 0 {
+		return 0
+	}
+	if o.LoginFailure {
+		s.counter.Visit(o.IP)
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(threshold)
+}
+
+func (s *loginSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+}
+
+func (s *loginSignal) Reset() {
+	s.counter.Clear()
+}
+
+func (s *loginSignal) Rotate() {
+	s.counter.Rotate()
+}
+
+// headersSignal scores requests whose headers don't match their
+// User-Agent per window against the header threshold: scripts claiming
+// to be browsers rarely send the headers browsers do.
+type headersSignal struct {
+	counter   *Counter
+	threshold func() int // disabled when <= 0
+}
+
+func (s *headersSignal) Name() string {
+	return "headers"
+}
+
+func (s *headersSignal) Observe(o Observation) float64 {
+	threshold := s.threshold()
+	if threshold <= 0 {
+		return 0
+	}
+	if o.Mismatch {
+		s.counter.Visit(o.IP)
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(threshold)
+}
+
+func (s *headersSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+}
+
+func (s *headersSignal) Reset() {
+	s.counter.Clear()
+}
+
+func (s *headersSignal) Rotate() {
+	s.counter.Rotate()
+}
+
+// sequenceGap is how far apart IDs can be and still be sequential:
+// scrapers skip the deleted ones.
+const sequenceGap = 3
+
+// sequenceSignal scores sequential IDs per window against the sequence
+// threshold: steps from an ID of a path to the next few, in the same
+// direction as the previous step, e.g. /item/1001, /item/1002,
+// /item/1004.
+type sequenceSignal struct {
+	counter   *Counter
+	threshold func() int // disabled when <= 0
+
+	// Last IDs of each IP, which may interleave a few paths, e.g. items
+	// and their reviews
+	last map[string]*sequences
+}
+
+type sequences struct {
+	ids  [4]sequence
+	next int // slot replaced by a new path
+}
+
+type sequence struct {
+	template, id uint64
+	dir          int // of the last step, 0 before the first
+}
+
+func (s *sequenceSignal) Name() string {
+	return "sequence"
+}
+
+func (s *sequenceSignal) Observe(o Observation) float64 {
+	threshold := s.threshold()
+	if threshold <= 0 {
+		return 0
+	}
+	if o.Status == 0 && o.Template != 0 {
+		s.step(o)
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(threshold)
+}
+
+// step moves o's IP to o.ID of o.Template, counting it if sequential.
+func (s *sequenceSignal) step(o Observation) {
+	seqs := s.last[o.IP]
+	if seqs == nil {
+		if len(s.last) >= s.counter.maxSize {
+			return
+		}
+		seqs = &sequences{}
+		s.last[o.IP] = seqs
+	}
+
+	for i := range seqs.ids {
+		seq := &seqs.ids[i]
+		if seq.template != o.Template {
+			continue
+		}
+		dir, gap := 1, o.ID-seq.id
+		if o.ID < seq.id {
+			dir, gap = -1, seq.id-o.ID
+		}
+		if gap == 0 {
+			return
+		}
+		switch {
+		case gap > sequenceGap:
+			seq.dir = 0
+		case seq.dir == 0 || seq.dir == dir:
+			s.counter.Visit(o.IP)
+			seq.dir = dir
+		default:
+			// Back and forth is reading, not enumerating
+			seq.dir = dir
+		}
+		seq.id = o.ID
+		return
+	}
+
+	seqs.ids[seqs.next] = sequence{template: o.Template, id: o.ID}
+	seqs.next = (seqs.next + 1) % len(seqs.ids)
+}
+
+func (s *sequenceSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+	delete(s.last, ip)
+}
+
+func (s *sequenceSignal) Reset() {
+	s.counter.Clear()
+	s.last = make(map[string]*sequences)
+}
+
+// Rotate keeps the counts for the previous window, and drops the last
+// IDs, whose sequences start over.
+func (s *sequenceSignal) Rotate() {
+	s.counter.Rotate()
+	s.last = make(map[string]*sequences)
+}
+
+// queriesSignal scores the distinct query strings of an IP's pages per
+// window against the query threshold: a fuzzer or cache buster hits a
+// page with a new query string every time.
+type queriesSignal struct {
+	counter   *Counter   // the most query strings of one of each IP's pages
+	threshold func() int // disabled when <= 0
+
+	// Query strings of each IP's pages
+	pages map[ipPage]uint16
+}
+
+type ipPage struct {
+	ip   string
+	page uint64
+}
+
+func (s *queriesSignal) Name() string {
+	return "queries"
+}
+
+func (s *queriesSignal) Observe(o Observation) float64 {
+	threshold := s.threshold()
+	if threshold <= 0 {
+		return 0
+	}
+	if o.NewQuery {
+		key := ipPage{ip: o.IP, page: o.Path}
+		if n, ok := s.pages[key]; ok || len(s.pages) < s.counter.maxSize {
+			if n < math.MaxUint16 {
+				n++
+			}
+			s.pages[key] = n
+			if n > s.counter.Count(o.IP) {
+				s.counter.Set(o.IP, n)
+			}
+		}
+	}
+	return s.counter.Weighted(o.IP, o.Previous) / float64(threshold)
+}
+
+func (s *queriesSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+	maps.DeleteFunc(s.pages, func(key ipPage, _ uint16) bool {
+		return key.ip == ip
+	})
+}
+
+func (s *queriesSignal) Reset() {
+	s.counter.Clear()
+	s.pages = make(map[ipPage]uint16)
+}
+
+// Rotate keeps the counts for the previous window, and drops those of
+// the pages, which start over.
+func (s *queriesSignal) Rotate() {
+	s.counter.Rotate()
+	s.pages = make(map[ipPage]uint16)
+}
+
+// methodsSignal scores the requests of methods with a threshold of their
+// own per window, weighted, against the threshold of the request's
+// method, see Visit.MethodThreshold: spam submissions and signups are
+// stopped long before the page threshold would. Each method is counted
+// against its own threshold; counter sums them for reports.
+type methodsSignal struct {
+	counter *Counter
+	methods map[string]*Counter
+}
+
+func (s *methodsSignal) Name() string {
+	return "methods"
+}
+
+func (s *methodsSignal) Observe(o Observation) float64 {
+	if o.MethodThreshold <= 0 {
+		return 0
+	}
+	c := s.methods[o.Method]
+	if c == nil {
+		c = NewCounter()
+		s.methods[o.Method] = c
+	}
+	if o.Status == 0 {
+		c.Add(o.IP, o.weight())
+		s.counter.Add(o.IP, o.weight())
+	}
+	return c.Weighted(o.IP, o.Previous) / float64(o.MethodThreshold)
+}
+
+func (s *methodsSignal) Forget(ip string) {
+	s.counter.Delete(ip)
+	for _, c := range s.methods {
+		c.Delete(ip)
+	}
+}
+
+func (s *methodsSignal) Reset() {
+	s.counter.Clear()
+	for _, c := range s.methods {
+		c.Clear()
+	}
+}
+
+func (s *methodsSignal) Rotate() {
+	s.counter.Rotate()
+	for _, c := range s.methods {
+		c.Rotate()
+	}
+}
@@ -0,0 +1,495 @@
+package analyzer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hitsSignal scores every request, distinct or not, against limit.
+type hitsSignal struct {
+	limit  int
+	hits   map[string]int
+	resets int
+}
+
+func newHitsSignal(limit int) *hitsSignal {
+	return &hitsSignal{limit: limit, hits: make(map[string]int)}
+}
+
+func (s *hitsSignal) Name() string { return "hits" }
+
+func (s *hitsSignal) Observe(o Observation) float64 {
+	s.hits[o.IP]++
+	return float64(s.hits[o.IP]) / float64(s.limit)
+}
+
+func (s *hitsSignal) Forget(ip string) { delete(s.hits, ip) }
+
+func (s *hitsSignal) Reset() {
+	s.hits = make(map[string]int)
+	s.resets++
+}
+
+func TestAnalyzer_Signal(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 100,
+		QueueCap:      100,
+		Signals:       []Signal{newHitsSignal(5)},
+	})
+	defer a.Close()
+
+	// One page hammered: the pages signal never trips, hits does
+	for i := 0; i < 5; i++ {
+		a.Record("192.168.1.1", "/search")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if !a.Blocked("192.168.1.1") {
+		t.Error("custom signal should block")
+	}
+
+	events := a.Events()
+	if len(events) != 1 || events[0].Score < 1 || events[0].Pages != 1 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_Signal_Combined(t *testing.T) {
+	a := New(Config{
+		Window:         time.Hour,
+		PageThreshold:  4,
+		QueueCap:       100,
+		Signals:        []Signal{Weight(newHitsSignal(4), 0.5)},
+		ScoreThreshold: 1,
+	})
+	defer a.Close()
+
+	// Two distinct pages (0.5) plus two hits at half weight (0.25)
+	a.Record("192.168.1.1", "/a")
+	a.Record("192.168.1.1", "/b")
+	time.Sleep(50 * time.Millisecond)
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("score below the threshold should not block")
+	}
+
+	// A third page: 0.75 + 0.375
+	a.Record("192.168.1.1", "/c")
+	time.Sleep(50 * time.Millisecond)
+	if !a.Blocked("192.168.1.1") {
+		t.Error("combined score should block before either signal alone")
+	}
+}
+
+func TestAnalyzer_Signal_Reset(t *testing.T) {
+	hits := newHitsSignal(10)
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 100,
+		QueueCap:      100,
+		Signals:       []Signal{hits},
+	})
+	a.Close()
+
+	a.shards[0].rotate()
+	if hits.resets != 1 {
+		t.Errorf("signals should be reset on rotation, got %d", hits.resets)
+	}
+}
+
+func TestWeight(t *testing.T) {
+	s := Weight(newHitsSignal(1), 2)
+	if s.Name() != "hits" {
+		t.Errorf("weighted signal should keep the name, got %q", s.Name())
+	}
+	if got := s.Observe(Observation{IP: "a"}); got != 2 {
+		t.Errorf("expected weighted score 2, got %v", got)
+	}
+}
+
+func TestAnalyzer_RequestThreshold(t *testing.T) {
+	a := New(Config{
+		Window:           time.Hour,
+		PageThreshold:    100,
+		RequestThreshold: 5,
+		QueueCap:         100,
+	})
+	defer a.Close()
+
+	for i := 0; i < 4; i++ {
+		a.Record("192.168.1.1", "/search")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("below the request threshold should not block")
+	}
+
+	a.Record("192.168.1.1", "/search")
+	time.Sleep(50 * time.Millisecond)
+	if !a.Blocked("192.168.1.1") {
+		t.Error("hammering one URL should block at the request threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Requests != 5 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_ErrorThreshold(t *testing.T) {
+	a := New(Config{
+		Window:         time.Hour,
+		PageThreshold:  100,
+		ErrorThreshold: 3,
+		QueueCap:       100,
+	})
+	defer a.Close()
+
+	a.RecordResponse("192.168.1.1", 200)
+	a.RecordResponse("192.168.1.1", 404)
+	a.RecordResponse("192.168.1.1", 401)
+	time.Sleep(50 * time.Millisecond)
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("below the error threshold should not block")
+	}
+
+	a.RecordResponse("192.168.1.1", 500)
+	time.Sleep(50 * time.Millisecond)
+	if !a.Blocked("192.168.1.1") {
+		t.Error("error responses should block at the error threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Errors != 3 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_HeaderThreshold(t *testing.T) {
+	a := New(Config{
+		Window:          time.Hour,
+		PageThreshold:   100,
+		HeaderThreshold: 2,
+		QueueCap:        100,
+		Sync:            true,
+	})
+	defer a.Close()
+
+	a.RecordHeaders("192.168.1.1", "/a", 0, 1, false)
+	a.RecordHeaders("192.168.1.1", "/b", 0, 2, true)
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("below the header threshold should not block")
+	}
+	if st := a.Inspect("192.168.1.1"); st.Mismatches != 1 || st.Pages != 2 {
+		t.Errorf("expected 1 mismatch in 2 pages, got %+v", st)
+	}
+
+	a.RecordHeaders("192.168.1.1", "/b", 0, 2, true)
+	if !a.Blocked("192.168.1.1") {
+		t.Error("mismatching headers should block at the header threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Mismatches != 2 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_SequenceThreshold(t *testing.T) {
+	a := New(Config{
+		Window:            time.Hour,
+		PageThreshold:     100,
+		SequenceThreshold: 4,
+		PathNormalizer:    NormalizePath,
+		QueueCap:          100,
+		Sync:              true,
+	})
+	defer a.Close()
+
+	// Reading items back and forth, with reviews in between, is not
+	// enumerating them
+	for _, path := range []string{"/item/7", "/item/8", "/item/7", "/item/8", "/item/8/reviews", "/item/42", "/item/43?ref=x"} {
+		a.Record("192.168.1.1", path)
+	}
+	if st := a.Inspect("192.168.1.1"); st.Sequential != 2 {
+		t.Errorf("expected 2 sequential IDs, got %+v", st)
+	}
+
+	// Enumerating items, skipping a deleted one, interleaved with their
+	// reviews
+	for _, path := range []string{"/item/1001", "/item/1001/reviews/1", "/item/1002", "/item/1004", "/item/1004/reviews/2", "/item/1005"} {
+		a.Record("192.168.1.2", path)
+	}
+	if a.Blocked("192.168.1.2") {
+		t.Fatal("below the sequence threshold should not block")
+	}
+	a.Record("192.168.1.2", "/item/1006")
+	if !a.Blocked("192.168.1.2") {
+		t.Error("sequential IDs should block at the sequence threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Sequential != 4 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_QueryThreshold(t *testing.T) {
+	a := New(Config{
+		Window:         time.Hour,
+		PageThreshold:  100,
+		QueryThreshold: 3,
+		QueueCap:       100,
+		Sync:           true,
+	})
+	defer a.Close()
+
+	// Query strings of different pages, and the same one again, count
+	// apart
+	for _, path := range []string{"/search?q=a", "/search?q=b", "/search?q=a", "/list?page=2", "/list?page=3", "/list#top", "/about"} {
+		a.Record("192.168.1.1", path)
+	}
+	if st := a.Inspect("192.168.1.1"); st.Queries != 2 || st.Pages != 3 || st.Blocked {
+		t.Errorf("expected 2 query strings of a page, got %+v", st)
+	}
+
+	// A cache buster
+	for _, path := range []string{"/api/items?_=1", "/api/items?_=2", "/api/items?_=3"} {
+		a.Record("192.168.1.2", path)
+	}
+	if !a.Blocked("192.168.1.2") {
+		t.Error("query strings of a page should block at the query threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Queries != 3 || events[0].Pages != 1 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_QueryThreshold_Bloom(t *testing.T) {
+	a := New(Config{
+		Window:         time.Hour,
+		PageThreshold:  100,
+		QueryThreshold: 1 << 20,
+		QueueCap:       100,
+		Sync:           true,
+		Workers:        1,
+		BloomCapacity:  100,
+	})
+	defer a.Close()
+
+	// Query strings filling a bloom filter don't hide new pages
+	for i := range 10000 {
+		a.Record("192.168.1.1", "/search?q="+strconv.Itoa(i))
+	}
+	for i := range 50 {
+		a.Record("192.168.1.2", "/page/"+strconv.Itoa(i))
+	}
+	if st := a.Inspect("192.168.1.2"); st.Pages < 45 {
+		t.Errorf("expected the new pages counted, got %+v", st)
+	}
+}
+
+func TestAnalyzer_RecordVisit_Weight(t *testing.T) {
+	a := New(Config{
+		Window:           time.Hour,
+		PageThreshold:    10,
+		RequestThreshold: 20,
+		QueueCap:         100,
+		Sync:             true,
+	})
+	defer a.Close()
+
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/signup", Weight: 5})
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/signup", Weight: 5})
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/", Weight: 0})
+	if st := a.Inspect("192.168.1.1"); st.Pages != 6 || st.Requests != 11 {
+		t.Errorf("expected 6 pages and 11 requests weighted, got %+v", st)
+	}
+
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/contact", Weight: 5})
+	if !a.Blocked("192.168.1.1") {
+		t.Error("weighted pages should block at the page threshold")
+	}
+}
+
+func TestAnalyzer_RecordVisit_MethodThreshold(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 100,
+		QueueCap:      100,
+		Sync:          true,
+	})
+	defer a.Close()
+
+	for _, path := range []string{"/a", "/b", "/c", "/d"} {
+		a.RecordVisit(Visit{IP: "192.168.1.1", Path: path})
+	}
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/comment", MethodThreshold: 3})
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/comment", MethodThreshold: 3})
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("requests without a method threshold should not count")
+	}
+	if st := a.Inspect("192.168.1.1"); st.Methods != 2 {
+		t.Errorf("expected 2 requests with a method threshold, got %+v", st)
+	}
+
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/comment", MethodThreshold: 3})
+	if !a.Blocked("192.168.1.1") {
+		t.Error("requests should block at their method threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Methods != 3 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_RecordVisit_MethodThreshold_PerMethod(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 100,
+		QueueCap:      100,
+		Sync:          true,
+	})
+	defer a.Close()
+
+	// Each method counts against its own threshold only
+	for range 5 {
+		a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/item", Method: "PUT", MethodThreshold: 10})
+	}
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/signup", Method: "POST", MethodThreshold: 2})
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("requests of other methods should not count against the threshold")
+	}
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/signup", Method: "POST", MethodThreshold: 2})
+	if !a.Blocked("192.168.1.1") {
+		t.Error("requests should block at their method's threshold")
+	}
+}
+
+func TestAnalyzer_RecordResponse_NotPages(t *testing.T) {
+	a := New(Config{
+		Window:           time.Hour,
+		PageThreshold:    4,
+		RequestThreshold: 4,
+		QueueCap:         100,
+	})
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/")
+	for i := 0; i < 5; i++ {
+		a.RecordResponse("192.168.1.1", 200)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("responses should not count as pages or requests")
+	}
+}
+
+func TestAnalyzer_LoginThreshold(t *testing.T) {
+	a := New(Config{
+		Window:         time.Hour,
+		PageThreshold:  100,
+		ErrorThreshold: 100,
+		LoginThreshold: 2,
+		QueueCap:       100,
+	})
+	defer a.Close()
+
+	a.RecordLoginFailure("192.168.1.1", 401)
+	time.Sleep(50 * time.Millisecond)
+	if a.Blocked("192.168.1.1") {
+		t.Fatal("below the login threshold should not block")
+	}
+
+	a.RecordLoginFailure("192.168.1.1", 401)
+	time.Sleep(50 * time.Millisecond)
+	if !a.Blocked("192.168.1.1") {
+		t.Error("failed logins should block at the login threshold")
+	}
+	if events := a.Events(); len(events) != 1 || events[0].Logins != 2 || events[0].Errors != 0 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestAnalyzer_Greylist(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		greylisted []string
+	)
+	a := New(Config{
+		Window:            time.Hour,
+		PageThreshold:     4,
+		GreylistThreshold: 0.5,
+		QueueCap:          100,
+		OnGreylist: func(ip string, score float64) {
+			mu.Lock()
+			defer mu.Unlock()
+			greylisted = append(greylisted, ip)
+		},
+	})
+	defer a.Close()
+
+	a.Record("192.168.1.1", "/1")
+	time.Sleep(50 * time.Millisecond)
+	if a.Greylisted("192.168.1.1") {
+		t.Fatal("below the greylist threshold should not greylist")
+	}
+
+	a.Record("192.168.1.1", "/2")
+	a.Record("192.168.1.1", "/3")
+	time.Sleep(50 * time.Millisecond)
+	if !a.Greylisted("192.168.1.1") || a.Blocked("192.168.1.1") {
+		t.Fatal("halfway to the threshold should greylist, not block")
+	}
+	mu.Lock()
+	if len(greylisted) != 1 {
+		t.Errorf("callback should run once, got %v", greylisted)
+	}
+	mu.Unlock()
+	if s := a.Stats(); s.Greylist != 1 {
+		t.Errorf("expected 1 greylisted IP, got %d", s.Greylist)
+	}
+
+	a.Record("192.168.1.1", "/4")
+	time.Sleep(50 * time.Millisecond)
+	if a.Greylisted("192.168.1.1") || !a.Blocked("192.168.1.1") {
+		t.Error("continued behavior should promote to a block")
+	}
+}
+
+func TestAnalyzer_SlidingWindow(t *testing.T) {
+	for _, sliding := range []bool{false, true} {
+		a := New(Config{
+			Window:        200 * time.Millisecond,
+			PageThreshold: 4,
+			QueueCap:      100,
+			SlidingWindow: sliding,
+		})
+
+		// Just below the threshold, then right after the boundary
+		for _, path := range []string{"/1", "/2", "/3"} {
+			a.Record("192.168.1.1", path)
+		}
+		time.Sleep(220 * time.Millisecond)
+		a.Record("192.168.1.1", "/4")
+		a.Record("192.168.1.1", "/5")
+		time.Sleep(20 * time.Millisecond)
+
+		if blocked := a.Blocked("192.168.1.1"); blocked != sliding {
+			t.Errorf("sliding=%v: expected blocked=%v", sliding, sliding)
+		}
+		a.Close()
+	}
+}
+
+func TestWeight_Rotate(t *testing.T) {
+	s := &requestsSignal{counter: NewCounter(), threshold: func() int { return 2 }}
+	w := Weight(s, 2)
+
+	w.Observe(Observation{IP: "192.168.1.1"})
+	w.(Rotator).Rotate()
+	if score := w.Observe(Observation{IP: "192.168.1.1", Status: 200, Previous: 0.5}); score != 0.5 {
+		t.Errorf("expected (0 + 1*0.5) / 2 * 2, got %v", score)
+	}
+
+	// Signals that are not Rotators are reset
+	h := newHitsSignal(1)
+	Weight(h, 1).(Rotator).Rotate()
+	if h.resets != 1 {
+		t.Errorf("expected 1 reset, got %d", h.resets)
+	}
+}
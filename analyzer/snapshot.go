@@ -0,0 +1,153 @@
+package analyzer
+
+import (
+	"math"
+	"time"
+)
+
+// Snapshot is a serializable copy of the analyzer state, to migrate it
+// between instances or checkpoint it, see Analyzer.Snapshot.
+type Snapshot struct {
+	Time      time.Time `json:"time"`
+	Blocklist []Entry   `json:"blocklist"`
+
+	// Offenses are the offense counts, with a penalty schedule.
+	Offenses []Offense `json:"offenses,omitempty"`
+
+	// Counts are the current window's counts per IP.
+	Counts []Count `json:"counts,omitempty"`
+}
+
+// Count is an IP's counts in the current window. Pages are only kept
+// without HyperLogLog, and the pages visited are not: a page visited
+// before the snapshot counts again when visited after a restore.
+type Count struct {
+	IP         string `json:"ip"`
+	Pages      uint16 `json:"pages,omitempty"`
+	Requests   uint16 `json:"requests,omitempty"`
+	Errors     uint16 `json:"errors,omitempty"`
+	Logins     uint16 `json:"logins,omitempty"`
+	Mismatches uint16 `json:"mismatches,omitempty"`
+	Sequential uint16 `json:"sequential,omitempty"`
+	Queries    uint16 `json:"queries,omitempty"`
+
+	// Methods are the requests counted per method, with method
+	// thresholds.
+	Methods map[string]uint16 `json:"methods,omitempty"`
+}
+
+// Snapshot returns a copy of the blocklist, offenses and counts. Custom
+// signals are not included.
+func (a *Analyzer) Snapshot() Snapshot {
+	s := Snapshot{Time: a.cfg.Clock.Now(), Blocklist: []Entry{}}
+	a.store.Range(func(ip string, until time.Time) bool {
+		s.Blocklist = append(s.Blocklist, Entry{IP: ip, Until: until})
+		return true
+	})
+	if a.penalties != nil {
+		s.Offenses = a.penalties.list()
+	}
+	for _, sh := range a.shards {
+		s.Counts = sh.counts(s.Counts)
+	}
+	return s
+}
+
+// Restore adds the state in s, e.g. from another instance's Snapshot.
+// Blocks keep their expiry, those already expired are skipped. Offenses
+// are dropped without a penalty schedule, and counts without the
+// matching signal.
+func (a *Analyzer) Restore(s Snapshot) error {
+	now := a.cfg.Clock.Now()
+	for _, e := range s.Blocklist {
+		var ttl time.Duration
+		if !e.Until.IsZero() {
+			if ttl = e.Until.Sub(now); ttl <= 0 {
+				continue
+			}
+		}
+		if err := a.store.Block(e.IP, ttl); err != nil {
+			return err
+		}
+	}
+	if a.penalties != nil {
+		a.penalties.restore(s.Offenses)
+	}
+	for _, c := range s.Counts {
+		a.shard(c.IP).restore(c)
+	}
+	return nil
+}
+
+// counts appends the shard's counts to counts.
+func (s *shard) counts(counts []Count) []Count {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := make(map[string]int)
+	add := func(c *Counter, set func(*Count, uint16)) {
+		if c == nil {
+			return
+		}
+		for ip, n := range c.data {
+			i, ok := index[ip]
+			if !ok {
+				i = len(counts)
+				index[ip] = i
+				counts = append(counts, Count{IP: ip})
+			}
+			set(&counts[i], n)
+		}
+	}
+	if s.hll == nil {
+		add(s.counter, func(c *Count, n uint16) { c.Pages = n })
+	}
+	add(s.requests, func(c *Count, n uint16) { c.Requests = n })
+	add(s.errors, func(c *Count, n uint16) { c.Errors = n })
+	add(s.logins, func(c *Count, n uint16) { c.Logins = n })
+	add(s.headers, func(c *Count, n uint16) { c.Mismatches = n })
+	add(s.steps, func(c *Count, n uint16) { c.Sequential = n })
+	add(s.queries, func(c *Count, n uint16) { c.Queries = n })
+	for method, counter := range s.byMethod {
+		add(counter, func(c *Count, n uint16) {
+			if c.Methods == nil {
+				c.Methods = make(map[string]uint16)
+			}
+			c.Methods[method] = n
+		})
+	}
+	return counts
+}
+
+// restore sets the counts of c.IP.
+func (s *shard) restore(c Count) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	set := func(counter *Counter, n uint16) {
+		if counter != nil && n > 0 {
+			counter.Set(c.IP, n)
+		}
+	}
+	if s.hll == nil {
+		set(s.counter, c.Pages)
+	}
+	set(s.requests, c.Requests)
+	set(s.errors, c.Errors)
+	set(s.logins, c.Logins)
+	set(s.headers, c.Mismatches)
+	set(s.steps, c.Sequential)
+	set(s.queries, c.Queries)
+
+	var sum uint16
+	for method, n := range c.Methods {
+		counter := s.byMethod[method]
+		if counter == nil {
+			counter = NewCounter()
+			s.byMethod[method] = counter
+		}
+		set(counter, n)
+		sum += min(n, math.MaxUint16-sum)
+	}
+	set(s.methods, sum)
+}
@@ -0,0 +1,113 @@
+package analyzer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAnalyzer_Snapshot(t *testing.T) {
+	cfg := Config{
+		Window:           time.Hour,
+		PageThreshold:    3,
+		RequestThreshold: 100,
+		QueueCap:         100,
+		PenaltySchedule:  []time.Duration{time.Minute, time.Hour},
+		Sync:             true,
+	}
+	a := New(cfg)
+	defer a.Close()
+
+	a.Block("10.0.0.1", 0)
+	a.Block("10.0.0.2", time.Hour)
+	a.Trap("10.0.0.3", "/trap") // first offense, blocked for a minute
+	a.Record("192.168.1.1", "/a")
+	a.Record("192.168.1.1", "/b")
+
+	s := a.Snapshot()
+	if len(s.Blocklist) != 3 || len(s.Offenses) != 1 || len(s.Counts) != 1 {
+		t.Fatalf("unexpected snapshot %+v", s)
+	}
+	if c := s.Counts[0]; c.IP != "192.168.1.1" || c.Pages != 2 || c.Requests != 2 {
+		t.Errorf("unexpected counts %+v", c)
+	}
+
+	b := New(Config{Window: time.Hour, PageThreshold: 3, RequestThreshold: 100, QueueCap: 100, PenaltySchedule: cfg.PenaltySchedule, Sync: true, Workers: 2})
+	defer b.Close()
+	if err := b.Restore(s); err != nil {
+		t.Fatalf("Restore() returned error: %v", err)
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if !b.Blocked(ip) {
+			t.Errorf("%s should be blocked after restoring", ip)
+		}
+	}
+
+	// The restored counts carry on
+	b.Record("192.168.1.1", "/c")
+	if !b.Blocked("192.168.1.1") {
+		t.Error("third page after restoring should block")
+	}
+
+	// So do the offenses: the second trap escalates to an hour
+	b.Trap("10.0.0.3", "/trap")
+	if e := b.Events()[0]; e.Offenses != 2 || e.Duration != time.Hour {
+		t.Errorf("expected the second offense for an hour, got %+v", e)
+	}
+}
+
+func TestAnalyzer_Snapshot_Methods(t *testing.T) {
+	cfg := Config{Window: time.Hour, PageThreshold: 100, QueueCap: 100, Sync: true}
+	a := New(cfg)
+	defer a.Close()
+
+	for range 3 {
+		a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/item", Method: "PUT", MethodThreshold: 10})
+	}
+	a.RecordVisit(Visit{IP: "192.168.1.1", Path: "/signup", Method: "POST", MethodThreshold: 2})
+
+	s := a.Snapshot()
+	if len(s.Counts) != 1 || s.Counts[0].Methods["PUT"] != 3 || s.Counts[0].Methods["POST"] != 1 {
+		t.Fatalf("expected the counts per method, got %+v", s.Counts)
+	}
+
+	b := New(cfg)
+	defer b.Close()
+	if err := b.Restore(s); err != nil {
+		t.Fatalf("Restore() returned error: %v", err)
+	}
+
+	// The restored POSTs carry on against their own threshold
+	b.RecordVisit(Visit{IP: "192.168.1.1", Path: "/signup", Method: "POST", MethodThreshold: 2})
+	if !b.Blocked("192.168.1.1") {
+		t.Error("second POST after restoring should block")
+	}
+	if e := b.Events()[0]; e.Methods != 5 {
+		t.Errorf("expected the methods summed in the event, got %d", e.Methods)
+	}
+}
+
+func TestAnalyzer_Restore_Expired(t *testing.T) {
+	a := New(Config{Window: time.Hour, PageThreshold: 3, QueueCap: 100})
+	defer a.Close()
+
+	err := a.Restore(Snapshot{Blocklist: []Entry{
+		{IP: "10.0.0.1", Until: time.Now().Add(-time.Minute)},
+		{IP: "10.0.0.2", Until: time.Now().Add(time.Minute)},
+	}})
+	if err != nil {
+		t.Fatalf("Restore() returned error: %v", err)
+	}
+	if a.Blocked("10.0.0.1") {
+		t.Error("expired blocks should be skipped")
+	}
+
+	var until time.Time
+	a.Range(func(ip string, u time.Time) bool {
+		until = u
+		return true
+	})
+	if d := time.Until(until); d <= 59*time.Second || d > time.Minute {
+		t.Errorf("restored block should keep its expiry, got %v left", d)
+	}
+}
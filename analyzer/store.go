@@ -0,0 +1,209 @@
+package analyzer
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Store holds the blocklist. Implementations must be safe for concurrent use.
+// Blocked is called on the request hot path and should not do network I/O.
+type Store interface {
+	Blocked(ip string) bool
+
+	// Block adds ip to the blocklist for ttl. A ttl <= 0 never expires.
+	Block(ip string, ttl time.Duration) error
+
+	// Unblock removes ip from the blocklist.
+	Unblock(ip string) error
+
+	// Expire removes entries that expired before now.
+	Expire(now time.Time) error
+
+	// Len returns the number of blocked IPs.
+	Len() int
+
+	// Range calls fn for each blocked IP and the time its block ends
+	// (zero never expires), until fn returns false.
+	Range(fn func(ip string, until time.Time) bool)
+}
+
+// Entry is a blocked IP.
+type Entry struct {
+	IP    string    `json:"ip"`
+	Until time.Time `json:"until"` // zero never expires
+}
+
+// MemoryStore is an in-process blocklist backed by a copy-on-write map.
+// Reads are lock-free, writes are serialized.
+type MemoryStore struct {
+	mu    sync.Mutex
+	m     atomic.Pointer[map[string]time.Time]
+	clock Clock
+
+	// Most IPs blocked at once, 0 for no limit, see SetMaxLen
+	maxLen  int
+	evicted atomic.Uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return NewMemoryStoreWithClock(SystemClock)
+}
+
+// NewMemoryStoreWithClock returns a MemoryStore timing blocks with clock.
+func NewMemoryStoreWithClock(clock Clock) *MemoryStore {
+	s := &MemoryStore{clock: clock}
+	m := make(map[string]time.Time)
+	s.m.Store(&m)
+	return s
+}
+
+// newMemoryStore returns a MemoryStore timing blocks with clock, holding
+// at most maxLen IPs.
+func newMemoryStore(clock Clock, maxLen int) *MemoryStore {
+	s := NewMemoryStoreWithClock(clock)
+	s.maxLen = maxLen
+	return s
+}
+
+func (s *MemoryStore) Blocked(ip string) bool {
+	_, exists := (*s.m.Load())[ip]
+	return exists
+}
+
+func (s *MemoryStore) Len() int {
+	return len(*s.m.Load())
+}
+
+func (s *MemoryStore) Range(fn func(ip string, until time.Time) bool) {
+	for ip, until := range *s.m.Load() {
+		if !fn(ip, until) {
+			return
+		}
+	}
+}
+
+// SetMaxLen bounds the IPs blocked at once to n, so a distributed
+// attack can't grow the blocklist without bound; n <= 0 doesn't. Past
+// it, a new block evicts the expired blocks, then those ending soonest,
+// blocks that never expire last. Evicted counts the blocks evicted
+// before their end.
+func (s *MemoryStore) SetMaxLen(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxLen = n
+}
+
+// Evicted returns the number of blocks evicted before their end to keep
+// within SetMaxLen.
+func (s *MemoryStore) Evicted() uint64 {
+	return s.evicted.Load()
+}
+
+func (s *MemoryStore) Block(ip string, ttl time.Duration) error {
+	var until time.Time
+	if ttl > 0 {
+		until = s.clock.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.m.Load()
+	if cur, exists := old[ip]; exists && cur.Equal(until) {
+		return nil
+	}
+
+	m := make(map[string]time.Time, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[ip] = until
+	if s.maxLen > 0 && len(m) > s.maxLen {
+		s.evict(m, ip)
+	}
+
+	s.m.Store(&m)
+	return nil
+}
+
+// evict removes blocks from m, but ip's, down to the maximum length: the
+// expired ones, then those ending soonest.
+func (s *MemoryStore) evict(m map[string]time.Time, ip string) {
+	now := s.clock.Now()
+	for k, until := range m {
+		if isExpired(until, now) {
+			delete(m, k)
+		}
+	}
+	for len(m) > s.maxLen {
+		var victim string
+		var soonest time.Time
+		for k, until := range m {
+			if k == ip {
+				continue
+			}
+			if victim == "" || (!until.IsZero() && (soonest.IsZero() || until.Before(soonest))) {
+				victim, soonest = k, until
+			}
+		}
+		if victim == "" {
+			return
+		}
+		delete(m, victim)
+		s.evicted.Add(1)
+	}
+}
+
+func (s *MemoryStore) Unblock(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.m.Load()
+	if _, exists := old[ip]; !exists {
+		return nil
+	}
+
+	m := make(map[string]time.Time, len(old))
+	for k, v := range old {
+		if k != ip {
+			m[k] = v
+		}
+	}
+
+	s.m.Store(&m)
+	return nil
+}
+
+func (s *MemoryStore) Expire(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.m.Load()
+
+	expired := 0
+	for _, until := range old {
+		if isExpired(until, now) {
+			expired++
+		}
+	}
+	if expired == 0 {
+		return nil
+	}
+
+	m := make(map[string]time.Time, len(old)-expired)
+	for k, v := range old {
+		if !isExpired(v, now) {
+			m[k] = v
+		}
+	}
+
+	s.m.Store(&m)
+	return nil
+}
+
+// isExpired reports whether a block ending at until is over. A zero until
+// never expires.
+func isExpired(until, now time.Time) bool {
+	return !until.IsZero() && !now.Before(until)
+}
@@ -0,0 +1,249 @@
+package analyzer
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_Block(t *testing.T) {
+	s := NewMemoryStore()
+
+	if s.Blocked("192.168.1.1") {
+		t.Error("new store should be empty")
+	}
+
+	if err := s.Block("192.168.1.1", 0); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	if !s.Blocked("192.168.1.1") {
+		t.Error("IP should be blocked")
+	}
+
+	if s.Blocked("192.168.1.2") {
+		t.Error("other IPs should not be blocked")
+	}
+}
+
+func TestMemoryStore_Block_AlreadyBlocked(t *testing.T) {
+	s := NewMemoryStore()
+
+	s.Block("192.168.1.1", 0)
+	before := s.m.Load()
+	s.Block("192.168.1.1", 0)
+
+	if s.m.Load() != before {
+		t.Error("blocking an already blocked IP should not copy the map")
+	}
+}
+
+func TestMemoryStore_Concurrent(t *testing.T) {
+	s := NewMemoryStore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ip := "10.0.0." + strconv.Itoa(i)
+			s.Block(ip, 0)
+			s.Blocked(ip)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 100; i++ {
+		if !s.Blocked("10.0.0." + strconv.Itoa(i)) {
+			t.Errorf("IP 10.0.0.%d should be blocked", i)
+		}
+	}
+}
+
+func TestAnalyzer_CustomStore(t *testing.T) {
+	s := NewMemoryStore()
+	s.Block("192.168.1.1", 0)
+
+	a := New(Config{
+		Window:        time.Minute,
+		PageThreshold: 50,
+		QueueCap:      1000,
+		Store:         s,
+	})
+	defer a.Close()
+
+	if !a.Blocked("192.168.1.1") {
+		t.Error("analyzer should read from the custom store")
+	}
+}
+
+func TestMemoryStore_Expire(t *testing.T) {
+	s := NewMemoryStore()
+
+	s.Block("192.168.1.1", time.Minute)
+	s.Block("192.168.1.2", 0)
+
+	if err := s.Expire(time.Now()); err != nil {
+		t.Fatalf("Expire() returned error: %v", err)
+	}
+	if !s.Blocked("192.168.1.1") {
+		t.Error("IP should stay blocked before its TTL")
+	}
+
+	s.Expire(time.Now().Add(2 * time.Minute))
+
+	if s.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked after its TTL")
+	}
+	if !s.Blocked("192.168.1.2") {
+		t.Error("IP without TTL should never expire")
+	}
+}
+
+func TestMemoryStore_Expire_Nothing(t *testing.T) {
+	s := NewMemoryStore()
+	s.Block("192.168.1.1", time.Minute)
+
+	before := s.m.Load()
+	s.Expire(time.Now())
+
+	if s.m.Load() != before {
+		t.Error("sweep without expired entries should not copy the map")
+	}
+}
+
+func TestAnalyzer_BlockDuration(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 50,
+		QueueCap:      1000,
+		BlockDuration: 100 * time.Millisecond,
+	})
+	defer a.Close()
+
+	if a.cfg.SweepInterval != 100*time.Millisecond {
+		t.Errorf("sweep interval should be capped at block duration, got %v", a.cfg.SweepInterval)
+	}
+
+	a.block("192.168.1.1", a.Thresholds().BlockDuration)
+	if !a.Blocked("192.168.1.1") {
+		t.Fatal("IP should be blocked")
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if a.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked by the sweep after the block duration")
+	}
+}
+
+func TestMemoryStore_Unblock(t *testing.T) {
+	s := NewMemoryStore()
+	s.Block("192.168.1.1", 0)
+	s.Block("192.168.1.2", 0)
+
+	if err := s.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+
+	if s.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked")
+	}
+	if !s.Blocked("192.168.1.2") {
+		t.Error("other IPs should stay blocked")
+	}
+
+	if err := s.Unblock("10.0.0.1"); err != nil {
+		t.Errorf("unblocking an unknown IP should be a no-op, got %v", err)
+	}
+}
+
+func TestMemoryStore_Len(t *testing.T) {
+	s := NewMemoryStore()
+	s.Block("192.168.1.1", 0)
+	s.Block("192.168.1.2", 0)
+	s.Block("192.168.1.2", 0)
+
+	if n := s.Len(); n != 2 {
+		t.Errorf("expected 2, got %d", n)
+	}
+}
+
+func TestMemoryStore_Range(t *testing.T) {
+	s := NewMemoryStore()
+	s.Block("192.168.1.1", time.Minute)
+	s.Block("192.168.1.2", 0)
+
+	got := make(map[string]time.Time)
+	s.Range(func(ip string, until time.Time) bool {
+		got[ip] = until
+		return true
+	})
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(got))
+	}
+	if got["192.168.1.1"].IsZero() {
+		t.Error("expiring block should have an end time")
+	}
+	if !got["192.168.1.2"].IsZero() {
+		t.Error("permanent block should have a zero end time")
+	}
+
+	n := 0
+	s.Range(func(ip string, until time.Time) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("Range should stop when fn returns false, got %d calls", n)
+	}
+}
+
+func TestMemoryStore_SetMaxLen(t *testing.T) {
+	clock := NewManualClock(time.Now())
+	s := NewMemoryStoreWithClock(clock)
+	s.SetMaxLen(3)
+
+	s.Block("192.168.1.1", 0)
+	s.Block("192.168.1.2", time.Hour)
+	s.Block("192.168.1.3", time.Minute)
+	s.Block("192.168.1.4", 2*time.Hour)
+
+	// The block ending soonest makes room
+	if s.Len() != 3 || s.Blocked("192.168.1.3") || s.Evicted() != 1 {
+		t.Errorf("expected 192.168.1.3 evicted, got len %d, evicted %d", s.Len(), s.Evicted())
+	}
+
+	// Expired blocks go first, without counting as evicted
+	clock.Advance(90 * time.Minute)
+	s.Block("192.168.1.5", time.Minute)
+	if s.Len() != 3 || s.Blocked("192.168.1.2") || s.Evicted() != 1 {
+		t.Errorf("expected the expired 192.168.1.2 removed, got len %d, evicted %d", s.Len(), s.Evicted())
+	}
+
+	// Blocks that never expire go last, and the new block stays
+	s.Block("192.168.1.6", time.Minute)
+	s.Block("192.168.1.7", time.Minute)
+	for _, ip := range []string{"192.168.1.1", "192.168.1.7"} {
+		if !s.Blocked(ip) {
+			t.Errorf("%s should stay blocked", ip)
+		}
+	}
+	if s.Len() != 3 || s.Evicted() != 3 {
+		t.Errorf("expected len 3, 3 evicted, got len %d, evicted %d", s.Len(), s.Evicted())
+	}
+}
+
+func TestAnalyzer_MaxBlocklist(t *testing.T) {
+	a := New(Config{Window: time.Minute, PageThreshold: 1, QueueCap: 100, Sync: true, MaxBlocklist: 2})
+	defer a.Close()
+
+	for i := 0; i < 5; i++ {
+		a.Block("192.168.1."+strconv.Itoa(i), time.Hour)
+	}
+	if st := a.Stats(); st.Blocklist != 2 || st.Evicted != 3 {
+		t.Errorf("expected 2 blocked and 3 evicted, got %+v", st)
+	}
+}
@@ -0,0 +1,111 @@
+package analyzer
+
+import (
+	"math"
+	"net/netip"
+)
+
+// DefaultMaxSubnets bounds the prefixes and autonomous systems a
+// SubnetSignal counts per window, as Counter bounds the IPs.
+var DefaultMaxSubnets = 100000
+
+// subnetKey is a prefix, or an autonomous system.
+type subnetKey struct {
+	prefix netip.Prefix
+	asn    uint32
+}
+
+// SubnetSignal scores the distinct pages of an IP's prefix, its /24 or
+// /48 by default, and of its autonomous system, per window: a
+// distributed scraper keeping each IP below the page threshold still
+// adds up across its range. Keys that are not IPs score 0.
+type SubnetSignal struct {
+	prefixThreshold int
+	asnThreshold    int
+	asn             func(netip.Addr) uint32
+	lenV4, lenV6    int
+
+	// Distinct pages by subnet in the window, and the previous one, see
+	// Rotate
+	counts map[subnetKey]int
+	prev   map[subnetKey]int
+}
+
+// NewSubnetSignal returns a signal scoring 1 at prefixThreshold distinct
+// pages of an IP's prefix, or asnThreshold of its autonomous system,
+// which asn returns, 0 if unknown. A threshold <= 0, or a nil asn,
+// disables the count. Prefixes are DefaultPrefixLenV4 and
+// DefaultPrefixLenV6 bits long.
+//
+// The signal is shared by the IPs of a subnet: add it to Config.Signals,
+// so that with Workers it is shared by the workers too.
+func NewSubnetSignal(prefixThreshold, asnThreshold int, asn func(netip.Addr) uint32) *SubnetSignal {
+	if asn == nil {
+		asnThreshold = 0
+	}
+	return &SubnetSignal{
+		prefixThreshold: prefixThreshold,
+		asnThreshold:    asnThreshold,
+		asn:             asn,
+		lenV4:           DefaultPrefixLenV4,
+		lenV6:           DefaultPrefixLenV6,
+		counts:          make(map[subnetKey]int),
+	}
+}
+
+func (s *SubnetSignal) Name() string {
+	return "subnet"
+}
+
+// Observe counts o's distinct pages for its subnets and returns the
+// higher of their scores.
+func (s *SubnetSignal) Observe(o Observation) float64 {
+	addr, err := netip.ParseAddr(o.IP)
+	if err != nil {
+		return 0
+	}
+	addr = addr.Unmap()
+
+	var score float64
+	if s.prefixThreshold > 0 {
+		bits := s.lenV6
+		if addr.Is4() {
+			bits = s.lenV4
+		}
+		prefix, _ := addr.Prefix(bits)
+		score = s.observe(subnetKey{prefix: prefix}, o, s.prefixThreshold)
+	}
+	if s.asnThreshold > 0 {
+		if asn := s.asn(addr); asn != 0 {
+			score = math.Max(score, s.observe(subnetKey{asn: asn}, o, s.asnThreshold))
+		}
+	}
+	return score
+}
+
+// observe counts o's distinct page for key and returns its score.
+func (s *SubnetSignal) observe(key subnetKey, o Observation, threshold int) float64 {
+	if o.Status == 0 && o.Distinct {
+		if _, ok := s.counts[key]; ok || len(s.counts) < DefaultMaxSubnets {
+			s.counts[key]++
+		}
+	}
+	n := float64(s.counts[key])
+	if o.Previous > 0 {
+		n += float64(s.prev[key]) * o.Previous
+	}
+	return n / float64(threshold)
+}
+
+// Forget does nothing, the counts are per subnet.
+func (s *SubnetSignal) Forget(ip string) {}
+
+func (s *SubnetSignal) Reset() {
+	clear(s.counts)
+	s.prev = nil
+}
+
+func (s *SubnetSignal) Rotate() {
+	s.prev = s.counts
+	s.counts = make(map[subnetKey]int, len(s.prev))
+}
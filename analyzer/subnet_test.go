@@ -0,0 +1,84 @@
+package analyzer
+
+import (
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSubnetSignal(t *testing.T) {
+	asn := func(addr netip.Addr) uint32 {
+		if addr.Is4() && addr.As4()[0] == 198 {
+			return 64500
+		}
+		return 0
+	}
+	s := NewSubnetSignal(10, 4, asn)
+
+	var score float64
+	for i := 0; i < 10; i++ {
+		// Each IP of the prefix visits a single page
+		score = s.Observe(Observation{IP: "203.0.113." + strconv.Itoa(i), Distinct: true})
+	}
+	if score != 1 {
+		t.Errorf("expected a score of 1 at the prefix threshold, got %g", score)
+	}
+	if got := s.Observe(Observation{IP: "203.0.114.1", Distinct: true}); got != 0.1 {
+		t.Errorf("expected other prefixes to count apart, got %g", got)
+	}
+	if got := s.Observe(Observation{IP: "203.0.113.1", Status: 404}); got != 1 {
+		t.Errorf("responses should not count, got %g", got)
+	}
+
+	// The autonomous system spans prefixes
+	for _, ip := range []string{"198.51.100.1", "198.51.101.1", "198.52.0.1"} {
+		s.Observe(Observation{IP: ip, Distinct: true})
+	}
+	if got := s.Observe(Observation{IP: "198.53.0.1", Distinct: true}); got != 1 {
+		t.Errorf("expected a score of 1 at the ASN threshold, got %g", got)
+	}
+
+	if got := s.Observe(Observation{IP: "user:42", Distinct: true}); got != 0 {
+		t.Errorf("keys that are not IPs should score 0, got %g", got)
+	}
+
+	s.Rotate()
+	if got := s.Observe(Observation{IP: "203.0.113.1", Previous: 0.5}); got != 0.5 {
+		t.Errorf("expected the previous window weighted in, got %g", got)
+	}
+	s.Reset()
+	if got := s.Observe(Observation{IP: "203.0.113.1", Previous: 0.5}); got != 0 {
+		t.Errorf("expected nothing after Reset, got %g", got)
+	}
+}
+
+func TestAnalyzer_SubnetSignal(t *testing.T) {
+	a := New(Config{
+		Window:        time.Hour,
+		PageThreshold: 10,
+		QueueCap:      100,
+		Workers:       4,
+		Sync:          true,
+		Signals:       []Signal{NewSubnetSignal(12, 0, nil)},
+	})
+	defer a.Close()
+
+	// 4 IPs with 3 pages each, far below the page threshold
+	for i := 1; i <= 4; i++ {
+		ip := "203.0.113." + strconv.Itoa(i)
+		for _, path := range []string{"/a", "/b", "/c"} {
+			a.Record(ip, path)
+		}
+	}
+	if !a.Blocked("203.0.113.4") {
+		t.Error("the IP reaching the prefix threshold should be blocked")
+	}
+	a.Record("203.0.113.1", "/d")
+	if !a.Blocked("203.0.113.1") {
+		t.Error("other IPs of the prefix should be blocked on their next page")
+	}
+	if a.Blocked("203.0.113.2") {
+		t.Error("IPs of the prefix should not be blocked before their next page")
+	}
+}
@@ -0,0 +1,299 @@
+// Package audit writes an append-only JSON Lines log of botrate blocks
+// and unblocks, for shops that must keep a record of every enforcement
+// decision:
+//
+//	{"time":"2026-10-15T10:47:49Z","type":"block","ip":"203.0.113.7","reason":"score","pages":120,"score":1.2,"duration":"1h0m0s","config":"9f86d081884c7d65"}
+//
+// Each record carries the hash of the limiter configuration in force, see
+// botrate.Limiter.ConfigHash, so a decision can be traced back to the
+// thresholds that made it. Open writes to a file, rotated once it
+// reaches WithMaxSize; Reopen supports rotation by logrotate instead.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// backupTime formats the time of a rotated file in its name, sorting
+// chronologically.
+const backupTime = "20060102T150405.000000000"
+
+// Option is a functional option for configuring Log.
+type Option func(*Log)
+
+// WithConfigHash sets the configuration hash recorded with every record.
+// botrate.WithAuditLog and botrate.WithAuditFile set it to the limiter's.
+func WithConfigHash(hash string) Option {
+	return func(l *Log) {
+		l.config = hash
+	}
+}
+
+// WithMaxSize rotates the file of Open once writing a record would take
+// it past size bytes: it is renamed with the time appended, e.g.
+// audit.jsonl.20261015T104749.000000000, and a new one started. Zero
+// never rotates.
+func WithMaxSize(size int64) Option {
+	return func(l *Log) {
+		l.maxSize = size
+	}
+}
+
+// WithMaxBackups removes the oldest rotated files past n. Zero keeps them
+// all.
+func WithMaxBackups(n int) Option {
+	return func(l *Log) {
+		l.maxBackups = n
+	}
+}
+
+// WithLogger sets the logger for failed writes and rotations.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Log) {
+		l.logger = logger
+	}
+}
+
+// Record is a line of the log.
+type Record struct {
+	Time time.Time `json:"time"`
+	Type string    `json:"type"` // analyzer.EventBlock or analyzer.EventUnblock
+	IP   string    `json:"ip"`
+
+	// Reason is why the block or unblock was made, see
+	// analyzer.Event.Reason.
+	Reason string `json:"reason"`
+
+	// Counts and score that tripped the block.
+	Pages      int     `json:"pages,omitempty"`
+	Requests   int     `json:"requests,omitempty"`
+	Errors     int     `json:"errors,omitempty"`
+	Logins     int     `json:"logins,omitempty"`
+	Mismatches int     `json:"mismatches,omitempty"`
+	Sequential int     `json:"sequential,omitempty"`
+	Queries    int     `json:"queries,omitempty"`
+	Methods    int     `json:"methods,omitempty"`
+	Score      float64 `json:"score,omitempty"`
+	Honeypot   string  `json:"honeypot,omitempty"`
+	Offenses   int     `json:"offenses,omitempty"`
+
+	// Duration is how long the block lasts, "forever" if it doesn't end.
+	Duration string `json:"duration,omitempty"`
+
+	// Config is the hash of the limiter configuration, see
+	// WithConfigHash.
+	Config string `json:"config,omitempty"`
+}
+
+// Log writes block and unblock events as Records, one JSON object per
+// line. It is safe for concurrent use.
+type Log struct {
+	maxSize    int64
+	maxBackups int
+	logger     *slog.Logger
+
+	mu     sync.Mutex
+	config string
+	w      io.Writer
+	path   string   // "" for a writer of New
+	file   *os.File // nil for a writer of New
+	size   int64
+}
+
+// New returns a Log writing to w. Rotation options are ignored.
+func New(w io.Writer, opts ...Option) *Log {
+	l := &Log{w: w}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.logger == nil {
+		l.logger = logging.Discard()
+	}
+	return l
+}
+
+// Open returns a Log appending to the file at path, created if needed.
+func Open(path string, opts ...Option) (*Log, error) {
+	l := New(nil, opts...)
+	l.path = path
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.file, l.w, l.size = f, f, info.Size()
+	return nil
+}
+
+// Handle records a block or unblock, see botrate.WithEventFunc. Other
+// events are ignored. The record is written before Handle returns.
+func (l *Log) Handle(e analyzer.Event) {
+	if e.Type != analyzer.EventBlock && e.Type != analyzer.EventUnblock {
+		return
+	}
+
+	r := Record{
+		Time:       e.Time,
+		Type:       e.Type,
+		IP:         e.IP,
+		Reason:     e.Reason(),
+		Pages:      e.Pages,
+		Requests:   e.Requests,
+		Errors:     e.Errors,
+		Logins:     e.Logins,
+		Mismatches: e.Mismatches,
+		Sequential: e.Sequential,
+		Queries:    e.Queries,
+		Methods:    e.Methods,
+		Score:      e.Score,
+		Honeypot:   e.Honeypot,
+		Offenses:   e.Offenses,
+	}
+	if e.Type == analyzer.EventBlock {
+		r.Duration = "forever"
+		if e.Duration > 0 {
+			r.Duration = e.Duration.String()
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	r.Config = l.config
+	line, err := json.Marshal(r)
+	if err != nil {
+		l.logger.Warn("botrate: failed to encode audit record", "ip", e.IP, "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	if l.file != nil && l.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			// Keep writing to the current file rather than losing records
+			l.logger.Warn("botrate: failed to rotate audit log", "path", l.path, "error", err)
+		}
+	}
+	if l.w == nil {
+		l.logger.Warn("botrate: audit log closed, record dropped", "ip", e.IP)
+		return
+	}
+	n, err := l.w.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		l.logger.Warn("botrate: failed to write audit record", "ip", e.IP, "error", err)
+	}
+}
+
+// rotate renames the file with the time appended, starts a new one and
+// prunes the backups. It is called under mu.
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.file, l.w = nil, nil
+
+	renameErr := os.Rename(l.path, l.path+"."+time.Now().UTC().Format(backupTime))
+	if err := l.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+	l.prune()
+	return nil
+}
+
+// prune removes the oldest backups past maxBackups.
+func (l *Log) prune() {
+	if l.maxBackups <= 0 {
+		return
+	}
+	dir, base := filepath.Split(l.path)
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		l.logger.Warn("botrate: failed to list audit log backups", "path", l.path, "error", err)
+		return
+	}
+
+	var backups []string
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), base+".") && !e.IsDir() {
+			backups = append(backups, e.Name())
+		}
+	}
+	sort.Strings(backups)
+	for len(backups) > l.maxBackups {
+		if err := os.Remove(filepath.Join(dir, backups[0])); err != nil {
+			l.logger.Warn("botrate: failed to remove audit log backup", "path", backups[0], "error", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// SetConfigHash changes the configuration hash recorded with the next
+// records, e.g. after botrate.Limiter.ApplyConfig, which calls it.
+func (l *Log) SetConfigHash(hash string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = hash
+}
+
+// Reopen closes and reopens the file of Open, e.g. on SIGHUP after
+// logrotate moved it. It does nothing for a writer of New.
+func (l *Log) Reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.path == "" {
+		return nil
+	}
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			return err
+		}
+		l.file, l.w = nil, nil
+	}
+	return l.open()
+}
+
+// Close closes the file of Open, syncing it first. The writer of New is
+// left open.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Sync()
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file, l.w = nil, nil
+	return err
+}
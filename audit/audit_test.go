@@ -0,0 +1,121 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+func records(t *testing.T, data []byte) []Record {
+	t.Helper()
+
+	var out []Record
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		var r Record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			t.Fatalf("invalid record %q: %v", s.Text(), err)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+func TestLog_Handle(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, WithConfigHash("abc"))
+
+	now := time.Now()
+	l.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "1.1.1.1", Pages: 120, Score: 1.2, Duration: time.Hour})
+	l.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "2.2.2.2", Manual: true})
+	l.Handle(analyzer.Event{Type: analyzer.EventDrop, Time: now, Dropped: 5})
+	l.SetConfigHash("def")
+	l.Handle(analyzer.Event{Type: analyzer.EventUnblock, Time: now, IP: "1.1.1.1", Manual: true})
+
+	out := records(t, buf.Bytes())
+	if len(out) != 3 {
+		t.Fatalf("expected 3 records without the drop, got %d", len(out))
+	}
+	if r := out[0]; r.Type != analyzer.EventBlock || r.Reason != "score" || r.Pages != 120 || r.Duration != "1h0m0s" || r.Config != "abc" {
+		t.Errorf("unexpected block record %+v", r)
+	}
+	if r := out[1]; r.Reason != "manual" || r.Duration != "forever" {
+		t.Errorf("unexpected manual block record %+v", r)
+	}
+	if r := out[2]; r.Type != analyzer.EventUnblock || r.IP != "1.1.1.1" || r.Duration != "" || r.Config != "def" {
+		t.Errorf("unexpected unblock record %+v", r)
+	}
+}
+
+func TestOpen_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path, WithMaxSize(300), WithMaxBackups(2))
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Each record is about 100 bytes: rotated every few
+	for i := 0; i < 20; i++ {
+		l.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "192.168.1.1", Duration: time.Hour})
+	}
+
+	matches, _ := filepath.Glob(path + ".*")
+	if len(matches) != 2 {
+		t.Errorf("expected 2 backups, got %v", matches)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() > 300 {
+		t.Errorf("current file should stay within the max size, got %v, %v", info.Size(), err)
+	}
+}
+
+func TestOpen_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	for i := 0; i < 2; i++ {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open() returned error: %v", err)
+		}
+		l.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "192.168.1.1"})
+		if err := l.Close(); err != nil {
+			t.Fatalf("Close() returned error: %v", err)
+		}
+	}
+
+	data, _ := os.ReadFile(path)
+	if n := len(records(t, data)); n != 2 {
+		t.Errorf("reopening should append, got %d records", n)
+	}
+}
+
+func TestLog_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "1.1.1.1"})
+	if err := os.Rename(path, path+".1"); err != nil { // as logrotate would
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatalf("Reopen() returned error: %v", err)
+	}
+	l.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "2.2.2.2"})
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "2.2.2.2") || strings.Contains(string(data), "1.1.1.1") {
+		t.Errorf("records after Reopen should go to the new file, got %q", data)
+	}
+}
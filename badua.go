@@ -0,0 +1,38 @@
+package botrate
+
+// BadUAPolicy is what to do with normal users sending an empty,
+// truncated or overlong User-Agent, see WithBadUAPolicy and
+// useragent.Validate.
+type BadUAPolicy int
+
+const (
+	// BadUAAllow treats them like others.
+	BadUAAllow BadUAPolicy = iota
+
+	// BadUAScore analyzes them with WithBadUAPageThreshold, so that they
+	// are blocked after fewer pages than others.
+	BadUAScore
+
+	// BadUAChallenge denies them with ReasonBadUA and ActionChallenge,
+	// unless WithAction sets another action.
+	BadUAChallenge
+
+	// BadUABlock denies them with ReasonBadUA.
+	BadUABlock
+)
+
+// String returns the policy name.
+func (p BadUAPolicy) String() string {
+	switch p {
+	case BadUAAllow:
+		return "allow"
+	case BadUAScore:
+		return "score"
+	case BadUAChallenge:
+		return "challenge"
+	case BadUABlock:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,97 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/botrate/useragent"
+)
+
+func TestLimiter_WithBadUAPolicy(t *testing.T) {
+	tests := []struct {
+		policy  BadUAPolicy
+		allowed bool
+		action  Action
+	}{
+		{BadUAAllow, true, ActionReject},
+		{BadUAScore, true, ActionReject},
+		{BadUAChallenge, false, ActionChallenge},
+		{BadUABlock, false, ActionReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			l, err := New(WithKnownbots(testbots.New(t)), WithBadUAPolicy(tt.policy), WithMaxUALength(64))
+			if err != nil {
+				t.Fatalf("New() returned error: %v", err)
+			}
+			defer l.Close()
+
+			for ua, flaw := range map[string]useragent.Flaw{
+				"":                           useragent.Empty,
+				"Mozilla/5.0 (Windows NT 10": useragent.Truncated,
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36": useragent.TooLong,
+			} {
+				d := l.Decide(ua, "192.168.1.1", "/")
+				if d.Allowed != tt.allowed {
+					t.Errorf("%q: got %+v", ua, d)
+				}
+				if tt.policy != BadUAAllow && d.BadUA != flaw {
+					t.Errorf("%q: expected %q, got %q", ua, flaw, d.BadUA)
+				}
+				if !tt.allowed && (d.Reason != ReasonBadUA || d.Action != tt.action) {
+					t.Errorf("%q: expected ReasonBadUA with %v, got %+v", ua, tt.action, d)
+				}
+			}
+			if d := l.Decide("Java/17.0.1", "192.168.1.2", "/"); !d.Allowed || d.BadUA != useragent.NoFlaw {
+				t.Errorf("other users should be allowed, got %+v", d)
+			}
+		})
+	}
+}
+
+func TestLimiter_WithBadUAPageThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(100),
+		WithBadUAPolicy(BadUAScore),
+		WithBadUAPageThreshold(2),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/a", "/b"} {
+		l.Allow("", "192.168.1.1", path)
+		l.Allow("Java/17.0.1", "192.168.1.2", path)
+	}
+	if d := l.Decide("", "192.168.1.1", "/c"); !d.Blocklisted {
+		t.Errorf("the empty UA should be blocked at 2 pages, got %+v", d)
+	}
+	if d := l.Decide("Java/17.0.1", "192.168.1.2", "/c"); d.Blocklisted {
+		t.Errorf("other UAs should keep the global threshold, got %+v", d)
+	}
+}
+
+func TestLimiter_ApplyConfig_BadUAPolicy(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	cfg := l.Config()
+	cfg.BadUAPolicy = BadUAChallenge
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() returned error: %v", err)
+	}
+	if d := l.Decide("", "192.168.1.1", "/"); d.Reason != ReasonBadUA || d.Action != ActionChallenge {
+		t.Errorf("expected ReasonBadUA with a challenge, got %+v", d)
+	}
+	if cfg.Actions[ReasonBadUA] != ActionReject {
+		t.Error("ApplyConfig should not change the caller's actions")
+	}
+}
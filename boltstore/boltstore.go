@@ -0,0 +1,256 @@
+// Package boltstore provides a blocklist store persisted to a local bbolt
+// file, so learned blocks survive restarts and deploys.
+//
+// Blocked IPs are kept in a bucket mapping the IP to its expiry as a Unix
+// timestamp in milliseconds (0 never expires). The store keeps an
+// in-memory mirror of the bucket, so lookups on the request hot path
+// never touch the disk.
+package boltstore
+
+import (
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Default configuration values.
+var (
+	DefaultBucket  = "blocklist"
+	DefaultTimeout = time.Second
+)
+
+// Option is a functional option for configuring Store.
+type Option func(*Store)
+
+// WithBucket sets the name of the bbolt bucket holding the blocklist.
+func WithBucket(bucket string) Option {
+	return func(s *Store) {
+		s.bucket = []byte(bucket)
+	}
+}
+
+// WithTimeout sets how long Open waits for the file lock held by another
+// process.
+func WithTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.timeout = d
+	}
+}
+
+// Store is a bbolt-backed blocklist. It implements analyzer.Store.
+type Store struct {
+	db      *bolt.DB
+	bucket  []byte
+	timeout time.Duration
+
+	// Local mirror of the bucket: IP -> expiry
+	mirror atomic.Pointer[map[string]time.Time]
+	mu     sync.Mutex
+}
+
+// Open opens or creates the blocklist file at path and loads the blocks
+// that have not expired yet. The file is locked while the store is open.
+func Open(path string, opts ...Option) (*Store, error) {
+	s := &Store{
+		bucket:  []byte(DefaultBucket),
+		timeout: DefaultTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: s.timeout})
+	if err != nil {
+		return nil, err
+	}
+	s.db = db
+
+	if err := s.load(time.Now()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Blocked reports whether ip is blocked, using the local mirror.
+func (s *Store) Blocked(ip string) bool {
+	_, exists := (*s.mirror.Load())[ip]
+	return exists
+}
+
+// Len returns the number of blocked IPs.
+func (s *Store) Len() int {
+	return len(*s.mirror.Load())
+}
+
+// Range calls fn for each blocked IP until fn returns false.
+func (s *Store) Range(fn func(ip string, until time.Time) bool) {
+	for ip, until := range *s.mirror.Load() {
+		if !fn(ip, until) {
+			return
+		}
+	}
+}
+
+// Block persists ip to the blocklist for ttl. A ttl <= 0 never expires.
+func (s *Store) Block(ip string, ttl time.Duration) error {
+	var until time.Time
+	if ttl > 0 {
+		until = time.Now().Add(ttl)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(ip), encodeUntil(until))
+	})
+	if err != nil {
+		return err
+	}
+
+	old := *s.mirror.Load()
+	m := make(map[string]time.Time, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[ip] = until
+
+	s.mirror.Store(&m)
+	return nil
+}
+
+// Unblock removes ip from the blocklist.
+func (s *Store) Unblock(ip string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.mirror.Load()
+	if _, exists := old[ip]; !exists {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(ip))
+	})
+	if err != nil {
+		return err
+	}
+
+	m := make(map[string]time.Time, len(old))
+	for k, v := range old {
+		if k != ip {
+			m[k] = v
+		}
+	}
+
+	s.mirror.Store(&m)
+	return nil
+}
+
+// Expire removes entries that expired before now.
+func (s *Store) Expire(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.mirror.Load()
+
+	var expired []string
+	m := make(map[string]time.Time, len(old))
+	for k, v := range old {
+		if !v.IsZero() && !now.Before(v) {
+			expired = append(expired, k)
+			continue
+		}
+		m[k] = v
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(s.bucket)
+		for _, ip := range expired {
+			if err := b.Delete([]byte(ip)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mirror.Store(&m)
+	return nil
+}
+
+// Close closes the file. Blocks are persisted as they are made, so there
+// is nothing to flush.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// load creates the bucket if needed and fills the mirror with the blocks
+// still active at now, deleting the others.
+func (s *Store) load(now time.Time) error {
+	m := make(map[string]time.Time)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(s.bucket)
+		if err != nil {
+			return err
+		}
+
+		var stale [][]byte
+		err = b.ForEach(func(k, v []byte) error {
+			until, ok := decodeUntil(v)
+			if !ok || !until.IsZero() && !now.Before(until) {
+				// Keys are only valid during the transaction, and
+				// deleting while iterating is not allowed
+				stale = append(stale, k)
+				return nil
+			}
+			m[string(k)] = until
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mirror.Store(&m)
+	return nil
+}
+
+func encodeUntil(until time.Time) []byte {
+	var ms int64
+	if !until.IsZero() {
+		ms = until.UnixMilli()
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(ms))
+}
+
+// decodeUntil decodes an expiry, reporting false for a malformed one.
+func decodeUntil(v []byte) (time.Time, bool) {
+	if len(v) != 8 {
+		return time.Time{}, false
+	}
+	ms := int64(binary.BigEndian.Uint64(v))
+	if ms == 0 {
+		return time.Time{}, true
+	}
+	return time.UnixMilli(ms), true
+}
@@ -0,0 +1,145 @@
+package boltstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ analyzer.Store = (*Store)(nil)
+
+func openStore(t *testing.T, path string, opts ...Option) *Store {
+	t.Helper()
+
+	s, err := Open(path, opts...)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	return s
+}
+
+func TestStore_Block(t *testing.T) {
+	s := openStore(t, filepath.Join(t.TempDir(), "blocklist.db"))
+	defer s.Close()
+
+	if s.Blocked("192.168.1.1") {
+		t.Error("new store should be empty")
+	}
+
+	if err := s.Block("192.168.1.1", 0); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if !s.Blocked("192.168.1.1") || s.Len() != 1 {
+		t.Error("IP should be blocked")
+	}
+
+	if err := s.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	if s.Blocked("192.168.1.1") || s.Len() != 0 {
+		t.Error("IP should be unblocked")
+	}
+}
+
+func TestStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.db")
+
+	s := openStore(t, path)
+	s.Block("192.168.1.1", 0)
+	s.Block("192.168.1.2", time.Hour)
+	s.Block("192.168.1.3", time.Millisecond)
+	s.Block("192.168.1.4", time.Hour)
+	s.Unblock("192.168.1.4")
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	s = openStore(t, path)
+	defer s.Close()
+
+	if !s.Blocked("192.168.1.1") || !s.Blocked("192.168.1.2") {
+		t.Error("active blocks should survive a restart")
+	}
+	if s.Blocked("192.168.1.3") {
+		t.Error("blocks expired while closed should not be loaded")
+	}
+	if s.Blocked("192.168.1.4") {
+		t.Error("unblocked IPs should stay unblocked")
+	}
+
+	var until time.Time
+	s.Range(func(ip string, u time.Time) bool {
+		if ip == "192.168.1.2" {
+			until = u
+		}
+		return true
+	})
+	if d := time.Until(until); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("expiry should survive a restart, got %v left", d)
+	}
+
+	// The expired block is deleted from the file as well
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(s.bucket).Get([]byte("192.168.1.3")) != nil {
+			t.Error("expired block should be deleted on load")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View() returned error: %v", err)
+	}
+}
+
+func TestStore_Expire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.db")
+	s := openStore(t, path)
+
+	s.Block("192.168.1.1", time.Minute)
+	s.Block("192.168.1.2", 0)
+
+	if err := s.Expire(time.Now().Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Expire() returned error: %v", err)
+	}
+	if s.Blocked("192.168.1.1") {
+		t.Error("expired IP should be removed")
+	}
+	if !s.Blocked("192.168.1.2") {
+		t.Error("permanent block should not expire")
+	}
+
+	s.Close()
+	s = openStore(t, path)
+	defer s.Close()
+	if s.Len() != 1 {
+		t.Errorf("expected 1 block after reopening, got %d", s.Len())
+	}
+}
+
+func TestStore_WithBucket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.db")
+
+	a := openStore(t, path, WithBucket("a"))
+	a.Block("192.168.1.1", 0)
+	a.Close()
+
+	b := openStore(t, path, WithBucket("b"))
+	defer b.Close()
+	if b.Blocked("192.168.1.1") {
+		t.Error("buckets should be separate blocklists")
+	}
+}
+
+func TestOpen_Locked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.db")
+	s := openStore(t, path)
+	defer s.Close()
+
+	if _, err := Open(path, WithTimeout(10*time.Millisecond)); err == nil {
+		t.Error("Open() should fail while another store holds the file")
+	}
+}
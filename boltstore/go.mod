@@ -0,0 +1,16 @@
+module github.com/cnlangzi/botrate/boltstore
+
+go 1.22
+
+require (
+	github.com/cnlangzi/botrate v0.0.0-00010101000000-000000000000
+	go.etcd.io/bbolt v1.3.11
+)
+
+require (
+	github.com/bits-and-blooms/bitset v1.24.2 // indirect
+	github.com/bits-and-blooms/bloom/v3 v3.7.1 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
+
+replace github.com/cnlangzi/botrate => ..
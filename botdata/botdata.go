@@ -0,0 +1,311 @@
+// Package botdata manages the knownbots dataset, the bots' UA patterns
+// and IP ranges: how often the ranges are fetched from upstream, if at
+// all, and who hears about it.
+//
+// knownbots.New fetches the ranges at startup and every day, with its own
+// HTTP client and without reporting failures. Data builds the validator
+// without that scheduler instead, from the embedded dataset and the
+// conf.d directory of its root, and fetches the ranges itself, or never
+// with WithOffline.
+//
+// See botrate.WithBotData.
+package botdata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/knownbots"
+	"github.com/cnlangzi/knownbots/parser"
+
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	// DefaultRoot is the knownbots root, with custom bot configs in
+	// conf.d and RDNS caches.
+	DefaultRoot    = "./bots"
+	DefaultRefresh = 24 * time.Hour
+	DefaultTimeout = 30 * time.Second
+)
+
+// maxListSize bounds the range lists read.
+const maxListSize = 16 << 20
+
+// Option is a functional option for configuring Data.
+type Option func(*Data)
+
+// WithRoot sets the knownbots root, DefaultRoot by default.
+func WithRoot(dir string) Option {
+	return func(d *Data) {
+		d.root = dir
+	}
+}
+
+// WithRefresh sets how often the ranges are fetched again, only at
+// startup if not positive.
+func WithRefresh(interval time.Duration) Option {
+	return func(d *Data) {
+		d.refresh = interval
+	}
+}
+
+// WithOffline never fetches the ranges: bots are verified with the
+// ranges in the embedded dataset and conf.d, those passed to Load, and
+// RDNS.
+func WithOffline() Option {
+	return func(d *Data) {
+		d.offline = true
+	}
+}
+
+// WithClient sets the HTTP client fetching the ranges, e.g. one going
+// through an egress proxy.
+func WithClient(hc *http.Client) Option {
+	return func(d *Data) {
+		d.client = hc
+	}
+}
+
+// WithTimeout sets the timeout for fetching the ranges.
+func WithTimeout(timeout time.Duration) Option {
+	return func(d *Data) {
+		d.timeout = timeout
+	}
+}
+
+// WithFunc sets a function called after each refresh with its error, nil
+// if every bot's ranges were fetched.
+func WithFunc(fn func(err error)) Option {
+	return func(d *Data) {
+		d.fn = fn
+	}
+}
+
+// WithLogger sets the logger for failed fetches.
+func WithLogger(logger *slog.Logger) Option {
+	return func(d *Data) {
+		d.logger = logger
+	}
+}
+
+// Data is the knownbots dataset with the ranges fetched from upstream.
+type Data struct {
+	root    string
+	refresh time.Duration
+	offline bool
+	client  *http.Client
+	timeout time.Duration
+	fn      func(error)
+	logger  *slog.Logger
+
+	kb   *knownbots.Validator
+	bots []*knownbots.Bot // with ranges to fetch
+
+	// Reads are lock-free
+	ranges atomic.Pointer[map[string][]netip.Prefix] // by bot name
+	mu     sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New loads the dataset and, unless offline, fetches the ranges in the
+// background. Call Refresh to wait for them.
+func New(opts ...Option) (*Data, error) {
+	d := &Data{
+		root:    DefaultRoot,
+		refresh: DefaultRefresh,
+		client:  http.DefaultClient,
+		timeout: DefaultTimeout,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.logger == nil {
+		d.logger = logging.Discard()
+	}
+
+	// A Validator from knownbots.New would start its own scheduler
+	d.kb = new(knownbots.Validator)
+	if err := d.kb.Reload(d.root); err != nil {
+		return nil, err
+	}
+	bots, err := knownbots.Load(d.root)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range bots {
+		if len(b.URLs) > 0 {
+			d.bots = append(d.bots, b)
+		}
+	}
+
+	d.ranges.Store(&map[string][]netip.Prefix{})
+	if d.offline {
+		close(d.done)
+		return d, nil
+	}
+	go d.run()
+	return d, nil
+}
+
+func (d *Data) run() {
+	defer close(d.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-d.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	d.Refresh(ctx)
+	if d.refresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(d.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.Refresh(ctx)
+		}
+	}
+}
+
+// Validator returns the validator of the dataset. Its bots are verified
+// with the ranges of the dataset only: see Contains for those fetched.
+func (d *Data) Validator() *knownbots.Validator {
+	return d.kb
+}
+
+// Root returns the knownbots root of the dataset.
+func (d *Data) Root() string {
+	return d.root
+}
+
+// Refresh fetches the ranges of all bots concurrently, then calls the
+// function of WithFunc. Bots whose ranges fail keep their previous ones.
+func (d *Data) Refresh(ctx context.Context) error {
+	errs := make([]error, len(d.bots))
+	var wg sync.WaitGroup
+	for i, b := range d.bots {
+		wg.Add(1)
+		go func(i int, b *knownbots.Bot) {
+			defer wg.Done()
+
+			prefixes, err := d.fetch(ctx, b)
+			if err != nil {
+				if ctx.Err() == nil {
+					d.logger.Warn("botrate: failed to fetch bot ranges", "bot", b.Name, "error", err)
+				}
+				errs[i] = fmt.Errorf("botdata: %s: %w", b.Name, err)
+				return
+			}
+			d.Load(b.Name, prefixes)
+		}(i, b)
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if d.fn != nil {
+		d.fn(err)
+	}
+	return err
+}
+
+// fetch returns the ranges of b from all its URLs.
+func (d *Data) fetch(ctx context.Context, b *knownbots.Bot) ([]netip.Prefix, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+
+	p := parser.Get(b.Parser)
+	var prefixes []netip.Prefix
+	for _, u := range b.URLs {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := d.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return nil, errors.New(resp.Status)
+		}
+		list, err := p.Parse(io.LimitReader(resp.Body, maxListSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, list...)
+	}
+	if len(prefixes) == 0 {
+		// Rather keep the previous ranges than trust empty ones
+		return nil, errors.New("empty range list")
+	}
+	return prefixes, nil
+}
+
+// Load replaces the ranges of bot, e.g. with a copy of its list.
+func (d *Data) Load(bot string, prefixes []netip.Prefix) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	old := *d.ranges.Load()
+	m := make(map[string][]netip.Prefix, len(old)+1)
+	for name, p := range old {
+		m[name] = p
+	}
+	m[bot] = prefixes
+	d.ranges.Store(&m)
+}
+
+// Contains reports whether addr is in the ranges fetched or loaded for
+// bot.
+func (d *Data) Contains(bot string, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range (*d.ranges.Load())[bot] {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of bots with ranges fetched or loaded.
+func (d *Data) Len() int {
+	return len(*d.ranges.Load())
+}
+
+// Close stops fetching the ranges. The ranges loaded are kept.
+func (d *Data) Close() error {
+	select {
+	case <-d.stop:
+	default:
+		close(d.stop)
+	}
+	<-d.done
+	return nil
+}
@@ -0,0 +1,166 @@
+package botdata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+)
+
+const testbot = `name: testbot
+kind: SearchEngine
+parser: txt
+ua: "TestBot"
+urls:
+  - "http://bots.test/testbot.txt"
+`
+
+// root returns a knownbots root with testbot in conf.d.
+func root(t *testing.T) string {
+	t.Helper()
+
+	return testbots.Root(t, map[string]string{"testbot": testbot})
+}
+
+// client serves every URL from h, whatever its host.
+func client(t *testing.T, h http.HandlerFunc) *http.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return &http.Client{Transport: rewrite{srv.URL}}
+}
+
+type rewrite struct{ url string }
+
+func (rw rewrite) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = "http"
+	req.URL.Host = strings.TrimPrefix(rw.url, "http://")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// only keeps the bots named names, to fetch nothing else.
+func only(d *Data, names ...string) {
+	var bots []*knownbots.Bot
+	for _, b := range d.bots {
+		for _, name := range names {
+			if b.Name == name {
+				bots = append(bots, b)
+			}
+		}
+	}
+	d.bots = bots
+}
+
+func TestData_Offline(t *testing.T) {
+	var fetched atomic.Int32
+	hc := client(t, func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+	})
+
+	d, err := New(WithRoot(root(t)), WithOffline(), WithClient(hc))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer d.Close()
+
+	res := d.Validator().Validate("Mozilla/5.0 (compatible; TestBot/1.0)", "198.51.100.1")
+	if res.BotName != "testbot" || res.Status != knownbots.StatusFailed {
+		t.Errorf("Validate() = %+v, want testbot failing verification", res)
+	}
+	if d.Contains("testbot", netip.MustParseAddr("198.51.100.1")) {
+		t.Error("offline data should have no ranges")
+	}
+
+	d.Load("testbot", []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")})
+	if !d.Contains("testbot", netip.MustParseAddr("::ffff:198.51.100.1")) {
+		t.Error("loaded ranges should be contained")
+	}
+	if fetched.Load() != 0 {
+		t.Errorf("offline data fetched %d times", fetched.Load())
+	}
+}
+
+func TestData_Refresh(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	hc := client(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte("# testbot\n198.51.100.0/24\n2001:db8::/32\n"))
+	})
+
+	var calls []error
+	d, err := New(WithRoot(root(t)), WithOffline(), WithClient(hc), WithFunc(func(err error) {
+		calls = append(calls, err)
+	}))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer d.Close()
+	only(d, "testbot")
+
+	if err := d.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+	for _, ip := range []string{"198.51.100.7", "2001:db8::1"} {
+		if !d.Contains("testbot", netip.MustParseAddr(ip)) {
+			t.Errorf("%s should be in testbot's ranges", ip)
+		}
+	}
+	if d.Contains("testbot", netip.MustParseAddr("203.0.113.1")) || d.Contains("otherbot", netip.MustParseAddr("198.51.100.7")) {
+		t.Error("other addresses and bots should not be contained")
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	err = d.Refresh(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "testbot") {
+		t.Errorf("expected an error naming the bot, got %v", err)
+	}
+	if !d.Contains("testbot", netip.MustParseAddr("198.51.100.7")) {
+		t.Error("a failed fetch should keep the previous ranges")
+	}
+
+	if len(calls) != 2 || calls[0] != nil || calls[1] == nil {
+		t.Errorf("func called with %v, want a success then a failure", calls)
+	}
+}
+
+func TestData_Background(t *testing.T) {
+	hc := client(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/testbot.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("198.51.100.0/24\n"))
+	})
+
+	done := make(chan error, 1)
+	d, err := New(WithRoot(root(t)), WithRefresh(time.Hour), WithClient(hc), WithFunc(func(err error) {
+		done <- err
+	}))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer d.Close()
+
+	select {
+	case err := <-done:
+		// Other bots' lists are missing from the test server
+		if err == nil {
+			t.Error("expected the errors of the other bots")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ranges not fetched in the background")
+	}
+	if !d.Contains("testbot", netip.MustParseAddr("198.51.100.1")) {
+		t.Error("testbot's ranges should be fetched in the background")
+	}
+}
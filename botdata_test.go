@@ -0,0 +1,40 @@
+package botrate
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+)
+
+func TestLimiter_WithBotData(t *testing.T) {
+	conf := "kind: SearchEngine\nname: testbot\nparser: txt\nua: \"TestBot\"\nurls:\n  - \"http://bots.test/testbot.txt\"\n"
+	dir := testbots.Root(t, map[string]string{"testbot": conf})
+
+	l, err := New(WithBotData(botdata.WithRoot(dir), botdata.WithOffline()))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Offline, the ranges of testbot are never fetched
+	d := l.Decide("TestBot/1.0", "198.51.100.1", "/")
+	if d.Allowed || d.Reason != ReasonFakeBot {
+		t.Errorf("testbot should fail verification without its ranges, got %+v", d)
+	}
+
+	l.botData.Load("testbot", []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")})
+	d = l.Decide("TestBot/1.0", "198.51.100.1", "/")
+	if !d.Allowed || d.Bot != "testbot" || d.Verification != knownbots.StatusVerified {
+		t.Errorf("testbot should be verified with its loaded ranges, got %+v", d)
+	}
+}
+
+func TestLimiter_WithBotDataKnownbots(t *testing.T) {
+	_, err := New(WithKnownbots(testbots.NewAI(t)), WithBotData(botdata.WithOffline()))
+	if err == nil {
+		t.Error("expected an error for WithBotData with WithKnownbots")
+	}
+}
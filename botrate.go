@@ -1,6 +1,40 @@
 package botrate
 
-import "context"
+import (
+	"errors"
+	"fmt"
+	"time"
 
-// ErrLimit is returned when the request is rate limited.
-var ErrLimit = context.DeadlineExceeded
+	"golang.org/x/time/rate"
+)
+
+// ErrLimit is returned when the request is rate limited. Errors returned
+// by Wait match it with errors.Is; use errors.As with *LimitError for the
+// reason and retry-after.
+var ErrLimit = errors.New("botrate: rate limited")
+
+// ErrManagerClosed is returned by Manager.Configure after Manager.Close.
+var ErrManagerClosed = errors.New("botrate: manager closed")
+
+// LimitError is returned by Wait when the request is denied.
+type LimitError struct {
+	// Reason is why the request was denied.
+	Reason Reason
+
+	// RetryAfter is how long until the request would be allowed.
+	// rate.InfDuration means never.
+	RetryAfter time.Duration
+}
+
+// Error implements error.
+func (e *LimitError) Error() string {
+	if e.RetryAfter == rate.InfDuration {
+		return fmt.Sprintf("botrate: rate limited (%s)", e.Reason)
+	}
+	return fmt.Sprintf("botrate: rate limited (%s), retry after %s", e.Reason, e.RetryAfter)
+}
+
+// Is reports whether target is ErrLimit.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimit
+}
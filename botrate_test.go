@@ -1,13 +1,27 @@
 package botrate
 
 import (
+	"bytes"
 	"context"
-	"os"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
-	"github.com/cnlangzi/knownbots"
+	"github.com/cnlangzi/botrate/abuseipdb"
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/audit"
+	"github.com/cnlangzi/botrate/cloudranges"
+	"github.com/cnlangzi/botrate/dnsbl"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/botrate/tor"
+	"github.com/cnlangzi/botrate/useragent"
 	"golang.org/x/time/rate"
 )
 
@@ -26,6 +40,10 @@ func TestLimiter_New(t *testing.T) {
 		t.Errorf("expected default limit, got %v", l.cfg.Limit)
 	}
 
+	if l.cfg.Burst != DefaultBurst {
+		t.Errorf("expected default burst, got %v", l.cfg.Burst)
+	}
+
 	if l.kb == nil {
 		t.Error("knownbots validator should be initialized")
 	}
@@ -68,36 +86,13 @@ func TestLimiter_New_WithOptions(t *testing.T) {
 }
 
 func TestLimiter_Allow_VerifiedBot(t *testing.T) {
-	botDir := t.TempDir()
-	botConfDir := botDir + "/conf.d"
-	if err := os.MkdirAll(botConfDir, 0755); err != nil {
-		t.Fatalf("Failed to create config dir: %v", err)
-	}
-
-	customBotYAML := `kind: SearchEngine
-name: testbot
-parser: txt
-ua: "TestBot"
-custom:
-  - "192.168.100.0/24"
-`
-	if err := os.WriteFile(botConfDir+"/testbot.yaml", []byte(customBotYAML), 0644); err != nil {
-		t.Fatalf("Failed to write bot config: %v", err)
-	}
-
-	kb, err := knownbots.New(knownbots.WithRoot(botDir))
-	if err != nil {
-		t.Fatalf("Failed to create knownbots validator: %v", err)
-	}
-	defer kb.Close()
-
-	l, err := New(WithKnownbots(kb))
+	l, err := New(WithKnownbots(testbots.New(t)))
 	if err != nil {
 		t.Fatalf("New() returned error: %v", err)
 	}
 	defer l.Close()
 
-	allowed, reason := l.Allow("TestBot/1.0", "192.168.100.42")
+	allowed, reason := l.Allow("TestBot/1.0", "192.168.100.42", "/")
 	if !allowed {
 		t.Error("verified bot should be allowed")
 	}
@@ -105,7 +100,7 @@ custom:
 		t.Errorf("reason should be empty for allowed request, got %s", reason)
 	}
 
-	allowed, reason = l.Allow("TestBot/1.0", "10.0.0.1")
+	allowed, reason = l.Allow("TestBot/1.0", "10.0.0.1", "/")
 	if allowed {
 		t.Error("fake bot should be blocked")
 	}
@@ -121,7 +116,7 @@ func TestLimiter_Wait_VerifiedBot(t *testing.T) {
 	}
 	defer l.Close()
 
-	err, _ = l.Wait(context.Background(), "Googlebot/2.1", "66.249.66.1")
+	err, _ = l.Wait(context.Background(), "Googlebot/2.1", "66.249.66.1", "/")
 	_ = err
 }
 
@@ -137,9 +132,9 @@ func TestLimiter_Wait_ContextCanceled(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	err, _ = l.Wait(ctx, "Mozilla/5.0", "192.168.1.1")
+	err, _ = l.Wait(ctx, "Mozilla/5.0", "192.168.1.1", "/")
 
-	if err != nil && err != context.Canceled && err != ErrLimit {
+	if err != nil && err != context.Canceled && !errors.Is(err, ErrLimit) {
 		t.Errorf("expected nil, context.Canceled, or ErrLimit, got %v", err)
 	}
 }
@@ -154,13 +149,39 @@ func TestLimiter_Allow_NormalUser(t *testing.T) {
 	}
 	defer l.Close()
 
-	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1")
+	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/")
 
 	if !allowed {
 		t.Error("normal user should be allowed")
 	}
 }
 
+func TestLimiter_Allow_NormalUser_Allocs(t *testing.T) {
+	for _, syncAnalyzer := range []bool{false, true} {
+		l, err := New(
+			WithKnownbots(testbots.New(t)),
+			WithAnalyzerWindow(time.Hour),
+			WithAnalyzerPageThreshold(1000),
+			WithSyncAnalyzer(syncAnalyzer),
+		)
+		if err != nil {
+			t.Fatalf("New() returned error: %v", err)
+		}
+
+		// A known client: its IP, counters and bloom entries exist
+		l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+		l.Flush(context.Background())
+
+		allocs := testing.AllocsPerRun(1000, func() {
+			l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+		})
+		l.Close()
+		if allocs > 0 {
+			t.Errorf("sync analyzer %v: expected 0 allocs per Allow, got %g", syncAnalyzer, allocs)
+		}
+	}
+}
+
 func TestLimiter_Allow_BotLike(t *testing.T) {
 	l, err := New()
 	if err != nil {
@@ -168,7 +189,7 @@ func TestLimiter_Allow_BotLike(t *testing.T) {
 	}
 	defer l.Close()
 
-	allowed, _ := l.Allow("Python-urllib/3.11", "192.168.1.1")
+	allowed, _ := l.Allow("Python-urllib/3.11", "192.168.1.1", "/")
 	_ = allowed
 }
 
@@ -182,14 +203,14 @@ func TestLimiter_Allow_BlacklistedIP(t *testing.T) {
 	}
 	defer l.Close()
 
-	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1")
+	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/")
 	if !allowed {
 		t.Error("first request should be allowed")
 	}
 
 	time.Sleep(time.Millisecond * 200)
 
-	allowed, _ = l.Allow("Mozilla/5.0", "192.168.1.1")
+	allowed, _ = l.Allow("Mozilla/5.0", "192.168.1.1", "/")
 	_ = allowed
 }
 
@@ -203,7 +224,7 @@ func TestLimiter_Wait_NormalUser(t *testing.T) {
 	}
 	defer l.Close()
 
-	err, _ = l.Wait(context.Background(), "Mozilla/5.0", "192.168.1.1")
+	err, _ = l.Wait(context.Background(), "Mozilla/5.0", "192.168.1.1", "/")
 
 	if err != nil {
 		t.Errorf("normal user should not return error, got %v", err)
@@ -222,7 +243,7 @@ func TestLimiter_Wait_BotLike(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
 	defer cancel()
 
-	_, _ = l.Wait(ctx, "Python-urllib/3.11", "192.168.1.1")
+	_, _ = l.Wait(ctx, "Python-urllib/3.11", "192.168.1.1", "/")
 }
 
 func TestLimiter_Close(t *testing.T) {
@@ -235,6 +256,178 @@ func TestLimiter_Close(t *testing.T) {
 	l.Close()
 }
 
+func TestLimiter_New_ReleasesOnError(t *testing.T) {
+	// A closed server refuses the fetches, so nothing is left connected
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	aws := cloudranges.AWS
+	aws.URL = srv.URL
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 5; i++ {
+		_, err := New(
+			WithTor(TorLimit, tor.WithURL(srv.URL)),
+			WithCloudRanges(cloudranges.WithProviders(aws)),
+			WithSkipPaths("["),
+		)
+		if err == nil {
+			t.Fatal("expected an error for an invalid skip path")
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked by a failed New: %d before, %d after", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLimiter_Flush(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(3),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", path)
+	}
+	if err := l.Flush(context.Background()); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	if blocked := l.Blocklist(); len(blocked) != 1 {
+		t.Errorf("3 distinct pages should be blocked after Flush, got %+v", blocked)
+	}
+}
+
+func TestLimiter_WithClock(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Minute)),
+		WithBurst(1),
+		WithAnalyzerWindow(time.Hour),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", 10*time.Minute)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/") // burst
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); allowed {
+		t.Fatal("expected the bucket to be empty")
+	}
+	if r := l.Reserve("Mozilla/5.0", "192.168.1.1", "/"); r.Delay() != time.Minute {
+		t.Errorf("expected a delay of 1m on the clock, got %v", r.Delay())
+	} else {
+		r.Cancel()
+	}
+
+	// The bucket refills and the block ends on the clock
+	clock.Advance(time.Minute)
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+		t.Error("bucket should refill after a minute on the clock")
+	}
+	clock.Advance(10 * time.Minute)
+	l.Flush(context.Background())
+	if d := l.Decide("Mozilla/5.0", "192.168.1.1", "/"); d.Blocklisted {
+		t.Errorf("block should expire on the clock, got %+v", d)
+	}
+}
+
+func TestLimiter_WithEventFunc(t *testing.T) {
+	var first, second []analyzer.Event
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithEventFunc(func(e analyzer.Event) { first = append(first, e) }),
+		WithEventFunc(func(e analyzer.Event) { second = append(second, e) }),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Unblock("192.168.1.1")
+
+	for _, events := range [][]analyzer.Event{first, second} {
+		if len(events) != 2 || events[0].Type != analyzer.EventBlock || events[1].Type != analyzer.EventUnblock {
+			t.Errorf("expected a block then an unblock event, got %+v", events)
+		}
+	}
+}
+
+func TestLimiter_WithAbuseIPDB(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"abuseConfidenceScore":90}}`))
+	}))
+	defer srv.Close()
+
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(1000),
+		WithSyncAnalyzer(true),
+		WithAbuseIPDB("key", abuseipdb.WithBaseURL(srv.URL)),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// The first request queues the lookup, a later one is scored by it
+	deadline := time.Now().Add(2 * time.Second)
+	for len(l.Blocklist()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("IP with a score above the threshold should be blocked")
+		}
+		l.Allow("Mozilla/5.0", "203.0.113.1", "/")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// listedResolver lists every IP.
+type listedResolver struct{}
+
+func (listedResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return []string{"127.0.0.2"}, nil
+}
+
+func TestLimiter_WithDNSBL(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(1000),
+		WithSyncAnalyzer(true),
+		WithDNSBL(
+			dnsbl.WithResolver(listedResolver{}),
+			dnsbl.WithZones(dnsbl.Zone{Name: "dnsbl.example", Score: 1}),
+		),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// The first request queues the check, a later one is scored by it
+	deadline := time.Now().Add(2 * time.Second)
+	for len(l.Blocklist()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("listed IP should be blocked")
+		}
+		l.Allow("Mozilla/5.0", "203.0.113.1", "/")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestLimiter_Allow_ManyRequests(t *testing.T) {
 	l, err := New(
 		WithAnalyzerWindow(time.Hour),
@@ -249,7 +442,7 @@ func TestLimiter_Allow_ManyRequests(t *testing.T) {
 		ip := "192.168.1." + string(rune('0'+i%256))
 		ua := "UserAgent/" + string(rune('A'+i%26))
 
-		allowed, _ := l.Allow(ua, ip)
+		allowed, _ := l.Allow(ua, ip, "/")
 
 		if !allowed {
 			t.Errorf("request %d should be allowed", i)
@@ -264,7 +457,7 @@ func TestLimiter_Allow_IPv6(t *testing.T) {
 	}
 	defer l.Close()
 
-	allowed, _ := l.Allow("Mozilla/5.0", "2001:0db8:85a3:0000:0000:8a2e:0370:7334")
+	allowed, _ := l.Allow("Mozilla/5.0", "2001:0db8:85a3:0000:0000:8a2e:0370:7334", "/")
 	if !allowed {
 		t.Error("IPv6 request should be allowed")
 	}
@@ -277,7 +470,7 @@ func TestLimiter_Allow_EmptyUserAgent(t *testing.T) {
 	}
 	defer l.Close()
 
-	allowed, _ := l.Allow("", "192.168.1.1")
+	allowed, _ := l.Allow("", "192.168.1.1", "/")
 	if !allowed {
 		t.Error("empty UA should be allowed")
 	}
@@ -290,7 +483,7 @@ func TestLimiter_Allow_EmptyIP(t *testing.T) {
 	}
 	defer l.Close()
 
-	allowed, _ := l.Allow("Mozilla/5.0", "")
+	allowed, _ := l.Allow("Mozilla/5.0", "", "/")
 	if !allowed {
 		t.Error("empty IP should be allowed")
 	}
@@ -309,8 +502,8 @@ func TestLimiter_WithKnownbots(t *testing.T) {
 	}
 	defer l2.Close()
 
-	_, _ = l1.Allow("Googlebot/2.1", "66.249.66.1")
-	_, _ = l2.Allow("Googlebot/2.1", "66.249.66.1")
+	_, _ = l1.Allow("Googlebot/2.1", "66.249.66.1", "/")
+	_, _ = l2.Allow("Googlebot/2.1", "66.249.66.1", "/")
 }
 
 func TestLimiter_RateLimitPersistence(t *testing.T) {
@@ -324,8 +517,8 @@ func TestLimiter_RateLimitPersistence(t *testing.T) {
 	}
 	defer l.Close()
 
-	_, _ = l.Allow("Python-urllib/3.11", "192.168.1.1")
-	_, _ = l.Allow("Python-urllib/3.11", "192.168.1.1")
+	_, _ = l.Allow("Python-urllib/3.11", "192.168.1.1", "/")
+	_, _ = l.Allow("Python-urllib/3.11", "192.168.1.1", "/")
 }
 
 func TestLimiter_DifferentBots(t *testing.T) {
@@ -341,7 +534,7 @@ func TestLimiter_DifferentBots(t *testing.T) {
 	}
 
 	for _, bot := range bots {
-		_, _ = l.Allow(bot, "66.249.66.1")
+		_, _ = l.Allow(bot, "66.249.66.1", "/")
 	}
 }
 
@@ -377,7 +570,7 @@ func TestLimiter_BotScenarios(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			allowed, _ := l.Allow(tc.ua, tc.ip)
+			allowed, _ := l.Allow(tc.ua, tc.ip, "/")
 			_ = allowed
 		})
 	}
@@ -398,7 +591,7 @@ func TestLimiter_InvalidIPFormat(t *testing.T) {
 	}
 
 	for _, ip := range invalidIPs {
-		_, _ = l.Allow("Mozilla/5.0", ip)
+		_, _ = l.Allow("Mozilla/5.0", ip, "/")
 	}
 }
 
@@ -411,7 +604,7 @@ func TestLimiter_LongUserAgent(t *testing.T) {
 
 	longUA := strings.Repeat("Mozilla/5.0 ", 1000)
 
-	allowed, _ := l.Allow(longUA, "192.168.1.1")
+	allowed, _ := l.Allow(longUA, "192.168.1.1", "/")
 	if !allowed {
 		t.Error("long UA should be allowed")
 	}
@@ -425,10 +618,9 @@ func TestLimiter_LongPath(t *testing.T) {
 	defer l.Close()
 
 	longPath := "/" + strings.Repeat("a", 10000)
-	_, _ = l.Allow("Mozilla/5.0", "192.168.1.1")
-	_ = longPath
+	_, _ = l.Allow("Mozilla/5.0", "192.168.1.1", longPath)
 
-	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1")
+	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", longPath)
 	if !allowed {
 		t.Error("long path should be allowed")
 	}
@@ -450,7 +642,7 @@ func TestLimiter_ConcurrentAccess(t *testing.T) {
 			for j := 0; j < 100; j++ {
 				ip := string(rune('A'+workerID%26)) + string(rune('0'+j/10))
 				ua := "Worker/" + string(rune('0'+workerID%10))
-				l.Allow(ua, ip)
+				l.Allow(ua, ip, "/")
 			}
 			done <- true
 		}(i)
@@ -472,7 +664,7 @@ func BenchmarkLimiter_Allow_VerifiedBot(b *testing.B) {
 	b.ReportAllocs()
 
 	for i := 0; i < b.N; i++ {
-		l.Allow("Googlebot/2.1", "66.249.66.1")
+		l.Allow("Googlebot/2.1", "66.249.66.1", "/")
 	}
 }
 
@@ -490,7 +682,7 @@ func BenchmarkLimiter_Allow_NormalUser(b *testing.B) {
 	b.ReportAllocs()
 
 	for i := 0; i < b.N; i++ {
-		l.Allow("Mozilla/5.0", "192.168.1.1")
+		l.Allow("Mozilla/5.0", "192.168.1.1", "/")
 	}
 }
 
@@ -504,14 +696,14 @@ func BenchmarkLimiter_Allow_BlacklistedIP(b *testing.B) {
 	}
 	defer l.Close()
 
-	l.Allow("Mozilla/5.0", "192.168.1.1")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
 	time.Sleep(time.Millisecond * 100)
 
 	b.ResetTimer()
 	b.ReportAllocs()
 
 	for i := 0; i < b.N; i++ {
-		l.Allow("Mozilla/5.0", "192.168.1.1")
+		l.Allow("Mozilla/5.0", "192.168.1.1", "/")
 	}
 }
 
@@ -530,7 +722,7 @@ func BenchmarkLimiter_Wait(b *testing.B) {
 	b.ReportAllocs()
 
 	for i := 0; i < b.N; i++ {
-		l.Wait(ctx, "Mozilla/5.0", "192.168.1.1")
+		l.Wait(ctx, "Mozilla/5.0", "192.168.1.1", "/")
 	}
 }
 
@@ -545,3 +737,1184 @@ func BenchmarkLimiter_Close(b *testing.B) {
 		l.Close()
 	}
 }
+
+func TestLimiter_WithStore(t *testing.T) {
+	store := analyzer.NewMemoryStore()
+	store.Block("192.168.1.1", 0)
+
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithStore(store),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	if !allowed {
+		t.Error("first request of a blocked IP should use the burst")
+	}
+
+	allowed, reason := l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	if allowed {
+		t.Error("IP blocked in the store should be rate limited")
+	}
+	if reason != ReasonRateLimited {
+		t.Errorf("expected reason %s, got %s", ReasonRateLimited, reason)
+	}
+}
+
+func TestLimiter_WithBlockDuration(t *testing.T) {
+	l, err := New(WithBlockDuration(time.Minute))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if l.cfg.BlockDuration != time.Minute {
+		t.Errorf("expected custom block duration, got %v", l.cfg.BlockDuration)
+	}
+}
+
+func TestLimiter_BlockUnblock(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(10000),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if err := l.Block("192.168.1.1", time.Hour); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	allowed, reason := l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	if allowed || reason != ReasonRateLimited {
+		t.Errorf("manually blocked IP should be rate limited, got %v %s", allowed, reason)
+	}
+
+	if err := l.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+
+	if _, ok := l.blocked.Load("192.168.1.1"); ok {
+		t.Error("token bucket should be dropped on unblock")
+	}
+
+	allowed, _ = l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	if !allowed {
+		t.Error("unblocked IP should be allowed")
+	}
+}
+
+func TestLimiter_WithLogger(t *testing.T) {
+	kb := testbots.New(t)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	l, err := New(WithKnownbots(kb), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Allow("TestBot/1.0", "10.0.0.1", "/")
+
+	out := buf.String()
+	if !strings.Contains(out, "bot verification failed") || !strings.Contains(out, "bot=testbot") {
+		t.Errorf("expected verification failure log, got %q", out)
+	}
+}
+
+func TestLimiter_Blocklist(t *testing.T) {
+	l, err := New()
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	entries := l.Blocklist()
+	if len(entries) != 1 || entries[0].IP != "192.168.1.1" {
+		t.Errorf("unexpected blocklist %+v", entries)
+	}
+
+	events := l.Events()
+	if len(events) != 1 || !events[0].Manual || events[0].Duration != time.Hour {
+		t.Errorf("unexpected events %+v", events)
+	}
+
+	if l.Config().PageThreshold != DefaultPageThreshold {
+		t.Errorf("unexpected config %+v", l.Config())
+	}
+}
+
+func TestLimiter_WithDryRun(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithDryRun(true),
+		WithLogger(logger),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	allowed, reason := l.Allow("TestBot/1.0", "10.0.0.1", "/")
+	if !allowed || reason != "" {
+		t.Errorf("dry run should allow fake bots, got %v %s", allowed, reason)
+	}
+
+	l.Block("192.168.1.1", time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+			t.Error("dry run should allow blocked IPs")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err, _ := l.Wait(ctx, "Mozilla/5.0", "192.168.1.1", "/"); err != nil {
+		t.Errorf("dry run Wait should not block, got %v", err)
+	}
+
+	s := l.Stats()
+	if s.Denied[ReasonFakeBot] != 1 {
+		t.Errorf("expected 1 would-be fake bot denial, got %d", s.Denied[ReasonFakeBot])
+	}
+	if s.Denied[ReasonRateLimited] != 3 {
+		t.Errorf("expected 3 would-be rate limited denials, got %d", s.Denied[ReasonRateLimited])
+	}
+
+	if !strings.Contains(buf.String(), "would be denied") {
+		t.Errorf("expected dry run log, got %q", buf.String())
+	}
+}
+
+func TestLimiter_WithAllowCIDRs(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithAllowCIDRs([]string{"10.0.0.0/8"}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Fake bot from an allowlisted range
+	if allowed, _ := l.Allow("TestBot/1.0", "10.0.0.1", "/"); !allowed {
+		t.Error("allowlisted IP should bypass bot verification")
+	}
+
+	// Blocked but allowlisted
+	l.Block("10.0.0.2", time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := l.Allow("Mozilla/5.0", "10.0.0.2", "/"); !allowed {
+			t.Error("allowlisted IP should bypass the blocklist")
+		}
+	}
+
+	if err, _ := l.Wait(context.Background(), "TestBot/1.0", "10.0.0.1", "/"); err != nil {
+		t.Errorf("allowlisted IP should pass Wait, got %v", err)
+	}
+
+	// Runtime addition
+	if allowed, _ := l.Allow("TestBot/1.0", "172.16.0.1", "/"); allowed {
+		t.Error("fake bot outside the allowlist should be blocked")
+	}
+	if err := l.AddAllow("172.16.0.0/12"); err != nil {
+		t.Fatalf("AddAllow() returned error: %v", err)
+	}
+	if allowed, _ := l.Allow("TestBot/1.0", "172.16.0.1", "/"); !allowed {
+		t.Error("IP allowlisted at runtime should bypass bot verification")
+	}
+}
+
+func TestLimiter_WithAllowCIDRs_Invalid(t *testing.T) {
+	if _, err := New(WithAllowCIDRs([]string{"bogus"})); err == nil {
+		t.Error("New() should fail on invalid allowlist entries")
+	}
+}
+
+func TestLimiter_WithDenyCIDRs(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithDenyCIDRs([]string{"10.0.0.0/8"}),
+		WithAllowCIDRs([]string{"10.0.0.1"}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	allowed, reason := l.Allow("Mozilla/5.0", "10.1.2.3", "/")
+	if allowed || reason != ReasonDenied {
+		t.Errorf("denylisted IP should be denied, got %v %s", allowed, reason)
+	}
+
+	// Denylist runs before the allowlist
+	if allowed, _ := l.Allow("Mozilla/5.0", "10.0.0.1", "/"); allowed {
+		t.Error("denylist should take precedence over the allowlist")
+	}
+
+	// Denylist runs before bot verification
+	if allowed, _ := l.Allow("TestBot/1.0", "10.0.0.2", "/"); allowed {
+		t.Error("denylist should take precedence over bot verification")
+	}
+
+	err, reason = l.Wait(context.Background(), "Mozilla/5.0", "10.1.2.3", "/")
+	if !errors.Is(err, ErrLimit) || reason != ReasonDenied {
+		t.Errorf("Wait should deny denylisted IPs, got %v %s", err, reason)
+	}
+
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+		t.Error("other IPs should be allowed")
+	}
+	if err := l.AddDeny("192.168.1.0/24"); err != nil {
+		t.Fatalf("AddDeny() returned error: %v", err)
+	}
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); allowed {
+		t.Error("IP denylisted at runtime should be denied")
+	}
+
+	if n := l.Stats().Denied[ReasonDenied]; n != 5 {
+		t.Errorf("expected 5 denylist denials, got %d", n)
+	}
+}
+
+func TestLimiter_WithDenyCIDRs_Invalid(t *testing.T) {
+	if _, err := New(WithDenyCIDRs([]string{"bogus"})); err == nil {
+		t.Error("New() should fail on invalid denylist entries")
+	}
+}
+
+func TestLimiter_WithPrefixThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithHoneypotPaths("/trap"),
+		WithPrefixThreshold(2),
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua = "Mozilla/5.0"
+	l.Allow(ua, "203.0.113.1", "/trap")
+	if allowed, _ := l.Allow(ua, "203.0.113.50", "/"); !allowed {
+		t.Fatal("other IPs of the prefix should be allowed below the threshold")
+	}
+	l.Allow(ua, "203.0.113.2", "/trap")
+
+	// The prefix's clients share its bucket of one token
+	if allowed, _ := l.Allow(ua, "203.0.113.50", "/"); !allowed {
+		t.Error("first request from the blocked prefix should take the token")
+	}
+	if allowed, reason := l.Allow(ua, "203.0.113.51", "/"); allowed || reason != ReasonRateLimited {
+		t.Errorf("blocked prefix should be rate limited, got %v, %s", allowed, reason)
+	}
+	if allowed, _ := l.Allow(ua, "203.0.114.1", "/"); !allowed {
+		t.Error("other prefixes should be allowed")
+	}
+
+	if s := l.Stats(); s.Prefixes != 1 || s.PrefixBlocks != 1 || s.Blocklist != 2 {
+		t.Errorf("expected 1 prefix and 2 IPs blocked, got %+v", s)
+	}
+	if entries := l.Blocklist(); len(entries) != 3 {
+		t.Errorf("expected the blocklist to list the prefix, got %+v", entries)
+	}
+	if got := l.Inspect("203.0.113.51").Prefix; got != "203.0.113.0/24" {
+		t.Errorf("expected Inspect to report the prefix, got %q", got)
+	}
+
+	if err := l.Unblock("203.0.113.77/24"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	if allowed, _ := l.Allow(ua, "203.0.113.51", "/"); !allowed {
+		t.Error("unblocked prefix should be allowed")
+	}
+	if _, ok := l.blocked.Load("203.0.113.0/24"); ok {
+		t.Error("the prefix's bucket should be dropped")
+	}
+}
+
+func TestLimiter_CanonicalIP(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithDenyCIDRs([]string{"10.0.0.0/8"}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("2001:db8::1", time.Hour)
+
+	// Burst, then every spelling shares one bucket
+	l.Allow("Mozilla/5.0", "2001:DB8::1", "/")
+	for _, ip := range []string{"2001:db8::1", "2001:0db8::0001", "2001:db8::1%eth0"} {
+		if allowed, _ := l.Allow("Mozilla/5.0", ip, "/"); allowed {
+			t.Errorf("%s should share the blocked key", ip)
+		}
+	}
+
+	if allowed, _ := l.Allow("Mozilla/5.0", "::ffff:10.0.0.1", "/"); allowed {
+		t.Error("IPv4-mapped address should match IPv4 denylist")
+	}
+
+	if err := l.Unblock("2001:0DB8::1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	if len(l.Blocklist()) != 0 {
+		t.Error("unblock should canonicalize the IP")
+	}
+}
+
+func TestLimiter_WithUAClassPageThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithGeoLocator(fakeLocator{"192.168.1.3": "DE"}),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(100),
+		WithCountryPageThreshold(2, "DE"),
+		WithUAClassPageThreshold(3, useragent.Library, useragent.Tool),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.Allow("Java/17.0.1", "192.168.1.1", path)
+		l.Allow("okhttp/4.12.0", "192.168.1.2", path)
+	}
+	if d := l.Decide("Java/17.0.1", "192.168.1.1", "/d"); !d.Blocklisted || d.UAClass != useragent.Library {
+		t.Errorf("the library's crawl should be blocked at 3 pages, got %+v", d)
+	}
+	if d := l.Decide("okhttp/4.12.0", "192.168.1.2", "/d"); d.Blocklisted || d.UAClass != useragent.MobileApp {
+		t.Errorf("the app's crawl should keep the global threshold, got %+v", d)
+	}
+
+	// The lowest threshold wins
+	for _, path := range []string{"/a", "/b"} {
+		l.Allow("aiohttp/3.9.1", "192.168.1.3", path)
+	}
+	if d := l.Decide("aiohttp/3.9.1", "192.168.1.3", "/c"); !d.Blocklisted {
+		t.Errorf("the country threshold should block at 2 pages, got %+v", d)
+	}
+
+	// Thresholds applied to the running limiter take effect
+	cfg := l.Config()
+	cfg.UAClassPageThresholds = map[useragent.Class]int{useragent.MobileApp: 1}
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() returned error: %v", err)
+	}
+	l.Allow("okhttp/4.12.0", "192.168.1.4", "/a")
+	if d := l.Decide("okhttp/4.12.0", "192.168.1.4", "/b"); !d.Blocklisted {
+		t.Errorf("the applied class threshold should block, got %+v", d)
+	}
+}
+
+func TestLimiter_UAClassUnset(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("Java/17.0.1", "192.168.1.1", "/"); d.UAClass != useragent.Unknown {
+		t.Errorf("UAs should not be classified without class thresholds, got %q", d.UAClass)
+	}
+}
+
+func TestLimiter_WithBurst(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(3),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+			t.Errorf("request %d should be allowed within the burst", i)
+		}
+	}
+
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); allowed {
+		t.Error("request beyond the burst should be limited")
+	}
+}
+
+func TestLimiter_WithFakeBotLimit(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithBehaviorLimit(rate.Every(time.Hour)),
+		WithFakeBotLimit(rate.Every(time.Hour)),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if l.Config().FakeBotLimit != rate.Every(time.Hour) {
+		t.Errorf("expected fake bot limit to be set, got %v", l.Config().FakeBotLimit)
+	}
+
+	// The fake bot gets one request per bucket instead of none
+	if allowed, _ := l.Allow("TestBot/1.0", "10.0.0.1", "/"); !allowed {
+		t.Error("first fake bot request should be allowed within the burst")
+	}
+	allowed, reason := l.Allow("TestBot/1.0", "10.0.0.1", "/")
+	if allowed || reason != ReasonFakeBot {
+		t.Errorf("expected fake bot to be limited, got %v %s", allowed, reason)
+	}
+
+	// Behavior blocks use their own buckets
+	l.Block("10.0.0.1", time.Hour)
+	if allowed, _ := l.Allow("Mozilla/5.0", "10.0.0.1", "/"); !allowed {
+		t.Error("behavior bucket should be separate from the fake bot bucket")
+	}
+	allowed, reason = l.Allow("Mozilla/5.0", "10.0.0.1", "/")
+	if allowed || reason != ReasonRateLimited {
+		t.Errorf("expected rate limited, got %v %s", allowed, reason)
+	}
+
+	if s := l.Stats(); s.Limiters != 2 {
+		t.Errorf("expected 2 limiters, got %d", s.Limiters)
+	}
+}
+
+func TestLimiter_FakeBotLimit_Default(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if allowed, reason := l.Allow("TestBot/1.0", "10.0.0.1", "/"); allowed || reason != ReasonFakeBot {
+		t.Errorf("fake bots should be rejected outright by default, got %v %s", allowed, reason)
+	}
+	if s := l.Stats(); s.Limiters != 0 {
+		t.Errorf("no bucket should be created by default, got %d", s.Limiters)
+	}
+}
+
+func TestLimiter_AllowN(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(5),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Not throttled: cost is irrelevant
+	if allowed, _ := l.AllowN("Mozilla/5.0", "192.168.1.2", "/", 100); !allowed {
+		t.Error("unblocked IP should be allowed regardless of cost")
+	}
+
+	l.Block("192.168.1.1", time.Hour)
+
+	if allowed, _ := l.AllowN("Mozilla/5.0", "192.168.1.1", "/", 4); !allowed {
+		t.Error("request within the burst should be allowed")
+	}
+	if allowed, reason := l.AllowN("Mozilla/5.0", "192.168.1.1", "/", 2); allowed || reason != ReasonRateLimited {
+		t.Errorf("request beyond the remaining budget should be limited, got %v %s", allowed, reason)
+	}
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+		t.Error("remaining token should still be usable")
+	}
+}
+
+func TestLimiter_WithCostFunc(t *testing.T) {
+	l, err := New(WithCostFunc(func(path, method string) int {
+		switch {
+		case path == "/search":
+			return 5
+		case method == "HEAD":
+			return 0
+		}
+		return 1
+	}))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	tests := []struct {
+		path, method string
+		want         int
+	}{
+		{"/search", "GET", 5},
+		{"/", "GET", 1},
+		{"/", "HEAD", 1}, // clamped to at least 1
+	}
+	for _, tt := range tests {
+		if got := l.Cost(tt.path, tt.method); got != tt.want {
+			t.Errorf("Cost(%q, %q) = %d, want %d", tt.path, tt.method, got, tt.want)
+		}
+	}
+
+	d, err := New()
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer d.Close()
+	if got := d.Cost("/search", "GET"); got != 1 {
+		t.Errorf("default cost should be 1, got %d", got)
+	}
+}
+
+func TestLimitError(t *testing.T) {
+	var err error = &LimitError{Reason: ReasonRateLimited, RetryAfter: time.Second}
+
+	if !errors.Is(err, ErrLimit) {
+		t.Error("LimitError should match ErrLimit")
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("LimitError should not match context.DeadlineExceeded")
+	}
+
+	var le *LimitError
+	if !errors.As(err, &le) || le.Reason != ReasonRateLimited || le.RetryAfter != time.Second {
+		t.Errorf("unexpected LimitError %+v", le)
+	}
+	if got := err.Error(); got != "botrate: rate limited (rate_limited), retry after 1s" {
+		t.Errorf("unexpected message %q", got)
+	}
+}
+
+func TestLimiter_Wait_Blocked(t *testing.T) {
+	l, err := New(WithLimit(rate.Every(time.Hour)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	// The burst token is available, so Wait succeeds right away
+	if err, reason := l.Wait(context.Background(), "Mozilla/5.0", "192.168.1.1", "/"); err != nil {
+		t.Errorf("expected nil within the burst, got %v %s", err, reason)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err, reason := l.Wait(ctx, "Mozilla/5.0", "192.168.1.1", "/")
+	var le *LimitError
+	if !errors.As(err, &le) || reason != ReasonRateLimited {
+		t.Fatalf("expected LimitError, got %v %s", err, reason)
+	}
+	if le.RetryAfter < 59*time.Minute {
+		t.Errorf("expected retry after about an hour, got %v", le.RetryAfter)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Error("rate limiting should be distinguishable from a timeout")
+	}
+}
+
+func TestLimiter_Wait_BlockedWaits(t *testing.T) {
+	l, err := New(WithLimit(rate.Every(50 * time.Millisecond)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/") // burst
+
+	start := time.Now()
+	if err, reason := l.Wait(context.Background(), "Mozilla/5.0", "192.168.1.1", "/"); err != nil {
+		t.Errorf("expected Wait to succeed after the delay, got %v %s", err, reason)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("expected Wait to block for the next token, took %v", elapsed)
+	}
+}
+
+func TestLimiter_Path(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(3),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Repeated pages and changing UAs are not distinct pages
+	for i := 0; i < 10; i++ {
+		l.Allow("Mozilla/5.0 "+strconv.Itoa(i), "192.168.1.1", "/same")
+	}
+
+	// Distinct pages trip the threshold
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.Allow("Mozilla/5.0", "192.168.1.2", path)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	blocked := l.Blocklist()
+	if len(blocked) != 1 || blocked[0].IP != "192.168.1.2" {
+		t.Errorf("expected only the IP visiting distinct pages to be blocked, got %+v", blocked)
+	}
+}
+
+func TestLimiter_WithIgnoreAssets(t *testing.T) {
+	l, err := New(WithIgnoreAssets())
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if len(l.Config().IgnoreAssets) != len(analyzer.DefaultAssets) {
+		t.Errorf("expected default assets, got %v", l.Config().IgnoreAssets)
+	}
+
+	l2, err := New(WithIgnoreAssets(".js"))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l2.Close()
+
+	if got := l2.Config().IgnoreAssets; len(got) != 1 || got[0] != ".js" {
+		t.Errorf("expected custom assets, got %v", got)
+	}
+}
+
+func TestLimiter_WithPathNormalizer(t *testing.T) {
+	l, err := New(
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(2),
+		WithPathNormalizer(analyzer.NormalizePath),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 10; i++ {
+		l.Allow("Mozilla/5.0", "192.168.1.1", "/product/"+strconv.Itoa(i))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if len(l.Blocklist()) != 0 {
+		t.Error("normalized paths should count as one page")
+	}
+}
+
+// repeatSignal blocks an IP after n requests, distinct or not.
+type repeatSignal struct {
+	n    int
+	hits map[string]int
+}
+
+func (s *repeatSignal) Name() string { return "repeat" }
+
+func (s *repeatSignal) Observe(o analyzer.Observation) float64 {
+	s.hits[o.IP]++
+	return float64(s.hits[o.IP]) / float64(s.n)
+}
+
+func (s *repeatSignal) Forget(ip string) { delete(s.hits, ip) }
+
+func (s *repeatSignal) Reset() { clear(s.hits) }
+
+func TestLimiter_WithSignal(t *testing.T) {
+	l, err := New(
+		WithAnalyzerWindow(time.Hour),
+		WithSignal(&repeatSignal{n: 3, hits: make(map[string]int)}),
+		WithScoreThreshold(1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if len(l.Config().Signals) != 1 || l.Config().ScoreThreshold != 1 {
+		t.Errorf("unexpected config %+v", l.Config())
+	}
+
+	for i := 0; i < 3; i++ {
+		l.Allow("Mozilla/5.0", "192.168.1.1", "/search")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if len(l.Blocklist()) != 1 {
+		t.Error("custom signal should block the IP")
+	}
+}
+
+func TestLimiter_WithAnalyzerRequestThreshold(t *testing.T) {
+	l, err := New(
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerRequestThreshold(3),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		l.Allow("Mozilla/5.0", "192.168.1.1", "/search")
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if len(l.Blocklist()) != 1 {
+		t.Error("request threshold should block an IP hammering one URL")
+	}
+}
+
+func TestLimiter_WithSequenceThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithSequenceThreshold(3),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/item/1001", "/item/1002", "/item/1003", "/item/1004"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", path)
+	}
+	for _, path := range []string{"/item/7", "/item/42", "/item/1001", "/item/3"} {
+		l.Allow("Mozilla/5.0", "192.168.1.2", path)
+	}
+
+	if info := l.Inspect("192.168.1.1"); !info.Blocked || info.Sequential != 3 {
+		t.Errorf("enumerating IDs should block at the sequence threshold, got %+v", info.State)
+	}
+	if l.Inspect("192.168.1.2").Blocked {
+		t.Error("IDs out of sequence should not block")
+	}
+}
+
+func TestLimiter_WithQueryThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithQueryThreshold(3),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/product?id=1", "/product?id=1'", "/product?id=1%20OR%201=1"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", path)
+	}
+	for _, path := range []string{"/product?id=1", "/cart?id=1", "/search?q=shoes"} {
+		l.Allow("Mozilla/5.0", "192.168.1.2", path)
+	}
+
+	if info := l.Inspect("192.168.1.1"); !info.Blocked || info.Queries != 3 || info.Pages != 1 {
+		t.Errorf("fuzzing a page's query should block at the query threshold, got %+v", info.State)
+	}
+	if l.Inspect("192.168.1.2").Blocked {
+		t.Error("query strings of different pages should not block")
+	}
+}
+
+func TestLimiter_WithMethodWeight(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(10),
+		WithMethodWeight(5, "post", "PUT"),
+		WithMethodThreshold(3, http.MethodPost),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Two weighted writes to distinct pages reach the page threshold
+	for _, path := range []string{"/a", "/b"} {
+		l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: path, Method: http.MethodPut})
+	}
+	if !l.Inspect("192.168.1.1").Blocked {
+		t.Error("weighted requests should block at the page threshold")
+	}
+
+	// Posting the same form again, weighted, trips the POST threshold
+	l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.2", Path: "/signup", Method: http.MethodPost})
+	if info := l.Inspect("192.168.1.2"); !info.Blocked || info.Methods != 5 || info.Pages != 5 {
+		t.Errorf("expected the POST threshold to block, got %+v", info.State)
+	}
+
+	// Reads count as 1 and against no method threshold
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.3", Path: path, Method: http.MethodGet})
+	}
+	if info := l.Inspect("192.168.1.3"); info.Blocked || info.Methods != 0 || info.Pages != 3 {
+		t.Errorf("reads should not be weighted, got %+v", info.State)
+	}
+
+	// Thresholds applied to the running limiter take effect
+	cfg := l.Config()
+	cfg.MethodThresholds = map[string]int{http.MethodDelete: 1}
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() returned error: %v", err)
+	}
+	l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.4", Path: "/a", Method: http.MethodDelete})
+	if !l.Inspect("192.168.1.4").Blocked {
+		t.Error("applied method threshold should block")
+	}
+}
+
+func TestLimiter_RecordLogin(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithLoginPaths("/login", "/api/auth/*"),
+		WithLoginThreshold(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if l.RecordLogin("192.168.1.1", "/", 401) {
+		t.Error("other paths should not count as logins")
+	}
+	if l.RecordLogin("192.168.1.1", "/login", 200) {
+		t.Error("successful logins should not count")
+	}
+	if !l.RecordLogin("192.168.1.1", "/login", 401) {
+		t.Error("401 on a login path should count")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(l.Blocklist()) != 0 {
+		t.Fatal("below the login threshold should not block")
+	}
+
+	l.RecordLogin("192.168.1.1", "/api/auth/token", 403)
+	time.Sleep(50 * time.Millisecond)
+	if blocked := l.Blocklist(); len(blocked) != 1 || blocked[0].IP != "192.168.1.1" {
+		t.Errorf("failed logins should block the IP, got %+v", blocked)
+	}
+}
+
+func TestLimiter_RecordLogin_Disabled(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if l.RecordLogin("192.168.1.1", "/login", 401) {
+		t.Error("logins should not count without login paths")
+	}
+}
+
+func TestLimiter_WithHoneypotPaths(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithHoneypotPaths("/wp-admin/*", "/trap"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+		t.Fatal("normal page should be allowed")
+	}
+
+	// The trap request spends the burst of the new bucket
+	d := l.Decide("Mozilla/5.0", "192.168.1.1", "/wp-admin/setup.php")
+	if !d.Blocklisted || !d.Throttled || d.Remaining != 0 {
+		t.Errorf("trap path should be throttled, got %+v", d)
+	}
+
+	// Blocked right away, without waiting for the analyzer
+	if blocked := l.Blocklist(); len(blocked) != 1 || blocked[0].IP != "192.168.1.1" {
+		t.Fatalf("trap path should block the IP, got %+v", blocked)
+	}
+	if events := l.Events(); len(events) != 1 || events[0].Honeypot != "/wp-admin/setup.php" {
+		t.Errorf("unexpected events %+v", events)
+	}
+	if allowed, reason := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); allowed || reason != ReasonRateLimited {
+		t.Errorf("trapped IP should be rate limited, got %v %s", allowed, reason)
+	}
+}
+
+func TestLimiter_WithPenaltySchedule(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithHoneypotPaths("/trap"),
+		WithPenaltySchedule([]time.Duration{5 * time.Minute, time.Hour}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/trap")
+	if events := l.Events(); len(events) != 1 || events[0].Duration != 5*time.Minute || events[0].Offenses != 1 {
+		t.Errorf("first offense should be blocked briefly, got %+v", events)
+	}
+}
+
+func TestLimiter_WithGreylistThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(4),
+		WithGreylistThreshold(0.5),
+		WithGreylistLimit(rate.Every(time.Hour), 1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/1")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/2")
+	time.Sleep(50 * time.Millisecond)
+
+	d := l.Decide("Mozilla/5.0", "192.168.1.1", "/3")
+	if !d.Allowed || !d.Greylisted || d.Blocklisted || !d.Throttled {
+		t.Errorf("greylisted IP should use its burst, got %+v", d)
+	}
+	if allowed, reason := l.Allow("Mozilla/5.0", "192.168.1.1", "/3"); allowed || reason != ReasonGreylisted {
+		t.Errorf("greylisted IP should be limited, got %v %s", allowed, reason)
+	}
+
+	// Still analyzed, so it is blocked once it reaches the threshold
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/4")
+	time.Sleep(50 * time.Millisecond)
+	if d := l.Decide("Mozilla/5.0", "192.168.1.1", "/5"); !d.Blocklisted || d.Greylisted {
+		t.Errorf("continued behavior should block, got %+v", d)
+	}
+}
+
+func TestLimiter_WithAnalyzerHyperLogLog(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(3),
+		WithAnalyzerHyperLogLog(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/a", "/a", "/b", "/c"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", path)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if blocked := l.Blocklist(); len(blocked) != 1 {
+		t.Errorf("3 distinct pages should block, got %+v", blocked)
+	}
+}
+
+func TestLimiter_WithAnalyzerWorkers(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(3),
+		WithAnalyzerQueueCap(100),
+		WithAnalyzerWorkers(4),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if s := l.Stats(); s.QueueCap != 400 {
+		t.Errorf("expected 4 queues of 100, got %d", s.QueueCap)
+	}
+
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+		for _, path := range []string{"/a", "/b", "/c"} {
+			l.Allow("Mozilla/5.0", ip, path)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if blocked := l.Blocklist(); len(blocked) != 3 {
+		t.Errorf("each IP should be blocked by its worker, got %+v", blocked)
+	}
+}
+
+func TestLimiter_WithSyncAnalyzer(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(1),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(3),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", path)
+	}
+
+	// Blocked by the third page, without waiting for a worker
+	if d := l.Decide("Mozilla/5.0", "192.168.1.1", "/d"); !d.Blocklisted {
+		t.Errorf("expected the next request to hit the blocklist, got %+v", d)
+	}
+}
+
+func TestLimiter_WithAnalyzerSlidingWindow(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(200*time.Millisecond),
+		WithAnalyzerPageThreshold(4),
+		WithAnalyzerSlidingWindow(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, path := range []string{"/1", "/2", "/3"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", path)
+	}
+	time.Sleep(220 * time.Millisecond)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/4")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/5")
+	time.Sleep(20 * time.Millisecond)
+
+	if blocked := l.Blocklist(); len(blocked) != 1 {
+		t.Errorf("pages from the previous window should still count, got %+v", blocked)
+	}
+}
+
+func TestLimiter_WithBotLimit(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.NewAI(t)),
+		WithBotLimit("testbot", rate.Every(time.Hour), 2),
+		WithBotLimit("", rate.Every(time.Hour), 1),
+		WithAIBotPolicy(AIBotDeny, "trainbot"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// The bucket is shared by all the IPs of the bot
+	for _, ip := range []string{"192.168.100.1", "192.168.100.2"} {
+		if allowed, _ := l.Allow("TestBot/1.0", ip, "/"); !allowed {
+			t.Errorf("bots within their burst should be allowed")
+		}
+	}
+	if allowed, reason := l.Allow("TestBot/1.0", "192.168.100.3", "/"); allowed || reason != ReasonBotLimited {
+		t.Errorf("expected ReasonBotLimited past the burst, got %v, %v", allowed, reason)
+	}
+
+	// Other bots get the default limit
+	if allowed, _ := l.Allow("AnswerBot/1.0", "192.168.100.1", "/"); !allowed {
+		t.Error("other bots within the default burst should be allowed")
+	}
+	if d := l.Decide("AnswerBot/1.0", "192.168.100.1", "/"); d.Allowed || d.Reason != ReasonBotLimited || d.RetryAfter <= 0 {
+		t.Errorf("other bots past the default burst should be limited, got %+v", d)
+	}
+
+	// AI bot policies take precedence
+	if d := l.Decide("TrainBot/1.0", "192.168.100.1", "/"); d.Reason != ReasonAIBot {
+		t.Errorf("AI bot policies should take precedence, got %+v", d)
+	}
+	if s := l.Stats(); s.Denied[ReasonBotLimited] != 2 {
+		t.Errorf("expected 2 bot limit denials, got %d", s.Denied[ReasonBotLimited])
+	}
+}
+
+func TestLimiter_WithAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	l, err := New(WithKnownbots(testbots.New(t)), WithAuditLog(&buf))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Unblock("192.168.1.1")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit records, got %q", buf.String())
+	}
+	var r audit.Record
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatalf("invalid audit record: %v", err)
+	}
+	if r.Type != "block" || r.IP != "192.168.1.1" || r.Reason != "manual" || r.Config != l.ConfigHash() {
+		t.Errorf("unexpected audit record %+v", r)
+	}
+
+	if _, err := New(WithKnownbots(testbots.New(t)), WithAuditFile(t.TempDir())); err == nil {
+		t.Error("New() should fail when the audit file can't be opened")
+	}
+}
+
+func TestLimiter_ConfigHash(t *testing.T) {
+	hash := func(opts ...Option) string {
+		l, err := New(append(opts, WithKnownbots(testbots.New(t)))...)
+		if err != nil {
+			t.Fatalf("New() returned error: %v", err)
+		}
+		defer l.Close()
+		return l.ConfigHash()
+	}
+
+	a := hash(WithAnalyzerPageThreshold(50), WithAction(ReasonFakeBot, ActionTarpit))
+	if len(a) != 16 {
+		t.Errorf("expected 16 hex digits, got %q", a)
+	}
+	if b := hash(WithAnalyzerPageThreshold(50), WithAction(ReasonFakeBot, ActionTarpit)); a != b {
+		t.Errorf("equal settings should hash the same, got %s and %s", a, b)
+	}
+	if b := hash(WithAnalyzerPageThreshold(51), WithAction(ReasonFakeBot, ActionTarpit)); a == b {
+		t.Error("different thresholds should hash differently")
+	}
+}
+
+func TestReason_Forbidden(t *testing.T) {
+	for _, reason := range []Reason{ReasonFakeBot, ReasonDenied, ReasonDatacenter, ReasonAIBot, ReasonTor, ReasonTLSFingerprint, ReasonBadUA} {
+		if !reason.Forbidden() {
+			t.Errorf("%s should be forbidden", reason)
+		}
+	}
+	for _, reason := range []Reason{ReasonRateLimited, ReasonGreylisted, ReasonBotLimited, ReasonCrawlDelay, ReasonBotQuota, ReasonMessageLimited, ReasonPathLimited} {
+		if reason.Forbidden() {
+			t.Errorf("%s should not be forbidden", reason)
+		}
+	}
+}
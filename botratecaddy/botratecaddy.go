@@ -0,0 +1,221 @@
+// Package botratecaddy registers botrate as a Caddy HTTP handler module,
+// http.handlers.botrate, so sites behind Caddy get bot-aware rate
+// limiting at the proxy tier without Go code.
+//
+// Build Caddy with it using xcaddy:
+//
+//	xcaddy build --with github.com/cnlangzi/botrate/botratecaddy
+//
+// and configure it with the botrate Caddyfile directive, see
+// UnmarshalCaddyfile. Requests are checked by botratehttp.Middleware,
+// with the client IP Caddy determined from its trusted_proxies.
+package botratecaddy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/botratehttp"
+	"golang.org/x/time/rate"
+)
+
+func init() {
+	caddy.RegisterModule(Handler{})
+}
+
+// Handler is the botrate Caddy handler. Zero fields keep botrate's
+// defaults.
+type Handler struct {
+	// Limit is the rate of blocked clients, as events/duration, e.g.
+	// "10/s" or "1/10m". See botrate.WithLimit.
+	Limit string `json:"limit,omitempty"`
+
+	// Burst is the token bucket burst of blocked clients.
+	Burst int `json:"burst,omitempty"`
+
+	// FakeBotLimit is the rate of fake bots, as events/duration, none
+	// rejects them outright. See botrate.WithFakeBotLimit.
+	FakeBotLimit string `json:"fake_bot_limit,omitempty"`
+
+	// Window is the analysis window.
+	Window caddy.Duration `json:"window,omitempty"`
+
+	// PageThreshold, RequestThreshold and ErrorThreshold are the
+	// distinct pages, requests and error responses per window past
+	// which a client is blocked. See the botrate.WithAnalyzer options.
+	PageThreshold    int `json:"page_threshold,omitempty"`
+	RequestThreshold int `json:"request_threshold,omitempty"`
+	ErrorThreshold   int `json:"error_threshold,omitempty"`
+
+	// BlockDuration is how long clients stay blocked.
+	BlockDuration caddy.Duration `json:"block_duration,omitempty"`
+
+	// Actions maps denial reasons, e.g. "rate_limited", to the action
+	// taken: "reject", "tarpit" or "challenge". See botrate.WithAction.
+	Actions map[string]string `json:"actions,omitempty"`
+
+	// AllowCIDRs and DenyCIDRs bypass or reject IPs and ranges.
+	AllowCIDRs []string `json:"allow_cidrs,omitempty"`
+	DenyCIDRs  []string `json:"deny_cidrs,omitempty"`
+
+	// SkipPaths are path globs bypassing analysis and limiting.
+	SkipPaths []string `json:"skip_paths,omitempty"`
+
+	// DryRun logs denials without enforcing them.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	limiter *botrate.Limiter
+	mw      func(http.Handler) http.Handler
+}
+
+// CaddyModule returns the Caddy module information.
+func (Handler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.botrate",
+		New: func() caddy.Module { return new(Handler) },
+	}
+}
+
+// Provision creates the limiter. Each config load gets its own: blocks
+// do not survive a reload.
+func (h *Handler) Provision(ctx caddy.Context) error {
+	opts, err := h.options()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, botrate.WithLogger(ctx.Slogger()))
+
+	h.limiter, err = botrate.New(opts...)
+	if err != nil {
+		return err
+	}
+	h.mw = botratehttp.Middleware(h.limiter, botratehttp.WithIPFunc(clientIP))
+	return nil
+}
+
+// Validate checks the configuration without creating a limiter.
+func (h *Handler) Validate() error {
+	_, err := h.options()
+	return err
+}
+
+// Cleanup closes the limiter when the config is unloaded.
+func (h *Handler) Cleanup() error {
+	if h.limiter != nil {
+		h.limiter.Close()
+	}
+	return nil
+}
+
+// ServeHTTP checks the request and passes allowed ones on. Errors
+// returned by later handlers are handled by Caddy's error routes, after
+// the middleware recorded the response: they count as successes.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) error {
+	var err error
+	h.mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err = next.ServeHTTP(w, r)
+	})).ServeHTTP(w, r)
+	return err
+}
+
+// options returns the limiter options of the configuration.
+func (h *Handler) options() ([]botrate.Option, error) {
+	var opts []botrate.Option
+
+	if h.Limit != "" {
+		limit, err := parseRate(h.Limit)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, botrate.WithLimit(limit))
+	}
+	if h.Burst > 0 {
+		opts = append(opts, botrate.WithBurst(h.Burst))
+	}
+	if h.FakeBotLimit != "" {
+		limit, err := parseRate(h.FakeBotLimit)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, botrate.WithFakeBotLimit(limit))
+	}
+	if h.Window > 0 {
+		opts = append(opts, botrate.WithAnalyzerWindow(time.Duration(h.Window)))
+	}
+	if h.PageThreshold > 0 {
+		opts = append(opts, botrate.WithAnalyzerPageThreshold(h.PageThreshold))
+	}
+	if h.RequestThreshold > 0 {
+		opts = append(opts, botrate.WithAnalyzerRequestThreshold(h.RequestThreshold))
+	}
+	if h.ErrorThreshold > 0 {
+		opts = append(opts, botrate.WithAnalyzerErrorThreshold(h.ErrorThreshold))
+	}
+	if h.BlockDuration > 0 {
+		opts = append(opts, botrate.WithBlockDuration(time.Duration(h.BlockDuration)))
+	}
+	for reason, name := range h.Actions {
+		action, err := botrate.ParseAction(name)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, botrate.WithAction(botrate.Reason(reason), action))
+	}
+	if len(h.AllowCIDRs) > 0 {
+		opts = append(opts, botrate.WithAllowCIDRs(h.AllowCIDRs))
+	}
+	if len(h.DenyCIDRs) > 0 {
+		opts = append(opts, botrate.WithDenyCIDRs(h.DenyCIDRs))
+	}
+	if len(h.SkipPaths) > 0 {
+		opts = append(opts, botrate.WithSkipPaths(h.SkipPaths...))
+	}
+	if h.DryRun {
+		opts = append(opts, botrate.WithDryRun(true))
+	}
+	return opts, nil
+}
+
+// clientIP returns the client IP Caddy determined, honoring its
+// trusted_proxies.
+func clientIP(r *http.Request) string {
+	if ip, ok := caddyhttp.GetVar(r.Context(), caddyhttp.ClientIPVarKey).(string); ok {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
+// parseRate parses a rate written events/duration, e.g. "10/s" or
+// "1/10m".
+func parseRate(s string) (rate.Limit, error) {
+	events, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("botrate: invalid rate %q, want events/duration", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	n, err := strconv.ParseFloat(events, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("botrate: invalid rate %q: bad event count", s)
+	}
+	d, err := caddy.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("botrate: invalid rate %q: bad duration", s)
+	}
+	return rate.Limit(n / d.Seconds()), nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner           = (*Handler)(nil)
+	_ caddy.Validator             = (*Handler)(nil)
+	_ caddy.CleanerUpper          = (*Handler)(nil)
+	_ caddyhttp.MiddlewareHandler = (*Handler)(nil)
+)
@@ -0,0 +1,146 @@
+package botratecaddy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/botratehttp"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestUnmarshalCaddyfile(t *testing.T) {
+	d := caddyfile.NewTestDispenser(`botrate {
+		limit 1/10m
+		burst 2
+		fake_bot_limit 1/m
+		window 10m
+		threshold 100
+		request_threshold 1000
+		error_threshold 50
+		block_duration 2h
+		action rate_limited tarpit
+		allow 10.0.0.0/8
+		deny 203.0.113.0/24 198.51.100.0/24
+		skip /health /static/*
+		dry_run
+	}`)
+
+	var h Handler
+	if err := h.UnmarshalCaddyfile(d); err != nil {
+		t.Fatalf("UnmarshalCaddyfile() returned error: %v", err)
+	}
+	if h.Limit != "1/10m" || h.Burst != 2 || h.FakeBotLimit != "1/m" {
+		t.Errorf("unexpected limits %q, %d, %q", h.Limit, h.Burst, h.FakeBotLimit)
+	}
+	if time.Duration(h.Window) != 10*time.Minute || time.Duration(h.BlockDuration) != 2*time.Hour {
+		t.Errorf("unexpected durations %v, %v", h.Window, h.BlockDuration)
+	}
+	if h.PageThreshold != 100 || h.RequestThreshold != 1000 || h.ErrorThreshold != 50 {
+		t.Errorf("unexpected thresholds %d, %d, %d", h.PageThreshold, h.RequestThreshold, h.ErrorThreshold)
+	}
+	if h.Actions["rate_limited"] != "tarpit" {
+		t.Errorf("unexpected actions %v", h.Actions)
+	}
+	if len(h.AllowCIDRs) != 1 || len(h.DenyCIDRs) != 2 || len(h.SkipPaths) != 2 || !h.DryRun {
+		t.Errorf("unexpected lists %+v", h)
+	}
+	if err := h.Validate(); err != nil {
+		t.Errorf("Validate() returned error: %v", err)
+	}
+}
+
+func TestUnmarshalCaddyfile_Invalid(t *testing.T) {
+	for _, input := range []string{
+		"botrate extra",
+		"botrate {\n limit 10\n}",
+		"botrate {\n burst many\n}",
+		"botrate {\n window soon\n}",
+		"botrate {\n action rate_limited drop\n}",
+		"botrate {\n deny\n}",
+		"botrate {\n unknown\n}",
+	} {
+		var h Handler
+		if err := h.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want rate.Limit
+	}{
+		{"10/s", 10},
+		{"1/10m", rate.Every(10 * time.Minute)},
+		{"0/s", 0},
+	}
+	for _, tt := range tests {
+		got, err := parseRate(tt.in)
+		if err != nil {
+			t.Errorf("parseRate(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if diff := float64(got - tt.want); diff > 1e-9 || diff < -1e-9 {
+			t.Errorf("parseRate(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+	for _, in := range []string{"10", "x/s", "1/x", "-1/s", "1/0s"} {
+		if _, err := parseRate(in); err == nil {
+			t.Errorf("parseRate(%q): expected an error", in)
+		}
+	}
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	h := &Handler{DenyCIDRs: []string{"203.0.113.0/24"}}
+	opts, err := h.options()
+	if err != nil {
+		t.Fatalf("options() returned error: %v", err)
+	}
+	h.limiter, err = botrate.New(append(opts, botrate.WithKnownbots(testbots.New(t)))...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer h.Cleanup()
+	h.mw = botratehttp.Middleware(h.limiter, botratehttp.WithIPFunc(clientIP))
+
+	next := caddyhttp.HandlerFunc(func(w http.ResponseWriter, r *http.Request) error {
+		return caddyhttp.Error(http.StatusNotFound, nil)
+	})
+	serve := func(ip string) (int, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		ctx := context.WithValue(req.Context(), caddyhttp.VarsCtxKey, map[string]any{
+			caddyhttp.ClientIPVarKey: ip,
+		})
+		rec := httptest.NewRecorder()
+		err := h.ServeHTTP(rec, req.WithContext(ctx), next)
+		return rec.Code, err
+	}
+
+	if code, err := serve("203.0.113.1"); code != http.StatusForbidden || err != nil {
+		t.Errorf("denylisted IPs should get 403, got %d, %v", code, err)
+	}
+	if _, err := serve("192.168.1.1"); err == nil {
+		t.Errorf("errors of later handlers should be returned, got %v", err)
+	}
+}
+
+func TestHandler_Provision(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	h := &Handler{Limit: "bogus"}
+	if err := h.Provision(ctx); err == nil {
+		t.Error("invalid configs should not provision")
+	}
+}
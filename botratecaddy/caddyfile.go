@@ -0,0 +1,146 @@
+package botratecaddy
+
+import (
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+	"github.com/cnlangzi/botrate"
+)
+
+func init() {
+	httpcaddyfile.RegisterHandlerDirective("botrate", parseCaddyfile)
+	httpcaddyfile.RegisterDirectiveOrder("botrate", httpcaddyfile.After, "header")
+}
+
+func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	m := new(Handler)
+	err := m.UnmarshalCaddyfile(h.Dispenser)
+	return m, err
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens:
+//
+//	botrate {
+//		limit             <events/duration>
+//		burst             <n>
+//		fake_bot_limit    <events/duration>
+//		window            <duration>
+//		threshold         <pages>
+//		request_threshold <requests>
+//		error_threshold   <errors>
+//		block_duration    <duration>
+//		action            <reason> reject|tarpit|challenge
+//		allow             <cidrs...>
+//		deny              <cidrs...>
+//		skip              <globs...>
+//		dry_run
+//	}
+//
+// The directive runs after header, before redir and rewrites, so clients
+// are analyzed by the paths they requested.
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume directive name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "limit", "fake_bot_limit":
+			name := d.Val()
+			var s string
+			if !d.AllArgs(&s) {
+				return d.ArgErr()
+			}
+			if _, err := parseRate(s); err != nil {
+				return d.WrapErr(err)
+			}
+			if name == "limit" {
+				h.Limit = s
+			} else {
+				h.FakeBotLimit = s
+			}
+
+		case "burst", "threshold", "request_threshold", "error_threshold":
+			name := d.Val()
+			var s string
+			if !d.AllArgs(&s) {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return d.Errf("invalid %s %q", name, s)
+			}
+			switch name {
+			case "burst":
+				h.Burst = n
+			case "threshold":
+				h.PageThreshold = n
+			case "request_threshold":
+				h.RequestThreshold = n
+			case "error_threshold":
+				h.ErrorThreshold = n
+			}
+
+		case "window", "block_duration":
+			name := d.Val()
+			var s string
+			if !d.AllArgs(&s) {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(s)
+			if err != nil {
+				return d.Errf("invalid %s %q: %v", name, s, err)
+			}
+			if name == "window" {
+				h.Window = caddy.Duration(dur)
+			} else {
+				h.BlockDuration = caddy.Duration(dur)
+			}
+
+		case "action":
+			var reason, action string
+			if !d.AllArgs(&reason, &action) {
+				return d.ArgErr()
+			}
+			if _, err := botrate.ParseAction(action); err != nil {
+				return d.WrapErr(err)
+			}
+			if h.Actions == nil {
+				h.Actions = make(map[string]string)
+			}
+			h.Actions[reason] = action
+
+		case "allow", "deny", "skip":
+			name := d.Val()
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			switch name {
+			case "allow":
+				h.AllowCIDRs = append(h.AllowCIDRs, args...)
+			case "deny":
+				h.DenyCIDRs = append(h.DenyCIDRs, args...)
+			case "skip":
+				h.SkipPaths = append(h.SkipPaths, args...)
+			}
+
+		case "dry_run":
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			h.DryRun = true
+
+		default:
+			return d.Errf("unrecognized botrate option %q", d.Val())
+		}
+	}
+	return nil
+}
+
+// Interface guard
+var _ caddyfile.Unmarshaler = (*Handler)(nil)
@@ -0,0 +1,201 @@
+// Package botrateconnect applies a botrate.Limiter to Connect-RPC
+// services as an interceptor, mapping denials to Connect error codes.
+//
+// RPCs are checked like HTTP requests, with the procedure as the path,
+// e.g. /acme.v1.UserService/GetUser, so path-based options such as
+// WithSkipPaths and WithCostFunc apply. Twirp services are plain
+// net/http handlers: use botratehttp.Middleware with
+// botratehttp.TwirpDenied.
+package botrateconnect
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/cnlangzi/botrate"
+	"golang.org/x/time/rate"
+)
+
+// Option is a functional option for configuring the interceptor.
+type Option func(*Interceptor)
+
+// IPFunc extracts the client IP from the peer and request headers of an
+// RPC.
+type IPFunc func(peer connect.Peer, header http.Header) string
+
+// UserFunc returns the authenticated user ID of an RPC, or "".
+type UserFunc func(ctx context.Context, header http.Header) string
+
+// WithIPFunc sets how the client IP is extracted. Defaults to the peer
+// address. Behind a proxy, read the header the proxy sets, only from the
+// proxy.
+func WithIPFunc(fn IPFunc) Option {
+	return func(i *Interceptor) {
+		i.ip = fn
+	}
+}
+
+// WithUserFunc sets how the authenticated user of an RPC is found, see
+// botrate.Request.User.
+func WithUserFunc(fn UserFunc) Option {
+	return func(i *Interceptor) {
+		i.user = fn
+	}
+}
+
+// Interceptor checks the RPCs a handler serves against a limiter. It
+// implements connect.Interceptor; clients are not checked.
+type Interceptor struct {
+	l    *botrate.Limiter
+	ip   IPFunc
+	user UserFunc
+}
+
+// New returns an interceptor applying l to every RPC. Fake bots and
+// denylisted IPs get CodePermissionDenied, rate limited clients
+// CodeResourceExhausted with a Retry-After header when they may proceed
+// later. Errors of analyzed RPCs are fed back with l.RecordResponse and
+// l.RecordLogin, by their HTTP status.
+func New(l *botrate.Limiter, opts ...Option) *Interceptor {
+	i := &Interceptor{
+		l:  l,
+		ip: peerIP,
+	}
+
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// WrapUnary checks unary RPCs.
+func (i *Interceptor) WrapUnary(next connect.UnaryFunc) connect.UnaryFunc {
+	return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+		if req.Spec().IsClient {
+			return next(ctx, req)
+		}
+
+		d, err := i.check(ctx, req.Spec().Procedure, req.Peer(), req.Header())
+		if err != nil {
+			return nil, err
+		}
+		res, err := next(ctx, req)
+		i.record(d, req.Spec().Procedure, err)
+		return res, err
+	}
+}
+
+// WrapStreamingClient leaves client streams alone.
+func (i *Interceptor) WrapStreamingClient(next connect.StreamingClientFunc) connect.StreamingClientFunc {
+	return next
+}
+
+// WrapStreamingHandler checks streaming RPCs when they start.
+func (i *Interceptor) WrapStreamingHandler(next connect.StreamingHandlerFunc) connect.StreamingHandlerFunc {
+	return func(ctx context.Context, conn connect.StreamingHandlerConn) error {
+		d, err := i.check(ctx, conn.Spec().Procedure, conn.Peer(), conn.RequestHeader())
+		if err != nil {
+			return err
+		}
+		err = next(ctx, conn)
+		i.record(d, conn.Spec().Procedure, err)
+		return err
+	}
+}
+
+// check returns the decision for an RPC, and the error to return if it is
+// denied.
+func (i *Interceptor) check(ctx context.Context, procedure string, peer connect.Peer, header http.Header) (botrate.Decision, error) {
+	req := botrate.Request{
+		UA:     header.Get("User-Agent"),
+		IP:     i.ip(peer, header),
+		Path:   procedure,
+		Method: http.MethodPost,
+	}
+	if i.user != nil {
+		req.User = i.user(ctx, header)
+	}
+
+	d := i.l.Check(req)
+	if d.Allowed {
+		return d, nil
+	}
+	return d, Error(d)
+}
+
+// record feeds the outcome of an analyzed RPC back to the limiter.
+func (i *Interceptor) record(d botrate.Decision, procedure string, err error) {
+	if d.Key == "" {
+		// Not analyzed: bots, allowlisted IPs and skipped procedures
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = httpStatus(connect.CodeOf(err))
+	}
+	i.l.RecordResponse(d.Key, status)
+	i.l.RecordLogin(d.Key, procedure, status)
+}
+
+// Error returns the Connect error for a denied decision, with a
+// Retry-After header when the client may proceed later.
+func Error(d botrate.Decision) *connect.Error {
+	code := connect.CodeResourceExhausted
+	if d.Reason.Forbidden() {
+		code = connect.CodePermissionDenied
+	}
+
+	err := connect.NewError(code, errors.New(string(d.Reason)))
+	if d.RetryAfter != rate.InfDuration && code == connect.CodeResourceExhausted {
+		err.Meta().Set("Retry-After", seconds(d.RetryAfter))
+	}
+	return err
+}
+
+// seconds formats d as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// peerIP is the default IPFunc, the peer address without its port.
+func peerIP(peer connect.Peer, _ http.Header) string {
+	host, _, err := net.SplitHostPort(peer.Addr)
+	if err != nil {
+		return peer.Addr
+	}
+	return host
+}
+
+// httpStatus maps a Connect code to the HTTP status of the Connect
+// protocol.
+func httpStatus(code connect.Code) int {
+	switch code {
+	case connect.CodeCanceled:
+		return 499
+	case connect.CodeInvalidArgument, connect.CodeFailedPrecondition, connect.CodeOutOfRange:
+		return http.StatusBadRequest
+	case connect.CodeDeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case connect.CodeNotFound:
+		return http.StatusNotFound
+	case connect.CodeAlreadyExists, connect.CodeAborted:
+		return http.StatusConflict
+	case connect.CodePermissionDenied:
+		return http.StatusForbidden
+	case connect.CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case connect.CodeUnimplemented:
+		return http.StatusNotImplemented
+	case connect.CodeUnavailable:
+		return http.StatusServiceUnavailable
+	case connect.CodeUnauthenticated:
+		return http.StatusUnauthorized
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,133 @@
+package botrateconnect
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+const procedure = "/test.v1.TestService/Ping"
+
+// newClient serves a Ping procedure with the interceptor, taking the
+// client IP from the X-Client-IP header, and returns a client for it.
+// Ping fails with NotFound when asked to by the X-Fail header.
+func newClient(t *testing.T, opts ...botrate.Option) (*connect.Client[emptypb.Empty, emptypb.Empty], *botrate.Limiter) {
+	t.Helper()
+
+	l, err := botrate.New(append([]botrate.Option{botrate.WithKnownbots(testbots.New(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	interceptor := New(l, WithIPFunc(func(peer connect.Peer, header http.Header) string {
+		return header.Get("X-Client-IP")
+	}))
+	h := connect.NewUnaryHandler(procedure,
+		func(ctx context.Context, req *connect.Request[emptypb.Empty]) (*connect.Response[emptypb.Empty], error) {
+			if req.Header().Get("X-Fail") != "" {
+				return nil, connect.NewError(connect.CodeNotFound, errors.New("not found"))
+			}
+			return connect.NewResponse(&emptypb.Empty{}), nil
+		},
+		connect.WithInterceptors(interceptor),
+	)
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return connect.NewClient[emptypb.Empty, emptypb.Empty](srv.Client(), srv.URL+procedure), l
+}
+
+func ping(c *connect.Client[emptypb.Empty, emptypb.Empty], ua, ip string, fail bool) error {
+	req := connect.NewRequest(&emptypb.Empty{})
+	req.Header().Set("User-Agent", ua)
+	req.Header().Set("X-Client-IP", ip)
+	if fail {
+		req.Header().Set("X-Fail", "1")
+	}
+	_, err := c.CallUnary(context.Background(), req)
+	return err
+}
+
+func TestInterceptor_Allowed(t *testing.T) {
+	c, _ := newClient(t)
+
+	if err := ping(c, "Mozilla/5.0", "192.168.1.1", false); err != nil {
+		t.Errorf("expected success, got %v", err)
+	}
+	if err := ping(c, "TestBot/1.0", "192.168.100.1", false); err != nil {
+		t.Errorf("verified bots should pass, got %v", err)
+	}
+}
+
+func TestInterceptor_Denied(t *testing.T) {
+	c, _ := newClient(t, botrate.WithDenyCIDRs([]string{"203.0.113.0/24"}))
+
+	if err := ping(c, "TestBot/1.0", "10.0.0.1", false); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Errorf("fake bots should get PermissionDenied, got %v", err)
+	}
+	if err := ping(c, "Mozilla/5.0", "203.0.113.1", false); connect.CodeOf(err) != connect.CodePermissionDenied {
+		t.Errorf("denylisted IPs should get PermissionDenied, got %v", err)
+	}
+}
+
+func TestInterceptor_RateLimited(t *testing.T) {
+	c, l := newClient(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerRequestThreshold(2),
+		botrate.WithLimit(rate.Every(time.Hour)),
+	)
+
+	for i := 0; i < 3; i++ {
+		ping(c, "Mozilla/5.0", "192.168.1.2", false)
+	}
+	err := ping(c, "Mozilla/5.0", "192.168.1.2", false)
+	if connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Fatalf("expected ResourceExhausted, got %v", err)
+	}
+	var ce *connect.Error
+	if !errors.As(err, &ce) || ce.Meta().Get("Retry-After") == "" {
+		t.Errorf("expected a Retry-After header, got %v", err)
+	}
+	if l.Stats().Denied[botrate.ReasonRateLimited] == 0 {
+		t.Error("denials should be counted")
+	}
+}
+
+func TestInterceptor_RecordResponse(t *testing.T) {
+	c, _ := newClient(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerErrorThreshold(2),
+	)
+
+	// Errors returned by handlers count with their HTTP status
+	for i := 0; i < 3; i++ {
+		if err := ping(c, "Mozilla/5.0", "192.168.1.3", true); connect.CodeOf(err) != connect.CodeNotFound {
+			t.Fatalf("expected NotFound, got %v", err)
+		}
+	}
+	if err := ping(c, "Mozilla/5.0", "192.168.1.3", false); connect.CodeOf(err) != connect.CodeResourceExhausted {
+		t.Errorf("clients hitting errors should be blocked, got %v", err)
+	}
+}
+
+func TestError(t *testing.T) {
+	err := Error(botrate.Decision{Reason: botrate.ReasonFakeBot, RetryAfter: rate.InfDuration})
+	if err.Code() != connect.CodePermissionDenied || err.Meta().Get("Retry-After") != "" {
+		t.Errorf("unexpected error for fake bots: %v %v", err, err.Meta())
+	}
+
+	err = Error(botrate.Decision{Reason: botrate.ReasonRateLimited, RetryAfter: 1500 * time.Millisecond})
+	if err.Code() != connect.CodeResourceExhausted || err.Meta().Get("Retry-After") != "2" {
+		t.Errorf("unexpected error for rate limits: %v %v", err, err.Meta())
+	}
+}
@@ -0,0 +1,19 @@
+module github.com/cnlangzi/botrate/botrateconnect
+
+go 1.22
+
+require (
+	connectrpc.com/connect v1.16.2
+	github.com/cnlangzi/botrate v0.0.0-00010101000000-000000000000
+	golang.org/x/time v0.7.0
+	google.golang.org/protobuf v1.34.1
+)
+
+require (
+	github.com/bits-and-blooms/bitset v1.24.2 // indirect
+	github.com/bits-and-blooms/bloom/v3 v3.7.1 // indirect
+	github.com/cnlangzi/knownbots v1.0.6 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/cnlangzi/botrate => ..
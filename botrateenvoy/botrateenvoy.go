@@ -0,0 +1,198 @@
+// Package botrateenvoy implements the Envoy external authorization gRPC
+// API (envoy.service.auth.v3.Authorization) on top of a botrate.Limiter,
+// so Envoy and Istio users can enforce botrate decisions at the mesh
+// edge.
+//
+// Point an ext_authz HTTP filter at a gRPC server the Server is
+// registered with. Envoy asks before forwarding each request; denied
+// requests get the response built by the DeniedFunc, with Retry-After
+// and RateLimit headers. Envoy does not report upstream responses to
+// ext_authz, so error and login thresholds, which need
+// Limiter.RecordResponse, do not apply.
+package botrateenvoy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Option is a functional option for configuring Server.
+type Option func(*Server)
+
+// IPFunc extracts the client IP from the attributes of a request.
+type IPFunc func(attrs *authv3.AttributeContext) string
+
+// UserFunc returns the authenticated user ID of a request, or "".
+type UserFunc func(attrs *authv3.AttributeContext) string
+
+// DeniedFunc returns the status, headers and body of the response for a
+// blocked request.
+type DeniedFunc func(reason botrate.Reason) (code int, header http.Header, body string)
+
+// WithIPFunc sets how the client IP is extracted. Defaults to the source
+// address, the downstream peer as Envoy sees it: configure
+// xff_num_trusted_hops on the connection manager behind proxies.
+func WithIPFunc(fn IPFunc) Option {
+	return func(s *Server) {
+		s.ip = fn
+	}
+}
+
+// WithUserFunc sets how the authenticated user of a request is found, see
+// botrate.Request.User.
+func WithUserFunc(fn UserFunc) Option {
+	return func(s *Server) {
+		s.user = fn
+	}
+}
+
+// WithDeniedHandler sets how the responses for blocked requests are
+// built, e.g. with a custom body.
+func WithDeniedHandler(fn DeniedFunc) Option {
+	return func(s *Server) {
+		s.denied = fn
+	}
+}
+
+// Server is an Envoy external authorization server.
+type Server struct {
+	l      *botrate.Limiter
+	ip     IPFunc
+	user   UserFunc
+	denied DeniedFunc
+}
+
+// New returns a server checking requests against l. Fake bots and
+// denylisted IPs are denied with 403 Forbidden, rate limited clients
+// with 429 Too Many Requests, unless WithDeniedHandler.
+func New(l *botrate.Limiter, opts ...Option) *Server {
+	s := &Server{
+		l:      l,
+		ip:     sourceIP,
+		denied: Denied,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Register registers the server with g.
+func (s *Server) Register(g *grpc.Server) {
+	authv3.RegisterAuthorizationServer(g, s)
+}
+
+// Check implements authv3.AuthorizationServer.
+func (s *Server) Check(ctx context.Context, req *authv3.CheckRequest) (*authv3.CheckResponse, error) {
+	attrs := req.GetAttributes()
+	h := attrs.GetRequest().GetHttp()
+
+	path, _, _ := strings.Cut(h.GetPath(), "?")
+	r := botrate.Request{
+		UA:     h.GetHeaders()["user-agent"],
+		IP:     s.ip(attrs),
+		Path:   path,
+		Method: h.GetMethod(),
+		Host:   h.GetHost(),
+	}
+	if s.user != nil {
+		r.User = s.user(attrs)
+	}
+
+	d := s.l.Check(r)
+	headers := rateLimitHeaders(d)
+	if d.Allowed {
+		return &authv3.CheckResponse{
+			Status: &status.Status{Code: int32(codes.OK)},
+			HttpResponse: &authv3.CheckResponse_OkResponse{
+				OkResponse: &authv3.OkHttpResponse{ResponseHeadersToAdd: headers},
+			},
+		}, nil
+	}
+
+	if d.RetryAfter != rate.InfDuration {
+		headers = append(headers, header("Retry-After", seconds(d.RetryAfter)))
+	}
+	code, hdr, body := s.denied(d.Reason)
+	for name, values := range hdr {
+		for _, v := range values {
+			headers = append(headers, header(name, v))
+		}
+	}
+	return &authv3.CheckResponse{
+		Status: &status.Status{Code: int32(codes.PermissionDenied), Message: string(d.Reason)},
+		HttpResponse: &authv3.CheckResponse_DeniedResponse{
+			DeniedResponse: &authv3.DeniedHttpResponse{
+				Status:  &typev3.HttpStatus{Code: typev3.StatusCode(code)},
+				Headers: headers,
+				Body:    body,
+			},
+		},
+	}, nil
+}
+
+// Denied is the default DeniedFunc. It answers 403 for reasons that are
+// Forbidden, 429 otherwise, with the status text as body.
+func Denied(reason botrate.Reason) (int, http.Header, string) {
+	code := http.StatusTooManyRequests
+	if reason.Forbidden() {
+		code = http.StatusForbidden
+	}
+	header := http.Header{
+		"Cache-Control": {"no-store"},
+		"Content-Type":  {"text/plain; charset=utf-8"},
+	}
+	return code, header, http.StatusText(code) + "\n"
+}
+
+// rateLimitHeaders returns the RateLimit headers of a throttled request,
+// like botratehttp.WriteRateLimitHeaders.
+func rateLimitHeaders(d botrate.Decision) []*corev3.HeaderValueOption {
+	if !d.Throttled {
+		return nil
+	}
+
+	headers := []*corev3.HeaderValueOption{
+		header("RateLimit-Limit", strconv.Itoa(d.Burst)),
+		header("RateLimit-Remaining", strconv.Itoa(d.Remaining)),
+	}
+	if d.Reset != rate.InfDuration {
+		headers = append(headers, header("RateLimit-Reset", seconds(d.Reset)))
+	}
+	return headers
+}
+
+func header(name, value string) *corev3.HeaderValueOption {
+	return &corev3.HeaderValueOption{
+		Header:       &corev3.HeaderValue{Key: name, Value: value},
+		AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+	}
+}
+
+// seconds formats d as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// sourceIP is the default IPFunc, the source socket address.
+func sourceIP(attrs *authv3.AttributeContext) string {
+	addr := attrs.GetSource().GetAddress().GetSocketAddress().GetAddress()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
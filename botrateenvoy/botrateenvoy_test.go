@@ -0,0 +1,124 @@
+package botrateenvoy
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	authv3 "github.com/envoyproxy/go-control-plane/envoy/service/auth/v3"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+)
+
+func newServer(t *testing.T, opts []botrate.Option, srvOpts ...Option) (*Server, *botrate.Limiter) {
+	t.Helper()
+
+	l, err := botrate.New(append([]botrate.Option{botrate.WithKnownbots(testbots.New(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+	return New(l, srvOpts...), l
+}
+
+func check(t *testing.T, s *Server, ua, ip, path string) *authv3.CheckResponse {
+	t.Helper()
+
+	req := &authv3.CheckRequest{
+		Attributes: &authv3.AttributeContext{
+			Source: &authv3.AttributeContext_Peer{
+				Address: &corev3.Address{Address: &corev3.Address_SocketAddress{
+					SocketAddress: &corev3.SocketAddress{Address: ip},
+				}},
+			},
+			Request: &authv3.AttributeContext_Request{
+				Http: &authv3.AttributeContext_HttpRequest{
+					Method:  http.MethodGet,
+					Path:    path,
+					Headers: map[string]string{"user-agent": ua},
+				},
+			},
+		},
+	}
+	res, err := s.Check(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Check() returned error: %v", err)
+	}
+	return res
+}
+
+func headerValue(headers []*corev3.HeaderValueOption, name string) string {
+	for _, h := range headers {
+		if h.GetHeader().GetKey() == name {
+			return h.GetHeader().GetValue()
+		}
+	}
+	return ""
+}
+
+func TestServer_Allowed(t *testing.T) {
+	s, _ := newServer(t, nil)
+
+	if res := check(t, s, "Mozilla/5.0", "192.168.1.1", "/?q=1"); res.GetStatus().GetCode() != int32(codes.OK) || res.GetOkResponse() == nil {
+		t.Errorf("expected OK, got %v", res)
+	}
+	if res := check(t, s, "TestBot/1.0", "192.168.100.1", "/"); res.GetStatus().GetCode() != int32(codes.OK) {
+		t.Errorf("verified bots should pass, got %v", res)
+	}
+}
+
+func TestServer_Denied(t *testing.T) {
+	s, _ := newServer(t, []botrate.Option{botrate.WithDenyCIDRs([]string{"203.0.113.0/24"})})
+
+	res := check(t, s, "TestBot/1.0", "10.0.0.1", "/")
+	if res.GetStatus().GetCode() != int32(codes.PermissionDenied) {
+		t.Fatalf("fake bots should be denied, got %v", res)
+	}
+	denied := res.GetDeniedResponse()
+	if denied.GetStatus().GetCode() != http.StatusForbidden || headerValue(denied.GetHeaders(), "Cache-Control") != "no-store" {
+		t.Errorf("fake bots should get 403, got %v", denied)
+	}
+	if res := check(t, s, "Mozilla/5.0", "203.0.113.1", "/"); res.GetDeniedResponse().GetStatus().GetCode() != http.StatusForbidden {
+		t.Errorf("denylisted IPs should get 403, got %v", res)
+	}
+}
+
+func TestServer_RateLimited(t *testing.T) {
+	s, l := newServer(t, []botrate.Option{
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerRequestThreshold(2),
+		botrate.WithLimit(rate.Every(time.Hour)),
+	})
+
+	for i := 0; i < 3; i++ {
+		check(t, s, "Mozilla/5.0", "192.168.1.2", "/")
+	}
+	denied := check(t, s, "Mozilla/5.0", "192.168.1.2", "/").GetDeniedResponse()
+	if denied.GetStatus().GetCode() != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %v", denied)
+	}
+	if headerValue(denied.GetHeaders(), "Retry-After") == "" || headerValue(denied.GetHeaders(), "RateLimit-Limit") == "" {
+		t.Errorf("expected Retry-After and RateLimit headers, got %v", denied.GetHeaders())
+	}
+	if l.Stats().Denied[botrate.ReasonRateLimited] == 0 {
+		t.Error("denials should be counted")
+	}
+}
+
+func TestServer_WithDeniedHandler(t *testing.T) {
+	s, _ := newServer(t, nil, WithDeniedHandler(func(reason botrate.Reason) (int, http.Header, string) {
+		return http.StatusTeapot, http.Header{"Content-Type": {"application/json"}}, `{"reason":"` + string(reason) + `"}`
+	}))
+
+	denied := check(t, s, "TestBot/1.0", "10.0.0.1", "/").GetDeniedResponse()
+	if denied.GetStatus().GetCode() != http.StatusTeapot || denied.GetBody() != `{"reason":"fake_bot"}` {
+		t.Errorf("expected the custom response, got %v", denied)
+	}
+	if headerValue(denied.GetHeaders(), "Content-Type") != "application/json" {
+		t.Errorf("expected the custom headers, got %v", denied.GetHeaders())
+	}
+}
@@ -0,0 +1,155 @@
+// Package botratefiber applies a botrate.Limiter to Fiber (fasthttp)
+// apps, like botratehttp.Middleware does for net/http.
+//
+// fasthttp reuses request memory once a handler returns, so the UA, IP
+// and path checked are copied. Requests have no *http.Request: a
+// botrate.KeyFunc gets nil. Tarpits and challenges are net/http only;
+// requests denied with those actions are rejected.
+package botratefiber
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/time/rate"
+)
+
+// Option is a functional option for configuring the middleware.
+type Option func(*config)
+
+// IPFunc extracts the client IP from a request.
+type IPFunc func(c *fiber.Ctx) string
+
+// UserFunc returns the authenticated user ID of a request, or "".
+type UserFunc func(c *fiber.Ctx) string
+
+// DeniedFunc writes the response for a blocked request.
+type DeniedFunc func(c *fiber.Ctx, reason botrate.Reason) error
+
+type config struct {
+	ip     IPFunc
+	user   UserFunc
+	denied DeniedFunc
+}
+
+// WithIPFunc sets how the client IP is extracted from a request.
+// Defaults to c.IP(), the peer address unless the app's ProxyHeader is
+// set; set it only with EnableTrustedProxyCheck and the proxy ranges.
+func WithIPFunc(fn IPFunc) Option {
+	return func(c *config) {
+		c.ip = fn
+	}
+}
+
+// WithUserFunc sets how the authenticated user of a request is found, see
+// botrate.Request.User.
+func WithUserFunc(fn UserFunc) Option {
+	return func(c *config) {
+		c.user = fn
+	}
+}
+
+// WithDeniedHandler sets the handler used to write blocked responses.
+func WithDeniedHandler(fn DeniedFunc) Option {
+	return func(c *config) {
+		c.denied = fn
+	}
+}
+
+// New returns a Fiber middleware that applies l to every request. Each
+// request is weighted with l.Cost, see botrate.WithCostFunc. Throttled
+// requests get RateLimit headers, and a Retry-After header when they are
+// denied but may proceed later. Response statuses of analyzed requests,
+// or of the errors returned by the next handlers, are fed back with
+// l.RecordResponse and l.RecordLogin.
+// Fake bots and denylisted IPs are rejected with 403 Forbidden, rate
+// limited clients with 429 Too Many Requests.
+func New(l *botrate.Limiter, opts ...Option) fiber.Handler {
+	cfg := config{
+		ip:     func(c *fiber.Ctx) string { return c.IP() },
+		denied: Denied,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return func(c *fiber.Ctx) error {
+		req := botrate.Request{
+			UA:     strings.Clone(string(c.Request().Header.UserAgent())),
+			IP:     strings.Clone(cfg.ip(c)),
+			Path:   strings.Clone(c.Path()),
+			Method: strings.Clone(c.Method()),
+			Host:   string(c.Request().Host()),
+		}
+		// fasthttp keeps the order headers were sent in
+		c.Request().Header.VisitAllInOrder(func(key, _ []byte) {
+			req.Headers.Add(string(key))
+		})
+		if cfg.user != nil {
+			req.User = strings.Clone(cfg.user(c))
+		}
+
+		d := l.Check(req)
+		WriteRateLimitHeaders(c, d)
+		if !d.Allowed {
+			if d.RetryAfter != rate.InfDuration {
+				c.Set(fiber.HeaderRetryAfter, seconds(d.RetryAfter))
+			}
+			return cfg.denied(c, d.Reason)
+		}
+
+		if d.Key == "" {
+			// Not analyzed: bots, allowlisted IPs and skipped paths
+			return c.Next()
+		}
+
+		err := c.Next()
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+		l.RecordResponse(d.Key, status)
+		l.RecordLogin(d.Key, req.Path, status)
+		return err
+	}
+}
+
+// WriteRateLimitHeaders writes the RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers for a throttled request, like
+// botratehttp.WriteRateLimitHeaders.
+func WriteRateLimitHeaders(c *fiber.Ctx, d botrate.Decision) {
+	if !d.Throttled {
+		return
+	}
+
+	c.Set("RateLimit-Limit", strconv.Itoa(d.Burst))
+	c.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	if d.Reset != rate.InfDuration {
+		c.Set("RateLimit-Reset", seconds(d.Reset))
+	}
+}
+
+// seconds formats d as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// Denied is the default DeniedFunc. It answers 403 for reasons that are
+// Forbidden, 429 otherwise.
+func Denied(c *fiber.Ctx, reason botrate.Reason) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	if reason.Forbidden() {
+		return c.SendStatus(fiber.StatusForbidden)
+	}
+	return c.SendStatus(fiber.StatusTooManyRequests)
+}
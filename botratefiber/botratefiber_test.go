@@ -0,0 +1,115 @@
+package botratefiber
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/gofiber/fiber/v2"
+	"golang.org/x/time/rate"
+)
+
+// newApp returns an app with the middleware, taking the client IP from
+// the X-Client-IP header.
+func newApp(t *testing.T, opts ...botrate.Option) (*fiber.App, *botrate.Limiter) {
+	t.Helper()
+
+	l, err := botrate.New(append([]botrate.Option{botrate.WithKnownbots(testbots.New(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	app := fiber.New()
+	app.Use(New(l, WithIPFunc(func(c *fiber.Ctx) string {
+		return c.Get("X-Client-IP")
+	})))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("ok")
+	})
+	app.Get("/missing", func(c *fiber.Ctx) error {
+		return fiber.ErrNotFound
+	})
+	return app, l
+}
+
+func serve(t *testing.T, app *fiber.App, path, ua, ip string) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("User-Agent", ua)
+	req.Header.Set("X-Client-IP", ip)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Test() returned error: %v", err)
+	}
+	return resp
+}
+
+func TestMiddleware_Allowed(t *testing.T) {
+	app, _ := newApp(t)
+
+	if resp := serve(t, app, "/", "Mozilla/5.0", "192.168.1.1"); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200, got %d", resp.StatusCode)
+	}
+	if resp := serve(t, app, "/", "TestBot/1.0", "192.168.100.1"); resp.StatusCode != http.StatusOK {
+		t.Errorf("verified bots should pass, got %d", resp.StatusCode)
+	}
+}
+
+func TestMiddleware_Denied(t *testing.T) {
+	app, _ := newApp(t, botrate.WithDenyCIDRs([]string{"203.0.113.0/24"}))
+
+	resp := serve(t, app, "/", "TestBot/1.0", "10.0.0.1")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("fake bots should get 403, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Error("denied responses should not be cached")
+	}
+	if resp := serve(t, app, "/", "Mozilla/5.0", "203.0.113.1"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("denylisted IPs should get 403, got %d", resp.StatusCode)
+	}
+}
+
+func TestMiddleware_RateLimited(t *testing.T) {
+	app, l := newApp(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerRequestThreshold(2),
+		botrate.WithLimit(rate.Every(time.Hour)),
+	)
+
+	for i := 0; i < 3; i++ {
+		serve(t, app, "/", "Mozilla/5.0", "192.168.1.2")
+	}
+	resp := serve(t, app, "/", "Mozilla/5.0", "192.168.1.2")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" || resp.Header.Get("RateLimit-Limit") == "" {
+		t.Errorf("expected Retry-After and RateLimit headers, got %v", resp.Header)
+	}
+	if l.Stats().Denied[botrate.ReasonRateLimited] == 0 {
+		t.Error("denials should be counted")
+	}
+}
+
+func TestMiddleware_RecordResponse(t *testing.T) {
+	app, _ := newApp(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerErrorThreshold(2),
+	)
+
+	// Errors returned by handlers count with their status
+	for i := 0; i < 3; i++ {
+		if resp := serve(t, app, "/missing", "Mozilla/5.0", "192.168.1.3"); resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("expected 404, got %d", resp.StatusCode)
+		}
+	}
+	if resp := serve(t, app, "/", "Mozilla/5.0", "192.168.1.3"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("clients hitting errors should be blocked, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,88 @@
+package botratehttp
+
+import (
+	"crypto/rand"
+	"net/http"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+)
+
+// Default bypass cookie settings, see BypassCookie.
+const (
+	DefaultBypassCookie = "botrate_bypass"
+	DefaultBypassTTL    = time.Hour
+)
+
+// BypassCookie carries botrate.BypassTokens in a cookie, bound to the
+// client IP. Clients with a valid one skip verification, analysis and
+// limiting, see botrate.Request.Bypass.
+type BypassCookie struct {
+	// Tokens signs and verifies the cookies. Required.
+	Tokens *botrate.BypassTokens
+
+	// Name is the cookie name. Defaults to DefaultBypassCookie.
+	Name string
+
+	// TTL is how long an issued cookie is valid. Defaults to
+	// DefaultBypassTTL.
+	TTL time.Duration
+}
+
+// WithBypassCookie makes the middleware honor bypass cookies, e.g. issued
+// with c.Issue after a login. Use the same cookie with WithChallenge.
+func WithBypassCookie(c *BypassCookie) MWOption {
+	return func(cfg *config) {
+		cfg.bypass = c
+	}
+}
+
+// Issue sets a bypass cookie for the client at ip on w, e.g. once the
+// user logged in. ip must be the IP the middleware extracts, see
+// WithIPFunc.
+func (c *BypassCookie) Issue(w http.ResponseWriter, r *http.Request, ip string) {
+	ttl := c.ttl()
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.name(),
+		Value:    c.Tokens.Issue(ip, ttl),
+		Path:     "/",
+		Expires:  time.Now().Add(ttl),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Verify reports whether r carries a valid bypass cookie for ip.
+func (c *BypassCookie) Verify(r *http.Request, ip string) bool {
+	cookie, err := r.Cookie(c.name())
+	if err != nil {
+		return false
+	}
+	return c.Tokens.Verify(cookie.Value, ip)
+}
+
+func (c *BypassCookie) name() string {
+	if c.Name == "" {
+		return DefaultBypassCookie
+	}
+	return c.Name
+}
+
+func (c *BypassCookie) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return DefaultBypassTTL
+	}
+	return c.TTL
+}
+
+// newBypassCookie returns a bypass cookie signed with a random key, which
+// invalidates cookies on restart and across replicas.
+func newBypassCookie() *BypassCookie {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("botratehttp: failed to generate bypass key: " + err.Error())
+	}
+	tokens, _ := botrate.NewBypassTokens(key)
+	return &BypassCookie{Tokens: tokens}
+}
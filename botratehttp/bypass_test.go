@@ -0,0 +1,77 @@
+package botratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"golang.org/x/time/rate"
+)
+
+func TestBypassCookie_IssueVerify(t *testing.T) {
+	tokens, err := botrate.NewBypassTokens([]byte("secret"))
+	if err != nil {
+		t.Fatalf("NewBypassTokens() returned error: %v", err)
+	}
+	c := &BypassCookie{Tokens: tokens}
+
+	rec := httptest.NewRecorder()
+	c.Issue(rec, httptest.NewRequest(http.MethodGet, "/", nil), "192.168.1.1")
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultBypassCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected one HttpOnly bypass cookie, got %+v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	if !c.Verify(r, "192.168.1.1") {
+		t.Error("issued cookie should verify")
+	}
+	if c.Verify(r, "192.168.1.2") {
+		t.Error("cookie should be bound to the IP")
+	}
+	if c.Verify(httptest.NewRequest(http.MethodGet, "/", nil), "192.168.1.1") {
+		t.Error("request without cookie should not verify")
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: DefaultBypassCookie, Value: tokens.Issue("192.168.1.1", -time.Second)})
+	if c.Verify(r, "192.168.1.1") {
+		t.Error("expired cookie should not verify")
+	}
+}
+
+func TestMiddleware_WithBypassCookie(t *testing.T) {
+	tokens, _ := botrate.NewBypassTokens([]byte("secret"))
+	c := &BypassCookie{Tokens: tokens}
+
+	h := newHandler(t, []botrate.Option{botrate.WithLimit(rate.Every(time.Hour))}, WithBypassCookie(c))
+
+	// Issued after a login
+	rec := httptest.NewRecorder()
+	c.Issue(rec, httptest.NewRequest(http.MethodGet, "/", nil), "10.0.0.1")
+
+	// A fake bot UA would be rejected, but the cookie skips verification
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "TestBot/1.0")
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.AddCookie(rec.Result().Cookies()[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("bypass cookie should skip the limiter, got %d", rec.Code)
+	}
+
+	// Still rejected after rotating the key away
+	tokens.Rotate([]byte("k2"))
+	tokens.Rotate([]byte("k3"))
+	tokens.Rotate([]byte("k4"))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cookie from a retired key should not bypass, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,278 @@
+package botratehttp
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultChallengePath is where the challenge form is posted, see
+// Challenge.
+const DefaultChallengePath = "/.botrate/challenge"
+
+// DefaultChallengeAttempts is how many challenge responses a client IP
+// may post a minute, see Challenge.Attempts.
+var DefaultChallengeAttempts = 5
+
+// Provider is a challenge provider such as Turnstile or hCaptcha.
+type Provider interface {
+	// Widget returns the HTML rendering the challenge inside a form.
+	Widget() template.HTML
+
+	// Field is the form field the widget submits its response in.
+	Field() string
+
+	// Verify reports whether response, submitted by a client at ip,
+	// passes the challenge.
+	Verify(ctx context.Context, response, ip string) (bool, error)
+}
+
+// Challenge configures WithChallenge.
+type Challenge struct {
+	// Provider verifies challenge responses. Required.
+	Provider Provider
+
+	// Path is where the challenge form is posted. Defaults to
+	// DefaultChallengePath.
+	Path string
+
+	// Page renders the challenge page. It is executed with a
+	// ChallengePage. Defaults to a minimal page.
+	Page *template.Template
+
+	// Bypass is the cookie issued to clients passing the challenge.
+	// Defaults to the WithBypassCookie one, or a cookie signed with a
+	// random key, which invalidates cookies on restart and across
+	// replicas.
+	Bypass *BypassCookie
+
+	// Attempts is how many challenge responses a client IP may post a
+	// minute, each verified with the provider; more are rejected with
+	// 429 Too Many Requests. Defaults to DefaultChallengeAttempts.
+	Attempts int
+
+	attempts *attempts
+}
+
+// ChallengePage is the data the challenge page is executed with.
+type ChallengePage struct {
+	// Action is the URL the form posts to.
+	Action string
+
+	// Return is the URL to return to after passing the challenge, to be
+	// posted in the "return" field.
+	Return string
+
+	// Widget is the provider's challenge widget.
+	Widget template.HTML
+
+	// Failed reports whether a previous attempt failed.
+	Failed bool
+}
+
+var defaultChallengePage = template.Must(template.New("challenge").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Checking your browser</title></head>
+<body>
+<h1>Checking your browser</h1>
+{{if .Failed}}<p>The check failed, please try again.</p>{{end}}
+<form method="POST" action="{{.Action}}">
+<input type="hidden" name="return" value="{{.Return}}">
+{{.Widget}}
+<button type="submit">Continue</button>
+</form>
+</body>
+</html>
+`))
+
+// WithChallenge serves requests denied with botrate.ActionChallenge, see
+// botrate.WithAction, a challenge page instead of rejecting them. Clients
+// passing it get a bypass cookie, see BypassCookie, so humans can recover
+// from false positives without operator intervention.
+func WithChallenge(c Challenge) MWOption {
+	return func(cfg *config) {
+		if c.Path == "" {
+			c.Path = DefaultChallengePath
+		}
+		if c.Page == nil {
+			c.Page = defaultChallengePage
+		}
+		if c.Attempts <= 0 {
+			c.Attempts = DefaultChallengeAttempts
+		}
+		c.attempts = &attempts{limit: c.Attempts, ips: make(map[string]attempt)}
+		cfg.challenge = &c
+	}
+}
+
+// serve writes the challenge page returning to ret with status.
+func (c *Challenge) serve(w http.ResponseWriter, ret string, failed bool, status int) {
+	h := w.Header()
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	c.Page.Execute(w, ChallengePage{
+		Action: c.Path,
+		Return: ret,
+		Widget: c.Provider.Widget(),
+		Failed: failed,
+	})
+}
+
+// verify handles a challenge form posted by the client at ip.
+func (c *Challenge) verify(w http.ResponseWriter, r *http.Request, ip string) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !c.attempts.allow(ip, time.Now()) {
+		w.Header().Set("Retry-After", seconds(time.Minute))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	ret := localURL(r.PostFormValue("return"))
+	ok, err := c.Provider.Verify(r.Context(), r.PostFormValue(c.Provider.Field()), ip)
+	if err != nil || !ok {
+		c.serve(w, ret, true, http.StatusForbidden)
+		return
+	}
+
+	c.Bypass.Issue(w, r, ip)
+	http.Redirect(w, r, ret, http.StatusSeeOther)
+}
+
+// attempts counts the challenge responses posted by IP in a minute.
+type attempts struct {
+	limit int
+
+	mu        sync.Mutex
+	ips       map[string]attempt
+	sweepSize int
+}
+
+type attempt struct {
+	n     int
+	start time.Time
+}
+
+// allow counts an attempt of ip, and reports whether ip has attempts
+// left this minute.
+func (a *attempts) allow(ip string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	at := a.ips[ip]
+	if now.Sub(at.start) >= time.Minute {
+		at = attempt{start: now}
+	}
+	if at.n >= a.limit {
+		return false
+	}
+	at.n++
+
+	// Drop the minutes ended once the map doubles, so it stays bounded
+	// by the IPs posting in the last minute
+	if len(a.ips) >= a.sweepSize {
+		for k, v := range a.ips {
+			if now.Sub(v.start) >= time.Minute {
+				delete(a.ips, k)
+			}
+		}
+		a.sweepSize = max(2*len(a.ips), 1024)
+	}
+	a.ips[ip] = at
+	return true
+}
+
+// localURL returns u if it is a path on this site, "/" otherwise, so the
+// challenge cannot be used as an open redirect.
+func localURL(u string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return "/"
+	}
+	return u
+}
+
+// siteVerify is a provider verifying responses with a siteverify
+// endpoint, as Turnstile and hCaptcha do.
+type siteVerify struct {
+	url    string
+	field  string
+	widget template.HTML
+	secret string
+	client *http.Client
+}
+
+// Turnstile returns a Cloudflare Turnstile provider.
+func Turnstile(siteKey, secret string) Provider {
+	return &siteVerify{
+		url:    "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		field:  "cf-turnstile-response",
+		widget: widget("https://challenges.cloudflare.com/turnstile/v0/api.js", "cf-turnstile", siteKey),
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// HCaptcha returns an hCaptcha provider.
+func HCaptcha(siteKey, secret string) Provider {
+	return &siteVerify{
+		url:    "https://api.hcaptcha.com/siteverify",
+		field:  "h-captcha-response",
+		widget: widget("https://js.hcaptcha.com/1/api.js", "h-captcha", siteKey),
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func widget(script, class, siteKey string) template.HTML {
+	return template.HTML(`<script src="` + script + `" async defer></script>` +
+		`<div class="` + class + `" data-sitekey="` + template.HTMLEscapeString(siteKey) + `"></div>`)
+}
+
+func (s *siteVerify) Widget() template.HTML {
+	return s.widget
+}
+
+func (s *siteVerify) Field() string {
+	return s.field
+}
+
+func (s *siteVerify) Verify(ctx context.Context, response, ip string) (bool, error) {
+	if response == "" {
+		return false, nil
+	}
+
+	form := url.Values{
+		"secret":   {s.secret},
+		"response": {response},
+		"remoteip": {ip},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var out struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return false, err
+	}
+	return out.Success, nil
+}
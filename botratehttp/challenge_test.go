@@ -0,0 +1,182 @@
+package botratehttp
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+// fakeProvider passes responses equal to "ok".
+type fakeProvider struct{}
+
+func (fakeProvider) Widget() template.HTML { return `<div id="widget"></div>` }
+func (fakeProvider) Field() string         { return "token" }
+func (fakeProvider) Verify(ctx context.Context, response, ip string) (bool, error) {
+	return response == "ok", nil
+}
+
+func newChallengeHandler(t *testing.T) (http.Handler, *botrate.Limiter) {
+	t.Helper()
+
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithLimit(rate.Every(time.Hour)),
+		botrate.WithAction(botrate.ReasonRateLimited, botrate.ActionChallenge),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	h := Middleware(l, WithChallenge(Challenge{Provider: fakeProvider{}}))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+	return h, l
+}
+
+func postChallenge(h http.Handler, token, ret, remoteAddr string) *httptest.ResponseRecorder {
+	form := url.Values{"token": {token}, "return": {ret}}
+	req := httptest.NewRequest(http.MethodPost, DefaultChallengePath, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_Challenge(t *testing.T) {
+	h, l := newChallengeHandler(t)
+
+	l.Block("192.168.1.1", time.Hour)
+	serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+
+	// Denied: challenged instead of rejected
+	rec := serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `<div id="widget"></div>`) {
+		t.Fatalf("expected challenge page, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	// Failed attempt: challenged again
+	rec = postChallenge(h, "bad", "/page", "192.168.1.1:1234")
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "try again") {
+		t.Errorf("failed attempt should be challenged again, got %d", rec.Code)
+	}
+
+	// Passed: bypass cookie and redirect back
+	rec = postChallenge(h, "ok", "/page", "192.168.1.1:1234")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/page" {
+		t.Fatalf("expected redirect back, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultBypassCookie {
+		t.Fatalf("expected bypass cookie, got %v", cookies)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	req.AddCookie(cookies[0])
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("bypass cookie should skip the limiter, got %d", rec.Code)
+	}
+
+	// The cookie is bound to the IP
+	l.Block("192.168.1.2", time.Hour)
+	serve(h, "Mozilla/5.0", "192.168.1.2:1234")
+	req.RemoteAddr = "192.168.1.2:1234"
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("cookie from another IP should not bypass, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Challenge_MethodNotAllowed(t *testing.T) {
+	h, _ := newChallengeHandler(t)
+
+	req := httptest.NewRequest(http.MethodGet, DefaultChallengePath, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Challenge_Attempts(t *testing.T) {
+	h, _ := newChallengeHandler(t)
+
+	for i := 0; i < DefaultChallengeAttempts; i++ {
+		if rec := postChallenge(h, "bad", "/", "192.168.1.1:1234"); rec.Code != http.StatusForbidden {
+			t.Fatalf("attempt %d should be verified, got %d", i+1, rec.Code)
+		}
+	}
+	if rec := postChallenge(h, "ok", "/", "192.168.1.1:1234"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("attempts past the limit should be rejected, got %d", rec.Code)
+	}
+	if rec := postChallenge(h, "ok", "/", "192.168.1.2:1234"); rec.Code != http.StatusSeeOther {
+		t.Errorf("attempts should be counted by IP, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Challenge_Denylisted(t *testing.T) {
+	h, l := newChallengeHandler(t)
+	l.AddDeny("192.168.1.1")
+
+	if rec := postChallenge(h, "ok", "/", "192.168.1.1:1234"); rec.Code != http.StatusForbidden || len(rec.Result().Cookies()) != 0 {
+		t.Errorf("denylisted IPs should not pass the challenge, got %d", rec.Code)
+	}
+}
+
+func TestLocalURL(t *testing.T) {
+	testCases := map[string]string{
+		"/page?q=1":          "/page?q=1",
+		"":                   "/",
+		"https://evil.com/":  "/",
+		"//evil.com/":        "/",
+		"/\\evil.com/":       "/",
+		"javascript:alert()": "/",
+	}
+
+	for in, want := range testCases {
+		if got := localURL(in); got != want {
+			t.Errorf("localURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSiteVerify(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("secret") != "secret" || r.PostFormValue("remoteip") != "192.168.1.1" {
+			t.Errorf("unexpected form %v", r.PostForm)
+		}
+		w.Write([]byte(`{"success":` + map[bool]string{true: "true", false: "false"}[r.PostFormValue("response") == "ok"] + `}`))
+	}))
+	defer srv.Close()
+
+	p := Turnstile("site", "secret").(*siteVerify)
+	p.url = srv.URL
+
+	if ok, err := p.Verify(context.Background(), "ok", "192.168.1.1"); !ok || err != nil {
+		t.Errorf("expected success, got %v %v", ok, err)
+	}
+	if ok, err := p.Verify(context.Background(), "bad", "192.168.1.1"); ok || err != nil {
+		t.Errorf("expected failure, got %v %v", ok, err)
+	}
+	if ok, _ := p.Verify(context.Background(), "", "192.168.1.1"); ok {
+		t.Error("empty response should fail without a request")
+	}
+	if !strings.Contains(string(HCaptcha("site\"", "secret").Widget()), `data-sitekey="site&#34;"`) {
+		t.Error("site key should be escaped")
+	}
+}
@@ -0,0 +1,237 @@
+package botratehttp
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"golang.org/x/time/rate"
+)
+
+// MWOption is a functional option for configuring the middleware.
+type MWOption func(*config)
+
+// IPFunc extracts the client IP from a request.
+type IPFunc func(r *http.Request) string
+
+// UserFunc returns the authenticated user ID of a request, or "".
+type UserFunc func(r *http.Request) string
+
+// TLSFingerprintFunc returns the JA3 or JA4 hash of a request's TLS
+// handshake, or "".
+type TLSFingerprintFunc func(r *http.Request) string
+
+// DeniedFunc writes the response for a blocked request.
+type DeniedFunc func(w http.ResponseWriter, r *http.Request, reason botrate.Reason)
+
+type config struct {
+	ip        IPFunc
+	user      UserFunc
+	tls       TLSFingerprintFunc
+	denied    DeniedFunc
+	session   *session
+	tarpit    *tarpit
+	challenge *Challenge
+	bypass    *BypassCookie
+}
+
+// WithIPFunc sets how the client IP is extracted from a request.
+// Defaults to botrate.IPExtractor(nil), the peer address. Behind a proxy,
+// use botrate.IPExtractor with the proxy ranges.
+func WithIPFunc(fn IPFunc) MWOption {
+	return func(c *config) {
+		c.ip = fn
+	}
+}
+
+// WithUserFunc sets how the authenticated user of a request is found, see
+// botrate.Request.User.
+func WithUserFunc(fn UserFunc) MWOption {
+	return func(c *config) {
+		c.user = fn
+	}
+}
+
+// WithUserHeader reads the authenticated user ID from header name. Only use
+// it when a trusted proxy or auth layer sets the header and strips it from
+// client requests.
+func WithUserHeader(name string) MWOption {
+	return WithUserFunc(func(r *http.Request) string {
+		return r.Header.Get(name)
+	})
+}
+
+// WithTLSFingerprintFunc sets how the TLS client fingerprint of a request
+// is found, see botrate.Request.TLSFingerprint, e.g. from the connection
+// context where the server's tls.Config recorded the ClientHello.
+func WithTLSFingerprintFunc(fn TLSFingerprintFunc) MWOption {
+	return func(c *config) {
+		c.tls = fn
+	}
+}
+
+// WithTLSFingerprintHeader reads the TLS client fingerprint from header
+// name. Only use it when a trusted proxy terminating TLS sets the header
+// and strips it from client requests.
+func WithTLSFingerprintHeader(name string) MWOption {
+	return WithTLSFingerprintFunc(func(r *http.Request) string {
+		return r.Header.Get(name)
+	})
+}
+
+// WithDeniedHandler sets the handler used to write blocked responses.
+func WithDeniedHandler(fn DeniedFunc) MWOption {
+	return func(c *config) {
+		c.denied = fn
+	}
+}
+
+// Middleware returns a net/http middleware that applies l to every request.
+// Each request is weighted with l.Cost, see botrate.WithCostFunc.
+// Throttled requests get RateLimit headers, see WriteRateLimitHeaders,
+// and a Retry-After header when they are denied but may proceed later.
+// Response statuses of analyzed requests are fed back with
+// l.RecordResponse and l.RecordLogin.
+// Fake bots and denylisted IPs are rejected with 403 Forbidden, rate
+// limited clients with 429 Too Many Requests. Requests denied with
+// botrate.ActionTarpit are held first, see WithTarpit, and requests
+// denied with botrate.ActionChallenge are challenged, see WithChallenge.
+// Allowed WebSocket upgrades carry a botrate.Conn to charge their
+// messages with, see ConnFrom.
+func Middleware(l *botrate.Limiter, opts ...MWOption) func(http.Handler) http.Handler {
+	cfg := config{
+		ip:     botrate.IPExtractor(nil),
+		denied: Denied,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.tarpit == nil {
+		cfg.tarpit = newTarpit(DefaultTarpitMin, DefaultTarpitMax, DefaultTarpitHeld)
+	}
+	if c := cfg.challenge; c != nil && c.Bypass == nil {
+		if cfg.bypass == nil {
+			cfg.bypass = newBypassCookie()
+		}
+		c.Bypass = cfg.bypass
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := botrate.Request{
+				UA:      r.UserAgent(),
+				IP:      cfg.ip(r),
+				Path:    r.URL.Path,
+				Method:  r.Method,
+				Host:    r.Host,
+				Headers: botrate.HeaderFingerprint(r.Header),
+				HTTP:    r,
+			}
+			if cfg.challenge != nil && r.URL.Path == cfg.challenge.Path {
+				// Denylisted IPs don't get to reach the provider
+				if l.Denylisted(req.IP) {
+					cfg.denied(w, r, botrate.ReasonDenied)
+					return
+				}
+				cfg.challenge.verify(w, r, req.IP)
+				return
+			}
+			if cfg.bypass != nil {
+				req.Bypass = cfg.bypass.Verify(r, req.IP)
+			}
+			if cfg.challenge != nil && !req.Bypass && cfg.challenge.Bypass != cfg.bypass {
+				req.Bypass = cfg.challenge.Bypass.Verify(r, req.IP)
+			}
+			if cfg.user != nil {
+				req.User = cfg.user(r)
+			}
+			if cfg.tls != nil {
+				req.TLSFingerprint = cfg.tls(r)
+			}
+			if cfg.session != nil {
+				req.Session = cfg.session.get(r)
+			}
+
+			d := l.Check(req)
+			WriteRateLimitHeaders(w, d)
+			if !d.Allowed {
+				if d.Action == botrate.ActionChallenge && cfg.challenge != nil {
+					cfg.challenge.serve(w, r.URL.RequestURI(), false, http.StatusForbidden)
+					return
+				}
+				if d.Action == botrate.ActionTarpit {
+					key := d.Key
+					if key == "" {
+						key = req.IP
+					}
+					cfg.tarpit.hold(r, key)
+				}
+				if d.RetryAfter != rate.InfDuration {
+					w.Header().Set("Retry-After", seconds(d.RetryAfter))
+				}
+				cfg.denied(w, r, d.Reason)
+				return
+			}
+
+			// Sessions are only issued to allowed clients, or a blocked
+			// one would get a fresh key to come back with
+			if cfg.session != nil && req.Session == "" {
+				cfg.session.issue(w, r)
+			}
+
+			if isWebSocket(r) {
+				r = withConn(r, l.Conn(req))
+			}
+
+			if d.Key == "" {
+				// Not analyzed: bots, allowlisted IPs and skipped paths
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			l.RecordResponse(d.Key, sw.status)
+			l.RecordLogin(d.Key, r.URL.Path, sw.status)
+		})
+	}
+}
+
+// WriteRateLimitHeaders writes the RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset headers (draft-ietf-httpapi-ratelimit-headers) for a
+// throttled request, so clients and well-behaved crawlers can
+// self-throttle. It writes nothing when the request was not throttled.
+func WriteRateLimitHeaders(w http.ResponseWriter, d botrate.Decision) {
+	if !d.Throttled {
+		return
+	}
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(d.Burst))
+	h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+	if d.Reset != rate.InfDuration {
+		h.Set("RateLimit-Reset", seconds(d.Reset))
+	}
+}
+
+// seconds formats d as whole seconds, rounded up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64((d+time.Second-1)/time.Second), 10)
+}
+
+// Denied is the default DeniedFunc. It answers 403 for reasons that are
+// Forbidden, 429 otherwise.
+func Denied(w http.ResponseWriter, r *http.Request, reason botrate.Reason) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if reason.Forbidden() {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	} else {
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	}
+}
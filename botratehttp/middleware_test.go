@@ -0,0 +1,283 @@
+package botratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func newHandler(t *testing.T, opts []botrate.Option, mwOpts ...MWOption) http.Handler {
+	t.Helper()
+
+	l, err := botrate.New(append([]botrate.Option{botrate.WithKnownbots(testbots.New(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return Middleware(l, mwOpts...)(next)
+}
+
+func serve(h http.Handler, ua, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", ua)
+	req.RemoteAddr = remoteAddr
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddleware_Allowed(t *testing.T) {
+	h := newHandler(t, nil)
+
+	rec := serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rec.Code)
+	}
+	if rec.Body.String() != "ok" {
+		t.Errorf("expected next handler to run, got %q", rec.Body.String())
+	}
+}
+
+func TestMiddleware_VerifiedBot(t *testing.T) {
+	h := newHandler(t, nil)
+
+	rec := serve(h, "TestBot/1.0", "192.168.100.42:1234")
+	if rec.Code != http.StatusOK {
+		t.Errorf("verified bot should be allowed, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_FakeBot(t *testing.T) {
+	h := newHandler(t, nil)
+
+	rec := serve(h, "TestBot/1.0", "10.0.0.1:1234")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("fake bot should get 403, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "" {
+		t.Errorf("rejected fake bot should not get Retry-After, got %q", got)
+	}
+}
+
+func TestMiddleware_RateLimited(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithLimit(rate.Every(time.Hour)),
+		botrate.WithAnalyzerWindow(time.Hour),
+		botrate.WithAnalyzerPageThreshold(1),
+	})
+
+	if rec := serve(h, "Mozilla/5.0", "192.168.1.1:1234"); rec.Code != http.StatusOK {
+		t.Fatalf("first request should be allowed, got %d", rec.Code)
+	}
+
+	time.Sleep(time.Millisecond * 200)
+
+	// Burst of 1 for the blocked IP, then limited.
+	serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+
+	rec := serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("blocked IP should get 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3600" {
+		t.Errorf("expected Retry-After of an hour, got %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Errorf("expected RateLimit-Remaining 0, got %q", got)
+	}
+}
+
+func TestMiddleware_HeaderThreshold(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithLimit(rate.Every(time.Hour)),
+		botrate.WithBurst(1),
+		botrate.WithHeaderThreshold(1),
+		botrate.WithSyncAnalyzer(true),
+	})
+
+	// A browser UA without any other header
+	serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	if rec := serve(h, "Mozilla/5.0", "192.168.1.1:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("browser UAs without browser headers should be blocked, got %d", rec.Code)
+	}
+}
+
+func TestWriteRateLimitHeaders(t *testing.T) {
+	rec := httptest.NewRecorder()
+	WriteRateLimitHeaders(rec, botrate.Decision{Allowed: true})
+	if len(rec.Header()) != 0 {
+		t.Errorf("unthrottled decision should write no headers, got %v", rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	WriteRateLimitHeaders(rec, botrate.Decision{
+		Throttled: true,
+		Burst:     10,
+		Remaining: 3,
+		Reset:     1500 * time.Millisecond,
+	})
+	want := map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "3",
+		"RateLimit-Reset":     "2",
+	}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s = %q, want %q", k, got, v)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	WriteRateLimitHeaders(rec, botrate.Decision{Throttled: true, Reset: rate.InfDuration})
+	if got := rec.Header().Get("RateLimit-Reset"); got != "" {
+		t.Errorf("infinite reset should be omitted, got %q", got)
+	}
+}
+
+func TestMiddleware_CostFunc(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithFakeBotLimit(rate.Every(time.Hour)),
+		botrate.WithBurst(2),
+		botrate.WithCostFunc(func(path, method string) int {
+			if path == "/search" {
+				return 2
+			}
+			return 1
+		}),
+	})
+
+	get := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "TestBot/1.0")
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("/"); code != http.StatusOK {
+		t.Fatalf("cheap request should fit the burst, got %d", code)
+	}
+	if code := get("/search"); code != http.StatusForbidden {
+		t.Errorf("expensive request should exceed the remaining budget, got %d", code)
+	}
+	if code := get("/"); code != http.StatusOK {
+		t.Errorf("remaining token should still be usable, got %d", code)
+	}
+}
+
+func TestMiddleware_WithIPFunc(t *testing.T) {
+	h := newHandler(t, nil, WithIPFunc(func(r *http.Request) string {
+		return r.Header.Get("X-Test-IP")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "TestBot/1.0")
+	req.Header.Set("X-Test-IP", "192.168.100.42")
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("custom IP func should be used, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_TrustedProxy(t *testing.T) {
+	h := newHandler(t, nil, WithIPFunc(botrate.IPExtractor([]netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "TestBot/1.0")
+	req.Header.Set("X-Forwarded-For", "192.168.100.42")
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("client IP behind a trusted proxy should be used, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_SpoofedHeader(t *testing.T) {
+	h := newHandler(t, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", "TestBot/1.0")
+	req.Header.Set("X-Forwarded-For", "192.168.100.42")
+	req.RemoteAddr = "10.0.0.1:1234"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("forwarded headers should be ignored by default, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_WithDeniedHandler(t *testing.T) {
+	var got botrate.Reason
+	h := newHandler(t, nil, WithDeniedHandler(func(w http.ResponseWriter, r *http.Request, reason botrate.Reason) {
+		got = reason
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := serve(h, "TestBot/1.0", "10.0.0.1:1234")
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("expected custom status, got %d", rec.Code)
+	}
+	if got != botrate.ReasonFakeBot {
+		t.Errorf("expected reason %s, got %s", botrate.ReasonFakeBot, got)
+	}
+}
+
+func TestMiddleware_Denied(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithDenyCIDRs([]string{"10.0.0.0/8"}),
+	})
+
+	rec := serve(h, "Mozilla/5.0", "10.0.0.1:1234")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("denylisted IP should get 403, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_Honeypot(t *testing.T) {
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithHoneypotPaths("/trap"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest(http.MethodGet, "/trap", nil)
+	req.RemoteAddr = "192.168.1.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("trap path should spend the burst, got headers %v", rec.Header())
+	}
+
+	if rec := serve(h, "Mozilla/5.0", "192.168.1.1:1234"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("trapped IP should be rejected, got %d", rec.Code)
+	}
+}
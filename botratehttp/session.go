@@ -0,0 +1,80 @@
+package botratehttp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// DefaultSessionCookie is the cookie name used by WithSessionCookie when
+// name is empty.
+const DefaultSessionCookie = "botrate_session"
+
+// session issues and verifies signed session cookies.
+type session struct {
+	name   string
+	secret []byte
+}
+
+// WithSessionCookie keys analysis on a signed session cookie instead of
+// the IP, so users sharing a CGNAT address are not blocked together while
+// cookie-less scrapers, keyed on their IP, stand out. Allowed clients
+// without a valid cookie are issued one, and sessions are blocked along
+// with their IP. The secret signs cookies; nil generates a
+// random one, which invalidates cookies on restart and across replicas.
+func WithSessionCookie(name string, secret []byte) MWOption {
+	return func(c *config) {
+		if name == "" {
+			name = DefaultSessionCookie
+		}
+		if secret == nil {
+			secret = make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				panic("botratehttp: failed to generate session secret: " + err.Error())
+			}
+		}
+		c.session = &session{name: name, secret: secret}
+	}
+}
+
+// get returns the session ID from r's cookie, or "" when it is missing or
+// its signature does not match.
+func (s *session) get(r *http.Request) string {
+	c, err := r.Cookie(s.name)
+	if err != nil {
+		return ""
+	}
+
+	id, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || id == "" || !hmac.Equal([]byte(sig), []byte(s.sign(id))) {
+		return ""
+	}
+	return id
+}
+
+// issue sets a new session cookie on w.
+func (s *session) issue(w http.ResponseWriter, r *http.Request) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.name,
+		Value:    id + "." + s.sign(id),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *session) sign(id string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,157 @@
+package botratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestSession_GetIssue(t *testing.T) {
+	s := &session{name: DefaultSessionCookie, secret: []byte("secret")}
+
+	rec := httptest.NewRecorder()
+	s.issue(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultSessionCookie || !cookies[0].HttpOnly {
+		t.Fatalf("expected one HttpOnly session cookie, got %+v", cookies)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	if id := s.get(r); id == "" {
+		t.Error("issued cookie should verify")
+	}
+
+	// Another secret does not accept it
+	other := &session{name: DefaultSessionCookie, secret: []byte("other")}
+	if id := other.get(r); id != "" {
+		t.Errorf("cookie signed with another secret should be rejected, got %q", id)
+	}
+
+	forged := httptest.NewRequest(http.MethodGet, "/", nil)
+	forged.AddCookie(&http.Cookie{Name: DefaultSessionCookie, Value: "abc.def"})
+	if id := s.get(forged); id != "" {
+		t.Errorf("forged cookie should be rejected, got %q", id)
+	}
+}
+
+func TestMiddleware_WithSessionCookie(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithLimit(rate.Every(time.Hour)),
+		botrate.WithAnalyzerWindow(time.Hour),
+		botrate.WithAnalyzerPageThreshold(2),
+	}, WithSessionCookie("sid", []byte("secret")))
+
+	get := func(path string, c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.RemoteAddr = "100.64.0.1:1234"
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// First visits get a cookie
+	alice := get("/", nil).Result().Cookies()
+	bob := get("/", nil).Result().Cookies()
+	if len(alice) != 1 || len(bob) != 1 || alice[0].Name != "sid" {
+		t.Fatalf("expected session cookies, got %+v %+v", alice, bob)
+	}
+
+	// Returning visitors are not issued a new one
+	if rec := get("/", alice[0]); len(rec.Result().Cookies()) != 0 {
+		t.Error("valid session should not be reissued")
+	}
+
+	// Alice scrapes behind the shared address, bob browses normally
+	get("/a", alice[0])
+	get("/b", alice[0])
+	time.Sleep(200 * time.Millisecond)
+
+	get("/a", alice[0]) // burst
+	if rec := get("/a", alice[0]); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("scraping session should be limited, got %d", rec.Code)
+	}
+	if rec := get("/a", bob[0]); rec.Code != http.StatusOK {
+		t.Errorf("other session on the same IP should be allowed, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_WithSessionCookie_Blocked(t *testing.T) {
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithLimit(rate.Every(time.Hour)),
+		botrate.WithBurst(1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+	if err := l.Block("100.64.0.1", time.Hour); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	h := Middleware(l, WithSessionCookie("sid", []byte("secret")))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	get := func(c *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.RemoteAddr = "100.64.0.1:1234"
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// The burst is spent, then the blocked client is denied without a
+	// cookie
+	get(nil)
+	rec := get(nil)
+	if rec.Code != http.StatusTooManyRequests || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("denied request should get no session, got %d %+v", rec.Code, rec.Result().Cookies())
+	}
+
+	// A session issued before the block does not escape it
+	s := &session{name: "sid", secret: []byte("secret")}
+	issued := httptest.NewRecorder()
+	s.issue(issued, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec := get(issued.Result().Cookies()[0]); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("session of a blocked IP should be denied, got %d", rec.Code)
+	}
+}
+
+func TestMiddleware_WithUserHeader(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithLimit(rate.Every(time.Hour)),
+		botrate.WithExemptUsers(true),
+	}, WithUserHeader("X-User"))
+
+	get := func(user string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("User-Agent", "TestBot/1.0")
+		req.RemoteAddr = "10.0.0.1:1234"
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get("42"); code != http.StatusOK {
+		t.Errorf("exempt user should be allowed, got %d", code)
+	}
+	if code := get(""); code != http.StatusForbidden {
+		t.Errorf("anonymous fake bot should be rejected, got %d", code)
+	}
+}
@@ -0,0 +1,105 @@
+package botratehttp
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default tarpit settings, see WithTarpit.
+var (
+	DefaultTarpitMin  = 2 * time.Second
+	DefaultTarpitMax  = 30 * time.Second
+	DefaultTarpitHeld = 100
+)
+
+// tarpit delays denied requests with botrate.ActionTarpit. The delay
+// doubles from min to max on each tarpitted request by the same key, and
+// at most held requests are delayed at once; the rest are rejected right
+// away.
+type tarpit struct {
+	min, max time.Duration
+	slots    chan struct{}
+
+	mu   sync.Mutex
+	hits map[string]tarpitHit
+}
+
+type tarpitHit struct {
+	delay time.Duration
+	last  time.Time
+}
+
+func newTarpit(min, max time.Duration, held int) *tarpit {
+	if held <= 0 {
+		held = DefaultTarpitHeld
+	}
+	if max < min {
+		max = min
+	}
+	return &tarpit{
+		min:   min,
+		max:   max,
+		slots: make(chan struct{}, held),
+		hits:  make(map[string]tarpitHit),
+	}
+}
+
+// WithTarpit sets how requests denied with botrate.ActionTarpit, see
+// botrate.WithAction, are held: the delay doubles from min to max on
+// each tarpitted request by the same client, and at most held requests
+// are held at once. Requests over the budget are rejected right away.
+// Defaults to DefaultTarpitMin, DefaultTarpitMax and DefaultTarpitHeld.
+func WithTarpit(min, max time.Duration, held int) MWOption {
+	return func(c *config) {
+		c.tarpit = newTarpit(min, max, held)
+	}
+}
+
+// hold delays the request of key, and reports whether it did. It returns
+// early when the client goes away.
+func (t *tarpit) hold(r *http.Request, key string) bool {
+	select {
+	case t.slots <- struct{}{}:
+	default:
+		return false
+	}
+	defer func() { <-t.slots }()
+
+	timer := time.NewTimer(t.delay(key, time.Now()))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+	return true
+}
+
+// delay returns the next delay for key. Keys idle for longer than max
+// start over at min.
+func (t *tarpit) delay(key string, now time.Time) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h, ok := t.hits[key]
+	switch {
+	case !ok || now.Sub(h.last) > 2*t.max:
+		h.delay = t.min
+	case h.delay < t.max:
+		h.delay = min(2*h.delay, t.max)
+	}
+	h.last = now
+	t.hits[key] = h
+
+	// Drop idle keys once the map grows, so it stays bounded by the
+	// number of clients tarpitted recently
+	if len(t.hits) > 4*cap(t.slots) {
+		for k, v := range t.hits {
+			if now.Sub(v.last) > 2*t.max {
+				delete(t.hits, k)
+			}
+		}
+	}
+	return h.delay
+}
@@ -0,0 +1,80 @@
+package botratehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestTarpit_Delay(t *testing.T) {
+	tp := newTarpit(2*time.Second, 10*time.Second, 1)
+	now := time.Now()
+
+	want := []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if d := tp.delay("192.168.1.1", now); d != w {
+			t.Errorf("hit %d: got %v, want %v", i+1, d, w)
+		}
+	}
+
+	if d := tp.delay("192.168.1.2", now); d != 2*time.Second {
+		t.Errorf("other keys should start at min, got %v", d)
+	}
+	if d := tp.delay("192.168.1.1", now.Add(time.Minute)); d != 2*time.Second {
+		t.Errorf("idle keys should start over, got %v", d)
+	}
+}
+
+func TestTarpit_Hold(t *testing.T) {
+	tp := newTarpit(time.Hour, time.Hour, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+
+	held := make(chan bool)
+	go func() { held <- tp.hold(r, "192.168.1.1") }()
+
+	// Wait for the slot to be taken
+	for len(tp.slots) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if tp.hold(r, "192.168.1.2") {
+		t.Error("requests over the budget should not be held")
+	}
+
+	// The client going away releases the slot
+	cancel()
+	if !<-held {
+		t.Error("first request should be held")
+	}
+	if len(tp.slots) != 0 {
+		t.Error("slot should be released")
+	}
+}
+
+func TestMiddleware_Tarpit(t *testing.T) {
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithAction(botrate.ReasonFakeBot, botrate.ActionTarpit),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	h := Middleware(l, WithTarpit(50*time.Millisecond, time.Second, 1))(http.NotFoundHandler())
+
+	start := time.Now()
+	rec := serve(h, "TestBot/1.0", "10.0.0.1:1234")
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("tarpitted fake bot should still be rejected, got %d", rec.Code)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("fake bot should be held, took %v", elapsed)
+	}
+}
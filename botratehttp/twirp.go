@@ -0,0 +1,30 @@
+package botratehttp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/cnlangzi/botrate"
+)
+
+// TwirpDenied is a DeniedFunc for Twirp services: it writes a Twirp JSON
+// error, permission_denied for reasons that are Forbidden,
+// resource_exhausted otherwise, so Twirp clients get a typed error.
+//
+//	handler := botratehttp.Middleware(l,
+//		botratehttp.WithDeniedHandler(botratehttp.TwirpDenied),
+//	)(twirpServer)
+func TwirpDenied(w http.ResponseWriter, r *http.Request, reason botrate.Reason) {
+	code, status := "resource_exhausted", http.StatusTooManyRequests
+	if reason.Forbidden() {
+		code, status = "permission_denied", http.StatusForbidden
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+	}{code, string(reason)})
+}
@@ -0,0 +1,34 @@
+package botratehttp
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/cnlangzi/botrate"
+)
+
+func TestTwirpDenied(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithDenyCIDRs([]string{"10.0.0.0/8"}),
+	}, WithDeniedHandler(TwirpDenied))
+
+	rec := serve(h, "Mozilla/5.0", "10.0.0.1:1234")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("denylisted IP should get 403, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected a JSON error, got %q", ct)
+	}
+
+	var body struct {
+		Code string `json:"code"`
+		Msg  string `json:"msg"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid Twirp error: %v", err)
+	}
+	if body.Code != "permission_denied" || body.Msg != string(botrate.ReasonDenied) {
+		t.Errorf("unexpected Twirp error %+v", body)
+	}
+}
@@ -0,0 +1,57 @@
+package botratehttp
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/cnlangzi/botrate"
+)
+
+type connKey struct{}
+
+// ConnFrom returns the botrate.Conn of a WebSocket upgrade allowed by
+// Middleware, or nil if r is not one. Check each message read from the
+// socket with its Message, and close the socket when one is denied:
+//
+//	conn := botratehttp.ConnFrom(r)
+//	for {
+//		_, msg, err := ws.ReadMessage()
+//		if err != nil {
+//			return
+//		}
+//		if d := conn.Message(1); !d.Allowed {
+//			ws.WriteControl(websocket.CloseMessage,
+//				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, string(d.Reason)),
+//				time.Now().Add(time.Second))
+//			return
+//		}
+//		...
+//	}
+func ConnFrom(r *http.Request) *botrate.Conn {
+	c, _ := r.Context().Value(connKey{}).(*botrate.Conn)
+	return c
+}
+
+func withConn(r *http.Request, c *botrate.Conn) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), connKey{}, c))
+}
+
+// isWebSocket reports whether r asks to upgrade to a WebSocket.
+func isWebSocket(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerContains(r.Header, "Connection", "upgrade")
+}
+
+// headerContains reports whether the comma-separated header name contains
+// token, case-insensitively.
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package botratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestMiddleware_WebSocket(t *testing.T) {
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithMessageLimit(1, 1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	var conn *botrate.Conn
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn = ConnFrom(r)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.RemoteAddr = "192.168.1.1:1234"
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	if conn == nil {
+		t.Fatal("WebSocket upgrades should carry a Conn")
+	}
+	if d := conn.Message(1); !d.Allowed {
+		t.Errorf("first message should be allowed, got %+v", d)
+	}
+	if d := conn.Message(1); d.Allowed || d.Reason != botrate.ReasonMessageLimited {
+		t.Errorf("second message should be limited, got %+v", d)
+	}
+
+	conn = nil
+	serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	if conn != nil {
+		t.Error("plain requests should not carry a Conn")
+	}
+}
+
+func TestMiddleware_WebSocketDenied(t *testing.T) {
+	h := newHandler(t, []botrate.Option{
+		botrate.WithDenyCIDRs([]string{"10.0.0.0/8"}),
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.RemoteAddr = "10.0.0.1:1234"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("upgrades of denylisted IPs should get 403, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,89 @@
+package botratehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestStatusWriter(t *testing.T) {
+	testCases := []struct {
+		name  string
+		write func(w http.ResponseWriter)
+		want  int
+	}{
+		{"explicit", func(w http.ResponseWriter) { w.WriteHeader(http.StatusNotFound) }, http.StatusNotFound},
+		{"implicit", func(w http.ResponseWriter) { w.Write([]byte("ok")) }, http.StatusOK},
+		{"first wins", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.WriteHeader(http.StatusOK)
+		}, http.StatusUnauthorized},
+		{"flush", func(w http.ResponseWriter) { w.(http.Flusher).Flush() }, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+		tc.write(sw)
+		if sw.status != tc.want {
+			t.Errorf("%s: status = %d, want %d", tc.name, sw.status, tc.want)
+		}
+	}
+
+	sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := sw.Hijack(); err != http.ErrNotSupported {
+		t.Errorf("expected ErrNotSupported, got %v", err)
+	}
+}
+
+func TestMiddleware_RecordResponse(t *testing.T) {
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithAnalyzerWindow(time.Hour),
+		botrate.WithAnalyzerErrorThreshold(3),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	h := Middleware(l)(http.NotFoundHandler())
+
+	for i := 0; i < 3; i++ {
+		serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if blocked := l.Blocklist(); len(blocked) != 1 || blocked[0].IP != "192.168.1.1" {
+		t.Errorf("404 storm should block the IP, got %+v", blocked)
+	}
+}
+
+func TestMiddleware_RecordLogin(t *testing.T) {
+	l, err := botrate.New(
+		botrate.WithKnownbots(testbots.New(t)),
+		botrate.WithAnalyzerWindow(time.Hour),
+		botrate.WithLoginPaths("/"),
+		botrate.WithLoginThreshold(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	h := Middleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	for i := 0; i < 2; i++ {
+		serve(h, "Mozilla/5.0", "192.168.1.1:1234")
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if blocked := l.Blocklist(); len(blocked) != 1 || blocked[0].IP != "192.168.1.1" {
+		t.Errorf("failed logins should block the IP, got %+v", blocked)
+	}
+}
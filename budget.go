@@ -0,0 +1,173 @@
+package botrate
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultBudgetSaveInterval is how often consumed crawl budgets are saved
+// to the file of WithBotBudgetFile.
+var DefaultBudgetSaveInterval = time.Minute
+
+// BotBudget is the crawl budget of a verified bot, see WithBotBudget.
+type BotBudget struct {
+	Requests int
+	Period   time.Duration
+}
+
+// budgetUse is the budget consumed by a bot in a period.
+type budgetUse struct {
+	Start time.Time `json:"start"`
+	Used  int       `json:"used"`
+}
+
+// budgets counts the requests of verified bots against their budgets.
+type budgets struct {
+	limits map[string]BotBudget // by bot name, "" for other bots
+	path   string
+
+	mu    sync.Mutex
+	used  map[string]budgetUse // by bot name
+	dirty bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newBudgets loads the budgets consumed from the file at path, if any,
+// and saves them there every DefaultBudgetSaveInterval until close.
+func newBudgets(limits map[string]BotBudget, path string, logger *slog.Logger) (*budgets, error) {
+	for _, b := range limits {
+		if b.Period <= 0 {
+			return nil, errors.New("botrate: crawl budget periods must be positive")
+		}
+	}
+
+	b := &budgets{
+		limits: limits,
+		path:   path,
+		used:   make(map[string]budgetUse),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if path == "" {
+		return b, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		data = []byte("{}")
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &b.used); err != nil {
+		return nil, err
+	}
+	go b.run(DefaultBudgetSaveInterval, logger)
+	return b, nil
+}
+
+// run saves the consumed budgets every interval until close.
+func (b *budgets) run(interval time.Duration, logger *slog.Logger) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.save(); err != nil {
+				logger.Warn("botrate: failed to save crawl budgets", "error", err)
+			}
+		}
+	}
+}
+
+// close stops saving the consumed budgets, saving them a last time.
+func (b *budgets) close() error {
+	if b.path == "" {
+		return nil
+	}
+	close(b.stop)
+	<-b.done
+	return b.save()
+}
+
+// take consumes a request of bot's budget at now, reporting false with
+// the wait until the next period if it is exhausted. Bots without a
+// budget always get one.
+func (b *budgets) take(bot string, now time.Time) (time.Duration, bool) {
+	limit, ok := b.limits[bot]
+	if !ok {
+		if limit, ok = b.limits[""]; !ok {
+			return 0, true
+		}
+	}
+
+	// Periods are aligned, e.g. days start at midnight UTC
+	start := now.Truncate(limit.Period)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	u := b.used[bot]
+	if !u.Start.Equal(start) {
+		u = budgetUse{Start: start}
+	}
+	if u.Used >= limit.Requests {
+		return start.Add(limit.Period).Sub(now), false
+	}
+	u.Used++
+	b.used[bot] = u
+	b.dirty = true
+	return 0, true
+}
+
+// save writes the consumed budgets to the file, if changed since the last
+// save.
+func (b *budgets) save() error {
+	b.mu.Lock()
+	if b.path == "" || !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(b.used)
+	b.dirty = false
+	b.mu.Unlock()
+
+	if err == nil {
+		err = writeFile(b.path, data)
+	}
+	if err != nil {
+		// Try again on the next save
+		b.mu.Lock()
+		b.dirty = true
+		b.mu.Unlock()
+	}
+	return err
+}
+
+// writeFile replaces the file at path with data atomically.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,98 @@
+package botrate
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestBudgets_Take(t *testing.T) {
+	b, err := newBudgets(map[string]BotBudget{
+		"testbot": {Requests: 2, Period: time.Hour},
+		"":        {Requests: 1, Period: 24 * time.Hour},
+	}, "", nil)
+	if err != nil {
+		t.Fatalf("newBudgets() returned error: %v", err)
+	}
+
+	now := time.Date(2026, 1, 1, 10, 15, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if _, ok := b.take("testbot", now); !ok {
+			t.Fatalf("request %d within the budget should be taken", i)
+		}
+	}
+	if wait, ok := b.take("testbot", now); ok || wait != 45*time.Minute {
+		t.Errorf("take() past the budget = %v, %v, want 45m, false", wait, ok)
+	}
+	if _, ok := b.take("testbot", now.Add(45*time.Minute)); !ok {
+		t.Error("the budget should be renewed in the next period")
+	}
+
+	// Other bots get the default budget, each their own
+	for _, bot := range []string{"a", "b"} {
+		if _, ok := b.take(bot, now); !ok {
+			t.Errorf("%s should get the default budget", bot)
+		}
+	}
+	if wait, ok := b.take("a", now); ok || wait != 13*time.Hour+45*time.Minute {
+		t.Errorf("take() past the default budget = %v, %v, want until midnight", wait, ok)
+	}
+
+	if _, err := newBudgets(map[string]BotBudget{"testbot": {Requests: 1}}, "", nil); err == nil {
+		t.Error("expected an error for a budget without a period")
+	}
+}
+
+func TestLimiter_WithBotBudget(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Date(2026, 1, 1, 23, 0, 0, 0, time.UTC))
+	path := filepath.Join(t.TempDir(), "budgets.json")
+	opts := []Option{
+		WithKnownbots(testbots.New(t)),
+		WithClock(clock),
+		WithBotBudget("testbot", 2, 24*time.Hour),
+		WithBotBudgetFile(path),
+	}
+
+	l, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if allowed, _ := l.Allow("TestBot/1.0", "192.168.100.1", "/"); !allowed {
+			t.Fatalf("request %d within the budget should be allowed", i)
+		}
+	}
+	d := l.Decide("TestBot/1.0", "192.168.100.1", "/")
+	if d.Allowed || d.Reason != ReasonBotQuota || d.RetryAfter != time.Hour {
+		t.Errorf("expected ReasonBotQuota until midnight, got %+v", d)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var le *LimitError
+	if err, _ := l.Wait(ctx, "TestBot/1.0", "192.168.100.1", "/"); !errors.As(err, &le) || le.RetryAfter != time.Hour {
+		t.Errorf("Wait() should report the wait until midnight, got %v", err)
+	}
+	l.Close()
+
+	// The consumed budget survives restarts
+	l, err = New(opts...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+	if allowed, reason := l.Allow("TestBot/1.0", "192.168.100.1", "/"); allowed || reason != ReasonBotQuota {
+		t.Errorf("the consumed budget should be restored, got %v, %v", allowed, reason)
+	}
+	clock.Advance(time.Hour)
+	if allowed, _ := l.Allow("TestBot/1.0", "192.168.100.1", "/"); !allowed {
+		t.Error("the budget should be renewed the next day")
+	}
+	if s := l.Stats(); s.Denied[ReasonBotQuota] != 1 {
+		t.Errorf("expected 1 quota denial after the restart, got %d", s.Denied[ReasonBotQuota])
+	}
+}
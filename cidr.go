@@ -0,0 +1,100 @@
+package botrate
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// prefixSet is a copy-on-write set of IP prefixes. Reads are lock-free.
+type prefixSet struct {
+	mu       sync.Mutex
+	prefixes atomic.Pointer[[]netip.Prefix]
+}
+
+func newPrefixSet(cidrs []string) (*prefixSet, error) {
+	prefixes, err := parsePrefixes(cidrs)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &prefixSet{}
+	s.prefixes.Store(&prefixes)
+	return s, nil
+}
+
+// Set replaces the prefixes of the set, including those added.
+func (s *prefixSet) Set(prefixes []netip.Prefix) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prefixes.Store(&prefixes)
+}
+
+// Add parses cidr and adds it to the set.
+func (s *prefixSet) Add(cidr string) error {
+	p, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.prefixes.Load()
+	prefixes := make([]netip.Prefix, len(old), len(old)+1)
+	copy(prefixes, old)
+	prefixes = append(prefixes, p)
+
+	s.prefixes.Store(&prefixes)
+	return nil
+}
+
+// Contains reports whether addr is inside any prefix of the set.
+// addr must be canonical (see canonicalIP); the zero Addr never matches.
+func (s *prefixSet) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+
+	prefixes := *s.prefixes.Load()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses CIDRs and bare IPs, see parsePrefix.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		p, err := parsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+// parsePrefix parses a CIDR or a bare IP, which is treated as a single
+// host prefix.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return p.Masked(), nil
+}
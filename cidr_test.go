@@ -0,0 +1,96 @@
+package botrate
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestParsePrefix(t *testing.T) {
+	testCases := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{"192.168.1.1", "192.168.1.1/32", false},
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{"10.1.2.3/8", "10.0.0.0/8", false},
+		{" 2001:db8::/32 ", "2001:db8::/32", false},
+		{"2001:db8::1", "2001:db8::1/128", false},
+		{"::ffff:1.2.3.4", "1.2.3.4/32", false},
+		{"not-an-ip", "", true},
+		{"10.0.0.0/33", "", true},
+	}
+
+	for _, tc := range testCases {
+		p, err := parsePrefix(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("parsePrefix(%q) should fail", tc.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePrefix(%q) returned error: %v", tc.in, err)
+			continue
+		}
+		if p.String() != tc.want {
+			t.Errorf("parsePrefix(%q) = %s, want %s", tc.in, p, tc.want)
+		}
+	}
+}
+
+func TestPrefixSet_Contains(t *testing.T) {
+	s, err := newPrefixSet([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatalf("newPrefixSet() returned error: %v", err)
+	}
+
+	testCases := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.1", true},
+		{"192.168.1.2", false},
+		{"2001:db8::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"", false},
+		{"not-an-ip", false},
+	}
+
+	for _, tc := range testCases {
+		_, addr := canonicalIP(tc.ip)
+		if got := s.Contains(addr); got != tc.want {
+			t.Errorf("Contains(%q) = %v, want %v", tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestPrefixSet_Add(t *testing.T) {
+	s, err := newPrefixSet(nil)
+	if err != nil {
+		t.Fatalf("newPrefixSet() returned error: %v", err)
+	}
+
+	addr := netip.MustParseAddr("10.0.0.1")
+	if s.Contains(addr) {
+		t.Error("empty set should not contain anything")
+	}
+
+	if err := s.Add("10.0.0.0/24"); err != nil {
+		t.Fatalf("Add() returned error: %v", err)
+	}
+	if !s.Contains(addr) {
+		t.Error("added range should match")
+	}
+
+	if err := s.Add("bogus"); err == nil {
+		t.Error("Add() should reject invalid CIDRs")
+	}
+}
+
+func TestNewPrefixSet_Invalid(t *testing.T) {
+	if _, err := newPrefixSet([]string{"10.0.0.0/8", "bogus"}); err == nil {
+		t.Error("newPrefixSet() should reject invalid CIDRs")
+	}
+}
@@ -0,0 +1,504 @@
+// Package cloudranges matches IPs against the ranges cloud providers
+// publish, AWS, Google Cloud, Azure and Oracle Cloud by default, refreshed
+// periodically.
+//
+// Ranges can be checked directly with Contains and Lookup, scored as an
+// analyzer.Signal, or used by botrate's datacenter policies.
+//
+// See botrate.WithCloudRanges.
+package cloudranges
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultRefresh = 24 * time.Hour
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultProviders are the providers loaded.
+	DefaultProviders = []Provider{AWS, GCP, Azure, Oracle}
+)
+
+// maxFeedSize bounds the feeds read, the largest, Azure's, is a few MB.
+const maxFeedSize = 64 << 20
+
+// Provider is a cloud provider publishing its IP ranges.
+type Provider struct {
+	// Name identifies the provider, e.g. in Lookup.
+	Name string
+
+	// URL is the feed, or the page linking to it if Find is set.
+	URL string
+
+	// Find, if set, returns the URL of the feed from the page at URL,
+	// for feeds moving with each release.
+	Find func(page []byte) (string, error)
+
+	// Parse returns the ranges of the feed.
+	Parse func(feed []byte) ([]netip.Prefix, error)
+}
+
+// AWS is Amazon Web Services, all services.
+var AWS = Provider{
+	Name: "aws",
+	URL:  "https://ip-ranges.amazonaws.com/ip-ranges.json",
+	Parse: func(feed []byte) ([]netip.Prefix, error) {
+		var f struct {
+			Prefixes []struct {
+				Prefix string `json:"ip_prefix"`
+			} `json:"prefixes"`
+			IPv6Prefixes []struct {
+				Prefix string `json:"ipv6_prefix"`
+			} `json:"ipv6_prefixes"`
+		}
+		if err := json.Unmarshal(feed, &f); err != nil {
+			return nil, err
+		}
+		cidrs := make([]string, 0, len(f.Prefixes)+len(f.IPv6Prefixes))
+		for _, p := range f.Prefixes {
+			cidrs = append(cidrs, p.Prefix)
+		}
+		for _, p := range f.IPv6Prefixes {
+			cidrs = append(cidrs, p.Prefix)
+		}
+		return parsePrefixes(cidrs)
+	},
+}
+
+// GCP is Google Cloud, the ranges of customer resources only.
+var GCP = Provider{
+	Name: "gcp",
+	URL:  "https://www.gstatic.com/ipranges/cloud.json",
+	Parse: func(feed []byte) ([]netip.Prefix, error) {
+		var f struct {
+			Prefixes []struct {
+				IPv4 string `json:"ipv4Prefix"`
+				IPv6 string `json:"ipv6Prefix"`
+			} `json:"prefixes"`
+		}
+		if err := json.Unmarshal(feed, &f); err != nil {
+			return nil, err
+		}
+		cidrs := make([]string, 0, len(f.Prefixes))
+		for _, p := range f.Prefixes {
+			cidrs = append(cidrs, p.IPv4+p.IPv6)
+		}
+		return parsePrefixes(cidrs)
+	},
+}
+
+// azureFeed matches the link to the Azure service tags feed, renamed
+// weekly.
+var azureFeed = regexp.MustCompile(`https://download\.microsoft\.com/download/[^"']+/ServiceTags_Public_\d+\.json`)
+
+// Azure is Microsoft Azure, its AzureCloud service tag. The feed is found
+// on its download page.
+var Azure = Provider{
+	Name: "azure",
+	URL:  "https://www.microsoft.com/en-us/download/details.aspx?id=56519",
+	Find: func(page []byte) (string, error) {
+		u := azureFeed.Find(page)
+		if u == nil {
+			return "", errors.New("no service tags link")
+		}
+		return string(u), nil
+	},
+	Parse: func(feed []byte) ([]netip.Prefix, error) {
+		var f struct {
+			Values []struct {
+				Name       string `json:"name"`
+				Properties struct {
+					AddressPrefixes []string `json:"addressPrefixes"`
+				} `json:"properties"`
+			} `json:"values"`
+		}
+		if err := json.Unmarshal(feed, &f); err != nil {
+			return nil, err
+		}
+		for _, v := range f.Values {
+			if v.Name == "AzureCloud" {
+				return parsePrefixes(v.Properties.AddressPrefixes)
+			}
+		}
+		return nil, errors.New("no AzureCloud service tag")
+	},
+}
+
+// Oracle is Oracle Cloud Infrastructure.
+var Oracle = Provider{
+	Name: "oracle",
+	URL:  "https://docs.oracle.com/en-us/iaas/tools/public_ip_ranges.json",
+	Parse: func(feed []byte) ([]netip.Prefix, error) {
+		var f struct {
+			Regions []struct {
+				CIDRs []struct {
+					CIDR string `json:"cidr"`
+				} `json:"cidrs"`
+			} `json:"regions"`
+		}
+		if err := json.Unmarshal(feed, &f); err != nil {
+			return nil, err
+		}
+		var cidrs []string
+		for _, r := range f.Regions {
+			for _, c := range r.CIDRs {
+				cidrs = append(cidrs, c.CIDR)
+			}
+		}
+		return parsePrefixes(cidrs)
+	},
+}
+
+// Option is a functional option for configuring Ranges.
+type Option func(*Ranges)
+
+// WithProviders sets the providers loaded, DefaultProviders by default.
+func WithProviders(providers ...Provider) Option {
+	return func(r *Ranges) {
+		r.providers = providers
+	}
+}
+
+// WithRefresh sets how often the feeds are fetched again. Providers
+// publish changes a few times a week.
+func WithRefresh(d time.Duration) Option {
+	return func(r *Ranges) {
+		r.refresh = d
+	}
+}
+
+// WithClient sets the HTTP client fetching the feeds.
+func WithClient(hc *http.Client) Option {
+	return func(r *Ranges) {
+		r.client = hc
+	}
+}
+
+// WithTimeout sets the timeout for fetching a feed.
+func WithTimeout(d time.Duration) Option {
+	return func(r *Ranges) {
+		r.timeout = d
+	}
+}
+
+// WithScore sets the score of IPs in the ranges as an analyzer.Signal, 0
+// by default. A score of 1 blocks them on their first analyzed request.
+func WithScore(score float64) Option {
+	return func(r *Ranges) {
+		r.score = score
+	}
+}
+
+// WithLogger sets the logger for failed fetches.
+func WithLogger(logger *slog.Logger) Option {
+	return func(r *Ranges) {
+		r.logger = logger
+	}
+}
+
+// span is a range of addresses of a provider.
+type span struct {
+	first, last netip.Addr
+	provider    string
+}
+
+// Ranges matches IPs against the ranges of cloud providers. It
+// implements analyzer.Signal.
+type Ranges struct {
+	providers []Provider
+	refresh   time.Duration
+	client    *http.Client
+	timeout   time.Duration
+	score     float64
+	logger    *slog.Logger
+
+	// spans are sorted and don't overlap. Reads are lock-free.
+	spans atomic.Pointer[[]span]
+
+	mu       sync.Mutex
+	prefixes map[string][]netip.Prefix // by provider
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns ranges, empty until the feeds are fetched in the
+// background. Call Refresh to wait for them.
+func New(opts ...Option) *Ranges {
+	r := &Ranges{
+		providers: DefaultProviders,
+		refresh:   DefaultRefresh,
+		client:    http.DefaultClient,
+		timeout:   DefaultTimeout,
+		prefixes:  make(map[string][]netip.Prefix),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.logger == nil {
+		r.logger = logging.Discard()
+	}
+
+	r.spans.Store(&[]span{})
+	go r.run()
+	return r
+}
+
+func (r *Ranges) run() {
+	defer close(r.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	r.Refresh(ctx)
+	if r.refresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.Refresh(ctx)
+		}
+	}
+}
+
+// Refresh fetches the feeds of all providers concurrently. Providers
+// whose feed fails keep their previous ranges.
+func (r *Ranges) Refresh(ctx context.Context) error {
+	errs := make([]error, len(r.providers))
+	var wg sync.WaitGroup
+	for i, p := range r.providers {
+		wg.Add(1)
+		go func(i int, p Provider) {
+			defer wg.Done()
+
+			prefixes, err := r.fetch(ctx, p)
+			if err != nil {
+				r.logger.Warn("botrate: failed to fetch cloud ranges", "provider", p.Name, "error", err)
+				errs[i] = fmt.Errorf("cloudranges: %s: %w", p.Name, err)
+				return
+			}
+			r.Load(p.Name, prefixes)
+		}(i, p)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Load sets the ranges of a provider, e.g. from a copy of its feed.
+func (r *Ranges) Load(provider string, prefixes []netip.Prefix) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.prefixes[provider] = prefixes
+
+	var spans []span
+	for name, prefixes := range r.prefixes {
+		for _, p := range prefixes {
+			spans = append(spans, span{first: p.Addr(), last: last(p), provider: name})
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		if c := spans[i].first.Compare(spans[j].first); c != 0 {
+			return c < 0
+		}
+		return spans[i].provider < spans[j].provider
+	})
+
+	// Merge overlapping spans, the first one keeps its provider
+	merged := spans[:0]
+	for _, s := range spans {
+		if n := len(merged); n > 0 && s.first.Compare(merged[n-1].last) <= 0 {
+			if s.last.Compare(merged[n-1].last) > 0 {
+				merged[n-1].last = s.last
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	r.spans.Store(&merged)
+}
+
+// Lookup returns the provider whose ranges contain addr, "" if none.
+func (r *Ranges) Lookup(addr netip.Addr) string {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return ""
+	}
+
+	spans := *r.spans.Load()
+	i := sort.Search(len(spans), func(i int) bool {
+		return spans[i].first.Compare(addr) > 0
+	})
+	if i > 0 && spans[i-1].last.Compare(addr) >= 0 {
+		return spans[i-1].provider
+	}
+	return ""
+}
+
+// Contains reports whether the ranges of any provider contain addr.
+func (r *Ranges) Contains(addr netip.Addr) bool {
+	return r.Lookup(addr) != ""
+}
+
+// Len returns the number of ranges, after merging overlapping ones.
+func (r *Ranges) Len() int {
+	return len(*r.spans.Load())
+}
+
+func (r *Ranges) Name() string {
+	return "cloudranges"
+}
+
+// Observe returns the score of WithScore if o.IP is in the ranges.
+func (r *Ranges) Observe(o analyzer.Observation) float64 {
+	if r.score == 0 {
+		return 0
+	}
+	addr, err := netip.ParseAddr(o.IP)
+	if err != nil || !r.Contains(addr) {
+		return 0
+	}
+	return r.score
+}
+
+// Forget does nothing, the ranges are not per IP.
+func (r *Ranges) Forget(ip string) {}
+
+// Reset does nothing, the ranges are not per window.
+func (r *Ranges) Reset() {}
+
+// Close stops refreshing the ranges. The ranges loaded are kept.
+func (r *Ranges) Close() error {
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	<-r.done
+	return nil
+}
+
+func (r *Ranges) fetch(ctx context.Context, p Provider) ([]netip.Prefix, error) {
+	u := p.URL
+	if p.Find != nil {
+		page, err := r.get(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+		if u, err = p.Find(page); err != nil {
+			return nil, err
+		}
+	}
+
+	feed, err := r.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	prefixes, err := p.Parse(feed)
+	if err != nil {
+		return nil, err
+	}
+	if len(prefixes) == 0 {
+		// Rather keep the previous ranges than trust an empty feed
+		return nil, errors.New("no ranges")
+	}
+	return prefixes, nil
+}
+
+func (r *Ranges) get(ctx context.Context, u string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%s: %s", u, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxFeedSize))
+}
+
+// parsePrefixes parses CIDRs, skipping empty ones.
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, c := range cidrs {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(c)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// last returns the last address of p.
+func last(p netip.Prefix) netip.Addr {
+	p = p.Masked()
+	if p.Addr().Is4() {
+		a := p.Addr().As4()
+		n := binary.BigEndian.Uint32(a[:]) | uint32(1<<(32-p.Bits())-1)
+		binary.BigEndian.PutUint32(a[:], n)
+		return netip.AddrFrom4(a)
+	}
+
+	a := p.Addr().As16()
+	hi := binary.BigEndian.Uint64(a[:8])
+	lo := binary.BigEndian.Uint64(a[8:])
+	switch bits := p.Bits(); {
+	case bits <= 64:
+		hi |= 1<<(64-bits) - 1
+		lo = ^uint64(0)
+	default:
+		lo |= 1<<(128-bits) - 1
+	}
+	binary.BigEndian.PutUint64(a[:8], hi)
+	binary.BigEndian.PutUint64(a[8:], lo)
+	return netip.AddrFrom16(a)
+}
@@ -0,0 +1,174 @@
+package cloudranges
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+var _ analyzer.Signal = (*Ranges)(nil)
+
+// feeds serves the feeds of the default providers, in their formats.
+func feeds(t *testing.T) []Provider {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/aws":
+			w.Write([]byte(`{"prefixes":[{"ip_prefix":"198.51.100.0/24","service":"EC2"}],"ipv6_prefixes":[{"ipv6_prefix":"2001:db8:a::/48"}]}`))
+		case "/gcp":
+			w.Write([]byte(`{"prefixes":[{"ipv4Prefix":"203.0.113.0/25"},{"ipv6Prefix":"2001:db8:b::/48"}]}`))
+		case "/azure":
+			w.Write([]byte(`<a href="https://download.microsoft.com/download/7/1/d/ServiceTags_Public_20260105.json">Download</a>`))
+		case "/azure.json":
+			w.Write([]byte(`{"values":[{"name":"AzureCloud","properties":{"addressPrefixes":["203.0.113.128/26","2001:db8:c::/48"]}}]}`))
+		case "/oracle":
+			w.Write([]byte(`{"regions":[{"region":"us-phoenix-1","cidrs":[{"cidr":"192.0.2.0/28"}]}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	azure := Azure
+	azure.URL = srv.URL + "/azure"
+	azure.Find = func(page []byte) (string, error) {
+		if _, err := Azure.Find(page); err != nil {
+			return "", err
+		}
+		return srv.URL + "/azure.json", nil
+	}
+	aws, gcp, oracle := AWS, GCP, Oracle
+	aws.URL = srv.URL + "/aws"
+	gcp.URL = srv.URL + "/gcp"
+	oracle.URL = srv.URL + "/oracle"
+	return []Provider{aws, gcp, azure, oracle}
+}
+
+func TestRanges_Refresh(t *testing.T) {
+	providers := feeds(t)
+	r := New(WithProviders(providers...), WithRefresh(0))
+	defer r.Close()
+
+	if err := r.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"198.51.100.7", "aws"},
+		{"::ffff:198.51.100.7", "aws"},
+		{"2001:db8:a::1", "aws"},
+		{"203.0.113.127", "gcp"},
+		{"203.0.113.128", "azure"},
+		{"203.0.113.191", "azure"},
+		{"203.0.113.192", ""},
+		{"2001:db8:c:ffff::1", "azure"},
+		{"192.0.2.15", "oracle"},
+		{"192.0.2.16", ""},
+		{"10.0.0.1", ""},
+	}
+	for _, tt := range tests {
+		if got := r.Lookup(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("Lookup(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+	if r.Contains(netip.Addr{}) {
+		t.Error("an invalid address should not be contained")
+	}
+}
+
+func TestRanges_RefreshError(t *testing.T) {
+	var fail atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"prefixes":[{"ip_prefix":"198.51.100.0/24"}]}`))
+	}))
+	defer srv.Close()
+
+	aws := AWS
+	aws.URL = srv.URL
+	r := New(WithProviders(aws), WithRefresh(0))
+	defer r.Close()
+
+	if err := r.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+	fail.Store(true)
+	err := r.Refresh(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "aws") {
+		t.Errorf("expected an error naming the provider, got %v", err)
+	}
+	if !r.Contains(netip.MustParseAddr("198.51.100.1")) {
+		t.Error("failed providers should keep their previous ranges")
+	}
+}
+
+func TestRanges_Background(t *testing.T) {
+	providers := feeds(t)
+	r := New(WithProviders(providers...), WithRefresh(time.Hour))
+	defer r.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for r.Len() < 7 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ranges not loaded in the background, got %d", r.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRanges_Load(t *testing.T) {
+	r := New(WithProviders(), WithScore(0.5))
+	defer r.Close()
+
+	r.Load("a", []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("10.1.0.0/16")})
+	r.Load("b", []netip.Prefix{netip.MustParsePrefix("10.255.0.0/16"), netip.MustParsePrefix("11.0.0.0/8")})
+	if r.Len() != 2 {
+		t.Errorf("overlapping ranges should be merged, got %d", r.Len())
+	}
+	if got := r.Lookup(netip.MustParseAddr("10.255.0.1")); got != "a" {
+		t.Errorf("Lookup() = %q, want the first provider of overlapping ranges", got)
+	}
+	if got := r.Lookup(netip.MustParseAddr("11.0.0.1")); got != "b" {
+		t.Errorf("Lookup() = %q, want b", got)
+	}
+
+	if s := r.Observe(analyzer.Observation{IP: "11.0.0.1"}); s != 0.5 {
+		t.Errorf("Observe() = %v, want 0.5", s)
+	}
+	if s := r.Observe(analyzer.Observation{IP: "12.0.0.1"}); s != 0 {
+		t.Errorf("Observe() of other IPs = %v, want 0", s)
+	}
+}
+
+func TestLast(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"198.51.100.0/24", "198.51.100.255"},
+		{"192.0.2.1/32", "192.0.2.1"},
+		{"0.0.0.0/0", "255.255.255.255"},
+		{"2001:db8::/32", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		{"2001:db8::/96", "2001:db8::ffff:ffff"},
+		{"::/0", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+	}
+	for _, tt := range tests {
+		if got := last(netip.MustParsePrefix(tt.prefix)); got.String() != tt.want {
+			t.Errorf("last(%s) = %s, want %s", tt.prefix, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,582 @@
+// Package cluster provides a blocklist store that keeps botrate instances
+// in sync without Redis: each node pushes its blocks and unblocks to its
+// peers over HTTP, so a bot blocked on one node is quickly blocked on all
+// of them.
+//
+// The nodes form a full mesh, not a gossip network: every node serves its
+// Node as an http.Handler on an internal address and lists every other
+// node as a peer. Changes are pushed in batches straight to every peer,
+// with retries, and never relayed, so each change costs a push per peer;
+// it suits a handful of instances. A node joining late pulls the
+// blocklists of its peers with Join.
+//
+// With WithSecret, messages and blocklist pulls are signed along with a
+// timestamp and a nonce, and those outside the replay window, or seen
+// before, are rejected. Expiries and timestamps are absolute times, so node clocks
+// should be synchronized.
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultTimeout   = time.Second
+	DefaultRetries   = 3
+	DefaultBatchSize = 100
+	DefaultQueueCap  = 10000
+
+	// DefaultReplayWindow is how far the timestamp of a signed message
+	// may be from the receiver's clock, see WithReplayWindow.
+	DefaultReplayWindow = time.Minute
+)
+
+// Headers of signed messages, with WithSecret. SignatureHeader carries
+// the hex HMAC-SHA256 of the timestamp, the nonce and the body.
+const (
+	SignatureHeader = "X-Botrate-Signature"
+	TimestampHeader = "X-Botrate-Timestamp" // Unix milliseconds
+	NonceHeader     = "X-Botrate-Nonce"
+)
+
+// maxBody bounds the request bodies a node accepts.
+const maxBody = 4 << 20
+
+// Option is a functional option for configuring Node.
+type Option func(*Node)
+
+// WithSecret signs the messages pushed to peers and rejects unsigned
+// ones, so only nodes sharing secret can block IPs. Recommended unless
+// the network is trusted.
+func WithSecret(secret []byte) Option {
+	return func(n *Node) {
+		n.secret = secret
+	}
+}
+
+// WithReplayWindow sets how far the timestamp of a signed message may be
+// from the receiver's clock, DefaultReplayWindow by default. Nonces are
+// remembered for twice as long, so a message can't be replayed within
+// it.
+func WithReplayWindow(d time.Duration) Option {
+	return func(n *Node) {
+		n.window = d
+	}
+}
+
+// WithClient sets the HTTP client pushing to peers.
+func WithClient(c *http.Client) Option {
+	return func(n *Node) {
+		n.client = c
+	}
+}
+
+// WithTimeout sets the timeout for a push to a peer.
+func WithTimeout(d time.Duration) Option {
+	return func(n *Node) {
+		n.timeout = d
+	}
+}
+
+// WithRetries sets how many times a failed push to a peer is retried,
+// with exponential backoff, before it is dropped.
+func WithRetries(retries int) Option {
+	return func(n *Node) {
+		n.retries = retries
+	}
+}
+
+// WithQueueCap sets how many changes can wait to be pushed. Changes are
+// dropped when the queue is full.
+func WithQueueCap(cap int) Option {
+	return func(n *Node) {
+		n.queueCap = cap
+	}
+}
+
+// WithLogger sets the logger for failed pushes.
+func WithLogger(logger *slog.Logger) Option {
+	return func(n *Node) {
+		n.logger = logger
+	}
+}
+
+// message is a block, or an unblock, pushed to peers.
+type message struct {
+	IP      string `json:"ip"`
+	Until   int64  `json:"until,omitempty"` // Unix milliseconds, 0 never expires
+	Unblock bool   `json:"unblock,omitempty"`
+}
+
+// Node is a blocklist shared with peers. It implements analyzer.Store
+// and http.Handler.
+type Node struct {
+	peers    []string
+	secret   []byte
+	client   *http.Client
+	timeout  time.Duration
+	retries  int
+	queueCap int
+	window   time.Duration
+	logger   *slog.Logger
+
+	// Nonces of the signed messages received in the replay window
+	nonces    map[string]time.Time
+	noncesMu  sync.Mutex
+	sweepSize int
+
+	// Local mirror of the cluster blocklist: IP -> expiry
+	mirror map[string]time.Time
+	mu     sync.RWMutex
+
+	queue   chan message
+	dropped atomic.Uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a node pushing its changes to peers, the base URLs of the
+// other nodes' handlers.
+func New(peers []string, opts ...Option) *Node {
+	n := &Node{
+		peers:    peers,
+		client:   http.DefaultClient,
+		timeout:  DefaultTimeout,
+		retries:  DefaultRetries,
+		queueCap: DefaultQueueCap,
+		window:   DefaultReplayWindow,
+		nonces:   make(map[string]time.Time),
+		mirror:   make(map[string]time.Time),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.logger == nil {
+		n.logger = logging.Discard()
+	}
+
+	n.queue = make(chan message, n.queueCap)
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	go n.run(ctx)
+	return n
+}
+
+// Blocked reports whether ip is blocked, using the local mirror. Blocks
+// that expired are not, even before Expire removes them.
+func (n *Node) Blocked(ip string) bool {
+	n.mu.RLock()
+	until, exists := n.mirror[ip]
+	n.mu.RUnlock()
+	return exists && active(until, time.Now())
+}
+
+// Len returns the number of blocked IPs, those expired since the last
+// Expire included.
+func (n *Node) Len() int {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return len(n.mirror)
+}
+
+// Range calls fn for each blocked IP until fn returns false.
+func (n *Node) Range(fn func(ip string, until time.Time) bool) {
+	for _, msg := range n.messages() {
+		if !fn(msg.IP, parseUntil(msg.Until)) {
+			return
+		}
+	}
+}
+
+// Block adds ip to the blocklist for ttl and pushes it to the peers. A
+// ttl <= 0 never expires.
+func (n *Node) Block(ip string, ttl time.Duration) error {
+	var until time.Time
+	if ttl > 0 {
+		until = time.Now().Add(ttl)
+	}
+	n.set(ip, until)
+	n.push(message{IP: ip, Until: formatUntil(until)})
+	return nil
+}
+
+// Unblock removes ip from the blocklist and pushes the unblock to the
+// peers.
+func (n *Node) Unblock(ip string) error {
+	n.delete(ip)
+	n.push(message{IP: ip, Unblock: true})
+	return nil
+}
+
+// Expire removes entries that expired before now. Every node sweeps its
+// own mirror, so expiries are not pushed.
+func (n *Node) Expire(now time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for ip, until := range n.mirror {
+		if !active(until, now) {
+			delete(n.mirror, ip)
+		}
+	}
+	return nil
+}
+
+// Dropped returns the number of changes not pushed to a peer, because
+// the queue was full or the peer failed every retry.
+func (n *Node) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// Join pulls the blocklists of the peers into the local mirror, e.g.
+// when the node starts. It returns an error only if no peer answered.
+func (n *Node) Join(ctx context.Context) error {
+	var errs []error
+	for _, peer := range n.peers {
+		msgs, err := n.pull(ctx, peer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		n.apply(msgs)
+	}
+	if len(n.peers) > 0 && len(errs) == len(n.peers) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// ServeHTTP receives the changes pushed by peers on POST, and serves the
+// blocklist to joining peers on GET. With WithSecret, both must be
+// signed.
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if !n.verify(r.Header, nil) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		body, err := json.Marshal(n.messages())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		n.signHeader(w.Header(), body)
+		w.Write(body)
+	case http.MethodPost:
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !n.verify(r.Header, body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		var msgs []message
+		if err := json.Unmarshal(body, &msgs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n.apply(msgs)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// Close stops pushing to peers. Changes still queued are dropped.
+func (n *Node) Close() error {
+	n.cancel()
+	<-n.done
+	return nil
+}
+
+func (n *Node) push(msg message) {
+	if len(n.peers) == 0 {
+		return
+	}
+	select {
+	case n.queue <- msg:
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+// run pushes the queued changes to the peers in batches.
+func (n *Node) run(ctx context.Context) {
+	defer close(n.done)
+
+	for {
+		var batch []message
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-n.queue:
+			batch = append(batch, msg)
+		}
+		// Take whatever else is waiting, up to a batch
+	fill:
+		for len(batch) < DefaultBatchSize {
+			select {
+			case msg := <-n.queue:
+				batch = append(batch, msg)
+			default:
+				break fill
+			}
+		}
+
+		body, err := json.Marshal(batch)
+		if err != nil {
+			continue
+		}
+		var wg sync.WaitGroup
+		for _, peer := range n.peers {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				n.send(ctx, peer, body, len(batch))
+			}(peer)
+		}
+		wg.Wait()
+	}
+}
+
+// send posts body to peer, retrying with exponential backoff.
+func (n *Node) send(ctx context.Context, peer string, body []byte, count int) {
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return
+			}
+			backoff *= 2
+		}
+		if err = n.post(ctx, peer, body); err == nil {
+			return
+		}
+	}
+	n.dropped.Add(uint64(count))
+	n.logger.Warn("botrate: failed to push blocklist changes", "peer", peer, "changes", count, "error", err)
+}
+
+func (n *Node) post(ctx context.Context, peer string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	n.signHeader(req.Header, body)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("cluster: peer responded %s", resp.Status)
+	}
+	return nil
+}
+
+func (n *Node) pull(ctx context.Context, peer string) ([]message, error) {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer, nil)
+	if err != nil {
+		return nil, err
+	}
+	n.signHeader(req.Header, nil)
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cluster: peer responded %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, err
+	}
+	if !n.verify(resp.Header, body) {
+		return nil, errors.New("cluster: invalid signature")
+	}
+	var msgs []message
+	if err := json.Unmarshal(body, &msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// messages returns the blocklist as blocks to push, without those that
+// expired.
+func (n *Node) messages() []message {
+	now := time.Now()
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	msgs := make([]message, 0, len(n.mirror))
+	for ip, until := range n.mirror {
+		if active(until, now) {
+			msgs = append(msgs, message{IP: ip, Until: formatUntil(until)})
+		}
+	}
+	return msgs
+}
+
+// apply applies changes received from a peer, without pushing them on.
+func (n *Node) apply(msgs []message) {
+	now := time.Now()
+	for _, msg := range msgs {
+		if msg.Unblock {
+			n.delete(msg.IP)
+			continue
+		}
+		until := parseUntil(msg.Until)
+		if !active(until, now) {
+			continue
+		}
+		n.set(msg.IP, until)
+	}
+}
+
+// signHeader signs body with a fresh timestamp and nonce in h, with
+// WithSecret.
+func (n *Node) signHeader(h http.Header, body []byte) {
+	if n.secret == nil {
+		return
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	nonce := hex.EncodeToString(b)
+
+	h.Set(TimestampHeader, ts)
+	h.Set(NonceHeader, nonce)
+	h.Set(SignatureHeader, hex.EncodeToString(n.sign(ts, nonce, body)))
+}
+
+// verify reports whether h signs body with a timestamp in the replay
+// window and a nonce not seen before, with WithSecret.
+func (n *Node) verify(h http.Header, body []byte) bool {
+	if n.secret == nil {
+		return true
+	}
+	sig, err := hex.DecodeString(h.Get(SignatureHeader))
+	if err != nil {
+		return false
+	}
+	ts, nonce := h.Get(TimestampHeader), h.Get(NonceHeader)
+	if nonce == "" || !hmac.Equal(sig, n.sign(ts, nonce, body)) {
+		return false
+	}
+
+	ms, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	if skew := now.Sub(time.UnixMilli(ms)); skew > n.window || skew < -n.window {
+		return false
+	}
+	return n.fresh(nonce, now)
+}
+
+func (n *Node) sign(ts, nonce string, body []byte) []byte {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'\n'})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// fresh records nonce and reports whether it was not seen in the replay
+// window. Nonces are swept once their number doubles, so they stay
+// bounded by what the window receives.
+func (n *Node) fresh(nonce string, now time.Time) bool {
+	n.noncesMu.Lock()
+	defer n.noncesMu.Unlock()
+
+	if _, seen := n.nonces[nonce]; seen {
+		return false
+	}
+	if len(n.nonces) >= n.sweepSize {
+		for k, t := range n.nonces {
+			if now.Sub(t) > 2*n.window {
+				delete(n.nonces, k)
+			}
+		}
+		n.sweepSize = max(2*len(n.nonces), 1024)
+	}
+	n.nonces[nonce] = now
+	return true
+}
+
+func (n *Node) set(ip string, until time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.mirror[ip] = until
+}
+
+func (n *Node) delete(ip string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.mirror, ip)
+}
+
+// active reports whether a block until until is in force at now.
+func active(until, now time.Time) bool {
+	return until.IsZero() || now.Before(until)
+}
+
+func formatUntil(until time.Time) int64 {
+	if until.IsZero() {
+		return 0
+	}
+	return until.UnixMilli()
+}
+
+func parseUntil(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -0,0 +1,207 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+var _ analyzer.Store = (*Node)(nil)
+
+// newCluster starts size nodes peering with each other.
+func newCluster(t *testing.T, size int, opts ...Option) []*Node {
+	t.Helper()
+
+	handlers := make([]http.Handler, size)
+	urls := make([]string, size)
+	for i := range urls {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		urls[i] = srv.URL
+	}
+
+	nodes := make([]*Node, size)
+	for i := range nodes {
+		var peers []string
+		for j, u := range urls {
+			if j != i {
+				peers = append(peers, u)
+			}
+		}
+		nodes[i] = New(peers, opts...)
+		handlers[i] = nodes[i]
+		t.Cleanup(func() { nodes[i].Close() })
+	}
+	return nodes
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNode_Block(t *testing.T) {
+	nodes := newCluster(t, 3)
+
+	if err := nodes[0].Block("192.168.1.1", time.Hour); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if !nodes[0].Blocked("192.168.1.1") {
+		t.Error("IP should be blocked locally right away")
+	}
+	for _, n := range nodes[1:] {
+		waitFor(t, func() bool { return n.Blocked("192.168.1.1") }, "block should reach every peer")
+	}
+
+	// Peers keep the expiry
+	var until time.Time
+	nodes[2].Range(func(ip string, u time.Time) bool {
+		until = u
+		return false
+	})
+	if d := time.Until(until); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("expiry should be shared, got %v left", d)
+	}
+
+	nodes[1].Unblock("192.168.1.1")
+	for _, n := range []*Node{nodes[0], nodes[2]} {
+		waitFor(t, func() bool { return !n.Blocked("192.168.1.1") }, "unblock should reach every peer")
+	}
+}
+
+func TestNode_Secret(t *testing.T) {
+	nodes := newCluster(t, 2, WithSecret([]byte("secret")))
+
+	nodes[0].Block("192.168.1.1", 0)
+	waitFor(t, func() bool { return nodes[1].Blocked("192.168.1.1") }, "signed block should be accepted")
+
+	// An unsigned push is rejected
+	rec := httptest.NewRecorder()
+	nodes[1].ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`[{"ip":"10.0.0.1"}]`)))
+	if rec.Code != http.StatusUnauthorized || nodes[1].Blocked("10.0.0.1") {
+		t.Errorf("unsigned push should be rejected, got %d", rec.Code)
+	}
+
+	// So is an unsigned pull of the blocklist
+	rec = httptest.NewRecorder()
+	nodes[1].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized || strings.Contains(rec.Body.String(), "192.168.1.1") {
+		t.Errorf("unsigned pull should be rejected, got %d", rec.Code)
+	}
+}
+
+func TestNode_Replay(t *testing.T) {
+	n := New(nil, WithSecret([]byte("secret")), WithReplayWindow(time.Minute))
+	defer n.Close()
+
+	push := func(h http.Header, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		r.Header = h
+		rec := httptest.NewRecorder()
+		n.ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	body := `[{"ip":"10.0.0.1"}]`
+	h := http.Header{}
+	n.signHeader(h, []byte(body))
+	if code := push(h.Clone(), body); code != http.StatusNoContent {
+		t.Fatalf("signed push should be accepted, got %d", code)
+	}
+
+	// A captured push can't be replayed, e.g. after an unblock
+	n.Unblock("10.0.0.1")
+	if code := push(h.Clone(), body); code != http.StatusUnauthorized || n.Blocked("10.0.0.1") {
+		t.Errorf("replayed push should be rejected, got %d", code)
+	}
+
+	// Nor one signed outside the replay window
+	stale := http.Header{}
+	ts := strconv.FormatInt(time.Now().Add(-2*time.Minute).UnixMilli(), 10)
+	stale.Set(TimestampHeader, ts)
+	stale.Set(NonceHeader, "0123")
+	stale.Set(SignatureHeader, hex.EncodeToString(n.sign(ts, "0123", []byte(body))))
+	if code := push(stale, body); code != http.StatusUnauthorized || n.Blocked("10.0.0.1") {
+		t.Errorf("stale push should be rejected, got %d", code)
+	}
+}
+
+func TestNode_Join(t *testing.T) {
+	nodes := newCluster(t, 2, WithSecret([]byte("secret")))
+
+	// Blocked before the node joined, so not pushed to it
+	nodes[0].set("192.168.1.1", time.Time{})
+	nodes[0].set("192.168.1.2", time.Now().Add(time.Hour))
+
+	if err := nodes[1].Join(context.Background()); err != nil {
+		t.Fatalf("Join() returned error: %v", err)
+	}
+	if nodes[1].Len() != 2 {
+		t.Errorf("expected 2 blocks after joining, got %d", nodes[1].Len())
+	}
+}
+
+func TestNode_Join_Unreachable(t *testing.T) {
+	n := New([]string{"http://127.0.0.1:1"}, WithTimeout(100*time.Millisecond))
+	defer n.Close()
+
+	if err := n.Join(context.Background()); err == nil {
+		t.Error("Join() should fail when no peer answers")
+	}
+}
+
+func TestNode_Dropped(t *testing.T) {
+	n := New([]string{"http://127.0.0.1:1"}, WithRetries(0), WithTimeout(100*time.Millisecond))
+	defer n.Close()
+
+	n.Block("192.168.1.1", 0)
+	waitFor(t, func() bool { return n.Dropped() == 1 }, "failed push should be counted as dropped")
+	if !n.Blocked("192.168.1.1") {
+		t.Error("failed push should not undo the local block")
+	}
+}
+
+func TestNode_Expire(t *testing.T) {
+	n := New(nil)
+	defer n.Close()
+
+	n.Block("192.168.1.1", time.Minute)
+	n.Block("192.168.1.2", 0)
+	n.Expire(time.Now().Add(2 * time.Minute))
+
+	if n.Blocked("192.168.1.1") || !n.Blocked("192.168.1.2") {
+		t.Error("only the expired block should be removed")
+	}
+}
+
+func TestNode_Blocked_Expired(t *testing.T) {
+	n := New(nil)
+	defer n.Close()
+
+	n.set("192.168.1.1", time.Now().Add(-time.Second))
+	if n.Blocked("192.168.1.1") {
+		t.Error("expired block should not be reported before Expire")
+	}
+	n.Range(func(ip string, _ time.Time) bool {
+		t.Errorf("Range() should skip expired block of %s", ip)
+		return true
+	})
+}
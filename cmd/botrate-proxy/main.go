@@ -0,0 +1,244 @@
+// Command botrate-proxy is a reverse proxy applying botrate to all
+// traffic to an upstream, for sites whose code can't be changed.
+//
+//	botrate-proxy -listen :8080 -upstream http://127.0.0.1:3000
+//
+// Blocked clients get a block page, or, with -challenge, a challenge
+// page they can pass to get through. The admin API is served on -admin,
+// kept on localhost by default.
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/admin"
+	"github.com/cnlangzi/botrate/boltstore"
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/botratehttp"
+	"golang.org/x/time/rate"
+)
+
+func main() {
+	var (
+		listen       = flag.String("listen", ":8080", "address to listen on")
+		upstream     = flag.String("upstream", "", "URL of the upstream to proxy to, required")
+		preserveHost = flag.Bool("preserve-host", false, "pass the client's Host header upstream")
+		adminAddr    = flag.String("admin", "127.0.0.1:9091", "address of the admin API, empty to disable")
+		trusted      = flag.String("trusted-proxies", "", "comma-separated CIDRs of proxies in front, whose X-Forwarded-For is trusted")
+		every        = flag.Duration("every", 10*time.Minute, "interval between requests allowed for blocked clients")
+		burst        = flag.Int("burst", botrate.DefaultBurst, "token bucket burst for blocked clients")
+		window       = flag.Duration("window", botrate.DefaultWindow, "analysis window")
+		threshold    = flag.Int("threshold", botrate.DefaultPageThreshold, "distinct pages per window before blocking")
+		requests     = flag.Int("request-threshold", 0, "requests per window before blocking, 0 for none")
+		errs         = flag.Int("error-threshold", 0, "error responses per window before blocking, 0 for none")
+		blockFor     = flag.Duration("block-duration", botrate.DefaultBlockDuration, "how long clients stay blocked")
+		allow        = flag.String("allow", "", "comma-separated CIDRs bypassing every check")
+		deny         = flag.String("deny", "", "comma-separated CIDRs always rejected")
+		skip         = flag.String("skip", "", "comma-separated path globs bypassing analysis and limiting")
+		actions      = flag.String("actions", "", "comma-separated reason=action pairs, e.g. rate_limited=challenge,fake_bot=tarpit")
+		challenge    = flag.String("challenge", "", "challenge provider, turnstile or hcaptcha; rate limited clients are challenged unless -actions")
+		siteKey      = flag.String("site-key", "", "site key of the challenge provider")
+		secret       = flag.String("secret", "", "secret of the challenge provider, or $BOTRATE_CHALLENGE_SECRET")
+		bypassKey    = flag.String("bypass-key", "", "hex key signing bypass cookies, shared by replicas, or $BOTRATE_BYPASS_KEY; random if empty")
+		blockPage    = flag.String("block-page", "", "html/template file of the block page, executed with the status, reason and Retry-After")
+		persist      = flag.String("persist", "", "bbolt file persisting the blocklist")
+		bots         = flag.String("bots", "", "directory of the knownbots dataset, refreshed daily")
+		dryRun       = flag.Bool("dry-run", false, "log denials without enforcing them")
+		debug        = flag.Bool("debug", false, "log at debug level")
+	)
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	fatal := func(msg string, args ...any) {
+		logger.Error("botrate-proxy: "+msg, args...)
+		os.Exit(1)
+	}
+
+	target, err := url.Parse(*upstream)
+	if err != nil || target.Scheme == "" || target.Host == "" {
+		fatal("invalid -upstream, want a URL like http://127.0.0.1:3000", "upstream", *upstream)
+	}
+	var proxies []netip.Prefix
+	for _, cidr := range split(*trusted) {
+		p, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			fatal("invalid -trusted-proxies", "err", err)
+		}
+		proxies = append(proxies, p)
+	}
+	page := defaultBlockPage
+	if *blockPage != "" {
+		if page, err = template.ParseFiles(*blockPage); err != nil {
+			fatal("invalid -block-page", "err", err)
+		}
+	}
+
+	opts := []botrate.Option{
+		botrate.WithLogger(logger),
+		botrate.WithLimit(rate.Every(*every)),
+		botrate.WithBurst(*burst),
+		botrate.WithAnalyzerWindow(*window),
+		botrate.WithAnalyzerPageThreshold(*threshold),
+		botrate.WithAnalyzerRequestThreshold(*requests),
+		botrate.WithAnalyzerErrorThreshold(*errs),
+		botrate.WithBlockDuration(*blockFor),
+		botrate.WithAllowCIDRs(split(*allow)),
+		botrate.WithDenyCIDRs(split(*deny)),
+		botrate.WithSkipPaths(split(*skip)...),
+		botrate.WithDryRun(*dryRun),
+	}
+	if *challenge != "" && *actions == "" {
+		opts = append(opts, botrate.WithAction(botrate.ReasonRateLimited, botrate.ActionChallenge))
+	}
+	for _, pair := range split(*actions) {
+		reason, name, _ := strings.Cut(pair, "=")
+		action, err := botrate.ParseAction(name)
+		if err != nil {
+			fatal("invalid -actions", "err", err)
+		}
+		opts = append(opts, botrate.WithAction(botrate.Reason(reason), action))
+	}
+	if *persist != "" {
+		store, err := boltstore.Open(*persist)
+		if err != nil {
+			fatal("failed to open blocklist file", "err", err)
+		}
+		defer store.Close()
+		opts = append(opts, botrate.WithStore(store))
+	}
+	if *bots != "" {
+		opts = append(opts, botrate.WithBotData(botdata.WithRoot(*bots)))
+	}
+
+	mwOpts := []botratehttp.MWOption{
+		botratehttp.WithIPFunc(botrate.IPExtractor(proxies)),
+		botratehttp.WithDeniedHandler(blockHandler(page)),
+	}
+	if *challenge != "" {
+		provider, err := newProvider(*challenge, *siteKey, env(*secret, "BOTRATE_CHALLENGE_SECRET"))
+		if err != nil {
+			fatal("invalid -challenge", "err", err)
+		}
+		c := botratehttp.Challenge{Provider: provider}
+		if key := env(*bypassKey, "BOTRATE_BYPASS_KEY"); key != "" {
+			cookie, err := newBypassCookie(key)
+			if err != nil {
+				fatal("invalid -bypass-key", "err", err)
+			}
+			c.Bypass = cookie
+			mwOpts = append(mwOpts, botratehttp.WithBypassCookie(cookie))
+		}
+		mwOpts = append(mwOpts, botratehttp.WithChallenge(c))
+	}
+
+	l, err := botrate.New(opts...)
+	if err != nil {
+		fatal("failed to create limiter", "err", err)
+	}
+	defer l.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handler := botratehttp.Middleware(l, mwOpts...)(newProxy(target, *preserveHost, logger))
+	servers := []*http.Server{{Addr: *listen, Handler: handler}}
+	if *adminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/botrate/", http.StripPrefix("/debug/botrate", admin.New(l)))
+		servers = append(servers, &http.Server{Addr: *adminAddr, Handler: mux})
+	}
+
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		srv.ReadHeaderTimeout = 10 * time.Second
+		go func(srv *http.Server) {
+			logger.Info("botrate-proxy: listening", "addr", srv.Addr, "upstream", target.String())
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}(srv)
+	}
+
+	select {
+	case <-ctx.Done():
+	case err := <-errc:
+		logger.Error("botrate-proxy: server failed", "err", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+	if err := l.Flush(shutdownCtx); err != nil {
+		logger.Warn("botrate-proxy: failed to flush", "err", err)
+	}
+}
+
+// newProvider returns the challenge provider named name.
+func newProvider(name, siteKey, secret string) (botratehttp.Provider, error) {
+	if siteKey == "" || secret == "" {
+		return nil, errors.New("-site-key and -secret are required")
+	}
+	switch name {
+	case "turnstile":
+		return botratehttp.Turnstile(siteKey, secret), nil
+	case "hcaptcha":
+		return botratehttp.HCaptcha(siteKey, secret), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", name)
+	}
+}
+
+// newBypassCookie returns a bypass cookie signed with the hex key.
+func newBypassCookie(key string) (*botratehttp.BypassCookie, error) {
+	b, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := botrate.NewBypassTokens(b)
+	if err != nil {
+		return nil, err
+	}
+	return &botratehttp.BypassCookie{Tokens: tokens}, nil
+}
+
+// env returns v, or the environment variable name if v is empty, so
+// secrets need not be passed on the command line.
+func env(v, name string) string {
+	if v != "" {
+		return v
+	}
+	return os.Getenv(name)
+}
+
+// split splits a comma-separated flag, dropping empty items.
+func split(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/botratehttp"
+)
+
+// blockPageData is what the block page is executed with.
+type blockPageData struct {
+	Status int
+	Reason botrate.Reason
+
+	// RetryAfter is the Retry-After header in seconds, "" if the client
+	// may never proceed.
+	RetryAfter string
+}
+
+var defaultBlockPage = template.Must(template.New("block").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Access denied</title></head>
+<body>
+<h1>{{if eq .Status 429}}Too many requests{{else}}Access denied{{end}}</h1>
+<p>Your requests were blocked as automated traffic.{{if .RetryAfter}} Please try again in {{.RetryAfter}} seconds.{{end}}</p>
+</body>
+</html>
+`))
+
+// blockHandler returns a botratehttp.DeniedFunc serving page, with the
+// status botratehttp.Denied would use.
+func blockHandler(page *template.Template) botratehttp.DeniedFunc {
+	return func(w http.ResponseWriter, r *http.Request, reason botrate.Reason) {
+		status := http.StatusTooManyRequests
+		if reason.Forbidden() {
+			status = http.StatusForbidden
+		}
+
+		h := w.Header()
+		h.Set("Cache-Control", "no-store")
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		page.Execute(w, blockPageData{
+			Status:     status,
+			Reason:     reason,
+			RetryAfter: h.Get("Retry-After"),
+		})
+	}
+}
+
+// newProxy returns a reverse proxy to target, setting X-Forwarded
+// headers. The Host header is target's unless preserveHost.
+func newProxy(target *url.URL, preserveHost bool, logger *slog.Logger) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			if preserveHost {
+				pr.Out.Host = pr.In.Host
+			}
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			logger.Warn("botrate-proxy: upstream failed", "path", r.URL.Path, "err", err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
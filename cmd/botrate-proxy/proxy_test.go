@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/botratehttp"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+// newHandler returns the proxy to an upstream echoing the client IP it
+// was forwarded.
+func newHandler(t *testing.T, opts ...botrate.Option) http.Handler {
+	t.Helper()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.Header.Get("X-Forwarded-For"))
+	}))
+	t.Cleanup(upstream.Close)
+	target, _ := url.Parse(upstream.URL)
+
+	l, err := botrate.New(append([]botrate.Option{botrate.WithKnownbots(testbots.New(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return botratehttp.Middleware(l,
+		botratehttp.WithDeniedHandler(blockHandler(defaultBlockPage)),
+	)(newProxy(target, false, logger))
+}
+
+func get(h http.Handler, ua, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("User-Agent", ua)
+	req.RemoteAddr = remoteAddr
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestProxy_Allowed(t *testing.T) {
+	h := newHandler(t)
+
+	rec := get(h, "Mozilla/5.0", "192.168.1.1:1234")
+	if rec.Code != http.StatusOK || rec.Body.String() != "upstream 192.168.1.1" {
+		t.Errorf("expected the upstream response, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestProxy_BlockPage(t *testing.T) {
+	h := newHandler(t)
+
+	rec := get(h, "TestBot/1.0", "10.0.0.1:1234")
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("fake bots should get 403, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "Access denied") || strings.Contains(body, "upstream") {
+		t.Errorf("expected the block page, got %q", body)
+	}
+}
+
+func TestProxy_RateLimited(t *testing.T) {
+	h := newHandler(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerRequestThreshold(2),
+		botrate.WithLimit(rate.Every(time.Hour)),
+	)
+
+	for i := 0; i < 3; i++ {
+		get(h, "Mozilla/5.0", "192.168.1.2:1234")
+	}
+	rec := get(h, "Mozilla/5.0", "192.168.1.2:1234")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "try again in "+rec.Header().Get("Retry-After")+" seconds") {
+		t.Errorf("block page should tell when to retry, got %q", body)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	if _, err := newProvider("turnstile", "site", "secret"); err != nil {
+		t.Errorf("turnstile: %v", err)
+	}
+	if _, err := newProvider("hcaptcha", "site", ""); err == nil {
+		t.Error("missing secrets should fail")
+	}
+	if _, err := newProvider("recaptcha", "site", "secret"); err == nil {
+		t.Error("unknown providers should fail")
+	}
+}
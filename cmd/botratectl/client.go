@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client talks to an admin API, see package admin.
+type client struct {
+	base string // URL the admin API is mounted at, without a trailing slash
+	http *http.Client
+}
+
+func newClient(base string) *client {
+	return &client{
+		base: strings.TrimSuffix(base, "/"),
+		http: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// do sends a request to path and decodes the JSON response into v, if
+// not nil. Error statuses are returned as errors.
+func (c *client) do(method, path string, body io.Reader, v any) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("%s %s: %s: %s", method, path, res.Status, e.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, res.Status)
+	}
+	if v == nil {
+		return nil
+	}
+	if w, ok := v.(io.Writer); ok {
+		_, err = io.Copy(w, res.Body)
+		return err
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (c *client) get(path string, v any) error {
+	return c.do(http.MethodGet, path, nil, v)
+}
+
+// ipPath returns path with ip escaped as its last segment.
+func ipPath(path, ip string) string {
+	return path + "/" + url.PathEscape(ip)
+}
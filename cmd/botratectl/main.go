@@ -0,0 +1,296 @@
+// Command botratectl manages a limiter through its admin API, see package
+// admin, e.g. from a terminal during an incident.
+//
+//	botratectl [-addr url] <command> [args]
+//
+// Commands:
+//
+//	stats                     show the stats
+//	config                    show the configuration
+//	set <name=value>...       set limit (events/duration), page_threshold or window
+//	blocked                   list the blocked IPs
+//	inspect <ip>              show what the limiter knows about an IP
+//	block <ip> [duration]     block an IP, forever without a duration
+//	unblock <ip>...           unblock IPs
+//	events [-f] [-interval d] show recent block events, following new ones with -f
+//	snapshot dump [file]      write the limiter state to file, or stdout
+//	snapshot import [file]    restore the limiter state from file, or stdin
+//
+// The admin API is at -addr, $BOTRATE_ADMIN or
+// http://127.0.0.1:9091/debug/botrate, where botrated serves it.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+const defaultAddr = "http://127.0.0.1:9091/debug/botrate"
+
+var errUsage = errors.New("usage: botratectl [-addr url] stats|config|set|blocked|inspect|block|unblock|events|snapshot [args]")
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "botratectl:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command in args.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("botratectl", flag.ContinueOnError)
+	addr := fs.String("addr", env("BOTRATE_ADMIN", defaultAddr), "URL of the admin API")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	c := newClient(*addr)
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "stats":
+		return printJSON(c, "/", stdout)
+	case "config":
+		return printJSON(c, "/config", stdout)
+	case "set":
+		return set(c, args, stdout)
+	case "blocked":
+		return blocked(c, stdout)
+	case "inspect":
+		if len(args) != 1 {
+			return errors.New("usage: botratectl inspect <ip>")
+		}
+		return printJSON(c, ipPath("/ips", args[0]), stdout)
+	case "block":
+		return block(c, args)
+	case "unblock":
+		return unblock(c, args)
+	case "events":
+		return events(c, args, stdout)
+	case "snapshot":
+		return snapshot(c, args, stdin, stdout)
+	default:
+		return errUsage
+	}
+}
+
+// printJSON prints the JSON served at path, indented.
+func printJSON(c *client, path string, stdout io.Writer) error {
+	var raw json.RawMessage
+	if err := c.get(path, &raw); err != nil {
+		return err
+	}
+	return writeIndented(raw, stdout)
+}
+
+// writeIndented writes raw JSON to stdout, indented.
+func writeIndented(raw json.RawMessage, stdout io.Writer) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := buf.WriteTo(stdout)
+	return err
+}
+
+// set changes the configuration with name=value pairs and prints the
+// result.
+func set(c *client, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: botratectl set limit=<events/duration> page_threshold=<n> window=<duration>")
+	}
+	patch := make(map[string]any)
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q, want name=value", arg)
+		}
+		switch name {
+		case "page_threshold":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid page_threshold: %w", err)
+			}
+			patch[name] = n
+		case "limit", "window":
+			patch[name] = value
+		default:
+			return fmt.Errorf("unknown setting %q", name)
+		}
+	}
+
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	var raw json.RawMessage
+	if err := c.do(http.MethodPatch, "/config", bytes.NewReader(body), &raw); err != nil {
+		return err
+	}
+	return writeIndented(raw, stdout)
+}
+
+type blockedIP struct {
+	IP    string     `json:"ip"`
+	Until *time.Time `json:"until,omitempty"`
+	Pages int        `json:"pages,omitempty"`
+}
+
+func blocked(c *client, stdout io.Writer) error {
+	var ips []blockedIP
+	if err := c.get("/blocked", &ips); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "IP\tUNTIL\tPAGES")
+	for _, b := range ips {
+		until := "forever"
+		if b.Until != nil {
+			until = b.Until.Local().Format(time.RFC3339)
+		}
+		pages := "-"
+		if b.Pages > 0 {
+			pages = strconv.Itoa(b.Pages)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", b.IP, until, pages)
+	}
+	return tw.Flush()
+}
+
+func block(c *client, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: botratectl block <ip> [duration]")
+	}
+	path := ipPath("/blocked", args[0])
+	if len(args) == 2 {
+		if _, err := time.ParseDuration(args[1]); err != nil {
+			return err
+		}
+		path += "?duration=" + url.QueryEscape(args[1])
+	}
+	return c.do(http.MethodPost, path, nil, nil)
+}
+
+func unblock(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: botratectl unblock <ip>...")
+	}
+	for _, ip := range args {
+		if err := c.do(http.MethodDelete, ipPath("/blocked", ip), nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func events(c *client, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	follow := fs.Bool("f", false, "follow new events")
+	interval := fs.Duration("interval", 2*time.Second, "how often to poll with -f")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var last time.Time
+	for {
+		var events []analyzer.Event
+		if err := c.get("/events", &events); err != nil {
+			return err
+		}
+		// Newest first: print the new ones oldest first
+		for i := len(events) - 1; i >= 0; i-- {
+			if e := events[i]; e.Time.After(last) {
+				printEvent(stdout, e)
+				last = e.Time
+			}
+		}
+		if !*follow {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func printEvent(w io.Writer, e analyzer.Event) {
+	fmt.Fprintf(w, "%s %s %s reason=%s", e.Time.Local().Format(time.RFC3339), e.Type, e.IP, e.Reason())
+	if e.Duration > 0 {
+		fmt.Fprintf(w, " duration=%s", e.Duration)
+	}
+	for _, f := range []struct {
+		name  string
+		value int
+	}{
+		{"pages", e.Pages},
+		{"requests", e.Requests},
+		{"errors", e.Errors},
+		{"logins", e.Logins},
+		{"offenses", e.Offenses},
+	} {
+		if f.value > 0 {
+			fmt.Fprintf(w, " %s=%d", f.name, f.value)
+		}
+	}
+	if e.Score > 0 {
+		fmt.Fprintf(w, " score=%g", e.Score)
+	}
+	if e.Honeypot != "" {
+		fmt.Fprintf(w, " honeypot=%s", e.Honeypot)
+	}
+	fmt.Fprintln(w)
+}
+
+func snapshot(c *client, args []string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: botratectl snapshot dump|import [file]")
+	}
+
+	switch args[0] {
+	case "dump":
+		if len(args) == 1 {
+			return c.get("/snapshot", stdout)
+		}
+		var buf bytes.Buffer
+		if err := c.get("/snapshot", &buf); err != nil {
+			return err
+		}
+		return os.WriteFile(args[1], buf.Bytes(), 0600)
+	case "import":
+		r := stdin
+		if len(args) == 2 && args[1] != "-" {
+			f, err := os.Open(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		return c.do(http.MethodPut, "/snapshot", r, nil)
+	default:
+		return errors.New("usage: botratectl snapshot dump|import [file]")
+	}
+}
+
+// env returns the environment variable name, or def if it is empty.
+func env(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/admin"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+)
+
+// newServer serves the admin API of a new limiter.
+func newServer(t *testing.T) (*botrate.Limiter, string) {
+	t.Helper()
+
+	kb, err := knownbots.New(knownbots.WithRoot(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Failed to create knownbots validator: %v", err)
+	}
+	t.Cleanup(func() { kb.Close() })
+
+	l, err := botrate.New(botrate.WithKnownbots(kb))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+
+	srv := httptest.NewServer(admin.New(l))
+	t.Cleanup(srv.Close)
+	return l, srv.URL
+}
+
+func ctl(t *testing.T, addr string, args ...string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	if err := run(append([]string{"-addr", addr}, args...), strings.NewReader(""), &out); err != nil {
+		t.Fatalf("botratectl %v: %v", args, err)
+	}
+	return out.String()
+}
+
+func TestBlockUnblock(t *testing.T) {
+	l, addr := newServer(t)
+
+	ctl(t, addr, "block", "192.168.1.1", "1h")
+	ctl(t, addr, "block", "192.168.1.2")
+	if n := len(l.Blocklist()); n != 2 {
+		t.Fatalf("expected 2 blocked IPs, got %d", n)
+	}
+
+	out := ctl(t, addr, "blocked")
+	if !strings.Contains(out, "192.168.1.1") || !strings.Contains(out, "forever") {
+		t.Errorf("unexpected blocked list:\n%s", out)
+	}
+
+	ctl(t, addr, "unblock", "192.168.1.1", "192.168.1.2")
+	if n := len(l.Blocklist()); n != 0 {
+		t.Errorf("expected no blocked IPs, got %d", n)
+	}
+}
+
+func TestStatsInspectEvents(t *testing.T) {
+	l, addr := newServer(t)
+	l.Block("192.168.1.1", time.Hour)
+
+	if out := ctl(t, addr, "stats"); !strings.Contains(out, `"blocklist": 1`) {
+		t.Errorf("unexpected stats:\n%s", out)
+	}
+	if out := ctl(t, addr, "inspect", "192.168.1.1"); !strings.Contains(out, `"blocked": true`) {
+		t.Errorf("unexpected inspection:\n%s", out)
+	}
+	if out := ctl(t, addr, "events"); !strings.Contains(out, "block 192.168.1.1 reason=manual duration=1h0m0s") {
+		t.Errorf("unexpected events:\n%s", out)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	l, addr := newServer(t)
+	l.Block("192.168.1.1", time.Hour)
+
+	file := filepath.Join(t.TempDir(), "snapshot.json")
+	ctl(t, addr, "snapshot", "dump", file)
+
+	other, otherAddr := newServer(t)
+	ctl(t, otherAddr, "snapshot", "import", file)
+	if entries := other.Blocklist(); len(entries) != 1 || entries[0].IP != "192.168.1.1" {
+		t.Errorf("snapshot should be restored, got %+v", entries)
+	}
+}
+
+func TestSet(t *testing.T) {
+	l, addr := newServer(t)
+
+	out := ctl(t, addr, "set", "page_threshold=30", "window=10m", "limit=1/h")
+	if !strings.Contains(out, `"page_threshold": 30`) {
+		t.Errorf("set should print the configuration, got %s", out)
+	}
+	cfg := l.Config()
+	if cfg.PageThreshold != 30 || cfg.Window != 10*time.Minute || cfg.Limit != rate.Every(time.Hour) {
+		t.Errorf("unexpected config, threshold %d, window %s, limit %v", cfg.PageThreshold, cfg.Window, cfg.Limit)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, addr := newServer(t)
+
+	for _, args := range [][]string{
+		{},
+		{"bogus"},
+		{"block"},
+		{"inspect"},
+		{"block", "192.168.1.1", "soon"},
+		{"snapshot", "restore"},
+		{"set"},
+		{"set", "burst=2"},
+		{"set", "page_threshold=many"},
+		{"set", "window=soon"},
+	} {
+		if err := run(append([]string{"-addr", addr}, args...), strings.NewReader(""), &bytes.Buffer{}); err == nil {
+			t.Errorf("botratectl %v: expected an error", args)
+		}
+	}
+
+	err := run([]string{"-addr", addr, "snapshot", "import"}, strings.NewReader("{}"), &bytes.Buffer{})
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("API errors should be returned, got %v", err)
+	}
+}
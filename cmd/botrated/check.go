@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/botratehttp"
+	"golang.org/x/time/rate"
+)
+
+// checkRequest is a request to decide, as posted to /check. GET requests
+// pass the same fields as query parameters.
+type checkRequest struct {
+	UA     string `json:"ua"`
+	IP     string `json:"ip"`
+	Path   string `json:"path"`
+	Method string `json:"method,omitempty"`
+	Host   string `json:"host,omitempty"`
+	User   string `json:"user,omitempty"`
+	Cost   int    `json:"cost,omitempty"`
+
+	// TLSFingerprint is the client's JA3 or JA4 hash, see
+	// botrate.Request.TLSFingerprint.
+	TLSFingerprint string `json:"tls_fingerprint,omitempty"`
+}
+
+// checkResponse is the decision returned by /check.
+type checkResponse struct {
+	Allowed bool           `json:"allowed"`
+	Reason  botrate.Reason `json:"reason,omitempty"`
+	Action  string         `json:"action,omitempty"`
+	Bot     string         `json:"bot,omitempty"`
+
+	// Key is what the request was analyzed by, to pass to /record.
+	Key string `json:"key,omitempty"`
+
+	// RetryAfter is in seconds, rounded up: -1 for never, 0 when allowed.
+	RetryAfter int64 `json:"retry_after,omitempty"`
+}
+
+// recordRequest reports the response status of a checked request, as
+// posted to /record.
+type recordRequest struct {
+	Key    string `json:"key"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status"`
+}
+
+type checkHandler struct {
+	l         *botrate.Limiter
+	ipHeader  string
+	tlsHeader string
+}
+
+// newCheckHandler returns the handler of the check API. /auth takes the
+// client IP from ipHeader, and the TLS fingerprint from tlsHeader if not
+// empty, set by the proxy.
+func newCheckHandler(l *botrate.Limiter, ipHeader, tlsHeader string) http.Handler {
+	h := &checkHandler{l: l, ipHeader: ipHeader, tlsHeader: tlsHeader}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /check", h.check)
+	mux.HandleFunc("POST /check", h.check)
+	mux.HandleFunc("POST /record", h.record)
+	mux.HandleFunc("GET /auth", h.auth)
+	return mux
+}
+
+// check decides the request described by the query or the JSON body. It
+// responds 200 either way, with RateLimit and Retry-After headers like
+// botratehttp.Middleware.
+func (h *checkHandler) check(w http.ResponseWriter, r *http.Request) {
+	var req checkRequest
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	} else {
+		q := r.URL.Query()
+		req = checkRequest{
+			UA:     q.Get("ua"),
+			IP:     q.Get("ip"),
+			Path:   q.Get("path"),
+			Method: q.Get("method"),
+			Host:   q.Get("host"),
+			User:   q.Get("user"),
+
+			TLSFingerprint: q.Get("tls_fingerprint"),
+		}
+		if cost := q.Get("cost"); cost != "" {
+			n, err := strconv.Atoi(cost)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid cost"})
+				return
+			}
+			req.Cost = n
+		}
+	}
+	if req.IP == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing ip"})
+		return
+	}
+	if req.Method == "" {
+		req.Method = http.MethodGet
+	}
+
+	d := h.l.Check(botrate.Request{
+		UA:     req.UA,
+		IP:     req.IP,
+		Path:   req.Path,
+		Method: req.Method,
+		Host:   req.Host,
+		User:   req.User,
+		Cost:   req.Cost,
+
+		TLSFingerprint: req.TLSFingerprint,
+	})
+	botratehttp.WriteRateLimitHeaders(w, d)
+
+	res := checkResponse{
+		Allowed: d.Allowed,
+		Reason:  d.Reason,
+		Bot:     d.Bot,
+		Key:     d.Key,
+	}
+	if !d.Allowed {
+		res.Action = d.Action.String()
+		res.RetryAfter = retryAfter(w, d)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// record feeds a response status back, see Limiter.RecordResponse and
+// Limiter.RecordLogin.
+func (h *checkHandler) record(w http.ResponseWriter, r *http.Request) {
+	var req recordRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if req.Key == "" || req.Status == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing key or status"})
+		return
+	}
+
+	h.l.RecordResponse(req.Key, req.Status)
+	if req.Path != "" {
+		h.l.RecordLogin(req.Key, req.Path, req.Status)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// auth serves nginx auth_request subrequests: the original URI, method
+// and host come in X-Original-URI, X-Original-Method and
+// X-Original-Host, the client IP in
+// the configured header. It responds 204 when the request is allowed and
+// 403 when it is not, nginx treating other statuses as errors, with the
+// reason in X-Botrate-Reason so nginx can map it, e.g. to 429.
+func (h *checkHandler) auth(w http.ResponseWriter, r *http.Request) {
+	ip := r.Header.Get(h.ipHeader)
+	if ip == "" {
+		http.Error(w, "missing "+h.ipHeader, http.StatusBadRequest)
+		return
+	}
+	method := r.Header.Get("X-Original-Method")
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := r.Header.Get("X-Original-URI")
+	if u, err := r.URL.Parse(path); err == nil {
+		path = u.Path
+	}
+
+	// nginx passes the client's headers on, next to its own
+	req := botrate.Request{
+		UA:      r.UserAgent(),
+		IP:      ip,
+		Path:    path,
+		Method:  method,
+		Host:    r.Header.Get("X-Original-Host"),
+		Headers: botrate.HeaderFingerprint(r.Header),
+	}
+	if h.tlsHeader != "" {
+		req.TLSFingerprint = r.Header.Get(h.tlsHeader)
+	}
+	d := h.l.Check(req)
+	botratehttp.WriteRateLimitHeaders(w, d)
+	if d.Allowed {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	retryAfter(w, d)
+	w.Header().Set("X-Botrate-Reason", string(d.Reason))
+	w.WriteHeader(http.StatusForbidden)
+}
+
+// retryAfter sets the Retry-After header of a denied decision, if it may
+// proceed later, and returns it in seconds, -1 for never.
+func retryAfter(w http.ResponseWriter, d botrate.Decision) int64 {
+	if d.RetryAfter == rate.InfDuration {
+		return -1
+	}
+	secs := int64((d.RetryAfter + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	return secs
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
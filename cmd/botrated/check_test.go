@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func newLimiter(t *testing.T, opts ...botrate.Option) *botrate.Limiter {
+	t.Helper()
+
+	l, err := botrate.New(append([]botrate.Option{botrate.WithKnownbots(testbots.New(t))}, opts...)...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(l.Close)
+	return l
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) checkResponse {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var res checkResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("invalid response: %v", err)
+	}
+	return res
+}
+
+func TestCheck(t *testing.T) {
+	h := newCheckHandler(newLimiter(t), "X-Real-IP", "")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?ua=Mozilla/5.0&ip=192.168.1.1&path=/", nil))
+	if res := decode(t, rec); !res.Allowed || res.Key != "192.168.1.1" {
+		t.Errorf("normal users should be allowed, got %+v", res)
+	}
+
+	rec = httptest.NewRecorder()
+	body := `{"ua":"TestBot/1.0","ip":"10.0.0.1","path":"/"}`
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(body)))
+	res := decode(t, rec)
+	if res.Allowed || res.Reason != botrate.ReasonFakeBot || res.Action != "reject" || res.RetryAfter != -1 {
+		t.Errorf("fake bots should be denied, got %+v", res)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?ua=Mozilla/5.0", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("requests without an IP should get 400, got %d", rec.Code)
+	}
+}
+
+func TestCheck_RateLimited(t *testing.T) {
+	h := newCheckHandler(newLimiter(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerRequestThreshold(2),
+		botrate.WithLimit(rate.Every(time.Hour)),
+	), "X-Real-IP", "")
+
+	var rec *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?ua=Mozilla/5.0&ip=192.168.1.2&path=/", nil))
+	}
+	res := decode(t, rec)
+	if res.Allowed || res.Reason != botrate.ReasonRateLimited || res.RetryAfter <= 0 {
+		t.Errorf("expected a rate limit, got %+v", res)
+	}
+	if rec.Header().Get("Retry-After") == "" || rec.Header().Get("RateLimit-Limit") == "" {
+		t.Errorf("expected Retry-After and RateLimit headers, got %v", rec.Header())
+	}
+}
+
+func TestRecord(t *testing.T) {
+	h := newCheckHandler(newLimiter(t,
+		botrate.WithSyncAnalyzer(true),
+		botrate.WithAnalyzerErrorThreshold(2),
+	), "X-Real-IP", "")
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?ua=Mozilla/5.0&ip=192.168.1.3&path=/missing", nil))
+		res := decode(t, rec)
+
+		rec = httptest.NewRecorder()
+		body := `{"key":"` + res.Key + `","status":404}`
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/record", strings.NewReader(body)))
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("expected 204, got %d", rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?ua=Mozilla/5.0&ip=192.168.1.3&path=/", nil))
+	if res := decode(t, rec); res.Allowed {
+		t.Errorf("clients hitting errors should be blocked, got %+v", res)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	h := newCheckHandler(newLimiter(t, botrate.WithDenyCIDRs([]string{"203.0.113.0/24"})), "X-Real-IP", "")
+
+	auth := func(ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+		req.Header.Set("User-Agent", "Mozilla/5.0")
+		req.Header.Set("X-Original-URI", "/page?q=1")
+		req.Header.Set("X-Real-IP", ip)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := auth("192.168.1.1"); rec.Code != http.StatusNoContent {
+		t.Errorf("allowed requests should get 204, got %d", rec.Code)
+	}
+	rec := auth("203.0.113.1")
+	if rec.Code != http.StatusForbidden || rec.Header().Get("X-Botrate-Reason") != string(botrate.ReasonDenied) {
+		t.Errorf("denied requests should get 403 with the reason, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := auth(""); rec.Code != http.StatusBadRequest {
+		t.Errorf("requests without the IP header should get 400, got %d", rec.Code)
+	}
+}
+
+func TestAuth_TLSFingerprint(t *testing.T) {
+	h := newCheckHandler(newLimiter(t, botrate.WithDenyTLSFingerprints("e7d705a3286e19ea42f587b344ee6865")), "X-Real-IP", "X-JA3")
+
+	req := httptest.NewRequest(http.MethodGet, "/auth", nil)
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("X-Real-IP", "192.168.1.1")
+	req.Header.Set("X-JA3", "e7d705a3286e19ea42f587b344ee6865")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || rec.Header().Get("X-Botrate-Reason") != string(botrate.ReasonTLSFingerprint) {
+		t.Errorf("denied fingerprints should get 403 with the reason, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestCheck_HostIsolation(t *testing.T) {
+	h := newCheckHandler(newLimiter(t, botrate.WithHostIsolation("shop.example.com")), "X-Real-IP", "")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?ua=Mozilla/5.0&ip=192.168.1.1&path=/&host=shop.example.com", nil))
+	if res := decode(t, rec); res.Key != "shop.example.com/192.168.1.1" {
+		t.Errorf("expected the host and IP as key, got %q", res.Key)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	l := newLimiter(t)
+	l.Decide("TestBot/1.0", "10.0.0.1", "/")
+
+	rec := httptest.NewRecorder()
+	metricsHandler(l).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"botrate_requests_total 1\n",
+		`botrate_denied_total{reason="fake_bot"} 1` + "\n",
+		"# TYPE botrate_blocklist gauge\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Command botrated runs botrate as a sidecar, so services not written in
+// Go, e.g. PHP or Python apps and nginx, can use it.
+//
+// It serves, on -listen:
+//
+//	GET|POST /check   decide a request from its UA, IP and path, as JSON
+//	POST     /record  report the response status of a checked request
+//	GET      /auth    nginx auth_request endpoint
+//
+// and, on -admin, kept on localhost by default:
+//
+//	/debug/botrate/   the admin API, see package admin
+//	/metrics          Prometheus metrics
+//
+// With -grpc, it also serves the Envoy external authorization API, see
+// package botrateenvoy.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/admin"
+	"github.com/cnlangzi/botrate/boltstore"
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/botrateenvoy"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+)
+
+func main() {
+	var (
+		listen    = flag.String("listen", "127.0.0.1:9090", "address of the check API")
+		adminAddr = flag.String("admin", "127.0.0.1:9091", "address of the admin API and metrics, empty to disable")
+		grpcAddr  = flag.String("grpc", "", "address of the Envoy ext_authz gRPC API, empty to disable")
+		ipHeader  = flag.String("ip-header", "X-Real-IP", "header carrying the client IP on /auth")
+		tlsHeader = flag.String("tls-header", "", "header carrying the client's JA3 or JA4 hash on /auth, empty for none")
+		denyTLS   = flag.String("deny-tls", "", "comma-separated JA3 or JA4 hashes always rejected")
+		every     = flag.Duration("every", 10*time.Minute, "interval between requests allowed for blocked clients")
+		burst     = flag.Int("burst", botrate.DefaultBurst, "token bucket burst for blocked clients")
+		window    = flag.Duration("window", botrate.DefaultWindow, "analysis window")
+		threshold = flag.Int("threshold", botrate.DefaultPageThreshold, "distinct pages per window before blocking")
+		requests  = flag.Int("request-threshold", 0, "requests per window before blocking, 0 for none")
+		errs      = flag.Int("error-threshold", 0, "error responses per window before blocking, 0 for none")
+		headers   = flag.Int("header-threshold", 0, "browser requests without browser headers per window before blocking, on /auth, 0 for none")
+		sequences = flag.Int("sequence-threshold", 0, "sequential IDs, like /item/1 then /item/2, per window before blocking, 0 for none")
+		queries   = flag.Int("query-threshold", 0, "distinct query strings of a page per window before blocking, 0 for none")
+		blockFor  = flag.Duration("block-duration", botrate.DefaultBlockDuration, "how long clients stay blocked")
+		maxBlocks = flag.Int("max-blocklist", 0, "clients blocked at once before evicting, 0 for no limit")
+		prefixes  = flag.Int("prefix-threshold", 0, "IPs of a /24 or /48 blocked per window before blocking it, 0 for none")
+		allow     = flag.String("allow", "", "comma-separated CIDRs bypassing every check")
+		deny      = flag.String("deny", "", "comma-separated CIDRs always rejected")
+		skip      = flag.String("skip", "", "comma-separated path globs bypassing analysis and limiting")
+		perHost   = flag.String("isolate-hosts", "", "comma-separated virtual hosts whose clients are analyzed and limited apart")
+		persist   = flag.String("persist", "", "bbolt file persisting the blocklist")
+		bots      = flag.String("bots", "", "directory of the knownbots dataset, refreshed daily")
+		dryRun    = flag.Bool("dry-run", false, "log denials without enforcing them")
+		debug     = flag.Bool("debug", false, "log at debug level")
+	)
+	flag.Parse()
+
+	level := slog.LevelInfo
+	if *debug {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	opts := []botrate.Option{
+		botrate.WithLogger(logger),
+		botrate.WithLimit(rate.Every(*every)),
+		botrate.WithBurst(*burst),
+		botrate.WithAnalyzerWindow(*window),
+		botrate.WithAnalyzerPageThreshold(*threshold),
+		botrate.WithAnalyzerRequestThreshold(*requests),
+		botrate.WithAnalyzerErrorThreshold(*errs),
+		botrate.WithHeaderThreshold(*headers),
+		botrate.WithSequenceThreshold(*sequences),
+		botrate.WithQueryThreshold(*queries),
+		botrate.WithBlockDuration(*blockFor),
+		botrate.WithMaxBlocklist(*maxBlocks),
+		botrate.WithPrefixThreshold(*prefixes),
+		botrate.WithAllowCIDRs(split(*allow)),
+		botrate.WithDenyCIDRs(split(*deny)),
+		botrate.WithDenyTLSFingerprints(split(*denyTLS)...),
+		botrate.WithSkipPaths(split(*skip)...),
+		botrate.WithHostIsolation(split(*perHost)...),
+		botrate.WithDryRun(*dryRun),
+	}
+	if *persist != "" {
+		store, err := boltstore.Open(*persist)
+		if err != nil {
+			logger.Error("botrated: failed to open blocklist file", "err", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		opts = append(opts, botrate.WithStore(store))
+	}
+	if *bots != "" {
+		opts = append(opts, botrate.WithBotData(botdata.WithRoot(*bots)))
+	}
+
+	l, err := botrate.New(opts...)
+	if err != nil {
+		logger.Error("botrated: failed to create limiter", "err", err)
+		os.Exit(1)
+	}
+	defer l.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	servers := []*http.Server{{Addr: *listen, Handler: newCheckHandler(l, *ipHeader, *tlsHeader)}}
+	if *adminAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/debug/botrate/", http.StripPrefix("/debug/botrate", admin.New(l)))
+		mux.Handle("/metrics", metricsHandler(l))
+		servers = append(servers, &http.Server{Addr: *adminAddr, Handler: mux})
+	}
+
+	errc := make(chan error, len(servers)+1)
+	for _, srv := range servers {
+		srv.ReadHeaderTimeout = 10 * time.Second
+		go func(srv *http.Server) {
+			logger.Info("botrated: listening", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}(srv)
+	}
+
+	var g *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			logger.Error("botrated: failed to listen", "addr", *grpcAddr, "err", err)
+			os.Exit(1)
+		}
+		g = grpc.NewServer()
+		botrateenvoy.New(l).Register(g)
+		go func() {
+			logger.Info("botrated: listening", "addr", *grpcAddr, "api", "ext_authz")
+			errc <- g.Serve(lis)
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+	case err := <-errc:
+		logger.Error("botrated: server failed", "err", err)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(shutdownCtx)
+	}
+	if g != nil {
+		g.GracefulStop()
+	}
+	if err := l.Flush(shutdownCtx); err != nil {
+		logger.Warn("botrated: failed to flush", "err", err)
+	}
+}
+
+// split splits a comma-separated flag, dropping empty items.
+func split(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
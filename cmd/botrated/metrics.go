@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/cnlangzi/botrate"
+)
+
+// metricsHandler serves l.Stats in the Prometheus text format.
+func metricsHandler(l *botrate.Limiter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, l.Stats())
+	})
+}
+
+func writeMetrics(w io.Writer, s botrate.Stats) {
+	metric(w, "botrate_requests_total", "counter", "Requests checked.", s.Requests)
+
+	reasons := make([]string, 0, len(s.Denied))
+	for reason := range s.Denied {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	fmt.Fprintln(w, "# HELP botrate_denied_total Requests denied, by reason.")
+	fmt.Fprintln(w, "# TYPE botrate_denied_total counter")
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "botrate_denied_total{reason=%q} %d\n", reason, s.Denied[botrate.Reason(reason)])
+	}
+
+	metric(w, "botrate_blocklist", "gauge", "Clients currently blocked.", s.Blocklist)
+	metric(w, "botrate_greylist", "gauge", "Clients currently greylisted.", s.Greylist)
+	metric(w, "botrate_queue_length", "gauge", "Analyzer events queued.", s.QueueLen)
+	metric(w, "botrate_queue_capacity", "gauge", "Analyzer event queue capacity.", s.QueueCap)
+	metric(w, "botrate_dropped_total", "counter", "Analyzer events dropped because the queue was full.", s.Dropped)
+	metric(w, "botrate_evicted_total", "counter", "Blocks evicted because the blocklist was full.", s.Evicted)
+	metric(w, "botrate_blocked_prefixes", "gauge", "Currently blocked IP prefixes.", s.Prefixes)
+	metric(w, "botrate_prefix_blocks_total", "counter", "IP prefixes blocked for the IPs blocked in them.", s.PrefixBlocks)
+	metric(w, "botrate_limiters", "gauge", "Active per-client token buckets.", s.Limiters)
+	metric(w, "botrate_verify_cache_hits_total", "counter", "Bot verifications answered by the cache.", s.VerifyCacheHits)
+	metric(w, "botrate_verify_cache_misses_total", "counter", "Bot verifications missing the cache.", s.VerifyCacheMisses)
+	metric(w, "botrate_pending_total", "counter", "Pending bot verifications.", s.Pending)
+	metric(w, "botrate_pending_exhausted_total", "counter", "Pending bot verifications past their retries.", s.PendingExhausted)
+}
+
+func metric(w io.Writer, name, typ, help string, value any) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, value)
+}
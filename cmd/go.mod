@@ -0,0 +1,34 @@
+module github.com/cnlangzi/botrate/cmd
+
+go 1.22
+
+require (
+	github.com/cnlangzi/botrate v0.0.0-00010101000000-000000000000
+	github.com/cnlangzi/botrate/boltstore v0.0.0-00010101000000-000000000000
+	github.com/cnlangzi/botrate/botrateenvoy v0.0.0-00010101000000-000000000000
+	github.com/cnlangzi/knownbots v1.0.6
+	golang.org/x/time v0.7.0
+	google.golang.org/grpc v1.63.2
+)
+
+require (
+	github.com/bits-and-blooms/bitset v1.24.2 // indirect
+	github.com/bits-and-blooms/bloom/v3 v3.7.1 // indirect
+	github.com/cncf/xds/go v0.0.0-20231128003011-0fa0005c9caa // indirect
+	github.com/envoyproxy/go-control-plane v0.12.0 // indirect
+	github.com/envoyproxy/protoc-gen-validate v1.0.4 // indirect
+	github.com/golang/protobuf v1.5.4 // indirect
+	go.etcd.io/bbolt v1.3.11 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240429193739-8cf5692501f6 // indirect
+	google.golang.org/protobuf v1.34.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
+
+replace github.com/cnlangzi/botrate => ..
+
+replace github.com/cnlangzi/botrate/boltstore => ../boltstore
+
+replace github.com/cnlangzi/botrate/botrateenvoy => ../botrateenvoy
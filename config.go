@@ -1,15 +1,261 @@
 package botrate
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
 	"time"
 
+	"github.com/cnlangzi/botrate/abuseipdb"
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/audit"
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/cloudranges"
+	"github.com/cnlangzi/botrate/dnsbl"
+	"github.com/cnlangzi/botrate/rdns"
+	"github.com/cnlangzi/botrate/tor"
+	"github.com/cnlangzi/botrate/useragent"
+	"github.com/cnlangzi/knownbots"
 	"golang.org/x/time/rate"
 )
 
+// BotLimit is the rate limit of a verified bot, see WithBotLimit.
+type BotLimit struct {
+	Limit rate.Limit
+	Burst int
+}
+
 // Config holds core configuration.
 type Config struct {
-	Limit         rate.Limit
-	Window        time.Duration
-	PageThreshold int
-	QueueCap      int
+	Limit               rate.Limit // for IPs blocked by behavior analysis
+	FakeBotLimit        rate.Limit // for fake bots, zero blocks outright
+	AIBotLimit          rate.Limit // for each AI bot with AIBotLimit
+	AIBotBurst          int
+	AIBotPolicies       map[string]AIBotAction
+	CustomBots          []CustomBot
+	BotLimits           map[string]BotLimit // by bot name, "" for other bots
+	BotBudgets          map[string]BotBudget
+	BotBudgetFile       string
+	Robots              string
+	CrawlDelays         map[string]time.Duration // by robots.txt user-agent
+	Burst               int
+	Window              time.Duration
+	SlidingWindow       bool
+	PageThreshold       int
+	HyperLogLog         bool
+	BloomCapacity       uint
+	BloomFPRate         float64
+	RequestThreshold    int
+	ErrorThreshold      int
+	HeaderThreshold     int            // see WithHeaderThreshold
+	SequenceThreshold   int            // see WithSequenceThreshold
+	QueryThreshold      int            // see WithQueryThreshold
+	MethodWeights       map[string]int // see WithMethodWeight
+	MethodThresholds    map[string]int // see WithMethodThreshold
+	QueueCap            int
+	Workers             int
+	SyncAnalyzer        bool
+	BlockDuration       time.Duration
+	PenaltySchedule     []time.Duration
+	MaxBlocklist        int
+	PrefixThreshold     int // see WithPrefixThreshold
+	PrefixLenV4         int
+	PrefixLenV6         int
+	LimiterIdle         time.Duration
+	Store               analyzer.Store
+	Clock               analyzer.Clock
+	DryRun              bool
+	Actions             map[Reason]Action
+	AllowCIDRs          []string
+	DenyCIDRs           []string
+	DenyTLSFingerprints []string // see WithDenyTLSFingerprints
+	SkipPaths           []string
+	HoneypotPaths       []string
+	IgnoreAssets        []string
+	PathNormalizer      func(string) string
+
+	// Scoring, see analyzer.Signal
+	Signals        []analyzer.Signal
+	ScoreThreshold float64
+
+	// Greylist between clean and blocked, see WithGreylistThreshold
+	GreylistThreshold float64
+	GreylistLimit     rate.Limit
+	GreylistBurst     int
+	OnGreylist        func(key string, score float64)
+
+	// Block, unblock and drop event consumers, see WithEventFunc
+	EventFuncs []func(analyzer.Event)
+
+	// AbuseIPDB lookups and reports, see WithAbuseIPDB
+	AbuseIPDB        string
+	AbuseIPDBOptions []abuseipdb.Option
+
+	// DNS blocklist checks, see WithDNSBL
+	DNSBL        bool
+	DNSBLOptions []dnsbl.Option
+
+	// Geo policies, see WithGeoLocator
+	GeoLocator            GeoLocator
+	CountryDeny           []string
+	CountryAllow          []string
+	CountryPageThresholds map[string]int
+
+	// Page thresholds by UA class, see WithUAClassPageThreshold
+	UAClassPageThresholds map[useragent.Class]int
+
+	// Empty, truncated and overlong UAs, see WithBadUAPolicy
+	BadUAPolicy        BadUAPolicy
+	MaxUALength        int
+	BadUAPageThreshold int
+
+	// Datacenter policies, see WithASNLocator
+	ASNLocator              ASNLocator
+	DatacenterASNs          []uint32
+	DatacenterPageThreshold int
+	DenyDatacenterBrowsers  bool
+	SubnetPageThreshold     int // see WithSubnetPageThreshold
+	ASNPageThreshold        int
+	CloudRanges             bool
+	CloudRangesOptions      []cloudranges.Option
+
+	// Bot dataset managed by botrate, see WithBotData
+	BotData        bool
+	BotDataOptions []botdata.Option
+
+	// Verification cache, see WithVerifyCache
+	VerifyCacheTTL  time.Duration
+	VerifyCacheSize int
+
+	// RDNS verification, see WithRDNS
+	RDNS        bool
+	RDNSOptions []rdns.Option
+
+	// Pending verification retries, see WithPendingRetries
+	PendingRetries  int
+	PendingWindow   time.Duration
+	PendingFallback knownbots.ResultStatus
+
+	// Tor exits, see WithTor
+	Tor        bool
+	TorPolicy  TorPolicy
+	TorOptions []tor.Option
+	TorLimit   rate.Limit
+	TorBurst   int
+
+	// Message budget of each connection, see WithMessageLimit
+	MessageLimit rate.Limit
+	MessageBurst int
+
+	// Authenticated users, see Request.User
+	UserPageThreshold int
+	ExemptUsers       bool
+
+	// Virtual hosts analyzed and limited apart, see WithHostIsolation
+	IsolatedHosts []string
+
+	// Keying of normal users by IP and User-Agent, see WithUAKeying and
+	// WithUAKeysPerIP
+	UAKeying    bool
+	UAKeysPerIP int
+
+	// Session keys an IP gets, see WithSessionsPerIP
+	SessionsPerIP int
+
+	// Login brute-force detection, see Limiter.RecordLogin
+	LoginPaths     []string
+	LoginStatuses  []int
+	LoginThreshold int
+
+	// Per-path thresholds and limits, see WithPathPolicy
+	PathPolicies []PathPolicy
+
+	// Stats published with expvar, see WithExpvar
+	Expvar string
+
+	// Audit log of blocks and unblocks, see WithAuditLog and WithAuditFile
+	AuditLog     io.Writer
+	AuditFile    string
+	AuditOptions []audit.Option
+
+	// Events buffered for each subscriber, see Limiter.Subscribe
+	SubscribeBuffer int
+}
+
+// defaultLogin defaults the login statuses and threshold with login
+// paths.
+func (c *Config) defaultLogin() {
+	if len(c.LoginPaths) == 0 {
+		return
+	}
+	if c.LoginStatuses == nil {
+		c.LoginStatuses = DefaultLoginStatuses
+	}
+	if c.LoginThreshold <= 0 {
+		c.LoginThreshold = DefaultLoginThreshold
+	}
+}
+
+// defaultAction sets the action of reason to action, unless c sets one.
+// Actions is copied, it may be shared with another Config.
+func (c *Config) defaultAction(reason Reason, action Action) {
+	if _, ok := c.Actions[reason]; ok {
+		return
+	}
+	c.Actions = maps.Clone(c.Actions)
+	if c.Actions == nil {
+		c.Actions = make(map[Reason]Action)
+	}
+	c.Actions[reason] = action
+}
+
+// thresholds returns the analyzer thresholds of c. The login threshold
+// only applies with login paths.
+func (c *Config) thresholds() analyzer.Thresholds {
+	t := analyzer.Thresholds{
+		PageThreshold:     c.PageThreshold,
+		RequestThreshold:  c.RequestThreshold,
+		ErrorThreshold:    c.ErrorThreshold,
+		HeaderThreshold:   c.HeaderThreshold,
+		SequenceThreshold: c.SequenceThreshold,
+		QueryThreshold:    c.QueryThreshold,
+		ScoreThreshold:    c.ScoreThreshold,
+		GreylistThreshold: c.GreylistThreshold,
+		PrefixThreshold:   c.PrefixThreshold,
+		BlockDuration:     c.BlockDuration,
+	}
+	if len(c.LoginPaths) > 0 {
+		t.LoginThreshold = c.LoginThreshold
+	}
+	return t
+}
+
+// hash returns the hex SHA-256, shortened to 16 digits, of the settings
+// deciding what is limited and blocked, see Limiter.ConfigHash. Maps
+// print sorted, so equal settings hash the same.
+func (c *Config) hash() string {
+	signals := make([]string, len(c.Signals))
+	for i, sig := range c.Signals {
+		signals[i] = sig.Name()
+	}
+
+	h := sha256.New()
+	fmt.Fprintln(h, c.Limit, c.FakeBotLimit, c.Burst, c.Window, c.SlidingWindow, c.DryRun)
+	fmt.Fprintln(h, c.PageThreshold, c.RequestThreshold, c.ErrorThreshold, c.HeaderThreshold, c.SequenceThreshold, c.QueryThreshold, c.ScoreThreshold, signals)
+	fmt.Fprintln(h, c.MethodWeights, c.MethodThresholds)
+	fmt.Fprintln(h, c.BlockDuration, c.PenaltySchedule, c.Actions)
+	fmt.Fprintln(h, c.AllowCIDRs, c.DenyCIDRs, c.DenyTLSFingerprints, c.SkipPaths, c.HoneypotPaths)
+	fmt.Fprintln(h, c.GreylistThreshold, c.GreylistLimit, c.GreylistBurst)
+	fmt.Fprintln(h, c.AIBotLimit, c.AIBotBurst, c.AIBotPolicies, c.BotLimits, c.BotBudgets, c.CrawlDelays)
+	fmt.Fprintln(h, c.CountryDeny, c.CountryAllow, c.CountryPageThresholds, c.UAClassPageThresholds)
+	fmt.Fprintln(h, c.BadUAPolicy, c.MaxUALength, c.BadUAPageThreshold)
+	fmt.Fprintln(h, c.DatacenterASNs, c.DatacenterPageThreshold, c.DenyDatacenterBrowsers)
+	fmt.Fprintln(h, c.SubnetPageThreshold, c.ASNPageThreshold)
+	fmt.Fprintln(h, c.TorPolicy, c.TorLimit, c.TorBurst, c.MessageLimit, c.MessageBurst)
+	fmt.Fprintln(h, c.UserPageThreshold, c.ExemptUsers, c.LoginPaths, c.LoginStatuses, c.LoginThreshold)
+	fmt.Fprintln(h, c.PathPolicies, c.IsolatedHosts, c.UAKeying, c.UAKeysPerIP, c.SessionsPerIP)
+	fmt.Fprintln(h, c.PrefixThreshold, c.PrefixLenV4, c.PrefixLenV6)
+	return hex.EncodeToString(h.Sum(nil)[:8])
 }
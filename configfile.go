@@ -0,0 +1,490 @@
+package botrate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/useragent"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the configuration file read by NewFromConfigFile, in YAML
+// or JSON, e.g.:
+//
+//	limit: 1/10m
+//	window: 5m
+//	page_threshold: 50
+//	block_duration: 1h
+//	penalty_schedule: [5m, 1h, 24h]
+//	allow_cidrs: [10.0.0.0/8]
+//	skip_paths: [/healthz, /metrics]
+//	actions:
+//	  rate_limited: challenge
+//	bot_limits:
+//	  googlebot: {limit: 10/s, burst: 20}
+//	ai_bots:
+//	  policy: {AITraining: deny}
+//	tor:
+//	  policy: limit
+//
+// Durations are written like "10m", limits as events/duration like
+// "10/s", "1/10m" or "inf". Each field maps to the option named in its
+// comment, and unset fields keep the defaults. Unknown fields are errors.
+type FileConfig struct {
+	Limit             *ConfigLimit     `json:"limit"`          // WithLimit
+	FakeBotLimit      *ConfigLimit     `json:"fake_bot_limit"` // WithFakeBotLimit
+	Burst             int              `json:"burst"`          // WithBurst
+	Window            ConfigDuration   `json:"window"`         // WithAnalyzerWindow
+	SlidingWindow     bool             `json:"sliding_window"` // WithAnalyzerSlidingWindow
+	PageThreshold     int              `json:"page_threshold"` // WithAnalyzerPageThreshold
+	HyperLogLog       bool             `json:"hyperloglog"`    // WithAnalyzerHyperLogLog
+	RequestThreshold  int              `json:"request_threshold"`
+	ErrorThreshold    int              `json:"error_threshold"`
+	HeaderThreshold   int              `json:"header_threshold"`
+	SequenceThreshold int              `json:"sequence_threshold"`
+	QueryThreshold    int              `json:"query_threshold"`
+	ScoreThreshold    float64          `json:"score_threshold"`
+	QueueCap          int              `json:"queue_cap"`
+	Workers           int              `json:"workers"`
+	BlockDuration     ConfigDuration   `json:"block_duration"`
+	PenaltySchedule   []ConfigDuration `json:"penalty_schedule"`
+	MaxBlocklist      int              `json:"max_blocklist"`
+	PrefixThreshold   int              `json:"prefix_threshold"` // WithPrefixThreshold
+	PrefixLenV4       int              `json:"prefix_len_v4"`    // WithPrefixLen
+	PrefixLenV6       int              `json:"prefix_len_v6"`
+	LimiterIdle       ConfigDuration   `json:"limiter_idle"`
+	DryRun            bool             `json:"dry_run"`
+
+	// Weights and thresholds by method, e.g. POST: 5, see
+	// WithMethodWeight and WithMethodThreshold
+	MethodWeights    map[string]int `json:"method_weights"`
+	MethodThresholds map[string]int `json:"method_thresholds"`
+
+	// Actions by reason, e.g. rate_limited: challenge, see WithAction
+	Actions map[Reason]string `json:"actions"`
+
+	AllowCIDRs          []string `json:"allow_cidrs"`
+	DenyCIDRs           []string `json:"deny_cidrs"`
+	DenyTLSFingerprints []string `json:"deny_tls_fingerprints"`
+	SkipPaths           []string `json:"skip_paths"`
+	HoneypotPaths       []string `json:"honeypot_paths"`
+	IgnoreAssets        []string `json:"ignore_assets"`
+
+	Greylist *struct {
+		Threshold float64      `json:"threshold"` // WithGreylistThreshold
+		Limit     *ConfigLimit `json:"limit"`     // WithGreylistLimit
+		Burst     int          `json:"burst"`
+	} `json:"greylist"`
+
+	Login *struct {
+		Paths     []string `json:"paths"` // WithLoginPaths
+		Statuses  []int    `json:"statuses"`
+		Threshold int      `json:"threshold"`
+	} `json:"login"`
+
+	UserPageThreshold int      `json:"user_page_threshold"`
+	ExemptUsers       bool     `json:"exempt_users"`
+	IsolatedHosts     []string `json:"isolated_hosts"`
+	UAKeying          bool     `json:"ua_keying"`
+	UAKeysPerIP       int      `json:"ua_keys_per_ip"`
+	SessionsPerIP     int      `json:"sessions_per_ip"`
+
+	// Policies of path patterns, the first matching applies, see
+	// WithPathPolicy
+	PathPolicies []struct {
+		Path          string      `json:"path"`
+		PageThreshold int         `json:"page_threshold"`
+		Limit         ConfigLimit `json:"limit"`
+		Burst         int         `json:"burst"`
+	} `json:"path_policies"`
+
+	// Bot dataset directory, see WithBotData and botdata.WithRoot
+	BotData    string `json:"bot_data"`
+	CustomBots []struct {
+		Name  string   `json:"name"`
+		UA    string   `json:"ua"`
+		CIDRs []string `json:"cidrs"`
+	} `json:"custom_bots"`
+	BotLimits map[string]struct {
+		Limit ConfigLimit `json:"limit"`
+		Burst int         `json:"burst"`
+	} `json:"bot_limits"`
+	BotBudgets map[string]struct {
+		Requests int            `json:"requests"`
+		Period   ConfigDuration `json:"period"`
+	} `json:"bot_budgets"`
+	BotBudgetFile string                    `json:"bot_budget_file"`
+	Robots        string                    `json:"robots"`
+	CrawlDelays   map[string]ConfigDuration `json:"crawl_delays"`
+
+	AIBots *struct {
+		Policy map[string]string `json:"policy"` // by bot or kind, "" for all: allow, limit or deny
+		Limit  *ConfigLimit      `json:"limit"`  // WithAIBotLimit
+		Burst  int               `json:"burst"`
+	} `json:"ai_bots"`
+
+	VerifyCache *struct {
+		TTL  ConfigDuration `json:"ttl"`
+		Size int            `json:"size"`
+	} `json:"verify_cache"`
+
+	PendingRetries *struct {
+		Retries  int            `json:"retries"`
+		Window   ConfigDuration `json:"window"`
+		Fallback string         `json:"fallback"` // verified or failed
+	} `json:"pending_retries"`
+
+	CountryDeny           []string       `json:"country_deny"`
+	CountryAllow          []string       `json:"country_allow"`
+	CountryPageThresholds map[string]int `json:"country_page_thresholds"`
+
+	// Page thresholds by UA class, e.g. library: 20, see
+	// WithUAClassPageThreshold
+	UAClassPageThresholds map[string]int `json:"ua_class_page_thresholds"`
+
+	BadUA *struct {
+		Policy        string `json:"policy"`         // allow, score, challenge or block
+		MaxLength     *int   `json:"max_length"`     // WithMaxUALength
+		PageThreshold int    `json:"page_threshold"` // WithBadUAPageThreshold
+	} `json:"bad_ua"`
+
+	DatacenterASNs          []uint32 `json:"datacenter_asns"`
+	DatacenterPageThreshold int      `json:"datacenter_page_threshold"`
+	DenyDatacenterBrowsers  bool     `json:"deny_datacenter_browsers"`
+	SubnetPageThreshold     int      `json:"subnet_page_threshold"` // WithSubnetPageThreshold
+	ASNPageThreshold        int      `json:"asn_page_threshold"`
+	CloudRanges             bool     `json:"cloud_ranges"`
+
+	Tor *struct {
+		Policy string       `json:"policy"` // allow, limit, challenge or block
+		Limit  *ConfigLimit `json:"limit"`  // WithTorLimit
+		Burst  int          `json:"burst"`
+	} `json:"tor"`
+
+	MessageLimit *struct {
+		Limit ConfigLimit `json:"limit"`
+		Burst int         `json:"burst"`
+	} `json:"message_limit"`
+
+	DNSBL     bool   `json:"dnsbl"`
+	AbuseIPDB string `json:"abuseipdb"` // API key
+	AuditFile string `json:"audit_file"`
+	Expvar    string `json:"expvar"`
+}
+
+// ConfigDuration is a time.Duration written like "10m" in a FileConfig.
+type ConfigDuration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *ConfigDuration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = ConfigDuration(v)
+	return nil
+}
+
+// ConfigLimit is a rate.Limit written like "10/s" in a FileConfig, see
+// ParseLimit.
+type ConfigLimit rate.Limit
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (l *ConfigLimit) UnmarshalText(text []byte) error {
+	v, err := ParseLimit(string(text))
+	if err != nil {
+		return err
+	}
+	*l = ConfigLimit(v)
+	return nil
+}
+
+// ParseLimit parses a limit written events/duration, e.g. "10/s",
+// "1/10m" or "0.5/1h30m", or "inf" for no limit.
+func ParseLimit(s string) (rate.Limit, error) {
+	if s == "inf" {
+		return rate.Inf, nil
+	}
+	events, per, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, fmt.Errorf("botrate: invalid limit %q, want events/duration", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	n, err := strconv.ParseFloat(events, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("botrate: invalid limit %q: bad event count", s)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("botrate: invalid limit %q: bad duration", s)
+	}
+	return rate.Limit(n / d.Seconds()), nil
+}
+
+// LoadConfigFile reads the FileConfig at path: JSON if it ends in .json,
+// YAML otherwise.
+func LoadConfigFile(path string) (*FileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(filepath.Ext(path), ".json") {
+		// Through JSON, so both formats share the field names and types
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("botrate: %s: %w", path, err)
+		}
+		if v == nil {
+			v = map[string]any{}
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, fmt.Errorf("botrate: %s: %w", path, err)
+		}
+	}
+
+	var c FileConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("botrate: %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// NewFromConfigFile creates a limiter configured by the FileConfig at
+// path, so the configuration can be mounted rather than compiled in. opts
+// are applied after the file's, e.g. WithLogger or options the file can't
+// express, like WithStore.
+func NewFromConfigFile(path string, opts ...Option) (*Limiter, error) {
+	c, err := LoadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileOpts, err := c.Options()
+	if err != nil {
+		return nil, fmt.Errorf("botrate: %s: %w", path, err)
+	}
+	return New(append(fileOpts, opts...)...)
+}
+
+// Options returns the options c sets.
+func (c *FileConfig) Options() ([]Option, error) {
+	var opts []Option
+	add := func(set bool, opt Option) {
+		if set {
+			opts = append(opts, opt)
+		}
+	}
+
+	if c.Limit != nil {
+		opts = append(opts, WithLimit(rate.Limit(*c.Limit)))
+	}
+	if c.FakeBotLimit != nil {
+		opts = append(opts, WithFakeBotLimit(rate.Limit(*c.FakeBotLimit)))
+	}
+	add(c.Burst > 0, WithBurst(c.Burst))
+	add(c.Window > 0, WithAnalyzerWindow(time.Duration(c.Window)))
+	add(c.SlidingWindow, WithAnalyzerSlidingWindow(true))
+	add(c.PageThreshold > 0, WithAnalyzerPageThreshold(c.PageThreshold))
+	add(c.HyperLogLog, WithAnalyzerHyperLogLog(true))
+	add(c.RequestThreshold > 0, WithAnalyzerRequestThreshold(c.RequestThreshold))
+	add(c.ErrorThreshold > 0, WithAnalyzerErrorThreshold(c.ErrorThreshold))
+	add(c.HeaderThreshold > 0, WithHeaderThreshold(c.HeaderThreshold))
+	add(c.SequenceThreshold > 0, WithSequenceThreshold(c.SequenceThreshold))
+	add(c.QueryThreshold > 0, WithQueryThreshold(c.QueryThreshold))
+	for method, weight := range c.MethodWeights {
+		opts = append(opts, WithMethodWeight(weight, method))
+	}
+	for method, threshold := range c.MethodThresholds {
+		opts = append(opts, WithMethodThreshold(threshold, method))
+	}
+	add(c.ScoreThreshold > 0, WithScoreThreshold(c.ScoreThreshold))
+	add(c.QueueCap > 0, WithAnalyzerQueueCap(c.QueueCap))
+	add(c.Workers > 0, WithAnalyzerWorkers(c.Workers))
+	add(c.BlockDuration > 0, WithBlockDuration(time.Duration(c.BlockDuration)))
+	if len(c.PenaltySchedule) > 0 {
+		schedule := make([]time.Duration, len(c.PenaltySchedule))
+		for i, d := range c.PenaltySchedule {
+			schedule[i] = time.Duration(d)
+		}
+		opts = append(opts, WithPenaltySchedule(schedule))
+	}
+	add(c.MaxBlocklist > 0, WithMaxBlocklist(c.MaxBlocklist))
+	add(c.PrefixThreshold > 0, WithPrefixThreshold(c.PrefixThreshold))
+	add(c.PrefixLenV4 > 0 || c.PrefixLenV6 > 0, WithPrefixLen(c.PrefixLenV4, c.PrefixLenV6))
+	add(c.LimiterIdle != 0, WithLimiterIdle(time.Duration(c.LimiterIdle)))
+	add(c.DryRun, WithDryRun(true))
+	for reason, name := range c.Actions {
+		action, err := ParseAction(name)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithAction(reason, action))
+	}
+
+	add(len(c.AllowCIDRs) > 0, WithAllowCIDRs(c.AllowCIDRs))
+	add(len(c.DenyCIDRs) > 0, WithDenyCIDRs(c.DenyCIDRs))
+	add(len(c.DenyTLSFingerprints) > 0, WithDenyTLSFingerprints(c.DenyTLSFingerprints...))
+	add(len(c.SkipPaths) > 0, WithSkipPaths(c.SkipPaths...))
+	add(len(c.HoneypotPaths) > 0, WithHoneypotPaths(c.HoneypotPaths...))
+	add(len(c.IgnoreAssets) > 0, WithIgnoreAssets(c.IgnoreAssets...))
+
+	if g := c.Greylist; g != nil {
+		add(g.Threshold > 0, WithGreylistThreshold(g.Threshold))
+		if g.Limit != nil {
+			opts = append(opts, WithGreylistLimit(rate.Limit(*g.Limit), g.Burst))
+		}
+	}
+	if l := c.Login; l != nil {
+		add(len(l.Paths) > 0, WithLoginPaths(l.Paths...))
+		add(len(l.Statuses) > 0, WithLoginStatuses(l.Statuses...))
+		add(l.Threshold > 0, WithLoginThreshold(l.Threshold))
+	}
+	add(c.UserPageThreshold > 0, WithUserPageThreshold(c.UserPageThreshold))
+	add(c.ExemptUsers, WithExemptUsers(true))
+	add(len(c.IsolatedHosts) > 0, WithHostIsolation(c.IsolatedHosts...))
+	add(c.UAKeying, WithUAKeying(true))
+	add(c.UAKeysPerIP != 0, WithUAKeysPerIP(c.UAKeysPerIP))
+	add(c.SessionsPerIP != 0, WithSessionsPerIP(c.SessionsPerIP))
+	for _, p := range c.PathPolicies {
+		opts = append(opts, WithPathPolicy(p.Path, Policy{PageThreshold: p.PageThreshold, Limit: rate.Limit(p.Limit), Burst: p.Burst}))
+	}
+
+	add(c.BotData != "", WithBotData(botdata.WithRoot(c.BotData)))
+	for _, b := range c.CustomBots {
+		opts = append(opts, WithCustomBot(b.Name, b.UA, b.CIDRs))
+	}
+	for bot, l := range c.BotLimits {
+		opts = append(opts, WithBotLimit(bot, rate.Limit(l.Limit), l.Burst))
+	}
+	for bot, b := range c.BotBudgets {
+		opts = append(opts, WithBotBudget(bot, b.Requests, time.Duration(b.Period)))
+	}
+	add(c.BotBudgetFile != "", WithBotBudgetFile(c.BotBudgetFile))
+	add(c.Robots != "", WithRobots(c.Robots))
+	for agent, d := range c.CrawlDelays {
+		opts = append(opts, WithCrawlDelay(agent, time.Duration(d)))
+	}
+
+	if ai := c.AIBots; ai != nil {
+		for bot, name := range ai.Policy {
+			action, err := parseAIBotAction(name)
+			if err != nil {
+				return nil, err
+			}
+			if bot == "" {
+				opts = append(opts, WithAIBotPolicy(action))
+			} else {
+				opts = append(opts, WithAIBotPolicy(action, bot))
+			}
+		}
+		if ai.Limit != nil {
+			opts = append(opts, WithAIBotLimit(rate.Limit(*ai.Limit), ai.Burst))
+		}
+	}
+
+	if v := c.VerifyCache; v != nil {
+		opts = append(opts, WithVerifyCache(time.Duration(v.TTL), v.Size))
+	}
+	if p := c.PendingRetries; p != nil {
+		var fallback knownbots.ResultStatus
+		switch p.Fallback {
+		case "verified":
+			fallback = knownbots.StatusVerified
+		case "failed":
+			fallback = knownbots.StatusFailed
+		default:
+			return nil, fmt.Errorf("botrate: invalid pending fallback %q, want verified or failed", p.Fallback)
+		}
+		opts = append(opts, WithPendingRetries(p.Retries, time.Duration(p.Window), fallback))
+	}
+
+	add(len(c.CountryDeny) > 0, WithCountryDeny(c.CountryDeny...))
+	add(len(c.CountryAllow) > 0, WithCountryAllow(c.CountryAllow...))
+	for country, threshold := range c.CountryPageThresholds {
+		opts = append(opts, WithCountryPageThreshold(threshold, country))
+	}
+	for class, threshold := range c.UAClassPageThresholds {
+		if !slices.Contains(useragent.Classes, useragent.Class(class)) {
+			return nil, fmt.Errorf("botrate: unknown UA class %q", class)
+		}
+		opts = append(opts, WithUAClassPageThreshold(threshold, useragent.Class(class)))
+	}
+
+	if b := c.BadUA; b != nil {
+		policy, err := parseBadUAPolicy(b.Policy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithBadUAPolicy(policy))
+		if b.MaxLength != nil {
+			opts = append(opts, WithMaxUALength(*b.MaxLength))
+		}
+		add(b.PageThreshold > 0, WithBadUAPageThreshold(b.PageThreshold))
+	}
+
+	add(len(c.DatacenterASNs) > 0, WithDatacenterASNs(c.DatacenterASNs...))
+	add(c.DatacenterPageThreshold > 0, WithDatacenterPageThreshold(c.DatacenterPageThreshold))
+	add(c.DenyDatacenterBrowsers, WithDenyDatacenterBrowsers(true))
+	add(c.SubnetPageThreshold > 0 || c.ASNPageThreshold > 0, WithSubnetPageThreshold(c.SubnetPageThreshold, c.ASNPageThreshold))
+	add(c.CloudRanges, WithCloudRanges())
+
+	if t := c.Tor; t != nil {
+		policy, err := parseTorPolicy(t.Policy)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTor(policy))
+		if t.Limit != nil {
+			opts = append(opts, WithTorLimit(rate.Limit(*t.Limit), t.Burst))
+		}
+	}
+	if m := c.MessageLimit; m != nil {
+		opts = append(opts, WithMessageLimit(rate.Limit(m.Limit), m.Burst))
+	}
+
+	add(c.DNSBL, WithDNSBL())
+	add(c.AbuseIPDB != "", WithAbuseIPDB(c.AbuseIPDB))
+	add(c.AuditFile != "", WithAuditFile(c.AuditFile))
+	add(c.Expvar != "", WithExpvar(c.Expvar))
+	return opts, nil
+}
+
+func parseAIBotAction(s string) (AIBotAction, error) {
+	for _, a := range []AIBotAction{AIBotAllow, AIBotLimit, AIBotDeny} {
+		if s == a.String() {
+			return a, nil
+		}
+	}
+	return 0, fmt.Errorf("botrate: unknown AI bot action %q", s)
+}
+
+func parseTorPolicy(s string) (TorPolicy, error) {
+	for _, p := range []TorPolicy{TorAllow, TorLimit, TorChallenge, TorBlock} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("botrate: unknown Tor policy %q", s)
+}
+
+func parseBadUAPolicy(s string) (BadUAPolicy, error) {
+	for _, p := range []BadUAPolicy{BadUAAllow, BadUAScore, BadUAChallenge, BadUABlock} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("botrate: unknown bad UA policy %q", s)
+}
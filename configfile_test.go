@@ -0,0 +1,178 @@
+package botrate
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/botrate/useragent"
+	"golang.org/x/time/rate"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewFromConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "botrate.yaml", `
+limit: 1/10m
+fake_bot_limit: 1/h
+burst: 2
+window: 10m
+page_threshold: 40
+request_threshold: 500
+sequence_threshold: 30
+query_threshold: 100
+method_weights: {post: 5}
+method_thresholds: {POST: 20}
+block_duration: 2h
+penalty_schedule: [5m, 1h]
+prefix_threshold: 10
+prefix_len_v6: 56
+subnet_page_threshold: 200
+ua_class_page_thresholds: {library: 20}
+actions:
+  rate_limited: challenge
+allow_cidrs: [10.0.0.0/8]
+skip_paths: [/healthz]
+greylist:
+  threshold: 0.5
+  limit: 2/s
+  burst: 5
+login:
+  paths: [/login]
+path_policies:
+  - {path: /api/*, page_threshold: 20, limit: 5/s, burst: 10}
+bot_limits:
+  googlebot: {limit: 10/s, burst: 20}
+ai_bots:
+  policy: {AITraining: deny, "": limit}
+tor:
+  policy: limit
+  limit: inf
+bad_ua: {policy: challenge, max_length: 256}
+`)
+
+	l, err := NewFromConfigFile(path, WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("NewFromConfigFile() returned error: %v", err)
+	}
+	defer l.Close()
+
+	cfg := l.Config()
+	if cfg.Limit != rate.Every(10*time.Minute) || cfg.FakeBotLimit != rate.Every(time.Hour) || cfg.Burst != 2 {
+		t.Errorf("unexpected limits %v %v %d", cfg.Limit, cfg.FakeBotLimit, cfg.Burst)
+	}
+	if cfg.Window != 10*time.Minute || cfg.PageThreshold != 40 || cfg.RequestThreshold != 500 || cfg.SequenceThreshold != 30 || cfg.QueryThreshold != 100 {
+		t.Errorf("unexpected analysis %v %d %d %d %d", cfg.Window, cfg.PageThreshold, cfg.RequestThreshold, cfg.SequenceThreshold, cfg.QueryThreshold)
+	}
+	if cfg.BlockDuration != 2*time.Hour || !slices.Equal(cfg.PenaltySchedule, []time.Duration{5 * time.Minute, time.Hour}) {
+		t.Errorf("unexpected blocks %v %v", cfg.BlockDuration, cfg.PenaltySchedule)
+	}
+	if cfg.PrefixThreshold != 10 || cfg.PrefixLenV4 != 0 || cfg.PrefixLenV6 != 56 {
+		t.Errorf("unexpected prefix blocks %d /%d /%d", cfg.PrefixThreshold, cfg.PrefixLenV4, cfg.PrefixLenV6)
+	}
+	if cfg.MethodWeights["POST"] != 5 || cfg.MethodThresholds["POST"] != 20 {
+		t.Errorf("unexpected method weights %v and thresholds %v", cfg.MethodWeights, cfg.MethodThresholds)
+	}
+	if cfg.UAClassPageThresholds[useragent.Library] != 20 {
+		t.Errorf("unexpected UA class thresholds %v", cfg.UAClassPageThresholds)
+	}
+	if cfg.SubnetPageThreshold != 200 || cfg.ASNPageThreshold != 0 {
+		t.Errorf("unexpected subnet thresholds %d %d", cfg.SubnetPageThreshold, cfg.ASNPageThreshold)
+	}
+	if l.Action(ReasonRateLimited) != ActionChallenge {
+		t.Errorf("expected challenge for rate limited, got %v", l.Action(ReasonRateLimited))
+	}
+	if !slices.Equal(cfg.AllowCIDRs, []string{"10.0.0.0/8"}) || !slices.Equal(cfg.SkipPaths, []string{"/healthz"}) {
+		t.Errorf("unexpected lists %v %v", cfg.AllowCIDRs, cfg.SkipPaths)
+	}
+	if cfg.GreylistThreshold != 0.5 || cfg.GreylistLimit != 2 || cfg.GreylistBurst != 5 {
+		t.Errorf("unexpected greylist %v %v %d", cfg.GreylistThreshold, cfg.GreylistLimit, cfg.GreylistBurst)
+	}
+	if !slices.Equal(cfg.LoginPaths, []string{"/login"}) || cfg.LoginThreshold != DefaultLoginThreshold {
+		t.Errorf("unexpected login %v %d", cfg.LoginPaths, cfg.LoginThreshold)
+	}
+	if want := []PathPolicy{{"/api/*", Policy{PageThreshold: 20, Limit: 5, Burst: 10}}}; !slices.Equal(cfg.PathPolicies, want) {
+		t.Errorf("unexpected path policies %+v", cfg.PathPolicies)
+	}
+	if b := cfg.BotLimits["googlebot"]; b.Limit != 10 || b.Burst != 20 {
+		t.Errorf("unexpected bot limit %+v", b)
+	}
+	if cfg.AIBotPolicies["AITraining"] != AIBotDeny || cfg.AIBotPolicies[""] != AIBotLimit {
+		t.Errorf("unexpected AI bot policies %v", cfg.AIBotPolicies)
+	}
+	if !cfg.Tor || cfg.TorPolicy != TorLimit || cfg.TorLimit != rate.Inf {
+		t.Errorf("unexpected Tor policy %v %v", cfg.TorPolicy, cfg.TorLimit)
+	}
+	if cfg.BadUAPolicy != BadUAChallenge || cfg.MaxUALength != 256 || cfg.BadUAPageThreshold != DefaultBadUAPageThreshold {
+		t.Errorf("unexpected bad UA policy %v %d %d", cfg.BadUAPolicy, cfg.MaxUALength, cfg.BadUAPageThreshold)
+	}
+}
+
+func TestNewFromConfigFile_JSON(t *testing.T) {
+	path := writeConfigFile(t, "botrate.json", `{"limit": "5/m", "page_threshold": 30, "dry_run": true}`)
+
+	l, err := NewFromConfigFile(path, WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("NewFromConfigFile() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if cfg := l.Config(); cfg.Limit != rate.Every(12*time.Second) || cfg.PageThreshold != 30 || !cfg.DryRun {
+		t.Errorf("unexpected config %v %d %v", cfg.Limit, cfg.PageThreshold, cfg.DryRun)
+	}
+}
+
+func TestNewFromConfigFile_Invalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"unknown field":  "page_treshold: 10",
+		"bad duration":   "window: soon",
+		"bad limit":      "limit: 10",
+		"bad action":     "actions: {rate_limited: ignore}",
+		"bad tor policy": "tor: {policy: maybe}",
+		"bad UA class":   "ua_class_page_thresholds: {robot: 10}",
+		"bad UA policy":  "bad_ua: {policy: maybe}",
+		"bad yaml":       "limit: [",
+	} {
+		path := writeConfigFile(t, "botrate.yaml", content)
+		if _, err := NewFromConfigFile(path, WithKnownbots(testbots.New(t))); err == nil {
+			t.Errorf("%s: expected an error", name)
+		} else if !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: error should name the file, got %v", name, err)
+		}
+	}
+
+	if _, err := NewFromConfigFile(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing file: expected an error")
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	for in, want := range map[string]rate.Limit{
+		"10/s":      10,
+		"1/10m":     rate.Every(10 * time.Minute),
+		"0.5/1h30m": rate.Limit(0.5 / 5400),
+		"0/s":       0,
+		"inf":       rate.Inf,
+	} {
+		got, err := ParseLimit(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "10", "x/s", "-1/s", "1/x", "1/0s"} {
+		if _, err := ParseLimit(in); err == nil {
+			t.Errorf("ParseLimit(%q): expected an error", in)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package botrate
+
+import "golang.org/x/time/rate"
+
+// Conn charges the messages of a long-lived connection, e.g. a WebSocket,
+// to the client that opened it, so bots can't bypass HTTP limits by
+// switching to a socket. It is safe for concurrent use.
+type Conn struct {
+	l      *Limiter
+	req    Request
+	budget *rate.Limiter // nil without WithMessageLimit
+}
+
+// Conn returns a Conn for the connection opened by req, usually the
+// upgrade request once Check allowed it. See botratehttp.ConnFrom.
+func (l *Limiter) Conn(req Request) *Conn {
+	c := &Conn{l: l, req: req}
+	if cfg := l.config(); cfg.MessageLimit > 0 {
+		c.budget = rate.NewLimiter(cfg.MessageLimit, cfg.MessageBurst)
+	}
+	return c
+}
+
+// Message checks a message costing n tokens, 1 if n <= 0. The message is
+// checked like a request to the connection's path, so it is analyzed
+// and limited with the client's other requests, then against the
+// connection's budget, see WithMessageLimit. Close the connection, or
+// drop the message, when it is denied.
+func (c *Conn) Message(n int) (d Decision) {
+	if n <= 0 {
+		n = 1
+	}
+	req := c.req
+	req.Cost = n
+
+	d = c.l.Check(req)
+	if !d.Allowed || c.budget == nil {
+		return d
+	}
+
+	now := c.l.cfg.Clock.Now()
+	r := c.budget.ReserveN(now, n)
+	delay := r.DelayFrom(now)
+	if r.OK() && delay == 0 {
+		return d
+	}
+	r.CancelAt(now)
+	if !r.OK() {
+		delay = rate.InfDuration
+	}
+
+	c.l.counters.deny(ReasonMessageLimited)
+	if c.l.config().DryRun {
+		c.l.logger.Info("botrate: dry run, message would be denied", "ua", req.UA, "ip", req.IP, "reason", ReasonMessageLimited)
+		return d
+	}
+	d.Allowed, d.Reason, d.RetryAfter = false, ReasonMessageLimited, delay
+	d.Action = c.l.Action(ReasonMessageLimited)
+	return d
+}
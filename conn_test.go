@@ -0,0 +1,75 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestConn_MessageLimit(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	l, err := New(
+		WithKnownbots(testbots.NewAI(t)),
+		WithMessageLimit(1, 2),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	c := l.Conn(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/ws"})
+	for i := 0; i < 2; i++ {
+		if d := c.Message(1); !d.Allowed {
+			t.Fatalf("message %d should be allowed, got %+v", i, d)
+		}
+	}
+	d := c.Message(1)
+	if d.Allowed || d.Reason != ReasonMessageLimited || d.RetryAfter != time.Second {
+		t.Errorf("message over the budget should be limited, got %+v", d)
+	}
+	if d := c.Message(3); d.Allowed || d.RetryAfter != rate.InfDuration {
+		t.Errorf("message over the burst should never be allowed, got %+v", d)
+	}
+
+	// Budgets are per connection
+	if d := l.Conn(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/ws"}).Message(1); !d.Allowed {
+		t.Errorf("another connection should have its own budget, got %+v", d)
+	}
+
+	clock.Advance(time.Second)
+	if d := c.Message(1); !d.Allowed {
+		t.Errorf("message should be allowed once the budget refills, got %+v", d)
+	}
+	if s := l.Stats(); s.Denied[ReasonMessageLimited] != 2 {
+		t.Errorf("expected 2 limited messages, got %d", s.Denied[ReasonMessageLimited])
+	}
+}
+
+func TestConn_Analyzed(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.NewAI(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerRequestThreshold(3),
+		WithLimit(rate.Every(time.Hour)),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Messages count as requests of the client, without a message limit
+	c := l.Conn(Request{UA: "Mozilla/5.0", IP: "192.168.1.2", Path: "/ws"})
+	for i := 0; i < 5; i++ {
+		c.Message(1)
+	}
+	if d := l.Decide("Mozilla/5.0", "192.168.1.2", "/"); d.Allowed {
+		t.Errorf("client flooding messages should be blocked over HTTP too, got %+v", d)
+	}
+	if d := c.Message(1); d.Allowed || d.Reason != ReasonRateLimited {
+		t.Errorf("messages of a blocked client should be limited, got %+v", d)
+	}
+}
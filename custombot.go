@@ -0,0 +1,81 @@
+package botrate
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/cnlangzi/knownbots"
+)
+
+// KindCustom is the knownbots kind reported for bots registered with
+// WithCustomBot.
+const KindCustom knownbots.BotKind = "Custom"
+
+// CustomBot is a bot registered with WithCustomBot.
+type CustomBot struct {
+	Name  string
+	UA    string
+	CIDRs []string
+}
+
+// customBot is a CustomBot, parsed.
+type customBot struct {
+	name     string
+	ua       string
+	prefixes *prefixSet
+}
+
+func newCustomBots(bots []CustomBot) ([]customBot, error) {
+	parsed := make([]customBot, 0, len(bots))
+	for _, b := range bots {
+		if b.Name == "" || b.UA == "" {
+			return nil, errors.New("botrate: custom bots need a name and a UA")
+		}
+		prefixes, err := newPrefixSet(b.CIDRs)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, customBot{name: b.Name, ua: b.UA, prefixes: prefixes})
+	}
+	return parsed, nil
+}
+
+// validateCustom verifies ua and addr against the custom bots, like
+// knownbots, reporting false if ua is none of theirs.
+func validateCustom(bots []customBot, ua string, addr netip.Addr) (knownbots.Result, bool) {
+	for i := range bots {
+		b := &bots[i]
+		if !containsWord(ua, b.ua) {
+			continue
+		}
+		res := knownbots.Result{BotName: b.name, BotKind: KindCustom, IsBot: true, Status: knownbots.StatusFailed}
+		if b.prefixes.Contains(addr) {
+			res.Status = knownbots.StatusVerified
+		}
+		return res, true
+	}
+	return knownbots.Result{}, false
+}
+
+// containsWord reports whether word is in text, not within a longer
+// word, the way knownbots matches UAs.
+func containsWord(text, word string) bool {
+	for i := 0; i < len(text); {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			return false
+		}
+		pos := i + j
+		end := pos + len(word)
+		if (pos == 0 || !alphaNumeric(text[pos-1])) && (end == len(text) || !alphaNumeric(text[end])) {
+			return true
+		}
+		i = pos + 1
+	}
+	return false
+}
+
+func alphaNumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
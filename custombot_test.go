@@ -0,0 +1,62 @@
+package botrate
+
+import (
+	"testing"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+)
+
+func TestContainsWord(t *testing.T) {
+	tests := []struct {
+		text, word string
+		want       bool
+	}{
+		{"PartnerBot/1.0", "PartnerBot", true},
+		{"Mozilla/5.0 (compatible; PartnerBot/1.0)", "PartnerBot", true},
+		{"MyPartnerBot/1.0", "PartnerBot", false},
+		{"PartnerBotX PartnerBot", "PartnerBot", true},
+		{"Mozilla/5.0", "PartnerBot", false},
+	}
+	for _, tt := range tests {
+		if got := containsWord(tt.text, tt.word); got != tt.want {
+			t.Errorf("containsWord(%q, %q) = %v, want %v", tt.text, tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter_WithCustomBot(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithCustomBot("partner", "PartnerBot", []string{"198.51.100.0/24"}),
+		WithCustomBot("monitor", "TestBot", []string{"203.0.113.10"}),
+		WithAIBotPolicy(AIBotDeny, "monitor"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	d := l.Decide("PartnerBot/1.0", "198.51.100.7", "/")
+	if !d.Allowed || d.Bot != "partner" || d.BotKind != KindCustom || d.Verification != knownbots.StatusVerified {
+		t.Errorf("custom bots from their CIDRs should be verified, got %+v", d)
+	}
+	if d := l.Decide("PartnerBot/1.0", "192.0.2.1", "/"); d.Allowed || d.Reason != ReasonFakeBot {
+		t.Errorf("custom bots from elsewhere should be fake bots, got %+v", d)
+	}
+
+	// Custom bots are matched before knownbots, and policies apply by name
+	if d := l.Decide("TestBot/1.0", "192.168.100.1", "/"); d.Reason != ReasonFakeBot || d.Bot != "monitor" {
+		t.Errorf("custom bots should take precedence, got %+v", d)
+	}
+	if d := l.Decide("TestBot/1.0", "203.0.113.10", "/"); d.Reason != ReasonAIBot {
+		t.Errorf("bot policies should apply to custom bots by name, got %+v", d)
+	}
+
+	if _, err := New(WithCustomBot("partner", "PartnerBot", []string{"invalid"})); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if _, err := New(WithCustomBot("partner", "", nil)); err == nil {
+		t.Error("expected an error for a custom bot without a UA")
+	}
+}
@@ -0,0 +1,92 @@
+package botrate
+
+import (
+	"errors"
+	"net/netip"
+	"strings"
+
+	"github.com/cnlangzi/botrate/cloudranges"
+)
+
+// ASNLocator returns the autonomous system of an IP, 0 and "" if
+// unknown. It must be safe for concurrent use. A geoip.DB implements it
+// with a MaxMind ASN database.
+type ASNLocator interface {
+	ASN(addr netip.Addr) (asn uint32, org string)
+}
+
+// DatacenterASNs are autonomous systems of hosting and cloud providers,
+// whose IPs browsers rarely come from. Some, like Microsoft's, also
+// carry office traffic.
+var DatacenterASNs = []uint32{
+	16509, 14618, // Amazon AWS
+	396982,       // Google Cloud
+	8075,         // Microsoft Azure
+	31898,        // Oracle Cloud
+	45102,        // Alibaba Cloud
+	132203,       // Tencent Cloud
+	14061,        // DigitalOcean
+	16276,        // OVH
+	24940,        // Hetzner
+	63949,        // Akamai Linode
+	20473,        // Vultr
+	51167,        // Contabo
+	12876,        // Scaleway
+	60781, 28753, // Leaseweb
+	9009,   // M247
+	212238, // Datacamp
+	47583,  // Hostinger
+}
+
+// datacenterPolicy applies the datacenter policies to normal users, see
+// WithASNLocator and WithCloudRanges.
+type datacenterPolicy struct {
+	locator      ASNLocator // nil with cloud ranges only
+	cloud        *cloudranges.Ranges
+	asns         map[uint32]bool
+	threshold    int
+	denyBrowsers bool
+}
+
+func newDatacenterPolicy(locator ASNLocator, cloud *cloudranges.Ranges, cfg *Config) (*datacenterPolicy, error) {
+	if locator == nil && cloud == nil {
+		if cfg.DatacenterPageThreshold > 0 || cfg.DenyDatacenterBrowsers {
+			return nil, errors.New("botrate: datacenter policies need WithASNLocator or WithCloudRanges")
+		}
+		return nil, nil
+	}
+
+	asns := cfg.DatacenterASNs
+	if asns == nil {
+		asns = DatacenterASNs
+	}
+	p := &datacenterPolicy{
+		locator:      locator,
+		cloud:        cloud,
+		asns:         make(map[uint32]bool, len(asns)),
+		threshold:    cfg.DatacenterPageThreshold,
+		denyBrowsers: cfg.DenyDatacenterBrowsers,
+	}
+	for _, asn := range asns {
+		p.asns[asn] = true
+	}
+	return p, nil
+}
+
+// lookup returns the autonomous system and cloud provider of addr, and
+// whether either is a datacenter.
+func (p *datacenterPolicy) lookup(addr netip.Addr) (asn uint32, cloud string, datacenter bool) {
+	if p.locator != nil {
+		asn, _ = p.locator.ASN(addr)
+	}
+	if p.cloud != nil {
+		cloud = p.cloud.Lookup(addr)
+	}
+	return asn, cloud, p.asns[asn] || cloud != ""
+}
+
+// browser reports whether ua claims to be a browser. Known bots are
+// verified before, so what's left are browsers and impersonators.
+func browser(ua string) bool {
+	return strings.HasPrefix(ua, "Mozilla/")
+}
@@ -0,0 +1,170 @@
+package botrate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/cloudranges"
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+// fakeASNLocator finds autonomous systems from a map.
+type fakeASNLocator map[string]uint32
+
+func (f fakeASNLocator) ASN(addr netip.Addr) (uint32, string) {
+	return f[addr.String()], ""
+}
+
+var testASNLocator = fakeASNLocator{
+	"203.0.113.1": 24940, // Hetzner
+	"203.0.113.2": 3320,  // Deutsche Telekom
+}
+
+func TestLimiter_WithDenyDatacenterBrowsers(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithASNLocator(testASNLocator),
+		WithDenyDatacenterBrowsers(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	d := l.Decide("Mozilla/5.0", "203.0.113.1", "/")
+	if d.Allowed || d.Reason != ReasonDatacenter || d.ASN != 24940 || !d.Datacenter {
+		t.Errorf("browsers from datacenters should be denied, got %+v", d)
+	}
+	if d := l.Decide("Feedfetcher/2.1", "203.0.113.1", "/"); !d.Allowed || !d.Datacenter {
+		t.Errorf("other clients from datacenters should be allowed, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "203.0.113.2", "/"); !d.Allowed || d.ASN != 3320 || d.Datacenter {
+		t.Errorf("browsers from other networks should be allowed, got %+v", d)
+	}
+	l.Allow("Mozilla/5.0", "203.0.113.1", "/")
+	if s := l.Stats(); s.Denied[ReasonDatacenter] != 2 {
+		t.Errorf("expected 2 datacenter denials, got %d", s.Denied[ReasonDatacenter])
+	}
+}
+
+func TestLimiter_WithDatacenterPageThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithASNLocator(testASNLocator),
+		WithGeoLocator(fakeLocator{"203.0.113.1": "DE", "203.0.113.2": "DE"}),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(100),
+		WithCountryPageThreshold(5, "DE"),
+		WithDatacenterPageThreshold(3),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		for _, path := range []string{"/a", "/b", "/c"} {
+			l.Allow("Feedfetcher/2.1", ip, path)
+		}
+	}
+	if d := l.Decide("Feedfetcher/2.1", "203.0.113.1", "/d"); !d.Blocklisted {
+		t.Errorf("the lower datacenter threshold should block at 3 pages, got %+v", d)
+	}
+	if d := l.Decide("Feedfetcher/2.1", "203.0.113.2", "/d"); d.Blocklisted {
+		t.Errorf("other networks should keep the country threshold, got %+v", d)
+	}
+}
+
+func TestLimiter_WithDatacenterASNs(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithASNLocator(testASNLocator),
+		WithDatacenterASNs(3320),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("Mozilla/5.0", "203.0.113.1", "/"); d.Datacenter {
+		t.Errorf("ASNs not listed should not be datacenters, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "203.0.113.2", "/"); !d.Datacenter {
+		t.Errorf("listed ASNs should be datacenters, got %+v", d)
+	}
+
+	if _, err := New(WithDenyDatacenterBrowsers(true)); err == nil {
+		t.Error("expected an error for datacenter policies without an ASN database")
+	}
+}
+
+func TestLimiter_WithCloudRanges(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"prefixes":[{"ip_prefix":"198.51.100.0/24"}]}`))
+	}))
+	defer srv.Close()
+
+	aws := cloudranges.AWS
+	aws.URL = srv.URL
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithCloudRanges(cloudranges.WithProviders(aws)),
+		WithDenyDatacenterBrowsers(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for l.cloud.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("cloud ranges not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	d := l.Decide("Mozilla/5.0", "198.51.100.7", "/")
+	if d.Allowed || d.Reason != ReasonDatacenter || d.Cloud != "aws" || !d.Datacenter {
+		t.Errorf("browsers from cloud ranges should be denied, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "203.0.113.1", "/"); !d.Allowed || d.Cloud != "" || d.Datacenter {
+		t.Errorf("browsers from other networks should be allowed, got %+v", d)
+	}
+}
+
+func TestLimiter_WithSubnetPageThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithASNLocator(fakeASNLocator{"198.51.100.1": 24940, "192.0.2.1": 24940}),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(100),
+		WithSubnetPageThreshold(0, 4),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// 2 prefixes of the same autonomous system, 2 pages each
+	for _, ip := range []string{"198.51.100.1", "192.0.2.1"} {
+		for _, path := range []string{"/a", "/b"} {
+			l.Allow("Feedfetcher/2.1", ip, path)
+		}
+	}
+	if d := l.Decide("Feedfetcher/2.1", "192.0.2.1", "/c"); !d.Blocklisted {
+		t.Errorf("the autonomous system's pages should add up, got %+v", d)
+	}
+	if d := l.Decide("Feedfetcher/2.1", "198.51.100.1", "/c"); d.Blocklisted {
+		t.Errorf("other IPs should be blocked on their next page only, got %+v", d)
+	}
+
+	if _, err := New(WithSubnetPageThreshold(0, 4)); err == nil {
+		t.Error("expected an error for an ASN page threshold without an ASN database")
+	}
+}
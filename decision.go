@@ -0,0 +1,181 @@
+package botrate
+
+import (
+	"time"
+
+	"github.com/cnlangzi/botrate/useragent"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+)
+
+// Decision is the detailed outcome of Decide, for middleware and
+// loggers that need more than Allow's verdict.
+type Decision struct {
+	// Allowed reports whether the request should proceed.
+	Allowed bool
+
+	// Reason is why the request was denied, or would have been in dry run.
+	Reason Reason
+
+	// Bot is the name of the bot the UA claims to be, "" for normal users.
+	Bot string
+
+	// BotKind is the knownbots kind of the bot, e.g. AITraining.
+	BotKind knownbots.BotKind
+
+	// Verification is the bot verification status, zero for normal users.
+	Verification knownbots.ResultStatus
+
+	// Country is the normal user's country with WithGeoLocator, "" if
+	// unknown.
+	Country string
+
+	// ASN is the normal user's autonomous system with WithASNLocator, 0 if
+	// unknown, Cloud its cloud provider with WithCloudRanges, and
+	// Datacenter whether either is a datacenter.
+	ASN        uint32
+	Cloud      string
+	Datacenter bool
+
+	// Tor reports whether the normal user comes from a Tor exit, with
+	// WithTor.
+	Tor bool
+
+	// UAClass is the class of the normal user's UA with
+	// WithUAClassPageThreshold, useragent.Unknown otherwise.
+	UAClass useragent.Class
+
+	// BadUA is the flaw of the normal user's UA with WithBadUAPolicy,
+	// useragent.NoFlaw otherwise.
+	BadUA useragent.Flaw
+
+	// Key is what the request was analyzed and limited by: the IP, or
+	// the key from WithKeyFunc. Empty for bots and requests decided
+	// before analysis.
+	Key string
+
+	// Blocklisted reports whether the key is on the blocklist. Bots are
+	// not checked against it.
+	Blocklisted bool
+
+	// Greylisted reports whether the key is greylisted, see
+	// WithGreylistThreshold.
+	Greylisted bool
+
+	// Throttled reports whether the request was checked against the IP's
+	// token bucket. Burst, Remaining and Reset are only meaningful when it is.
+	Throttled bool
+
+	// Burst is the size of the IP's bucket.
+	Burst int
+
+	// Remaining is the number of tokens left in the IP's bucket.
+	Remaining int
+
+	// Reset is how long until the IP's bucket is full again.
+	// rate.InfDuration means never.
+	Reset time.Duration
+
+	// RetryAfter is how long until the request would be allowed.
+	// rate.InfDuration means never, zero that it is allowed.
+	RetryAfter time.Duration
+
+	// Action is what to do with the request when it is denied, see
+	// WithAction.
+	Action Action
+}
+
+// Decide is like Allow but returns the detailed Decision.
+func (l *Limiter) Decide(ua, ip, path string) Decision {
+	return l.DecideN(ua, ip, path, 1)
+}
+
+// DecideN is like Decide but the request consumes n tokens when the IP is
+// throttled.
+func (l *Limiter) DecideN(ua, ip, path string, n int) Decision {
+	return l.Check(Request{UA: ua, IP: ip, Path: path, Cost: n})
+}
+
+// Check is like Decide but takes a Request, for callers with more than
+// the UA, IP and path at hand, e.g. middleware.
+func (l *Limiter) Check(req Request) Decision {
+	d, _ := l.decide(req, false)
+	return d
+}
+
+// decide is the path of every check: it counts req, runs the layers and
+// fills in d, then applies dry run. A throttled request is denied when
+// its tokens are not available now. With hold, they stay reserved in r
+// for the caller to wait for or cancel, as Wait and Reserve do; otherwise
+// they are given back. r is only set with hold for a throttled request
+// that dry run doesn't let through.
+func (l *Limiter) decide(req Request, hold bool) (d Decision, r *rate.Reservation) {
+	ip, addr := canonicalIP(req.IP)
+	req.IP = ip
+	ua := req.UA
+
+	n := req.Cost
+	if n <= 0 {
+		n = l.Cost(req.Path, req.Method)
+	}
+
+	l.counters.requests.Add(1)
+	defer func() {
+		if !d.Allowed {
+			l.counters.deny(d.Reason)
+			if l.config().DryRun {
+				l.logger.Info("botrate: dry run, request would be denied", "ua", ua, "ip", ip, "reason", d.Reason)
+				if r != nil {
+					r.CancelAt(l.cfg.Clock.Now())
+					r = nil
+				}
+				d.Allowed, d.RetryAfter = true, 0
+			} else {
+				d.Action = l.Action(d.Reason)
+			}
+		}
+	}()
+
+	bucket := l.check(&req, addr, &d)
+	if bucket == nil {
+		if !d.Allowed && d.RetryAfter == 0 {
+			d.RetryAfter = rate.InfDuration
+		}
+		return d, nil
+	}
+
+	d.Throttled = true
+	now := l.cfg.Clock.Now()
+	r = bucket.ReserveN(now, n)
+	switch delay := r.DelayFrom(now); {
+	case !r.OK():
+		d.RetryAfter = rate.InfDuration
+	case delay > 0:
+		if !hold {
+			// Denied now, so give the tokens back
+			r.CancelAt(now)
+		}
+		d.RetryAfter = delay
+	default:
+		d.Allowed, d.Reason = true, ""
+	}
+
+	d.Burst = bucket.Burst()
+	tokens := bucket.TokensAt(now)
+	if tokens > 0 {
+		d.Remaining = int(tokens)
+	}
+	if missing := float64(d.Burst) - tokens; missing > 0 {
+		switch limit := bucket.Limit(); {
+		case limit == rate.Inf:
+		case limit <= 0:
+			d.Reset = rate.InfDuration
+		default:
+			d.Reset = time.Duration(missing / float64(limit) * float64(time.Second))
+		}
+	}
+	if !hold {
+		r = nil
+	}
+	return d, r
+}
@@ -0,0 +1,146 @@
+package botrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Decide(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	d := l.Decide("Mozilla/5.0", "192.168.1.2", "/")
+	if !d.Allowed || d.Reason != "" || d.Bot != "" || d.Throttled || d.RetryAfter != 0 {
+		t.Errorf("unexpected decision for normal user: %+v", d)
+	}
+
+	d = l.Decide("TestBot/1.0", "192.168.100.1", "/")
+	if !d.Allowed || d.Bot != "testbot" || d.Verification != knownbots.StatusVerified {
+		t.Errorf("unexpected decision for verified bot: %+v", d)
+	}
+
+	d = l.Decide("TestBot/1.0", "10.0.0.1", "/")
+	if d.Allowed || d.Reason != ReasonFakeBot || d.Bot != "testbot" || d.Verification != knownbots.StatusFailed {
+		t.Errorf("unexpected decision for fake bot: %+v", d)
+	}
+	if d.RetryAfter != rate.InfDuration {
+		t.Errorf("fake bot should never be allowed, got retry after %v", d.RetryAfter)
+	}
+
+	l.Block("192.168.1.1", time.Hour)
+
+	d = l.Decide("Mozilla/5.0", "192.168.1.1", "/")
+	if !d.Allowed || !d.Blocklisted || !d.Throttled || d.Remaining != 1 {
+		t.Errorf("unexpected decision within the burst: %+v", d)
+	}
+
+	l.Decide("Mozilla/5.0", "192.168.1.1", "/")
+	d = l.Decide("Mozilla/5.0", "192.168.1.1", "/")
+	if d.Allowed || d.Reason != ReasonRateLimited || d.Remaining != 0 {
+		t.Errorf("unexpected decision beyond the burst: %+v", d)
+	}
+	if d.RetryAfter < 59*time.Minute || d.RetryAfter > time.Hour {
+		t.Errorf("expected retry after about an hour, got %v", d.RetryAfter)
+	}
+	if d.Burst != 2 || d.Reset < 119*time.Minute || d.Reset > 2*time.Hour {
+		t.Errorf("expected a full refill in about two hours, got burst %d reset %v", d.Burst, d.Reset)
+	}
+
+	// Denied decisions do not consume tokens
+	d = l.Decide("Mozilla/5.0", "192.168.1.1", "/")
+	if d.RetryAfter > time.Hour {
+		t.Errorf("denied decisions should not add to the delay, got %v", d.RetryAfter)
+	}
+
+	if s := l.Stats(); s.Denied[ReasonRateLimited] != 2 || s.Denied[ReasonFakeBot] != 1 {
+		t.Errorf("unexpected denials %v", s.Denied)
+	}
+}
+
+func TestLimiter_DecideN(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	d := l.DecideN("Mozilla/5.0", "192.168.1.1", "/", 3)
+	if d.Allowed || d.RetryAfter != rate.InfDuration {
+		t.Errorf("cost beyond the burst should never be allowed: %+v", d)
+	}
+
+	d = l.DecideN("Mozilla/5.0", "192.168.1.1", "/", 2)
+	if !d.Allowed || d.Remaining != 0 {
+		t.Errorf("cost within the burst should be allowed: %+v", d)
+	}
+}
+
+func TestLimiter_Decide_DryRun(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithDryRun(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	d := l.Decide("TestBot/1.0", "10.0.0.1", "/")
+	if !d.Allowed || d.RetryAfter != 0 {
+		t.Errorf("dry run should allow, got %+v", d)
+	}
+	if d.Reason != ReasonFakeBot {
+		t.Errorf("dry run should keep the would-be reason, got %q", d.Reason)
+	}
+}
+
+func TestLimiter_DryRun_EntryPoints(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(1),
+		WithDryRun(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua, ip = "Mozilla/5.0", "192.168.1.1"
+	l.Block(ip, time.Hour)
+	l.Allow(ua, ip, "/") // burst
+
+	// Every entry point lets the would-be denial through and counts it
+	if allowed, reason := l.Allow(ua, ip, "/"); !allowed || reason != "" {
+		t.Errorf("Allow: expected allowed, got %s", reason)
+	}
+	if d := l.Decide(ua, ip, "/"); !d.Allowed || d.Reason != ReasonRateLimited {
+		t.Errorf("Decide: expected allowed with the would-be reason, got %+v", d)
+	}
+	if err, reason := l.Wait(context.Background(), ua, ip, "/"); err != nil {
+		t.Errorf("Wait: expected nil, got %v %s", err, reason)
+	}
+	if r := l.Reserve(ua, ip, "/"); !r.OK() || r.Delay() != 0 || r.Reason() != "" {
+		t.Errorf("Reserve: expected to proceed now, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+
+	if s := l.Stats(); s.Requests != 5 || s.Denied[ReasonRateLimited] != 4 {
+		t.Errorf("expected 4 would-be denials of 5 requests, got %+v", s)
+	}
+}
@@ -0,0 +1,369 @@
+// Package dnsbl checks the IPs botrate analyzes against DNS blocklists,
+// such as Spamhaus ZEN, as a scoring signal.
+//
+// A Checker is an analyzer.Signal scoring an IP by the zones listing it.
+// Lookups never block the analyzer: the first requests of an IP are
+// scored without them while the zones are queried in the background,
+// with a strict timeout, and results are cached.
+//
+// See botrate.WithDNSBL.
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultTimeout   = 500 * time.Millisecond
+	DefaultCacheTTL  = time.Hour
+	DefaultCacheSize = 100000
+	DefaultWorkers   = 4
+	DefaultQueueCap  = 1000
+
+	// DefaultZones is Spamhaus ZEN, minus the PBL listing the dynamic
+	// ranges of ordinary visitors. Spamhaus does not answer queries
+	// through public resolvers.
+	DefaultZones = []Zone{{
+		Name:  "zen.spamhaus.org",
+		Score: 1,
+		Codes: []string{"127.0.0.2", "127.0.0.3", "127.0.0.4", "127.0.0.5", "127.0.0.6", "127.0.0.7", "127.0.0.9"},
+	}}
+)
+
+// Zone is a DNS blocklist.
+type Zone struct {
+	// Name is the zone queried, e.g. zen.spamhaus.org.
+	Name string
+
+	// Score is added to an IP's score when the zone lists it. A score of
+	// 1 blocks the IP on its own.
+	Score float64
+
+	// Codes are the return codes counting as listed, all of 127.0.0.0/8
+	// if empty. Answers outside 127.0.0.0/8 are errors, e.g. a refused
+	// query, and never count.
+	Codes []string
+}
+
+// Resolver looks up hosts. *net.Resolver implements it.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Option is a functional option for configuring Checker.
+type Option func(*Checker)
+
+// WithZones sets the zones queried, DefaultZones by default.
+func WithZones(zones ...Zone) Option {
+	return func(c *Checker) {
+		c.zones = zones
+	}
+}
+
+// WithResolver sets the resolver, net.DefaultResolver by default. Use a
+// local caching resolver, DNSBLs throttle busy public ones.
+func WithResolver(r Resolver) Option {
+	return func(c *Checker) {
+		c.resolver = r
+	}
+}
+
+// WithTimeout sets the timeout for checking an IP against all zones.
+// Zones that don't answer in time count as not listing it.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Checker) {
+		c.timeout = d
+	}
+}
+
+// WithCache sets how long results are cached and the most IPs cached.
+func WithCache(ttl time.Duration, size int) Option {
+	return func(c *Checker) {
+		c.cacheTTL = ttl
+		c.cacheSize = size
+	}
+}
+
+// WithWorkers sets how many IPs are checked concurrently.
+func WithWorkers(n int) Option {
+	return func(c *Checker) {
+		c.workers = n
+	}
+}
+
+// WithQueueCap sets how many IPs can wait to be checked. IPs are dropped
+// when the queue is full and checked on a later request.
+func WithQueueCap(cap int) Option {
+	return func(c *Checker) {
+		c.queueCap = cap
+	}
+}
+
+// WithLogger sets the logger for failed lookups.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Checker) {
+		c.logger = logger
+	}
+}
+
+// entry is a cached score.
+type entry struct {
+	score   float64
+	expires time.Time
+}
+
+// Checker checks IPs against DNS blocklists. It implements
+// analyzer.Signal.
+type Checker struct {
+	zones     []Zone
+	resolver  Resolver
+	timeout   time.Duration
+	cacheTTL  time.Duration
+	cacheSize int
+	workers   int
+	queueCap  int
+	logger    *slog.Logger
+
+	mu      sync.Mutex
+	cache   map[string]entry
+	pending map[string]bool
+
+	queue  chan string
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a checker.
+func New(opts ...Option) *Checker {
+	c := &Checker{
+		zones:     DefaultZones,
+		resolver:  net.DefaultResolver,
+		timeout:   DefaultTimeout,
+		cacheTTL:  DefaultCacheTTL,
+		cacheSize: DefaultCacheSize,
+		workers:   DefaultWorkers,
+		queueCap:  DefaultQueueCap,
+		cache:     make(map[string]entry),
+		pending:   make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.logger == nil {
+		c.logger = logging.Discard()
+	}
+	if c.workers < 1 {
+		c.workers = 1
+	}
+
+	c.queue = make(chan string, c.queueCap)
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	for i := 0; i < c.workers; i++ {
+		c.wg.Add(1)
+		go c.work(ctx)
+	}
+	return c
+}
+
+func (c *Checker) Name() string {
+	return "dnsbl"
+}
+
+// Observe returns the cached score of o.IP. Without one, it queues a
+// check and returns 0.
+func (c *Checker) Observe(o analyzer.Observation) float64 {
+	if _, ok := reverse(o.IP); !ok {
+		return 0
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	e, cached := c.cache[o.IP]
+	if cached && now.Before(e.expires) {
+		c.mu.Unlock()
+		return e.score
+	}
+	if c.pending[o.IP] {
+		c.mu.Unlock()
+		return 0
+	}
+	c.pending[o.IP] = true
+	c.mu.Unlock()
+
+	select {
+	case c.queue <- o.IP:
+	default:
+		c.mu.Lock()
+		delete(c.pending, o.IP)
+		c.mu.Unlock()
+	}
+	return 0
+}
+
+// Forget drops the cached score of ip, e.g. after a manual unblock.
+func (c *Checker) Forget(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cache, ip)
+}
+
+// Reset keeps the cache, listings outlive windows.
+func (c *Checker) Reset() {}
+
+// Close stops the background checks. IPs still queued are dropped.
+func (c *Checker) Close() error {
+	c.cancel()
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Checker) work(ctx context.Context) {
+	defer c.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ip := <-c.queue:
+			c.check(ctx, ip)
+		}
+	}
+}
+
+func (c *Checker) check(ctx context.Context, ip string) {
+	score := c.Check(ctx, ip)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, ip)
+
+	now := time.Now()
+	if len(c.cache) >= c.cacheSize {
+		c.evict(now)
+	}
+	c.cache[ip] = entry{score: score, expires: now.Add(c.cacheTTL)}
+}
+
+// evict makes room in the cache, dropping the expired scores, or an
+// arbitrary one if none expired. It is called under mu.
+func (c *Checker) evict(now time.Time) {
+	for ip, e := range c.cache {
+		if !now.Before(e.expires) {
+			delete(c.cache, ip)
+		}
+	}
+	for ip := range c.cache {
+		if len(c.cache) < c.cacheSize {
+			return
+		}
+		delete(c.cache, ip)
+	}
+}
+
+// Check queries the zones concurrently and returns the summed score of
+// those listing ip.
+func (c *Checker) Check(ctx context.Context, ip string) float64 {
+	rev, ok := reverse(ip)
+	if !ok {
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	scores := make(chan float64, len(c.zones))
+	for _, z := range c.zones {
+		go func(z Zone) {
+			addrs, err := c.resolver.LookupHost(ctx, rev+"."+z.Name)
+			if err != nil {
+				// Not listed answers NXDOMAIN
+				var dnsErr *net.DNSError
+				if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+					c.logger.Debug("botrate: dnsbl lookup failed", "zone", z.Name, "ip", ip, "error", err)
+				}
+				scores <- 0
+				return
+			}
+			if z.listed(addrs) {
+				scores <- z.Score
+				return
+			}
+			scores <- 0
+		}(z)
+	}
+
+	var score float64
+	for range c.zones {
+		score += <-scores
+	}
+	return score
+}
+
+// listed reports whether addrs, the answer of the zone, lists the IP.
+func (z Zone) listed(addrs []string) bool {
+	for _, a := range addrs {
+		if !strings.HasPrefix(a, "127.") {
+			continue
+		}
+		if len(z.Codes) == 0 {
+			return true
+		}
+		for _, code := range z.Codes {
+			if a == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// reverse returns the DNSBL query name of ip without the zone, e.g.
+// 4.3.2.1 for 1.2.3.4, reporting false for IPs not worth checking.
+func reverse(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return "", false
+	}
+
+	var b strings.Builder
+	if addr.Is4() {
+		a := addr.As4()
+		for i := len(a) - 1; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(a[i])))
+			if i > 0 {
+				b.WriteByte('.')
+			}
+		}
+		return b.String(), true
+	}
+
+	const hex = "0123456789abcdef"
+	a := addr.As16()
+	for i := len(a) - 1; i >= 0; i-- {
+		b.WriteByte(hex[a[i]&0xf])
+		b.WriteByte('.')
+		b.WriteByte(hex[a[i]>>4])
+		if i > 0 {
+			b.WriteByte('.')
+		}
+	}
+	return b.String(), true
+}
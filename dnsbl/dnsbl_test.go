@@ -0,0 +1,144 @@
+package dnsbl
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+var _ analyzer.Signal = (*Checker)(nil)
+
+// fakeResolver answers from a map of hosts, NXDOMAIN otherwise.
+type fakeResolver struct {
+	hosts   map[string][]string
+	delay   time.Duration
+	lookups atomic.Int32
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.lookups.Add(1)
+	if r.delay > 0 {
+		select {
+		case <-time.After(r.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+		ok   bool
+	}{
+		{"203.0.113.7", "7.113.0.203", true},
+		{"::ffff:203.0.113.7", "7.113.0.203", true},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2", true},
+		{"192.168.1.1", "", false},
+		{"127.0.0.1", "", false},
+		{"invalid", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := reverse(tt.ip)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("reverse(%q) = %q, %v, want %q, %v", tt.ip, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	r := &fakeResolver{hosts: map[string][]string{
+		"1.113.0.203.zen.example":   {"127.0.0.2"},
+		"2.113.0.203.zen.example":   {"127.0.0.10"}, // code not counted
+		"1.113.0.203.other.example": {"127.0.0.2"},
+		"3.113.0.203.other.example": {"203.0.113.250"}, // not a listing
+	}}
+	c := New(WithResolver(r), WithZones(
+		Zone{Name: "zen.example", Score: 1, Codes: []string{"127.0.0.2"}},
+		Zone{Name: "other.example", Score: 0.5},
+	))
+	defer c.Close()
+
+	tests := []struct {
+		ip   string
+		want float64
+	}{
+		{"203.0.113.1", 1.5},
+		{"203.0.113.2", 0},
+		{"203.0.113.3", 0},
+		{"203.0.113.4", 0},
+	}
+	for _, tt := range tests {
+		if got := c.Check(context.Background(), tt.ip); got != tt.want {
+			t.Errorf("Check(%q) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestChecker_Check_Timeout(t *testing.T) {
+	r := &fakeResolver{
+		hosts: map[string][]string{"1.113.0.203.zen.example": {"127.0.0.2"}},
+		delay: time.Second,
+	}
+	c := New(WithResolver(r), WithTimeout(20*time.Millisecond), WithZones(Zone{Name: "zen.example", Score: 1}))
+	defer c.Close()
+
+	start := time.Now()
+	if got := c.Check(context.Background(), "203.0.113.1"); got != 0 {
+		t.Errorf("zones not answering in time should not count, got %v", got)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Check should give up after the timeout, took %v", d)
+	}
+}
+
+func TestChecker_Observe(t *testing.T) {
+	r := &fakeResolver{hosts: map[string][]string{"1.113.0.203.zen.example": {"127.0.0.2"}}}
+	c := New(WithResolver(r), WithZones(Zone{Name: "zen.example", Score: 1}))
+	defer c.Close()
+
+	o := analyzer.Observation{IP: "203.0.113.1"}
+	if s := c.Observe(o); s != 0 {
+		t.Errorf("the first observation should not wait for the lookup, got %v", s)
+	}
+	waitFor(t, func() bool { return c.Observe(o) == 1 }, "listed IP should score once checked")
+
+	for i := 0; i < 10; i++ {
+		c.Observe(o)
+	}
+	if n := r.lookups.Load(); n != 1 {
+		t.Errorf("cached results should not be looked up again, got %d lookups", n)
+	}
+
+	c.Observe(analyzer.Observation{IP: "192.168.1.1"})
+	time.Sleep(20 * time.Millisecond)
+	if n := r.lookups.Load(); n != 1 {
+		t.Errorf("private IPs should not be looked up, got %d lookups", n)
+	}
+
+	c.Forget("203.0.113.1")
+	if s := c.Observe(o); s != 0 {
+		t.Errorf("forgotten IP should be checked again, got %v", s)
+	}
+}
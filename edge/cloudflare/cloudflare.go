@@ -0,0 +1,211 @@
+// Package cloudflare blocks IPs at the Cloudflare edge through an IP
+// list, see edge.Pusher.
+//
+// Create a custom IP list in the account and a WAF custom rule blocking
+// requests whose source IP is in it, then give New an API token with the
+// Account Filter Lists Edit permission. Cloudflare lists don't expire
+// items, the pusher removes them when the blocks expire.
+package cloudflare
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Cloudflare API.
+const DefaultBaseURL = "https://api.cloudflare.com/client/v4"
+
+// Option is a functional option for configuring List.
+type Option func(*List)
+
+// WithBaseURL sets the API base URL, e.g. for a proxy or tests.
+func WithBaseURL(u string) Option {
+	return func(l *List) {
+		l.baseURL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithClient sets the HTTP client calling the API.
+func WithClient(c *http.Client) Option {
+	return func(l *List) {
+		l.client = c
+	}
+}
+
+// List is a Cloudflare IP list. It implements edge.Edge.
+type List struct {
+	token     string
+	accountID string
+	listID    string
+	baseURL   string
+	client    *http.Client
+}
+
+// New returns the IP list listID of the account accountID, called with
+// the API token.
+func New(token, accountID, listID string, opts ...Option) *List {
+	l := &List{
+		token:     token,
+		accountID: accountID,
+		listID:    listID,
+		baseURL:   DefaultBaseURL,
+		client:    http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// item is an entry of a list.
+type item struct {
+	ID      string `json:"id,omitempty"`
+	IP      string `json:"ip,omitempty"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// response is the envelope of every API response.
+type response struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// Block adds ip to the list unless it is already there. Cloudflare lists
+// take IPv6 addresses as /64 prefixes, so the /64 of an IPv6 is blocked.
+func (l *List) Block(ctx context.Context, ip string, until time.Time) error {
+	target, err := listIP(ip)
+	if err != nil {
+		return err
+	}
+	items, err := l.search(ctx, target)
+	if err != nil {
+		return err
+	}
+	if len(items) > 0 {
+		return nil
+	}
+
+	comment := "botrate"
+	if !until.IsZero() {
+		comment += " until " + until.UTC().Format(time.RFC3339)
+	}
+	return l.do(ctx, http.MethodPost, l.itemsURL(), []item{{IP: target, Comment: comment}}, nil)
+}
+
+// Unblock removes ip from the list.
+func (l *List) Unblock(ctx context.Context, ip string) error {
+	target, err := listIP(ip)
+	if err != nil {
+		return err
+	}
+	items, err := l.search(ctx, target)
+	if err != nil || len(items) == 0 {
+		return err
+	}
+
+	var body struct {
+		Items []item `json:"items"`
+	}
+	for _, it := range items {
+		body.Items = append(body.Items, item{ID: it.ID})
+	}
+	return l.do(ctx, http.MethodDelete, l.itemsURL(), body, nil)
+}
+
+// search returns the items of the list for target.
+func (l *List) search(ctx context.Context, target string) ([]item, error) {
+	var items []item
+	if err := l.do(ctx, http.MethodGet, l.itemsURL()+"?search="+url.QueryEscape(target), nil, &items); err != nil {
+		return nil, err
+	}
+
+	// The search also matches substrings
+	found := items[:0]
+	for _, it := range items {
+		if it.IP == target {
+			found = append(found, it)
+		}
+	}
+	return found, nil
+}
+
+func (l *List) itemsURL() string {
+	return fmt.Sprintf("%s/accounts/%s/rules/lists/%s/items", l.baseURL, url.PathEscape(l.accountID), url.PathEscape(l.listID))
+}
+
+// do calls the API, decoding the result into result unless nil.
+func (l *List) do(ctx context.Context, method, u string, body, result any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+l.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res response
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("cloudflare: %s: %w", resp.Status, err)
+	}
+	if !res.Success {
+		var errs []error
+		for _, e := range res.Errors {
+			errs = append(errs, fmt.Errorf("cloudflare: %d: %s", e.Code, e.Message))
+		}
+		if len(errs) == 0 {
+			errs = append(errs, fmt.Errorf("cloudflare: %s", resp.Status))
+		}
+		return errors.Join(errs...)
+	}
+	if result != nil {
+		return json.Unmarshal(res.Result, result)
+	}
+	return nil
+}
+
+// listIP returns ip as Cloudflare lists take it.
+func listIP(ip string) (string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", err
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String(), nil
+	}
+	prefix, err := addr.Prefix(64)
+	if err != nil {
+		return "", err
+	}
+	return prefix.String(), nil
+}
@@ -0,0 +1,137 @@
+package cloudflare
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/edge"
+)
+
+var _ edge.Edge = (*List)(nil)
+
+// fakeAPI serves the list items API of one list.
+type fakeAPI struct {
+	mu     sync.Mutex
+	items  map[string]item // id -> item
+	nextID int
+	posts  int
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"success":false,"errors":[{"code":10000,"message":"Authentication error"}]}`)
+		return
+	}
+	if r.URL.Path != "/accounts/acc/rules/lists/list/items" {
+		http.NotFound(w, r)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result any
+	switch r.Method {
+	case http.MethodGet:
+		found := []item{}
+		for _, it := range f.items {
+			if strings.Contains(it.IP, r.URL.Query().Get("search")) {
+				found = append(found, it)
+			}
+		}
+		result = found
+	case http.MethodPost:
+		var items []item
+		json.NewDecoder(r.Body).Decode(&items)
+		for _, it := range items {
+			f.nextID++
+			it.ID = fmt.Sprint(f.nextID)
+			f.items[it.ID] = it
+		}
+		f.posts++
+		result = map[string]string{"operation_id": "op"}
+	case http.MethodDelete:
+		var body struct {
+			Items []item `json:"items"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		for _, it := range body.Items {
+			delete(f.items, it.ID)
+		}
+		result = map[string]string{"operation_id": "op"}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"success": true, "errors": []any{}, "result": result})
+}
+
+func (f *fakeAPI) ips() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ips []string
+	for _, it := range f.items {
+		ips = append(ips, it.IP)
+	}
+	return ips
+}
+
+func TestList(t *testing.T) {
+	api := &fakeAPI{items: make(map[string]item)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	l := New("token", "acc", "list", WithBaseURL(srv.URL))
+	ctx := context.Background()
+
+	if err := l.Block(ctx, "1.1.1.1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if err := l.Block(ctx, "11.1.1.1", time.Time{}); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	// Blocking again is a no-op
+	if err := l.Block(ctx, "1.1.1.1", time.Time{}); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if api.posts != 2 {
+		t.Errorf("expected 2 items added, got %d", api.posts)
+	}
+
+	if err := l.Block(ctx, "2001:db8::1", time.Time{}); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	// Unblocking 1.1.1.1 leaves 11.1.1.1, which the search also matches
+	if err := l.Unblock(ctx, "1.1.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	if err := l.Unblock(ctx, "2001:db8::2"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+	if ips := api.ips(); len(ips) != 1 || ips[0] != "11.1.1.1" {
+		t.Errorf("expected only 11.1.1.1 left, got %v", ips)
+	}
+}
+
+func TestList_Error(t *testing.T) {
+	api := &fakeAPI{items: make(map[string]item)}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+
+	l := New("wrong", "acc", "list", WithBaseURL(srv.URL))
+	err := l.Block(context.Background(), "1.1.1.1", time.Time{})
+	if err == nil || !strings.Contains(err.Error(), "Authentication error") {
+		t.Errorf("expected the API error, got %v", err)
+	}
+
+	if err := l.Block(context.Background(), "not an ip", time.Time{}); err == nil {
+		t.Error("expected an error for an invalid IP")
+	}
+}
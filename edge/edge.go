@@ -0,0 +1,274 @@
+// Package edge pushes long-lived botrate blocks to an edge network or
+// WAF, so repeated offenders are stopped before they reach the origin.
+//
+// Short blocks stay local, edge APIs are slow and rate limited. A Pusher
+// forwards blocks of at least a minimum duration to an Edge, such as the
+// cloudflare subpackage or a Webhook, and removes them again when they
+// are unblocked or expire:
+//
+//	list := cloudflare.New(token, accountID, listID)
+//	pusher := edge.NewPusher(list, edge.WithMinDuration(24*time.Hour))
+//	defer pusher.Close()
+//	limiter, err := botrate.New(botrate.WithEventFunc(pusher.Handle))
+package edge
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultMinDuration = time.Hour
+	DefaultTimeout     = 10 * time.Second
+	DefaultRetries     = 3
+	DefaultInterval    = time.Minute
+	DefaultQueueCap    = 1000
+)
+
+// Edge blocks IPs at the edge. Block must be idempotent, the same IP may
+// be pushed again after a restart.
+type Edge interface {
+	// Block blocks ip until until, zero if never. Edges without expiry
+	// may ignore until, the Pusher unblocks the IP when it expires.
+	Block(ctx context.Context, ip string, until time.Time) error
+	Unblock(ctx context.Context, ip string) error
+}
+
+// Option is a functional option for configuring Pusher.
+type Option func(*Pusher)
+
+// WithMinDuration sets the shortest block pushed to the edge. Blocks
+// that never expire are always pushed.
+func WithMinDuration(d time.Duration) Option {
+	return func(p *Pusher) {
+		p.minDuration = d
+	}
+}
+
+// WithTimeout sets the timeout for a call to the edge.
+func WithTimeout(d time.Duration) Option {
+	return func(p *Pusher) {
+		p.timeout = d
+	}
+}
+
+// WithRetries sets how many times a failed call to the edge is retried,
+// with exponential backoff, before it is dropped.
+func WithRetries(retries int) Option {
+	return func(p *Pusher) {
+		p.retries = retries
+	}
+}
+
+// WithInterval sets how often expired blocks are removed from the edge.
+func WithInterval(d time.Duration) Option {
+	return func(p *Pusher) {
+		p.interval = d
+	}
+}
+
+// WithQueueCap sets how many changes can wait to be pushed. Changes are
+// dropped when the queue is full.
+func WithQueueCap(cap int) Option {
+	return func(p *Pusher) {
+		p.queueCap = cap
+	}
+}
+
+// WithLogger sets the logger for failed pushes.
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *Pusher) {
+		p.logger = logger
+	}
+}
+
+// change is a block, or an unblock, to push.
+type change struct {
+	ip      string
+	until   time.Time
+	unblock bool
+}
+
+// Pusher forwards long-lived blocks to an Edge in the background.
+type Pusher struct {
+	edge        Edge
+	minDuration time.Duration
+	timeout     time.Duration
+	retries     int
+	interval    time.Duration
+	queueCap    int
+	logger      *slog.Logger
+
+	queue   chan change
+	dropped atomic.Uint64
+
+	// IPs blocked at the edge -> expiry, only used by run
+	pushed map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewPusher returns a pusher to edge.
+func NewPusher(edge Edge, opts ...Option) *Pusher {
+	p := &Pusher{
+		edge:        edge,
+		minDuration: DefaultMinDuration,
+		timeout:     DefaultTimeout,
+		retries:     DefaultRetries,
+		interval:    DefaultInterval,
+		queueCap:    DefaultQueueCap,
+		pushed:      make(map[string]time.Time),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.logger == nil {
+		p.logger = logging.Discard()
+	}
+
+	p.queue = make(chan change, p.queueCap)
+	go p.run()
+	return p
+}
+
+// Handle queues long-lived blocks, and unblocks, to be pushed, see
+// botrate.WithEventFunc.
+func (p *Pusher) Handle(e analyzer.Event) {
+	switch e.Type {
+	case analyzer.EventBlock:
+		if e.Duration > 0 && e.Duration < p.minDuration {
+			return
+		}
+		var until time.Time
+		if e.Duration > 0 {
+			until = e.Time.Add(e.Duration)
+		}
+		p.push(change{ip: e.IP, until: until})
+	case analyzer.EventUnblock:
+		p.push(change{ip: e.IP, unblock: true})
+	}
+}
+
+// Load pushes the long-lived blocks made before the Pusher was created,
+// e.g. those of botrate.Limiter.Blocklist after a restart with a
+// persistent store, so they are removed from the edge when they expire.
+func (p *Pusher) Load(entries []analyzer.Entry) {
+	now := time.Now()
+	for _, e := range entries {
+		if e.Until.IsZero() || e.Until.Sub(now) >= p.minDuration {
+			p.push(change{ip: e.IP, until: e.Until})
+		}
+	}
+}
+
+// Dropped returns the number of changes not pushed, because the queue
+// was full or the edge failed every retry.
+func (p *Pusher) Dropped() uint64 {
+	return p.dropped.Load()
+}
+
+// Close stops pushing. Changes still queued are dropped, and blocks
+// already pushed stay at the edge.
+func (p *Pusher) Close() error {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	<-p.done
+	return nil
+}
+
+func (p *Pusher) push(c change) {
+	select {
+	case p.queue <- c:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *Pusher) run() {
+	defer close(p.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-p.stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case c := <-p.queue:
+			p.apply(ctx, c)
+		case now := <-ticker.C:
+			for ip, until := range p.pushed {
+				if !until.IsZero() && !now.Before(until) {
+					p.apply(ctx, change{ip: ip, unblock: true})
+				}
+			}
+		}
+	}
+}
+
+func (p *Pusher) apply(ctx context.Context, c change) {
+	if c.unblock {
+		if _, exists := p.pushed[c.ip]; !exists {
+			return
+		}
+		if p.call(ctx, c, func(ctx context.Context) error { return p.edge.Unblock(ctx, c.ip) }) {
+			delete(p.pushed, c.ip)
+		}
+		return
+	}
+
+	// The edge already blocks the IP long enough
+	if until, exists := p.pushed[c.ip]; exists && (until.IsZero() || !c.until.IsZero() && !c.until.After(until)) {
+		return
+	}
+	if p.call(ctx, c, func(ctx context.Context) error { return p.edge.Block(ctx, c.ip, c.until) }) {
+		p.pushed[c.ip] = c.until
+	}
+}
+
+// call calls fn, retrying with exponential backoff, and reports whether
+// it succeeded.
+func (p *Pusher) call(ctx context.Context, c change, fn func(ctx context.Context) error) bool {
+	backoff := 100 * time.Millisecond
+	var err error
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		err = fn(callCtx)
+		cancel()
+		if err == nil {
+			return true
+		}
+		if attempt == p.retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return false
+		}
+	}
+	p.dropped.Add(1)
+	p.logger.Warn("botrate: failed to push to the edge", "ip", c.ip, "unblock", c.unblock, "error", err)
+	return false
+}
@@ -0,0 +1,130 @@
+package edge
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// fakeEdge records the IPs it blocks.
+type fakeEdge struct {
+	mu      sync.Mutex
+	blocked map[string]time.Time
+	calls   int
+	fails   int
+}
+
+func newFakeEdge() *fakeEdge {
+	return &fakeEdge{blocked: make(map[string]time.Time)}
+}
+
+func (f *fakeEdge) Block(ctx context.Context, ip string, until time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("unavailable")
+	}
+	f.blocked[ip] = until
+	return nil
+}
+
+func (f *fakeEdge) Unblock(ctx context.Context, ip string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	delete(f.blocked, ip)
+	return nil
+}
+
+func (f *fakeEdge) has(ip string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, exists := f.blocked[ip]
+	return exists
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPusher(t *testing.T) {
+	e := newFakeEdge()
+	p := NewPusher(e, WithMinDuration(time.Hour))
+	defer p.Close()
+
+	now := time.Now()
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "1.1.1.1", Duration: time.Minute})
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "2.2.2.2", Duration: 24 * time.Hour})
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "3.3.3.3"})
+	waitFor(t, func() bool { return e.has("2.2.2.2") && e.has("3.3.3.3") }, "long-lived blocks should be pushed")
+	if e.has("1.1.1.1") {
+		t.Error("short blocks should stay local")
+	}
+
+	p.Handle(analyzer.Event{Type: analyzer.EventUnblock, Time: now, IP: "3.3.3.3"})
+	waitFor(t, func() bool { return !e.has("3.3.3.3") }, "unblocks should be pushed")
+}
+
+func TestPusher_Expire(t *testing.T) {
+	e := newFakeEdge()
+	p := NewPusher(e, WithMinDuration(0), WithInterval(10*time.Millisecond))
+	defer p.Close()
+
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "1.1.1.1", Duration: 50 * time.Millisecond})
+	waitFor(t, func() bool { return e.has("1.1.1.1") }, "block should be pushed")
+	waitFor(t, func() bool { return !e.has("1.1.1.1") }, "expired block should be removed from the edge")
+}
+
+func TestPusher_Load(t *testing.T) {
+	e := newFakeEdge()
+	p := NewPusher(e, WithMinDuration(time.Hour))
+	defer p.Close()
+
+	p.Load([]analyzer.Entry{
+		{IP: "1.1.1.1", Until: time.Now().Add(time.Minute)},
+		{IP: "2.2.2.2", Until: time.Now().Add(2 * time.Hour)},
+	})
+	// A repeated block within the pushed one is not pushed again
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "2.2.2.2", Duration: time.Hour})
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "3.3.3.3", Duration: time.Hour})
+	waitFor(t, func() bool { return e.has("3.3.3.3") }, "block should be pushed")
+
+	if !e.has("2.2.2.2") || e.has("1.1.1.1") {
+		t.Errorf("expected only the long-lived entry loaded, got %v", e.blocked)
+	}
+	if e.calls != 2 {
+		t.Errorf("expected 2 calls to the edge, got %d", e.calls)
+	}
+}
+
+func TestPusher_Retry(t *testing.T) {
+	e := newFakeEdge()
+	e.fails = 2
+	p := NewPusher(e, WithMinDuration(0))
+	defer p.Close()
+
+	p.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "1.1.1.1"})
+	waitFor(t, func() bool { return e.has("1.1.1.1") }, "block should be pushed after retries")
+
+	e.mu.Lock()
+	e.fails = 10
+	e.mu.Unlock()
+	p2 := NewPusher(e, WithMinDuration(0), WithRetries(1))
+	p2.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "2.2.2.2"})
+	waitFor(t, func() bool { return p2.Dropped() == 1 }, "block should be dropped after the retries")
+	p2.Close()
+}
@@ -0,0 +1,82 @@
+package edge
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cnlangzi/botrate/webhook"
+)
+
+// Webhook is an Edge posting each change as JSON to a URL, for edge WAFs
+// without a dedicated integration:
+//
+//	{"action": "block", "ip": "203.0.113.7", "until": 1767225600000}
+//
+// The action is "block" or "unblock", and until is in Unix milliseconds,
+// 0 if never. With a secret, the webhook.SignatureHeader header carries
+// the hex HMAC-SHA256 of the body, see webhook.Sign.
+type Webhook struct {
+	url    string
+	secret []byte
+	client *http.Client
+}
+
+// NewWebhook returns an edge posting to url, signed with secret unless
+// nil. A nil client uses http.DefaultClient.
+func NewWebhook(url string, secret []byte, client *http.Client) *Webhook {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &Webhook{url: url, secret: secret, client: client}
+}
+
+// WebhookMessage is the body of a post.
+type WebhookMessage struct {
+	Action string `json:"action"`
+	IP     string `json:"ip"`
+	Until  int64  `json:"until,omitempty"`
+}
+
+func (w *Webhook) Block(ctx context.Context, ip string, until time.Time) error {
+	msg := WebhookMessage{Action: "block", IP: ip}
+	if !until.IsZero() {
+		msg.Until = until.UnixMilli()
+	}
+	return w.post(ctx, msg)
+}
+
+func (w *Webhook) Unblock(ctx context.Context, ip string) error {
+	return w.post(ctx, WebhookMessage{Action: "unblock", IP: ip})
+}
+
+func (w *Webhook) post(ctx context.Context, msg WebhookMessage) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != nil {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("edge: webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/webhook"
+)
+
+func TestWebhook(t *testing.T) {
+	secret := []byte("s3cret")
+	var msgs []WebhookMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(webhook.SignatureHeader) != webhook.Sign(secret, body) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		var msg WebhookMessage
+		json.Unmarshal(body, &msg)
+		msgs = append(msgs, msg)
+	}))
+	defer srv.Close()
+
+	w := NewWebhook(srv.URL, secret, nil)
+	until := time.UnixMilli(1767225600000)
+	if err := w.Block(context.Background(), "1.1.1.1", until); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if err := w.Unblock(context.Background(), "1.1.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+
+	want := []WebhookMessage{
+		{Action: "block", IP: "1.1.1.1", Until: 1767225600000},
+		{Action: "unblock", IP: "1.1.1.1"},
+	}
+	if len(msgs) != 2 || msgs[0] != want[0] || msgs[1] != want[1] {
+		t.Errorf("expected %+v, got %+v", want, msgs)
+	}
+
+	if err := NewWebhook(srv.URL, []byte("wrong"), nil).Unblock(context.Background(), "1.1.1.1"); err == nil {
+		t.Error("expected an error when the endpoint rejects the post")
+	}
+}
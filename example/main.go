@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"net/netip"
 	"time"
 
 	"github.com/cnlangzi/botrate"
+	"github.com/cnlangzi/botrate/admin"
+	"github.com/cnlangzi/botrate/botratehttp"
 	"golang.org/x/time/rate"
 )
 
@@ -24,29 +26,19 @@ func main() {
 	defer limiter.Close()
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ua := r.UserAgent()
-		ip := extractIP(r)
-
-		allowed, _ := limiter.Allow(ua, ip)
-		if !allowed {
-			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
-			return
-		}
-
 		w.Write([]byte("Hello!"))
 	})
 
-	http.Handle("/", handler)
+	// Trust X-Forwarded-For only from the local reverse proxy
+	extractIP := botrate.IPExtractor([]netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/8"),
+	})
+
+	mw := botratehttp.Middleware(limiter, botratehttp.WithIPFunc(extractIP))
+
+	http.Handle("/", mw(handler))
+	// Keep the admin API off public interfaces in production
+	http.Handle("/debug/botrate/", http.StripPrefix("/debug/botrate", admin.New(limiter)))
 	fmt.Println("Server started on :8080")
 	http.ListenAndServe(":8080", nil)
 }
-
-func extractIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return strings.TrimSpace(strings.Split(xff, ",")[0])
-	}
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
-	}
-	return r.RemoteAddr
-}
@@ -0,0 +1,55 @@
+package botrate
+
+import (
+	"expvar"
+	"sync"
+)
+
+// expvars maps the names published by WithExpvar to their limiters.
+// expvar can't unpublish a name, so each name is published once and
+// reports whichever limiter holds it.
+var expvars struct {
+	sync.Mutex
+	published map[string]bool
+	limiters  map[string]*Limiter
+}
+
+// publishExpvar publishes l's stats as name, replacing the limiter that
+// held it before.
+func publishExpvar(name string, l *Limiter) {
+	expvars.Lock()
+	defer expvars.Unlock()
+
+	if !expvars.published[name] {
+		if expvar.Get(name) != nil {
+			l.logger.Warn("botrate: expvar name already published", "name", name)
+			return
+		}
+		expvar.Publish(name, expvar.Func(func() any {
+			expvars.Lock()
+			l := expvars.limiters[name]
+			expvars.Unlock()
+			if l == nil {
+				return nil
+			}
+			return l.Stats()
+		}))
+		if expvars.published == nil {
+			expvars.published = make(map[string]bool)
+			expvars.limiters = make(map[string]*Limiter)
+		}
+		expvars.published[name] = true
+	}
+	expvars.limiters[name] = l
+}
+
+// unpublishExpvar stops reporting l as name, unless another limiter has
+// taken it over.
+func unpublishExpvar(name string, l *Limiter) {
+	expvars.Lock()
+	defer expvars.Unlock()
+
+	if expvars.limiters[name] == l {
+		delete(expvars.limiters, name)
+	}
+}
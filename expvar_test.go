@@ -0,0 +1,50 @@
+package botrate
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestWithExpvar(t *testing.T) {
+	newLimiter := func() *Limiter {
+		l, err := New(WithKnownbots(testbots.New(t)), WithExpvar("botrate_test"))
+		if err != nil {
+			t.Fatalf("New() returned error: %v", err)
+		}
+		return l
+	}
+	get := func() Stats {
+		var s Stats
+		if err := json.Unmarshal([]byte(expvar.Get("botrate_test").String()), &s); err != nil {
+			t.Fatalf("invalid expvar: %v", err)
+		}
+		return s
+	}
+
+	l := newLimiter()
+	l.Block("192.168.1.1", time.Hour)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+
+	s := get()
+	if s.Requests != 2 || s.Denied[ReasonRateLimited] != 1 || s.Blocklist != 1 {
+		t.Errorf("expvar should report the stats, got %+v", s)
+	}
+
+	// A newer limiter takes the name over
+	other := newLimiter()
+	defer other.Close()
+	l.Close()
+	if s := get(); s.Requests != 0 || s.Blocklist != 0 {
+		t.Errorf("expvar should report the newer limiter, got %+v", s)
+	}
+
+	other.Close()
+	if v := expvar.Get("botrate_test").String(); v != "null" {
+		t.Errorf("expvar should be null once closed, got %s", v)
+	}
+}
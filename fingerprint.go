@@ -0,0 +1,78 @@
+package botrate
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// FNV-1a 64-bit, hashing header names without allocating
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// Fingerprint describes the headers of a request, as far as they tell
+// browsers from scripts claiming to be one, see WithHeaderThreshold. The
+// middleware sets it with HeaderFingerprint; the zero Fingerprint is
+// unknown and not scored.
+type Fingerprint struct {
+	// Names is the FNV-1a hash of the header names, lowercased, in the
+	// order added, for custom signals, see analyzer.Observation.Headers.
+	Names uint64
+
+	// Accept, AcceptLanguage and AcceptEncoding report whether the
+	// headers were sent. Browsers send all three.
+	Accept         bool
+	AcceptLanguage bool
+	AcceptEncoding bool
+}
+
+// Add adds the header name, in the order the request sent it where the
+// server keeps it.
+func (f *Fingerprint) Add(name string) {
+	h := f.Names
+	if h == 0 {
+		h = fnvOffset64
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		h = (h ^ uint64(c)) * fnvPrime64
+	}
+	f.Names = (h ^ '\n') * fnvPrime64
+
+	switch {
+	case strings.EqualFold(name, "Accept"):
+		f.Accept = true
+	case strings.EqualFold(name, "Accept-Language"):
+		f.AcceptLanguage = true
+	case strings.EqualFold(name, "Accept-Encoding"):
+		f.AcceptEncoding = true
+	}
+}
+
+// mismatch reports whether f is known and lacks headers that browsers
+// send.
+func (f Fingerprint) mismatch() bool {
+	return f.Names != 0 && !(f.Accept && f.AcceptLanguage && f.AcceptEncoding)
+}
+
+// HeaderFingerprint returns the Fingerprint of h. net/http does not keep
+// the order headers were sent in, so names are added sorted.
+func HeaderFingerprint(h http.Header) Fingerprint {
+	var buf [32]string
+	names := buf[:0]
+	for name := range h {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var f Fingerprint
+	for _, name := range names {
+		f.Add(name)
+	}
+	return f
+}
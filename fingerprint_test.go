@@ -0,0 +1,78 @@
+package botrate
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestHeaderFingerprint(t *testing.T) {
+	h := http.Header{}
+	h.Set("Accept", "text/html")
+	h.Set("Accept-Encoding", "gzip")
+	f := HeaderFingerprint(h)
+	if !f.Accept || f.AcceptLanguage || !f.AcceptEncoding || f.Names == 0 {
+		t.Errorf("unexpected fingerprint %+v", f)
+	}
+	if !f.mismatch() {
+		t.Error("a fingerprint without Accept-Language should not match a browser's")
+	}
+
+	h.Set("Accept-Language", "en")
+	if f := HeaderFingerprint(h); f.mismatch() {
+		t.Errorf("a browser's headers should match, got %+v", f)
+	}
+	if (Fingerprint{}).mismatch() {
+		t.Error("an unknown fingerprint should not mismatch")
+	}
+
+	// The order of names counts, their case doesn't
+	var a, b, c Fingerprint
+	a.Add("Host")
+	a.Add("Accept")
+	b.Add("accept")
+	b.Add("host")
+	c.Add("host")
+	c.Add("ACCEPT")
+	if a.Names == b.Names || a.Names != c.Names {
+		t.Errorf("unexpected names %x %x %x", a.Names, b.Names, c.Names)
+	}
+}
+
+func TestLimiter_WithHeaderThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithAnalyzerWindow(time.Hour),
+		WithHeaderThreshold(2),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	var script, browser Fingerprint
+	for _, name := range []string{"Host", "User-Agent", "Accept"} {
+		script.Add(name)
+	}
+	for _, name := range []string{"Host", "User-Agent", "Accept", "Accept-Language", "Accept-Encoding"} {
+		browser.Add(name)
+	}
+	check := func(ua, ip, path string, f Fingerprint) Decision {
+		return l.Check(Request{UA: ua, IP: ip, Path: path, Headers: f})
+	}
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		check("Mozilla/5.0", "192.168.1.1", path, browser)
+		check("curl/8.0", "192.168.1.2", path, script)
+		check("Mozilla/5.0", "192.168.1.3", path, Fingerprint{})
+		check("Mozilla/5.0", "192.168.1.4", path, script)
+	}
+	for ip, want := range map[string]bool{"192.168.1.1": false, "192.168.1.2": false, "192.168.1.3": false, "192.168.1.4": true} {
+		if got := l.analyzer.Blocked(ip); got != want {
+			t.Errorf("Blocked(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package firewall
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// Command runs a command for each block and unblock of an IP or prefix,
+// one at a time in the background. Commands are run directly, not
+// through a shell, and events on other keys are skipped, so a key can't
+// inject a command or an option.
+type Command struct {
+	block   []*template.Template
+	unblock []*template.Template
+	opts    options
+
+	queue   chan analyzer.Event
+	dropped atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewCommand returns a Command running block for blocks and unblock for
+// unblocks, each a program followed by its arguments. Either may be nil
+// to run nothing.
+func NewCommand(block, unblock []string, opts ...Option) (*Command, error) {
+	c := &Command{
+		opts: newOptions(opts),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	var err error
+	if c.block, err = parseArgs("block", block); err != nil {
+		return nil, err
+	}
+	if c.unblock, err = parseArgs("unblock", unblock); err != nil {
+		return nil, err
+	}
+
+	c.queue = make(chan analyzer.Event, c.opts.queueCap)
+	go c.run()
+	return c, nil
+}
+
+func parseArgs(name string, args []string) ([]*template.Template, error) {
+	tmpls := make([]*template.Template, len(args))
+	for i, arg := range args {
+		t, err := parse(name, arg)
+		if err != nil {
+			return nil, err
+		}
+		tmpls[i] = t
+	}
+	return tmpls, nil
+}
+
+// Handle queues the command for e, see botrate.WithEventFunc.
+func (c *Command) Handle(e analyzer.Event) {
+	switch {
+	case e.Type == analyzer.EventBlock && len(c.block) > 0:
+	case e.Type == analyzer.EventUnblock && len(c.unblock) > 0:
+	default:
+		return
+	}
+	if !address(e.IP) {
+		return
+	}
+	select {
+	case c.queue <- e:
+	default:
+		c.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events whose command did not run because
+// the queue was full. Failed commands are logged.
+func (c *Command) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// Close runs the commands still queued and stops.
+func (c *Command) Close() error {
+	select {
+	case <-c.stop:
+	default:
+		close(c.stop)
+	}
+	<-c.done
+	return nil
+}
+
+func (c *Command) run() {
+	defer close(c.done)
+
+	for {
+		select {
+		case e := <-c.queue:
+			c.exec(e)
+		case <-c.stop:
+			for {
+				select {
+				case e := <-c.queue:
+					c.exec(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Command) exec(e analyzer.Event) {
+	tmpls := c.unblock
+	data := Data{IP: e.IP}
+	if e.Type == analyzer.EventBlock {
+		tmpls = c.block
+		var until time.Time
+		if e.Duration > 0 {
+			until = e.Time.Add(e.Duration)
+		}
+		data = newData(e.IP, e.Reason(), until, e.Time)
+	}
+
+	args := make([]string, len(tmpls))
+	for i, t := range tmpls {
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			c.opts.logger.Warn("botrate: failed to render firewall command", "type", e.Type, "ip", e.IP, "error", err)
+			return
+		}
+		args[i] = b.String()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && stderr.Len() > 0 {
+			err = errors.New(strings.TrimSpace(stderr.String()))
+		}
+		c.opts.logger.Warn("botrate: firewall command failed", "type", e.Type, "ip", e.IP, "command", args[0], "error", err)
+	}
+}
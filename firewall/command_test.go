@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCommand(
+		[]string{"touch", filepath.Join(dir, "{{.IP}}-{{.Reason}}-{{.Seconds}}")},
+		[]string{"rm", filepath.Join(dir, "{{.IP}}-score-60")},
+	)
+	if err != nil {
+		t.Fatalf("NewCommand() returned error: %v", err)
+	}
+
+	now := time.Now()
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "1.1.1.1", Pages: 10, Duration: time.Minute})
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "2.2.2.2", Manual: true})
+	c.Handle(analyzer.Event{Type: analyzer.EventUnblock, Time: now, IP: "1.1.1.1"})
+	c.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "user:42", Manual: true})
+	c.Close()
+
+	if _, err := os.Stat(filepath.Join(dir, "user:42-manual-0")); !os.IsNotExist(err) {
+		t.Errorf("blocks of other keys should be skipped, got %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "2.2.2.2-manual-0")); err != nil {
+		t.Errorf("block command should have run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "1.1.1.1-score-60")); !os.IsNotExist(err) {
+		t.Errorf("unblock command should have run after the block, got %v", err)
+	}
+}
+
+func TestCommand_Nil(t *testing.T) {
+	c, err := NewCommand([]string{"true"}, nil, WithQueueCap(1))
+	if err != nil {
+		t.Fatalf("NewCommand() returned error: %v", err)
+	}
+	defer c.Close()
+
+	// Unblocks are ignored without an unblock command
+	for i := 0; i < 10; i++ {
+		c.Handle(analyzer.Event{Type: analyzer.EventUnblock, IP: "1.1.1.1"})
+	}
+	if c.Dropped() != 0 {
+		t.Errorf("ignored events should not be queued, got %d dropped", c.Dropped())
+	}
+
+	if _, err := NewCommand([]string{"echo", "{{.Missing"}, nil); err == nil {
+		t.Error("expected an error for a malformed template")
+	}
+}
@@ -0,0 +1,191 @@
+package firewall
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// File keeps the blocked IPs and prefixes in a file, one line each,
+// rewritten in the background as they are blocked, unblocked and expire.
+// Blocks of other keys are skipped, see Command. The file is
+// replaced atomically, so readers never see it half written. Point a
+// fail2ban jail, an ipset restore or an nftables include at it.
+type File struct {
+	path string
+	line *template.Template
+	opts options
+
+	// IP -> block
+	blocks map[string]block
+	mu     sync.Mutex
+
+	changed chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type block struct {
+	reason string
+	until  time.Time
+}
+
+// NewFile returns a File writing to path, which is written right away
+// so it exists for the firewall to load.
+func NewFile(path string, opts ...Option) (*File, error) {
+	f := &File{
+		path:    path,
+		opts:    newOptions(opts),
+		blocks:  make(map[string]block),
+		changed: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	line, err := parse("line", f.opts.line)
+	if err != nil {
+		return nil, err
+	}
+	f.line = line
+
+	if err := f.write(time.Now()); err != nil {
+		return nil, err
+	}
+
+	go f.run()
+	return f, nil
+}
+
+// Load adds blocks made before the File was created, e.g. those of
+// botrate.Limiter.Blocklist after a restart with a persistent store.
+func (f *File) Load(entries []analyzer.Entry) {
+	f.mu.Lock()
+	for _, e := range entries {
+		if address(e.IP) {
+			f.blocks[e.IP] = block{until: e.Until}
+		}
+	}
+	f.mu.Unlock()
+	f.notify()
+}
+
+// Handle applies a block or unblock to the file, see
+// botrate.WithEventFunc.
+func (f *File) Handle(e analyzer.Event) {
+	if !address(e.IP) {
+		return
+	}
+
+	f.mu.Lock()
+	switch e.Type {
+	case analyzer.EventBlock:
+		var until time.Time
+		if e.Duration > 0 {
+			until = e.Time.Add(e.Duration)
+		}
+		f.blocks[e.IP] = block{reason: e.Reason(), until: until}
+	case analyzer.EventUnblock:
+		delete(f.blocks, e.IP)
+	default:
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Unlock()
+	f.notify()
+}
+
+// Close writes the last changes and stops.
+func (f *File) Close() error {
+	select {
+	case <-f.stop:
+	default:
+		close(f.stop)
+	}
+	<-f.done
+	return nil
+}
+
+func (f *File) notify() {
+	select {
+	case f.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (f *File) run() {
+	defer close(f.done)
+
+	ticker := time.NewTicker(f.opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.changed:
+		case <-ticker.C:
+		case <-f.stop:
+			select {
+			case <-f.changed:
+				f.save()
+			default:
+			}
+			return
+		}
+		f.save()
+	}
+}
+
+func (f *File) save() {
+	if err := f.write(time.Now()); err != nil {
+		f.opts.logger.Warn("botrate: failed to write firewall file", "path", f.path, "error", err)
+	}
+}
+
+// write replaces the file with the blocks still active at now, dropping
+// the expired ones.
+func (f *File) write(now time.Time) error {
+	f.mu.Lock()
+	lines := make([]Data, 0, len(f.blocks))
+	for ip, b := range f.blocks {
+		if !b.until.IsZero() && !now.Before(b.until) {
+			delete(f.blocks, ip)
+			continue
+		}
+		lines = append(lines, newData(ip, b.reason, b.until, now))
+	}
+	f.mu.Unlock()
+
+	sort.Slice(lines, func(i, j int) bool { return lines[i].IP < lines[j].IP })
+
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), "."+filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, d := range lines {
+		if err := f.line.Execute(w, d); err != nil {
+			tmp.Close()
+			return err
+		}
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
@@ -0,0 +1,95 @@
+package firewall
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return string(b)
+}
+
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := readFile(t, path)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected file %q, got %q", want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.txt")
+	f, err := NewFile(path)
+	if err != nil {
+		t.Fatalf("NewFile() returned error: %v", err)
+	}
+	defer f.Close()
+
+	// The file exists before the first block
+	if got := readFile(t, path); got != "" {
+		t.Errorf("expected an empty file, got %q", got)
+	}
+
+	now := time.Now()
+	f.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "2.2.2.2", Duration: time.Hour})
+	f.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "1.1.1.1"})
+	f.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "-session:abc"})
+	waitForFile(t, path, "1.1.1.1\n2.2.2.2\n")
+
+	f.Handle(analyzer.Event{Type: analyzer.EventUnblock, Time: now, IP: "2.2.2.2"})
+	f.Handle(analyzer.Event{Type: analyzer.EventDrop, Time: now, Dropped: 3})
+	waitForFile(t, path, "1.1.1.1\n")
+}
+
+func TestFile_Expire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocked.txt")
+	f, err := NewFile(path, WithInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewFile() returned error: %v", err)
+	}
+	defer f.Close()
+
+	f.Load([]analyzer.Entry{
+		{IP: "1.1.1.1", Until: time.Now().Add(50 * time.Millisecond)},
+		{IP: "2.2.2.2"},
+	})
+	waitForFile(t, path, "1.1.1.1\n2.2.2.2\n")
+	waitForFile(t, path, "2.2.2.2\n")
+}
+
+func TestFile_Line(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ipset.txt")
+	f, err := NewFile(path, WithLine("add botrate {{.IP}} timeout {{.Seconds}}"))
+	if err != nil {
+		t.Fatalf("NewFile() returned error: %v", err)
+	}
+
+	f.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: time.Now(), IP: "1.1.1.1", Duration: time.Hour})
+	f.Close()
+
+	if got := readFile(t, path); got != "add botrate 1.1.1.1 timeout 3600\n" {
+		t.Errorf("unexpected file %q", got)
+	}
+
+	if _, err := NewFile(path, WithLine("{{.IP")); err == nil {
+		t.Error("expected an error for a malformed template")
+	}
+}
@@ -0,0 +1,138 @@
+// Package firewall exports botrate blocks to the kernel firewall, so
+// sustained attackers are dropped before they reach the application.
+//
+// A File keeps the blocked IPs in a file for fail2ban, ipset or nftables
+// to load. A Command runs a command per block and unblock, e.g. adding an
+// element with a timeout to an nftables set:
+//
+//	cmd, err := firewall.NewCommand(
+//		[]string{"nft", "add", "element", "inet", "filter", "botrate", "{ {{.IP}} timeout {{.Seconds}}s }"},
+//		[]string{"nft", "delete", "element", "inet", "filter", "botrate", "{ {{.IP}} }"},
+//	)
+//	limiter, err := botrate.New(botrate.WithEventFunc(cmd.Handle))
+//
+// Every argument, and every line of a File, is a text/template executed
+// with Data. Only events on IPs and prefixes are exported: analysis keys
+// of users, sessions, UAs or hosts are skipped.
+package firewall
+
+import (
+	"log/slog"
+	"net/netip"
+	"text/template"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultTimeout  = 5 * time.Second
+	DefaultQueueCap = 10000
+	DefaultInterval = time.Minute
+	DefaultLine     = "{{.IP}}"
+)
+
+// Data is what templates are executed with.
+type Data struct {
+	IP string
+
+	// Reason is why the IP was blocked, see analyzer.Event.Reason. It is
+	// empty for unblocks and for blocks loaded with File.Load.
+	Reason string
+
+	// Until is when the block expires, zero if never.
+	Until time.Time
+
+	// Seconds is the time left until the block expires, 0 if never. It
+	// suits the timeouts of ipset and nftables sets.
+	Seconds int64
+}
+
+func newData(ip, reason string, until, now time.Time) Data {
+	d := Data{IP: ip, Reason: reason, Until: until}
+	if !until.IsZero() {
+		// Round up, a 0 timeout means forever to ipset and nftables
+		d.Seconds = int64((until.Sub(now) + time.Second - 1) / time.Second)
+	}
+	return d
+}
+
+// Option is a functional option for configuring File and Command.
+type Option func(*options)
+
+type options struct {
+	timeout  time.Duration
+	queueCap int
+	interval time.Duration
+	line     string
+	logger   *slog.Logger
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		timeout:  DefaultTimeout,
+		queueCap: DefaultQueueCap,
+		interval: DefaultInterval,
+		line:     DefaultLine,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = logging.Discard()
+	}
+	return o
+}
+
+// WithTimeout sets how long a Command may run before it is killed.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithQueueCap sets how many events can wait for a Command. Events are
+// dropped when the queue is full.
+func WithQueueCap(cap int) Option {
+	return func(o *options) {
+		o.queueCap = cap
+	}
+}
+
+// WithInterval sets how often a File drops the expired blocks.
+func WithInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.interval = d
+	}
+}
+
+// WithLine sets the template of a line of a File, e.g.
+// "add botrate {{.IP}} timeout {{.Seconds}}" for ipset restore.
+func WithLine(tmpl string) Option {
+	return func(o *options) {
+		o.line = tmpl
+	}
+}
+
+// WithLogger sets the logger for failed commands and writes.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// address reports whether key is an IP or a prefix, such as a block of
+// WithPrefixThreshold, rather than another analysis key, which firewalls
+// can't match and which could pass for a command-line option.
+func address(key string) bool {
+	if _, err := netip.ParseAddr(key); err == nil {
+		return true
+	}
+	_, err := netip.ParsePrefix(key)
+	return err == nil
+}
+
+func parse(name, text string) (*template.Template, error) {
+	return template.New(name).Parse(text)
+}
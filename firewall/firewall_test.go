@@ -0,0 +1,44 @@
+package firewall
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddress(t *testing.T) {
+	for key, want := range map[string]bool{
+		"1.2.3.4":             true,
+		"2001:db8::1":         true,
+		"1.2.3.0/24":          true,
+		"2001:db8::/48":       true,
+		"user:42":             false,
+		"session:abc":         false,
+		"1.2.3.4#3f2a":        false,
+		"example.com/1.2.3.4": false,
+		"--help":              false,
+		"":                    false,
+	} {
+		if got := address(key); got != want {
+			t.Errorf("address(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestNewData_Seconds(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		until time.Time
+		want  int64
+	}{
+		{time.Time{}, 0},
+		{now.Add(time.Hour), 3600},
+		{now.Add(1500 * time.Millisecond), 2},
+		{now.Add(time.Millisecond), 1},
+	}
+	for _, tt := range tests {
+		if d := newData("1.2.3.4", "", tt.until, now); d.Seconds != tt.want {
+			t.Errorf("Seconds until %v = %d, want %d", tt.until.Sub(now), d.Seconds, tt.want)
+		}
+	}
+}
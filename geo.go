@@ -0,0 +1,82 @@
+package botrate
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// GeoLocator returns the ISO 3166-1 alpha-2 country code of an IP, e.g.
+// "US", or "" if unknown. It must be safe for concurrent use. A
+// geoip.DB implements it with a MaxMind database; wrap other providers
+// to use them with WithGeoLocator.
+type GeoLocator interface {
+	Country(addr netip.Addr) string
+}
+
+// geoPolicy applies the country policies to normal users, see
+// WithGeoLocator.
+type geoPolicy struct {
+	locator    GeoLocator
+	deny       map[string]bool
+	allow      map[string]bool
+	thresholds map[string]int
+}
+
+func newGeoPolicy(locator GeoLocator, cfg *Config) (*geoPolicy, error) {
+	if locator == nil {
+		if len(cfg.CountryDeny) > 0 || len(cfg.CountryAllow) > 0 || len(cfg.CountryPageThresholds) > 0 {
+			return nil, errors.New("botrate: country policies need WithGeoLocator")
+		}
+		return nil, nil
+	}
+
+	p := &geoPolicy{locator: locator}
+	var err error
+	if p.deny, err = countrySet(cfg.CountryDeny); err != nil {
+		return nil, err
+	}
+	if p.allow, err = countrySet(cfg.CountryAllow); err != nil {
+		return nil, err
+	}
+	p.thresholds = make(map[string]int, len(cfg.CountryPageThresholds))
+	for c, threshold := range cfg.CountryPageThresholds {
+		code, err := countryCode(c)
+		if err != nil {
+			return nil, err
+		}
+		p.thresholds[code] = threshold
+	}
+	return p, nil
+}
+
+// denied reports whether requests from country are rejected. Unknown
+// countries are never rejected.
+func (p *geoPolicy) denied(country string) bool {
+	if country == "" {
+		return false
+	}
+	return p.deny[country] || len(p.allow) > 0 && !p.allow[country]
+}
+
+func countrySet(codes []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		code, err := countryCode(c)
+		if err != nil {
+			return nil, err
+		}
+		set[code] = true
+	}
+	return set, nil
+}
+
+// countryCode validates and upper-cases an ISO 3166-1 alpha-2 code.
+func countryCode(c string) (string, error) {
+	code := strings.ToUpper(c)
+	if len(code) != 2 || code[0] < 'A' || code[0] > 'Z' || code[1] < 'A' || code[1] > 'Z' {
+		return "", fmt.Errorf("botrate: invalid country code %q", c)
+	}
+	return code, nil
+}
@@ -0,0 +1,103 @@
+package botrate
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+// fakeLocator locates IPs from a map.
+type fakeLocator map[string]string
+
+func (f fakeLocator) Country(addr netip.Addr) string {
+	return f[addr.String()]
+}
+
+var testLocator = fakeLocator{
+	"203.0.113.1": "CN",
+	"203.0.113.2": "US",
+	"203.0.113.3": "DE",
+}
+
+func TestLimiter_WithCountryDeny(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithGeoLocator(testLocator),
+		WithCountryDeny("cn"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("Mozilla/5.0", "203.0.113.1", "/"); d.Allowed || d.Reason != ReasonDenied || d.Country != "CN" {
+		t.Errorf("users from a denied country should be denied, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "203.0.113.2", "/"); !d.Allowed || d.Country != "US" {
+		t.Errorf("users from other countries should be allowed, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "198.51.100.1", "/"); !d.Allowed || d.Country != "" {
+		t.Errorf("users from unknown countries should be allowed, got %+v", d)
+	}
+}
+
+func TestLimiter_WithCountryAllow(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithGeoLocator(testLocator),
+		WithCountryAllow("US", "DE"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for ip, allowed := range map[string]bool{
+		"203.0.113.1":  false,
+		"203.0.113.2":  true,
+		"203.0.113.3":  true,
+		"198.51.100.1": true,
+	} {
+		if d := l.Decide("Mozilla/5.0", ip, "/"); d.Allowed != allowed {
+			t.Errorf("%s (%s): expected allowed %v, got %+v", ip, testLocator[ip], allowed, d)
+		}
+	}
+}
+
+func TestLimiter_WithCountryPageThreshold(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithGeoLocator(testLocator),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(100),
+		WithCountryPageThreshold(3, "CN"),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		for _, path := range []string{"/a", "/b", "/c"} {
+			l.Allow("Mozilla/5.0", ip, path)
+		}
+	}
+	if d := l.Decide("Mozilla/5.0", "203.0.113.1", "/d"); !d.Blocklisted {
+		t.Errorf("the country threshold should block at 3 pages, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "203.0.113.2", "/d"); d.Blocklisted {
+		t.Errorf("other countries should keep the default threshold, got %+v", d)
+	}
+}
+
+func TestLimiter_WithGeoLocator_Errors(t *testing.T) {
+	if _, err := New(WithCountryDeny("CN")); err == nil {
+		t.Error("expected an error for country policies without a database")
+	}
+	if _, err := New(WithGeoLocator(testLocator), WithCountryDeny("China")); err == nil {
+		t.Error("expected an error for an invalid country code")
+	}
+}
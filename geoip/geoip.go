@@ -0,0 +1,80 @@
+// Package geoip locates IPs with a MaxMind database, such as GeoLite2
+// Country or City, and finds their autonomous system with GeoLite2 ASN,
+// for botrate's geo and datacenter policies.
+//
+// A DB implements botrate.GeoLocator and botrate.ASNLocator:
+//
+//	db, err := geoip.Open("GeoLite2-Country.mmdb")
+//	...
+//	defer db.Close()
+//	limiter, err := botrate.New(botrate.WithGeoLocator(db), botrate.WithCountryDeny("KP"))
+package geoip
+
+import (
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// DB is a MaxMind database. It implements botrate.GeoLocator and
+// botrate.ASNLocator.
+type DB struct {
+	r *maxminddb.Reader
+}
+
+// Open opens the MaxMind database at path, memory-mapped.
+func Open(path string) (*DB, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{r: r}, nil
+}
+
+// country is the part of a Country or City record read by Country.
+type country struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// Country returns the country of addr, "" if it is not in the database.
+func (db *DB) Country(addr netip.Addr) string {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return ""
+	}
+
+	var rec country
+	if err := db.r.Lookup(addr.AsSlice(), &rec); err != nil {
+		// E.g. an IPv6 address in an IPv4 database
+		return ""
+	}
+	return rec.Country.ISOCode
+}
+
+// asn is the part of an ASN record read by ASN.
+type asn struct {
+	Number       uint32 `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// ASN returns the autonomous system of addr, 0 and "" if it is not in
+// the database.
+func (db *DB) ASN(addr netip.Addr) (uint32, string) {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return 0, ""
+	}
+
+	var rec asn
+	if err := db.r.Lookup(addr.AsSlice(), &rec); err != nil {
+		return 0, ""
+	}
+	return rec.Number, rec.Organization
+}
+
+// Close unmaps the database.
+func (db *DB) Close() error {
+	return db.r.Close()
+}
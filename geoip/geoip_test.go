@@ -0,0 +1,182 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// writeDB writes a minimal IPv4 MaxMind database mapping prefixes to
+// records, maps of strings, uint32s and nested maps.
+func writeDB(t *testing.T, records map[string]map[string]any) string {
+	t.Helper()
+
+	type node struct {
+		child [2]*node
+		data  [2]int // data offset + 1, 0 if none
+	}
+	root := &node{}
+	var data bytes.Buffer
+
+	prefixes := make([]string, 0, len(records))
+	for p := range records {
+		prefixes = append(prefixes, p)
+	}
+	sort.Strings(prefixes)
+
+	for _, p := range prefixes {
+		prefix := netip.MustParsePrefix(p)
+		offset := data.Len()
+		encode(&data, records[p])
+
+		ip := prefix.Addr().As4()
+		n := root
+		for i := 0; i < prefix.Bits(); i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == prefix.Bits()-1 {
+				n.data[bit] = offset + 1
+				break
+			}
+			if n.child[bit] == nil {
+				n.child[bit] = &node{}
+			}
+			n = n.child[bit]
+		}
+	}
+
+	// Number the nodes breadth first, the root is 0
+	nodes := []*node{root}
+	index := map[*node]int{root: 0}
+	for i := 0; i < len(nodes); i++ {
+		for _, c := range nodes[i].child {
+			if c != nil {
+				index[c] = len(nodes)
+				nodes = append(nodes, c)
+			}
+		}
+	}
+
+	var db bytes.Buffer
+	count := len(nodes)
+	for _, n := range nodes {
+		for bit := 0; bit < 2; bit++ {
+			record := count // empty
+			switch {
+			case n.child[bit] != nil:
+				record = index[n.child[bit]]
+			case n.data[bit] != 0:
+				record = count + 16 + n.data[bit] - 1
+			}
+			db.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+	db.WriteString("\xab\xcd\xefMaxMind.com")
+	encode(&db, map[string]any{
+		"node_count":                  uint32(count),
+		"record_size":                 uint32(24),
+		"ip_version":                  uint32(4),
+		"binary_format_major_version": uint32(2),
+		"database_type":               "Test",
+	})
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, db.Bytes(), 0o644); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+	return path
+}
+
+// encode writes v in the MaxMind DB data format.
+func encode(b *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case string:
+		if len(v) < 29 {
+			b.WriteByte(2<<5 | byte(len(v)))
+		} else {
+			// Sizes from 29 take an extra byte
+			b.WriteByte(2<<5 | 29)
+			b.WriteByte(byte(len(v) - 29))
+		}
+		b.WriteString(v)
+	case uint32:
+		buf := binary.BigEndian.AppendUint32(nil, v)
+		buf = bytes.TrimLeft(buf, "\x00")
+		b.WriteByte(6<<5 | byte(len(buf)))
+		b.Write(buf)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte(7<<5 | byte(len(v)))
+		for _, k := range keys {
+			encode(b, k)
+			encode(b, v[k])
+		}
+	default:
+		panic("unsupported type")
+	}
+}
+
+func TestDB_Country(t *testing.T) {
+	path := writeDB(t, map[string]map[string]any{
+		"203.0.113.0/24":  {"country": map[string]any{"iso_code": "CN"}},
+		"198.51.100.0/25": {"country": map[string]any{"iso_code": "US"}},
+	})
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"203.0.113.7", "CN"},
+		{"::ffff:203.0.113.7", "CN"},
+		{"198.51.100.1", "US"},
+		{"198.51.100.200", ""},
+		{"192.0.2.1", ""},
+		{"2001:db8::1", ""},
+	}
+	for _, tt := range tests {
+		if got := db.Country(netip.MustParseAddr(tt.ip)); got != tt.want {
+			t.Errorf("Country(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+	if got := db.Country(netip.Addr{}); got != "" {
+		t.Errorf("Country of an invalid address = %q, want empty", got)
+	}
+}
+
+func TestDB_ASN(t *testing.T) {
+	path := writeDB(t, map[string]map[string]any{
+		"203.0.113.0/24": {"autonomous_system_number": uint32(24940), "autonomous_system_organization": "Hetzner Online GmbH"},
+	})
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() returned error: %v", err)
+	}
+	defer db.Close()
+
+	if n, org := db.ASN(netip.MustParseAddr("203.0.113.7")); n != 24940 || org != "Hetzner Online GmbH" {
+		t.Errorf("ASN() = %d, %q, want 24940, Hetzner Online GmbH", n, org)
+	}
+	if n, org := db.ASN(netip.MustParseAddr("192.0.2.1")); n != 0 || org != "" {
+		t.Errorf("ASN() of an unknown IP = %d, %q, want 0, empty", n, org)
+	}
+}
+
+func TestOpen_Error(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
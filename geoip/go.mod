@@ -0,0 +1,7 @@
+module github.com/cnlangzi/botrate/geoip
+
+go 1.22
+
+require github.com/oschwald/maxminddb-golang v1.13.1
+
+require golang.org/x/sys v0.21.0 // indirect
@@ -6,9 +6,7 @@ require (
 	github.com/bits-and-blooms/bloom/v3 v3.7.1
 	github.com/cnlangzi/knownbots v1.0.6
 	golang.org/x/time v0.7.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	github.com/bits-and-blooms/bitset v1.24.2 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
-)
+require github.com/bits-and-blooms/bitset v1.24.2 // indirect
@@ -0,0 +1,83 @@
+package botrate
+
+import (
+	"sync"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/knownbots"
+)
+
+// IPInfo is what the limiter knows about an IP, see Inspect.
+type IPInfo struct {
+	IP string `json:"ip"`
+
+	// Allowlisted and Denylisted report whether the IP is in the
+	// ranges of WithAllowCIDRs or WithDenyCIDRs.
+	Allowlisted bool `json:"allowlisted,omitempty"`
+	Denylisted  bool `json:"denylisted,omitempty"`
+
+	// State is the behavior analysis of the IP: whether it is blocked,
+	// its counts in the window, its offenses and block events.
+	analyzer.State
+
+	// Bucket names the token bucket the IP is throttled with: "blocked",
+	// "greylisted", "tor" or "fake_bot", "" if none. Tokens and Burst
+	// are its tokens left and size.
+	Bucket string  `json:"bucket,omitempty"`
+	Tokens float64 `json:"tokens,omitempty"`
+	Burst  int     `json:"burst,omitempty"`
+
+	// Bots are the bots claimed from the IP and their verification
+	// status, as far as WithVerifyCache and WithPendingRetries
+	// remember them.
+	Bots []BotVerification `json:"bots,omitempty"`
+}
+
+// BotVerification is the verification of a bot claimed from an IP.
+type BotVerification struct {
+	Bot    string                 `json:"bot"`
+	UA     string                 `json:"ua,omitempty"`
+	Status knownbots.ResultStatus `json:"status"`
+
+	// Retries is how often a pending verification was retried.
+	Retries int `json:"retries,omitempty"`
+}
+
+// Inspect returns what the limiter knows about ip, or a key of
+// WithKeyFunc, e.g. to answer "why am I blocked?". It walks the
+// blocklist, recent events and verifications, so it is meant for
+// support and admin tools rather than the request path.
+func (l *Limiter) Inspect(ip string) IPInfo {
+	ip, addr := canonicalIP(ip)
+	info := IPInfo{
+		IP:          ip,
+		Allowlisted: l.allow.Contains(addr),
+		Denylisted:  l.deny.Contains(addr),
+		State:       l.analyzer.Inspect(ip),
+	}
+
+	now := l.cfg.Clock.Now()
+	for _, b := range []struct {
+		name string
+		m    *sync.Map
+	}{
+		{"blocked", &l.blocked},
+		{"greylisted", &l.greylisted},
+		{"tor", &l.torExits},
+		{"fake_bot", &l.fakeBots},
+	} {
+		if v, ok := b.m.Load(ip); ok {
+			bucket := v.(*tokenBucket)
+			info.Bucket, info.Tokens, info.Burst = b.name, bucket.TokensAt(now), bucket.Burst()
+			break
+		}
+	}
+
+	if l.verifyCache != nil {
+		info.Bots = l.verifyCache.inspect(ip, l.cfg.Clock)
+	}
+	if l.pending != nil {
+		info.Bots = append(info.Bots, l.pending.inspect(ip)...)
+	}
+	return info
+}
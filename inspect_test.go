@@ -0,0 +1,52 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Inspect(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(2),
+		WithVerifyCache(time.Minute, 0),
+		WithDenyCIDRs([]string{"10.1.0.0/16"}),
+		WithSyncAnalyzer(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/a")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/b")
+	info := l.Inspect("192.168.1.1")
+	if info.Blocked || info.Pages != 2 || info.Bucket != "" {
+		t.Errorf("unexpected info of a normal user %+v", info)
+	}
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+	info = l.Inspect("192.168.1.1")
+	if !info.Blocked || info.Until.IsZero() || len(info.Events) != 1 {
+		t.Errorf("unexpected info of a blocked IP %+v", info)
+	}
+	if info.Bucket != "blocked" || info.Burst != 2 || info.Tokens < 0.99 || info.Tokens > 1.01 {
+		t.Errorf("expected 1 of 2 tokens left in the blocked bucket, got %q %v/%d", info.Bucket, info.Tokens, info.Burst)
+	}
+
+	l.Allow("TestBot/1.0", "10.0.0.1", "/")
+	info = l.Inspect("10.0.0.1")
+	if len(info.Bots) != 1 || info.Bots[0].Bot != "testbot" || info.Bots[0].Status != knownbots.StatusFailed {
+		t.Errorf("expected the failed verification, got %+v", info.Bots)
+	}
+
+	if info := l.Inspect("10.1.2.3"); !info.Denylisted || info.Allowlisted {
+		t.Errorf("expected a denylisted IP, got %+v", info)
+	}
+}
@@ -0,0 +1,21 @@
+// Package logging holds the logging helpers shared by the botrate
+// packages.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Discard returns a logger that drops every record. It is the default when
+// no logger is configured (slog.DiscardHandler requires Go 1.24).
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
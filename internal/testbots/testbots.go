@@ -0,0 +1,73 @@
+// Package testbots holds the knownbots datasets shared by the botrate
+// tests.
+package testbots
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cnlangzi/knownbots"
+)
+
+// CIDR is the range the bots of Conf are verified from.
+const CIDR = "192.168.100.0/24"
+
+// Bots verified from CIDR, by name.
+var (
+	// TestBot is a search engine, claimed by "TestBot".
+	TestBot = Conf("SearchEngine", "testbot", "TestBot")
+
+	// TrainBot is an AI training crawler, claimed by "TrainBot".
+	TrainBot = Conf("AITraining", "trainbot", "TrainBot")
+
+	// AnswerBot is an AI assistant, claimed by "AnswerBot".
+	AnswerBot = Conf("AIAssist", "answerbot", "AnswerBot")
+)
+
+// Conf returns the knownbots config of the bot name of kind, claimed by
+// UAs containing ua and verified from CIDR.
+func Conf(kind, name, ua string) string {
+	return "kind: " + kind + "\nname: " + name + "\nparser: txt\nua: \"" + ua + "\"\ncustom:\n  - \"" + CIDR + "\"\n"
+}
+
+// Root returns a knownbots root with confs, by bot name, in conf.d.
+func Root(t testing.TB, confs map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "conf.d"), 0755); err != nil {
+		t.Fatalf("Failed to create config dir: %v", err)
+	}
+	for name, conf := range confs {
+		if err := os.WriteFile(filepath.Join(dir, "conf.d", name+".yaml"), []byte(conf), 0644); err != nil {
+			t.Fatalf("Failed to write bot config: %v", err)
+		}
+	}
+	return dir
+}
+
+// Validator returns a validator of the bots in root, closed with t.
+func Validator(t testing.TB, root string) *knownbots.Validator {
+	t.Helper()
+
+	kb, err := knownbots.New(knownbots.WithRoot(root))
+	if err != nil {
+		t.Fatalf("Failed to create knownbots validator: %v", err)
+	}
+	t.Cleanup(func() { kb.Close() })
+	return kb
+}
+
+// New returns a validator of TestBot.
+func New(t testing.TB) *knownbots.Validator {
+	t.Helper()
+	return Validator(t, Root(t, map[string]string{"testbot": TestBot}))
+}
+
+// NewAI returns a validator of TestBot and the AI bots, TrainBot and
+// AnswerBot.
+func NewAI(t testing.TB) *knownbots.Validator {
+	t.Helper()
+	return Validator(t, Root(t, map[string]string{"testbot": TestBot, "trainbot": TrainBot, "answerbot": AnswerBot}))
+}
@@ -0,0 +1,108 @@
+package botrate
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPExtractor returns a function that extracts the client IP from a request.
+//
+// The direct peer (r.RemoteAddr) is trusted only if it falls inside
+// trustedProxies. In that case X-Forwarded-For is walked from the right,
+// skipping trusted hops, and the first untrusted address is the client.
+// X-Real-IP is honored only when the peer is trusted and there is no
+// X-Forwarded-For. Ports are stripped and IPv4-mapped IPv6 addresses are
+// unmapped.
+//
+// With no trusted proxies the peer address is always returned, so clients
+// can't spoof their way past the limiter with forged headers.
+func IPExtractor(trustedProxies []netip.Prefix) func(r *http.Request) string {
+	trusted := func(addr netip.Addr) bool {
+		for _, p := range trustedProxies {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(r *http.Request) string {
+		remote, ok := parseHostAddr(r.RemoteAddr)
+		if !ok {
+			return r.RemoteAddr
+		}
+		if !trusted(remote) {
+			return remote.String()
+		}
+
+		xff := r.Header.Values("X-Forwarded-For")
+		if len(xff) == 0 {
+			if xri, ok := parseHostAddr(r.Header.Get("X-Real-IP")); ok {
+				return xri.String()
+			}
+			return remote.String()
+		}
+
+		// Walk from the right: each trusted hop vouches for the one before it
+		client := remote
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, ok := parseHostAddr(hops[i])
+			if !ok {
+				// Garbage injected by the client or a broken proxy:
+				// stop at the last address we could vouch for
+				break
+			}
+			client = addr
+			if !trusted(addr) {
+				break
+			}
+		}
+		return client.String()
+	}
+}
+
+// parseHostAddr parses an IP with an optional port.
+func parseHostAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return netip.Addr{}, false
+	}
+
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+
+	host, _, err := net.SplitHostPort(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// canonicalIP parses ip and returns its canonical text form, so that
+// equivalent spellings ("::FFFF:1.2.3.4", "1.2.3.4", "fe80::1%eth0")
+// share one blocklist and limiter key. Invalid IPs are returned unchanged
+// with a zero Addr.
+func canonicalIP(ip string) (string, netip.Addr) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip, netip.Addr{}
+	}
+	addr = addr.Unmap().WithZone("")
+
+	// Most IPs are already canonical: compare on the stack to avoid
+	// allocating a new string on the hot path.
+	var buf [64]byte
+	b := addr.AppendTo(buf[:0])
+	if string(b) == ip {
+		return ip, addr
+	}
+	return string(b), addr
+}
@@ -0,0 +1,156 @@
+package botrate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPExtractor(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	testCases := []struct {
+		name       string
+		trusted    []netip.Prefix
+		remoteAddr string
+		xff        []string
+		xri        string
+		want       string
+	}{
+		{
+			name:       "no proxies uses peer",
+			remoteAddr: "203.0.113.1:1234",
+			xff:        []string{"1.1.1.1"},
+			xri:        "2.2.2.2",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "untrusted peer ignores headers",
+			trusted:    trusted,
+			remoteAddr: "203.0.113.1:1234",
+			xff:        []string{"1.1.1.1"},
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "trusted peer uses rightmost untrusted hop",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"6.6.6.6, 198.51.100.7, 10.0.0.2"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "multiple header lines",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"6.6.6.6", "198.51.100.7"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "all hops trusted uses leftmost",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"10.0.0.3, 10.0.0.2"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "garbage hop stops the walk",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.7, garbage, 10.0.0.2"},
+			want:       "10.0.0.2",
+		},
+		{
+			name:       "ports are stripped",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			xff:        []string{"198.51.100.7:5555"},
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "bracketed ipv6 with port",
+			trusted:    trusted,
+			remoteAddr: "[2001:db8::1]:443",
+			xff:        []string{"[2001:db9::5]:443"},
+			want:       "2001:db9::5",
+		},
+		{
+			name:       "x-real-ip from trusted peer",
+			trusted:    trusted,
+			remoteAddr: "10.0.0.1:1234",
+			xri:        "198.51.100.7",
+			want:       "198.51.100.7",
+		},
+		{
+			name:       "x-real-ip ignored from untrusted peer",
+			trusted:    trusted,
+			remoteAddr: "203.0.113.1:1234",
+			xri:        "198.51.100.7",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "mapped ipv4 is unmapped",
+			remoteAddr: "[::ffff:203.0.113.1]:1234",
+			want:       "203.0.113.1",
+		},
+		{
+			name:       "unparseable remote addr is returned as is",
+			remoteAddr: "pipe",
+			want:       "pipe",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tc.xri != "" {
+				r.Header.Set("X-Real-IP", tc.xri)
+			}
+
+			if got := IPExtractor(tc.trusted)(r); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalIP(t *testing.T) {
+	testCases := []struct {
+		in    string
+		want  string
+		valid bool
+	}{
+		{"192.168.1.1", "192.168.1.1", true},
+		{"::ffff:192.168.1.1", "192.168.1.1", true},
+		{"2001:DB8::1", "2001:db8::1", true},
+		{"2001:0db8:0000:0000:0000:0000:0000:0001", "2001:db8::1", true},
+		{"fe80::1%eth0", "fe80::1", true},
+		{"not-an-ip", "not-an-ip", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		got, addr := canonicalIP(tc.in)
+		if got != tc.want {
+			t.Errorf("canonicalIP(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		if addr.IsValid() != tc.valid {
+			t.Errorf("canonicalIP(%q) valid = %v, want %v", tc.in, addr.IsValid(), tc.valid)
+		}
+	}
+}
+
+func BenchmarkCanonicalIP(b *testing.B) {
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		canonicalIP("192.168.1.1")
+	}
+}
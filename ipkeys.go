@@ -0,0 +1,77 @@
+package botrate
+
+import (
+	"sync"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// DefaultUAKeysPerIP is how many UA keys an IP gets with WithUAKeying
+// before its other UAs share the IP's key, see WithUAKeysPerIP.
+var DefaultUAKeysPerIP = 32
+
+// DefaultSessionsPerIP is how many session keys an IP gets before its
+// other sessions are keyed as if they had none, see WithSessionsPerIP.
+var DefaultSessionsPerIP = 32
+
+// ipKeys are the keys given to the clients of an IP.
+type ipKeys struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+}
+
+// uaKey returns the key of req with WithUAKeying: analyzer.UAKey while
+// its IP has fewer than UAKeysPerIP, the IP for its other UAs, so a
+// client rotating its UA doesn't get a fresh key per UA.
+func (l *Limiter) uaKey(req *Request) string {
+	key := analyzer.UAKey(req.IP, req.UA)
+	if key == req.IP || !giveKey(&l.uaKeys, req.IP, key, l.cfg.UAKeysPerIP) {
+		return req.IP
+	}
+	return key
+}
+
+// sessionKey returns the key of req's session, and false once its IP
+// has SessionsPerIP other sessions, so a client minting session cookies
+// doesn't get a fresh key per cookie.
+func (l *Limiter) sessionKey(req *Request) (string, bool) {
+	key := sessionKeyPrefix + req.Session
+	return key, giveKey(&l.sessionKeys, req.IP, key, l.cfg.SessionsPerIP)
+}
+
+// giveKey reports whether the client of ip may have key, recording it in
+// m, the keys by IP, while ip has fewer than limit; limit <= 0 for no
+// limit.
+func giveKey(m *sync.Map, ip, key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	v, ok := m.Load(ip)
+	if !ok {
+		v, _ = m.LoadOrStore(ip, &ipKeys{keys: make(map[string]struct{})})
+	}
+	s := v.(*ipKeys)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key]; ok {
+		return true
+	}
+	if len(s.keys) >= limit {
+		return false
+	}
+	s.keys[key] = struct{}{}
+	return true
+}
+
+// forgetKeys forgets the UA and session keys given to IPs, at the end of
+// every analyzer window, so they are bounded by the IPs seen in a window.
+func (l *Limiter) forgetKeys() {
+	for _, m := range []*sync.Map{&l.uaKeys, &l.sessionKeys} {
+		m.Range(func(key, _ any) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+}
@@ -2,10 +2,24 @@ package botrate
 
 import (
 	"context"
+	"errors"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cnlangzi/botrate/abuseipdb"
 	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/audit"
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/cloudranges"
+	"github.com/cnlangzi/botrate/dnsbl"
+	"github.com/cnlangzi/botrate/internal/logging"
+	"github.com/cnlangzi/botrate/tor"
+	"github.com/cnlangzi/botrate/useragent"
 	"github.com/cnlangzi/knownbots"
 	"golang.org/x/time/rate"
 )
@@ -13,9 +27,41 @@ import (
 // Default configuration values.
 var (
 	DefaultLimit         = rate.Every(10 * time.Minute) // Very strict: 1 request per 10 min
+	DefaultBurst         = 1                            // Strict blocking
 	DefaultWindow        = 5 * time.Minute
 	DefaultPageThreshold = 50
 	DefaultQueueCap      = 10000
+	DefaultBlockDuration = time.Hour
+
+	// Failed logins per window before an IP is blocked, with WithLoginPaths
+	DefaultLoginThreshold = 5
+
+	// Moderate limit for greylisted IPs, with WithGreylistThreshold
+	DefaultGreylistLimit = rate.Every(time.Second)
+	DefaultGreylistBurst = 10
+
+	// Limit for each AI bot, with WithAIBotPolicy(AIBotLimit, ...)
+	DefaultAIBotLimit = rate.Every(time.Second)
+	DefaultAIBotBurst = 10
+
+	// Limit for each Tor exit, with WithTor(TorLimit, ...)
+	DefaultTorLimit = rate.Every(time.Second)
+	DefaultTorBurst = 20
+
+	// Longest UA that is not TooLong, and the page threshold of bad UAs,
+	// with WithBadUAPolicy
+	DefaultMaxUALength        = 512
+	DefaultBadUAPageThreshold = 10
+)
+
+// DefaultLoginStatuses are the response statuses that count as failed
+// logins on login paths.
+var DefaultLoginStatuses = []int{401, 403}
+
+// Key prefixes keep users and sessions apart from IPs on the blocklist.
+const (
+	userKeyPrefix    = "user:"
+	sessionKeyPrefix = "session:"
 )
 
 // Reason represents the reason for rate limiting.
@@ -29,154 +75,1148 @@ const (
 	// ReasonRateLimited indicates the request was blocked because
 	// the IP was flagged by behavior analysis.
 	ReasonRateLimited Reason = "rate_limited"
+
+	// ReasonDenied indicates the request was blocked because
+	// the IP is on the static denylist.
+	ReasonDenied Reason = "denied"
+
+	// ReasonGreylisted indicates the request was limited because
+	// the IP is greylisted: approaching the block threshold.
+	ReasonGreylisted Reason = "greylisted"
+
+	// ReasonDatacenter indicates the request was blocked because a
+	// browser UA came from a datacenter, see WithDenyDatacenterBrowsers.
+	ReasonDatacenter Reason = "datacenter"
+
+	// ReasonBotLimited indicates the request was limited because a
+	// verified bot exceeded its limit, see WithBotLimit.
+	ReasonBotLimited Reason = "bot_limited"
+
+	// ReasonCrawlDelay indicates the request was limited because a
+	// verified bot came back before its Crawl-delay, see WithRobots.
+	ReasonCrawlDelay Reason = "crawl_delay"
+
+	// ReasonBotQuota indicates the request was denied because a verified
+	// bot exhausted its crawl budget, see WithBotBudget.
+	ReasonBotQuota Reason = "bot_quota"
+
+	// ReasonAIBot indicates the request was blocked or limited because
+	// it came from an AI bot, see WithAIBotPolicy.
+	ReasonAIBot Reason = "ai_bot"
+
+	// ReasonTor indicates the request was blocked or limited because it
+	// came from a Tor exit, see WithTor.
+	ReasonTor Reason = "tor"
+
+	// ReasonMessageLimited indicates a message was denied because its
+	// connection exceeded its message budget, see WithMessageLimit.
+	ReasonMessageLimited Reason = "message_limited"
+
+	// ReasonPathLimited indicates the request was limited because the
+	// client exceeded the limit of the path's policy, see WithPathPolicy.
+	ReasonPathLimited Reason = "path_limited"
+
+	// ReasonTLSFingerprint indicates the request was blocked because its
+	// TLS client fingerprint is denied, see WithDenyTLSFingerprints.
+	ReasonTLSFingerprint Reason = "tls_fingerprint"
+
+	// ReasonBadUA indicates the request was blocked because its UA is
+	// empty, truncated or too long, see WithBadUAPolicy.
+	ReasonBadUA Reason = "bad_ua"
 )
 
+// Forbidden reports whether r refuses the client for what it is, e.g. a
+// fake bot, a denied IP, TLS fingerprint or UA, rather than for its rate,
+// so a middleware answers 403 instead of 429. Tor exits and AI bots are
+// refused whether blocked or limited.
+func (r Reason) Forbidden() bool {
+	switch r {
+	case ReasonFakeBot, ReasonDenied, ReasonDatacenter, ReasonAIBot, ReasonTor, ReasonTLSFingerprint, ReasonBadUA:
+		return true
+	}
+	return false
+}
+
 // Limiter provides bot-aware rate limiting.
 type Limiter struct {
+	// Configuration of New. The fields ApplyConfig changes are read from
+	// live.
 	cfg Config
 
-	// Token bucket limiters (only for blocked IPs)
+	// Configuration in effect, see config
+	live atomic.Pointer[Config]
+
+	// Serializes ApplyConfig
+	applying sync.Mutex
+
+	// Token bucket limiters (only for blocked IPs), *tokenBucket like
+	// the others
 	blocked sync.Map
 
+	// Token bucket limiters for fake bots (only with FakeBotLimit > 0)
+	fakeBots sync.Map
+
+	// Token bucket limiters for greylisted IPs
+	greylisted sync.Map
+
+	// Token bucket limiters for Tor exits, with TorLimit
+	torExits sync.Map
+
+	// Token bucket limiters for AI bots by name, with AIBotLimit
+	aiBots sync.Map
+
+	// Token bucket limiters for verified bots by name, with WithBotLimit
+	bots sync.Map
+
+	// UA keys by IP with WithUAKeying, and session keys by IP, *ipKeys,
+	// forgotten every analyzer window
+	uaKeys      sync.Map
+	sessionKeys sync.Map
+
+	// Bots of WithCustomBot, verified before knownbots
+	customBots []customBot
+
+	// Crawl budgets of WithBotBudget, nil without, closed with the limiter
+	budgets *budgets
+
+	// Crawl-delays of WithRobots and WithCrawlDelay, nil without
+	crawlDelays *crawlDelays
+
 	// KnownBots validator (can be customized via option)
 	kb *knownbots.Validator
 
+	// Dataset of WithBotData, providing kb, closed with the limiter
+	botData *botdata.Data
+
+	// Verifications of kb cached with WithVerifyCache, nil without
+	verifyCache *verifyCache
+
+	// Retries of pending verifications, with WithPendingRetries
+	pending *pendingRetries
+
+	// Bots verified by RDNS with WithRDNS rather than kb, nil without,
+	// closed with the limiter
+	rdns *rdnsBots
+
 	// Behavior analyzer (always enabled)
 	analyzer *analyzer.Analyzer
+
+	// IPs and ranges that bypass every check
+	allow *prefixSet
+
+	// IPs and ranges that are always rejected
+	deny *prefixSet
+
+	// TLS client fingerprints rejected for normal users
+	denyTLS *tlsSet
+
+	// Paths that bypass analysis and limiting
+	skip *pathSet
+
+	// Login paths for brute-force detection
+	login *pathSet
+
+	// Trap paths that block on the first request
+	honeypot *pathSet
+
+	// Per-path thresholds and limits, nil without any
+	pathPolicies atomic.Pointer[pathPolicies]
+
+	logger *slog.Logger
+
+	// Request weighting, nil weighs every request 1
+	cost CostFunc
+
+	// Analysis and limiting key, nil keys on the IP
+	keyFunc KeyFunc
+
+	// HostNames of the hosts keyed apart, nil without isolation
+	isolated map[string]struct{}
+
+	// Client for WithAbuseIPDB, closed with the limiter
+	abuseipdb *abuseipdb.Client
+
+	// Checker for WithDNSBL, closed with the limiter
+	dnsbl *dnsbl.Checker
+
+	// Country policies, nil without a locator
+	geo *geoPolicy
+
+	// Datacenter policies, nil without an ASN locator or cloud ranges
+	datacenter *datacenterPolicy
+
+	// Ranges for WithCloudRanges, closed with the limiter
+	cloud *cloudranges.Ranges
+
+	// Exits for WithTor, closed with the limiter
+	tor *tor.Exits
+
+	// Channels of Subscribe, closed with the limiter
+	subscribers subscribers
+
+	// Hash of the configuration in effect, see ConfigHash
+	configHash atomic.Pointer[string]
+
+	// Log for WithAuditLog and WithAuditFile, closed with the limiter
+	audit *audit.Log
+
+	// Bot verification shared by a Manager, which closes it
+	shared bool
+
+	// Closed by Close to stop sweeping token buckets, and by the sweep
+	// once stopped; swept is nil without it
+	stop  chan struct{}
+	swept chan struct{}
+
+	counters counters
 }
 
-// New creates a new rate limiter with default config and applies options.
-func New(opts ...Option) (*Limiter, error) {
-	l := &Limiter{
-		cfg: Config{
-			Limit:         DefaultLimit,
-			Window:        DefaultWindow,
-			PageThreshold: DefaultPageThreshold,
-			QueueCap:      DefaultQueueCap,
-		},
+// defaultConfig returns the configuration options apply to.
+func defaultConfig() Config {
+	return Config{
+		Limit:         DefaultLimit,
+		Burst:         DefaultBurst,
+		Window:        DefaultWindow,
+		PageThreshold: DefaultPageThreshold,
+		QueueCap:      DefaultQueueCap,
+		BlockDuration: DefaultBlockDuration,
+		GreylistLimit: DefaultGreylistLimit,
+		GreylistBurst: DefaultGreylistBurst,
+		AIBotLimit:    DefaultAIBotLimit,
+		AIBotBurst:    DefaultAIBotBurst,
+		TorLimit:      DefaultTorLimit,
+		TorBurst:      DefaultTorBurst,
+
+		MaxUALength:        DefaultMaxUALength,
+		BadUAPageThreshold: DefaultBadUAPageThreshold,
+
+		LimiterIdle:     DefaultLimiterIdle,
+		SubscribeBuffer: DefaultSubscribeBuffer,
+		UAKeysPerIP:     DefaultUAKeysPerIP,
+		SessionsPerIP:   DefaultSessionsPerIP,
 	}
+}
+
+// New creates a new rate limiter with default config and applies options.
+func New(opts ...Option) (_ *Limiter, err error) {
+	l := &Limiter{cfg: defaultConfig()}
 
 	for _, opt := range opts {
 		opt(l)
 	}
 
-	if l.kb == nil {
-		kb, err := knownbots.New()
+	if l.logger == nil {
+		l.logger = logging.Discard()
+	}
+	if l.cfg.Clock == nil {
+		l.cfg.Clock = analyzer.SystemClock
+	}
+
+	// Stop the refreshes and close the files started so far should a
+	// later step fail
+	defer func() {
+		if err != nil {
+			l.release()
+		}
+	}()
+
+	allow, err := newPrefixSet(l.cfg.AllowCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	l.allow = allow
+
+	deny, err := newPrefixSet(l.cfg.DenyCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	l.deny = deny
+	l.denyTLS = newTLSSet(l.cfg.DenyTLSFingerprints)
+
+	customBots, err := newCustomBots(l.cfg.CustomBots)
+	if err != nil {
+		return nil, err
+	}
+	l.customBots = customBots
+
+	delays := make(map[string]time.Duration)
+	if l.cfg.Robots != "" {
+		data, err := os.ReadFile(l.cfg.Robots)
 		if err != nil {
 			return nil, err
 		}
-		l.kb = kb
+		delays = parseRobots(data)
+	}
+	for agent, d := range l.cfg.CrawlDelays {
+		delays[agent] = d
+	}
+	if len(delays) > 0 {
+		l.crawlDelays = &crawlDelays{agents: delays}
+	}
+
+	geo, err := newGeoPolicy(l.cfg.GeoLocator, &l.cfg)
+	if err != nil {
+		return nil, err
+	}
+	l.geo = geo
+
+	asnLocator := l.cfg.ASNLocator
+	if l.cfg.CloudRanges {
+		opts := append([]cloudranges.Option{cloudranges.WithLogger(l.logger)}, l.cfg.CloudRangesOptions...)
+		l.cloud = cloudranges.New(opts...)
+		l.cfg.Signals = append(l.cfg.Signals, l.cloud)
+	}
+	datacenter, err := newDatacenterPolicy(asnLocator, l.cloud, &l.cfg)
+	if err == nil && l.cfg.ASNPageThreshold > 0 && asnLocator == nil {
+		err = errors.New("botrate: an ASN page threshold needs WithASNLocator")
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.datacenter = datacenter
+
+	if l.cfg.SubnetPageThreshold > 0 || l.cfg.ASNPageThreshold > 0 {
+		var asn func(netip.Addr) uint32
+		if asnLocator != nil {
+			asn = func(addr netip.Addr) uint32 {
+				n, _ := asnLocator.ASN(addr)
+				return n
+			}
+		}
+		l.cfg.Signals = append(l.cfg.Signals, analyzer.NewSubnetSignal(l.cfg.SubnetPageThreshold, l.cfg.ASNPageThreshold, asn))
+	}
+
+	if l.cfg.Tor {
+		opts := append([]tor.Option{tor.WithLogger(l.logger)}, l.cfg.TorOptions...)
+		l.tor = tor.New(opts...)
+		if l.cfg.TorPolicy == TorChallenge {
+			l.cfg.defaultAction(ReasonTor, ActionChallenge)
+		}
+	}
+	if l.cfg.BadUAPolicy == BadUAChallenge {
+		l.cfg.defaultAction(ReasonBadUA, ActionChallenge)
+	}
+
+	skip, err := newPathSet(l.cfg.SkipPaths)
+	if err != nil {
+		return nil, err
+	}
+	l.skip = skip
+
+	for _, host := range l.cfg.IsolatedHosts {
+		if l.isolated == nil {
+			l.isolated = make(map[string]struct{}, len(l.cfg.IsolatedHosts))
+		}
+		l.isolated[analyzer.HostName(host)] = struct{}{}
+	}
+
+	login, err := newPathSet(l.cfg.LoginPaths)
+	if err != nil {
+		return nil, err
+	}
+	l.login = login
+
+	honeypot, err := newPathSet(l.cfg.HoneypotPaths)
+	if err != nil {
+		return nil, err
+	}
+	l.honeypot = honeypot
+
+	policies, err := newPathPolicies(l.cfg.PathPolicies)
+	if err != nil {
+		return nil, err
+	}
+	l.pathPolicies.Store(policies)
+
+	l.cfg.defaultLogin()
+	hash := l.cfg.hash()
+	l.configHash.Store(&hash)
+
+	if l.cfg.PendingRetries > 0 || l.cfg.PendingWindow > 0 {
+		if l.cfg.PendingFallback != knownbots.StatusVerified && l.cfg.PendingFallback != knownbots.StatusFailed {
+			return nil, errors.New("botrate: pending verifications fall back to verified or failed")
+		}
+		l.pending = newPendingRetries(l.cfg.PendingRetries, l.cfg.PendingWindow)
+	}
+
+	if err := l.openBots(); err != nil {
+		return nil, err
+	}
+
+	if l.cfg.VerifyCacheTTL > 0 {
+		l.verifyCache = newVerifyCache(l.cfg.VerifyCacheTTL, l.cfg.VerifyCacheSize)
+	}
+
+	if len(l.cfg.BotBudgets) > 0 {
+		budgets, err := newBudgets(l.cfg.BotBudgets, l.cfg.BotBudgetFile, l.logger)
+		if err != nil {
+			return nil, err
+		}
+		l.budgets = budgets
+	}
+
+	if l.cfg.AuditLog != nil || l.cfg.AuditFile != "" {
+		opts := append([]audit.Option{audit.WithConfigHash(hash), audit.WithLogger(l.logger)}, l.cfg.AuditOptions...)
+		if l.cfg.AuditFile != "" {
+			auditLog, err := audit.Open(l.cfg.AuditFile, opts...)
+			if err != nil {
+				return nil, err
+			}
+			l.audit = auditLog
+		} else {
+			l.audit = audit.New(l.cfg.AuditLog, opts...)
+		}
+		l.cfg.EventFuncs = append(l.cfg.EventFuncs, l.audit.Handle)
+	}
+
+	if l.cfg.AbuseIPDB != "" {
+		opts := append([]abuseipdb.Option{abuseipdb.WithLogger(l.logger)}, l.cfg.AbuseIPDBOptions...)
+		l.abuseipdb = abuseipdb.New(l.cfg.AbuseIPDB, opts...)
+		l.cfg.Signals = append(l.cfg.Signals, l.abuseipdb)
+		l.cfg.EventFuncs = append(l.cfg.EventFuncs, l.abuseipdb.Handle)
+	}
+
+	if l.cfg.DNSBL {
+		opts := append([]dnsbl.Option{dnsbl.WithLogger(l.logger)}, l.cfg.DNSBLOptions...)
+		l.dnsbl = dnsbl.New(opts...)
+		l.cfg.Signals = append(l.cfg.Signals, l.dnsbl)
 	}
 
 	l.analyzer = analyzer.New(analyzer.Config{
-		Window:        l.cfg.Window,
-		PageThreshold: l.cfg.PageThreshold,
-		QueueCap:      l.cfg.QueueCap,
+		Window:                 l.cfg.Window,
+		SlidingWindow:          l.cfg.SlidingWindow,
+		PageThreshold:          l.cfg.PageThreshold,
+		HyperLogLog:            l.cfg.HyperLogLog,
+		BloomCapacity:          l.cfg.BloomCapacity,
+		BloomFalsePositiveRate: l.cfg.BloomFPRate,
+		RequestThreshold:       l.cfg.RequestThreshold,
+		ErrorThreshold:         l.cfg.ErrorThreshold,
+		HeaderThreshold:        l.cfg.HeaderThreshold,
+		SequenceThreshold:      l.cfg.SequenceThreshold,
+		QueryThreshold:         l.cfg.QueryThreshold,
+		LoginThreshold:         l.cfg.thresholds().LoginThreshold,
+		QueueCap:               l.cfg.QueueCap,
+		Workers:                l.cfg.Workers,
+		Sync:                   l.cfg.SyncAnalyzer,
+		BlockDuration:          l.cfg.BlockDuration,
+		PenaltySchedule:        l.cfg.PenaltySchedule,
+		MaxBlocklist:           l.cfg.MaxBlocklist,
+		PrefixThreshold:        l.cfg.PrefixThreshold,
+		PrefixLenV4:            l.cfg.PrefixLenV4,
+		PrefixLenV6:            l.cfg.PrefixLenV6,
+		Store:                  l.cfg.Store,
+		IgnoreAssets:           l.cfg.IgnoreAssets,
+		PathNormalizer:         l.cfg.PathNormalizer,
+		Signals:                l.cfg.Signals,
+		ScoreThreshold:         l.cfg.ScoreThreshold,
+		GreylistThreshold:      l.cfg.GreylistThreshold,
+		OnGreylist:             l.cfg.OnGreylist,
+		OnEvent:                l.onEvent(),
+		OnWindow:               l.forgetKeys,
+		Clock:                  l.cfg.Clock,
+		Logger:                 l.logger,
 	})
 
+	cfg := l.cfg
+	l.live.Store(&cfg)
+
+	l.stop = make(chan struct{})
+	if l.cfg.LimiterIdle > 0 {
+		l.swept = make(chan struct{})
+		go l.sweepLimiters(l.cfg.Clock.NewTicker(l.cfg.LimiterIdle))
+	}
+
+	if l.cfg.Expvar != "" {
+		publishExpvar(l.cfg.Expvar, l)
+	}
+
 	return l, nil
 }
 
-// Allow reports whether the request should proceed.
+// openBots sets up bot verification: the knownbots dataset of
+// WithBotData, or knownbots.New without WithKnownbots, and the RDNS
+// verifier of WithRDNS.
+func (l *Limiter) openBots() error {
+	if l.cfg.BotData {
+		if l.kb != nil {
+			return errors.New("botrate: WithBotData and WithKnownbots are exclusive")
+		}
+		opts := append([]botdata.Option{botdata.WithLogger(l.logger)}, l.cfg.BotDataOptions...)
+		data, err := botdata.New(opts...)
+		if err != nil {
+			return err
+		}
+		l.botData = data
+		l.kb = data.Validator()
+	}
+	if l.cfg.RDNS {
+		root := botdata.DefaultRoot
+		if l.botData != nil {
+			root = l.botData.Root()
+		}
+		bots, err := newRDNSBots(root, l.cfg.RDNSOptions)
+		if err != nil {
+			if l.botData != nil {
+				l.botData.Close()
+				l.botData = nil
+			}
+			return err
+		}
+		l.rdns = bots
+	}
+	if l.kb == nil {
+		kb, err := knownbots.New()
+		if err != nil {
+			return err
+		}
+		l.kb = kb
+	}
+	return nil
+}
+
+// closeBots closes the bot dataset and the RDNS verifier, unless a
+// Manager shares them.
+func (l *Limiter) closeBots() {
+	if l.shared {
+		return
+	}
+	if l.botData != nil {
+		l.botData.Close()
+	}
+	if l.rdns != nil {
+		l.rdns.verifier.Close()
+	}
+}
+
+// config returns the configuration in effect.
+func (l *Limiter) config() *Config {
+	return l.live.Load()
+}
+
+// onEvent fans analyzer events out to the configured consumers and the
+// subscribers.
+func (l *Limiter) onEvent() func(analyzer.Event) {
+	fns := l.cfg.EventFuncs
+	return func(e analyzer.Event) {
+		for _, fn := range fns {
+			fn(e)
+		}
+		l.subscribers.publish(e)
+	}
+}
+
+// Allow reports whether a request from ua and ip for path should proceed.
+// The path feeds behavior analysis, which counts distinct pages per IP.
 // Returns:
 //   - allowed: true if allowed, false if blocked
 //   - reason: the reason for blocking when allowed is false
-func (l *Limiter) Allow(ua, ip string) (allowed bool, reason Reason) {
+func (l *Limiter) Allow(ua, ip, path string) (allowed bool, reason Reason) {
+	return l.AllowN(ua, ip, path, 1)
+}
+
+// AllowN is like Allow but the request consumes n tokens when the IP is
+// throttled. Requests costing more than the burst are never allowed for
+// throttled IPs.
+func (l *Limiter) AllowN(ua, ip, path string, n int) (allowed bool, reason Reason) {
+	if d := l.Check(Request{UA: ua, IP: ip, Path: path, Cost: n}); !d.Allowed {
+		return false, d.Reason
+	}
+	return true, ""
+}
+
+// Wait blocks until the request is allowed or the context is canceled.
+// A request it waits for is counted denied in Stats, as by Check.
+// Returns:
+//   - err: nil if allowed, the context error if it ends first, otherwise a
+//     *LimitError matching ErrLimit (denied, or the wait would exceed the
+//     context deadline)
+//   - reason: the reason for blocking (ReasonDenied, ReasonFakeBot or ReasonRateLimited)
+func (l *Limiter) Wait(ctx context.Context, ua, ip, path string) (err error, reason Reason) {
+	d, r := l.decide(Request{UA: ua, IP: ip, Path: path, Cost: 1}, true)
+	if d.Allowed {
+		return nil, ""
+	}
+	if r == nil || !r.OK() {
+		return &LimitError{Reason: d.Reason, RetryAfter: d.RetryAfter}, d.Reason
+	}
+
+	// Throttled: wait for the token reserved, in real time, whatever the
+	// clock
+	if err := ctx.Err(); err != nil {
+		r.CancelAt(l.cfg.Clock.Now())
+		return err, d.Reason
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(l.cfg.Clock.Now().Add(d.RetryAfter)) {
+		// Would exceed the deadline, so give the token back
+		r.CancelAt(l.cfg.Clock.Now())
+		return &LimitError{Reason: d.Reason, RetryAfter: d.RetryAfter}, d.Reason
+	}
+
+	t := time.NewTimer(d.RetryAfter)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil, ""
+	case <-ctx.Done():
+		r.CancelAt(l.cfg.Clock.Now())
+		return ctx.Err(), d.Reason
+	}
+}
+
+// check runs the layers in order and fills in d. A throttled request
+// gets the token bucket it is limited with, d.Allowed false and the
+// reason it is throttled; otherwise the bucket is nil and d.Allowed is
+// the final verdict.
+func (l *Limiter) check(req *Request, addr netip.Addr, d *Decision) *rate.Limiter {
+	cfg := l.config()
+	ua, ip := req.UA, req.IP
+
+	// Denylisted IPs are rejected before anything else
+	if l.deny.Contains(addr) {
+		d.Reason = ReasonDenied
+		return nil
+	}
+
+	// Allowlisted IPs, skipped paths, exempt users and bypassing clients
+	// skip every layer
+	if l.allow.Contains(addr) || l.skip.Match(req.Path) || (cfg.ExemptUsers && req.User != "") || req.Bypass {
+		d.Allowed = true
+		return nil
+	}
+
 	// Layer 1: Bot verification
-	botResult := l.kb.Validate(ua, ip)
+	botResult, custom := validateCustom(l.customBots, ua, addr)
+	if !custom {
+		botResult = l.validate(ua, ip, addr)
+	}
 
 	if botResult.IsBot {
+		l.retryPending(&botResult, ip)
+		d.Bot = botResult.BotName
+		d.BotKind = botResult.BotKind
+		d.Verification = botResult.Status
+
 		switch botResult.Status {
 		case knownbots.StatusVerified:
-			// Verified bot: allow without rate limit
-			return true, ""
+			// Verified bot: allow without rate limit, unless a bot
+			// policy or limit applies
+			return l.checkBot(botResult, ua, d)
 		case knownbots.StatusPending:
 			// RDNS lookup failed, allow and retry verification next time
-			return true, ""
+			l.logVerification(botResult, ua, ip)
+			return l.checkBot(botResult, ua, d)
 		case knownbots.StatusFailed, knownbots.StatusUnknown:
-			// Fake bot (failed verification) or unknown: block immediately
-			return false, ReasonFakeBot
+			// Fake bot (failed verification) or unknown: throttle with
+			// the fake bot limit, which blocks outright by default
+			l.logVerification(botResult, ua, ip)
+			l.subscribers.publish(analyzer.Event{
+				Type: analyzer.EventVerificationFailed,
+				Time: cfg.Clock.Now(),
+				IP:   ip,
+				Bot:  botResult.BotName,
+				UA:   ua,
+			})
+			d.Reason = ReasonFakeBot
+			if cfg.FakeBotLimit > 0 {
+				return l.getLimiter(&l.fakeBots, ip, cfg.FakeBotLimit, cfg.Burst)
+			}
+			return nil
+		}
+	}
+
+	// Scripts and headless clients betray themselves in their TLS
+	// handshake, whatever their UA
+	if l.denyTLS.Contains(req.TLSFingerprint) {
+		d.Reason = ReasonTLSFingerprint
+		return nil
+	}
+
+	// Country policies apply to normal users too
+	if l.geo != nil {
+		d.Country = l.geo.locator.Country(addr)
+		if l.geo.denied(d.Country) {
+			d.Reason = ReasonDenied
+			return nil
+		}
+	}
+
+	// Datacenter policies too
+	if l.datacenter != nil {
+		d.ASN, d.Cloud, d.Datacenter = l.datacenter.lookup(addr)
+		if d.Datacenter && l.datacenter.denyBrowsers && browser(ua) {
+			d.Reason = ReasonDatacenter
+			return nil
 		}
 	}
 
+	// Tor policies too
+	if l.tor != nil && l.tor.Contains(addr) {
+		d.Tor = true
+		if cfg.TorPolicy == TorChallenge || cfg.TorPolicy == TorBlock {
+			d.Reason = ReasonTor
+			return nil
+		}
+	}
+
+	// Empty, truncated and overlong UAs are no browser's
+	if cfg.BadUAPolicy != BadUAAllow {
+		d.BadUA = useragent.Validate(ua, cfg.MaxUALength)
+		if d.BadUA != useragent.NoFlaw && (cfg.BadUAPolicy == BadUAChallenge || cfg.BadUAPolicy == BadUABlock) {
+			d.Reason = ReasonBadUA
+			return nil
+		}
+	}
+
+	// Classes of UAs get their own thresholds
+	if len(cfg.UAClassPageThresholds) > 0 {
+		d.UAClass = useragent.Classify(ua)
+	}
+
+	// Normal users are analyzed and limited by key, the IP by default
+	client, byIP := l.clientKey(req)
+	key := l.hostKey(req, client)
+	d.Key = key
+
 	// Layer 2: Blocklist check (only for normal users)
-	if l.analyzer.Blocked(ip) {
+	if blocked, ok := l.blockedAs(key, client, ip, byIP); ok {
 		// Behavior anomaly: apply rate limit
-		if l.allowBlocked(ip) {
-			return true, ""
+		d.Blocklisted = true
+		d.Reason = ReasonRateLimited
+		return l.getLimiter(&l.blocked, blocked, cfg.Limit, cfg.Burst)
+	}
+
+	// Blocked prefix: its clients share the prefix's bucket
+	if prefix, ok := l.analyzer.BlockedPrefix(addr); ok {
+		d.Blocklisted = true
+		d.Reason = ReasonRateLimited
+		return l.getLimiter(&l.blocked, prefix, cfg.Limit, cfg.Burst)
+	}
+
+	// Honeypot: no human requests a trap path, block right away
+	if l.honeypot.Match(req.Path) {
+		if err := l.analyzer.Trap(key, req.Path); err != nil {
+			l.logger.Warn("botrate: failed to store block", "ip", key, "error", err)
 		}
-		return false, ReasonRateLimited
+		d.Blocklisted = true
+		d.Reason = ReasonRateLimited
+		return l.getLimiter(&l.blocked, key, cfg.Limit, cfg.Burst)
+	}
+
+	// Path policies apply to the rest
+	policy := l.pathPolicies.Load().match(req.Path)
+
+	// Greylist: suspicious, moderately limited while still analyzed, so
+	// it is blocked if the behavior continues
+	if l.analyzer.Greylisted(key) {
+		l.record(req, key, d, policy)
+		d.Greylisted = true
+		d.Reason = ReasonGreylisted
+		return l.getLimiter(&l.greylisted, key, cfg.GreylistLimit, cfg.GreylistBurst)
+	}
+
+	// Tor exits with TorLimit: throttled while still analyzed
+	if d.Tor && cfg.TorPolicy == TorLimit {
+		l.record(req, key, d, policy)
+		d.Reason = ReasonTor
+		return l.getLimiter(&l.torExits, key, cfg.TorLimit, cfg.TorBurst)
+	}
+
+	// Path policy with a limit: throttled while still analyzed
+	if policy != nil && policy.Limit > 0 {
+		l.record(req, key, d, policy)
+		d.Reason = ReasonPathLimited
+		return l.getLimiter(&policy.buckets, key, policy.Limit, policy.Burst)
 	}
 
 	// Layer 3: Normal user + not blocked
-	l.analyzer.Record(ip, ua)
-	return true, ""
+	l.record(req, key, d, policy)
+	d.Allowed = true
+	return nil
 }
 
-// Wait blocks until the request is allowed or the context is canceled.
-// Returns:
-//   - err: nil if allowed, otherwise the blocking error (context canceled/timeout or ErrLimit)
-//   - reason: the reason for blocking (ReasonFakeBot or ReasonRateLimited)
-func (l *Limiter) Wait(ctx context.Context, ua, ip string) (err error, reason Reason) {
-	// Layer 1: Bot verification
-	botResult := l.kb.Validate(ua, ip)
+// record feeds req to behavior analysis under key, with the threshold
+// for its user, or the lowest for its country, datacenter, UA class and
+// bad UA in d, or else that of the path's policy, which may be nil, and
+// the weight and threshold of its method.
+func (l *Limiter) record(req *Request, key string, d *Decision, policy *pathPolicy) {
+	cfg := l.config()
+	threshold := 0
+	if req.User != "" {
+		threshold = cfg.UserPageThreshold
+	} else {
+		if l.geo != nil {
+			threshold = l.geo.thresholds[d.Country]
+		}
+		if d.Datacenter && l.datacenter.threshold > 0 && (threshold <= 0 || l.datacenter.threshold < threshold) {
+			threshold = l.datacenter.threshold
+		}
+		if t := cfg.UAClassPageThresholds[d.UAClass]; t > 0 && (threshold <= 0 || t < threshold) {
+			threshold = t
+		}
+		if d.BadUA != useragent.NoFlaw && cfg.BadUAPolicy == BadUAScore {
+			if t := cfg.BadUAPageThreshold; t > 0 && (threshold <= 0 || t < threshold) {
+				threshold = t
+			}
+		}
+	}
+	if threshold <= 0 && policy != nil {
+		threshold = policy.PageThreshold
+	}
+	l.analyzer.RecordVisit(analyzer.Visit{
+		IP:              key,
+		Path:            req.Path,
+		Threshold:       threshold,
+		Headers:         req.Headers.Names,
+		Mismatch:        browser(req.UA) && req.Headers.mismatch(),
+		Weight:          cfg.MethodWeights[req.Method],
+		Method:          req.Method,
+		MethodThreshold: cfg.MethodThresholds[req.Method],
+	})
+}
 
-	if botResult.IsBot {
-		switch botResult.Status {
-		case knownbots.StatusVerified:
-			// Verified bot: no rate limit needed
-			return nil, ""
-		case knownbots.StatusPending:
-			// RDNS lookup failed, allow and retry verification next time
-			return nil, ""
-		case knownbots.StatusFailed, knownbots.StatusUnknown:
-			// Fake bot: block immediately
-			return ErrLimit, ReasonFakeBot
+// key returns the key req is analyzed and limited by: the KeyFunc's key,
+// the user, the session or the IP, with its UA with WithUAKeying, in that
+// order, for its host with WithHostIsolation.
+func (l *Limiter) key(req *Request) string {
+	client, _ := l.clientKey(req)
+	return l.hostKey(req, client)
+}
+
+// hostKey returns client's key on req's host, client itself unless the
+// host is isolated.
+func (l *Limiter) hostKey(req *Request, client string) string {
+	if l.isolated == nil || req.Host == "" {
+		return client
+	}
+	host := analyzer.HostName(req.Host)
+	if _, ok := l.isolated[host]; !ok {
+		return client
+	}
+	return analyzer.HostKey(host, client)
+}
+
+// blockedAs returns which of key, the client's key without its host and,
+// for clients keyed on their IP, the IP is blocked. Blocking the IP so
+// blocks the client on every host and with every UA, and a session whose
+// cookie was issued before the block doesn't escape it.
+func (l *Limiter) blockedAs(key, client, ip string, byIP bool) (string, bool) {
+	if l.analyzer.Blocked(key) {
+		return key, true
+	}
+	if client != key && l.analyzer.Blocked(client) {
+		return client, true
+	}
+	if byIP && ip != client && l.analyzer.Blocked(ip) {
+		return ip, true
+	}
+	return "", false
+}
+
+// clientKey returns the key of req's client, see key, and whether it
+// stands for the IP's client, so blocks of the IP apply to it.
+func (l *Limiter) clientKey(req *Request) (string, bool) {
+	if l.keyFunc != nil {
+		if key := l.keyFunc(req.UA, req.IP, req.HTTP); key != "" {
+			return key, false
 		}
 	}
+	if req.User != "" {
+		return userKeyPrefix + req.User, false
+	}
+	if req.Session != "" {
+		if key, ok := l.sessionKey(req); ok {
+			return key, true
+		}
+	}
+	if l.cfg.UAKeying {
+		return l.uaKey(req), true
+	}
+	return req.IP, true
+}
 
-	// Layer 2: Blocklist check (only for normal users)
-	if l.analyzer.Blocked(ip) {
-		// Behavior anomaly: apply rate limit
-		err = l.waitBlocked(ctx, ip)
-		if err != nil {
-			// Context canceled/timeout while waiting
-			return err, ReasonRateLimited
+// Cost returns how many tokens a request to path with method consumes,
+// as set by WithCostFunc. It is at least 1.
+func (l *Limiter) Cost(path, method string) int {
+	if l.cost == nil {
+		return 1
+	}
+	if n := l.cost(path, method); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// checkBot applies the AI bot policies, then the crawl budgets, the bot
+// limits and Crawl-delays, to a bot passing verification, or pending it,
+// allowing it if none applies.
+func (l *Limiter) checkBot(res knownbots.Result, ua string, d *Decision) *rate.Limiter {
+	cfg := l.config()
+	action, _ := aiBotPolicy(cfg.AIBotPolicies).action(res)
+	switch action {
+	case AIBotDeny:
+		d.Reason = ReasonAIBot
+		return nil
+	case AIBotLimit:
+		d.Reason = ReasonAIBot
+		return l.getLimiter(&l.aiBots, res.BotName, cfg.AIBotLimit, cfg.AIBotBurst)
+	}
+
+	if l.budgets != nil {
+		if wait, ok := l.budgets.take(res.BotName, cfg.Clock.Now()); !ok {
+			d.Reason = ReasonBotQuota
+			d.RetryAfter = wait
+			return nil
 		}
-		// Rate limit hit (wait returned without error but context still active)
-		return ErrLimit, ReasonRateLimited
 	}
 
-	// Layer 3: Normal user + not blocked
-	l.analyzer.Record(ip, ua)
-	return nil, ""
+	limit, ok := cfg.BotLimits[res.BotName]
+	if !ok {
+		limit, ok = cfg.BotLimits[""]
+	}
+	reason := ReasonBotLimited
+	if l.crawlDelays != nil {
+		// The Crawl-delay applies unless the bot limit is stricter
+		if delay := l.crawlDelays.delay(res.BotName, ua); delay > 0 && (!ok || rate.Every(delay) < limit.Limit) {
+			limit, ok, reason = BotLimit{Limit: rate.Every(delay), Burst: 1}, true, ReasonCrawlDelay
+		}
+	}
+	if ok {
+		d.Reason = reason
+		return l.getLimiter(&l.bots, res.BotName, limit.Limit, limit.Burst)
+	}
+
+	d.Allowed = true
+	return nil
 }
 
-func (l *Limiter) allowBlocked(ip string) bool {
-	limiter := l.getLimiter(ip)
-	return limiter.Allow()
+// retryPending counts pending verifications and, with
+// WithPendingRetries, falls back once their retries are exhausted.
+func (l *Limiter) retryPending(res *knownbots.Result, ip string) {
+	switch res.Status {
+	case knownbots.StatusPending:
+		l.counters.pending.Add(1)
+		if l.pending != nil && !l.pending.retry(res.BotName, ip, l.cfg.Clock.Now()) {
+			l.counters.pendingExhausted.Add(1)
+			res.Status = l.cfg.PendingFallback
+		}
+	case knownbots.StatusVerified, knownbots.StatusFailed:
+		if l.pending != nil {
+			l.pending.settle(res.BotName, ip)
+		}
+	}
 }
 
-func (l *Limiter) waitBlocked(ctx context.Context, ip string) error {
-	limiter := l.getLimiter(ip)
-	return limiter.Wait(ctx)
+// validate verifies ua from ip with knownbots, through the verification
+// cache of WithVerifyCache if any.
+func (l *Limiter) validate(ua, ip string, addr netip.Addr) knownbots.Result {
+	if l.verifyCache != nil {
+		if res, ok := l.verifyCache.get(ua, ip, l.cfg.Clock); ok {
+			return res
+		}
+	}
+
+	res, ok := knownbots.Result{}, false
+	if l.rdns != nil {
+		res, ok = l.rdns.validate(ua, ip)
+	}
+	if !ok {
+		res = l.kb.Validate(ua, ip)
+	}
+	if res.IsBot && res.Status != knownbots.StatusVerified && l.botData != nil && l.botData.Contains(res.BotName, addr) {
+		// In the ranges botrate fetched rather than knownbots
+		res.Status = knownbots.StatusVerified
+	}
+	if res.IsBot && l.verifyCache != nil {
+		l.verifyCache.put(ua, ip, res, l.cfg.Clock)
+	}
+	return res
+}
+
+func (l *Limiter) logVerification(res knownbots.Result, ua, ip string) {
+	if res.Status == knownbots.StatusPending {
+		l.logger.Debug("botrate: bot verification pending", "bot", res.BotName, "ua", ua, "ip", ip)
+		return
+	}
+	l.logger.Debug("botrate: bot verification failed", "bot", res.BotName, "ua", ua, "ip", ip)
 }
 
-func (l *Limiter) getLimiter(ip string) *rate.Limiter {
-	if val, ok := l.blocked.Load(ip); ok {
-		return val.(*rate.Limiter)
+func (l *Limiter) getLimiter(m *sync.Map, ip string, limit rate.Limit, burst int) *rate.Limiter {
+	now := l.cfg.Clock.Now().UnixNano()
+	if val, ok := m.Load(ip); ok {
+		bucket := val.(*tokenBucket)
+		bucket.used.Store(now)
+		return bucket.Limiter
 	}
-	limiter := rate.NewLimiter(l.cfg.Limit, 1) // Burst=1 for strict blocking
-	actual, _ := l.blocked.LoadOrStore(ip, limiter)
-	return actual.(*rate.Limiter)
+	bucket := &tokenBucket{Limiter: rate.NewLimiter(limit, burst)}
+	bucket.used.Store(now)
+	actual, loaded := m.LoadOrStore(ip, bucket)
+	if loaded {
+		bucket = actual.(*tokenBucket)
+		bucket.used.Store(now)
+	} else {
+		l.counters.limiters.Add(1)
+	}
+	return bucket.Limiter
+}
+
+// RecordResponse records the status of a response to a normal user, so
+// analysis can detect 404 storms, brute forcing and fuzzers triggering
+// errors. ip is the key the request was analyzed by, i.e. Decision.Key;
+// the middleware records every analyzed response.
+func (l *Limiter) RecordResponse(ip string, status int) {
+	ip, _ = canonicalIP(ip)
+	l.analyzer.RecordResponse(ip, status)
+}
+
+// RecordLogin records the status of a response to a request for path, so
+// analysis can block brute forcing and credential stuffing after a few
+// failed logins, see WithLoginPaths. It reports whether the response
+// counted as a failed login: path matches a login path and status is
+// one of the login failure statuses. ip is the key the request was
+// analyzed by, like for RecordResponse.
+func (l *Limiter) RecordLogin(ip, path string, status int) bool {
+	if !l.login.Match(path) || !slices.Contains(l.config().LoginStatuses, status) {
+		return false
+	}
+	ip, _ = canonicalIP(ip)
+	l.analyzer.RecordLoginFailure(ip, status)
+	return true
+}
+
+// Block manually blocks ip for d. A d <= 0 blocks forever.
+// Blocked IPs are rate limited like IPs flagged by behavior analysis.
+// With WithKeyFunc, ip is a key; only IPs are canonicalized.
+func (l *Limiter) Block(ip string, d time.Duration) error {
+	ip, _ = canonicalIP(ip)
+	return l.analyzer.Block(ip, d)
+}
+
+// Unblock removes ip from the blocklist and drops its token bucket. A
+// prefix of WithPrefixThreshold, e.g. "203.0.113.0/24", removes the
+// prefix block.
+func (l *Limiter) Unblock(ip string) error {
+	ip, _ = canonicalIP(ip)
+	if prefix, err := netip.ParsePrefix(ip); err == nil {
+		ip = prefix.Masked().String()
+	}
+	if err := l.analyzer.Unblock(ip); err != nil {
+		return err
+	}
+	for _, m := range []*sync.Map{&l.blocked, &l.greylisted} {
+		if _, loaded := m.LoadAndDelete(ip); loaded {
+			l.counters.limiters.Add(-1)
+		}
+	}
+	return nil
+}
+
+// AddAllow adds an IP or CIDR range to the allowlist at runtime.
+func (l *Limiter) AddAllow(cidr string) error {
+	return l.allow.Add(cidr)
+}
+
+// AddDeny adds an IP or CIDR range to the denylist at runtime.
+func (l *Limiter) AddDeny(cidr string) error {
+	return l.deny.Add(cidr)
+}
+
+// Denylisted reports whether ip is on the denylist.
+func (l *Limiter) Denylisted(ip string) bool {
+	_, addr := canonicalIP(ip)
+	return l.deny.Contains(addr)
+}
+
+// Blocklist returns the currently blocked IPs, and prefixes of
+// WithPrefixThreshold.
+func (l *Limiter) Blocklist() []analyzer.Entry {
+	var entries []analyzer.Entry
+	l.analyzer.Range(func(ip string, until time.Time) bool {
+		entries = append(entries, analyzer.Entry{IP: ip, Until: until})
+		return true
+	})
+	l.analyzer.RangePrefixes(func(prefix netip.Prefix, until time.Time) bool {
+		entries = append(entries, analyzer.Entry{IP: prefix.String(), Until: until})
+		return true
+	})
+	return entries
+}
+
+// Events returns recent block events, newest first.
+func (l *Limiter) Events() []analyzer.Event {
+	return l.analyzer.Events()
+}
+
+// Config returns the limiter configuration in effect.
+func (l *Limiter) Config() Config {
+	return *l.config()
+}
+
+// ConfigHash returns a short hex hash of the settings deciding what is
+// limited and blocked, the same for limiters configured alike, e.g. to
+// tell which configuration an instance or an audit record ran with.
+func (l *Limiter) ConfigHash() string {
+	return *l.configHash.Load()
+}
+
+// Flush blocks until the requests and responses recorded so far have been
+// analyzed and the resulting blocks applied, or ctx is done. Call it
+// before Close to persist the final blocks to the store.
+func (l *Limiter) Flush(ctx context.Context) error {
+	return l.analyzer.Flush(ctx)
 }
 
 // Close gracefully shuts down the limiter and releases resources.
 func (l *Limiter) Close() {
+	if l.cfg.Expvar != "" {
+		unpublishExpvar(l.cfg.Expvar, l)
+	}
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+	if l.swept != nil {
+		<-l.swept
+	}
 	l.analyzer.Close()
+	l.subscribers.close()
+	l.release()
 
-	l.blocked.Range(func(key, value any) bool {
-		l.blocked.Delete(key)
-		return true
-	})
+	buckets := []*sync.Map{&l.blocked, &l.fakeBots, &l.greylisted, &l.torExits, &l.aiBots, &l.bots}
+	for _, m := range append(buckets, l.pathPolicies.Load().buckets()...) {
+		m.Range(func(key, value any) bool {
+			if _, loaded := m.LoadAndDelete(key); loaded {
+				l.counters.limiters.Add(-1)
+			}
+			return true
+		})
+	}
+}
+
+// release closes the clients, files and refreshes opened by New, those
+// it opened so far when it fails.
+func (l *Limiter) release() {
+	if l.abuseipdb != nil {
+		l.abuseipdb.Close()
+	}
+	if l.dnsbl != nil {
+		l.dnsbl.Close()
+	}
+	if l.audit != nil {
+		if err := l.audit.Close(); err != nil {
+			l.logger.Warn("botrate: failed to close audit log", "error", err)
+		}
+	}
+	if l.budgets != nil {
+		if err := l.budgets.close(); err != nil {
+			l.logger.Warn("botrate: failed to save crawl budgets", "error", err)
+		}
+	}
+	if l.cloud != nil {
+		l.cloud.Close()
+	}
+	if l.tor != nil {
+		l.tor.Close()
+	}
+	l.closeBots()
 }
@@ -0,0 +1,212 @@
+package botrate
+
+import (
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/internal/logging"
+	"github.com/cnlangzi/knownbots"
+)
+
+// Manager maintains a Limiter per tenant, such as each customer domain
+// of a SaaS platform, registered with Configure. Tenants are analyzed,
+// blocked and limited independently, but share bot verification: the
+// knownbots dataset with its refreshes, and the RDNS verifier of WithRDNS
+// with its workers. Tenants not configured share a default limiter.
+type Manager struct {
+	// Options of every tenant
+	opts []Option
+
+	// Bot verification shared by the limiters, closed with the manager
+	kb      *knownbots.Validator
+	botData *botdata.Data
+	rdns    *rdnsBots
+
+	// Limiters by tenant
+	limiters sync.Map
+
+	// Serializes creating, configuring and removing limiters
+	mu sync.Mutex
+
+	// Options of Configure by tenant
+	overrides map[string][]Option
+
+	// Limiter of the tenants not configured
+	def *Limiter
+
+	closed bool
+}
+
+// NewManager creates a manager whose tenants' limiters are created with
+// opts, then the options of Configure, and the default limiter with
+// opts. Bot verification options, like
+// WithBotData, WithRDNS and WithKnownbots, set up the verification the
+// tenants share. Options opening per-limiter resources, like
+// WithStore, WithAuditFile and WithExpvar, belong in Configure.
+func NewManager(opts ...Option) (*Manager, error) {
+	scratch := &Limiter{cfg: defaultConfig()}
+	for _, opt := range opts {
+		opt(scratch)
+	}
+	if scratch.logger == nil {
+		scratch.logger = logging.Discard()
+	}
+	if err := scratch.openBots(); err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		opts:      opts,
+		kb:        scratch.kb,
+		botData:   scratch.botData,
+		rdns:      scratch.rdns,
+		overrides: make(map[string][]Option),
+	}
+	def, err := m.newLimiter(nil)
+	if err != nil {
+		scratch.closeBots()
+		return nil, err
+	}
+	m.def = def
+	return m, nil
+}
+
+// newLimiter creates a limiter with the options of every tenant, then
+// overrides, sharing bot verification.
+func (m *Manager) newLimiter(overrides []Option) (*Limiter, error) {
+	return New(slices.Concat(m.opts, overrides, []Option{m.share})...)
+}
+
+// share is the option making a limiter use the manager's bot
+// verification.
+func (m *Manager) share(l *Limiter) {
+	l.kb, l.botData, l.rdns = m.kb, m.botData, m.rdns
+	l.cfg.BotData, l.cfg.RDNS = false, false
+	l.shared = true
+}
+
+// For returns the limiter of tenant, e.g. the request's host, if
+// configured, else the default limiter. Tenants only get a limiter of
+// their own with Configure, as each runs its own analyzer: one per
+// made-up Host header would let clients exhaust goroutines and memory.
+func (m *Manager) For(tenant string) *Limiter {
+	if l, ok := m.limiters.Load(tenant); ok {
+		return l.(*Limiter)
+	}
+	return m.def
+}
+
+// Configure sets the options of tenant, applied after those of every
+// tenant, replacing the previous ones. A tenant without a limiter gets
+// one right away. One with a limiter keeps it, and so what it learned,
+// with the configuration applied as by Limiter.ApplyConfig: settings
+// ApplyConfig ignores take Remove first.
+func (m *Manager) Configure(tenant string, opts ...Option) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrManagerClosed
+	}
+
+	if l, ok := m.limiters.Load(tenant); ok {
+		// Built as by newLimiter, so the configuration applied uses the
+		// shared bot verification as well
+		scratch := &Limiter{cfg: defaultConfig()}
+		for _, opt := range slices.Concat(m.opts, opts, []Option{m.share}) {
+			opt(scratch)
+		}
+		if err := l.(*Limiter).ApplyConfig(scratch.cfg); err != nil {
+			return err
+		}
+	} else {
+		l, err := m.newLimiter(opts)
+		if err != nil {
+			return err
+		}
+		m.limiters.Store(tenant, l)
+	}
+	m.overrides[tenant] = opts
+	return nil
+}
+
+// Remove closes the limiter of tenant, if any, and forgets its options,
+// e.g. when a customer leaves. For then returns the default limiter.
+func (m *Manager) Remove(tenant string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.overrides, tenant)
+	if l, ok := m.limiters.LoadAndDelete(tenant); ok {
+		l.(*Limiter).Close()
+	}
+}
+
+// Tenants returns the tenants configured, sorted.
+func (m *Manager) Tenants() []string {
+	var tenants []string
+	m.limiters.Range(func(key, _ any) bool {
+		tenants = append(tenants, key.(string))
+		return true
+	})
+	sort.Strings(tenants)
+	return tenants
+}
+
+// Stats returns the sum of the stats of the tenants' limiters and the
+// default limiter.
+func (m *Manager) Stats() Stats {
+	total := Stats{Denied: make(map[Reason]uint64)}
+	add := func(l *Limiter) {
+		s := l.Stats()
+		total.Requests += s.Requests
+		for reason, n := range s.Denied {
+			total.Denied[reason] += n
+		}
+		total.Blocklist += s.Blocklist
+		total.Greylist += s.Greylist
+		total.QueueLen += s.QueueLen
+		total.QueueCap += s.QueueCap
+		total.Dropped += s.Dropped
+		total.Evicted += s.Evicted
+		total.Prefixes += s.Prefixes
+		total.PrefixBlocks += s.PrefixBlocks
+		total.Limiters += s.Limiters
+		total.VerifyCacheHits += s.VerifyCacheHits
+		total.VerifyCacheMisses += s.VerifyCacheMisses
+		total.Pending += s.Pending
+		total.PendingExhausted += s.PendingExhausted
+	}
+
+	add(m.def)
+	m.limiters.Range(func(_, value any) bool {
+		add(value.(*Limiter))
+		return true
+	})
+	return total
+}
+
+// Close closes the tenants' limiters, the default limiter and the shared
+// bot verification.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return
+	}
+	m.closed = true
+
+	m.limiters.Range(func(key, value any) bool {
+		m.limiters.Delete(key)
+		value.(*Limiter).Close()
+		return true
+	})
+	m.def.Close()
+	if m.botData != nil {
+		m.botData.Close()
+	}
+	if m.rdns != nil {
+		m.rdns.verifier.Close()
+	}
+}
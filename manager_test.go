@@ -0,0 +1,124 @@
+package botrate
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestManager(t *testing.T) {
+	m, err := NewManager(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(3),
+	)
+	if err != nil {
+		t.Fatalf("NewManager() returned error: %v", err)
+	}
+	defer m.Close()
+
+	for _, tenant := range []string{"a.example.com", "b.example.com"} {
+		if err := m.Configure(tenant); err != nil {
+			t.Fatalf("Configure() returned error: %v", err)
+		}
+	}
+	a, b := m.For("a.example.com"), m.For("b.example.com")
+	if a == b || m.For("a.example.com") != a {
+		t.Fatal("expected one limiter per tenant")
+	}
+	if a.kb != b.kb {
+		t.Error("tenants should share bot verification")
+	}
+
+	// Tenants not configured share the default limiter
+	def := m.For("c.example.com")
+	if def == a || def == b || m.For("d.example.com") != def {
+		t.Fatal("expected the default limiter for tenants not configured")
+	}
+
+	// Tenants are analyzed independently
+	const ua = "Mozilla/5.0"
+	for _, path := range []string{"/a", "/b", "/c"} {
+		a.Allow(ua, "1.2.3.4", path)
+	}
+	if !a.analyzer.Blocked("1.2.3.4") || b.analyzer.Blocked("1.2.3.4") || def.analyzer.Blocked("1.2.3.4") {
+		t.Error("IP should be blocked on its tenant only")
+	}
+	b.Allow(ua, "1.2.3.4", "/a")
+	def.Allow(ua, "1.2.3.4", "/a")
+
+	if got := m.Tenants(); !slices.Equal(got, []string{"a.example.com", "b.example.com"}) {
+		t.Errorf("unexpected tenants %v", got)
+	}
+	s := m.Stats()
+	if s.Requests != 5 || s.Blocklist != 1 || s.Denied[ReasonRateLimited] != 0 {
+		t.Errorf("unexpected aggregate stats %+v", s)
+	}
+
+	m.Remove("a.example.com")
+	if got := m.Tenants(); !slices.Equal(got, []string{"b.example.com"}) {
+		t.Errorf("unexpected tenants after Remove %v", got)
+	}
+	if m.For("a.example.com") != def {
+		t.Error("removed tenant should get the default limiter")
+	}
+}
+
+func TestManager_Configure(t *testing.T) {
+	m, err := NewManager(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(10),
+	)
+	if err != nil {
+		t.Fatalf("NewManager() returned error: %v", err)
+	}
+
+	if err := m.Configure("api.example.com", WithAnalyzerPageThreshold(2)); err != nil {
+		t.Fatalf("Configure() returned error: %v", err)
+	}
+	l := m.For("api.example.com")
+	if got := l.Config().PageThreshold; got != 2 {
+		t.Errorf("expected the tenant's threshold 2, got %d", got)
+	}
+	if got := m.For("www.example.com").Config().PageThreshold; got != 10 {
+		t.Errorf("expected the threshold of every tenant 10, got %d", got)
+	}
+
+	// Configuring a tenant again keeps its limiter and state
+	const ua = "Mozilla/5.0"
+	l.Allow(ua, "1.2.3.4", "/a")
+	if err := m.Configure("api.example.com", WithAnalyzerPageThreshold(2), WithLimit(rate.Inf)); err != nil {
+		t.Fatalf("Configure() returned error: %v", err)
+	}
+	if m.For("api.example.com") != l {
+		t.Fatal("configured tenant should keep its limiter")
+	}
+	l.Allow(ua, "1.2.3.4", "/b")
+	if !l.analyzer.Blocked("1.2.3.4") {
+		t.Error("IP should be blocked with the pages counted before")
+	}
+	if got := l.Config().Limit; got != rate.Inf {
+		t.Errorf("expected the new limit, got %v", got)
+	}
+	if cfg := l.Config(); cfg.BotData || cfg.RDNS {
+		t.Error("configured tenant should keep the shared bot verification")
+	}
+
+	if err := m.Configure("bad.example.com", WithDenyCIDRs([]string{"not a cidr"})); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+
+	m.Close()
+	if err := m.Configure("api.example.com"); err != ErrManagerClosed {
+		t.Errorf("expected ErrManagerClosed, got %v", err)
+	}
+}
+
+func TestNewManager_Invalid(t *testing.T) {
+	if _, err := NewManager(WithKnownbots(testbots.New(t)), WithSkipPaths("/[a")); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
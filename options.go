@@ -1,8 +1,20 @@
 package botrate
 
 import (
+	"io"
+	"log/slog"
+	"strings"
 	"time"
 
+	"github.com/cnlangzi/botrate/abuseipdb"
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/audit"
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/cloudranges"
+	"github.com/cnlangzi/botrate/dnsbl"
+	"github.com/cnlangzi/botrate/rdns"
+	"github.com/cnlangzi/botrate/tor"
+	"github.com/cnlangzi/botrate/useragent"
 	"github.com/cnlangzi/knownbots"
 	"golang.org/x/time/rate"
 )
@@ -11,12 +23,48 @@ import (
 type Option func(*Limiter)
 
 // WithLimit sets events per second for rate limiting.
+// It is the same as WithBehaviorLimit.
 func WithLimit(limit rate.Limit) Option {
+	return WithBehaviorLimit(limit)
+}
+
+// WithBehaviorLimit sets events per second for IPs blocked by behavior
+// analysis or manually.
+func WithBehaviorLimit(limit rate.Limit) Option {
 	return func(l *Limiter) {
 		l.cfg.Limit = limit
 	}
 }
 
+// WithFakeBotLimit sets events per second for fake bots, i.e. clients
+// claiming a known bot UA that failed verification. Zero (the default)
+// rejects them outright.
+func WithFakeBotLimit(limit rate.Limit) Option {
+	return func(l *Limiter) {
+		l.cfg.FakeBotLimit = limit
+	}
+}
+
+// WithBurst sets the token bucket burst for blocked IPs, i.e. how many
+// requests a blocked IP may make at once before being limited to Limit.
+func WithBurst(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.Burst = n
+	}
+}
+
+// CostFunc returns how many tokens a request to path with method consumes.
+type CostFunc func(path, method string) int
+
+// WithCostFunc sets how requests are weighted, so heavyweight endpoints
+// (search, export) consume more of a throttled IP's budget than cached
+// pages. See Limiter.Cost. By default every request costs 1.
+func WithCostFunc(fn CostFunc) Option {
+	return func(l *Limiter) {
+		l.cost = fn
+	}
+}
+
 // WithAnalyzerWindow sets analysis window duration.
 func WithAnalyzerWindow(window time.Duration) Option {
 	return func(l *Limiter) {
@@ -24,6 +72,16 @@ func WithAnalyzerWindow(window time.Duration) Option {
 	}
 }
 
+// WithAnalyzerSlidingWindow makes analysis counts decay smoothly instead
+// of resetting at the end of each window, so a bot cannot stay just below
+// the thresholds in every window forever. The previous window's counts
+// are added, weighted down from 1 to 0 over the current window.
+func WithAnalyzerSlidingWindow(enabled bool) Option {
+	return func(l *Limiter) {
+		l.cfg.SlidingWindow = enabled
+	}
+}
+
 // WithAnalyzerPageThreshold sets max distinct pages threshold.
 func WithAnalyzerPageThreshold(threshold int) Option {
 	return func(l *Limiter) {
@@ -31,6 +89,113 @@ func WithAnalyzerPageThreshold(threshold int) Option {
 	}
 }
 
+// WithAnalyzerHyperLogLog estimates distinct pages per IP with a
+// HyperLogLog sketch instead of the bloom filter and counter, which is
+// not distorted by bloom false positives. It costs 256 bytes per IP, for
+// an error of a few percent.
+func WithAnalyzerHyperLogLog(enabled bool) Option {
+	return func(l *Limiter) {
+		l.cfg.HyperLogLog = enabled
+	}
+}
+
+// WithBloom sizes the bloom filter deduplicating pages: capacity is the
+// number of distinct IP and page pairs expected per window, fpRate the
+// rate at which a new page is mistaken for a revisit. High-traffic sites
+// need a larger capacity to keep the rate; memory grows with both.
+// Defaults to analyzer.BloomMaxCapacity and analyzer.BloomFalsePositiveRate.
+func WithBloom(capacity uint, fpRate float64) Option {
+	return func(l *Limiter) {
+		l.cfg.BloomCapacity = capacity
+		l.cfg.BloomFPRate = fpRate
+	}
+}
+
+// WithAnalyzerRequestThreshold sets max requests per window, distinct
+// pages or not, so a bot hammering one URL is blocked too. Zero (the
+// default) disables the check.
+func WithAnalyzerRequestThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.RequestThreshold = threshold
+	}
+}
+
+// WithAnalyzerErrorThreshold sets max error responses (status >= 400) per
+// window, fed by Limiter.RecordResponse, to catch 404 storms, brute
+// forcing and fuzzers. Zero (the default) disables the check.
+func WithAnalyzerErrorThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.ErrorThreshold = threshold
+	}
+}
+
+// WithHeaderThreshold sets max requests per window from browser UAs
+// without the Accept, Accept-Language or Accept-Encoding headers that
+// browsers send, see Request.Headers: scripts faking a browser's UA
+// rarely fake its headers too. Requests without a fingerprint, e.g.
+// from Allow, are not counted. Zero (the default) disables the check.
+func WithHeaderThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.HeaderThreshold = threshold
+	}
+}
+
+// WithSequenceThreshold sets max sequential IDs per window, like
+// /item/1001 then /item/1002, see analyzer.Config.SequenceThreshold:
+// scrapers enumerating IDs stay below the page threshold at low rates,
+// or count as one page with analyzer.NormalizePath. Readers paging
+// through a listing step through IDs too, so set it above the pages
+// they read. Zero (the default) disables the check.
+func WithSequenceThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.SequenceThreshold = threshold
+	}
+}
+
+// WithQueryThreshold sets max distinct query strings of a page per
+// window, see analyzer.Config.QueryThreshold: parameter fuzzers and
+// cache-busting scrapers hit one page with a new query string every
+// time, which counts as a single page once the query is stripped. The
+// path normalizer must strip the query, as the default does. Zero (the
+// default) disables the check.
+func WithQueryThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.QueryThreshold = threshold
+	}
+}
+
+// WithMethodWeight counts requests with methods, e.g. "POST", as weight
+// requests in the analysis, as pages when distinct and toward
+// WithAnalyzerRequestThreshold, so that write-path abuse trips the
+// thresholds sooner than reading. Requests count as 1 otherwise.
+func WithMethodWeight(weight int, methods ...string) Option {
+	return func(l *Limiter) {
+		if l.cfg.MethodWeights == nil {
+			l.cfg.MethodWeights = make(map[string]int)
+		}
+		for _, m := range methods {
+			l.cfg.MethodWeights[strings.ToUpper(m)] = weight
+		}
+	}
+}
+
+// WithMethodThreshold blocks normal users at threshold requests per
+// window with methods, weighted by WithMethodWeight, e.g. a few spam
+// submissions or signups, see analyzer.Visit.MethodThreshold. The
+// requests of methods with a threshold are counted together, against
+// the threshold of the latest one's method. They still count as pages
+// and requests too.
+func WithMethodThreshold(threshold int, methods ...string) Option {
+	return func(l *Limiter) {
+		if l.cfg.MethodThresholds == nil {
+			l.cfg.MethodThresholds = make(map[string]int)
+		}
+		for _, m := range methods {
+			l.cfg.MethodThresholds[strings.ToUpper(m)] = threshold
+		}
+	}
+}
+
 // WithAnalyzerQueueCap sets event queue capacity.
 func WithAnalyzerQueueCap(cap int) Option {
 	return func(l *Limiter) {
@@ -38,9 +203,801 @@ func WithAnalyzerQueueCap(cap int) Option {
 	}
 }
 
+// WithAnalyzerWorkers sets the number of analyzer workers, each with its
+// own queue, bloom filter and counters for a share of the IPs. Raise it
+// when Stats().Dropped grows at high request rates. Defaults to 1.
+func WithAnalyzerWorkers(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.Workers = n
+	}
+}
+
+// WithMaxBlocklist bounds the clients blocked at once to n, and those
+// greylisted, so memory stays bounded under a botnet: past it, new blocks
+// evict the expired ones, then those ending soonest. Evictions are
+// counted in Stats().Evicted. It bounds the default in-memory blocklist,
+// not a WithStore one. Zero (the default) is no
+// bound.
+func WithMaxBlocklist(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.MaxBlocklist = n
+	}
+}
+
+// WithPrefixThreshold blocks the /24 of an IPv4, or the /48 of an IPv6,
+// once n of its IPs were blocked in a window by analysis or honeypots:
+// botnets rotating addresses within a provider's range outpace blocking
+// them one by one. The prefix is blocked for the block duration, its
+// clients throttled together with WithLimit as a single blocked client.
+// Prefix blocks are counted in Stats and kept in memory, whatever the
+// store; Unblock takes the prefix, e.g. "203.0.113.0/24". Zero (the
+// default) disables them.
+func WithPrefixThreshold(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.PrefixThreshold = n
+	}
+}
+
+// WithPrefixLen sets the prefix lengths of WithPrefixThreshold, for IPv4
+// and IPv6. Defaults to analyzer.DefaultPrefixLenV4 and
+// analyzer.DefaultPrefixLenV6, 24 and 48.
+func WithPrefixLen(v4, v6 int) Option {
+	return func(l *Limiter) {
+		l.cfg.PrefixLenV4, l.cfg.PrefixLenV6 = v4, v6
+	}
+}
+
+// WithLimiterIdle sets how long a client's token bucket stays unused
+// before it is removed, checked every d: once refilled, a new bucket
+// would replace it as is. Buckets of clients no longer blocked or
+// greylisted are removed then too. Defaults to DefaultLimiterIdle; a
+// d <= 0 keeps them until Unblock or Close.
+func WithLimiterIdle(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.cfg.LimiterIdle = d
+	}
+}
+
+// WithSyncAnalyzer analyzes requests inline instead of in the background,
+// so a block applies before Allow returns. Meant for deterministic tests:
+// it slows down every request.
+func WithSyncAnalyzer(enabled bool) Option {
+	return func(l *Limiter) {
+		l.cfg.SyncAnalyzer = enabled
+	}
+}
+
+// WithBlockDuration sets how long a flagged IP stays blocked.
+// Zero blocks forever.
+func WithBlockDuration(d time.Duration) Option {
+	return func(l *Limiter) {
+		l.cfg.BlockDuration = d
+	}
+}
+
+// WithPenaltySchedule escalates block durations for repeat offenders,
+// e.g. 5m, 1h, 24h: an IP's nth block lasts the nth duration, or the
+// last one for every block after. Offenses are forgotten once the last
+// duration has passed since a block ended, or when the IP is unblocked.
+// It overrides WithBlockDuration for blocks by analysis; Block still
+// takes an explicit duration.
+func WithPenaltySchedule(schedule []time.Duration) Option {
+	return func(l *Limiter) {
+		l.cfg.PenaltySchedule = schedule
+	}
+}
+
+// WithDryRun enables observe-only mode: every request is allowed, but
+// verification, analysis, stats and logs still reflect the decision that
+// would have been made.
+func WithDryRun(dryRun bool) Option {
+	return func(l *Limiter) {
+		l.cfg.DryRun = dryRun
+	}
+}
+
+// WithAction sets what to do with requests denied with reason, reported
+// as Decision.Action. Denied requests are rejected right away by default.
+func WithAction(reason Reason, action Action) Option {
+	return func(l *Limiter) {
+		if l.cfg.Actions == nil {
+			l.cfg.Actions = make(map[Reason]Action)
+		}
+		l.cfg.Actions[reason] = action
+	}
+}
+
+// WithBotBudget sets the crawl budget of the verified bot named bot, e.g.
+// "bingbot": requests requests per period, e.g. 24 * time.Hour. Periods
+// are aligned to UTC, so a day starts at midnight. Requests past the
+// budget are denied with ReasonBotQuota and a Decision.RetryAfter until
+// the next period. An empty bot sets the budget of verified bots without
+// their own, counted per bot. AI bot policies take precedence.
+func WithBotBudget(bot string, requests int, period time.Duration) Option {
+	return func(l *Limiter) {
+		if l.cfg.BotBudgets == nil {
+			l.cfg.BotBudgets = make(map[string]BotBudget)
+		}
+		l.cfg.BotBudgets[bot] = BotBudget{Requests: requests, Period: period}
+	}
+}
+
+// WithBotBudgetFile persists the crawl budgets consumed to a JSON file at
+// path, so restarts don't reset them. It is loaded by New, saved every
+// DefaultBudgetSaveInterval and by Close.
+func WithBotBudgetFile(path string) Option {
+	return func(l *Limiter) {
+		l.cfg.BotBudgetFile = path
+	}
+}
+
+// WithRobots enforces the Crawl-delay of the robots.txt file at path on
+// verified bots, turning the advisory delay into a limit: a bot's
+// requests, across its IPs, are limited to one per delay, and those
+// coming sooner are denied with ReasonCrawlDelay. A bot gets the delay of
+// the group with the longest user-agent token in its UA, or of "*". The
+// file is read by New, which fails if it can't be. A WithBotLimit
+// stricter than the delay takes precedence.
+func WithRobots(path string) Option {
+	return func(l *Limiter) {
+		l.cfg.Robots = path
+	}
+}
+
+// WithCrawlDelay is like WithRobots with a Crawl-delay set in code for
+// the robots.txt user-agent token agent, e.g. "bingbot" or "*". It takes
+// precedence over the file's.
+func WithCrawlDelay(agent string, delay time.Duration) Option {
+	return func(l *Limiter) {
+		if l.cfg.CrawlDelays == nil {
+			l.cfg.CrawlDelays = make(map[string]time.Duration)
+		}
+		l.cfg.CrawlDelays[strings.ToLower(agent)] = delay
+	}
+}
+
+// WithCustomBot registers a bot, e.g. a partner's crawler or an internal
+// monitor, verified like the knownbots ones without changing their
+// dataset: requests whose UA contains the word ua, e.g. "PartnerBot",
+// are verified from cidrs and fake bots from elsewhere. Custom bots are
+// matched before knownbots ones, with the kind KindCustom. Invalid CIDRs
+// make New fail.
+func WithCustomBot(name, ua string, cidrs []string) Option {
+	return func(l *Limiter) {
+		l.cfg.CustomBots = append(l.cfg.CustomBots, CustomBot{Name: name, UA: ua, CIDRs: cidrs})
+	}
+}
+
+// WithBotLimit limits the verified bot named bot, e.g. "googlebot", to
+// limit events per second and burst across all its IPs, to keep it to a
+// sane crawl rate on resource-heavy sites. Requests over the limit are
+// denied with ReasonBotLimited. An empty bot sets the limit of verified
+// bots without their own; without it, they are not limited. AI bot
+// policies take precedence.
+func WithBotLimit(bot string, limit rate.Limit, burst int) Option {
+	return func(l *Limiter) {
+		if l.cfg.BotLimits == nil {
+			l.cfg.BotLimits = make(map[string]BotLimit)
+		}
+		l.cfg.BotLimits[bot] = BotLimit{Limit: limit, Burst: burst}
+	}
+}
+
+// WithAIBotPolicy sets what to do with AI bots, such as GPTBot,
+// ClaudeBot and PerplexityBot, on top of knownbots verification: bots
+// failing it are fake bots whatever the policy. bots are knownbots bot
+// names, e.g. "gptbot", or kinds, e.g. string(knownbots.KindAITraining);
+// none applies action to every bot of the AI kinds (AITraining, AIAssist
+// and AIMixed). A name takes precedence over a kind, which takes
+// precedence over all AI bots, so training crawlers can be denied while
+// answer bots bringing visitors are let through:
+//
+//	WithAIBotPolicy(AIBotDeny, string(knownbots.KindAITraining))
+//	WithAIBotPolicy(AIBotLimit, string(knownbots.KindAIAssist))
+//
+// Search engines and other verified bots are not affected unless named.
+func WithAIBotPolicy(action AIBotAction, bots ...string) Option {
+	return func(l *Limiter) {
+		if l.cfg.AIBotPolicies == nil {
+			l.cfg.AIBotPolicies = make(map[string]AIBotAction)
+		}
+		if len(bots) == 0 {
+			l.cfg.AIBotPolicies[""] = action
+		}
+		for _, b := range bots {
+			l.cfg.AIBotPolicies[b] = action
+		}
+	}
+}
+
+// WithAIBotLimit sets events per second and burst for each AI bot with
+// AIBotLimit, DefaultAIBotLimit and DefaultAIBotBurst by default. All the
+// IPs of a bot share its bucket.
+func WithAIBotLimit(limit rate.Limit, burst int) Option {
+	return func(l *Limiter) {
+		l.cfg.AIBotLimit = limit
+		l.cfg.AIBotBurst = burst
+	}
+}
+
+// WithAllowCIDRs sets IPs and CIDR ranges that bypass bot verification
+// and behavior analysis, e.g. office ranges and health checkers.
+// Invalid entries make New fail.
+func WithAllowCIDRs(cidrs []string) Option {
+	return func(l *Limiter) {
+		l.cfg.AllowCIDRs = cidrs
+	}
+}
+
+// WithDenyCIDRs sets IPs and CIDR ranges that are always rejected with
+// ReasonDenied, before the allowlist or any other check.
+// Invalid entries make New fail.
+func WithDenyCIDRs(cidrs []string) Option {
+	return func(l *Limiter) {
+		l.cfg.DenyCIDRs = cidrs
+	}
+}
+
+// WithDenyTLSFingerprints rejects normal users whose TLS client
+// fingerprint, the JA3 or JA4 hash of Request.TLSFingerprint, is one of
+// fingerprints, with ReasonTLSFingerprint: HTTP libraries and headless
+// browsers have telltale handshakes, whatever UA they claim. Verified
+// bots are not checked. Fingerprints are matched ignoring case.
+func WithDenyTLSFingerprints(fingerprints ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.DenyTLSFingerprints = fingerprints
+	}
+}
+
+// WithSkipPaths sets path glob patterns that bypass analysis and
+// limiting, e.g. health checks, /metrics and webhooks. Patterns use
+// path.Match syntax, and a trailing "*" also matches across slashes, so
+// "/static/*" skips everything under /static/. Denylisted IPs are still
+// rejected. Invalid patterns make New fail.
+func WithSkipPaths(globs ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.SkipPaths = globs
+	}
+}
+
+// WithHoneypotPaths sets trap paths, with the syntax of WithSkipPaths:
+// URLs linked invisibly or disallowed in robots.txt that no human or
+// well-behaved crawler requests. A normal user requesting one is blocked
+// right away, starting with that request. Verified bots are not trapped.
+func WithHoneypotPaths(paths ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.HoneypotPaths = append(l.cfg.HoneypotPaths, paths...)
+	}
+}
+
+// WithIgnoreAssets makes analysis ignore static assets when counting
+// distinct pages. Entries are extensions (".js") or content type prefixes
+// ("image/") the extension maps to. With no entries, analyzer.DefaultAssets
+// is used. Asset requests are still limited for blocked IPs.
+func WithIgnoreAssets(assets ...string) Option {
+	return func(l *Limiter) {
+		if len(assets) == 0 {
+			assets = analyzer.DefaultAssets
+		}
+		l.cfg.IgnoreAssets = assets
+	}
+}
+
+// WithPathNormalizer sets how paths are normalized before counting
+// distinct pages. Defaults to analyzer.StripQuery; analyzer.NormalizePath
+// also collapses numeric and UUID segments, so "/product/1".."/product/N"
+// count as one page.
+func WithPathNormalizer(fn func(string) string) Option {
+	return func(l *Limiter) {
+		l.cfg.PathNormalizer = fn
+	}
+}
+
+// WithKeyFunc sets the key normal users are analyzed and limited by,
+// instead of the IP. Bot verification, allow and deny lists still use the
+// IP. Keys replace IPs in Block, Unblock and the blocklist.
+func WithKeyFunc(fn KeyFunc) Option {
+	return func(l *Limiter) {
+		l.keyFunc = fn
+	}
+}
+
+// WithHostIsolation analyzes and limits normal users apart on each of
+// hosts, see Request.Host, so a client's heavy but legitimate use of one
+// site sharing the server doesn't block it on the others. Their keys
+// become analyzer.HostKey(host, key), also in Block, Unblock and the
+// blocklist; requests to other hosts, which the client picks, share the
+// key without isolation. Blocking the key without a host, e.g. the IP,
+// blocks the client on every host. Allow and deny lists still apply to
+// every host.
+func WithHostIsolation(hosts ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.IsolatedHosts = hosts
+	}
+}
+
+// WithUAKeying analyzes and limits normal users keyed by IP on
+// analyzer.UAKey(ip, ua), so the thousands of users of a large NAT, such
+// as a mobile carrier or a corporate proxy, are not blocked together,
+// while a single UA scraping through it still trips the thresholds. Keys
+// become e.g. "203.0.113.7#af63bd4c8601b7df", also in Block, Unblock and
+// the blocklist, and signals counting by IP no longer see them. Blocks of
+// the IP, e.g. by Block or a blocked prefix, still apply to every UA.
+// Users and sessions keep their keys. A scraper rotating its UA gets a
+// key per UA up to WithUAKeysPerIP: enable it where shared IPs are the
+// larger problem.
+func WithUAKeying(enabled bool) Option {
+	return func(l *Limiter) {
+		l.cfg.UAKeying = enabled
+	}
+}
+
+// WithUAKeysPerIP sets how many UAs of an IP get their own key with
+// WithUAKeying, every analyzer window; its other UAs share the IP's key, so
+// a scraper rotating its UA is analyzed as one client. Defaults to
+// DefaultUAKeysPerIP; n <= 0 for no limit.
+func WithUAKeysPerIP(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.UAKeysPerIP = n
+	}
+}
+
+// WithSessionsPerIP sets how many sessions of an IP, see
+// Request.Session, get their own key every analyzer window; its other
+// sessions are keyed as if they had none, so a scraper minting session
+// cookies is analyzed as one client. Defaults to DefaultSessionsPerIP;
+// n <= 0 for no limit.
+func WithSessionsPerIP(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.SessionsPerIP = n
+	}
+}
+
+// WithSignal adds a signal to behavior analysis. Signal scores are summed
+// with the built-in distinct pages signal, and an IP is blocked once the
+// sum reaches the score threshold. Use analyzer.Weight to weigh a signal.
+func WithSignal(s analyzer.Signal) Option {
+	return func(l *Limiter) {
+		l.cfg.Signals = append(l.cfg.Signals, s)
+	}
+}
+
+// WithScoreThreshold sets the summed signal score at which an IP is
+// blocked. Defaults to analyzer.DefaultScoreThreshold, where the distinct
+// pages signal alone blocks at the page threshold.
+func WithScoreThreshold(threshold float64) Option {
+	return func(l *Limiter) {
+		l.cfg.ScoreThreshold = threshold
+	}
+}
+
+// WithGreylistThreshold sets the summed signal score, below the score
+// threshold, at which an IP is greylisted for the rest of the window: it
+// is limited to the greylist limit with ReasonGreylisted but still
+// analyzed, and only blocked if its behavior continues. E.g. 0.5 greylists
+// halfway to the page threshold. Zero (the default) disables the greylist.
+func WithGreylistThreshold(threshold float64) Option {
+	return func(l *Limiter) {
+		l.cfg.GreylistThreshold = threshold
+	}
+}
+
+// WithGreylistLimit sets events per second and burst for greylisted IPs,
+// DefaultGreylistLimit and DefaultGreylistBurst by default.
+func WithGreylistLimit(limit rate.Limit, burst int) Option {
+	return func(l *Limiter) {
+		l.cfg.GreylistLimit = limit
+		l.cfg.GreylistBurst = burst
+	}
+}
+
+// WithGreylistFunc sets a callback for greylisted keys, e.g. to alert or
+// to require a challenge. It is called from the analyzer goroutine and
+// must not block.
+func WithGreylistFunc(fn func(key string, score float64)) Option {
+	return func(l *Limiter) {
+		l.cfg.OnGreylist = fn
+	}
+}
+
+// WithEventFunc adds a consumer of block, unblock and queue drop events,
+// e.g. a publisher.Sink feeding a security pipeline. It is called from
+// the goroutine causing the event and must not block.
+func WithEventFunc(fn func(e analyzer.Event)) Option {
+	return func(l *Limiter) {
+		l.cfg.EventFuncs = append(l.cfg.EventFuncs, fn)
+	}
+}
+
+// WithSubscribeBuffer sets the number of events buffered for each
+// subscriber of Limiter.Subscribe before further ones are dropped.
+// Defaults to DefaultSubscribeBuffer.
+func WithSubscribeBuffer(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.SubscribeBuffer = n
+	}
+}
+
+// WithUserPageThreshold sets the distinct pages threshold for
+// authenticated users (see Request.User), so legitimate power users do not
+// trip the scrape detector. Zero uses the analyzer page threshold.
+func WithUserPageThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.UserPageThreshold = threshold
+	}
+}
+
+// WithExemptUsers makes authenticated users (see Request.User) bypass
+// verification, analysis and limiting, like allowlisted IPs.
+func WithExemptUsers(exempt bool) Option {
+	return func(l *Limiter) {
+		l.cfg.ExemptUsers = exempt
+	}
+}
+
+// WithLoginPaths enables login brute-force detection on paths matching
+// the glob patterns, with the syntax of WithSkipPaths. Failed logins,
+// fed by Limiter.RecordLogin, block an IP after DefaultLoginThreshold per
+// window, much sooner than the page threshold.
+func WithLoginPaths(patterns ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.LoginPaths = append(l.cfg.LoginPaths, patterns...)
+	}
+}
+
+// WithPathPolicy applies p to requests of normal users to paths matching
+// pattern, with the syntax of WithSkipPaths, e.g. a lower threshold and
+// a limit for "/api/*" than for "/blog/*". Policies are matched in the
+// order given, the first matching applies. An invalid pattern makes New
+// fail.
+func WithPathPolicy(pattern string, p Policy) Option {
+	return func(l *Limiter) {
+		l.cfg.PathPolicies = append(l.cfg.PathPolicies, PathPolicy{Path: pattern, Policy: p})
+	}
+}
+
+// WithLoginStatuses sets the response statuses that count as failed
+// logins, DefaultLoginStatuses by default. Add e.g. 200 for login forms
+// that redisplay on failure.
+func WithLoginStatuses(statuses ...int) Option {
+	return func(l *Limiter) {
+		l.cfg.LoginStatuses = statuses
+	}
+}
+
+// WithLoginThreshold sets max failed logins per window, see
+// WithLoginPaths.
+func WithLoginThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.LoginThreshold = threshold
+	}
+}
+
 // WithKnownbots implants a custom knownbots.Validator.
 func WithKnownbots(kb *knownbots.Validator) Option {
 	return func(l *Limiter) {
 		l.kb = kb
 	}
 }
+
+// WithBotData lets botrate manage the knownbots dataset instead of
+// knownbots.New, which fetches the bots' IP ranges at startup and daily
+// with no say: fetch them every botdata.WithRefresh, through
+// botdata.WithClient, report each refresh to botdata.WithFunc, or never
+// with botdata.WithOffline for air-gapped deployments. Bots are verified
+// with the ranges of the dataset, those fetched and RDNS. It excludes
+// WithKnownbots.
+func WithBotData(opts ...botdata.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.BotData = true
+		l.cfg.BotDataOptions = opts
+	}
+}
+
+// WithVerifyCache caches the knownbots verifications of bots, by bot and
+// IP, for ttl, so repeated hits of a bot skip validation and RDNS. At
+// most size verifications are cached, DefaultVerifyCacheSize if not
+// positive, the least recently used evicted first. Pending verifications
+// are not cached. Hits and misses are reported in Stats.
+func WithVerifyCache(ttl time.Duration, size int) Option {
+	return func(l *Limiter) {
+		l.cfg.VerifyCacheTTL = ttl
+		l.cfg.VerifyCacheSize = size
+	}
+}
+
+// WithRDNS verifies the bots that knownbots checks by reverse DNS, such
+// as Googlebot, with an rdns.Verifier instead of the system resolver:
+// the resolver or DNS servers, the timeout and the concurrent lookups
+// are configurable, and hostnames are confirmed by a forward lookup.
+// With rdns.WithAsync, lookups leave the request path: bots not cached
+// are pending, see WithPendingRetries, until their lookup completes.
+// Bots are loaded from the root of WithBotData, botdata.DefaultRoot
+// without.
+func WithRDNS(opts ...rdns.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.RDNS = true
+		l.cfg.RDNSOptions = opts
+	}
+}
+
+// WithPendingRetries bounds how long pending verifications, whose RDNS
+// lookup failed, pass as verified: after retries pending verifications
+// of a bot from an IP, or window after the first, they are treated as
+// fallback, knownbots.StatusFailed (a fake bot) or StatusVerified, until
+// one settles. Zero retries or window is no bound on it. Without it,
+// pending bots pass as long as DNS fails.
+func WithPendingRetries(retries int, window time.Duration, fallback knownbots.ResultStatus) Option {
+	return func(l *Limiter) {
+		l.cfg.PendingRetries = retries
+		l.cfg.PendingWindow = window
+		l.cfg.PendingFallback = fallback
+	}
+}
+
+// WithStore sets the blocklist store, e.g. a shared Redis store so that
+// multiple instances see the same blocked IPs, or a boltstore.Store so
+// blocks survive restarts. The store is not closed with the limiter.
+func WithStore(s analyzer.Store) Option {
+	return func(l *Limiter) {
+		l.cfg.Store = s
+	}
+}
+
+// WithAuditLog writes every block and unblock to w as a line of JSON,
+// with its reason, counts and ConfigHash, see the audit package. w is not
+// closed with the limiter.
+func WithAuditLog(w io.Writer, opts ...audit.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.AuditLog = w
+		l.cfg.AuditOptions = opts
+	}
+}
+
+// WithAuditFile is like WithAuditLog but appends to the file at path,
+// rotated with audit.WithMaxSize, and closed with the limiter.
+func WithAuditFile(path string, opts ...audit.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.AuditFile = path
+		l.cfg.AuditOptions = opts
+	}
+}
+
+// WithAbuseIPDB consults AbuseIPDB with apiKey as a scoring signal: an IP
+// whose confidence score reaches abuseipdb.WithThreshold is blocked on
+// its own, lower scores add up with the other signals. Scores are looked
+// up in the background and cached. With abuseipdb.WithReport, blocks are
+// reported back.
+func WithAbuseIPDB(apiKey string, opts ...abuseipdb.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.AbuseIPDB = apiKey
+		l.cfg.AbuseIPDBOptions = opts
+	}
+}
+
+// WithDNSBL checks IPs against DNS blocklists, Spamhaus ZEN unless
+// dnsbl.WithZones, as a scoring signal. Checks run in the background
+// with a strict timeout and are cached.
+func WithDNSBL(opts ...dnsbl.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.DNSBL = true
+		l.cfg.DNSBLOptions = opts
+	}
+}
+
+// WithGeoLocator locates normal users with loc, e.g. a geoip.DB opened
+// on GeoLite2-Country.mmdb, for the country policies: WithCountryDeny,
+// WithCountryAllow and WithCountryPageThreshold. loc is not closed with
+// the limiter. Verified bots are not subject to country policies.
+func WithGeoLocator(loc GeoLocator) Option {
+	return func(l *Limiter) {
+		l.cfg.GeoLocator = loc
+	}
+}
+
+// WithCountryDeny rejects normal users from countries, ISO 3166-1
+// alpha-2 codes like "CN", with ReasonDenied. Needs WithGeoLocator.
+func WithCountryDeny(countries ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.CountryDeny = append(l.cfg.CountryDeny, countries...)
+	}
+}
+
+// WithCountryAllow rejects normal users from every country but those
+// given with ReasonDenied. Users whose country is unknown are not
+// rejected. Needs WithGeoLocator.
+func WithCountryAllow(countries ...string) Option {
+	return func(l *Limiter) {
+		l.cfg.CountryAllow = append(l.cfg.CountryAllow, countries...)
+	}
+}
+
+// WithCountryPageThreshold blocks normal users from countries at
+// threshold distinct pages instead of WithAnalyzerPageThreshold, e.g. a
+// stricter threshold for the countries most scrapers come from.
+// WithUserPageThreshold takes precedence for authenticated users. Needs
+// WithGeoLocator.
+func WithCountryPageThreshold(threshold int, countries ...string) Option {
+	return func(l *Limiter) {
+		if l.cfg.CountryPageThresholds == nil {
+			l.cfg.CountryPageThresholds = make(map[string]int)
+		}
+		for _, c := range countries {
+			l.cfg.CountryPageThresholds[c] = threshold
+		}
+	}
+}
+
+// WithUAClassPageThreshold blocks normal users whose UA is of one of
+// classes, see useragent.Classify, at threshold distinct pages instead
+// of WithAnalyzerPageThreshold, so that a crawl from python-requests or
+// curl is blocked long before the same crawl from a browser. When a
+// country or datacenter threshold also applies, the lowest wins.
+// WithUserPageThreshold takes precedence for authenticated users. The
+// class is reported in Decision.UAClass.
+func WithUAClassPageThreshold(threshold int, classes ...useragent.Class) Option {
+	return func(l *Limiter) {
+		if l.cfg.UAClassPageThresholds == nil {
+			l.cfg.UAClassPageThresholds = make(map[useragent.Class]int)
+		}
+		for _, c := range classes {
+			l.cfg.UAClassPageThresholds[c] = threshold
+		}
+	}
+}
+
+// WithBadUAPolicy applies policy to normal users whose UA is empty,
+// truncated or longer than WithMaxUALength, see useragent.Validate.
+// Browsers always send a complete UA; scripts often send none. The flaw
+// is reported in Decision.BadUA.
+func WithBadUAPolicy(policy BadUAPolicy) Option {
+	return func(l *Limiter) {
+		l.cfg.BadUAPolicy = policy
+	}
+}
+
+// WithMaxUALength sets the longest UA WithBadUAPolicy accepts,
+// DefaultMaxUALength by default. 0 accepts any length.
+func WithMaxUALength(n int) Option {
+	return func(l *Limiter) {
+		l.cfg.MaxUALength = n
+	}
+}
+
+// WithBadUAPageThreshold sets the distinct pages threshold of normal
+// users with a bad UA and BadUAScore, DefaultBadUAPageThreshold by
+// default. As for WithUAClassPageThreshold, the lowest applicable
+// threshold wins.
+func WithBadUAPageThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.BadUAPageThreshold = threshold
+	}
+}
+
+// WithASNLocator finds the autonomous system of normal users with loc,
+// e.g. a geoip.DB opened on GeoLite2-ASN.mmdb, to tell traffic from
+// datacenters, see WithDatacenterPageThreshold and
+// WithDenyDatacenterBrowsers. loc is not closed with the limiter. The
+// autonomous system is reported in Decision.ASN.
+func WithASNLocator(loc ASNLocator) Option {
+	return func(l *Limiter) {
+		l.cfg.ASNLocator = loc
+	}
+}
+
+// WithDatacenterASNs sets the autonomous systems counted as datacenters,
+// DatacenterASNs by default.
+func WithDatacenterASNs(asns ...uint32) Option {
+	return func(l *Limiter) {
+		l.cfg.DatacenterASNs = asns
+	}
+}
+
+// WithDatacenterPageThreshold blocks normal users from datacenters at
+// threshold distinct pages instead of WithAnalyzerPageThreshold. The
+// lower of it and a WithCountryPageThreshold applies. Needs
+// WithASNLocator or WithCloudRanges.
+func WithDatacenterPageThreshold(threshold int) Option {
+	return func(l *Limiter) {
+		l.cfg.DatacenterPageThreshold = threshold
+	}
+}
+
+// WithDenyDatacenterBrowsers denies requests with a browser UA from
+// datacenters with ReasonDatacenter: browsers rarely run there, bots
+// posing as browsers often do. Verified bots are not affected. Use
+// WithAction(ReasonDatacenter, ActionChallenge) to let the odd human
+// through. Needs WithASNLocator or WithCloudRanges.
+func WithDenyDatacenterBrowsers(enabled bool) Option {
+	return func(l *Limiter) {
+		l.cfg.DenyDatacenterBrowsers = enabled
+	}
+}
+
+// WithSubnetPageThreshold scores the distinct pages of a normal user's
+// /24 or /48 prefix, and of its autonomous system, per window, with
+// analyzer.NewSubnetSignal: the signal alone blocks the IPs of a subnet
+// at prefix, or asn, pages, catching distributed scrapers keeping each
+// IP below the page threshold. The subnet's IPs are blocked as they
+// request their next page. Zero disables a count; asn needs
+// WithASNLocator.
+func WithSubnetPageThreshold(prefix, asn int) Option {
+	return func(l *Limiter) {
+		l.cfg.SubnetPageThreshold, l.cfg.ASNPageThreshold = prefix, asn
+	}
+}
+
+// WithCloudRanges counts the IP ranges published by cloud providers,
+// AWS, Google Cloud, Azure and Oracle Cloud unless
+// cloudranges.WithProviders, as datacenters, alone or with WithASNLocator. The
+// feeds are fetched in the background by New and refreshed daily;
+// until they are, no IP is in them. The provider is reported in
+// Decision.Cloud. With cloudranges.WithScore, the ranges are also a
+// scoring signal.
+func WithCloudRanges(opts ...cloudranges.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.CloudRanges = true
+		l.cfg.CloudRangesOptions = opts
+	}
+}
+
+// WithTor applies policy to normal users coming from Tor exits, as
+// listed by the Tor Project unless tor.WithURL. The list is fetched in
+// the background by New and refreshed hourly; until it is, no IP is an
+// exit. Tor users are reported in Decision.Tor.
+func WithTor(policy TorPolicy, opts ...tor.Option) Option {
+	return func(l *Limiter) {
+		l.cfg.Tor = true
+		l.cfg.TorPolicy = policy
+		l.cfg.TorOptions = opts
+	}
+}
+
+// WithTorLimit sets events per second and burst for each Tor exit with
+// TorLimit, DefaultTorLimit and DefaultTorBurst by default. Exits are
+// shared by many users, so allow more than for a single client.
+func WithTorLimit(limit rate.Limit, burst int) Option {
+	return func(l *Limiter) {
+		l.cfg.TorLimit = limit
+		l.cfg.TorBurst = burst
+	}
+}
+
+// WithMessageLimit sets messages per second and burst for each
+// connection checked with Conn.Message, e.g. a WebSocket. Messages are
+// charged to the client like requests either way; this also bounds a
+// connection of an unblocked client. 0 leaves connections unlimited.
+func WithMessageLimit(limit rate.Limit, burst int) Option {
+	return func(l *Limiter) {
+		l.cfg.MessageLimit = limit
+		l.cfg.MessageBurst = burst
+	}
+}
+
+// WithExpvar publishes Stats with expvar as name, e.g. "botrate", for
+// scraping /debug/vars. expvar names are global: a newer limiter with
+// the same name replaces this one, and the name reports null once the
+// limiter is closed.
+func WithExpvar(name string) Option {
+	return func(l *Limiter) {
+		l.cfg.Expvar = name
+	}
+}
+
+// WithClock sets the clock for analyzer windows, blocks and token
+// buckets, e.g. an analyzer.ManualClock to advance time in tests and
+// simulations. Waits still sleep in real time. Defaults to
+// analyzer.SystemClock.
+func WithClock(clock analyzer.Clock) Option {
+	return func(l *Limiter) {
+		l.cfg.Clock = clock
+	}
+}
+
+// WithLogger sets the structured logger for block decisions, analyzer
+// rotations, queue drops and bot verification failures.
+func WithLogger(logger *slog.Logger) Option {
+	return func(l *Limiter) {
+		l.logger = logger
+	}
+}
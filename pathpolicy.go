@@ -0,0 +1,86 @@
+package botrate
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// Policy overrides the page threshold and limits requests of normal users
+// to the paths of WithPathPolicy, e.g. stricter for an API than for a
+// blog.
+type Policy struct {
+	// PageThreshold replaces the global page threshold for requests to
+	// the paths, zero keeps it. User, country and datacenter thresholds
+	// still take precedence.
+	PageThreshold int
+
+	// Limit and Burst throttle each client's requests to the paths with
+	// a token bucket of the policy, denying those over it with
+	// ReasonPathLimited. The requests are still analyzed. A zero Limit
+	// doesn't throttle.
+	Limit rate.Limit
+	Burst int
+}
+
+// PathPolicy is the Policy of the paths matching Path, see
+// WithPathPolicy.
+type PathPolicy struct {
+	Path string
+	Policy
+}
+
+// pathPolicies matches request paths to their policies, the first
+// matching in the order given.
+type pathPolicies struct {
+	policies []*pathPolicy
+}
+
+type pathPolicy struct {
+	Policy
+	paths *pathSet
+
+	// Token buckets by key, with a Limit
+	buckets sync.Map
+}
+
+// newPathPolicies returns the policies of pp, nil without any.
+func newPathPolicies(pp []PathPolicy) (*pathPolicies, error) {
+	if len(pp) == 0 {
+		return nil, nil
+	}
+	p := &pathPolicies{policies: make([]*pathPolicy, len(pp))}
+	for i, policy := range pp {
+		paths, err := newPathSet([]string{policy.Path})
+		if err != nil {
+			return nil, err
+		}
+		p.policies[i] = &pathPolicy{Policy: policy.Policy, paths: paths}
+	}
+	return p, nil
+}
+
+// match returns the policy of path, nil if none. p may be nil.
+func (p *pathPolicies) match(path string) *pathPolicy {
+	if p == nil {
+		return nil
+	}
+	for _, policy := range p.policies {
+		if policy.paths.Match(path) {
+			return policy
+		}
+	}
+	return nil
+}
+
+// buckets returns the token bucket maps of the policies. p may be nil.
+func (p *pathPolicies) buckets() []*sync.Map {
+	if p == nil {
+		return nil
+	}
+	maps := make([]*sync.Map, len(p.policies))
+	for i, policy := range p.policies {
+		maps[i] = &policy.buckets
+	}
+	return maps
+}
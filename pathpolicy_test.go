@@ -0,0 +1,102 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_WithPathPolicy(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(10),
+		WithPathPolicy("/api/*", Policy{PageThreshold: 3, Limit: rate.Every(time.Hour), Burst: 2}),
+		WithPathPolicy("/blog/*", Policy{PageThreshold: 100}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua = "Mozilla/5.0"
+
+	// The API limit applies per client, before the threshold is reached
+	for i, ip := range []string{"1.2.3.4", "1.2.3.4", "5.6.7.8"} {
+		if allowed, _ := l.Allow(ua, ip, "/api/a"); !allowed {
+			t.Errorf("request %d should be within the API burst", i)
+		}
+	}
+	if allowed, reason := l.Allow(ua, "1.2.3.4", "/api/b"); allowed || reason != ReasonPathLimited {
+		t.Errorf("expected path limited, got %v, %s", allowed, reason)
+	}
+	if s := l.Stats(); s.Denied[ReasonPathLimited] != 1 {
+		t.Errorf("expected 1 path limited request, got %d", s.Denied[ReasonPathLimited])
+	}
+
+	// Limited requests are still analyzed: the 3rd API page blocks
+	l.Allow(ua, "1.2.3.4", "/api/c")
+	if !l.analyzer.Blocked("1.2.3.4") {
+		t.Error("IP should be blocked at the API threshold")
+	}
+
+	// Other paths keep the global threshold, or their own
+	for i := range 12 {
+		l.Allow(ua, "9.9.9.9", "/blog/"+string(rune('a'+i)))
+	}
+	if l.analyzer.Blocked("9.9.9.9") {
+		t.Error("IP should not be blocked below the blog threshold")
+	}
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h", "/i", "/j"} {
+		l.Allow(ua, "8.8.8.8", path)
+	}
+	if !l.analyzer.Blocked("8.8.8.8") {
+		t.Error("IP should be blocked at the global threshold")
+	}
+}
+
+func TestLimiter_WithPathPolicy_Invalid(t *testing.T) {
+	if _, err := New(WithKnownbots(testbots.New(t)), WithPathPolicy("/[a", Policy{})); err == nil {
+		t.Error("invalid pattern should fail")
+	}
+}
+
+func TestLimiter_ApplyConfig_PathPolicies(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithPathPolicy("/api/*", Policy{Limit: rate.Every(time.Hour), Burst: 1}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua = "Mozilla/5.0"
+	l.Allow(ua, "1.2.3.4", "/api/a")
+	if allowed, _ := l.Allow(ua, "1.2.3.4", "/api/b"); allowed {
+		t.Fatal("request over the API burst should be limited")
+	}
+	limiters := l.Stats().Limiters
+
+	cfg := l.Config()
+	cfg.PathPolicies = []PathPolicy{{Path: "/api/*", Policy: Policy{Limit: rate.Every(time.Hour), Burst: 2}}}
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() returned error: %v", err)
+	}
+	if got := l.Stats().Limiters; got != limiters-1 {
+		t.Errorf("expected the API bucket to be dropped, got %d limiters, had %d", got, limiters)
+	}
+	for i := range 2 {
+		if allowed, _ := l.Allow(ua, "1.2.3.4", "/api/c"); !allowed {
+			t.Errorf("request %d should be within the new burst", i)
+		}
+	}
+
+	cfg.PathPolicies = []PathPolicy{{Path: "/[a"}}
+	if err := l.ApplyConfig(cfg); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
@@ -0,0 +1,107 @@
+package botrate
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cnlangzi/knownbots"
+)
+
+// DefaultPendingSize is the most pending verifications tracked with
+// WithPendingRetries.
+var DefaultPendingSize = 100000
+
+// pendingKey is a pending verification, by bot name and IP.
+type pendingKey struct {
+	bot, ip string
+}
+
+// pendingRetry is how long and how often a verification has been pending.
+type pendingRetry struct {
+	first time.Time
+	count int
+}
+
+// pendingRetries bounds the retries of pending verifications, see
+// WithPendingRetries.
+type pendingRetries struct {
+	retries int           // 0 for no bound
+	window  time.Duration // 0 for no bound
+	size    int
+
+	mu      sync.Mutex
+	entries map[pendingKey]pendingRetry
+}
+
+func newPendingRetries(retries int, window time.Duration) *pendingRetries {
+	return &pendingRetries{
+		retries: retries,
+		window:  window,
+		size:    DefaultPendingSize,
+		entries: make(map[pendingKey]pendingRetry),
+	}
+}
+
+// retry records a pending verification of bot from ip at now, reporting
+// false once its retries are exhausted.
+func (p *pendingRetries) retry(bot, ip string, now time.Time) bool {
+	k := pendingKey{bot, ip}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	r, ok := p.entries[k]
+	if !ok {
+		if len(p.entries) >= p.size {
+			p.evict()
+		}
+		r.first = now
+	}
+	r.count++
+	p.entries[k] = r
+
+	if p.retries > 0 && r.count > p.retries {
+		return false
+	}
+	return p.window <= 0 || now.Sub(r.first) < p.window
+}
+
+// settle forgets the pending verification of bot from ip, verified or
+// failed at last.
+func (p *pendingRetries) settle(bot, ip string) {
+	k := pendingKey{bot, ip}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entries, k)
+}
+
+// inspect returns the pending verifications of bots from ip.
+func (p *pendingRetries) inspect(ip string) []BotVerification {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var bots []BotVerification
+	for k, r := range p.entries {
+		if k.ip == ip {
+			bots = append(bots, BotVerification{Bot: k.bot, Status: knownbots.StatusPending, Retries: r.count})
+		}
+	}
+	return bots
+}
+
+// evict makes room, dropping the oldest of a sample of verifications. It
+// is called under mu.
+func (p *pendingRetries) evict() {
+	var oldest pendingKey
+	var first time.Time
+	n := 0
+	for k, r := range p.entries {
+		if n == 0 || r.first.Before(first) {
+			oldest, first = k, r.first
+		}
+		if n++; n == 16 {
+			break
+		}
+	}
+	delete(p.entries, oldest)
+}
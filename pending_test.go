@@ -0,0 +1,76 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+)
+
+func TestPendingRetries_Retry(t *testing.T) {
+	now := time.Now()
+
+	p := newPendingRetries(2, 0)
+	for i, want := range []bool{true, true, false, false} {
+		if got := p.retry("googlebot", "66.249.66.1", now); got != want {
+			t.Errorf("retry #%d = %v, want %v", i+1, got, want)
+		}
+	}
+	if !p.retry("googlebot", "66.249.66.2", now) {
+		t.Error("retries are counted by IP")
+	}
+	p.settle("googlebot", "66.249.66.1")
+	if !p.retry("googlebot", "66.249.66.1", now) {
+		t.Error("settled verifications should be retried again")
+	}
+
+	p = newPendingRetries(0, time.Minute)
+	if !p.retry("googlebot", "66.249.66.1", now) || !p.retry("googlebot", "66.249.66.1", now.Add(59*time.Second)) {
+		t.Error("pending verifications should be retried within the window")
+	}
+	if p.retry("googlebot", "66.249.66.1", now.Add(time.Minute)) {
+		t.Error("pending verifications should fall back after the window")
+	}
+}
+
+func TestPendingRetries_Evict(t *testing.T) {
+	now := time.Now()
+	p := newPendingRetries(1, 0)
+	p.size = 2
+
+	p.retry("googlebot", "66.249.66.1", now)
+	p.retry("googlebot", "66.249.66.2", now.Add(time.Second))
+	p.retry("googlebot", "66.249.66.3", now.Add(2*time.Second))
+	if len(p.entries) != 2 {
+		t.Fatalf("at most 2 verifications should be tracked, got %d", len(p.entries))
+	}
+	if _, ok := p.entries[pendingKey{"googlebot", "66.249.66.1"}]; ok {
+		t.Error("the oldest verification should be evicted")
+	}
+}
+
+func TestLimiter_WithPendingRetries(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.NewAI(t)), WithPendingRetries(1, 0, knownbots.StatusFailed))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, want := range []knownbots.ResultStatus{knownbots.StatusPending, knownbots.StatusFailed} {
+		res := knownbots.Result{BotName: "googlebot", IsBot: true, Status: knownbots.StatusPending}
+		l.retryPending(&res, "66.249.66.1")
+		if res.Status != want {
+			t.Errorf("status = %v, want %v", res.Status, want)
+		}
+	}
+
+	s := l.Stats()
+	if s.Pending != 2 || s.PendingExhausted != 1 {
+		t.Errorf("pending, exhausted = %d, %d, want 2, 1", s.Pending, s.PendingExhausted)
+	}
+
+	if _, err := New(WithKnownbots(testbots.NewAI(t)), WithPendingRetries(1, 0, knownbots.StatusPending)); err == nil {
+		t.Error("expected an error for a pending fallback")
+	}
+}
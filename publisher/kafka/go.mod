@@ -0,0 +1,17 @@
+module github.com/cnlangzi/botrate/publisher/kafka
+
+go 1.22
+
+require (
+	github.com/cnlangzi/botrate v0.0.0-00010101000000-000000000000
+	github.com/segmentio/kafka-go v0.4.47
+)
+
+require (
+	github.com/bits-and-blooms/bitset v1.24.2 // indirect
+	github.com/bits-and-blooms/bloom/v3 v3.7.1 // indirect
+	github.com/klauspost/compress v1.17.8 // indirect
+	github.com/pierrec/lz4/v4 v4.1.15 // indirect
+)
+
+replace github.com/cnlangzi/botrate => ../..
@@ -0,0 +1,36 @@
+// Package kafka publishes botrate events to a Kafka topic, see
+// publisher.Sink.
+package kafka
+
+import (
+	"context"
+
+	"github.com/cnlangzi/botrate/publisher"
+	"github.com/segmentio/kafka-go"
+)
+
+// Writer writes messages to Kafka. *kafka.Writer from
+// github.com/segmentio/kafka-go implements it.
+type Writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Publisher writes each event keyed by IP, so the events of an IP land
+// in the same partition in order, with the event type in a "type"
+// header. It implements publisher.Publisher.
+type Publisher struct {
+	w Writer
+}
+
+// New returns a publisher writing to w, whose Topic must be set.
+func New(w Writer) *Publisher {
+	return &Publisher{w: w}
+}
+
+func (p *Publisher) Publish(ctx context.Context, msg publisher.Message) error {
+	return p.w.WriteMessages(ctx, kafka.Message{
+		Key:     []byte(msg.Key),
+		Value:   msg.Data,
+		Headers: []kafka.Header{{Key: "type", Value: []byte(msg.Type)}},
+	})
+}
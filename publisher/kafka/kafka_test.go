@@ -0,0 +1,39 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cnlangzi/botrate/publisher"
+	"github.com/segmentio/kafka-go"
+)
+
+type fakeWriter struct {
+	msgs []kafka.Message
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.msgs = append(w.msgs, msgs...)
+	return nil
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	w := &fakeWriter{}
+	p := New(w)
+
+	err := p.Publish(context.Background(), publisher.Message{Type: "block", Key: "1.2.3.4", Data: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("Publish() returned error: %v", err)
+	}
+	if len(w.msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(w.msgs))
+	}
+
+	m := w.msgs[0]
+	if string(m.Key) != "1.2.3.4" || string(m.Value) != `{}` {
+		t.Errorf("unexpected message %s: %s", m.Key, m.Value)
+	}
+	if len(m.Headers) != 1 || m.Headers[0].Key != "type" || string(m.Headers[0].Value) != "block" {
+		t.Errorf("expected a type header, got %+v", m.Headers)
+	}
+}
@@ -0,0 +1,38 @@
+// Package nats publishes botrate events to NATS subjects, see
+// publisher.Sink.
+package nats
+
+import (
+	"context"
+
+	"github.com/cnlangzi/botrate/publisher"
+)
+
+// DefaultSubject is the subject prefix events are published under.
+const DefaultSubject = "botrate.events"
+
+// Conn publishes to NATS. *nats.Conn from github.com/nats-io/nats.go
+// implements it.
+type Conn interface {
+	Publish(subject string, data []byte) error
+}
+
+// Publisher publishes each event to the subject prefix followed by the
+// event type, e.g. botrate.events.block, so consumers can subscribe to
+// botrate.events.> or to a single type. It implements
+// publisher.Publisher.
+type Publisher struct {
+	conn    Conn
+	subject string
+}
+
+// New returns a publisher on conn under the subject prefix.
+func New(conn Conn, subject string) *Publisher {
+	return &Publisher{conn: conn, subject: subject}
+}
+
+// Publish publishes msg. Publishing to NATS only buffers the message, so
+// ctx is not used.
+func (p *Publisher) Publish(ctx context.Context, msg publisher.Message) error {
+	return p.conn.Publish(p.subject+"."+msg.Type, msg.Data)
+}
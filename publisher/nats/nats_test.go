@@ -0,0 +1,31 @@
+package nats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cnlangzi/botrate/publisher"
+)
+
+type fakeConn struct {
+	subject string
+	data    []byte
+}
+
+func (c *fakeConn) Publish(subject string, data []byte) error {
+	c.subject, c.data = subject, data
+	return nil
+}
+
+func TestPublisher_Publish(t *testing.T) {
+	c := &fakeConn{}
+	p := New(c, DefaultSubject)
+
+	err := p.Publish(context.Background(), publisher.Message{Type: "block", Key: "1.2.3.4", Data: []byte(`{}`)})
+	if err != nil {
+		t.Fatalf("Publish() returned error: %v", err)
+	}
+	if c.subject != "botrate.events.block" || string(c.data) != `{}` {
+		t.Errorf("unexpected publish to %q: %s", c.subject, c.data)
+	}
+}
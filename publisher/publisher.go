@@ -0,0 +1,171 @@
+// Package publisher emits botrate block, unblock and drop events as JSON
+// messages to a broker, so security pipelines and SIEMs can consume them.
+//
+// A Sink queues the events of a limiter and publishes them in the
+// background through a Publisher, see the kafka and nats subpackages:
+//
+//	sink := publisher.NewSink(nats.New(nc, nats.DefaultSubject))
+//	defer sink.Close()
+//	limiter, err := botrate.New(botrate.WithEventFunc(sink.Handle))
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultQueueCap = 10000
+	DefaultTimeout  = 5 * time.Second
+)
+
+// Message is an event encoded for a broker.
+type Message struct {
+	// Type is the event type, e.g. analyzer.EventBlock.
+	Type string
+
+	// Key is the IP the event is about, empty for drops. Brokers
+	// partitioning by key keep the events of an IP in order.
+	Key string
+
+	// Data is the JSON encoded analyzer.Event.
+	Data []byte
+}
+
+// Publisher sends messages to a broker. Implementations need not be safe
+// for concurrent use: a Sink publishes one message at a time.
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+}
+
+// Option is a functional option for configuring Sink.
+type Option func(*Sink)
+
+// WithQueueCap sets how many events can wait to be published. Events
+// are dropped when the queue is full.
+func WithQueueCap(cap int) Option {
+	return func(s *Sink) {
+		s.queueCap = cap
+	}
+}
+
+// WithTimeout sets the timeout for publishing a message.
+func WithTimeout(d time.Duration) Option {
+	return func(s *Sink) {
+		s.timeout = d
+	}
+}
+
+// WithLogger sets the logger for failed publishes.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Sink) {
+		s.logger = logger
+	}
+}
+
+// Sink publishes events in the background, so Handle never blocks the
+// analyzer.
+type Sink struct {
+	p        Publisher
+	queueCap int
+	timeout  time.Duration
+	logger   *slog.Logger
+
+	queue   chan analyzer.Event
+	dropped atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSink returns a sink publishing through p.
+func NewSink(p Publisher, opts ...Option) *Sink {
+	s := &Sink{
+		p:        p,
+		queueCap: DefaultQueueCap,
+		timeout:  DefaultTimeout,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.logger == nil {
+		s.logger = logging.Discard()
+	}
+
+	s.queue = make(chan analyzer.Event, s.queueCap)
+	go s.run()
+	return s
+}
+
+// Handle queues e to be published, see botrate.WithEventFunc.
+func (s *Sink) Handle(e analyzer.Event) {
+	select {
+	case s.queue <- e:
+	default:
+		s.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events not published, because the queue
+// was full or publishing failed.
+func (s *Sink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close publishes the events still queued and stops the sink. It does
+// not close the Publisher.
+func (s *Sink) Close() error {
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	<-s.done
+	return nil
+}
+
+func (s *Sink) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case e := <-s.queue:
+			s.publish(e)
+		case <-s.stop:
+			for {
+				select {
+				case e := <-s.queue:
+					s.publish(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Sink) publish(e analyzer.Event) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		s.dropped.Add(1)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.p.Publish(ctx, Message{Type: e.Type, Key: e.IP, Data: data}); err != nil {
+		s.dropped.Add(1)
+		s.logger.Warn("botrate: failed to publish event", "type", e.Type, "ip", e.IP, "error", err)
+	}
+}
@@ -0,0 +1,87 @@
+package publisher
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+type fakePublisher struct {
+	mu   sync.Mutex
+	msgs []Message
+	err  error
+	wait chan struct{}
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, msg Message) error {
+	if p.wait != nil {
+		<-p.wait
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.msgs = append(p.msgs, msg)
+	return nil
+}
+
+func TestSink_Handle(t *testing.T) {
+	p := &fakePublisher{}
+	s := NewSink(p)
+
+	now := time.Now().Truncate(time.Millisecond)
+	s.Handle(analyzer.Event{Type: analyzer.EventBlock, Time: now, IP: "1.2.3.4", Pages: 3})
+	s.Handle(analyzer.Event{Type: analyzer.EventUnblock, Time: now, IP: "1.2.3.4"})
+	s.Close()
+
+	if len(p.msgs) != 2 {
+		t.Fatalf("expected 2 messages after Close, got %d", len(p.msgs))
+	}
+
+	msg := p.msgs[0]
+	if msg.Type != analyzer.EventBlock || msg.Key != "1.2.3.4" {
+		t.Errorf("unexpected message %q %q", msg.Type, msg.Key)
+	}
+
+	var e analyzer.Event
+	if err := json.Unmarshal(msg.Data, &e); err != nil {
+		t.Fatalf("message is not JSON: %v", err)
+	}
+	if e.IP != "1.2.3.4" || e.Pages != 3 || !e.Time.Equal(now) {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if p.msgs[1].Type != analyzer.EventUnblock {
+		t.Errorf("expected unblock second, got %q", p.msgs[1].Type)
+	}
+}
+
+func TestSink_Dropped(t *testing.T) {
+	p := &fakePublisher{wait: make(chan struct{})}
+	s := NewSink(p, WithQueueCap(1))
+
+	// The first event blocks in Publish, the second waits in the queue
+	s.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "1.1.1.1"})
+	time.Sleep(20 * time.Millisecond)
+	s.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "2.2.2.2"})
+	s.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "3.3.3.3"})
+
+	if s.Dropped() != 1 {
+		t.Errorf("expected 1 event dropped on a full queue, got %d", s.Dropped())
+	}
+	close(p.wait)
+	s.Close()
+
+	p.err = errors.New("broker down")
+	s = NewSink(p)
+	s.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "4.4.4.4"})
+	s.Close()
+	if s.Dropped() != 1 {
+		t.Errorf("expected a failed publish to count as dropped, got %d", s.Dropped())
+	}
+}
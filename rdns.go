@@ -0,0 +1,46 @@
+package botrate
+
+import (
+	"context"
+
+	"github.com/cnlangzi/botrate/rdns"
+	"github.com/cnlangzi/knownbots"
+)
+
+// rdnsBots verifies the knownbots bots checked by RDNS with a verifier of
+// its own, see WithRDNS.
+type rdnsBots struct {
+	bots     []*knownbots.Bot
+	verifier *rdns.Verifier
+}
+
+// newRDNSBots loads the bots checked by RDNS from the knownbots root.
+func newRDNSBots(root string, opts []rdns.Option) (*rdnsBots, error) {
+	bots, err := knownbots.Load(root)
+	if err != nil {
+		return nil, err
+	}
+	r := &rdnsBots{verifier: rdns.New(opts...)}
+	for _, b := range bots {
+		if b.RDNS && b.UA != "" {
+			r.bots = append(r.bots, b)
+		}
+	}
+	return r, nil
+}
+
+// validate verifies ua from ip like knownbots, reporting false if ua is
+// not one of the bots.
+func (r *rdnsBots) validate(ua, ip string) (knownbots.Result, bool) {
+	for _, b := range r.bots {
+		if !containsWord(ua, b.UA) {
+			continue
+		}
+		res := knownbots.Result{BotName: b.Name, BotKind: b.Kind, IsBot: true, Status: knownbots.StatusVerified}
+		if !b.ContainsIP(ip) {
+			res.Status = r.verifier.Verify(context.Background(), ip, b.Domains)
+		}
+		return res, true
+	}
+	return knownbots.Result{}, false
+}
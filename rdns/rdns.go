@@ -0,0 +1,325 @@
+// Package rdns verifies bots by reverse DNS, the way search engines ask
+// for: the PTR record of the IP must be a hostname in the bot's domains,
+// and that hostname must resolve back to the IP.
+//
+// Unlike knownbots, which looks up with the system resolver and no
+// bound, the resolver, the timeout and the concurrent lookups are
+// configurable, and results are cached. With WithAsync, lookups leave the
+// request path altogether.
+//
+// See botrate.WithRDNS.
+package rdns
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/knownbots"
+)
+
+// Default configuration values.
+var (
+	DefaultTimeout     = 2 * time.Second
+	DefaultConcurrency = 64
+	DefaultCacheTTL    = time.Hour
+	DefaultCacheSize   = 100000
+	DefaultQueueCap    = 1000
+)
+
+// Resolver looks up PTR records and hosts. *net.Resolver implements it.
+type Resolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Option is a functional option for configuring Verifier.
+type Option func(*Verifier)
+
+// WithResolver sets the resolver, net.DefaultResolver by default.
+func WithResolver(r Resolver) Option {
+	return func(v *Verifier) {
+		v.resolver = r
+	}
+}
+
+// WithServers resolves with the DNS servers at addrs, host:port, in
+// turn, e.g. a local caching resolver, instead of the system's. Without
+// addrs the resolver is kept.
+func WithServers(addrs ...string) Option {
+	return func(v *Verifier) {
+		if len(addrs) == 0 {
+			return
+		}
+		var next atomic.Uint32
+		var d net.Dialer
+		v.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				addr := addrs[int(next.Add(1)-1)%len(addrs)]
+				return d.DialContext(ctx, network, addr)
+			},
+		}
+	}
+}
+
+// WithTimeout sets the timeout for verifying an IP, both lookups and
+// the wait for a lookup slot. IPs not verified in time are pending.
+func WithTimeout(d time.Duration) Option {
+	return func(v *Verifier) {
+		v.timeout = d
+	}
+}
+
+// WithConcurrency sets how many IPs are looked up concurrently.
+func WithConcurrency(n int) Option {
+	return func(v *Verifier) {
+		v.concurrency = n
+	}
+}
+
+// WithCache sets how long results are cached and the most IPs cached,
+// the least recently used evicted first. Pending results are not cached.
+func WithCache(ttl time.Duration, size int) Option {
+	return func(v *Verifier) {
+		v.cacheTTL = ttl
+		v.cacheSize = size
+	}
+}
+
+// WithAsync looks IPs up in the background, with workers, instead of
+// in Verify: IPs not cached are pending until their lookup completes.
+// At most queueCap IPs wait, DefaultQueueCap if not positive; more are
+// dropped and queued on a later Verify. Close stops the workers.
+func WithAsync(workers, queueCap int) Option {
+	return func(v *Verifier) {
+		v.workers = workers
+		v.queueCap = queueCap
+		if v.workers < 1 {
+			v.workers = 1
+		}
+		if v.queueCap <= 0 {
+			v.queueCap = DefaultQueueCap
+		}
+	}
+}
+
+// entry is a cached lookup: the confirmed hostnames of an IP.
+type entry struct {
+	ip      string
+	hosts   []string
+	expires time.Time
+}
+
+// Verifier verifies bots by reverse DNS.
+type Verifier struct {
+	resolver    Resolver
+	timeout     time.Duration
+	concurrency int
+	cacheTTL    time.Duration
+	cacheSize   int
+	workers     int // 0 for synchronous lookups
+	queueCap    int
+
+	slots chan struct{}
+
+	mu      sync.Mutex
+	cache   map[string]*list.Element
+	lru     *list.List      // *entry, most recently used first
+	pending map[string]bool // queued or being looked up
+
+	queue  chan string
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New returns a verifier.
+func New(opts ...Option) *Verifier {
+	v := &Verifier{
+		resolver:    net.DefaultResolver,
+		timeout:     DefaultTimeout,
+		concurrency: DefaultConcurrency,
+		cacheTTL:    DefaultCacheTTL,
+		cacheSize:   DefaultCacheSize,
+		cache:       make(map[string]*list.Element),
+		lru:         list.New(),
+		pending:     make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+	if v.concurrency < 1 {
+		v.concurrency = 1
+	}
+
+	v.slots = make(chan struct{}, v.concurrency)
+	ctx, cancel := context.WithCancel(context.Background())
+	v.cancel = cancel
+	if v.workers > 0 {
+		v.queue = make(chan string, v.queueCap)
+		for i := 0; i < v.workers; i++ {
+			v.wg.Add(1)
+			go v.work(ctx)
+		}
+	}
+	return v
+}
+
+// Close stops the background lookups. IPs still queued are dropped.
+func (v *Verifier) Close() error {
+	v.cancel()
+	v.wg.Wait()
+	return nil
+}
+
+func (v *Verifier) work(ctx context.Context) {
+	defer v.wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ip := <-v.queue:
+			v.resolve(ctx, ip)
+			v.mu.Lock()
+			delete(v.pending, ip)
+			v.mu.Unlock()
+		}
+	}
+}
+
+// Verify reports whether ip belongs to a bot of domains: verified if a
+// confirmed hostname of ip is one of domains or a subdomain, failed if
+// none is, pending if the lookups failed or timed out, or, with
+// WithAsync, are yet to complete.
+func (v *Verifier) Verify(ctx context.Context, ip string, domains []string) knownbots.ResultStatus {
+	v.mu.Lock()
+	if elem, cached := v.cache[ip]; cached {
+		if e := elem.Value.(*entry); time.Now().Before(e.expires) {
+			v.lru.MoveToFront(elem)
+			v.mu.Unlock()
+			return match(e.hosts, domains)
+		}
+	}
+	if v.queue == nil {
+		v.mu.Unlock()
+		hosts, err := v.resolve(ctx, ip)
+		if err != nil {
+			return knownbots.StatusPending
+		}
+		return match(hosts, domains)
+	}
+
+	if !v.pending[ip] {
+		select {
+		case v.queue <- ip:
+			v.pending[ip] = true
+		default:
+		}
+	}
+	v.mu.Unlock()
+	return knownbots.StatusPending
+}
+
+// resolve looks ip up within the timeout, once a slot is free, and
+// caches its hostnames unless the lookup failed.
+func (v *Verifier) resolve(ctx context.Context, ip string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	select {
+	case v.slots <- struct{}{}:
+		defer func() { <-v.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	hosts, err := v.lookup(ctx, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{ip: ip, hosts: hosts, expires: time.Now().Add(v.cacheTTL)}
+	v.mu.Lock()
+	if elem, cached := v.cache[ip]; cached {
+		elem.Value = e
+		v.lru.MoveToFront(elem)
+	} else {
+		if v.lru.Len() >= v.cacheSize {
+			v.evict()
+		}
+		v.cache[ip] = v.lru.PushFront(e)
+	}
+	v.mu.Unlock()
+	return hosts, nil
+}
+
+// lookup returns the hostnames of ip resolving back to it, none if ip
+// has no PTR record.
+func (v *Verifier) lookup(ctx context.Context, ip string) ([]string, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, nil
+	}
+	addr = addr.Unmap()
+
+	names, err := v.resolver.LookupAddr(ctx, addr.String())
+	if err != nil {
+		if notFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var hosts []string
+	for _, name := range names {
+		host := strings.ToLower(strings.TrimSuffix(name, "."))
+		ips, err := v.resolver.LookupHost(ctx, host)
+		if err != nil {
+			if notFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		for _, s := range ips {
+			if a, err := netip.ParseAddr(s); err == nil && a.Unmap() == addr {
+				hosts = append(hosts, host)
+				break
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// evict makes room in the cache, dropping the least recently used
+// lookup. It is called under mu.
+func (v *Verifier) evict() {
+	if elem := v.lru.Back(); elem != nil {
+		v.lru.Remove(elem)
+		delete(v.cache, elem.Value.(*entry).ip)
+	}
+}
+
+// match returns verified if one of hosts is in domains, failed if not.
+func match(hosts, domains []string) knownbots.ResultStatus {
+	for _, host := range hosts {
+		for _, domain := range domains {
+			if host == domain || strings.HasSuffix(host, "."+domain) {
+				return knownbots.StatusVerified
+			}
+		}
+	}
+	return knownbots.StatusFailed
+}
+
+func notFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
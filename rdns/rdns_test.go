@@ -0,0 +1,191 @@
+package rdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/knownbots"
+)
+
+// fakeResolver answers from PTR and A records, and fails for err.
+type fakeResolver struct {
+	ptr     map[string][]string
+	hosts   map[string][]string
+	err     error
+	lookups atomic.Int32
+	block   chan struct{}
+}
+
+func (r *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lookups.Add(1)
+	if r.block != nil {
+		select {
+		case <-r.block:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	names, ok := r.ptr[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	ips, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func newResolver() *fakeResolver {
+	return &fakeResolver{
+		ptr: map[string][]string{
+			"66.249.66.1":  {"crawl-66-249-66-1.googlebot.com."},
+			"203.0.113.1":  {"crawl-66-249-66-1.googlebot.com."}, // spoofed PTR
+			"198.51.100.1": {"host.example.com."},
+		},
+		hosts: map[string][]string{
+			"crawl-66-249-66-1.googlebot.com": {"66.249.66.1"},
+			"host.example.com":                {"198.51.100.1"},
+		},
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	r := newResolver()
+	v := New(WithResolver(r))
+	domains := []string{"googlebot.com", "google.com"}
+
+	tests := []struct {
+		ip   string
+		want knownbots.ResultStatus
+	}{
+		{"66.249.66.1", knownbots.StatusVerified},
+		{"::ffff:66.249.66.1", knownbots.StatusVerified},
+		{"203.0.113.1", knownbots.StatusFailed},
+		{"198.51.100.1", knownbots.StatusFailed},
+		{"192.0.2.1", knownbots.StatusFailed},
+		{"not an ip", knownbots.StatusFailed},
+	}
+	for _, tt := range tests {
+		if got := v.Verify(context.Background(), tt.ip, domains); got != tt.want {
+			t.Errorf("Verify(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	n := r.lookups.Load()
+	if got := v.Verify(context.Background(), "66.249.66.1", domains); got != knownbots.StatusVerified {
+		t.Errorf("cached Verify() = %v, want verified", got)
+	}
+	if r.lookups.Load() != n {
+		t.Error("verified IPs should be cached")
+	}
+	if got := v.Verify(context.Background(), "198.51.100.1", []string{"example.com"}); got != knownbots.StatusVerified {
+		t.Errorf("cached hosts should match other domains, got %v", got)
+	}
+}
+
+func TestVerifier_Pending(t *testing.T) {
+	r := newResolver()
+	r.err = errors.New("connection refused")
+	v := New(WithResolver(r))
+
+	for i := 0; i < 2; i++ {
+		if got := v.Verify(context.Background(), "66.249.66.1", []string{"googlebot.com"}); got != knownbots.StatusPending {
+			t.Errorf("Verify() = %v, want pending", got)
+		}
+	}
+	if r.lookups.Load() != 2 {
+		t.Errorf("pending results should not be cached, got %d lookups", r.lookups.Load())
+	}
+}
+
+func TestVerifier_Cache_Evict(t *testing.T) {
+	r := newResolver()
+	v := New(WithResolver(r), WithCache(time.Hour, 2))
+
+	v.Verify(context.Background(), "66.249.66.1", []string{"googlebot.com"})
+	v.Verify(context.Background(), "198.51.100.1", []string{"example.com"})
+	v.Verify(context.Background(), "66.249.66.1", []string{"googlebot.com"})
+	v.Verify(context.Background(), "203.0.113.1", []string{"googlebot.com"})
+	if r.lookups.Load() != 3 {
+		t.Fatalf("lookups = %d, want 3", r.lookups.Load())
+	}
+
+	// 198.51.100.1 was used least recently, so it was evicted
+	v.Verify(context.Background(), "66.249.66.1", []string{"googlebot.com"})
+	v.Verify(context.Background(), "198.51.100.1", []string{"example.com"})
+	if r.lookups.Load() != 4 {
+		t.Errorf("lookups = %d, want the least recently used IP looked up again", r.lookups.Load())
+	}
+}
+
+func TestWithServers_Empty(t *testing.T) {
+	r := newResolver()
+	v := New(WithResolver(r), WithServers())
+	if v.resolver != r {
+		t.Error("WithServers() without servers should keep the resolver")
+	}
+	if got := v.Verify(context.Background(), "66.249.66.1", []string{"googlebot.com"}); got != knownbots.StatusVerified {
+		t.Errorf("Verify() = %v, want verified", got)
+	}
+}
+
+func TestVerifier_Concurrency(t *testing.T) {
+	r := newResolver()
+	r.block = make(chan struct{})
+	v := New(WithResolver(r), WithConcurrency(1), WithTimeout(50*time.Millisecond))
+
+	done := make(chan knownbots.ResultStatus)
+	go func() {
+		done <- v.Verify(context.Background(), "66.249.66.1", []string{"googlebot.com"})
+	}()
+	for r.lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The only slot is taken
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if got := v.Verify(ctx, "198.51.100.1", []string{"example.com"}); got != knownbots.StatusPending {
+		t.Errorf("Verify() without a slot = %v, want pending", got)
+	}
+	if r.lookups.Load() != 1 {
+		t.Errorf("lookups = %d, want 1", r.lookups.Load())
+	}
+	if got := <-done; got != knownbots.StatusPending {
+		t.Errorf("Verify() past the timeout = %v, want pending", got)
+	}
+}
+
+func TestVerifier_Async(t *testing.T) {
+	r := newResolver()
+	v := New(WithResolver(r), WithAsync(2, 10))
+	defer v.Close()
+
+	domains := []string{"googlebot.com"}
+	if got := v.Verify(context.Background(), "66.249.66.1", domains); got != knownbots.StatusPending {
+		t.Errorf("Verify() before the lookup = %v, want pending", got)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for v.Verify(context.Background(), "66.249.66.1", domains) != knownbots.StatusVerified {
+		if time.Now().After(deadline) {
+			t.Fatal("IP not verified in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if r.lookups.Load() != 1 {
+		t.Errorf("queued IPs should be looked up once, got %d lookups", r.lookups.Load())
+	}
+}
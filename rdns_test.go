@@ -0,0 +1,90 @@
+package botrate
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/botdata"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/botrate/rdns"
+	"github.com/cnlangzi/knownbots"
+)
+
+// hostsResolver resolves PTR and A records both ways from hostnames by IP.
+type hostsResolver map[string]string
+
+func (r hostsResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	if host, ok := r[addr]; ok {
+		return []string{host + "."}, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+}
+
+func (r hostsResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	for ip, h := range r {
+		if h == host {
+			return []string{ip}, nil
+		}
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// rdnsRoot returns a knownbots root with TestBot, verified by RDNS from
+// crawl.example.
+func rdnsRoot(t *testing.T) string {
+	t.Helper()
+
+	conf := "kind: SearchEngine\nname: testbot\nua: \"TestBot\"\nrdns: true\ndomains:\n  - \"crawl.example\"\n"
+	return testbots.Root(t, map[string]string{"testbot": conf})
+}
+
+func TestLimiter_WithRDNS(t *testing.T) {
+	l, err := New(
+		WithBotData(botdata.WithRoot(rdnsRoot(t)), botdata.WithOffline()),
+		WithRDNS(rdns.WithResolver(hostsResolver{"198.51.100.1": "bot-1.crawl.example"})),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("TestBot/1.0", "198.51.100.1", "/"); !d.Allowed || d.Verification != knownbots.StatusVerified {
+		t.Errorf("testbot should be verified by its hostname, got %+v", d)
+	}
+	if d := l.Decide("TestBot/1.0", "198.51.100.2", "/"); d.Allowed || d.Reason != ReasonFakeBot {
+		t.Errorf("testbot without a hostname should be fake, got %+v", d)
+	}
+	if d := l.Decide("Mozilla/5.0", "198.51.100.2", "/"); !d.Allowed || d.Bot != "" {
+		t.Errorf("normal users should not be verified, got %+v", d)
+	}
+}
+
+func TestLimiter_WithRDNSAsync(t *testing.T) {
+	l, err := New(
+		WithBotData(botdata.WithRoot(rdnsRoot(t)), botdata.WithOffline()),
+		WithRDNS(rdns.WithResolver(hostsResolver{"198.51.100.1": "bot-1.crawl.example"}), rdns.WithAsync(1, 0)),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Provisional until the lookup completes
+	if d := l.Decide("TestBot/1.0", "198.51.100.1", "/"); !d.Allowed || d.Verification != knownbots.StatusPending {
+		t.Errorf("testbot should be pending, got %+v", d)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		d := l.Decide("TestBot/1.0", "198.51.100.1", "/")
+		if d.Verification == knownbots.StatusVerified {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("testbot not verified in the background, got %+v", d)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
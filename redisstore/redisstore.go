@@ -0,0 +1,306 @@
+// Package redisstore provides a Redis-backed blocklist store so that
+// multiple botrate instances share blocked-IP state.
+//
+// Blocked IPs are kept in a Redis hash mapping the IP to its expiry as a
+// Unix timestamp in milliseconds (0 never expires), and changes are broadcast over
+// Pub/Sub. Each instance keeps a local mirror of the hash, so lookups
+// on the request hot path never touch the network.
+package redisstore
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Default configuration values.
+var (
+	DefaultKey          = "botrate:blocklist"
+	DefaultSyncInterval = 30 * time.Second
+	DefaultTimeout      = time.Second
+)
+
+// Option is a functional option for configuring Store.
+type Option func(*Store)
+
+// WithKey sets the Redis key of the blocklist hash. The Pub/Sub channel
+// uses the same name.
+func WithKey(key string) Option {
+	return func(s *Store) {
+		s.key = key
+	}
+}
+
+// WithSyncInterval sets how often the local mirror is fully reloaded
+// from Redis, as a safety net for missed Pub/Sub messages.
+func WithSyncInterval(d time.Duration) Option {
+	return func(s *Store) {
+		s.syncInterval = d
+	}
+}
+
+// WithTimeout sets the timeout for individual Redis commands.
+func WithTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.timeout = d
+	}
+}
+
+// Store is a Redis-backed blocklist. It implements analyzer.Store.
+type Store struct {
+	client       redis.UniversalClient
+	key          string
+	syncInterval time.Duration
+	timeout      time.Duration
+
+	// Local mirror of the Redis hash: IP -> expiry
+	mirror atomic.Pointer[map[string]time.Time]
+	mu     sync.Mutex
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates a Store, loads the current blocklist and starts listening
+// for changes from other instances.
+func New(client redis.UniversalClient, opts ...Option) (*Store, error) {
+	s := &Store{
+		client:       client,
+		key:          DefaultKey,
+		syncInterval: DefaultSyncInterval,
+		timeout:      DefaultTimeout,
+		done:         make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	m := make(map[string]time.Time)
+	s.mirror.Store(&m)
+
+	if err := s.Sync(context.Background()); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
+	sub := client.Subscribe(ctx, s.key)
+	// Wait for the subscription to be confirmed so no change is missed
+	// between the initial sync and the first message.
+	if _, err := sub.Receive(ctx); err != nil {
+		cancel()
+		sub.Close()
+		return nil, err
+	}
+
+	go s.run(ctx, sub)
+	return s, nil
+}
+
+// Blocked reports whether ip is blocked, using the local mirror.
+func (s *Store) Blocked(ip string) bool {
+	_, exists := (*s.mirror.Load())[ip]
+	return exists
+}
+
+// Len returns the number of blocked IPs in the local mirror.
+func (s *Store) Len() int {
+	return len(*s.mirror.Load())
+}
+
+// Range calls fn for each IP in the local mirror until fn returns false.
+func (s *Store) Range(fn func(ip string, until time.Time) bool) {
+	for ip, until := range *s.mirror.Load() {
+		if !fn(ip, until) {
+			return
+		}
+	}
+}
+
+// Block adds ip to the shared blocklist for ttl and notifies other
+// instances. A ttl <= 0 never expires.
+func (s *Store) Block(ip string, ttl time.Duration) error {
+	var until time.Time
+	if ttl > 0 {
+		until = time.Now().Add(ttl)
+	}
+	s.set(ip, until)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, s.key, ip, formatUntil(until))
+		pipe.Publish(ctx, s.key, ip+" "+formatUntil(until))
+		return nil
+	})
+	return err
+}
+
+// Unblock removes ip from the shared blocklist and notifies other instances.
+func (s *Store) Unblock(ip string) error {
+	s.delete(ip)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.key, ip)
+		pipe.Publish(ctx, s.key, ip)
+		return nil
+	})
+	return err
+}
+
+// Expire removes entries that expired before now, both from the local
+// mirror and from Redis. Every instance sweeps; HDEL is idempotent.
+func (s *Store) Expire(now time.Time) error {
+	expired := s.expire(now)
+	if len(expired) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	return s.client.HDel(ctx, s.key, expired...).Err()
+}
+
+// Sync reloads the local mirror from Redis.
+func (s *Store) Sync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	entries, err := s.client.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return err
+	}
+
+	m := make(map[string]time.Time, len(entries))
+	for ip, v := range entries {
+		m[ip] = parseUntil(v)
+	}
+
+	s.mu.Lock()
+	s.mirror.Store(&m)
+	s.mu.Unlock()
+	return nil
+}
+
+// Close stops listening for changes. It does not close the Redis client.
+func (s *Store) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *Store) run(ctx context.Context, sub *redis.PubSub) {
+	defer close(s.done)
+	defer sub.Close()
+
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			// "ip until" blocks, a bare "ip" unblocks
+			ip, until, found := strings.Cut(msg.Payload, " ")
+			if !found {
+				s.delete(ip)
+				continue
+			}
+			s.set(ip, parseUntil(until))
+		case <-ticker.C:
+			// Keep the previous mirror if Redis is unreachable
+			_ = s.Sync(ctx)
+		}
+	}
+}
+
+func (s *Store) set(ip string, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.mirror.Load()
+	if cur, exists := old[ip]; exists && cur.Equal(until) {
+		return
+	}
+
+	m := make(map[string]time.Time, len(old)+1)
+	for k, v := range old {
+		m[k] = v
+	}
+	m[ip] = until
+
+	s.mirror.Store(&m)
+}
+
+func (s *Store) delete(ip string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.mirror.Load()
+	if _, exists := old[ip]; !exists {
+		return
+	}
+
+	m := make(map[string]time.Time, len(old))
+	for k, v := range old {
+		if k != ip {
+			m[k] = v
+		}
+	}
+
+	s.mirror.Store(&m)
+}
+
+func (s *Store) expire(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := *s.mirror.Load()
+
+	var expired []string
+	m := make(map[string]time.Time, len(old))
+	for k, v := range old {
+		if !v.IsZero() && !now.Before(v) {
+			expired = append(expired, k)
+			continue
+		}
+		m[k] = v
+	}
+
+	if len(expired) > 0 {
+		s.mirror.Store(&m)
+	}
+	return expired
+}
+
+func formatUntil(until time.Time) string {
+	if until.IsZero() {
+		return "0"
+	}
+	return strconv.FormatInt(until.UnixMilli(), 10)
+}
+
+func parseUntil(v string) time.Time {
+	ms, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
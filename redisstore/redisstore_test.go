@@ -0,0 +1,169 @@
+package redisstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/redis/go-redis/v9"
+)
+
+var _ analyzer.Store = (*Store)(nil)
+
+func newStore(t *testing.T, mr *miniredis.Miniredis, opts ...Option) *Store {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	s, err := New(client, opts...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func waitBlocked(t *testing.T, s *Store, ip string) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !s.Blocked(ip) {
+		if time.Now().After(deadline) {
+			t.Fatalf("IP %s should be blocked", ip)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStore_Block(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newStore(t, mr)
+
+	if s.Blocked("192.168.1.1") {
+		t.Error("new store should be empty")
+	}
+
+	if err := s.Block("192.168.1.1", 0); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	if !s.Blocked("192.168.1.1") {
+		t.Error("IP should be blocked locally right away")
+	}
+
+	if !mr.Exists(DefaultKey) || mr.HGet(DefaultKey, "192.168.1.1") == "" {
+		t.Error("IP should be written to redis")
+	}
+}
+
+func TestStore_Shared(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s1 := newStore(t, mr)
+	s2 := newStore(t, mr)
+
+	if err := s1.Block("192.168.1.1", 0); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+
+	waitBlocked(t, s2, "192.168.1.1")
+}
+
+func TestStore_InitialLoad(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.HSet(DefaultKey, "192.168.1.1", "0")
+
+	s := newStore(t, mr)
+
+	if !s.Blocked("192.168.1.1") {
+		t.Error("existing entries should be loaded on start")
+	}
+}
+
+func TestStore_Sync(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s := newStore(t, mr, WithKey("custom"))
+
+	mr.HSet("custom", "192.168.1.1", "0")
+
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatalf("Sync() returned error: %v", err)
+	}
+
+	if !s.Blocked("192.168.1.1") {
+		t.Error("Sync() should reload entries from redis")
+	}
+}
+
+func TestStore_Unreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	defer client.Close()
+
+	if _, err := New(client); err == nil {
+		t.Error("New() should fail when redis is unreachable")
+	}
+}
+
+func TestStore_Expire(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s1 := newStore(t, mr)
+	s2 := newStore(t, mr)
+
+	s1.Block("192.168.1.1", time.Minute)
+	s1.Block("192.168.1.2", 0)
+	waitBlocked(t, s2, "192.168.1.1")
+
+	if err := s1.Expire(time.Now().Add(2 * time.Minute)); err != nil {
+		t.Fatalf("Expire() returned error: %v", err)
+	}
+
+	if s1.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked after its TTL")
+	}
+	if !s1.Blocked("192.168.1.2") {
+		t.Error("IP without TTL should never expire")
+	}
+	if mr.HGet(DefaultKey, "192.168.1.1") != "" {
+		t.Error("expired IP should be removed from redis")
+	}
+
+	// Other instances expire their own mirror on their own sweep.
+	s2.Expire(time.Now().Add(2 * time.Minute))
+	if s2.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked on every instance")
+	}
+}
+
+func TestStore_Unblock(t *testing.T) {
+	mr := miniredis.RunT(t)
+	s1 := newStore(t, mr)
+	s2 := newStore(t, mr)
+
+	s1.Block("192.168.1.1", 0)
+	waitBlocked(t, s2, "192.168.1.1")
+
+	if err := s1.Unblock("192.168.1.1"); err != nil {
+		t.Fatalf("Unblock() returned error: %v", err)
+	}
+
+	if s1.Blocked("192.168.1.1") {
+		t.Error("IP should be unblocked locally right away")
+	}
+	if mr.HGet(DefaultKey, "192.168.1.1") != "" {
+		t.Error("IP should be removed from redis")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s2.Blocked("192.168.1.1") {
+		if time.Now().After(deadline) {
+			t.Fatal("unblock should propagate to other instances")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,279 @@
+package botrate
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultConfigWatchInterval is how often WatchConfigFile checks the file
+// for changes by default.
+var DefaultConfigWatchInterval = 10 * time.Second
+
+// ApplyConfig changes the configuration of the running limiter to cfg,
+// keeping what it learned: blocks, greylists, counts, offenses and the
+// tokens of throttled clients. A threshold can so be tightened during an
+// attack without a restart forgetting the blocks so far. Start from
+// Config to change a few fields.
+//
+// These fields of cfg apply, from the next request:
+//
+//   - limits: Limit, Burst, FakeBotLimit, GreylistLimit, GreylistBurst,
+//     AIBotLimit, AIBotBurst, TorLimit, TorBurst, BotLimits, and
+//     MessageLimit and MessageBurst for new connections
+//   - analysis: Window, from now as for SetWindow, PageThreshold,
+//     RequestThreshold, ErrorThreshold, LoginThreshold, ScoreThreshold,
+//     GreylistThreshold, UserPageThreshold, PrefixThreshold,
+//     HeaderThreshold, SequenceThreshold, QueryThreshold, MaxUALength,
+//     BadUAPageThreshold, MethodWeights, MethodThresholds,
+//     UAClassPageThresholds, and BlockDuration for new blocks
+//   - lists: AllowCIDRs, DenyCIDRs, DenyTLSFingerprints, SkipPaths,
+//     HoneypotPaths, LoginPaths and LoginStatuses, replacing the IPs
+//     added with AddAllow and AddDeny
+//   - policies: Actions, AIBotPolicies, TorPolicy, BadUAPolicy, DryRun,
+//     ExemptUsers and PathPolicies, whose clients start over with their
+//     tokens on a change
+//
+// The other fields are ignored: changing them takes a new limiter.
+// Throttled clients keep their token buckets, set to the new limits;
+// verified bots start over with theirs. Nothing changes if cfg has an
+// invalid CIDR or path pattern. SetLimit, SetPageThreshold and SetWindow
+// change a single field.
+func (l *Limiter) ApplyConfig(cfg Config) error {
+	allow, err := parsePrefixes(cfg.AllowCIDRs)
+	if err != nil {
+		return err
+	}
+	deny, err := parsePrefixes(cfg.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+	for _, patterns := range [][]string{cfg.SkipPaths, cfg.HoneypotPaths, cfg.LoginPaths} {
+		if err := checkPatterns(patterns); err != nil {
+			return err
+		}
+	}
+	policies, err := newPathPolicies(cfg.PathPolicies)
+	if err != nil {
+		return err
+	}
+
+	l.update(func(next *Config) {
+		next.Limit, next.Burst, next.FakeBotLimit = cfg.Limit, cfg.Burst, cfg.FakeBotLimit
+		next.GreylistLimit, next.GreylistBurst = cfg.GreylistLimit, cfg.GreylistBurst
+		next.AIBotLimit, next.AIBotBurst = cfg.AIBotLimit, cfg.AIBotBurst
+		next.TorLimit, next.TorBurst = cfg.TorLimit, cfg.TorBurst
+		next.BotLimits = maps.Clone(cfg.BotLimits)
+		next.MessageLimit, next.MessageBurst = cfg.MessageLimit, cfg.MessageBurst
+
+		next.Window = cfg.Window
+		next.PageThreshold, next.RequestThreshold, next.ErrorThreshold = cfg.PageThreshold, cfg.RequestThreshold, cfg.ErrorThreshold
+		next.LoginThreshold, next.ScoreThreshold, next.GreylistThreshold = cfg.LoginThreshold, cfg.ScoreThreshold, cfg.GreylistThreshold
+		next.UserPageThreshold, next.BlockDuration = cfg.UserPageThreshold, cfg.BlockDuration
+		next.PrefixThreshold, next.HeaderThreshold = cfg.PrefixThreshold, cfg.HeaderThreshold
+		next.SequenceThreshold, next.QueryThreshold = cfg.SequenceThreshold, cfg.QueryThreshold
+		next.MaxUALength, next.BadUAPageThreshold = cfg.MaxUALength, cfg.BadUAPageThreshold
+		next.MethodWeights, next.MethodThresholds = maps.Clone(cfg.MethodWeights), maps.Clone(cfg.MethodThresholds)
+		next.UAClassPageThresholds = maps.Clone(cfg.UAClassPageThresholds)
+
+		next.AllowCIDRs, next.DenyCIDRs = slices.Clone(cfg.AllowCIDRs), slices.Clone(cfg.DenyCIDRs)
+		next.DenyTLSFingerprints = slices.Clone(cfg.DenyTLSFingerprints)
+		next.SkipPaths, next.HoneypotPaths = slices.Clone(cfg.SkipPaths), slices.Clone(cfg.HoneypotPaths)
+		next.LoginPaths, next.LoginStatuses = slices.Clone(cfg.LoginPaths), slices.Clone(cfg.LoginStatuses)
+
+		next.Actions, next.AIBotPolicies = maps.Clone(cfg.Actions), maps.Clone(cfg.AIBotPolicies)
+		next.TorPolicy, next.BadUAPolicy = cfg.TorPolicy, cfg.BadUAPolicy
+		next.DryRun, next.ExemptUsers = cfg.DryRun, cfg.ExemptUsers
+		if !slices.Equal(next.PathPolicies, cfg.PathPolicies) {
+			next.PathPolicies = slices.Clone(cfg.PathPolicies)
+			l.setPathPolicies(policies)
+		}
+
+		l.allow.Set(allow)
+		l.deny.Set(deny)
+		l.denyTLS.Set(next.DenyTLSFingerprints)
+		l.skip.Set(next.SkipPaths)
+		l.honeypot.Set(next.HoneypotPaths)
+		l.login.Set(next.LoginPaths)
+	})
+	return nil
+}
+
+// SetLimit sets the limit of blocked clients, see WithLimit. Those
+// throttled already keep their tokens.
+func (l *Limiter) SetLimit(limit rate.Limit) {
+	l.update(func(next *Config) {
+		next.Limit = limit
+	})
+}
+
+// SetPageThreshold sets the distinct pages per window that block a
+// client, see WithAnalyzerPageThreshold. The pages counted so far count
+// against it.
+func (l *Limiter) SetPageThreshold(n int) {
+	l.update(func(next *Config) {
+		next.PageThreshold = n
+	})
+}
+
+// SetWindow sets the analysis window, see WithAnalyzerWindow: the current
+// window ends d from now, keeping its counts, and the next ones last d.
+// A d <= 0 is DefaultWindow.
+func (l *Limiter) SetWindow(d time.Duration) {
+	l.update(func(next *Config) {
+		next.Window = d
+	})
+}
+
+// update changes the configuration in effect with fn and applies the
+// result to the analyzer and the token buckets. Changes are serialized,
+// so concurrent ones are not lost.
+func (l *Limiter) update(fn func(next *Config)) {
+	l.applying.Lock()
+	defer l.applying.Unlock()
+
+	prev := l.config()
+	next := *prev
+	fn(&next)
+
+	if next.Window <= 0 {
+		next.Window = DefaultWindow
+	}
+	next.defaultLogin()
+	if l.tor != nil && next.TorPolicy == TorChallenge {
+		next.defaultAction(ReasonTor, ActionChallenge)
+	}
+	if next.BadUAPolicy == BadUAChallenge {
+		next.defaultAction(ReasonBadUA, ActionChallenge)
+	}
+
+	l.live.Store(&next)
+	l.analyzer.SetThresholds(next.thresholds())
+	if next.Window != prev.Window {
+		l.analyzer.SetWindow(next.Window)
+	}
+	l.retune(prev, &next)
+
+	hash := next.hash()
+	l.configHash.Store(&hash)
+	if l.audit != nil {
+		l.audit.SetConfigHash(hash)
+	}
+	l.logger.Info("botrate: configuration applied", "config", hash)
+}
+
+// retune sets the changed limits on the token buckets of throttled
+// clients, keeping their tokens, and drops those of verified bots on a
+// change of their limits, which depend on the bot, to be created anew.
+func (l *Limiter) retune(prev, next *Config) {
+	now := l.cfg.Clock.Now()
+	for _, b := range []struct {
+		m                  *sync.Map
+		fromLimit, toLimit rate.Limit
+		fromBurst, toBurst int
+	}{
+		{&l.blocked, prev.Limit, next.Limit, prev.Burst, next.Burst},
+		{&l.fakeBots, prev.FakeBotLimit, next.FakeBotLimit, prev.Burst, next.Burst},
+		{&l.greylisted, prev.GreylistLimit, next.GreylistLimit, prev.GreylistBurst, next.GreylistBurst},
+		{&l.torExits, prev.TorLimit, next.TorLimit, prev.TorBurst, next.TorBurst},
+		{&l.aiBots, prev.AIBotLimit, next.AIBotLimit, prev.AIBotBurst, next.AIBotBurst},
+	} {
+		if b.fromLimit == b.toLimit && b.fromBurst == b.toBurst {
+			continue
+		}
+		b.m.Range(func(_, value any) bool {
+			bucket := value.(*tokenBucket)
+			bucket.SetLimitAt(now, b.toLimit)
+			bucket.SetBurstAt(now, b.toBurst)
+			return true
+		})
+	}
+
+	if maps.Equal(prev.BotLimits, next.BotLimits) {
+		return
+	}
+	l.bots.Range(func(key, _ any) bool {
+		if _, loaded := l.bots.LoadAndDelete(key); loaded {
+			l.counters.limiters.Add(-1)
+		}
+		return true
+	})
+}
+
+// setPathPolicies replaces the path policies with p, dropping the token
+// buckets of the old ones.
+func (l *Limiter) setPathPolicies(p *pathPolicies) {
+	for _, m := range l.pathPolicies.Swap(p).buckets() {
+		m.Range(func(key, _ any) bool {
+			if _, loaded := m.LoadAndDelete(key); loaded {
+				l.counters.limiters.Add(-1)
+			}
+			return true
+		})
+	}
+}
+
+// ReloadConfigFile applies the FileConfig at path, then opts, to the
+// running limiter, see ApplyConfig. Pass the options given to
+// NewFromConfigFile, so the settings they override stay overridden.
+func (l *Limiter) ReloadConfigFile(path string, opts ...Option) error {
+	c, err := LoadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	fileOpts, err := c.Options()
+	if err != nil {
+		return fmt.Errorf("botrate: %s: %w", path, err)
+	}
+
+	scratch := &Limiter{cfg: defaultConfig()}
+	for _, opt := range append(fileOpts, opts...) {
+		opt(scratch)
+	}
+	if err := l.ApplyConfig(scratch.cfg); err != nil {
+		return fmt.Errorf("botrate: %s: %w", path, err)
+	}
+	return nil
+}
+
+// WatchConfigFile reloads the FileConfig at path with ReloadConfigFile
+// whenever it changes, checking every interval, DefaultConfigWatchInterval
+// if <= 0, until ctx is done. It is meant to run in its own goroutine,
+// e.g. with the file mounted from a Kubernetes ConfigMap, which is
+// updated in place. A file failing to load is logged and the
+// configuration in effect kept.
+func (l *Limiter) WatchConfigFile(ctx context.Context, path string, interval time.Duration, opts ...Option) {
+	if interval <= 0 {
+		interval = DefaultConfigWatchInterval
+	}
+
+	last, _ := os.Stat(path)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			l.logger.Warn("botrate: failed to check configuration file", "path", path, "error", err)
+			continue
+		}
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		if err := l.ReloadConfigFile(path, opts...); err != nil {
+			l.logger.Warn("botrate: failed to reload configuration file", "path", path, "error", err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package botrate
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_ApplyConfig(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(10),
+		WithLimit(rate.Every(time.Hour)),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua = "Mozilla/5.0"
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e"} {
+		if allowed, _ := l.Allow(ua, "1.2.3.4", path); !allowed {
+			t.Fatalf("request to %s should be allowed below the threshold", path)
+		}
+	}
+	if err := l.Block("5.6.7.8", 0); err != nil {
+		t.Fatal(err)
+	}
+	l.Allow(ua, "5.6.7.8", "/") // creates the blocked IP's bucket
+	hash := l.ConfigHash()
+
+	cfg := l.Config()
+	cfg.PageThreshold = 6
+	cfg.Limit = rate.Inf
+	cfg.DenyCIDRs = []string{"9.9.9.0/24"}
+	cfg.QueueCap = 1 // ignored
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() returned error: %v", err)
+	}
+
+	if l.ConfigHash() == hash {
+		t.Error("config hash should change")
+	}
+	if got := l.Config(); got.PageThreshold != 6 || got.QueueCap != DefaultQueueCap {
+		t.Errorf("unexpected config, threshold %d, queue cap %d", got.PageThreshold, got.QueueCap)
+	}
+	if allowed, reason := l.Allow(ua, "9.9.9.9", "/"); allowed || reason != ReasonDenied {
+		t.Errorf("new denylist should apply, got %v, %s", allowed, reason)
+	}
+
+	// The counts are kept: the 6th page reaches the new threshold
+	l.Allow(ua, "1.2.3.4", "/f")
+	if !l.analyzer.Blocked("1.2.3.4") {
+		t.Error("IP should be blocked at the new threshold")
+	}
+
+	// The blocks too, with the new limit on the existing bucket
+	if allowed, _ := l.Allow(ua, "5.6.7.8", "/"); !allowed {
+		t.Error("blocked IP should be allowed with an infinite limit")
+	}
+	if !l.analyzer.Blocked("5.6.7.8") {
+		t.Error("manual block should be kept")
+	}
+
+	cfg.AllowCIDRs = []string{"not a cidr"}
+	if err := l.ApplyConfig(cfg); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+	if allowed, _ := l.Allow(ua, "9.9.9.9", "/"); allowed {
+		t.Error("a failed ApplyConfig should change nothing")
+	}
+}
+
+func TestLimiter_WatchConfigFile(t *testing.T) {
+	path := writeConfigFile(t, "botrate.yaml", "page_threshold: 40\n")
+	l, err := NewFromConfigFile(path, WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("NewFromConfigFile() returned error: %v", err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		l.WatchConfigFile(ctx, path, 10*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Not a valid configuration: kept as is
+	if err := os.WriteFile(path, []byte("page_threshold: lots\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := l.Config().PageThreshold; got != 40 {
+		t.Errorf("expected the threshold to stay 40, got %d", got)
+	}
+
+	if err := os.WriteFile(path, []byte("page_threshold: 20\ndeny_cidrs: [9.9.9.9]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Config().PageThreshold != 20 {
+		if time.Now().After(deadline) {
+			t.Fatal("configuration file not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if allowed, _ := l.Allow("Mozilla/5.0", "9.9.9.9", "/"); allowed {
+		t.Error("reloaded denylist should apply")
+	}
+}
+
+func TestLimiter_Setters(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(10),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua = "Mozilla/5.0"
+	for _, path := range []string{"/a", "/b", "/c"} {
+		l.Allow(ua, "1.2.3.4", path)
+	}
+	l.SetPageThreshold(4)
+	l.SetWindow(time.Hour)
+	l.SetLimit(rate.Inf)
+
+	cfg := l.Config()
+	if cfg.PageThreshold != 4 || cfg.Window != time.Hour || cfg.Limit != rate.Inf {
+		t.Errorf("unexpected config, threshold %d, window %s, limit %v", cfg.PageThreshold, cfg.Window, cfg.Limit)
+	}
+	if got := l.analyzer.Window(); got != time.Hour {
+		t.Errorf("expected the analyzer window to be 1h, got %s", got)
+	}
+
+	// The 4th page reaches the new threshold; the new limit lets it through
+	l.Allow(ua, "1.2.3.4", "/d")
+	if !l.analyzer.Blocked("1.2.3.4") {
+		t.Fatal("IP should be blocked at the new threshold")
+	}
+	if allowed, _ := l.Allow(ua, "1.2.3.4", "/e"); !allowed {
+		t.Error("blocked IP should be allowed with an infinite limit")
+	}
+
+	l.SetWindow(0)
+	if got := l.Config().Window; got != DefaultWindow {
+		t.Errorf("expected the default window, got %s", got)
+	}
+}
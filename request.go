@@ -0,0 +1,58 @@
+package botrate
+
+import "net/http"
+
+// Request describes a request to Check.
+type Request struct {
+	UA     string
+	IP     string
+	Path   string
+	Method string
+
+	// Host is the virtual host requested, e.g. the Host header. Normal
+	// users are analyzed and limited apart on the hosts given to
+	// WithHostIsolation.
+	Host string
+
+	// Cost is how many tokens the request consumes when throttled.
+	// Zero means Limiter.Cost(Path, Method).
+	Cost int
+
+	// User is the authenticated user ID, "" for anonymous requests.
+	// Users are analyzed and limited by it, unless the KeyFunc returns a
+	// key. See WithUserPageThreshold, WithExemptUsers and
+	// botratehttp.WithUserFunc.
+	User string
+
+	// Session is a verified session ID. Normal users with a session are
+	// analyzed and limited by it instead of the IP, unless the KeyFunc
+	// returns a key, though blocking their IP blocks them too. See
+	// WithSessionsPerIP and botratehttp.WithSessionCookie.
+	Session string
+
+	// Bypass reports whether the client proved it is human, e.g. by
+	// passing a challenge. Like allowlisted IPs, it bypasses verification,
+	// analysis and limiting; denylisted IPs are still rejected. See
+	// botratehttp.WithChallenge.
+	Bypass bool
+
+	// Headers is the fingerprint of the request's headers, the zero
+	// Fingerprint if unknown. See WithHeaderThreshold and
+	// HeaderFingerprint.
+	Headers Fingerprint
+
+	// TLSFingerprint is the JA3 or JA4 hash of the client's TLS
+	// handshake, "" if unknown, for servers terminating TLS themselves.
+	// See WithDenyTLSFingerprints.
+	TLSFingerprint string
+
+	// HTTP is the underlying HTTP request, if any. It is passed to the
+	// KeyFunc.
+	HTTP *http.Request
+}
+
+// KeyFunc returns the key a normal user's requests are analyzed and
+// limited by, e.g. an API key or account ID instead of the IP, so users
+// behind one NAT do not share a block. r is nil for requests not checked
+// with an HTTP request. An empty key falls back to the IP.
+type KeyFunc func(ua, ip string, r *http.Request) string
@@ -0,0 +1,341 @@
+package botrate
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Check(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithBurst(3),
+		WithCostFunc(func(path, method string) int {
+			if method == http.MethodPost {
+				return 3
+			}
+			return 1
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	// Cost defaults to the cost function
+	d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", Method: http.MethodPost})
+	if !d.Allowed || d.Remaining != 0 {
+		t.Errorf("expected POST to spend the whole burst, got %+v", d)
+	}
+
+	l.Block("192.168.1.2", time.Hour)
+
+	// An explicit cost wins
+	d = l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.2", Path: "/", Method: http.MethodPost, Cost: 1})
+	if !d.Allowed || d.Remaining != 2 {
+		t.Errorf("expected explicit cost of 1, got %+v", d)
+	}
+	if d.Key != "192.168.1.2" {
+		t.Errorf("expected the IP as key, got %q", d.Key)
+	}
+}
+
+func TestLimiter_WithKeyFunc(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Hour)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(2),
+		WithKeyFunc(func(ua, ip string, r *http.Request) string {
+			if r == nil {
+				return ""
+			}
+			return r.Header.Get("X-API-Key")
+		}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	check := func(key, path string) Decision {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		return l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: path, HTTP: r})
+	}
+
+	// Two users behind one NAT: only the scraping one is blocked
+	check("alice", "/a")
+	check("alice", "/b")
+	check("bob", "/a")
+	time.Sleep(100 * time.Millisecond)
+
+	blocked := l.Blocklist()
+	if len(blocked) != 1 || blocked[0].IP != "alice" {
+		t.Fatalf("expected only alice to be blocked, got %+v", blocked)
+	}
+
+	check("alice", "/c") // burst
+	if d := check("alice", "/c"); d.Allowed || d.Key != "alice" {
+		t.Errorf("expected alice to be limited, got %+v", d)
+	}
+	if d := check("bob", "/c"); !d.Allowed {
+		t.Errorf("expected bob to be allowed, got %+v", d)
+	}
+
+	// No key falls back to the IP
+	if d := check("", "/"); d.Key != "192.168.1.1" {
+		t.Errorf("expected the IP as fallback key, got %q", d.Key)
+	}
+	if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.1", "/"); !allowed {
+		t.Error("the shared IP should not be blocked")
+	}
+}
+
+func TestLimiter_Session(t *testing.T) {
+	l, err := New(WithKeyFunc(func(ua, ip string, r *http.Request) string {
+		if ua == "partner" {
+			return "partner"
+		}
+		return ""
+	}))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", Session: "abc"}); d.Key != "session:abc" {
+		t.Errorf("expected the session as key, got %q", d.Key)
+	}
+	if d := l.Check(Request{UA: "partner", IP: "192.168.1.1", Path: "/", Session: "abc"}); d.Key != "partner" {
+		t.Errorf("the KeyFunc should take precedence, got %q", d.Key)
+	}
+	if d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/"}); d.Key != "192.168.1.1" {
+		t.Errorf("expected the IP without a session, got %q", d.Key)
+	}
+
+	// Blocking the IP blocks its sessions
+	if err := l.Block("192.168.1.1", time.Hour); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", Session: "abc"}); !d.Blocklisted {
+		t.Errorf("session of a blocked IP should be blocked, got %+v", d)
+	}
+}
+
+func TestLimiter_User(t *testing.T) {
+	l, err := New(
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(2),
+		WithUserPageThreshold(4),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", User: "42", Session: "abc"}); d.Key != "user:42" {
+		t.Errorf("expected the user as key, got %q", d.Key)
+	}
+
+	// Users get the raised threshold, anonymous visitors the default
+	for _, p := range []string{"/a", "/b"} {
+		l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: p, User: "42"})
+		l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.2", Path: p})
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	blocked := l.Blocklist()
+	if len(blocked) != 1 || blocked[0].IP != "192.168.1.2" {
+		t.Errorf("expected only the anonymous visitor to be blocked, got %+v", blocked)
+	}
+}
+
+func TestLimiter_WithHostIsolation(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(2),
+		WithHostIsolation("shop.example.com", "blog.example.com"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	check := func(host, path string) Decision {
+		return l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: path, Host: host, User: "42"})
+	}
+	if d := check("Shop.example.com:443", "/"); d.Key != "shop.example.com/user:42" {
+		t.Errorf("expected the host and user as key, got %q", d.Key)
+	}
+
+	// A crawl of one host doesn't block the client on the others
+	check("shop.example.com", "/a")
+	if !l.analyzer.Blocked("shop.example.com/user:42") {
+		t.Fatal("client should be blocked on the crawled host")
+	}
+	if d := check("blog.example.com", "/a"); !d.Allowed || d.Blocklisted {
+		t.Errorf("client should be allowed on another host, got %+v", d)
+	}
+
+	// Without a host or on other hosts, the key is the client's
+	if d := check("", "/"); d.Key != "user:42" {
+		t.Errorf("expected the user as key, got %q", d.Key)
+	}
+	if d := check("random.example.net", "/"); d.Key != "user:42" {
+		t.Errorf("expected the user as key on a host not isolated, got %q", d.Key)
+	}
+
+	// Blocking the client blocks it on every host
+	if err := l.Block("user:42", time.Hour); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if d := check("blog.example.com", "/"); !d.Blocklisted {
+		t.Errorf("client blocked without a host should be blocked on every host, got %+v", d)
+	}
+}
+
+func TestLimiter_WithUAKeying(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSyncAnalyzer(true),
+		WithAnalyzerPageThreshold(2),
+		WithUAKeying(true),
+		WithUAKeysPerIP(3),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	check := func(ua, path string) Decision {
+		return l.Check(Request{UA: ua, IP: "192.168.1.1", Path: path})
+	}
+	key := analyzer.UAKey("192.168.1.1", "Scraper/1.0")
+	if d := check("Scraper/1.0", "/a"); d.Key != key {
+		t.Errorf("expected the IP and UA as key, got %q", d.Key)
+	}
+
+	// A UA crawling through the NAT doesn't block its other users
+	check("Scraper/1.0", "/b")
+	if !l.analyzer.Blocked(key) {
+		t.Fatal("the crawling UA should be blocked")
+	}
+	if d := check("Mozilla/5.0", "/a"); !d.Allowed || d.Blocklisted {
+		t.Errorf("other UAs behind the IP should be allowed, got %+v", d)
+	}
+
+	// Users keep their keys
+	if d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", User: "42"}); d.Key != "user:42" {
+		t.Errorf("expected the user as key, got %q", d.Key)
+	}
+
+	// UAs past the IP's keys share the IP's key
+	if d := check("Mozilla/5.0 (X11)", "/"); d.Key != analyzer.UAKey("192.168.1.1", "Mozilla/5.0 (X11)") {
+		t.Errorf("expected the IP and UA as key, got %q", d.Key)
+	}
+	if d := check("Mozilla/5.0 (Macintosh)", "/"); d.Key != "192.168.1.1" {
+		t.Errorf("expected the IP as key past the UA keys, got %q", d.Key)
+	}
+	if d := check("Mozilla/5.0", "/"); d.Key != analyzer.UAKey("192.168.1.1", "Mozilla/5.0") {
+		t.Errorf("UAs keyed already should keep their key, got %q", d.Key)
+	}
+
+	// Blocking the IP blocks every UA behind it
+	if err := l.Block("192.168.1.1", time.Hour); err != nil {
+		t.Fatalf("Block() returned error: %v", err)
+	}
+	if d := check("Mozilla/5.0", "/c"); !d.Blocklisted {
+		t.Errorf("UAs of a blocked IP should be blocked, got %+v", d)
+	}
+}
+
+func TestLimiter_WithUAKeysPerIP_Window(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithClock(clock),
+		WithAnalyzerWindow(time.Minute),
+		WithLimiterIdle(0),
+		WithUAKeying(true),
+		WithUAKeysPerIP(1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	check := func(ua string) Decision {
+		return l.Check(Request{UA: ua, IP: "192.168.1.1", Path: "/"})
+	}
+	check("Mozilla/5.0")
+	if d := check("Mozilla/5.0 (X11)"); d.Key != "192.168.1.1" {
+		t.Fatalf("expected the IP as key past the UA keys, got %q", d.Key)
+	}
+
+	// The UA keys are forgotten with the window, not the idle sweep
+	clock.Advance(time.Minute)
+	l.Flush(context.Background())
+	if d := check("Mozilla/5.0 (X11)"); d.Key != analyzer.UAKey("192.168.1.1", "Mozilla/5.0 (X11)") {
+		t.Errorf("UA keys should be given again in a new window, got %q", d.Key)
+	}
+}
+
+func TestLimiter_WithSessionsPerIP(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithSessionsPerIP(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	check := func(ip, session string) Decision {
+		return l.Check(Request{UA: "Mozilla/5.0", IP: ip, Path: "/", Session: session})
+	}
+	check("192.168.1.1", "a")
+	check("192.168.1.1", "b")
+	if d := check("192.168.1.1", "c"); d.Key != "192.168.1.1" {
+		t.Errorf("expected the IP as key past the sessions, got %q", d.Key)
+	}
+	if d := check("192.168.1.1", "a"); d.Key != "session:a" {
+		t.Errorf("sessions keyed already should keep their key, got %q", d.Key)
+	}
+	if d := check("192.168.1.2", "c"); d.Key != "session:c" {
+		t.Errorf("sessions should be counted by IP, got %q", d.Key)
+	}
+}
+
+func TestLimiter_WithExemptUsers(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithExemptUsers(true),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("user:42", time.Hour)
+	for i := 0; i < 3; i++ {
+		if d := l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", User: "42"}); !d.Allowed {
+			t.Errorf("exempt users should bypass limiting, got %+v", d)
+		}
+	}
+
+	if d := l.Check(Request{UA: "TestBot/1.0", IP: "10.0.0.1", Path: "/"}); d.Allowed {
+		t.Error("anonymous fake bots should still be rejected")
+	}
+}
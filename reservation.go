@@ -0,0 +1,71 @@
+package botrate
+
+import (
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"golang.org/x/time/rate"
+)
+
+// Reservation holds the outcome of Limiter.Reserve: whether the request
+// may proceed at all, and how long it must wait before it does.
+type Reservation struct {
+	r      *rate.Reservation // nil unless the request is throttled
+	ok     bool
+	reason Reason
+	clock  analyzer.Clock
+}
+
+// OK reports whether the request can ever proceed. It is false for
+// denylisted IPs, fake bots without a fake bot limit, and throttled
+// requests costing more than the burst.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns how long the caller must wait before the request may
+// proceed, e.g. for a Retry-After header. Zero means proceed now,
+// rate.InfDuration that the request can never proceed.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return rate.InfDuration
+	}
+	if r.r == nil {
+		return 0
+	}
+	return r.r.DelayFrom(r.clock.Now())
+}
+
+// Reason returns why the request is delayed or rejected, or "" when it
+// may proceed now.
+func (r *Reservation) Reason() Reason {
+	return r.reason
+}
+
+// Cancel returns the reserved tokens to the bucket. Call it when the
+// request is rejected instead of waiting for Delay.
+func (r *Reservation) Cancel() {
+	if r.r != nil {
+		r.r.CancelAt(r.clock.Now())
+	}
+}
+
+// Reserve is like Allow but returns a Reservation telling how long the
+// request must wait instead of rejecting it outright. Throttled IPs spend
+// a token either way; callers that do not wait should Cancel.
+func (l *Limiter) Reserve(ua, ip, path string) *Reservation {
+	return l.ReserveN(ua, ip, path, 1)
+}
+
+// ReserveN is like Reserve but the request consumes n tokens when the IP
+// is throttled.
+func (l *Limiter) ReserveN(ua, ip, path string, n int) *Reservation {
+	d, r := l.decide(Request{UA: ua, IP: ip, Path: path, Cost: n}, true)
+	if d.Allowed {
+		return &Reservation{ok: true}
+	}
+	if r == nil {
+		return &Reservation{reason: d.Reason}
+	}
+	return &Reservation{r: r, ok: r.OK(), reason: d.Reason, clock: l.cfg.Clock}
+}
@@ -0,0 +1,103 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Reserve(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Normal user: proceed now
+	r := l.Reserve("Mozilla/5.0", "192.168.1.2", "/")
+	if !r.OK() || r.Delay() != 0 || r.Reason() != "" {
+		t.Errorf("normal user should proceed now, got ok=%v delay=%v reason=%s", r.OK(), r.Delay(), r.Reason())
+	}
+
+	// Fake bot: never
+	r = l.Reserve("TestBot/1.0", "10.0.0.1", "/")
+	if r.OK() || r.Delay() != rate.InfDuration || r.Reason() != ReasonFakeBot {
+		t.Errorf("fake bot should never proceed, got ok=%v delay=%v reason=%s", r.OK(), r.Delay(), r.Reason())
+	}
+
+	// Blocked IP: burst, then roughly an hour
+	l.Block("192.168.1.1", time.Hour)
+	if r := l.Reserve("Mozilla/5.0", "192.168.1.1", "/"); r.Delay() != 0 {
+		t.Errorf("first request should use the burst, got delay %v", r.Delay())
+	}
+
+	r = l.Reserve("Mozilla/5.0", "192.168.1.1", "/")
+	if !r.OK() || r.Reason() != ReasonRateLimited {
+		t.Errorf("expected rate limited reservation, got ok=%v reason=%s", r.OK(), r.Reason())
+	}
+	if d := r.Delay(); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("expected delay of about an hour, got %v", d)
+	}
+
+	// Canceling returns the token, so the next reservation waits as long
+	r.Cancel()
+	r = l.Reserve("Mozilla/5.0", "192.168.1.1", "/")
+	if d := r.Delay(); d > time.Hour {
+		t.Errorf("canceled reservation should not add to the delay, got %v", d)
+	}
+
+	s := l.Stats()
+	if s.Requests != 5 {
+		t.Errorf("expected 5 requests, got %d", s.Requests)
+	}
+	if s.Denied[ReasonRateLimited] != 2 || s.Denied[ReasonFakeBot] != 1 {
+		t.Errorf("unexpected denials %v", s.Denied)
+	}
+}
+
+func TestLimiter_ReserveN(t *testing.T) {
+	l, err := New(
+		WithLimit(rate.Every(time.Minute)),
+		WithBurst(2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	if r := l.ReserveN("Mozilla/5.0", "192.168.1.1", "/", 3); r.OK() {
+		t.Error("reservation beyond the burst should never be OK")
+	}
+	if r := l.ReserveN("Mozilla/5.0", "192.168.1.1", "/", 2); r.Delay() != 0 {
+		t.Errorf("reservation within the burst should proceed now, got %v", r.Delay())
+	}
+	if r := l.ReserveN("Mozilla/5.0", "192.168.1.1", "/", 2); r.Delay() < time.Minute {
+		t.Errorf("expected to wait for two tokens, got %v", r.Delay())
+	}
+}
+
+func TestLimiter_Reserve_DryRun(t *testing.T) {
+	l, err := New(WithDryRun(true))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Reserve("Mozilla/5.0", "192.168.1.1", "/")
+
+	r := l.Reserve("Mozilla/5.0", "192.168.1.1", "/")
+	if !r.OK() || r.Delay() != 0 {
+		t.Errorf("dry run should proceed now, got ok=%v delay=%v", r.OK(), r.Delay())
+	}
+	if s := l.Stats(); s.Denied[ReasonRateLimited] != 1 {
+		t.Errorf("expected 1 would-be denial, got %d", s.Denied[ReasonRateLimited])
+	}
+}
@@ -0,0 +1,77 @@
+package botrate
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crawlDelays are the Crawl-delays of robots.txt groups by user-agent
+// token, lower-cased, "*" for all crawlers. See WithRobots.
+type crawlDelays struct {
+	agents map[string]time.Duration
+
+	// Delay by bot name, as bots keep their UA
+	cache sync.Map
+}
+
+// parseRobots returns the Crawl-delays of robots.txt, by user-agent.
+func parseRobots(data []byte) map[string]time.Duration {
+	delays := make(map[string]time.Duration)
+
+	var agents []string
+	inAgents := false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line, _, _ := strings.Cut(s.Text(), "#")
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		field = strings.ToLower(strings.TrimSpace(field))
+		value = strings.TrimSpace(value)
+
+		switch field {
+		case "user-agent":
+			// Consecutive user-agents share a group
+			if !inAgents {
+				agents = agents[:0]
+				inAgents = true
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "crawl-delay":
+			inAgents = false
+			secs, err := strconv.ParseFloat(value, 64)
+			if err != nil || secs <= 0 {
+				continue
+			}
+			for _, a := range agents {
+				delays[a] = time.Duration(secs * float64(time.Second))
+			}
+		default:
+			inAgents = false
+		}
+	}
+	return delays
+}
+
+// delay returns the Crawl-delay for the bot named bot with ua: that of the
+// longest user-agent token in ua, or of "*", 0 if none.
+func (c *crawlDelays) delay(bot, ua string) time.Duration {
+	if d, ok := c.cache.Load(bot); ok {
+		return d.(time.Duration)
+	}
+
+	lower := strings.ToLower(ua)
+	d, longest := c.agents["*"], 0
+	for agent, delay := range c.agents {
+		if agent != "*" && len(agent) > longest && strings.Contains(lower, agent) {
+			d, longest = delay, len(agent)
+		}
+	}
+	c.cache.Store(bot, d)
+	return d
+}
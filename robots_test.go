@@ -0,0 +1,110 @@
+package botrate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+const testRobots = `# robots.txt
+User-agent: *
+Disallow: /private/
+Crawl-delay: 10
+
+User-agent: TestBot
+User-agent: OtherBot
+Crawl-delay: 2.5 # seconds
+
+User-agent: TestBot-News
+Crawl-delay: invalid
+`
+
+func TestParseRobots(t *testing.T) {
+	got := parseRobots([]byte(testRobots))
+	want := map[string]time.Duration{
+		"*":        10 * time.Second,
+		"testbot":  2500 * time.Millisecond,
+		"otherbot": 2500 * time.Millisecond,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseRobots() = %v, want %v", got, want)
+	}
+	for agent, d := range want {
+		if got[agent] != d {
+			t.Errorf("Crawl-delay of %s = %v, want %v", agent, got[agent], d)
+		}
+	}
+}
+
+func TestCrawlDelays_Delay(t *testing.T) {
+	c := &crawlDelays{agents: map[string]time.Duration{
+		"*":            10 * time.Second,
+		"testbot":      2 * time.Second,
+		"testbot-news": time.Second,
+	}}
+	tests := []struct {
+		bot, ua string
+		want    time.Duration
+	}{
+		{"testbot", "Mozilla/5.0 (compatible; TestBot/1.0)", 2 * time.Second},
+		{"news", "TestBot-News/1.0", time.Second},
+		{"other", "OtherBot/1.0", 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := c.delay(tt.bot, tt.ua); got != tt.want {
+			t.Errorf("delay(%s) = %v, want %v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestLimiter_WithRobots(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "robots.txt")
+	if err := os.WriteFile(path, []byte(testRobots), 0o644); err != nil {
+		t.Fatalf("failed to write robots.txt: %v", err)
+	}
+
+	l, err := New(WithKnownbots(testbots.NewAI(t)), WithRobots(path), WithCrawlDelay("AnswerBot", time.Hour))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if d := l.Decide("TestBot/1.0", "192.168.100.1", "/"); !d.Allowed {
+		t.Errorf("the first request should be allowed, got %+v", d)
+	}
+	d := l.Decide("TestBot/1.0", "192.168.100.2", "/")
+	if d.Allowed || d.Reason != ReasonCrawlDelay || d.RetryAfter <= 0 || d.RetryAfter > 2500*time.Millisecond {
+		t.Errorf("requests within the Crawl-delay should be denied, got %+v", d)
+	}
+
+	// Delays set in code take precedence over the file's "*"
+	l.Decide("AnswerBot/1.0", "192.168.100.1", "/")
+	if d := l.Decide("AnswerBot/1.0", "192.168.100.1", "/"); d.Reason != ReasonCrawlDelay || d.RetryAfter <= 10*time.Second {
+		t.Errorf("expected the Crawl-delay set in code, got %+v", d)
+	}
+
+	if _, err := New(WithRobots(filepath.Join(t.TempDir(), "missing.txt"))); err == nil {
+		t.Error("expected an error for a missing robots.txt")
+	}
+}
+
+func TestLimiter_WithCrawlDelay_BotLimit(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithCrawlDelay("*", time.Hour),
+		WithBotLimit("testbot", rate.Every(2*time.Hour), 1),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Decide("TestBot/1.0", "192.168.100.1", "/")
+	if d := l.Decide("TestBot/1.0", "192.168.100.1", "/"); d.Reason != ReasonBotLimited {
+		t.Errorf("a stricter bot limit should take precedence, got %+v", d)
+	}
+}
@@ -0,0 +1,56 @@
+package botrate
+
+import (
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// pathSet matches request paths against glob patterns. The patterns can
+// be replaced while it is read.
+type pathSet struct {
+	patterns atomic.Pointer[[]string]
+}
+
+func newPathSet(patterns []string) (*pathSet, error) {
+	if err := checkPatterns(patterns); err != nil {
+		return nil, err
+	}
+	s := &pathSet{}
+	s.patterns.Store(&patterns)
+	return s, nil
+}
+
+// Set replaces the patterns, which must have passed checkPatterns.
+func (s *pathSet) Set(patterns []string) {
+	s.patterns.Store(&patterns)
+}
+
+// checkPatterns returns an error for a malformed pattern.
+func checkPatterns(patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether p matches any pattern. Patterns use path.Match
+// syntax, except that a trailing "*" also matches across slashes, so
+// "/static/*" matches everything under /static/.
+func (s *pathSet) Match(p string) bool {
+	for _, pattern := range *s.patterns.Load() {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(p, prefix) && !hasMeta(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, `*?[\`)
+}
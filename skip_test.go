@@ -0,0 +1,90 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestPathSet_Match(t *testing.T) {
+	s, err := newPathSet([]string{"/healthz", "/static/*", "/api/*/webhook", "/metrics*"})
+	if err != nil {
+		t.Fatalf("newPathSet() returned error: %v", err)
+	}
+
+	testCases := []struct {
+		path string
+		want bool
+	}{
+		{"/healthz", true},
+		{"/healthz/", false},
+		{"/static/app.js", true},
+		{"/static/img/logo.png", true},
+		{"/static", false},
+		{"/api/github/webhook", true},
+		{"/api/github/other", false},
+		{"/metrics", true},
+		{"/metrics/prometheus", true},
+		{"/", false},
+	}
+
+	for _, tc := range testCases {
+		if got := s.Match(tc.path); got != tc.want {
+			t.Errorf("Match(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestPathSet_Invalid(t *testing.T) {
+	if _, err := newPathSet([]string{"/[a"}); err == nil {
+		t.Error("invalid pattern should fail")
+	}
+}
+
+func TestLimiter_WithSkipPaths(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(2),
+		WithSkipPaths("/healthz", "/static/*"),
+		WithDenyCIDRs([]string{"10.1.0.0/16"}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	// Skipped paths never count toward the page threshold
+	for _, p := range []string{"/healthz", "/static/a.js", "/static/b.js", "/static/c.js"} {
+		l.Allow("Mozilla/5.0", "192.168.1.1", p)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(l.Blocklist()) != 0 {
+		t.Errorf("skipped paths should not be analyzed, got %+v", l.Blocklist())
+	}
+
+	// Skipped paths are not limited
+	l.Block("192.168.1.2", time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := l.Allow("Mozilla/5.0", "192.168.1.2", "/healthz"); !allowed {
+			t.Error("skipped paths should bypass limiting")
+		}
+	}
+	if allowed, _ := l.Allow("TestBot/1.0", "10.0.0.1", "/healthz"); !allowed {
+		t.Error("skipped paths should bypass bot verification")
+	}
+
+	// The denylist still applies
+	if allowed, reason := l.Allow("Mozilla/5.0", "10.1.2.3", "/healthz"); allowed || reason != ReasonDenied {
+		t.Errorf("denylisted IPs should be rejected on skipped paths, got %v %s", allowed, reason)
+	}
+}
+
+func TestLimiter_WithSkipPaths_Invalid(t *testing.T) {
+	if _, err := New(WithSkipPaths("/[a")); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
@@ -0,0 +1,37 @@
+package botrate
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// snapshotVersion is the version of the Snapshot format.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version int `json:"version"`
+	analyzer.Snapshot
+}
+
+// Snapshot serializes the blocklist, the current window's counts and the
+// offense history to JSON, to migrate them to another instance with
+// RestoreSnapshot or checkpoint them to object storage.
+func (l *Limiter) Snapshot() ([]byte, error) {
+	return json.Marshal(snapshot{Version: snapshotVersion, Snapshot: l.analyzer.Snapshot()})
+}
+
+// RestoreSnapshot adds the state serialized by Snapshot, e.g. on another
+// instance. Blocks keep their expiry and those expired since are
+// skipped; existing state is kept.
+func (l *Limiter) RestoreSnapshot(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("botrate: unsupported snapshot version %d", s.Version)
+	}
+	return l.analyzer.Restore(s.Snapshot)
+}
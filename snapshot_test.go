@@ -0,0 +1,57 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestLimiter_Snapshot(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)), WithAnalyzerPageThreshold(3), WithSyncAnalyzer(true))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("10.0.0.1", time.Hour)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/a")
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/b")
+
+	data, err := l.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot() returned error: %v", err)
+	}
+
+	m, err := New(WithKnownbots(testbots.New(t)), WithAnalyzerPageThreshold(3), WithSyncAnalyzer(true))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer m.Close()
+	if err := m.RestoreSnapshot(data); err != nil {
+		t.Fatalf("RestoreSnapshot() returned error: %v", err)
+	}
+
+	if d := m.Decide("Mozilla/5.0", "10.0.0.1", "/"); !d.Blocklisted {
+		t.Errorf("block should be restored, got %+v", d)
+	}
+	m.Allow("Mozilla/5.0", "192.168.1.1", "/c")
+	if d := m.Decide("Mozilla/5.0", "192.168.1.1", "/d"); !d.Blocklisted {
+		t.Errorf("page count should be restored, got %+v", d)
+	}
+}
+
+func TestLimiter_RestoreSnapshot_Invalid(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	if err := l.RestoreSnapshot([]byte("{")); err == nil {
+		t.Error("malformed snapshot should fail")
+	}
+	if err := l.RestoreSnapshot([]byte(`{"version":99}`)); err == nil {
+		t.Error("unknown version should fail")
+	}
+}
@@ -0,0 +1,152 @@
+package botrate
+
+import "sync/atomic"
+
+// Stats is a point-in-time snapshot of limiter activity.
+type Stats struct {
+	// Requests is the number of requests checked by Allow or Wait.
+	Requests uint64 `json:"requests"`
+
+	// Denied is the number of denied requests by reason.
+	Denied map[Reason]uint64 `json:"denied"`
+
+	// Blocklist is the number of currently blocked IPs.
+	Blocklist int `json:"blocklist"`
+
+	// Greylist is the number of currently greylisted IPs.
+	Greylist int `json:"greylist"`
+
+	// QueueLen and QueueCap describe the analyzer event queue.
+	QueueLen int `json:"queue_len"`
+	QueueCap int `json:"queue_cap"`
+
+	// Dropped is the number of analyzer events dropped because the queue was full.
+	Dropped uint64 `json:"dropped"`
+
+	// Evicted is the number of blocks evicted to keep the blocklist
+	// within WithMaxBlocklist.
+	Evicted uint64 `json:"evicted"`
+
+	// Prefixes is the number of currently blocked prefixes, and
+	// PrefixBlocks of prefix blocks so far, see WithPrefixThreshold.
+	Prefixes     int    `json:"prefixes"`
+	PrefixBlocks uint64 `json:"prefix_blocks"`
+
+	// Limiters is the number of active per-IP token buckets.
+	Limiters int64 `json:"limiters"`
+
+	// VerifyCacheHits and VerifyCacheMisses count the bot verifications
+	// answered by the cache of WithVerifyCache, or not.
+	VerifyCacheHits   uint64 `json:"verify_cache_hits"`
+	VerifyCacheMisses uint64 `json:"verify_cache_misses"`
+
+	// Pending is the number of pending bot verifications, whose RDNS
+	// lookup failed, and PendingExhausted of those past the retries of
+	// WithPendingRetries.
+	Pending          uint64 `json:"pending"`
+	PendingExhausted uint64 `json:"pending_exhausted"`
+}
+
+// counters tracks request outcomes for Stats.
+type counters struct {
+	requests    atomic.Uint64
+	fakeBot     atomic.Uint64
+	rateLimited atomic.Uint64
+	denied      atomic.Uint64
+	greylisted  atomic.Uint64
+	datacenter  atomic.Uint64
+	tor         atomic.Uint64
+	aiBot       atomic.Uint64
+	botLimited  atomic.Uint64
+	botQuota    atomic.Uint64
+	crawlDelay  atomic.Uint64
+	message     atomic.Uint64
+	pathLimited atomic.Uint64
+	tlsDenied   atomic.Uint64
+	badUA       atomic.Uint64
+
+	pending          atomic.Uint64
+	pendingExhausted atomic.Uint64
+
+	limiters atomic.Int64
+}
+
+func (c *counters) deny(reason Reason) {
+	switch reason {
+	case ReasonFakeBot:
+		c.fakeBot.Add(1)
+	case ReasonRateLimited:
+		c.rateLimited.Add(1)
+	case ReasonDenied:
+		c.denied.Add(1)
+	case ReasonGreylisted:
+		c.greylisted.Add(1)
+	case ReasonDatacenter:
+		c.datacenter.Add(1)
+	case ReasonTor:
+		c.tor.Add(1)
+	case ReasonAIBot:
+		c.aiBot.Add(1)
+	case ReasonBotLimited:
+		c.botLimited.Add(1)
+	case ReasonBotQuota:
+		c.botQuota.Add(1)
+	case ReasonCrawlDelay:
+		c.crawlDelay.Add(1)
+	case ReasonMessageLimited:
+		c.message.Add(1)
+	case ReasonPathLimited:
+		c.pathLimited.Add(1)
+	case ReasonTLSFingerprint:
+		c.tlsDenied.Add(1)
+	case ReasonBadUA:
+		c.badUA.Add(1)
+	}
+}
+
+// Stats returns a snapshot of the limiter's counters.
+func (l *Limiter) Stats() Stats {
+	as := l.analyzer.Stats()
+
+	var hits, misses uint64
+	if l.verifyCache != nil {
+		hits, misses = l.verifyCache.hits.Load(), l.verifyCache.misses.Load()
+	}
+
+	return Stats{
+		Requests: l.counters.requests.Load(),
+		Denied: map[Reason]uint64{
+			ReasonFakeBot:        l.counters.fakeBot.Load(),
+			ReasonRateLimited:    l.counters.rateLimited.Load(),
+			ReasonDenied:         l.counters.denied.Load(),
+			ReasonGreylisted:     l.counters.greylisted.Load(),
+			ReasonDatacenter:     l.counters.datacenter.Load(),
+			ReasonTor:            l.counters.tor.Load(),
+			ReasonAIBot:          l.counters.aiBot.Load(),
+			ReasonBotLimited:     l.counters.botLimited.Load(),
+			ReasonBotQuota:       l.counters.botQuota.Load(),
+			ReasonCrawlDelay:     l.counters.crawlDelay.Load(),
+			ReasonMessageLimited: l.counters.message.Load(),
+			ReasonPathLimited:    l.counters.pathLimited.Load(),
+			ReasonTLSFingerprint: l.counters.tlsDenied.Load(),
+			ReasonBadUA:          l.counters.badUA.Load(),
+		},
+		Blocklist: as.Blocklist,
+		Greylist:  as.Greylist,
+		QueueLen:  as.QueueLen,
+		QueueCap:  as.QueueCap,
+		Dropped:   as.Dropped,
+		Evicted:   as.Evicted,
+
+		Prefixes:     as.Prefixes,
+		PrefixBlocks: as.PrefixBlocks,
+
+		Limiters: l.counters.limiters.Load(),
+
+		VerifyCacheHits:   hits,
+		VerifyCacheMisses: misses,
+
+		Pending:          l.counters.pending.Load(),
+		PendingExhausted: l.counters.pendingExhausted.Load(),
+	}
+}
@@ -0,0 +1,97 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Stats(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithLimit(rate.Every(time.Hour)),
+		WithAnalyzerWindow(time.Hour),
+		WithAnalyzerPageThreshold(10000),
+		WithAnalyzerQueueCap(500),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	l.Block("192.168.1.1", time.Hour)
+
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/") // burst
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/") // rate limited
+	l.Allow("TestBot/1.0", "10.0.0.1", "/")    // fake bot
+	l.Allow("Mozilla/5.0", "192.168.1.2", "/") // normal
+
+	s := l.Stats()
+
+	if s.Requests != 4 {
+		t.Errorf("expected 4 requests, got %d", s.Requests)
+	}
+	if s.Denied[ReasonRateLimited] != 1 {
+		t.Errorf("expected 1 rate limited, got %d", s.Denied[ReasonRateLimited])
+	}
+	if s.Denied[ReasonFakeBot] != 1 {
+		t.Errorf("expected 1 fake bot, got %d", s.Denied[ReasonFakeBot])
+	}
+	if s.Blocklist != 1 {
+		t.Errorf("expected blocklist size 1, got %d", s.Blocklist)
+	}
+	if s.QueueCap != 500 {
+		t.Errorf("expected queue cap 500, got %d", s.QueueCap)
+	}
+	if s.Limiters != 1 {
+		t.Errorf("expected 1 active limiter, got %d", s.Limiters)
+	}
+
+	l.Unblock("192.168.1.1")
+
+	s = l.Stats()
+	if s.Blocklist != 0 {
+		t.Errorf("expected empty blocklist after unblock, got %d", s.Blocklist)
+	}
+	if s.Limiters != 0 {
+		t.Errorf("expected no active limiters after unblock, got %d", s.Limiters)
+	}
+}
+
+func TestLimiter_Stats_Close(t *testing.T) {
+	l, err := New(WithLimit(rate.Every(time.Hour)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Allow("Mozilla/5.0", "192.168.1.1", "/")
+
+	l.Close()
+
+	if n := l.Stats().Limiters; n != 0 {
+		t.Errorf("expected no active limiters after close, got %d", n)
+	}
+}
+
+func TestLimiter_WithMaxBlocklist(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)), WithMaxBlocklist(2))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"} {
+		if err := l.Block(ip, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := l.Stats(); s.Blocklist != 2 || s.Evicted != 1 {
+		t.Errorf("expected 2 blocked and 1 evicted, got %d and %d", s.Blocklist, s.Evicted)
+	}
+	if !l.analyzer.Blocked("192.168.1.3") {
+		t.Error("the newest block should stay")
+	}
+}
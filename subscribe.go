@@ -0,0 +1,90 @@
+package botrate
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// DefaultSubscribeBuffer is the number of events buffered for each
+// subscriber, see WithSubscribeBuffer.
+var DefaultSubscribeBuffer = 256
+
+// subscribers fans events out to the channels of Subscribe.
+type subscribers struct {
+	mu    sync.RWMutex
+	chans map[chan analyzer.Event]struct{}
+	n     atomic.Int32 // len(chans), checked without the lock
+	done  bool
+}
+
+// publish delivers e to every subscriber with room for it, dropping it
+// for the others.
+func (s *subscribers) publish(e analyzer.Event) {
+	if s.n.Load() == 0 {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for ch := range s.chans {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *subscribers) add(size int) (<-chan analyzer.Event, func()) {
+	ch := make(chan analyzer.Event, max(size, 0))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.done {
+		close(ch)
+		return ch, func() {}
+	}
+	if s.chans == nil {
+		s.chans = make(map[chan analyzer.Event]struct{})
+	}
+	s.chans[ch] = struct{}{}
+	s.n.Add(1)
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { s.remove(ch) })
+	}
+}
+
+// remove closes ch, unless the subscribers are closed already.
+func (s *subscribers) remove(ch chan analyzer.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chans[ch]; ok {
+		delete(s.chans, ch)
+		s.n.Add(-1)
+		close(ch)
+	}
+}
+
+// close closes every channel and those subscribed later.
+func (s *subscribers) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.chans {
+		close(ch)
+	}
+	s.chans = nil
+	s.n.Store(0)
+	s.done = true
+}
+
+// Subscribe returns a channel of the limiter's events: blocks, unblocks,
+// analyzer queue drops and bots failing verification, see the
+// analyzer.Event types. Up to WithSubscribeBuffer events are buffered,
+// further ones are dropped until the subscriber catches up, so a slow
+// subscriber never holds up requests. Call the returned func to
+// unsubscribe; the channel is closed then, or when the limiter is.
+func (l *Limiter) Subscribe() (<-chan analyzer.Event, func()) {
+	return l.subscribers.add(l.cfg.SubscribeBuffer)
+}
@@ -0,0 +1,61 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestLimiter_Subscribe(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)), WithSubscribeBuffer(2))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	events, unsubscribe := l.Subscribe()
+
+	l.Block("192.168.1.1", time.Hour)
+	l.Allow("TestBot/1.0", "10.0.0.1", "/")
+	l.Unblock("192.168.1.1") // dropped: the buffer is full
+
+	if e := <-events; e.Type != analyzer.EventBlock || e.IP != "192.168.1.1" {
+		t.Errorf("expected the block, got %+v", e)
+	}
+	if e := <-events; e.Type != analyzer.EventVerificationFailed || e.IP != "10.0.0.1" || e.Bot != "testbot" || e.UA != "TestBot/1.0" {
+		t.Errorf("expected the failed verification, got %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("events past the buffer should be dropped, got %+v", e)
+	default:
+	}
+
+	unsubscribe()
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed after unsubscribing")
+	}
+	l.Block("192.168.1.2", time.Hour)
+}
+
+func TestLimiter_Subscribe_Close(t *testing.T) {
+	l, err := New(WithKnownbots(testbots.New(t)))
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+
+	events, unsubscribe := l.Subscribe()
+	l.Close()
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed with the limiter")
+	}
+	unsubscribe()
+
+	events, _ = l.Subscribe()
+	if _, ok := <-events; ok {
+		t.Error("subscribing to a closed limiter should return a closed channel")
+	}
+}
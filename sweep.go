@@ -0,0 +1,84 @@
+package botrate
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"golang.org/x/time/rate"
+)
+
+// DefaultLimiterIdle is how long a client's token bucket stays unused
+// before it is removed, see WithLimiterIdle.
+var DefaultLimiterIdle = 10 * time.Minute
+
+// tokenBucket is a client's token bucket, with when it was last used.
+type tokenBucket struct {
+	*rate.Limiter
+	used atomic.Int64 // UnixNano
+}
+
+// sweepLimiters removes idle token buckets every LimiterIdle, until
+// Close.
+func (l *Limiter) sweepLimiters(ticker analyzer.Ticker) {
+	defer close(l.swept)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C():
+			l.sweep(l.cfg.Clock.Now())
+		}
+	}
+}
+
+// sweep removes the token buckets unused for LimiterIdle and refilled,
+// which new buckets would replace as is, and those of clients no longer
+// blocked or greylisted.
+func (l *Limiter) sweep(now time.Time) {
+	maps := []struct {
+		m      *sync.Map
+		active func(key string) bool
+	}{
+		{&l.blocked, l.blockedKey},
+		{&l.greylisted, l.analyzer.Greylisted},
+		{&l.fakeBots, nil},
+		{&l.torExits, nil},
+		{&l.aiBots, nil},
+		{&l.bots, nil},
+	}
+	for _, m := range l.pathPolicies.Load().buckets() {
+		maps = append(maps, struct {
+			m      *sync.Map
+			active func(key string) bool
+		}{m, nil})
+	}
+
+	idle := l.cfg.LimiterIdle.Nanoseconds()
+	for _, b := range maps {
+		b.m.Range(func(key, value any) bool {
+			bucket := value.(*tokenBucket)
+			ended := b.active != nil && !b.active(key.(string))
+			if ended || (now.UnixNano()-bucket.used.Load() >= idle && bucket.TokensAt(now) >= float64(bucket.Burst())) {
+				if b.m.CompareAndDelete(key, value) {
+					l.counters.limiters.Add(-1)
+				}
+			}
+			return true
+		})
+	}
+}
+
+// blockedKey reports whether the client of key, or the prefix key, is
+// still blocked.
+func (l *Limiter) blockedKey(key string) bool {
+	if prefix, err := netip.ParsePrefix(key); err == nil {
+		blocked, ok := l.analyzer.BlockedPrefix(prefix.Addr())
+		return ok && blocked == key
+	}
+	return l.analyzer.Blocked(key)
+}
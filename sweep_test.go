@@ -0,0 +1,68 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"golang.org/x/time/rate"
+)
+
+func TestLimiter_Sweep(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithClock(clock),
+		WithLimiterIdle(time.Minute),
+		WithLimit(rate.Every(time.Hour)),
+		WithPathPolicy("/api/*", Policy{Limit: rate.Every(time.Second), Burst: 1}),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	const ua = "Mozilla/5.0"
+	l.Block("192.168.1.1", 0)
+	l.Block("192.168.1.2", 0)
+	for _, ip := range []string{"192.168.1.1", "192.168.1.2"} {
+		l.Allow(ua, ip, "/")
+	}
+	l.Allow(ua, "192.168.1.3", "/api/a")
+	if got := l.Stats().Limiters; got != 3 {
+		t.Fatalf("expected 3 limiters, got %d", got)
+	}
+
+	// A block ending drops the client's bucket right away
+	l.analyzer.Unblock("192.168.1.2")
+	l.sweep(clock.Now())
+	if _, ok := l.blocked.Load("192.168.1.2"); ok {
+		t.Error("bucket of an unblocked IP should be removed")
+	}
+	if _, ok := l.pathPolicies.Load().policies[0].buckets.Load("192.168.1.3"); !ok {
+		t.Error("bucket used within the idle period should be kept")
+	}
+
+	// Idle buckets go once refilled
+	l.sweep(clock.Now().Add(2 * time.Minute))
+	if _, ok := l.pathPolicies.Load().policies[0].buckets.Load("192.168.1.3"); ok {
+		t.Error("idle refilled bucket should be removed")
+	}
+	if _, ok := l.blocked.Load("192.168.1.1"); !ok {
+		t.Error("idle bucket still refilling should be kept")
+	}
+	if got := l.Stats().Limiters; got != 1 {
+		t.Errorf("expected 1 limiter, got %d", got)
+	}
+
+	// The sweep runs every idle period
+	clock.Advance(2 * time.Hour)
+	deadline := time.Now().Add(5 * time.Second)
+	for l.Stats().Limiters != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("idle buckets not swept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
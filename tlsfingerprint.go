@@ -0,0 +1,40 @@
+package botrate
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// tlsSet is a set of TLS client fingerprints, JA3 or JA4 hashes, see
+// WithDenyTLSFingerprints. Fingerprints are matched ignoring case.
+type tlsSet struct {
+	fingerprints atomic.Pointer[map[string]struct{}]
+}
+
+func newTLSSet(fingerprints []string) *tlsSet {
+	s := &tlsSet{}
+	s.Set(fingerprints)
+	return s
+}
+
+// Set replaces the fingerprints of the set.
+func (s *tlsSet) Set(fingerprints []string) {
+	m := make(map[string]struct{}, len(fingerprints))
+	for _, fp := range fingerprints {
+		if fp = strings.ToLower(strings.TrimSpace(fp)); fp != "" {
+			m[fp] = struct{}{}
+		}
+	}
+	s.fingerprints.Store(&m)
+}
+
+// Contains reports whether fp is in the set. An empty fp, unknown, is
+// not.
+func (s *tlsSet) Contains(fp string) bool {
+	m := *s.fingerprints.Load()
+	if len(m) == 0 || fp == "" {
+		return false
+	}
+	_, ok := m[strings.ToLower(fp)]
+	return ok
+}
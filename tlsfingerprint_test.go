@@ -0,0 +1,47 @@
+package botrate
+
+import (
+	"testing"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+)
+
+func TestLimiter_WithDenyTLSFingerprints(t *testing.T) {
+	const curl = "e7d705a3286e19ea42f587b344ee6865" // JA3 of an HTTP library
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithDenyTLSFingerprints(curl, "t13d1516h2_8daaf6152771_b186095e22b6"),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	check := func(fp string) Decision {
+		return l.Check(Request{UA: "Mozilla/5.0", IP: "192.168.1.1", Path: "/", TLSFingerprint: fp})
+	}
+	if d := check("E7D705A3286E19EA42F587B344EE6865"); d.Allowed || d.Reason != ReasonTLSFingerprint {
+		t.Errorf("denied fingerprints should be rejected, got %+v", d)
+	}
+	if d := check("t13d1516h2_8daaf6152771_b186095e22b6"); d.Allowed {
+		t.Errorf("denied JA4 fingerprints should be rejected, got %+v", d)
+	}
+	for _, fp := range []string{"", "b32309a26951912be7dba376398abc3b"} {
+		if d := check(fp); !d.Allowed {
+			t.Errorf("fingerprint %q should be allowed, got %+v", fp, d)
+		}
+	}
+	if s := l.Stats(); s.Denied[ReasonTLSFingerprint] != 2 {
+		t.Errorf("expected 2 TLS fingerprint denials, got %d", s.Denied[ReasonTLSFingerprint])
+	}
+
+	// The list is replaced with the configuration
+	cfg := l.Config()
+	cfg.DenyTLSFingerprints = nil
+	if err := l.ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() returned error: %v", err)
+	}
+	if d := check(curl); !d.Allowed {
+		t.Errorf("fingerprints removed from the list should be allowed, got %+v", d)
+	}
+}
@@ -0,0 +1,127 @@
+package botrate
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBypassKeys is how many keys BypassTokens keeps for verification,
+// the signing key included.
+var DefaultBypassKeys = 3
+
+// errNoBypassKey is returned by NewBypassTokens without keys.
+var errNoBypassKey = errors.New("botrate: no bypass token key")
+
+// BypassTokens issues and verifies HMAC-signed, time-limited bypass
+// tokens, carried by clients that passed a challenge or logged in so they
+// skip analysis, see Request.Bypass. Tokens are bound to a subject, e.g.
+// the client IP, so they cannot be shared. Keys can be rotated without
+// invalidating tokens signed with the previous ones.
+type BypassTokens struct {
+	mu   sync.RWMutex
+	keys []bypassKey // signing key first
+}
+
+type bypassKey struct {
+	id     [4]byte
+	secret []byte
+}
+
+func newBypassKey(secret []byte) bypassKey {
+	sum := sha256.Sum256(secret)
+	k := bypassKey{secret: secret}
+	copy(k.id[:], sum[:])
+	return k
+}
+
+// NewBypassTokens returns BypassTokens signing with the first key and
+// verifying with all of them, e.g. the current and previous key on every
+// replica.
+func NewBypassTokens(keys ...[]byte) (*BypassTokens, error) {
+	if len(keys) == 0 {
+		return nil, errNoBypassKey
+	}
+	t := &BypassTokens{}
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, errNoBypassKey
+		}
+		t.keys = append(t.keys, newBypassKey(key))
+	}
+	return t, nil
+}
+
+// Rotate makes key the signing key. Tokens signed with the previous keys
+// still verify until they expire or DefaultBypassKeys newer keys were
+// added.
+func (t *BypassTokens) Rotate(key []byte) error {
+	if len(key) == 0 {
+		return errNoBypassKey
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := append([]bypassKey{newBypassKey(key)}, t.keys...)
+	if len(keys) > DefaultBypassKeys {
+		keys = keys[:DefaultBypassKeys]
+	}
+	t.keys = keys
+	return nil
+}
+
+// Issue returns a token for subject that is valid for ttl.
+func (t *BypassTokens) Issue(subject string, ttl time.Duration) string {
+	t.mu.RLock()
+	key := t.keys[0]
+	t.mu.RUnlock()
+
+	var payload [12]byte
+	copy(payload[:4], key.id[:])
+	binary.BigEndian.PutUint64(payload[4:], uint64(time.Now().Add(ttl).Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload[:]) + "." + key.sign(payload[:], subject)
+}
+
+// Verify reports whether token was issued for subject by one of the keys
+// and has not expired.
+func (t *BypassTokens) Verify(token, subject string) bool {
+	enc, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+
+	var payload [12]byte
+	if len(enc) != base64.RawURLEncoding.EncodedLen(len(payload)) {
+		return false
+	}
+	if _, err := base64.RawURLEncoding.Decode(payload[:], []byte(enc)); err != nil {
+		return false
+	}
+	if expires := int64(binary.BigEndian.Uint64(payload[4:])); time.Now().Unix() >= expires {
+		return false
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, key := range t.keys {
+		if string(key.id[:]) == string(payload[:4]) {
+			return hmac.Equal([]byte(sig), []byte(key.sign(payload[:], subject)))
+		}
+	}
+	return false
+}
+
+func (k bypassKey) sign(payload []byte, subject string) string {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write(payload)
+	mac.Write([]byte(subject))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
@@ -0,0 +1,86 @@
+package botrate
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBypassTokens(t *testing.T) {
+	tokens, err := NewBypassTokens([]byte("secret"))
+	if err != nil {
+		t.Fatalf("NewBypassTokens() returned error: %v", err)
+	}
+
+	token := tokens.Issue("192.168.1.1", time.Hour)
+	if !tokens.Verify(token, "192.168.1.1") {
+		t.Error("issued token should verify")
+	}
+	if tokens.Verify(token, "192.168.1.2") {
+		t.Error("token should be bound to its subject")
+	}
+	if tokens.Verify(tokens.Issue("192.168.1.1", -time.Second), "192.168.1.1") {
+		t.Error("expired token should not verify")
+	}
+
+	other, _ := NewBypassTokens([]byte("other"))
+	if other.Verify(token, "192.168.1.1") {
+		t.Error("token signed with another key should not verify")
+	}
+
+	for _, forged := range []string{"", "abc", "abc.def", strings.Replace(token, ".", "x.", 1)} {
+		if tokens.Verify(forged, "192.168.1.1") {
+			t.Errorf("forged token %q should not verify", forged)
+		}
+	}
+}
+
+func TestBypassTokens_Malformed(t *testing.T) {
+	tokens, _ := NewBypassTokens([]byte("secret"))
+	token := tokens.Issue("192.168.1.1", time.Hour)
+	enc, sig, _ := strings.Cut(token, ".")
+
+	for _, malformed := range []string{
+		strings.Repeat("A", 36) + ".x",
+		enc + "AAAA." + sig,
+		enc[:len(enc)-1] + "." + sig,
+		enc[:len(enc)-1] + "!." + sig,
+		"." + sig,
+		enc + ".",
+	} {
+		if tokens.Verify(malformed, "192.168.1.1") {
+			t.Errorf("malformed token %q should not verify", malformed)
+		}
+	}
+}
+
+func TestBypassTokens_Rotate(t *testing.T) {
+	tokens, _ := NewBypassTokens([]byte("k1"))
+	old := tokens.Issue("192.168.1.1", time.Hour)
+
+	if err := tokens.Rotate([]byte("k2")); err != nil {
+		t.Fatalf("Rotate() returned error: %v", err)
+	}
+	if !tokens.Verify(old, "192.168.1.1") {
+		t.Error("token signed with the previous key should still verify")
+	}
+
+	// Replicas verifying with both keys accept tokens from either
+	replica, _ := NewBypassTokens([]byte("k2"), []byte("k1"))
+	if !replica.Verify(old, "192.168.1.1") || !replica.Verify(tokens.Issue("192.168.1.1", time.Hour), "192.168.1.1") {
+		t.Error("replica should verify tokens from both keys")
+	}
+
+	tokens.Rotate([]byte("k3"))
+	tokens.Rotate([]byte("k4"))
+	if tokens.Verify(old, "192.168.1.1") {
+		t.Errorf("token from a retired key should not verify after %d rotations", DefaultBypassKeys)
+	}
+
+	if err := tokens.Rotate(nil); err == nil {
+		t.Error("empty key should be rejected")
+	}
+	if _, err := NewBypassTokens(); err == nil {
+		t.Error("no keys should be rejected")
+	}
+}
@@ -0,0 +1,38 @@
+package botrate
+
+// TorPolicy is what to do with normal users coming from Tor exits, see
+// WithTor.
+type TorPolicy int
+
+const (
+	// TorAllow treats Tor users like others. Decision.Tor still reports
+	// them.
+	TorAllow TorPolicy = iota
+
+	// TorLimit throttles each Tor exit with WithTorLimit, while still
+	// analyzing it.
+	TorLimit
+
+	// TorChallenge denies Tor users with ReasonTor and ActionChallenge,
+	// unless WithAction sets another action.
+	TorChallenge
+
+	// TorBlock denies Tor users with ReasonTor.
+	TorBlock
+)
+
+// String returns the policy name.
+func (p TorPolicy) String() string {
+	switch p {
+	case TorAllow:
+		return "allow"
+	case TorLimit:
+		return "limit"
+	case TorChallenge:
+		return "challenge"
+	case TorBlock:
+		return "block"
+	default:
+		return "unknown"
+	}
+}
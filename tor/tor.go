@@ -0,0 +1,227 @@
+// Package tor matches IPs against the list of Tor exit nodes published by
+// the Tor Project, refreshed periodically.
+//
+// See botrate.WithTor.
+package tor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	// DefaultURL is the Tor Project's bulk exit list, one IP per line.
+	DefaultURL     = "https://check.torproject.org/torbulkexitlist"
+	DefaultRefresh = time.Hour
+	DefaultTimeout = 30 * time.Second
+)
+
+// maxListSize bounds the list read, a few thousand IPs.
+const maxListSize = 16 << 20
+
+// Option is a functional option for configuring Exits.
+type Option func(*Exits)
+
+// WithURL sets the URL of the exit list, DefaultURL by default. The list
+// has an IP per line; empty lines and lines starting with # are skipped.
+func WithURL(u string) Option {
+	return func(e *Exits) {
+		e.url = u
+	}
+}
+
+// WithRefresh sets how often the list is fetched again. Exits come and
+// go within hours.
+func WithRefresh(d time.Duration) Option {
+	return func(e *Exits) {
+		e.refresh = d
+	}
+}
+
+// WithClient sets the HTTP client fetching the list.
+func WithClient(hc *http.Client) Option {
+	return func(e *Exits) {
+		e.client = hc
+	}
+}
+
+// WithTimeout sets the timeout for fetching the list.
+func WithTimeout(d time.Duration) Option {
+	return func(e *Exits) {
+		e.timeout = d
+	}
+}
+
+// WithLogger sets the logger for failed fetches.
+func WithLogger(logger *slog.Logger) Option {
+	return func(e *Exits) {
+		e.logger = logger
+	}
+}
+
+// Exits is the set of Tor exit nodes.
+type Exits struct {
+	url     string
+	refresh time.Duration
+	client  *http.Client
+	timeout time.Duration
+	logger  *slog.Logger
+
+	// Reads are lock-free
+	addrs atomic.Pointer[map[netip.Addr]struct{}]
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns the exits, empty until the list is fetched in the
+// background. Call Refresh to wait for it.
+func New(opts ...Option) *Exits {
+	e := &Exits{
+		url:     DefaultURL,
+		refresh: DefaultRefresh,
+		client:  http.DefaultClient,
+		timeout: DefaultTimeout,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.logger == nil {
+		e.logger = logging.Discard()
+	}
+
+	e.addrs.Store(&map[netip.Addr]struct{}{})
+	go e.run()
+	return e
+}
+
+func (e *Exits) run() {
+	defer close(e.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-e.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	e.refreshLogged(ctx)
+	if e.refresh <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			e.refreshLogged(ctx)
+		}
+	}
+}
+
+func (e *Exits) refreshLogged(ctx context.Context) {
+	if err := e.Refresh(ctx); err != nil && ctx.Err() == nil {
+		e.logger.Warn("botrate: failed to fetch Tor exits", "error", err)
+	}
+}
+
+// Refresh fetches the list. On failure, the previous list is kept.
+func (e *Exits) Refresh(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, e.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("tor: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxListSize))
+	if err != nil {
+		return err
+	}
+
+	var addrs []netip.Addr
+	s := bufio.NewScanner(bytes.NewReader(body))
+	for s.Scan() {
+		line := bytes.TrimSpace(s.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		addr, err := netip.ParseAddr(string(line))
+		if err != nil {
+			return fmt.Errorf("tor: %w", err)
+		}
+		addrs = append(addrs, addr)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		// Rather keep the previous list than trust an empty one
+		return errors.New("tor: empty exit list")
+	}
+
+	e.Load(addrs)
+	return nil
+}
+
+// Load replaces the exits, e.g. with a copy of the list.
+func (e *Exits) Load(addrs []netip.Addr) {
+	m := make(map[netip.Addr]struct{}, len(addrs))
+	for _, a := range addrs {
+		m[a.Unmap()] = struct{}{}
+	}
+	e.addrs.Store(&m)
+}
+
+// Contains reports whether addr is a Tor exit.
+func (e *Exits) Contains(addr netip.Addr) bool {
+	_, ok := (*e.addrs.Load())[addr.Unmap()]
+	return ok
+}
+
+// Len returns the number of exits.
+func (e *Exits) Len() int {
+	return len(*e.addrs.Load())
+}
+
+// Close stops refreshing the list. The exits loaded are kept.
+func (e *Exits) Close() error {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	<-e.done
+	return nil
+}
@@ -0,0 +1,97 @@
+package tor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const list = `# exits
+185.220.101.1
+185.220.101.2
+
+2001:db8::1
+`
+
+func TestExits_Refresh(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	e := New(WithURL(srv.URL), WithRefresh(0))
+	defer e.Close()
+
+	if err := e.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh() returned error: %v", err)
+	}
+	if e.Len() != 3 {
+		t.Errorf("Len() = %d, want 3", e.Len())
+	}
+	for _, ip := range []string{"185.220.101.1", "::ffff:185.220.101.2", "2001:db8::1"} {
+		if !e.Contains(netip.MustParseAddr(ip)) {
+			t.Errorf("%s should be an exit", ip)
+		}
+	}
+	if e.Contains(netip.MustParseAddr("185.220.101.3")) || e.Contains(netip.Addr{}) {
+		t.Error("other addresses should not be exits")
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	if err := e.Refresh(context.Background()); err == nil {
+		t.Error("expected an error for a failed fetch")
+	}
+	if e.Len() != 3 {
+		t.Errorf("a failed fetch should keep the previous list, got %d exits", e.Len())
+	}
+}
+
+func TestExits_RefreshInvalid(t *testing.T) {
+	for _, body := range []string{"not an ip\n", "# nothing\n"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		}))
+
+		e := New(WithURL(srv.URL), WithRefresh(0))
+		if err := e.Refresh(context.Background()); err == nil {
+			t.Errorf("expected an error for list %q", body)
+		}
+		e.Close()
+		srv.Close()
+	}
+}
+
+func TestExits_Background(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	e := New(WithURL(srv.URL), WithRefresh(time.Hour))
+	defer e.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for e.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("exits not loaded in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExits_Load(t *testing.T) {
+	e := New(WithURL("http://127.0.0.1:0"), WithRefresh(0))
+	defer e.Close()
+
+	e.Load([]netip.Addr{netip.MustParseAddr("198.51.100.1")})
+	if !e.Contains(netip.MustParseAddr("198.51.100.1")) {
+		t.Error("loaded exits should be contained")
+	}
+}
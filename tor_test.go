@@ -0,0 +1,93 @@
+package botrate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/botrate/tor"
+	"golang.org/x/time/rate"
+)
+
+// newTestTor serves a Tor exit list with 198.51.100.1.
+func newTestTor(t *testing.T) tor.Option {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("198.51.100.1\n"))
+	}))
+	t.Cleanup(srv.Close)
+	return tor.WithURL(srv.URL)
+}
+
+func waitForTor(t *testing.T, l *Limiter) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for l.tor.Len() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Tor exits not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLimiter_WithTor(t *testing.T) {
+	tests := []struct {
+		policy  TorPolicy
+		allowed bool
+		action  Action
+	}{
+		{TorAllow, true, ActionReject},
+		{TorChallenge, false, ActionChallenge},
+		{TorBlock, false, ActionReject},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			l, err := New(WithKnownbots(testbots.New(t)), WithTor(tt.policy, newTestTor(t)))
+			if err != nil {
+				t.Fatalf("New() returned error: %v", err)
+			}
+			defer l.Close()
+			waitForTor(t, l)
+
+			d := l.Decide("Mozilla/5.0", "198.51.100.1", "/")
+			if d.Allowed != tt.allowed || !d.Tor {
+				t.Errorf("Tor users: got %+v", d)
+			}
+			if !tt.allowed && (d.Reason != ReasonTor || d.Action != tt.action) {
+				t.Errorf("expected ReasonTor with %v, got %+v", tt.action, d)
+			}
+			if d := l.Decide("Mozilla/5.0", "198.51.100.2", "/"); !d.Allowed || d.Tor {
+				t.Errorf("other users should be allowed, got %+v", d)
+			}
+		})
+	}
+}
+
+func TestLimiter_WithTorLimit(t *testing.T) {
+	l, err := New(
+		WithKnownbots(testbots.New(t)),
+		WithTor(TorLimit, newTestTor(t)),
+		WithTorLimit(rate.Every(time.Hour), 2),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+	waitForTor(t, l)
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := l.Allow("Mozilla/5.0", "198.51.100.1", "/"); !allowed {
+			t.Fatalf("request %d within the burst should be allowed", i)
+		}
+	}
+	if allowed, reason := l.Allow("Mozilla/5.0", "198.51.100.1", "/"); allowed || reason != ReasonTor {
+		t.Errorf("expected ReasonTor past the burst, got %v, %v", allowed, reason)
+	}
+	if s := l.Stats(); s.Denied[ReasonTor] != 1 {
+		t.Errorf("expected 1 Tor denial, got %d", s.Denied[ReasonTor])
+	}
+}
@@ -0,0 +1,51 @@
+package useragent
+
+import "strings"
+
+// Flaw is what is wrong with a User-Agent, see Validate.
+type Flaw string
+
+// Flaws, see Validate.
+const (
+	// NoFlaw is a well-formed UA.
+	NoFlaw Flaw = ""
+
+	// Empty is a missing or blank UA. Browsers always send one.
+	Empty Flaw = "empty"
+
+	// Truncated is a UA cut short: an unclosed comment, a trailing
+	// separator, or a bare "Mozilla/5.0" without the platform and engine
+	// that follow it in every browser's.
+	Truncated Flaw = "truncated"
+
+	// TooLong is a UA longer than the limit, padded to overflow logs or
+	// stuffed with payloads.
+	TooLong Flaw = "too_long"
+)
+
+// Flaws lists the flaws, NoFlaw excepted.
+var Flaws = []Flaw{Empty, Truncated, TooLong}
+
+// Validate returns the flaw of ua, NoFlaw if none. A maxLen <= 0 allows
+// UAs of any length.
+func Validate(ua string, maxLen int) Flaw {
+	if maxLen > 0 && len(ua) > maxLen {
+		return TooLong
+	}
+	ua = strings.TrimSpace(ua)
+	if ua == "" {
+		return Empty
+	}
+
+	switch ua[len(ua)-1] {
+	case '/', ';', ',', '(':
+		return Truncated
+	}
+	if strings.LastIndexByte(ua, '(') > strings.LastIndexByte(ua, ')') {
+		return Truncated
+	}
+	if strings.HasPrefix(ua, "Mozilla/") && strings.IndexByte(ua, ' ') < 0 {
+		return Truncated
+	}
+	return NoFlaw
+}
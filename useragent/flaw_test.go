@@ -0,0 +1,33 @@
+package useragent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		ua     string
+		maxLen int
+		want   Flaw
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", 512, NoFlaw},
+		{"curl/8.4.0", 512, NoFlaw},
+		{"Googlebot", 512, NoFlaw},
+		{"", 512, Empty},
+		{"  ", 512, Empty},
+		{"-", 512, NoFlaw},
+		{"Mozilla/5.0", 512, Truncated},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64", 512, Truncated},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/", 512, Truncated},
+		{"Mozilla/5.0 (Windows NT 10.0;", 512, Truncated},
+		{"python-requests/2.31.0", 10, TooLong},
+		{strings.Repeat("A", 4096), 0, NoFlaw},
+	}
+
+	for _, tc := range testCases {
+		if got := Validate(tc.ua, tc.maxLen); got != tc.want {
+			t.Errorf("Validate(%q, %d) = %q, want %q", tc.ua, tc.maxLen, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Package useragent classifies User-Agent strings by the kind of client
+// they claim: a browser, a mobile app, an HTTP library, a headless
+// browser or a known tool, so that the same crawl can be judged
+// differently from python-requests than from Chrome.
+//
+// Classification trusts the UA: it tells what a client claims, not what
+// it is. Known bots are verified by botrate before, so classes apply to
+// the rest.
+//
+// See botrate.WithUAClassPageThreshold.
+package useragent
+
+import "strings"
+
+// Class is the kind of client a User-Agent claims.
+type Class string
+
+// Classes, see Classify.
+const (
+	// Unknown is a UA of no known kind, such as a feed reader's, or an
+	// empty one.
+	Unknown Class = ""
+
+	// Browser is a desktop or mobile web browser.
+	Browser Class = "browser"
+
+	// MobileApp is a native mobile app, or a web view embedded in one.
+	MobileApp Class = "mobile_app"
+
+	// Library is the default UA of an HTTP library, e.g. python-requests
+	// or Go-http-client.
+	Library Class = "library"
+
+	// Headless is a headless or automated browser, e.g. HeadlessChrome
+	// or PhantomJS.
+	Headless Class = "headless"
+
+	// Tool is a command-line client, scraping framework or security
+	// scanner, e.g. curl, Scrapy or sqlmap.
+	Tool Class = "tool"
+)
+
+// Classes lists the classes, Unknown excepted.
+var Classes = []Class{Browser, MobileApp, Library, Headless, Tool}
+
+// products maps the product names of UAs, "name/version", and the items
+// of their comments, "(item; item)", to their class.
+var products = map[string]Class{
+	// Headless and automated browsers
+	"HeadlessChrome":    Headless,
+	"PhantomJS":         Headless,
+	"SlimerJS":          Headless,
+	"Puppeteer":         Headless,
+	"Playwright":        Headless,
+	"Selenium":          Headless,
+	"Splash":            Headless,
+	"Chrome-Lighthouse": Headless,
+
+	// Command-line clients, scraping frameworks and scanners
+	"curl":           Tool,
+	"Wget":           Tool,
+	"HTTPie":         Tool,
+	"Scrapy":         Tool,
+	"PostmanRuntime": Tool,
+	"insomnia":       Tool,
+	"sqlmap":         Tool,
+	"Nikto":          Tool,
+	"Nmap":           Tool,
+	"masscan":        Tool,
+	"zgrab":          Tool,
+	"Nuclei":         Tool,
+	"WPScan":         Tool,
+	"Fuzz":           Tool, // ffuf
+	"gobuster":       Tool,
+	"feroxbuster":    Tool,
+	"colly":          Tool,
+	"Wfuzz":          Tool,
+	"HTTrack":        Tool,
+
+	// HTTP libraries
+	"python-requests":   Library,
+	"Python-urllib":     Library,
+	"python-httpx":      Library,
+	"aiohttp":           Library,
+	"urllib3":           Library,
+	"Go-http-client":    Library,
+	"Java":              Library,
+	"Apache-HttpClient": Library,
+	"node-fetch":        Library,
+	"axios":             Library,
+	"undici":            Library,
+	"node":              Library,
+	"got":               Library,
+	"libwww-perl":       Library,
+	"Ruby":              Library,
+	"Faraday":           Library,
+	"rest-client":       Library,
+	"GuzzleHttp":        Library,
+	"PHP":               Library,
+	"Dart":              Library,
+	"reqwest":           Library,
+	"RestSharp":         Library,
+	"hyper":             Library,
+	"libcurl":           Library,
+	"http.rb":           Library,
+
+	// Mobile apps and web views
+	"CFNetwork": MobileApp,
+	"Dalvik":    MobileApp,
+	"okhttp":    MobileApp,
+	"Alamofire": MobileApp,
+	"wv":        MobileApp, // Android WebView
+
+	// Browsers, once the UA starts with "Mozilla/"
+	"Chrome":  Browser,
+	"Firefox": Browser,
+	"Safari":  Browser,
+	"Edg":     Browser,
+	"OPR":     Browser,
+	"MSIE":    Browser,
+	"Trident": Browser,
+}
+
+// rank orders classes when a UA names several: headless browsers claim
+// to be browsers, tools are built on libraries, and apps embed
+// browsers.
+func rank(c Class) int {
+	switch c {
+	case Browser:
+		return 1
+	case MobileApp:
+		return 2
+	case Library:
+		return 3
+	case Tool:
+		return 4
+	case Headless:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// Classify returns the class of ua. It walks ua once, without
+// allocating.
+func Classify(ua string) Class {
+	class := Unknown
+	note := func(name string) {
+		if c, ok := products[name]; ok && rank(c) > rank(class) {
+			class = c
+		}
+	}
+
+	for i := 0; i < len(ua); {
+		switch c := ua[i]; {
+		case c == ' ':
+			i++
+		case c == '(':
+			// Comment: items separated by ';', named by their first word
+			end := i + 1
+			for end < len(ua) && ua[end] != ')' {
+				end++
+			}
+			comment := ua[i+1 : end]
+			for start := 0; start <= len(comment); {
+				j := start
+				for j < len(comment) && comment[j] != ';' {
+					j++
+				}
+				note(name(strings.TrimSpace(comment[start:j])))
+				start = j + 1
+			}
+			i = end + 1
+		default:
+			// Product: name/version
+			end := i
+			for end < len(ua) && ua[end] != ' ' && ua[end] != '(' {
+				end++
+			}
+			note(name(ua[i:end]))
+			i = end
+		}
+	}
+
+	if class == Browser && !strings.HasPrefix(ua, "Mozilla/") {
+		return Unknown
+	}
+	return class
+}
+
+// name returns the name of a product or comment item: up to its version,
+// after a '/' or ' '.
+func name(s string) string {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '/' || s[i] == ' ' {
+			return s[:i]
+		}
+	}
+	return s
+}
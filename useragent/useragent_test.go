@@ -0,0 +1,49 @@
+package useragent
+
+import "testing"
+
+func TestClassify(t *testing.T) {
+	testCases := []struct {
+		ua   string
+		want Class
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", Browser},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.1; rv:121.0) Gecko/20100101 Firefox/121.0", Browser},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", Browser},
+		{"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1; Trident/6.0)", Browser},
+		{"Mozilla/5.0 (Linux; Android 13; Pixel 7 Build/TQ3A; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/120.0.0.0 Mobile Safari/537.36", MobileApp},
+		{"MyApp/2.3 CFNetwork/1410.0.3 Darwin/22.6.0", MobileApp},
+		{"Dalvik/2.1.0 (Linux; U; Android 13; Pixel 7 Build/TQ3A)", MobileApp},
+		{"okhttp/4.12.0", MobileApp},
+		{"python-requests/2.31.0", Library},
+		{"Go-http-client/1.1", Library},
+		{"Java/17.0.2", Library},
+		{"axios/1.6.2", Library},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.0.0 Safari/537.36", Headless},
+		{"Mozilla/5.0 (Unknown; Linux x86_64) AppleWebKit/538.1 (KHTML, like Gecko) PhantomJS/2.1.1 Safari/538.1", Headless},
+		{"curl/8.4.0", Tool},
+		{"Wget/1.21.4", Tool},
+		{"Scrapy/2.11.0 (+https://scrapy.org)", Tool},
+		{"sqlmap/1.7.11#stable (https://sqlmap.org)", Tool},
+		{"Mozilla/5.00 (Nikto/2.5.0) (Evasions:None) (Test:000001)", Tool},
+		{"Mozilla/5.0 (compatible; Nmap Scripting Engine; https://nmap.org/book/nse.html)", Tool},
+		{"Chrome/120.0.0.0", Unknown}, // a browser's product without Mozilla/
+		{"Feedfetcher/2.1", Unknown},
+		{"", Unknown},
+		{"(", Unknown},
+	}
+
+	for _, tc := range testCases {
+		if got := Classify(tc.ua); got != tc.want {
+			t.Errorf("Classify(%q) = %q, want %q", tc.ua, got, tc.want)
+		}
+	}
+}
+
+func BenchmarkClassify(b *testing.B) {
+	ua := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Classify(ua)
+	}
+}
@@ -0,0 +1,137 @@
+package botrate
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/knownbots"
+)
+
+// DefaultVerifyCacheSize is the most verifications cached with
+// WithVerifyCache.
+var DefaultVerifyCacheSize = 100000
+
+// verifyKey is a verification, by the bot claimed and the IP.
+type verifyKey struct {
+	bot, ip string
+}
+
+// verifyEntry is a cached verification.
+type verifyEntry struct {
+	key     verifyKey
+	ua      string // the UA last verified
+	res     knownbots.Result
+	expires time.Time
+}
+
+// verifyCache caches knownbots verifications of bots, see WithVerifyCache.
+// Verifications are kept by bot and IP, so the UA versions of a bot from
+// an IP share one, and evicted least recently used first.
+type verifyCache struct {
+	ttl  time.Duration
+	size int
+
+	mu      sync.Mutex
+	lru     *list.List // *verifyEntry, most recently used first
+	entries map[verifyKey]*list.Element
+
+	// The bots claimed by the UAs verified, as knownbots doesn't tell
+	// them before verifying
+	bots map[string]string
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+func newVerifyCache(ttl time.Duration, size int) *verifyCache {
+	if size <= 0 {
+		size = DefaultVerifyCacheSize
+	}
+	return &verifyCache{
+		ttl:     ttl,
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[verifyKey]*list.Element),
+		bots:    make(map[string]string),
+	}
+}
+
+// get returns the cached verification of ua from ip, if not expired.
+func (c *verifyCache) get(ua, ip string, clock analyzer.Clock) (knownbots.Result, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bot, ok := c.bots[ua]
+	if !ok {
+		return knownbots.Result{}, false
+	}
+	elem, ok := c.entries[verifyKey{bot, ip}]
+	if !ok {
+		return knownbots.Result{}, false
+	}
+	e := elem.Value.(*verifyEntry)
+	if !clock.Now().Before(e.expires) {
+		c.remove(elem)
+		return knownbots.Result{}, false
+	}
+	c.lru.MoveToFront(elem)
+	c.hits.Add(1)
+	return e.res, true
+}
+
+// put caches the verification res of ua from ip, a miss. Only settled
+// verifications are cached: pending ones are retried.
+func (c *verifyCache) put(ua, ip string, res knownbots.Result, clock analyzer.Clock) {
+	c.misses.Add(1)
+	if res.Status != knownbots.StatusVerified && res.Status != knownbots.StatusFailed {
+		return
+	}
+
+	now := clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.bots[ua]; !ok {
+		if len(c.bots) >= c.size {
+			// UAs rotated past the size start over, the verifications stay
+			clear(c.bots)
+		}
+		c.bots[ua] = res.BotName
+	}
+
+	key := verifyKey{res.BotName, ip}
+	e := &verifyEntry{key: key, ua: ua, res: res, expires: now.Add(c.ttl)}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = e
+		c.lru.MoveToFront(elem)
+		return
+	}
+	if c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+	c.entries[key] = c.lru.PushFront(e)
+}
+
+// inspect returns the unexpired verifications of bots from ip.
+func (c *verifyCache) inspect(ip string, clock analyzer.Clock) []BotVerification {
+	now := clock.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var bots []BotVerification
+	for k, elem := range c.entries {
+		if e := elem.Value.(*verifyEntry); k.ip == ip && now.Before(e.expires) {
+			bots = append(bots, BotVerification{Bot: e.res.BotName, UA: e.ua, Status: e.res.Status})
+		}
+	}
+	return bots
+}
+
+// remove drops the verification at elem. It is called under mu.
+func (c *verifyCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*verifyEntry).key)
+}
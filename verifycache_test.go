@@ -0,0 +1,107 @@
+package botrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/testbots"
+	"github.com/cnlangzi/knownbots"
+)
+
+func TestLimiter_WithVerifyCache(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	l, err := New(
+		WithKnownbots(testbots.NewAI(t)),
+		WithVerifyCache(time.Minute, 0),
+		WithClock(clock),
+	)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < 3; i++ {
+		if d := l.Decide("TestBot/1.0", "192.168.100.1", "/"); !d.Allowed || d.Verification != knownbots.StatusVerified {
+			t.Fatalf("testbot should be verified, got %+v", d)
+		}
+	}
+	// Fake bots are cached too
+	for i := 0; i < 2; i++ {
+		if d := l.Decide("TestBot/1.0", "10.0.0.1", "/"); d.Verification != knownbots.StatusFailed {
+			t.Fatalf("testbot from elsewhere should be fake, got %+v", d)
+		}
+	}
+	// Normal users are not verifications
+	l.Decide("Mozilla/5.0", "10.0.0.2", "/")
+
+	s := l.Stats()
+	if s.VerifyCacheHits != 3 || s.VerifyCacheMisses != 2 {
+		t.Errorf("hits, misses = %d, %d, want 3, 2", s.VerifyCacheHits, s.VerifyCacheMisses)
+	}
+
+	clock.Advance(time.Minute)
+	l.Decide("TestBot/1.0", "192.168.100.1", "/")
+	if s := l.Stats(); s.VerifyCacheMisses != 3 {
+		t.Errorf("expired verifications should miss, got %d misses", s.VerifyCacheMisses)
+	}
+}
+
+func TestVerifyCache_Evict(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	c := newVerifyCache(time.Minute, 2)
+	verified := knownbots.Result{BotName: "testbot", IsBot: true, Status: knownbots.StatusVerified}
+
+	c.put("TestBot", "192.168.100.1", verified, clock)
+	c.put("TestBot", "192.168.100.2", knownbots.Result{BotName: "testbot", IsBot: true, Status: knownbots.StatusPending}, clock)
+	if len(c.entries) != 1 {
+		t.Errorf("pending verifications should not be cached, got %d entries", len(c.entries))
+	}
+
+	clock.Advance(30 * time.Second)
+	c.put("TestBot", "192.168.100.3", verified, clock)
+	clock.Advance(30 * time.Second)
+	c.put("TestBot", "192.168.100.4", verified, clock)
+	if len(c.entries) != 2 {
+		t.Errorf("the cache should hold at most 2 entries, got %d", len(c.entries))
+	}
+	if _, ok := c.get("TestBot", "192.168.100.1", clock); ok {
+		t.Error("the least recently used entry should be evicted")
+	}
+	if res, ok := c.get("TestBot", "192.168.100.3", clock); !ok || res != verified {
+		t.Errorf("get() = %+v, %v, want the cached verification", res, ok)
+	}
+
+	// .3 was used last, so .4 makes room for .5
+	c.put("TestBot", "192.168.100.5", verified, clock)
+	if _, ok := c.get("TestBot", "192.168.100.4", clock); ok {
+		t.Error("the least recently used entry should be evicted")
+	}
+	if _, ok := c.get("TestBot", "192.168.100.3", clock); !ok {
+		t.Error("the recently used entry should be kept")
+	}
+}
+
+func TestVerifyCache_Bot(t *testing.T) {
+	clock := analyzer.NewManualClock(time.Now())
+	c := newVerifyCache(time.Minute, 2)
+	verified := knownbots.Result{BotName: "testbot", IsBot: true, Status: knownbots.StatusVerified}
+
+	c.put("TestBot/1.0", "192.168.100.1", verified, clock)
+	c.put("TestBot/2.0", "192.168.100.1", verified, clock)
+	if len(c.entries) != 1 {
+		t.Errorf("UAs of a bot from an IP should share a verification, got %d entries", len(c.entries))
+	}
+	if _, ok := c.get("TestBot/1.0", "192.168.100.1", clock); !ok {
+		t.Error("the verification should be cached for every UA of the bot")
+	}
+
+	// UAs past the size don't evict verifications
+	c.put("TestBot/3.0", "192.168.100.1", verified, clock)
+	if _, ok := c.get("TestBot/3.0", "192.168.100.1", clock); !ok || len(c.entries) != 1 {
+		t.Errorf("the verification should be kept, got %d entries", len(c.entries))
+	}
+	if len(c.bots) > 2 {
+		t.Errorf("the UAs should be bounded by the size, got %d", len(c.bots))
+	}
+}
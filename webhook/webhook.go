@@ -0,0 +1,331 @@
+// Package webhook posts botrate block events to an HTTP endpoint, for
+// teams wiring blocks into existing tooling like chat alerts or incident
+// trackers.
+//
+// A Notifier batches the blocks and posts them as JSON:
+//
+//	{
+//	  "window": 600000000000,
+//	  "events": [
+//	    {"type": "block", "reason": "score", "ip": "203.0.113.7", "pages": 120, ...}
+//	  ]
+//	}
+//
+// With WithSecret, the hex HMAC-SHA256 of the body is sent in the
+// X-Botrate-Signature header. Failed posts are retried with exponential
+// backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+	"github.com/cnlangzi/botrate/internal/logging"
+)
+
+// Default configuration values.
+var (
+	DefaultTimeout   = 5 * time.Second
+	DefaultRetries   = 3
+	DefaultBatchSize = 100
+	DefaultInterval  = time.Second
+	DefaultQueueCap  = 10000
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the request body with
+// WithSecret.
+const SignatureHeader = "X-Botrate-Signature"
+
+// Option is a functional option for configuring Notifier.
+type Option func(*Notifier)
+
+// WithSecret signs the posts so the endpoint can check they come from
+// botrate.
+func WithSecret(secret []byte) Option {
+	return func(n *Notifier) {
+		n.secret = secret
+	}
+}
+
+// WithClient sets the HTTP client posting to the endpoint.
+func WithClient(c *http.Client) Option {
+	return func(n *Notifier) {
+		n.client = c
+	}
+}
+
+// WithTimeout sets the timeout for a post.
+func WithTimeout(d time.Duration) Option {
+	return func(n *Notifier) {
+		n.timeout = d
+	}
+}
+
+// WithRetries sets how many times a failed post is retried, with
+// exponential backoff, before its events are dropped.
+func WithRetries(retries int) Option {
+	return func(n *Notifier) {
+		n.retries = retries
+	}
+}
+
+// WithBatchSize sets the most events posted at once.
+func WithBatchSize(size int) Option {
+	return func(n *Notifier) {
+		n.batchSize = size
+	}
+}
+
+// WithInterval sets how long the first event of a batch waits for more
+// before the batch is posted.
+func WithInterval(d time.Duration) Option {
+	return func(n *Notifier) {
+		n.interval = d
+	}
+}
+
+// WithQueueCap sets how many events can wait to be posted. Events are
+// dropped when the queue is full.
+func WithQueueCap(cap int) Option {
+	return func(n *Notifier) {
+		n.queueCap = cap
+	}
+}
+
+// WithWindow sets the analysis window reported with the events.
+// Set it to the limiter's, see botrate.WithAnalyzerWindow.
+func WithWindow(d time.Duration) Option {
+	return func(n *Notifier) {
+		n.window = d
+	}
+}
+
+// WithLogger sets the logger for failed posts.
+func WithLogger(logger *slog.Logger) Option {
+	return func(n *Notifier) {
+		n.logger = logger
+	}
+}
+
+// Payload is the body of a post.
+type Payload struct {
+	Window time.Duration `json:"window,omitempty"`
+	Events []Event       `json:"events"`
+}
+
+// Event is a block event with its reason, see analyzer.Event.Reason.
+type Event struct {
+	Reason string `json:"reason"`
+	analyzer.Event
+}
+
+// Notifier posts block events to a webhook.
+type Notifier struct {
+	url       string
+	secret    []byte
+	client    *http.Client
+	timeout   time.Duration
+	retries   int
+	batchSize int
+	interval  time.Duration
+	queueCap  int
+	window    time.Duration
+	logger    *slog.Logger
+
+	queue   chan analyzer.Event
+	dropped atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New returns a notifier posting to url.
+func New(url string, opts ...Option) *Notifier {
+	n := &Notifier{
+		url:       url,
+		client:    http.DefaultClient,
+		timeout:   DefaultTimeout,
+		retries:   DefaultRetries,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultInterval,
+		queueCap:  DefaultQueueCap,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+	if n.logger == nil {
+		n.logger = logging.Discard()
+	}
+
+	n.queue = make(chan analyzer.Event, n.queueCap)
+	go n.run()
+	return n
+}
+
+// Handle queues e to be posted if it is a block, see
+// botrate.WithEventFunc.
+func (n *Notifier) Handle(e analyzer.Event) {
+	if e.Type != analyzer.EventBlock {
+		return
+	}
+	select {
+	case n.queue <- e:
+	default:
+		n.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of events not posted, because the queue was
+// full or the endpoint failed every retry.
+func (n *Notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// Close posts the events still queued, without retrying, and stops the
+// notifier.
+func (n *Notifier) Close() error {
+	select {
+	case <-n.stop:
+	default:
+		close(n.stop)
+	}
+	<-n.done
+	return nil
+}
+
+// run posts the queued events in batches.
+func (n *Notifier) run() {
+	defer close(n.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		var batch []analyzer.Event
+		select {
+		case <-n.stop:
+			n.drain(ctx)
+			return
+		case e := <-n.queue:
+			batch = append(batch, e)
+		}
+
+		// Give the rest of a burst a chance to join the batch
+		timer := time.NewTimer(n.interval)
+	fill:
+		for len(batch) < n.batchSize {
+			select {
+			case e := <-n.queue:
+				batch = append(batch, e)
+			case <-timer.C:
+				break fill
+			case <-n.stop:
+				break fill
+			}
+		}
+		timer.Stop()
+
+		n.send(ctx, batch, n.retries)
+	}
+}
+
+// drain posts the queued events once each.
+func (n *Notifier) drain(ctx context.Context) {
+	for {
+		var batch []analyzer.Event
+	fill:
+		for len(batch) < n.batchSize {
+			select {
+			case e := <-n.queue:
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+		if len(batch) == 0 {
+			return
+		}
+		n.send(ctx, batch, 0)
+	}
+}
+
+// send posts batch, retrying with exponential backoff unless the
+// notifier is stopping.
+func (n *Notifier) send(ctx context.Context, batch []analyzer.Event, retries int) {
+	p := Payload{Window: n.window, Events: make([]Event, len(batch))}
+	for i, e := range batch {
+		p.Events[i] = Event{Reason: e.Reason(), Event: e}
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		n.dropped.Add(uint64(len(batch)))
+		return
+	}
+
+	backoff := 100 * time.Millisecond
+retry:
+	for attempt := 0; ; attempt++ {
+		if err = n.post(ctx, body); err == nil {
+			return
+		}
+		if attempt == retries {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-n.stop:
+			// Close doesn't wait out the backoff
+			break retry
+		}
+	}
+	n.dropped.Add(uint64(len(batch)))
+	n.logger.Warn("botrate: failed to post webhook", "url", n.url, "events", len(batch), "error", err)
+}
+
+func (n *Notifier) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, n.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != nil {
+		req.Header.Set(SignatureHeader, Sign(n.secret, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: endpoint responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the hex HMAC-SHA256 of body under secret, the value of
+// SignatureHeader. Endpoints compare it with hmac.Equal.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,157 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cnlangzi/botrate/analyzer"
+)
+
+// endpoint records the payloads posted to it.
+type endpoint struct {
+	mu       sync.Mutex
+	payloads []Payload
+	bodies   [][]byte
+	sigs     []string
+}
+
+func (e *endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var p Payload
+	if err := json.Unmarshal(body, &p); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.payloads = append(e.payloads, p)
+	e.bodies = append(e.bodies, body)
+	e.sigs = append(e.sigs, r.Header.Get(SignatureHeader))
+}
+
+func (e *endpoint) events() []Event {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var events []Event
+	for _, p := range e.payloads {
+		events = append(events, p.Events...)
+	}
+	return events
+}
+
+func TestNotifier_Batch(t *testing.T) {
+	e := &endpoint{}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	n := New(srv.URL, WithWindow(10*time.Minute), WithInterval(50*time.Millisecond))
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "1.1.1.1", Pages: 120})
+	n.Handle(analyzer.Event{Type: analyzer.EventUnblock, IP: "1.1.1.1"})
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "2.2.2.2", Manual: true})
+	time.Sleep(200 * time.Millisecond)
+	n.Close()
+
+	if len(e.payloads) != 1 {
+		t.Fatalf("expected the events in one batch, got %d posts", len(e.payloads))
+	}
+	p := e.payloads[0]
+	if p.Window != 10*time.Minute {
+		t.Errorf("expected the window in the payload, got %v", p.Window)
+	}
+	if len(p.Events) != 2 {
+		t.Fatalf("expected only the 2 blocks, got %+v", p.Events)
+	}
+	if ev := p.Events[0]; ev.IP != "1.1.1.1" || ev.Pages != 120 || ev.Reason != "score" {
+		t.Errorf("unexpected event %+v", ev)
+	}
+	if ev := p.Events[1]; ev.Reason != "manual" {
+		t.Errorf("expected a manual block, got %+v", ev)
+	}
+}
+
+func TestNotifier_Close(t *testing.T) {
+	e := &endpoint{}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	// Close posts the events still queued
+	n := New(srv.URL, WithInterval(time.Hour))
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "1.1.1.1", Manual: true})
+	n.Close()
+
+	if events := e.events(); len(events) != 1 || events[0].IP != "1.1.1.1" {
+		t.Errorf("expected the pending block posted on close, got %+v", events)
+	}
+}
+
+func TestNotifier_Secret(t *testing.T) {
+	e := &endpoint{}
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	secret := []byte("s3cret")
+	n := New(srv.URL, WithSecret(secret), WithInterval(time.Millisecond))
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "1.1.1.1"})
+	n.Close()
+
+	if len(e.payloads) != 1 {
+		t.Fatalf("expected 1 post, got %d", len(e.payloads))
+	}
+	if e.sigs[0] != Sign(secret, e.bodies[0]) {
+		t.Errorf("signature %q doesn't match the body", e.sigs[0])
+	}
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	e := &endpoint{}
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Fail the first two attempts
+		if calls.Add(1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		e.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	n := New(srv.URL, WithInterval(time.Millisecond))
+	defer n.Close()
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "1.1.1.1"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(e.events()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("event should be delivered after retries")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if calls.Load() != 3 || n.Dropped() != 0 {
+		t.Errorf("expected 3 attempts and nothing dropped, got %d and %d", calls.Load(), n.Dropped())
+	}
+}
+
+func TestNotifier_Dropped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	n := New(srv.URL, WithRetries(1), WithInterval(time.Millisecond))
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "1.1.1.1"})
+	n.Handle(analyzer.Event{Type: analyzer.EventBlock, IP: "2.2.2.2"})
+	time.Sleep(300 * time.Millisecond)
+	n.Close()
+
+	if n.Dropped() != 2 {
+		t.Errorf("expected 2 events dropped after the retries, got %d", n.Dropped())
+	}
+}